@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"runtime"
 	"testing"
 
 	"github.com/apache/arrow-go/v18/arrow"
@@ -50,6 +51,7 @@ func BenchmarkWriteLarge(b *testing.B) {
 		if err := lbx.Write(context.Background(), record, lb.WithPassword("bench")); err != nil {
 			b.Fatalf("write: %v", err)
 		}
+		record.Release()
 		lbx.Close()
 		os.Remove(tmp)
 	}
@@ -67,6 +69,7 @@ func BenchmarkReadLarge(b *testing.B) {
 	if err := lbx.Write(context.Background(), record, lb.WithPassword("bench")); err != nil {
 		b.Fatalf("write: %v", err)
 	}
+	record.Release()
 	lbx.Close()
 
 	lbx, err = lb.Open(tmp, lb.WithPassword("bench"))
@@ -87,3 +90,44 @@ func BenchmarkReadLarge(b *testing.B) {
 		rec.Release()
 	}
 }
+
+// Benchmark verifying the block checksums of a lockbox with many row
+// groups, on one core and on all of them.
+func BenchmarkValidate(b *testing.B) {
+	tmp := filepath.Join(os.TempDir(), "bench_validate.lbx")
+	lbx, err := lb.Create(tmp, schema, lb.WithPassword("bench"))
+	if err != nil {
+		b.Fatalf("create: %v", err)
+	}
+	defer func() {
+		lbx.Close()
+		os.Remove(tmp)
+	}()
+	record := largeRecord(10000)
+	for i := 0; i < 64; i++ {
+		if err := lbx.Write(context.Background(), record, lb.WithPassword("bench")); err != nil {
+			b.Fatalf("write: %v", err)
+		}
+	}
+	record.Release()
+	info, err := os.Stat(tmp)
+	if err != nil {
+		b.Fatalf("stat: %v", err)
+	}
+
+	procs := []int{1}
+	if n := runtime.NumCPU(); n > 1 {
+		procs = append(procs, n)
+	}
+	for _, n := range procs {
+		b.Run(fmt.Sprintf("procs=%d", n), func(b *testing.B) {
+			defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(n))
+			b.SetBytes(info.Size())
+			for i := 0; i < b.N; i++ {
+				if err := lbx.Validate(); err != nil {
+					b.Fatalf("validate: %v", err)
+				}
+			}
+		})
+	}
+}
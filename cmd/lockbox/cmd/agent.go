@@ -0,0 +1,166 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/TFMV/lockbox/pkg/lockbox"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+)
+
+var agentCmd = &cobra.Command{
+	Use:   "agent",
+	Short: "Keep lockboxes unlocked for a session",
+	Long: `Run an agent that keeps lockboxes unlocked for a limited time, like
+ssh-agent. Commands given a file the agent holds take its master key from
+the agent instead of prompting and deriving the key again. The agent keeps
+only the key, not the password, so commands that set a password or protect
+a new file, such as migrate or replica export, still need one. Flags such
+as --password-env still take precedence.
+
+The agent listens on the socket in $LOCKBOX_AGENT_SOCK, or a per-user
+default. The socket's directory must be owned by the user running the
+agent and have mode 0700; the agent and its clients refuse to use it
+otherwise.
+
+Examples:
+  lockbox agent start --ttl 30m &
+  lockbox agent add data.lbx
+  lockbox query data.lbx --sql "SELECT * FROM data"
+  lockbox agent clear`,
+}
+
+var agentStartCmd = &cobra.Command{
+	Use:   "start",
+	Short: "Run the agent in the foreground",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		socket, _ := cmd.Flags().GetString("socket")
+		ttl, _ := cmd.Flags().GetDuration("ttl")
+		pprofAddr, _ := cmd.Flags().GetString("pprof")
+		if socket == "" {
+			socket = lockbox.DefaultAgentSocket()
+		}
+
+		l, err := lockbox.ListenAgent(socket)
+		if err != nil {
+			return err
+		}
+		defer os.Remove(socket)
+
+		if pprofAddr != "" {
+			stopPprof, err := servePprof(pprofAddr)
+			if err != nil {
+				l.Close()
+				return err
+			}
+			defer stopPprof()
+		}
+
+		agent := lockbox.NewAgent(ttl)
+		defer agent.Clear()
+
+		ctx, stop := signal.NotifyContext(cmd.Context(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		go func() {
+			<-ctx.Done()
+			l.Close()
+		}()
+
+		fmt.Printf("export %s=%s\n", lockbox.AgentSocketEnv, socket)
+		log.Info().Str("socket", socket).Msg("Lockbox agent started")
+		if err := agent.Serve(l); err != nil {
+			return fmt.Errorf("agent failed: %w", err)
+		}
+		log.Info().Msg("Lockbox agent stopped")
+		return nil
+	},
+}
+
+var agentAddCmd = &cobra.Command{
+	Use:   "add [lockbox-file...]",
+	Short: "Unlock lockboxes in the agent",
+	Args:  cobra.MinimumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ttl, _ := cmd.Flags().GetDuration("ttl")
+		password, ok, err := flagPassword(cmd)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			if password, err = promptPassword(); err != nil {
+				return err
+			}
+		}
+
+		client := agentClient(cmd)
+		for _, path := range args {
+			if err := client.Add(path, password, ttl); err != nil {
+				return fmt.Errorf("failed to add %s: %w", path, err)
+			}
+			fmt.Printf("Unlocked %s\n", path)
+		}
+		return nil
+	},
+}
+
+var agentListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List the lockboxes the agent holds unlocked",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		entries, err := agentClient(cmd).List()
+		if err != nil {
+			return err
+		}
+		for _, e := range entries {
+			fmt.Printf("%s\t%s left\n", e.Path, time.Until(e.Expires).Round(time.Second))
+		}
+		return nil
+	},
+}
+
+var agentRemoveCmd = &cobra.Command{
+	Use:   "remove [lockbox-file...]",
+	Short: "Lock lockboxes again",
+	Args:  cobra.MinimumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		client := agentClient(cmd)
+		for _, path := range args {
+			if err := client.Remove(path); err != nil {
+				return fmt.Errorf("failed to remove %s: %w", path, err)
+			}
+		}
+		return nil
+	},
+}
+
+var agentClearCmd = &cobra.Command{
+	Use:   "clear",
+	Short: "Lock every lockbox held by the agent",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return agentClient(cmd).Clear()
+	},
+}
+
+// agentClient returns a client for the socket given with --socket
+func agentClient(cmd *cobra.Command) *lockbox.AgentClient {
+	socket, _ := cmd.Flags().GetString("socket")
+	return lockbox.NewAgentClient(socket)
+}
+
+func init() {
+	rootCmd.AddCommand(agentCmd)
+	agentCmd.AddCommand(agentStartCmd, agentAddCmd, agentListCmd, agentRemoveCmd, agentClearCmd)
+
+	agentCmd.PersistentFlags().String("socket", "", "Agent socket (default $"+lockbox.AgentSocketEnv+" or a per-user path)")
+	agentStartCmd.Flags().Duration("ttl", lockbox.DefaultAgentTTL, "How long files stay unlocked by default")
+	agentStartCmd.Flags().String("pprof", "", "Address to serve pprof profiles and traces on, e.g. localhost:6060")
+	agentAddCmd.Flags().Duration("ttl", 0, "How long the files stay unlocked (default: the agent's)")
+	addPasswordFlags(agentAddCmd.Flags(), "Password for the lockboxes")
+}
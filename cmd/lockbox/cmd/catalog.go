@@ -0,0 +1,117 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/TFMV/lockbox/pkg/lockbox"
+	"github.com/spf13/cobra"
+)
+
+var catalogCmd = &cobra.Command{
+	Use:   "catalog",
+	Short: "Index and search the metadata of many lockboxes",
+}
+
+var catalogIndexCmd = &cobra.Command{
+	Use:   "index [dir]",
+	Short: "Index the cleartext metadata of the lockboxes in a directory",
+	Long: `Scan a directory and its subdirectories for .lbx files and write their
+cleartext metadata (schema, sizes, tags, creation info) to a catalog file.
+No password is needed and no data is read.
+
+Example:
+  lockbox catalog index /data/lockboxes --out catalog.db`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		out, _ := cmd.Flags().GetString("out")
+
+		c, err := lockbox.IndexCatalog(cmd.Context(), args[0])
+		if err != nil {
+			return err
+		}
+		if err := c.Save(out); err != nil {
+			return err
+		}
+		fmt.Printf("Indexed %d lockboxes into %s\n", len(c.Entries), out)
+		for _, s := range c.Skipped {
+			fmt.Printf("Skipped %s: %s\n", s.Path, s.Error)
+		}
+		return nil
+	},
+}
+
+var catalogSearchCmd = &cobra.Command{
+	Use:   "search [query]",
+	Short: "Search a catalog",
+	Long: `Search a catalog written by "catalog index". All terms must match;
+* and ? are wildcards:
+  column:NAME       files with a column NAME
+  type:TYPE         files with a column of Arrow type TYPE
+  tag:KEY           files or columns tagged KEY or KEY=VALUE
+  creator:NAME      files created by NAME
+  path:GLOB         files whose path matches GLOB
+  description:GLOB  files whose description matches GLOB
+  WORD              any of the above, or a word of the description
+
+Example:
+  lockbox catalog search "column:email tag:pii"`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		catalogFile, _ := cmd.Flags().GetString("catalog")
+		output, _ := cmd.Flags().GetString("output")
+
+		c, err := lockbox.LoadCatalog(catalogFile)
+		if err != nil {
+			return err
+		}
+		matches, err := c.Search(args[0])
+		if err != nil {
+			return err
+		}
+
+		if output == "json" {
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "  ")
+			if matches == nil {
+				matches = []lockbox.CatalogEntry{}
+			}
+			return enc.Encode(matches)
+		}
+		if len(matches) == 0 {
+			fmt.Println("No matching lockboxes")
+			return nil
+		}
+		for _, e := range matches {
+			fmt.Printf("%s\n", e.Path)
+			fmt.Printf("  %d rows, %d bytes, created by %s at %s\n", e.RowCount, e.FileSize, e.CreatedBy, e.CreatedAt.Format("2006-01-02 15:04:05"))
+			if e.Description != "" {
+				fmt.Printf("  %s\n", e.Description)
+			}
+			if len(e.Tags) > 0 {
+				fmt.Printf("  tags: %s\n", strings.Join(e.Tags, ", "))
+			}
+			cols := make([]string, len(e.Columns))
+			for i, col := range e.Columns {
+				cols[i] = col.Name + " " + col.Type
+				if len(col.Tags) > 0 {
+					cols[i] += " [" + strings.Join(col.Tags, ", ") + "]"
+				}
+			}
+			fmt.Printf("  columns: %s\n", strings.Join(cols, ", "))
+		}
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(catalogCmd)
+	catalogCmd.AddCommand(catalogIndexCmd, catalogSearchCmd)
+
+	catalogIndexCmd.Flags().String("out", "catalog.db", "Catalog file to write")
+
+	catalogSearchCmd.Flags().String("catalog", "catalog.db", "Catalog file to search")
+	catalogSearchCmd.Flags().StringP("output", "o", "table", "Output format (table, json)")
+}
@@ -0,0 +1,234 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/TFMV/lockbox/pkg/lockbox"
+	"github.com/spf13/cobra"
+)
+
+var checkFKCmd = &cobra.Command{
+	Use:   "check-fk \"child.lbx:column -> parent.lbx:column\"",
+	Short: "Check that a column only references keys present in another lockbox",
+	Long: `Verify referential integrity between two lockboxes. The key column of the
+parent is read into an index of its distinct values, then every non-null
+value of the child column is looked up in it. Only the two key columns are
+decrypted; orphaned keys are reported, no other data is exported.
+
+Quote the relation, or give child and parent as separate arguments, since
+the shell would treat an unquoted > as a redirect. With --catalog, file
+names that do not exist are looked up in a catalog written by
+"catalog index", and the columns are checked against it before any
+password is asked for.
+
+The command fails when orphaned keys are found, so it can gate pipelines.
+
+Example:
+  lockbox check-fk "orders.lbx:customer_id -> customers.lbx:id"
+  lockbox check-fk orders.lbx:customer_id customers.lbx:id --catalog catalog.db`,
+	Args: cobra.RangeArgs(1, 2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		catalogFile, _ := cmd.Flags().GetString("catalog")
+		output, _ := cmd.Flags().GetString("output")
+		principal, _ := cmd.Flags().GetString("principal")
+		maxOrphans, _ := cmd.Flags().GetInt("max-orphans")
+
+		child, parent, err := parseForeignKey(args)
+		if err != nil {
+			return err
+		}
+		if catalogFile != "" {
+			c, err := lockbox.LoadCatalog(catalogFile)
+			if err != nil {
+				return err
+			}
+			dir := filepath.Dir(catalogFile)
+			if err := child.resolve(c, dir); err != nil {
+				return err
+			}
+			if err := parent.resolve(c, dir); err != nil {
+				return err
+			}
+		}
+
+		ctx := cmd.Context()
+
+		parentLB, parentPassword, err := openWithPassword(cmd, parent.file)
+		if err != nil {
+			return err
+		}
+		defer parentLB.Close()
+		index, err := parentLB.KeyIndex(ctx, parent.column, lockbox.WithPassword(parentPassword), lockbox.WithPrincipal(principal))
+		if err != nil {
+			return fmt.Errorf("failed to index %s: %w", parent, err)
+		}
+
+		childLB, childPassword, err := openWithPassword(cmd, child.file)
+		if err != nil {
+			return err
+		}
+		defer childLB.Close()
+		report, err := childLB.CheckForeignKey(ctx, child.column, index,
+			lockbox.WithPassword(childPassword),
+			lockbox.WithPrincipal(principal),
+			lockbox.WithMaxOrphans(maxOrphans),
+		)
+		if err != nil {
+			return fmt.Errorf("failed to check %s: %w", child, err)
+		}
+
+		if output == "json" {
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "  ")
+			if err := enc.Encode(report); err != nil {
+				return err
+			}
+		} else {
+			printForeignKeyReport(report)
+		}
+
+		if !report.Valid() {
+			return fmt.Errorf("%d rows of %s reference %d keys missing from %s", report.OrphanRows, child, report.OrphanCount, parent)
+		}
+		return nil
+	},
+}
+
+// keyRef names a column of a lockbox file as file:column
+type keyRef struct {
+	file, column string
+}
+
+func (r keyRef) String() string {
+	return r.file + ":" + r.column
+}
+
+func parseKeyRef(s string) (keyRef, error) {
+	s = strings.TrimSpace(s)
+	i := strings.LastIndex(s, ":")
+	if i <= 0 || i == len(s)-1 {
+		return keyRef{}, fmt.Errorf("invalid key %q, expected file:column", s)
+	}
+	return keyRef{file: s[:i], column: s[i+1:]}, nil
+}
+
+// parseForeignKey accepts "child -> parent" as one argument, or child and
+// parent as two
+func parseForeignKey(args []string) (keyRef, keyRef, error) {
+	var childSpec, parentSpec string
+	if len(args) == 2 {
+		childSpec, parentSpec = args[0], args[1]
+	} else {
+		var ok bool
+		childSpec, parentSpec, ok = strings.Cut(args[0], "->")
+		if !ok {
+			return keyRef{}, keyRef{}, fmt.Errorf("invalid relation %q, expected child:column -> parent:column", args[0])
+		}
+	}
+
+	child, err := parseKeyRef(childSpec)
+	if err != nil {
+		return keyRef{}, keyRef{}, err
+	}
+	parent, err := parseKeyRef(parentSpec)
+	if err != nil {
+		return keyRef{}, keyRef{}, err
+	}
+	return child, parent, nil
+}
+
+// resolve finds the file in the catalog when it does not exist as given,
+// and checks that the catalog lists the column. Relative paths in the
+// catalog are tried as given and then relative to dir, where the catalog
+// file is.
+func (r *keyRef) resolve(c *lockbox.Catalog, dir string) error {
+	var found *lockbox.CatalogEntry
+	for i, e := range c.Entries {
+		if e.Path == r.file || filepath.Clean(e.Path) == filepath.Clean(r.file) {
+			found = &c.Entries[i]
+			break
+		}
+	}
+	if found == nil {
+		if _, err := os.Stat(r.file); err == nil {
+			return nil
+		}
+		for i, e := range c.Entries {
+			if filepath.Base(e.Path) != filepath.Base(r.file) {
+				continue
+			}
+			if found != nil {
+				return fmt.Errorf("%s matches both %s and %s in the catalog", r.file, found.Path, e.Path)
+			}
+			found = &c.Entries[i]
+		}
+	}
+	if found == nil {
+		return fmt.Errorf("%s is not in the catalog", r.file)
+	}
+
+	r.file = found.Path
+	if _, err := os.Stat(r.file); err != nil && !filepath.IsAbs(r.file) {
+		r.file = filepath.Join(dir, r.file)
+	}
+	for _, col := range found.Columns {
+		if col.Name == r.column {
+			return nil
+		}
+	}
+	return fmt.Errorf("%s has no column %s", r.file, r.column)
+}
+
+// openWithPassword opens a lockbox, taking its password from the password
+// flags, the agent or a prompt naming the file
+func openWithPassword(cmd *cobra.Command, path string) (*lockbox.Lockbox, string, error) {
+	password, ok, err := flagPassword(cmd)
+	if err != nil {
+		return nil, "", err
+	}
+	if !ok {
+		password, ok = agentPassword(path)
+	}
+	if !ok {
+		fmt.Printf("%s\n", path)
+		if password, err = promptPassword(); err != nil {
+			return nil, "", err
+		}
+	}
+
+	lb, err := lockbox.Open(path, lockbox.WithPassword(password))
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to open %s: %w", path, err)
+	}
+	return lb, password, nil
+}
+
+func printForeignKeyReport(r *lockbox.ForeignKeyReport) {
+	fmt.Printf("%s:%s -> %s:%s\n", r.Child, r.ChildColumn, r.Parent, r.ParentColumn)
+	fmt.Printf("  %d rows checked against %d keys, %d null\n", r.Rows, r.ParentKeys, r.NullRows)
+	if r.Valid() {
+		fmt.Println("  OK: every key has a match")
+		return
+	}
+	fmt.Printf("  %d orphaned rows with %d distinct keys\n", r.OrphanRows, r.OrphanCount)
+	for _, o := range r.Orphans {
+		fmt.Printf("    %s (%d rows)\n", o.Key, o.Rows)
+	}
+	if r.Truncated {
+		fmt.Printf("    ... %d more keys\n", r.OrphanCount-len(r.Orphans))
+	}
+}
+
+func init() {
+	rootCmd.AddCommand(checkFKCmd)
+
+	addPasswordFlags(checkFKCmd.Flags(), "Password for both lockboxes")
+	checkFKCmd.Flags().String("catalog", "", "Catalog file to resolve lockbox names and check columns against")
+	checkFKCmd.Flags().StringP("output", "o", "table", "Output format (table, json)")
+	checkFKCmd.Flags().String("principal", "", "User or role the access policy is evaluated for")
+	checkFKCmd.Flags().Int("max-orphans", 100, "Number of orphaned keys to list")
+}
@@ -0,0 +1,79 @@
+package cmd
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/TFMV/lockbox/pkg/lockbox"
+	"github.com/spf13/cobra"
+)
+
+var computeCmd = &cobra.Command{
+	Use:   "compute [lockbox-file]",
+	Short: "Store the result of an expression in a column",
+	Long: `Evaluate an expression over the existing rows and write the result into
+a column. An existing column is rewritten with the result converted to its
+type, without touching the other columns; a new column is added to the
+schema. Unlike a virtual column the result is stored, so later changes to
+the inputs do not update it.
+
+Each --set is applied in order and committed as its own snapshot.
+
+Example:
+  lockbox compute data.lbx --set "total = price * qty" -m "Backfill totals"`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		sets, _ := cmd.Flags().GetStringArray("set")
+		message, _ := cmd.Flags().GetString("message")
+		principal, _ := cmd.Flags().GetString("principal")
+		if len(sets) == 0 {
+			return fmt.Errorf("--set is required")
+		}
+
+		type assignment struct{ column, expression string }
+		var assignments []assignment
+		for _, s := range sets {
+			column, expression, ok := strings.Cut(s, "=")
+			column, expression = strings.TrimSpace(column), strings.TrimSpace(expression)
+			if !ok || column == "" || expression == "" {
+				return fmt.Errorf("invalid --set %q, expected column = expression", s)
+			}
+			assignments = append(assignments, assignment{column, expression})
+		}
+
+		password, err := readPassword(cmd)
+		if err != nil {
+			return err
+		}
+
+		lb, err := lockbox.Open(args[0], lockbox.WithPassword(password))
+		if err != nil {
+			return fmt.Errorf("failed to open lockbox: %w", err)
+		}
+		defer lb.Close()
+
+		ctx := cmd.Context()
+		for _, a := range assignments {
+			rows, err := lb.Compute(ctx, a.column, a.expression,
+				lockbox.WithPassword(password),
+				lockbox.WithMessage(message),
+				lockbox.WithPrincipal(principal),
+			)
+			if err != nil {
+				return fmt.Errorf("failed to compute %s: %w", a.column, err)
+			}
+			fmt.Printf("Computed %s for %d rows\n", a.column, rows)
+		}
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(computeCmd)
+
+	computeCmd.Flags().StringArray("set", nil, "Assignment of the form \"column = expression\"; can be repeated")
+	addPasswordFlags(computeCmd.Flags(), "Password for the lockbox")
+	computeCmd.Flags().StringP("message", "m", "", "Commit message recorded with each column")
+	computeCmd.Flags().String("principal", "", "User or role the access policy is evaluated for")
+	addProfileFlags(computeCmd)
+}
@@ -4,7 +4,12 @@ import (
 	"encoding/json"
 	"fmt"
 	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
 
+	"github.com/TFMV/lockbox/pkg/geo"
 	"github.com/TFMV/lockbox/pkg/lockbox"
 	"github.com/apache/arrow-go/v18/arrow"
 	"github.com/rs/zerolog/log"
@@ -16,27 +21,204 @@ var createCmd = &cobra.Command{
 	Short: "Create a new lockbox file",
 	Long: `Create a new lockbox file with the specified schema.
 
-The schema can be provided as a JSON file or generated from sample data.`,
+The schema can be provided as a JSON file or generated from sample data.
+
+With --profile, or a profile key in the config file, the settings of that
+profile under "profiles" in the config file are applied: crypto module,
+key derivation, default compression, column masks, file mode and recovery
+codes. Explicit flags override the file mode and recovery codes; the other
+settings are enforced.
+
+Each --column-group name=col,col,... stores those columns together, as one
+block per write encrypted under a key of the group, which saves space and
+time for very wide schemas. Columns outside a group keep keys of their own.
+Files with column groups cannot be read by older versions of lockbox.
+
+With --encrypt-metadata the schema, audit trail, access policy and tags are
+encrypted under the master key as well. Only what is needed to unlock the
+key stays in the clear, so info --no-decrypt and catalogs show little more
+than the file size. Older versions of lockbox cannot read such files.
+
+--synthetic-key id=uuid (or id=snowflake) records id as the file's key
+column, adding it to the schema if needed. Writes that leave it out, or
+leave values null, get random UUIDs (or time-ordered int64 ids) there.
+Each --auto-increment column, which must be int64, is numbered 1, 2, 3...
+the same way, continuing across commits.
+
+--crypto-module mlkem768 mixes a secret encapsulated by ML-KEM-768 (FIPS
+203) into every block key next to the default module's edwards25519
+exchange, which is not quantum resistant. Each column gets an ML-KEM key of
+its own, stored wrapped under the master key. Older versions of lockbox
+cannot read such files.
+
+With --kdf argon2id keys are derived from the password with Argon2id
+instead of PBKDF2, costing --argon2-time passes over --argon2-memory MiB.
+The costs are recorded in the file and used whenever it is opened.
+
+--from takes the schema from a data file instead of --schema: the schema
+of a Parquet file, or types inferred from the first --infer-sample objects
+of a JSON or NDJSON file (.json, .ndjson, .jsonl) or rows of a CSV file.
+--flatten sep turns nested objects and Parquet structs into columns named
+by their path, e.g. address.city, and --type name=type overrides inferred
+types, with the type names of schema files. --infer is an alias of --from.
+
+--subject takes the schema from a version of a subject of the schema
+registry at --registry (Confluent Schema Registry, or Apicurio Registry at
+its /apis/ccompat/v7 path), the latest unless --schema-version is given.
+Avro and JSON Schema definitions are converted to Arrow, and the registry,
+subject and version are recorded in the file: later ingests check that the
+subject's latest version still fits and record it in their lineage.
+
+Examples:
+  lockbox create sales.lbx --from sales.parquet --password-env LOCKBOX_PW
+  lockbox create events.lbx --from events.ndjson --flatten . --type ts=timestamp
+  lockbox create orders.lbx --registry http://localhost:8081 --subject orders-value`,
 	Args: cobra.ExactArgs(1),
 	RunE: func(cmd *cobra.Command, args []string) error {
 		filename := args[0]
 
 		schemaFile, _ := cmd.Flags().GetString("schema")
-		password, _ := cmd.Flags().GetString("password")
 		createdBy, _ := cmd.Flags().GetString("created-by")
+		recoveryCodes, _ := cmd.Flags().GetInt("recovery-codes")
+		modeStr, _ := cmd.Flags().GetString("mode")
+		owner, _ := cmd.Flags().GetString("owner")
+		group, _ := cmd.Flags().GetString("group")
+		columnGroups, _ := cmd.Flags().GetStringArray("column-group")
+		codecName, _ := cmd.Flags().GetString("codec")
+		encryptMetadata, _ := cmd.Flags().GetBool("encrypt-metadata")
+		cryptoModule, _ := cmd.Flags().GetString("crypto-module")
+		syntheticKey, _ := cmd.Flags().GetString("synthetic-key")
+		autoIncrement, _ := cmd.Flags().GetStringArray("auto-increment")
+		fromFile, _ := cmd.Flags().GetString("from")
+		inferSample, _ := cmd.Flags().GetInt("infer-sample")
+		flatten, _ := cmd.Flags().GetString("flatten")
+		typeHints, _ := cmd.Flags().GetStringArray("type")
+		registryURL, _ := cmd.Flags().GetString("registry")
+		subject, _ := cmd.Flags().GetString("subject")
+		schemaVersion, _ := cmd.Flags().GetInt("schema-version")
+		if inferFile, _ := cmd.Flags().GetString("infer"); inferFile != "" {
+			if fromFile != "" {
+				return fmt.Errorf("--from and --infer cannot be used together")
+			}
+			fromFile = inferFile
+		}
+		if fromFile != "" && schemaFile != "" {
+			return fmt.Errorf("--from and --schema cannot be used together")
+		}
+		if subject != "" && (schemaFile != "" || fromFile != "") {
+			return fmt.Errorf("--subject cannot be used with --schema or --from")
+		}
+		if subject != "" && registryURL == "" {
+			return fmt.Errorf("--subject requires --registry")
+		}
 
+		password, err := readPassword(cmd)
+		if err != nil {
+			return err
+		}
 		if password == "" {
 			return fmt.Errorf("password is required")
 		}
 
+		mode, err := strconv.ParseUint(modeStr, 8, 32)
+		if err != nil || mode > 0o777 {
+			return fmt.Errorf("invalid --mode %q: expected octal permissions such as 0600", modeStr)
+		}
+		preset, presetCfg, err := selectedPreset()
+		if err != nil {
+			return err
+		}
+		if preset != nil {
+			if m, err := presetCfg.fileMode(); err != nil {
+				return err
+			} else if m != 0 && !cmd.Flags().Changed("mode") {
+				mode = uint64(m)
+			}
+			if presetCfg.RecoveryCodes > 0 && !cmd.Flags().Changed("recovery-codes") {
+				recoveryCodes = presetCfg.RecoveryCodes
+			}
+		}
+		createOpts := []lockbox.Option{
+			lockbox.WithPassword(password),
+			lockbox.WithCreatedBy(createdBy),
+			lockbox.WithFileMode(os.FileMode(mode)),
+			lockbox.WithPreset(preset),
+			lockbox.WithCompression(codecName),
+			lockbox.WithCryptoModule(cryptoModule),
+		}
+		if owner != "" || group != "" {
+			uid, gid, err := lookupOwner(owner, group)
+			if err != nil {
+				return err
+			}
+			createOpts = append(createOpts, lockbox.WithOwner(uid, gid))
+		}
+		for _, g := range columnGroups {
+			name, cols, ok := strings.Cut(g, "=")
+			if !ok || name == "" || cols == "" {
+				return fmt.Errorf("invalid --column-group %q: expected name=col,col,...", g)
+			}
+			createOpts = append(createOpts, lockbox.WithColumnGroup(name, strings.Split(cols, ",")...))
+		}
+		if encryptMetadata {
+			createOpts = append(createOpts, lockbox.WithEncryptedMetadata())
+		}
+		if syntheticKey != "" {
+			column, kind, ok := strings.Cut(syntheticKey, "=")
+			if !ok || column == "" {
+				return fmt.Errorf("invalid --synthetic-key %q: expected column=uuid or column=snowflake", syntheticKey)
+			}
+			createOpts = append(createOpts, lockbox.WithSyntheticKey(column, kind))
+		}
+		if len(autoIncrement) > 0 {
+			createOpts = append(createOpts, lockbox.WithAutoIncrement(autoIncrement...))
+		}
+		kdfOpts, err := kdfOptions(cmd.Flags())
+		if err != nil {
+			return err
+		}
+		createOpts = append(createOpts, kdfOpts...)
+
 		var schema *arrow.Schema
-		var err error
 
 		if schemaFile != "" {
 			schema, err = loadSchemaFromFile(schemaFile)
 			if err != nil {
 				return fmt.Errorf("failed to load schema: %w", err)
 			}
+		} else if subject != "" {
+			registry := lockbox.SchemaRegistry{URL: registryURL}
+			rs, err := registry.Fetch(cmd.Context(), subject, schemaVersion)
+			if err != nil {
+				return err
+			}
+			schema = rs.Schema
+			createOpts = append(createOpts, lockbox.WithSchemaRef(rs.Ref))
+			fmt.Printf("Schema: subject %s version %d (%s) from %s\n", rs.Ref.Subject, rs.Ref.Version, rs.Ref.Type, rs.Ref.Registry)
+		} else if fromFile != "" {
+			detectOpts := []lockbox.Option{lockbox.WithFlatten(flatten)}
+			for _, h := range typeHints {
+				name, typeName, ok := strings.Cut(h, "=")
+				if !ok || name == "" {
+					return fmt.Errorf("invalid --type %q: expected name=type", h)
+				}
+				typ, err := parseFieldType(typeName)
+				if err != nil {
+					return fmt.Errorf("invalid --type %q: %w", h, err)
+				}
+				detectOpts = append(detectOpts, lockbox.WithTypeHint(name, typ))
+			}
+			switch strings.ToLower(filepath.Ext(fromFile)) {
+			case ".parquet", ".pq":
+				schema, err = lockbox.DetectParquetSchema(fromFile, detectOpts...)
+			case ".json", ".ndjson", ".jsonl":
+				schema, err = lockbox.DetectJSONSchema(fromFile, inferSample, detectOpts...)
+			default:
+				schema, err = lockbox.DetectCSVSchema(fromFile, inferSample, detectOpts...)
+			}
+			if err != nil {
+				return fmt.Errorf("failed to infer schema from %s: %w", fromFile, err)
+			}
 		} else {
 			// Default schema for demonstration
 			schema = arrow.NewSchema([]arrow.Field{
@@ -49,23 +231,32 @@ The schema can be provided as a JSON file or generated from sample data.`,
 		}
 
 		// Create the lockbox
-		lb, err := lockbox.Create(
-			filename,
-			schema,
-			lockbox.WithPassword(password),
-			lockbox.WithCreatedBy(createdBy),
-		)
+		lb, err := lockbox.Create(filename, schema, createOpts...)
 		if err != nil {
 			return fmt.Errorf("failed to create lockbox: %w", err)
 		}
 		defer lb.Close()
 
 		fmt.Printf("Successfully created lockbox: %s\n", filename)
+		if preset != nil {
+			fmt.Printf("Profile: %s\n", preset.Name)
+		}
 		fmt.Printf("Schema fields: %d\n", len(schema.Fields()))
 		for i, field := range schema.Fields() {
 			fmt.Printf("  %d. %s (%s)\n", i+1, field.Name, field.Type)
 		}
 
+		if recoveryCodes > 0 {
+			codes, err := lb.GenerateRecoveryCodes(recoveryCodes, lockbox.WithPassword(password), lockbox.WithCreatedBy(createdBy))
+			if err != nil {
+				return fmt.Errorf("failed to generate recovery codes: %w", err)
+			}
+			fmt.Printf("\nRecovery codes (shown only once, each can be used once):\n")
+			for _, code := range codes {
+				fmt.Printf("  %s\n", code)
+			}
+		}
+
 		return nil
 	},
 }
@@ -74,12 +265,57 @@ func init() {
 	rootCmd.AddCommand(createCmd)
 
 	createCmd.Flags().StringP("schema", "s", "", "JSON schema file")
-	createCmd.Flags().StringP("password", "p", "", "Password for encryption (required)")
+	createCmd.Flags().String("from", "", "Take the schema from a Parquet, JSON, NDJSON or CSV data file")
+	createCmd.Flags().String("infer", "", "Alias of --from")
+	createCmd.Flags().MarkHidden("infer")
+	createCmd.Flags().Int("infer-sample", 1000, "Objects or rows of JSON and CSV files read by --from")
+	createCmd.Flags().String("flatten", "", "With --from, map nested objects and structs to columns joined by this separator")
+	createCmd.Flags().StringArray("type", nil, "With --from, override the type of a column as name=type (repeatable)")
+	createCmd.Flags().String("registry", "", "Schema registry URL for --subject")
+	createCmd.Flags().String("subject", "", "Take the schema from this schema registry subject")
+	createCmd.Flags().Int("schema-version", 0, "Version of --subject (default latest)")
+	addPasswordFlags(createCmd.Flags(), "Password for encryption")
 	createCmd.Flags().String("created-by", "system", "Creator name")
+	createCmd.Flags().Int("recovery-codes", 0, "Generate this many one-time password recovery codes")
+	createCmd.Flags().String("mode", "0600", "Permissions of the new file, in octal")
+	createCmd.Flags().String("owner", "", "Owner of the new file, as a user name or id")
+	createCmd.Flags().String("group", "", "Group of the new file, as a group name or id")
+	createCmd.Flags().String("codec", "", "Default compression codec for writes (e.g. zstd, lz4, gzip)")
+	createCmd.Flags().StringArray("column-group", nil, "Store columns together as name=col,col,... (repeatable)")
+	createCmd.Flags().Bool("encrypt-metadata", false, "Encrypt the schema, audit trail and other metadata")
+	createCmd.Flags().String("synthetic-key", "", "Fill column=uuid or column=snowflake with generated keys on write")
+	createCmd.Flags().StringArray("auto-increment", nil, "Number this int64 column 1, 2, 3... across writes that leave it out (repeatable)")
+	createCmd.Flags().String("crypto-module", "", "Crypto module for the file, e.g. mlkem768 (see modules list)")
+	addKDFFlags(createCmd.Flags())
+}
 
-	if err := createCmd.MarkFlagRequired("password"); err != nil {
-		log.Fatal().Err(err).Msg("Failed to mark password flag as required")
+// lookupOwner resolves user and group names or ids for chown; an empty name
+// resolves to -1, which leaves that id unchanged
+func lookupOwner(owner, group string) (int, int, error) {
+	uid, gid := -1, -1
+	if owner != "" {
+		id := owner
+		if u, err := user.Lookup(owner); err == nil {
+			id = u.Uid
+		}
+		n, err := strconv.Atoi(id)
+		if err != nil {
+			return 0, 0, fmt.Errorf("unknown user %s", owner)
+		}
+		uid = n
 	}
+	if group != "" {
+		id := group
+		if g, err := user.LookupGroup(group); err == nil {
+			id = g.Gid
+		}
+		n, err := strconv.Atoi(id)
+		if err != nil {
+			return 0, 0, fmt.Errorf("unknown group %s", group)
+		}
+		gid = n
+	}
+	return uid, gid, nil
 }
 
 // loadSchemaFromFile loads an Arrow schema from a JSON file
@@ -108,38 +344,24 @@ func loadSchemaFromFile(filename string) (*arrow.Schema, error) {
 
 	var fields []arrow.Field
 	for _, field := range schemaJSON.Fields {
-		var dataType arrow.DataType
-
-		switch field.Type {
-		case "int64":
-			dataType = arrow.PrimitiveTypes.Int64
-		case "int32":
-			dataType = arrow.PrimitiveTypes.Int32
-		case "float64":
-			dataType = arrow.PrimitiveTypes.Float64
-		case "float32":
-			dataType = arrow.PrimitiveTypes.Float32
-		case "string":
-			dataType = arrow.BinaryTypes.String
-		case "binary", "blob":
-			dataType = arrow.BinaryTypes.Binary
-		case "date":
-			dataType = arrow.FixedWidthTypes.Date32
-		case "timestamp":
-			dataType = arrow.FixedWidthTypes.Timestamp_s
-		case "time":
-			dataType = arrow.FixedWidthTypes.Time32ms
-		case "duration":
-			dataType = arrow.FixedWidthTypes.Duration_s
-		case "bool":
-			dataType = arrow.FixedWidthTypes.Boolean
-		default:
-			return nil, fmt.Errorf("unsupported type: %s", field.Type)
+		dataType, err := parseFieldType(field.Type)
+		if err != nil {
+			return nil, err
 		}
 
-		var md arrow.Metadata
+		var keys, values []string
+		if field.Type == "json" && field.Mime == "" {
+			field.Mime = lockbox.JSONMime
+		}
 		if field.Mime != "" {
-			md = arrow.NewMetadata([]string{"mime"}, []string{field.Mime})
+			keys, values = append(keys, "mime"), append(values, field.Mime)
+		}
+		if field.Type == "wkb" {
+			keys, values = append(keys, geo.MetadataKey), append(values, "wkb")
+		}
+		var md arrow.Metadata
+		if len(keys) > 0 {
+			md = arrow.NewMetadata(keys, values)
 		}
 
 		fields = append(fields, arrow.Field{
@@ -152,3 +374,110 @@ func loadSchemaFromFile(filename string) (*arrow.Schema, error) {
 
 	return arrow.NewSchema(fields, nil), nil
 }
+
+// parseFieldType returns the Arrow type of a type name of schema files,
+// e.g. int64, timestamp, decimal(12,2) or vector(384)
+func parseFieldType(typeName string) (arrow.DataType, error) {
+	switch typeName {
+	case "int64":
+		return arrow.PrimitiveTypes.Int64, nil
+	case "int32":
+		return arrow.PrimitiveTypes.Int32, nil
+	case "float64":
+		return arrow.PrimitiveTypes.Float64, nil
+	case "float32":
+		return arrow.PrimitiveTypes.Float32, nil
+	case "string", "json":
+		return arrow.BinaryTypes.String, nil
+	case "binary", "blob":
+		return arrow.BinaryTypes.Binary, nil
+	case "date":
+		return arrow.FixedWidthTypes.Date32, nil
+	case "timestamp":
+		return arrow.FixedWidthTypes.Timestamp_s, nil
+	case "time":
+		return arrow.FixedWidthTypes.Time32ms, nil
+	case "duration":
+		return arrow.FixedWidthTypes.Duration_s, nil
+	case "bool":
+		return arrow.FixedWidthTypes.Boolean, nil
+	case "point":
+		return geo.PointType(), nil
+	case "wkb":
+		return arrow.BinaryTypes.Binary, nil
+	}
+	// list<T>, map<K,V> and struct<name:T,...> nest other types
+	if open := strings.IndexByte(typeName, '<'); open > 0 && strings.HasSuffix(typeName, ">") {
+		args := splitTypeArgs(typeName[open+1 : len(typeName)-1])
+		switch kind := typeName[:open]; {
+		case kind == "list" && len(args) == 1:
+			elem, err := parseFieldType(args[0])
+			if err != nil {
+				return nil, err
+			}
+			return arrow.ListOf(elem), nil
+		case kind == "map" && len(args) == 2:
+			key, err := parseFieldType(args[0])
+			if err != nil {
+				return nil, err
+			}
+			item, err := parseFieldType(args[1])
+			if err != nil {
+				return nil, err
+			}
+			return arrow.MapOf(key, item), nil
+		case kind == "struct" && len(args) > 0:
+			fields := make([]arrow.Field, len(args))
+			for i, arg := range args {
+				name, fieldType, ok := strings.Cut(arg, ":")
+				if !ok || strings.TrimSpace(name) == "" {
+					return nil, fmt.Errorf("invalid struct field %q: expected name:type", arg)
+				}
+				dt, err := parseFieldType(strings.TrimSpace(fieldType))
+				if err != nil {
+					return nil, err
+				}
+				fields[i] = arrow.Field{Name: strings.TrimSpace(name), Type: dt, Nullable: true}
+			}
+			return arrow.StructOf(fields...), nil
+		}
+		return nil, fmt.Errorf("unsupported type: %s", typeName)
+	}
+
+	// decimal(P,S) holds exact numbers of P digits, S of them after the point
+	var precision, scale int32
+	if n, _ := fmt.Sscanf(typeName, "decimal(%d,%d)", &precision, &scale); n == 2 {
+		if precision < 1 || precision > 38 || scale < 0 || scale > precision {
+			return nil, fmt.Errorf("invalid precision or scale: %s", typeName)
+		}
+		return &arrow.Decimal128Type{Precision: precision, Scale: scale}, nil
+	}
+
+	// vector(N) holds embeddings of N float32 values
+	var dim int
+	if n, _ := fmt.Sscanf(typeName, "vector(%d)", &dim); n != 1 || dim <= 0 {
+		return nil, fmt.Errorf("unsupported type: %s", typeName)
+	}
+	return lockbox.VectorType(dim), nil
+}
+
+// splitTypeArgs splits the arguments of a nested type at the commas that
+// are not inside another type's brackets
+func splitTypeArgs(s string) []string {
+	var args []string
+	depth, start := 0, 0
+	for i, c := range s {
+		switch c {
+		case '<', '(':
+			depth++
+		case '>', ')':
+			depth--
+		case ',':
+			if depth == 0 {
+				args = append(args, strings.TrimSpace(s[start:i]))
+				start = i + 1
+			}
+		}
+	}
+	return append(args, strings.TrimSpace(s[start:]))
+}
@@ -0,0 +1,54 @@
+package cmd
+
+import (
+	"fmt"
+	"unicode/utf8"
+
+	"github.com/TFMV/lockbox/pkg/lockbox"
+	"github.com/spf13/cobra"
+)
+
+// addCSVFlags registers the CSV dialect flags. Commands that write CSV also
+// get --bom.
+func addCSVFlags(cmd *cobra.Command, output bool) {
+	cmd.Flags().String("delimiter", "", `CSV field delimiter, e.g. ";" or "\t" (default: detected on input, "," on output)`)
+	cmd.Flags().String("quote", "", `CSV quote character (default: detected on input, '"' on output)`)
+	cmd.Flags().String("null", "", "Token that stands for null in CSV (default: empty field)")
+	cmd.Flags().StringArray("date-format", nil, "Go time layout for CSV timestamps, e.g. 02.01.2006; repeatable, the first is used on output")
+	if output {
+		cmd.Flags().Bool("bom", false, "Start CSV output with a UTF-8 byte order mark")
+	}
+}
+
+// csvOptions turns the CSV dialect flags into lockbox options
+func csvOptions(cmd *cobra.Command) ([]lockbox.Option, error) {
+	var opts []lockbox.Option
+	for _, name := range []string{"delimiter", "quote"} {
+		value, _ := cmd.Flags().GetString(name)
+		if value == "" {
+			continue
+		}
+		if value == `\t` {
+			value = "\t"
+		}
+		r, size := utf8.DecodeRuneInString(value)
+		if size != len(value) || r == '\n' || r == '\r' {
+			return nil, fmt.Errorf("--%s must be a single character, got %q", name, value)
+		}
+		if name == "delimiter" {
+			opts = append(opts, lockbox.WithDelimiter(r))
+		} else {
+			opts = append(opts, lockbox.WithQuote(r))
+		}
+	}
+	if null, _ := cmd.Flags().GetString("null"); null != "" {
+		opts = append(opts, lockbox.WithNullToken(null))
+	}
+	if layouts, _ := cmd.Flags().GetStringArray("date-format"); len(layouts) > 0 {
+		opts = append(opts, lockbox.WithDateFormats(layouts...))
+	}
+	if bom, _ := cmd.Flags().GetBool("bom"); bom {
+		opts = append(opts, lockbox.WithBOM())
+	}
+	return opts, nil
+}
@@ -0,0 +1,103 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/TFMV/lockbox/pkg/lockbox"
+	"github.com/spf13/cobra"
+)
+
+var deleteCmd = &cobra.Command{
+	Use:   "delete [lockbox-file]",
+	Short: "Delete the rows matching a condition",
+	Long: `Delete the rows matching a WHERE condition, in the syntax of queries.
+Rows are marked deleted rather than rewritten, so deleting is cheap and
+queries skip them at once; run compact to remove them from the file.
+
+Example:
+  lockbox delete data.lbx --where "status = 'void'" -m "Drop voided orders"
+  lockbox compact data.lbx`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		where, _ := cmd.Flags().GetString("where")
+		message, _ := cmd.Flags().GetString("message")
+		principal, _ := cmd.Flags().GetString("principal")
+		if where == "" {
+			return fmt.Errorf("--where is required")
+		}
+
+		password, err := readPassword(cmd)
+		if err != nil {
+			return err
+		}
+		lb, err := lockbox.Open(args[0], lockbox.WithPassword(password))
+		if err != nil {
+			return fmt.Errorf("failed to open lockbox: %w", err)
+		}
+		defer lb.Close()
+
+		rows, err := lb.Delete(cmd.Context(), where,
+			lockbox.WithPassword(password),
+			lockbox.WithMessage(message),
+			lockbox.WithPrincipal(principal),
+		)
+		if err != nil {
+			return fmt.Errorf("failed to delete: %w", err)
+		}
+		fmt.Printf("Deleted %d rows\n", rows)
+		return nil
+	},
+}
+
+var compactCmd = &cobra.Command{
+	Use:   "compact [lockbox-file]",
+	Short: "Remove deleted rows from the file",
+	Long: `Rewrite the row groups that have deleted rows without them and
+rewrite the file without the old blocks.
+
+Example:
+  lockbox compact data.lbx`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		message, _ := cmd.Flags().GetString("message")
+		principal, _ := cmd.Flags().GetString("principal")
+
+		password, err := readPassword(cmd)
+		if err != nil {
+			return err
+		}
+		lb, err := lockbox.Open(args[0], lockbox.WithPassword(password))
+		if err != nil {
+			return fmt.Errorf("failed to open lockbox: %w", err)
+		}
+		defer lb.Close()
+
+		rows, err := lb.Compact(cmd.Context(),
+			lockbox.WithPassword(password),
+			lockbox.WithMessage(message),
+			lockbox.WithPrincipal(principal),
+		)
+		if err != nil {
+			return fmt.Errorf("failed to compact: %w", err)
+		}
+		if rows == 0 {
+			fmt.Println("No deleted rows to remove")
+			return nil
+		}
+		fmt.Printf("Removed %d deleted rows\n", rows)
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(deleteCmd)
+	rootCmd.AddCommand(compactCmd)
+
+	deleteCmd.Flags().String("where", "", "Condition the deleted rows match, e.g. \"id = 3\"")
+	for _, c := range []*cobra.Command{deleteCmd, compactCmd} {
+		addPasswordFlags(c.Flags(), "Password for the lockbox")
+		c.Flags().StringP("message", "m", "", "Commit message")
+		c.Flags().String("principal", "", "User or role the access policy is evaluated for")
+		addProfileFlags(c)
+	}
+}
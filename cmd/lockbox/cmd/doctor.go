@@ -0,0 +1,316 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+
+	"github.com/TFMV/lockbox/pkg/codec"
+	"github.com/TFMV/lockbox/pkg/crypto"
+	"github.com/TFMV/lockbox/pkg/lockbox"
+	"github.com/apache/arrow-go/v18/arrow"
+	"github.com/apache/arrow-go/v18/arrow/array"
+	"github.com/apache/arrow-go/v18/arrow/memory"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// Doctor check statuses
+const (
+	checkOK   = "ok"
+	checkWarn = "warn"
+	checkFail = "fail"
+	checkSkip = "skip"
+)
+
+// doctorCheck is the result of one doctor check
+type doctorCheck struct {
+	Name   string `json:"name"`
+	Status string `json:"status"`
+	Detail string `json:"detail,omitempty"`
+	Hint   string `json:"hint,omitempty"`
+}
+
+var doctorCmd = &cobra.Command{
+	Use:   "doctor [lockbox-file...]",
+	Short: "Check that lockbox works correctly on this machine",
+	Long: `Run self-tests and environment checks and report the results, e.g. to
+attach to a support ticket:
+- known-answer tests for SHA-256, AES-256-GCM and PBKDF2
+- an encrypt/decrypt round trip through each crypto module and codec
+- the configuration file
+- permissions of the given lockbox files and whether their directory
+  supports file locks
+- the settings of the given lockbox files that --strict-security refuses;
+  they fail the command in strict mode and are warnings otherwise
+- with --round-trip, creating, writing, querying and validating a small
+  lockbox in a temporary directory
+
+The command fails when any check fails.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		roundTrip, _ := cmd.Flags().GetBool("round-trip")
+		output, _ := cmd.Flags().GetString("output")
+
+		var checks []doctorCheck
+		for _, r := range crypto.SelfTest() {
+			checks = append(checks, resultCheck("crypto "+r.Name, r.Err, "the crypto implementation of this build is broken; reinstall lockbox"))
+		}
+		for _, name := range codec.Names() {
+			checks = append(checks, resultCheck("codec "+name, codecRoundTrip(name), "the codec is broken; do not write with it"))
+		}
+		checks = append(checks, configCheck())
+
+		var dirs []string
+		for _, path := range args {
+			checks = append(checks, permissionCheck(path))
+			checks = append(checks, securityChecks(path)...)
+			if dir := filepath.Dir(path); !slices.Contains(dirs, dir) {
+				dirs = append(dirs, dir)
+			}
+		}
+		if len(args) == 0 {
+			dirs = append(dirs, ".")
+		}
+		for _, dir := range dirs {
+			checks = append(checks, lockCheck(dir))
+		}
+
+		if roundTrip {
+			checks = append(checks, resultCheck("round trip", lockboxRoundTrip(cmd.Context()), "lockbox cannot store data on this machine; run with -v for details"))
+		} else {
+			checks = append(checks, doctorCheck{Name: "round trip", Status: checkSkip, Detail: "use --round-trip to run"})
+		}
+
+		if output == "json" {
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "  ")
+			if err := enc.Encode(map[string]interface{}{
+				"go":     runtime.Version(),
+				"os":     runtime.GOOS,
+				"arch":   runtime.GOARCH,
+				"checks": checks,
+			}); err != nil {
+				return err
+			}
+		} else {
+			fmt.Printf("lockbox doctor (%s, %s/%s)\n\n", runtime.Version(), runtime.GOOS, runtime.GOARCH)
+			for _, c := range checks {
+				fmt.Printf("[%-4s] %s", c.Status, c.Name)
+				if c.Detail != "" {
+					fmt.Printf(": %s", c.Detail)
+				}
+				fmt.Println()
+				if c.Hint != "" && c.Status != checkOK {
+					fmt.Printf("       -> %s\n", c.Hint)
+				}
+			}
+		}
+
+		failed := 0
+		for _, c := range checks {
+			if c.Status == checkFail {
+				failed++
+			}
+		}
+		if failed > 0 {
+			return fmt.Errorf("%d checks failed", failed)
+		}
+		return nil
+	},
+}
+
+func resultCheck(name string, err error, hint string) doctorCheck {
+	if err != nil {
+		return doctorCheck{Name: name, Status: checkFail, Detail: err.Error(), Hint: hint}
+	}
+	return doctorCheck{Name: name, Status: checkOK}
+}
+
+// codecRoundTrip compresses and restores a sample with a registered codec
+func codecRoundTrip(name string) error {
+	c, ok := codec.Get(name)
+	if !ok {
+		return fmt.Errorf("codec %s is not registered", name)
+	}
+	sample := bytes.Repeat([]byte("lockbox doctor "), 64)
+	enc, err := c.Encode(sample)
+	if err != nil {
+		return fmt.Errorf("encode: %w", err)
+	}
+	dec, err := c.Decode(enc)
+	if err != nil {
+		return fmt.Errorf("decode: %w", err)
+	}
+	if !bytes.Equal(dec, sample) {
+		return fmt.Errorf("round trip returned different data")
+	}
+	return nil
+}
+
+// configCheck verifies that the configuration file, if any, can be parsed
+func configCheck() doctorCheck {
+	c := doctorCheck{Name: "config"}
+	if err := viper.ReadInConfig(); err != nil {
+		if _, ok := err.(viper.ConfigFileNotFoundError); ok && cfgFile == "" {
+			c.Status = checkOK
+			c.Detail = "no config file"
+			return c
+		}
+		c.Status = checkFail
+		c.Detail = err.Error()
+		c.Hint = "fix or remove the config file, or pass another one with --config"
+		return c
+	}
+	c.Status = checkOK
+	c.Detail = viper.ConfigFileUsed()
+	return c
+}
+
+// permissionCheck warns about lockbox files other users can access
+func permissionCheck(path string) doctorCheck {
+	c := doctorCheck{Name: "permissions " + path}
+	fi, err := os.Stat(path)
+	if err != nil {
+		c.Status = checkFail
+		c.Detail = err.Error()
+		return c
+	}
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		c.Status = checkWarn
+		c.Detail = fmt.Sprintf("%s, not writable: %v", fi.Mode().Perm(), err)
+		c.Hint = "writes, key rotation and maintenance need write access"
+		return c
+	}
+	f.Close()
+
+	c.Detail = fi.Mode().Perm().String()
+	if runtime.GOOS != "windows" && fi.Mode().Perm()&0077 != 0 {
+		c.Status = checkWarn
+		c.Hint = fmt.Sprintf("other users can access the file; run chmod 600 %s", path)
+		return c
+	}
+	c.Status = checkOK
+	return c
+}
+
+// securityChecks reports the settings of a lockbox file that strict
+// security refuses
+func securityChecks(path string) []doctorCheck {
+	findings, err := lockbox.CheckSecurity(path)
+	if err != nil {
+		return []doctorCheck{{Name: "security " + path, Status: checkFail, Detail: err.Error()}}
+	}
+	status := checkWarn
+	if viper.GetBool("strict-security") {
+		status = checkFail
+	}
+	var checks []doctorCheck
+	for _, f := range findings {
+		checks = append(checks, doctorCheck{Name: "security " + path + " " + f.Check, Status: status, Detail: f.Detail, Hint: f.Fix})
+	}
+	if len(checks) == 0 {
+		checks = append(checks, doctorCheck{Name: "security " + path, Status: checkOK})
+	}
+	return checks
+}
+
+// lockCheck verifies that advisory file locks can be taken in dir
+func lockCheck(dir string) doctorCheck {
+	c := doctorCheck{Name: "file locks " + dir}
+	f, err := os.CreateTemp(dir, ".lockbox-doctor-*")
+	if err != nil {
+		c.Status = checkFail
+		c.Detail = err.Error()
+		c.Hint = "the directory must be writable"
+		return c
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	supported, err := tryLock(f)
+	switch {
+	case err != nil:
+		c.Status = checkFail
+		c.Detail = err.Error()
+		c.Hint = "the file system does not support locks (e.g. some network mounts); keep lockboxes on a local disk"
+	case !supported:
+		c.Status = checkSkip
+		c.Detail = "not supported on " + runtime.GOOS
+	default:
+		c.Status = checkOK
+	}
+	return c
+}
+
+// lockboxRoundTrip creates, writes, queries and validates a small lockbox
+func lockboxRoundTrip(ctx context.Context) error {
+	dir, err := os.MkdirTemp("", "lockbox-doctor-")
+	if err != nil {
+		return fmt.Errorf("failed to create temp dir: %w", err)
+	}
+	defer os.RemoveAll(dir)
+
+	secret := make([]byte, 16)
+	if _, err := rand.Read(secret); err != nil {
+		return fmt.Errorf("failed to generate password: %w", err)
+	}
+	password := hex.EncodeToString(secret)
+	path := filepath.Join(dir, "doctor.lbx")
+
+	schema := arrow.NewSchema([]arrow.Field{
+		{Name: "id", Type: arrow.PrimitiveTypes.Int64},
+		{Name: "name", Type: arrow.BinaryTypes.String},
+	}, nil)
+	lb, err := lockbox.Create(path, schema, lockbox.WithPassword(password), lockbox.WithCreatedBy("doctor"))
+	if err != nil {
+		return fmt.Errorf("create: %w", err)
+	}
+	defer lb.Close()
+
+	mem := memory.NewGoAllocator()
+	idb := array.NewInt64Builder(mem)
+	defer idb.Release()
+	nameb := array.NewStringBuilder(mem)
+	defer nameb.Release()
+	idb.AppendValues([]int64{1, 2}, nil)
+	nameb.AppendValues([]string{"alpha", "beta"}, nil)
+	ids := idb.NewArray()
+	defer ids.Release()
+	names := nameb.NewArray()
+	defer names.Release()
+	rec := array.NewRecord(schema, []arrow.Array{ids, names}, 2)
+	defer rec.Release()
+
+	if err := lb.Write(ctx, rec, lockbox.WithPassword(password)); err != nil {
+		return fmt.Errorf("write: %w", err)
+	}
+	if err := lb.Validate(); err != nil {
+		return fmt.Errorf("validate: %w", err)
+	}
+
+	result, err := lb.Query(ctx, "SELECT name FROM data WHERE id = 2", lockbox.WithPassword(password))
+	if err != nil {
+		return fmt.Errorf("query: %w", err)
+	}
+	defer result.Release()
+	col, ok := result.Column(0).(*array.String)
+	if result.NumRows() != 1 || !ok || col.Value(0) != "beta" {
+		return fmt.Errorf("query returned unexpected data")
+	}
+	return nil
+}
+
+func init() {
+	rootCmd.AddCommand(doctorCmd)
+
+	doctorCmd.Flags().Bool("round-trip", false, "Also create, write and query a temporary lockbox")
+	doctorCmd.Flags().StringP("output", "o", "table", "Output format (table, json)")
+}
@@ -0,0 +1,10 @@
+//go:build !(linux || darwin || freebsd || netbsd || openbsd)
+
+package cmd
+
+import "os"
+
+// tryLock reports that lock checks are not supported on this platform
+func tryLock(f *os.File) (bool, error) {
+	return false, nil
+}
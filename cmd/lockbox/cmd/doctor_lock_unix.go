@@ -0,0 +1,16 @@
+//go:build linux || darwin || freebsd || netbsd || openbsd
+
+package cmd
+
+import (
+	"os"
+	"syscall"
+)
+
+// tryLock takes and releases an exclusive advisory lock on f
+func tryLock(f *os.File) (bool, error) {
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		return true, err
+	}
+	return true, syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+}
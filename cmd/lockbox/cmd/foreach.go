@@ -0,0 +1,221 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"syscall"
+
+	"github.com/TFMV/lockbox/pkg/lockbox"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+var foreachCmd = &cobra.Command{
+	Use:   "foreach [pattern...] -- [validate|rotate-key|compact|info] [flags]",
+	Short: "Apply an operation to many lockbox files",
+	Long: `Apply one operation to every lockbox file matching the patterns, on a pool
+of --jobs workers. Besides the usual wildcards, a "**" path element matches
+any number of directories; quote the patterns so the shell doesn't expand
+them.
+
+The operation and its flags follow "--":
+  validate                          check the integrity of every block
+  rotate-key [--column c] [--lazy]  re-encrypt columns under new keys,
+                                    all columns unless --column is given
+  compact                           re-encrypt blocks left on old keys
+  info [--no-decrypt]               summarize the file
+Add --json to any of them to print the results as JSON.
+
+All files are opened with the same password. A failure for one file does
+not stop the others; a summary follows the results and the command fails
+if any file failed.
+
+Examples:
+  lockbox foreach 'data/**/*.lbx' -- validate
+  lockbox foreach 'data/**/*.lbx' --jobs 4 -- rotate-key --column ssn
+  lockbox foreach '/srv/a/*.lbx' '/srv/b/*.lbx' -- info --no-decrypt --json`,
+	Args: cobra.MinimumNArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		jobs, _ := cmd.Flags().GetInt("jobs")
+		dash := cmd.ArgsLenAtDash()
+		if dash < 1 || dash == len(args) {
+			return fmt.Errorf("expected patterns, then -- and an operation")
+		}
+		op, err := parseForeachOp(args[dash], args[dash+1:])
+		if err != nil {
+			return err
+		}
+
+		files, err := lockbox.GlobFiles(args[:dash]...)
+		if err != nil {
+			return err
+		}
+		if len(files) == 0 {
+			return fmt.Errorf("no files match %s", strings.Join(args[:dash], " "))
+		}
+
+		if op.needsPassword() {
+			if op.password, err = readPassword(cmd); err != nil {
+				return err
+			}
+		}
+
+		ctx, stop := signal.NotifyContext(cmd.Context(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		results := lockbox.ForEachFile(ctx, files, jobs, op.run)
+
+		failed := 0
+		for _, r := range results {
+			if r.Error != "" {
+				failed++
+			}
+		}
+
+		if op.json {
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "  ")
+			if err := enc.Encode(map[string]interface{}{
+				"operation": op.name,
+				"files":     results,
+				"succeeded": len(results) - failed,
+				"failed":    failed,
+			}); err != nil {
+				return err
+			}
+		} else {
+			for _, r := range results {
+				if r.Error != "" {
+					fmt.Printf("FAIL  %s: %s\n", r.File, r.Error)
+					continue
+				}
+				fmt.Printf("ok    %s: %s\n", r.File, describeForeachResult(r.Result))
+			}
+			fmt.Printf("\n%s: %d files, %d succeeded, %d failed\n", op.name, len(results), len(results)-failed, failed)
+		}
+
+		if failed > 0 {
+			return fmt.Errorf("%s failed for %d of %d files", op.name, failed, len(results))
+		}
+		return nil
+	},
+}
+
+// foreachOp is an operation applied by foreach, with its flags
+type foreachOp struct {
+	name      string
+	json      bool
+	columns   []string
+	lazy      bool
+	noDecrypt bool
+	password  string
+}
+
+// compactResult is the result of the compact operation
+type compactResult struct {
+	ReencryptedBlocks int `json:"reencryptedBlocks"`
+}
+
+func parseForeachOp(name string, args []string) (*foreachOp, error) {
+	op := &foreachOp{name: name}
+	flags := pflag.NewFlagSet(name, pflag.ContinueOnError)
+	flags.BoolVar(&op.json, "json", false, "Print the results as JSON")
+	switch name {
+	case "validate", "compact":
+	case "rotate-key":
+		flags.StringSliceVar(&op.columns, "column", nil, "Columns to rotate (default all)")
+		flags.BoolVar(&op.lazy, "lazy", false, "Only use the new keys for new writes")
+	case "info":
+		flags.BoolVar(&op.noDecrypt, "no-decrypt", false, "Show only cleartext metadata, without a password")
+	default:
+		return nil, fmt.Errorf("unknown operation %q, expected validate, rotate-key, compact or info", name)
+	}
+	if err := flags.Parse(args); err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	if flags.NArg() > 0 {
+		return nil, fmt.Errorf("%s: unexpected arguments %s", name, strings.Join(flags.Args(), " "))
+	}
+	return op, nil
+}
+
+func (op *foreachOp) needsPassword() bool {
+	return !(op.name == "info" && op.noDecrypt)
+}
+
+// run applies the operation to one file
+func (op *foreachOp) run(ctx context.Context, path string) (any, error) {
+	if op.name == "info" && op.noDecrypt {
+		return lockbox.ReadInfo(path)
+	}
+
+	lb, err := lockbox.Open(path, lockbox.WithPassword(op.password))
+	if err != nil {
+		return nil, fmt.Errorf("failed to open lockbox: %w", err)
+	}
+	defer lb.Close()
+
+	switch op.name {
+	case "validate":
+		return nil, lb.Validate()
+	case "rotate-key":
+		columns := op.columns
+		if len(columns) == 0 {
+			for _, f := range lb.Schema().Fields() {
+				columns = append(columns, f.Name)
+			}
+		}
+		opts := []lockbox.Option{lockbox.WithPassword(op.password)}
+		if op.lazy {
+			opts = append(opts, lockbox.WithLazyRotation())
+		}
+		epochs := make(map[string]int, len(columns))
+		for _, c := range columns {
+			epoch, err := lb.RotateColumnKey(c, opts...)
+			if err != nil {
+				return epochs, fmt.Errorf("column %s: %w", c, err)
+			}
+			epochs[c] = epoch
+		}
+		return epochs, nil
+	case "compact":
+		n, err := lb.CompactKeys(ctx, lockbox.WithPassword(op.password))
+		return compactResult{ReencryptedBlocks: n}, err
+	default:
+		return lb.Info()
+	}
+}
+
+// describeForeachResult summarizes the result of an operation on one line
+func describeForeachResult(result any) string {
+	switch r := result.(type) {
+	case map[string]int:
+		names := make([]string, 0, len(r))
+		for c := range r {
+			names = append(names, c)
+		}
+		sort.Strings(names)
+		parts := make([]string, len(names))
+		for i, c := range names {
+			parts[i] = fmt.Sprintf("%s (key version %d)", c, r[c])
+		}
+		return "rotated " + strings.Join(parts, ", ")
+	case compactResult:
+		return fmt.Sprintf("re-encrypted %d blocks", r.ReencryptedBlocks)
+	case *lockbox.Info:
+		return fmt.Sprintf("%d rows, %d blocks, %d bytes, module %s", r.RowCount, r.BlockCount, r.FileSize, r.Module)
+	default:
+		return "valid"
+	}
+}
+
+func init() {
+	rootCmd.AddCommand(foreachCmd)
+
+	foreachCmd.Flags().Int("jobs", 0, "Files processed in parallel (default one per CPU)")
+	addPasswordFlags(foreachCmd.Flags(), "Password for the lockboxes")
+}
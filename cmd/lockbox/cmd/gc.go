@@ -0,0 +1,88 @@
+package cmd
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/TFMV/lockbox/pkg/lockbox"
+	"github.com/spf13/cobra"
+)
+
+var gcCmd = &cobra.Command{
+	Use:   "gc [lockbox-file...]",
+	Short: "Expire old snapshots and reclaim unused space",
+	Long: `Expire old snapshots and rewrite the file without the regions nothing
+refers to any more: blocks replaced by key compaction or view refreshes and
+earlier copies of the metadata.
+
+A snapshot expires when it is neither among the --keep-last newest nor
+younger than --keep-days. The newest snapshot is always kept. Use --dry-run
+to see what would be expired and reclaimed.
+
+Example:
+  lockbox gc data.lbx --keep-last 10 --keep-days 30 --dry-run`,
+	Args: cobra.MinimumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		keepLast, _ := cmd.Flags().GetInt("keep-last")
+		keepDays, _ := cmd.Flags().GetInt("keep-days")
+		dryRun, _ := cmd.Flags().GetBool("dry-run")
+		if keepLast < 1 || keepDays < 0 {
+			return fmt.Errorf("--keep-last must be at least 1 and --keep-days not negative")
+		}
+
+		password, err := readPassword(cmd)
+		if err != nil {
+			return err
+		}
+
+		ctx := cmd.Context()
+		for _, path := range args {
+			lb, err := lockbox.Open(path, lockbox.WithPassword(password))
+			if err != nil {
+				return fmt.Errorf("failed to open lockbox %s: %w", path, err)
+			}
+			report, err := lb.GC(ctx,
+				lockbox.WithKeepLast(keepLast),
+				lockbox.WithKeepFor(time.Duration(keepDays)*24*time.Hour),
+				lockbox.WithDryRun(dryRun),
+			)
+			lb.Close()
+			if err != nil {
+				return fmt.Errorf("failed to collect garbage in %s: %w", path, err)
+			}
+			printGCReport(report)
+		}
+		return nil
+	},
+}
+
+func printGCReport(r *lockbox.GCReport) {
+	verb := "Expired"
+	reclaim := "Reclaimed"
+	if r.DryRun {
+		verb = "Would expire"
+		reclaim = "Would reclaim"
+	}
+	fmt.Printf("%s\n", r.File)
+	if len(r.ExpiredSnapshots) > 0 {
+		ids := make([]string, len(r.ExpiredSnapshots))
+		for i, id := range r.ExpiredSnapshots {
+			ids[i] = fmt.Sprint(id)
+		}
+		fmt.Printf("  %s %d snapshots (%s), keeping %d\n", verb, len(ids), strings.Join(ids, ", "), r.KeptSnapshots)
+	} else {
+		fmt.Printf("  No snapshots to expire, keeping %d\n", r.KeptSnapshots)
+	}
+	fmt.Printf("  %s %d bytes; file size %d bytes\n", reclaim, r.ReclaimedBytes, r.FileSize)
+}
+
+func init() {
+	rootCmd.AddCommand(gcCmd)
+
+	addPasswordFlags(gcCmd.Flags(), "Password for the lockboxes")
+	gcCmd.Flags().Int("keep-last", 10, "Always keep this many of the newest snapshots")
+	gcCmd.Flags().Int("keep-days", 30, "Keep snapshots committed within this many days")
+	gcCmd.Flags().Bool("dry-run", false, "Report what would be done without changing the files")
+	addProfileFlags(gcCmd)
+}
@@ -0,0 +1,68 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/TFMV/lockbox/pkg/lockbox"
+	"github.com/spf13/cobra"
+)
+
+var headCmd = &cobra.Command{
+	Use:   "head [lockbox-file]",
+	Short: "Show the first rows, or a random sample, of a lockbox file",
+	Long: `Show the first rows of a lockbox file.
+
+With --sample, a uniform random sample of that many rows is shown instead,
+in file order. Use it to get a representative look at a large file: only
+the row groups holding sampled rows are decrypted.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		filename := args[0]
+
+		lines, _ := cmd.Flags().GetInt("lines")
+		sample, _ := cmd.Flags().GetInt("sample")
+		output, _ := cmd.Flags().GetString("output")
+		principal, _ := cmd.Flags().GetString("principal")
+
+		sqlQuery := fmt.Sprintf("SELECT * FROM data LIMIT %d", lines)
+		if sample > 0 {
+			sqlQuery = fmt.Sprintf("SELECT * FROM data TABLESAMPLE %d ROWS", sample)
+		}
+
+		password, err := readPassword(cmd)
+		if err != nil {
+			return err
+		}
+
+		lb, err := lockbox.Open(filename, lockbox.WithPassword(password))
+		if err != nil {
+			return fmt.Errorf("failed to open lockbox: %w", err)
+		}
+		defer lb.Close()
+
+		result, err := lb.Query(cmd.Context(), sqlQuery, lockbox.WithPassword(password), lockbox.WithPrincipal(principal))
+		if err != nil {
+			return fmt.Errorf("failed to read rows: %w", err)
+		}
+		defer result.Release()
+
+		switch output {
+		case "json":
+			return outputJSON(result)
+		case "csv":
+			return outputCSV(result)
+		default:
+			return outputTable(result)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(headCmd)
+
+	headCmd.Flags().IntP("lines", "n", 10, "Number of rows to show")
+	headCmd.Flags().Int("sample", 0, "Show a random sample of this many rows instead")
+	headCmd.Flags().StringP("output", "o", "table", "Output format (table, json, csv)")
+	headCmd.Flags().String("principal", "", "User or role the access policy is evaluated for")
+	addPasswordFlags(headCmd.Flags(), "Password for decryption")
+}
@@ -0,0 +1,154 @@
+package cmd
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/TFMV/lockbox/pkg/lockbox"
+	"github.com/TFMV/lockbox/pkg/metadata"
+	"github.com/spf13/cobra"
+)
+
+var hookCmd = &cobra.Command{
+	Use:   "hook",
+	Short: "Manage write hooks and notifications",
+	Long: `Manage hooks that validate or transform records on every write, and
+webhook or exec hooks notified after commits, compactions, key rotations
+and policy denials.
+
+Notify hooks receive a JSON document with the event, the file, the newest
+snapshot id and event stats: webhooks as a POST body, exec hooks on their
+standard input, with LOCKBOX_EVENT and LOCKBOX_FILE set. Delivery is best
+effort; a failing hook is logged and does not undo the event.
+
+Examples:
+  lockbox hook add data.lbx positive --kind validate --expr "amount >= 0"
+  lockbox hook add data.lbx mask_ssn --kind transform --column ssn --expr "sha256(ssn)"
+  lockbox hook add data.lbx pipeline --kind webhook --url https://ci.example.com/hooks/lbx --events commit
+  lockbox hook add data.lbx sync --kind exec --exec "./sync.sh --incremental" --events commit,compact`,
+}
+
+var hookAddCmd = &cobra.Command{
+	Use:   "add [lockbox-file] [name]",
+	Short: "Add a write hook",
+	Args:  cobra.ExactArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		password, err := readPassword(cmd)
+		if err != nil {
+			return err
+		}
+
+		h := metadata.Hook{Name: args[1]}
+		h.Stage, _ = cmd.Flags().GetString("stage")
+		h.Kind, _ = cmd.Flags().GetString("kind")
+		h.Expression, _ = cmd.Flags().GetString("expr")
+		h.Column, _ = cmd.Flags().GetString("column")
+		h.Action, _ = cmd.Flags().GetString("action")
+		h.Plugin, _ = cmd.Flags().GetString("plugin")
+		h.URL, _ = cmd.Flags().GetString("url")
+		h.Events, _ = cmd.Flags().GetStringSlice("events")
+		command, _ := cmd.Flags().GetString("exec")
+		h.Command = strings.Fields(command)
+
+		lb, err := lockbox.Open(args[0], lockbox.WithPassword(password))
+		if err != nil {
+			return fmt.Errorf("failed to open lockbox: %w", err)
+		}
+		defer lb.Close()
+
+		if err := lb.AddHook(h); err != nil {
+			return fmt.Errorf("failed to add hook: %w", err)
+		}
+
+		fmt.Printf("Added %s hook %s\n", h.Kind, args[1])
+		return nil
+	},
+}
+
+var hookListCmd = &cobra.Command{
+	Use:   "list [lockbox-file]",
+	Short: "List write hooks",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		password, err := readPassword(cmd)
+		if err != nil {
+			return err
+		}
+
+		lb, err := lockbox.Open(args[0], lockbox.WithPassword(password))
+		if err != nil {
+			return fmt.Errorf("failed to open lockbox: %w", err)
+		}
+		defer lb.Close()
+
+		hooks := lb.Hooks()
+		if len(hooks) == 0 {
+			fmt.Println("No hooks configured")
+			return nil
+		}
+		for _, h := range hooks {
+			detail := h.Expression
+			switch h.Kind {
+			case metadata.HookTransform:
+				detail = h.Column + " = " + h.Expression
+			case metadata.HookPlugin:
+				detail = h.Plugin
+			case metadata.HookWebhook:
+				detail = h.URL
+			case metadata.HookExec:
+				detail = strings.Join(h.Command, " ")
+			}
+			if h.Stage == metadata.HookNotify {
+				events := "all events"
+				if len(h.Events) > 0 {
+					events = strings.Join(h.Events, ",")
+				}
+				detail += " on " + events
+			}
+			fmt.Printf("%s\t%s\t%s\t%s\n", h.Name, h.Stage, h.Kind, detail)
+		}
+		return nil
+	},
+}
+
+var hookRemoveCmd = &cobra.Command{
+	Use:   "remove [lockbox-file] [name]",
+	Short: "Remove a write hook",
+	Args:  cobra.ExactArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		password, err := readPassword(cmd)
+		if err != nil {
+			return err
+		}
+
+		lb, err := lockbox.Open(args[0], lockbox.WithPassword(password))
+		if err != nil {
+			return fmt.Errorf("failed to open lockbox: %w", err)
+		}
+		defer lb.Close()
+
+		if err := lb.RemoveHook(args[1]); err != nil {
+			return err
+		}
+
+		fmt.Printf("Removed hook %s\n", args[1])
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(hookCmd)
+	hookCmd.AddCommand(hookAddCmd, hookListCmd, hookRemoveCmd)
+
+	addPasswordFlags(hookCmd.PersistentFlags(), "Password for the lockbox")
+
+	hookAddCmd.Flags().String("stage", "", "When the hook runs (pre-write or post-write; default pre-write, or notify for webhook and exec hooks)")
+	hookAddCmd.Flags().String("kind", metadata.HookValidate, "Hook kind (validate, transform, plugin, webhook or exec)")
+	hookAddCmd.Flags().String("expr", "", "Expression evaluated for each row")
+	hookAddCmd.Flags().String("column", "", "Column replaced by a transform hook")
+	hookAddCmd.Flags().String("action", "reject", "What a validate hook does with failing rows (reject or drop)")
+	hookAddCmd.Flags().String("plugin", "", "Name of a registered Go hook for plugin hooks")
+	hookAddCmd.Flags().String("url", "", "URL a webhook hook POSTs events to")
+	hookAddCmd.Flags().String("exec", "", "Command an exec hook runs, with the event on standard input")
+	hookAddCmd.Flags().StringSlice("events", nil, "Events a notify hook fires on (commit, compact, rotate, policy-denied; default all)")
+}
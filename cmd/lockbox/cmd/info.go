@@ -1,13 +1,13 @@
 package cmd
 
 import (
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
-	"syscall"
+	"strings"
 
 	"github.com/TFMV/lockbox/pkg/lockbox"
 	"github.com/spf13/cobra"
-	"golang.org/x/term"
 )
 
 var infoCmd = &cobra.Command{
@@ -18,36 +18,43 @@ var infoCmd = &cobra.Command{
 - Metadata details
 - Creation and modification timestamps
 - Block information
-- Access audit logs`,
+- Access audit logs
+
+With --no-decrypt only the metadata stored in the clear is read, so no
+password is needed.`,
 	Args: cobra.ExactArgs(1),
 	RunE: func(cmd *cobra.Command, args []string) error {
 		filename := args[0]
 
-		password, _ := cmd.Flags().GetString("password")
 		outputFormat, _ := cmd.Flags().GetString("output")
+		noDecrypt, _ := cmd.Flags().GetBool("no-decrypt")
 
-		// Get password if not provided
-		if password == "" {
-			fmt.Print("Enter password: ")
-			passwordBytes, err := term.ReadPassword(int(syscall.Stdin))
+		var info *lockbox.Info
+		if noDecrypt {
+			// Only cleartext metadata is read; no password is needed
+			var err error
+			info, err = lockbox.ReadInfo(filename)
 			if err != nil {
-				return fmt.Errorf("failed to read password: %w", err)
+				return fmt.Errorf("failed to get file info: %w", err)
+			}
+		} else {
+			password, err := readPassword(cmd)
+			if err != nil {
+				return err
 			}
-			password = string(passwordBytes)
-			fmt.Println() // New line after password input
-		}
 
-		// Open the lockbox
-		lb, err := lockbox.Open(filename, lockbox.WithPassword(password))
-		if err != nil {
-			return fmt.Errorf("failed to open lockbox: %w", err)
-		}
-		defer lb.Close()
+			// Open the lockbox
+			lb, err := lockbox.Open(filename, lockbox.WithPassword(password))
+			if err != nil {
+				return fmt.Errorf("failed to open lockbox: %w", err)
+			}
+			defer lb.Close()
 
-		// Get file info
-		info, err := lb.Info()
-		if err != nil {
-			return fmt.Errorf("failed to get file info: %w", err)
+			// Get file info
+			info, err = lb.Info()
+			if err != nil {
+				return fmt.Errorf("failed to get file info: %w", err)
+			}
 		}
 
 		// Display information
@@ -63,8 +70,9 @@ var infoCmd = &cobra.Command{
 func init() {
 	rootCmd.AddCommand(infoCmd)
 
-	infoCmd.Flags().StringP("password", "p", "", "Password for decryption")
+	addPasswordFlags(infoCmd.Flags(), "Password for decryption")
 	infoCmd.Flags().StringP("output", "o", "table", "Output format (table, json)")
+	infoCmd.Flags().Bool("no-decrypt", false, "Show only cleartext metadata, without a password")
 }
 
 func displayInfoTable(info *lockbox.Info, filename string) error {
@@ -78,7 +86,73 @@ func displayInfoTable(info *lockbox.Info, filename string) error {
 	fmt.Printf("Modified By: %s\n", info.ModifiedBy)
 	fmt.Printf("Modified At: %v\n", info.ModifiedAt)
 	fmt.Printf("Block Count: %d\n", info.BlockCount)
+	fmt.Printf("Row Count: %d\n", info.RowCount)
+	if info.DeletedRows > 0 {
+		fmt.Printf("Deleted Rows: %d (run compact to remove)\n", info.DeletedRows)
+	}
+	fmt.Printf("File Size: %d bytes\n", info.FileSize)
 	fmt.Printf("Access Count: %d\n", info.AccessCount)
+	fmt.Printf("Crypto Module: %s\n", info.Module)
+	if info.KeyDerivation != "" {
+		fmt.Printf("Key Derivation: %s\n", info.KeyDerivation)
+	}
+	if info.BlockSignatures != "" {
+		fmt.Printf("Block Signatures: %s\n", info.BlockSignatures)
+	}
+	if len(info.SigningKey) > 0 {
+		fmt.Printf("Signing Key: %x\n", info.SigningKey)
+	}
+	for _, s := range info.Sequences {
+		fmt.Printf("Auto-increment: %s (last %d)\n", s.Column, s.Last)
+	}
+	if k := info.SyntheticKey; k != nil {
+		fmt.Printf("Synthetic Key: %s (%s)\n", k.Column, k.Kind)
+	}
+	if info.EncryptedMetadata {
+		fmt.Printf("Metadata: encrypted\n")
+	}
+	if r := info.Replica; r != nil {
+		fmt.Printf("Replica: read-only, snapshot %d of %s, exported %s\n", r.Snapshot, r.Source, r.ExportedAt.Format("2006-01-02 15:04:05"))
+	}
+	if r := info.SchemaRef; r != nil {
+		fmt.Printf("Schema Registry: subject %s version %d (%s, id %d) at %s\n", r.Subject, r.Version, r.Type, r.ID, r.Registry)
+	}
+	if info.Preset != "" {
+		fmt.Printf("Profile: %s\n", info.Preset)
+	}
+	if len(info.Codecs) > 0 {
+		fmt.Printf("Codecs: %s\n", strings.Join(info.Codecs, ", "))
+	}
+	if len(info.ColumnGroups) > 0 {
+		var groups []string
+		for _, g := range info.ColumnGroups {
+			groups = append(groups, fmt.Sprintf("%s (%s)", g.Name, strings.Join(g.Columns, ", ")))
+		}
+		fmt.Printf("Column Groups: %s\n", strings.Join(groups, "; "))
+	}
+	if info.StaleBlocks > 0 {
+		fmt.Printf("Blocks Awaiting Re-encryption: %d\n", info.StaleBlocks)
+	}
+	if len(info.AccessKeys) > 0 {
+		var names []string
+		for _, g := range info.AccessKeys {
+			names = append(names, fmt.Sprintf("%s (%s)", g.Name, strings.Join(g.Columns, ", ")))
+		}
+		fmt.Printf("Access Keys: %s\n", strings.Join(names, "; "))
+	}
+	if info.Description != "" {
+		fmt.Printf("Description: %s\n", info.Description)
+	}
+	if len(info.Tags) > 0 {
+		fmt.Printf("Tags: %s\n", strings.Join(formatTags(info.Tags), ", "))
+	}
+	if c := info.LastCommit; c != nil {
+		fmt.Printf("Last Commit: #%d by %s at %s", c.ID, c.Principal, c.Timestamp.Format("2006-01-02 15:04:05"))
+		if c.Message != "" {
+			fmt.Printf(": %s", c.Message)
+		}
+		fmt.Println()
+	}
 
 	fmt.Printf("\nSchema Information\n")
 	fmt.Printf("------------------\n")
@@ -90,12 +164,35 @@ func displayInfoTable(info *lockbox.Info, filename string) error {
 			if field.Nullable {
 				nullable = " (nullable)"
 			}
-			fmt.Printf("  %d. %s: %s%s\n", i+1, field.Name, field.Type, nullable)
+			epoch := ""
+			if e := info.KeyEpochs[field.Name]; e > 0 {
+				epoch = fmt.Sprintf(" [key version %d]", e)
+			}
+			fmt.Printf("  %d. %s: %s%s%s\n", i+1, field.Name, field.Type, nullable, epoch)
 		}
 	} else {
 		fmt.Printf("Schema: Not available\n")
 	}
 
+	if len(info.Columns) > 0 && info.Schema != nil {
+		fmt.Printf("\nColumn Statistics\n")
+		fmt.Printf("-----------------\n")
+		for _, field := range info.Schema.Fields() {
+			c := info.Columns[field.Name]
+			if c == nil {
+				continue
+			}
+			fmt.Printf("  %s: %d rows, %d nulls, %d bytes", field.Name, c.Rows, c.Nulls, c.Bytes)
+			if c.Min != "" || c.Max != "" {
+				fmt.Printf(", range %s .. %s", c.Min, c.Max)
+			}
+			if c.Partial {
+				fmt.Printf(" (partial)")
+			}
+			fmt.Println()
+		}
+	}
+
 	return nil
 }
 
@@ -125,11 +222,53 @@ func displayInfoJSON(info *lockbox.Info) error {
 		"modifiedBy":  info.ModifiedBy,
 		"modifiedAt":  info.ModifiedAt,
 		"blockCount":  info.BlockCount,
+		"rowCount":    info.RowCount,
+		"deletedRows": info.DeletedRows,
+		"fileSize":    info.FileSize,
 		"accessCount": info.AccessCount,
+		"module":      info.Module,
+		"codecs":      info.Codecs,
+		"keyEpochs":   info.KeyEpochs,
+		"staleBlocks": info.StaleBlocks,
+		"description": info.Description,
+		"tags":        info.Tags,
+		"lastCommit":  info.LastCommit,
+		"preset":      info.Preset,
+		"accessKeys":  info.AccessKeys,
 		"schema": map[string]interface{}{
 			"fields": fields,
 		},
 	}
+	if len(info.ColumnGroups) > 0 {
+		output["columnGroups"] = info.ColumnGroups
+	}
+	if info.EncryptedMetadata {
+		output["encryptedMetadata"] = true
+	}
+	if info.KeyDerivation != "" {
+		output["keyDerivation"] = info.KeyDerivation
+	}
+	if info.BlockSignatures != "" {
+		output["blockSignatures"] = info.BlockSignatures
+	}
+	if len(info.SigningKey) > 0 {
+		output["signingKey"] = hex.EncodeToString(info.SigningKey)
+	}
+	if len(info.Sequences) > 0 {
+		output["sequences"] = info.Sequences
+	}
+	if info.SyntheticKey != nil {
+		output["syntheticKey"] = info.SyntheticKey
+	}
+	if len(info.Columns) > 0 {
+		output["columns"] = info.Columns
+	}
+	if info.Replica != nil {
+		output["replica"] = info.Replica
+	}
+	if info.SchemaRef != nil {
+		output["schemaRef"] = info.SchemaRef
+	}
 
 	jsonData, err := json.MarshalIndent(output, "", "  ")
 	if err != nil {
@@ -0,0 +1,376 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/TFMV/lockbox/pkg/lockbox"
+	"github.com/TFMV/lockbox/pkg/metadata"
+	"github.com/spf13/cobra"
+)
+
+var ingestCmd = &cobra.Command{
+	Use:   "ingest",
+	Short: "Stream large input files into a lockbox",
+}
+
+var ingestCSVCmd = &cobra.Command{
+	Use:   "csv [lockbox-file] [csv-file]",
+	Short: "Stream a CSV file into a lockbox in batches",
+	Long: `Read a CSV file in batches of --batch-size rows, convert each batch to the
+schema of the lockbox and commit it, so files of any size load in bounded
+memory; "write --input" reads the whole file into memory first. Progress is
+reported on stderr after each batch.
+
+Fields are matched to columns and converted as by "write": by position or
+with --map, in the dialect detected or given with the CSV flags. Rows that
+do not fit abort the ingestion unless --on-error skips or quarantines them.
+Each batch is its own commit; when one fails, the batches before it stay
+committed. --dry-run converts every row without writing.
+
+Lockboxes created from a schema registry subject are checked first against
+the subject's latest version, fetched from the registry recorded in the
+file or --registry, and the version is recorded in the lineage of the
+commits. --no-registry-check skips the check.
+
+Fields are matched by position unless --drift is given, which matches them
+by header name, so reordered files load as they are, and compares the
+file's columns and inferred types with the lockbox's. New columns and type
+changes are then handled per mode: fail refuses the file, add-columns adds
+new columns to the lockbox (changed types are still refused), and coerce
+leaves new columns out and stores values that no longer convert as null.
+Columns the file lacks are null. The drift is reported on stderr.
+
+Examples:
+  lockbox ingest csv sales.lbx sales.csv --batch-size 100000
+  lockbox ingest csv sales.lbx sales.csv --dry-run --on-error skip
+  lockbox ingest csv sales.lbx sales-v2.csv --drift add-columns`,
+	Args: cobra.ExactArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		batchSize, _ := cmd.Flags().GetInt("batch-size")
+		codecName, _ := cmd.Flags().GetString("codec")
+		message, _ := cmd.Flags().GetString("message")
+		transform, _ := cmd.Flags().GetString("transform")
+		onError, _ := cmd.Flags().GetString("on-error")
+		quarantinePath, _ := cmd.Flags().GetString("quarantine")
+		mapPath, _ := cmd.Flags().GetString("map")
+		intern, _ := cmd.Flags().GetBool("intern")
+		dictionary, _ := cmd.Flags().GetStringSlice("dictionary")
+		dryRun, _ := cmd.Flags().GetBool("dry-run")
+		quiet, _ := cmd.Flags().GetBool("quiet")
+		asJSON, _ := cmd.Flags().GetBool("json")
+		driftMode, _ := cmd.Flags().GetString("drift")
+		if batchSize <= 0 {
+			return fmt.Errorf("--batch-size must be positive")
+		}
+
+		policy, err := lockbox.ParseErrorPolicy(onError)
+		if err != nil {
+			return err
+		}
+		if policy == lockbox.OnErrorQuarantine && quarantinePath == "" {
+			return fmt.Errorf("--on-error quarantine requires --quarantine")
+		}
+		rejects := &lockbox.Rejects{}
+
+		opts, err := csvOptions(cmd)
+		if err != nil {
+			return err
+		}
+		if mapPath != "" {
+			mapping, err := lockbox.LoadColumnMapping(mapPath)
+			if err != nil {
+				return err
+			}
+			opts = append(opts, lockbox.WithColumnMapping(mapping))
+		}
+		if intern {
+			opts = append(opts, lockbox.WithInternStrings())
+		}
+
+		password, err := readPassword(cmd)
+		if err != nil {
+			return err
+		}
+		lb, err := lockbox.Open(args[0], lockbox.WithPassword(password))
+		if err != nil {
+			return fmt.Errorf("failed to open lockbox: %w", err)
+		}
+		defer lb.Close()
+
+		opts = append(opts,
+			lockbox.WithPassword(password),
+			lockbox.WithBatchSize(batchSize),
+			lockbox.WithCodec(codecName),
+			lockbox.WithDictionary(dictionary...),
+			lockbox.WithMessage(message),
+			lockbox.WithLineage(metadata.Lineage{Transformation: transform}),
+			lockbox.WithOnError(policy, rejects),
+			lockbox.WithDryRun(dryRun),
+		)
+		if !quiet {
+			opts = append(opts, lockbox.WithProgress(printIngestProgress))
+		}
+		if registry := registryCheck(cmd, lb); registry != nil {
+			opts = append(opts, lockbox.WithSchemaRegistry(*registry))
+		}
+		if driftMode != "" {
+			mode, err := lockbox.ParseDriftMode(driftMode)
+			if err != nil {
+				return err
+			}
+			opts = append(opts, lockbox.WithDrift(mode))
+		}
+
+		res, err := lb.IngestCSV(cmd.Context(), args[1], opts...)
+		if err != nil {
+			if res != nil && res.Batches > 0 && !dryRun {
+				fmt.Fprintf(os.Stderr, "Committed %d rows in %d batches before the failure\n", res.Rows, res.Batches)
+			}
+			return fmt.Errorf("failed to ingest %s: %w", args[1], err)
+		}
+
+		if res.Drift != nil && res.Drift.Drifted() && !asJSON {
+			fmt.Fprintf(os.Stderr, "Schema drift (%s): %s\n", driftMode, res.Drift)
+		}
+		if asJSON {
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "  ")
+			if err := enc.Encode(res); err != nil {
+				return err
+			}
+		} else if dryRun {
+			fmt.Printf("Checked %d rows of %s in %d batches; nothing was written\n", res.Rows, args[1], res.Batches)
+		} else {
+			fmt.Printf("Ingested %d rows from %s into %s in %d batches\n", res.Rows, args[1], args[0], res.Batches)
+		}
+
+		if rejects.Count > 0 {
+			fmt.Fprintln(os.Stderr, rejects.Summary())
+			if policy == lockbox.OnErrorQuarantine && !dryRun {
+				if err := writeQuarantine(cmd.Context(), quarantinePath, rejects, args[1], password); err != nil {
+					return err
+				}
+				fmt.Fprintf(os.Stderr, "Quarantined rejected rows in %s\n", quarantinePath)
+			}
+		}
+		return nil
+	},
+}
+
+var ingestNDJSONCmd = &cobra.Command{
+	Use:   "ndjson [lockbox-file] [ndjson-file]",
+	Short: "Stream an NDJSON file into a lockbox in batches",
+	Long: `Read an NDJSON file, one JSON object per line, in batches of --batch-size
+objects, convert each batch to the schema of the lockbox and commit it, as
+"ingest csv" does for CSV. Fields are matched to columns by name or with
+--map. "lockbox create --infer" creates a lockbox whose schema fits a file.
+
+Lines that are not JSON objects or do not fit the schema do not stop the
+ingestion: they are reported with their line number and reason on stderr,
+and written to --errors as JSON lines. --on-error abort stops at the first
+instead; --on-error skip only counts them. The schema registry subject of
+the lockbox is checked as by "ingest csv", and --drift handles new columns
+and type changes as it does there.
+
+Examples:
+  lockbox create events.lbx --infer events.ndjson
+  lockbox ingest ndjson events.lbx events.ndjson --errors events.errors.ndjson`,
+	Args: cobra.ExactArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		batchSize, _ := cmd.Flags().GetInt("batch-size")
+		codecName, _ := cmd.Flags().GetString("codec")
+		message, _ := cmd.Flags().GetString("message")
+		transform, _ := cmd.Flags().GetString("transform")
+		onError, _ := cmd.Flags().GetString("on-error")
+		errorsPath, _ := cmd.Flags().GetString("errors")
+		mapPath, _ := cmd.Flags().GetString("map")
+		intern, _ := cmd.Flags().GetBool("intern")
+		dictionary, _ := cmd.Flags().GetStringSlice("dictionary")
+		dryRun, _ := cmd.Flags().GetBool("dry-run")
+		quiet, _ := cmd.Flags().GetBool("quiet")
+		asJSON, _ := cmd.Flags().GetBool("json")
+		driftMode, _ := cmd.Flags().GetString("drift")
+		if batchSize <= 0 {
+			return fmt.Errorf("--batch-size must be positive")
+		}
+
+		var opts []lockbox.Option
+		if onError != "" {
+			policy, err := lockbox.ParseErrorPolicy(onError)
+			if err != nil {
+				return err
+			}
+			opts = append(opts, lockbox.WithOnError(policy, &lockbox.Rejects{}))
+		}
+		if mapPath != "" {
+			mapping, err := lockbox.LoadColumnMapping(mapPath)
+			if err != nil {
+				return err
+			}
+			opts = append(opts, lockbox.WithColumnMapping(mapping))
+		}
+		if intern {
+			opts = append(opts, lockbox.WithInternStrings())
+		}
+
+		password, err := readPassword(cmd)
+		if err != nil {
+			return err
+		}
+		lb, err := lockbox.Open(args[0], lockbox.WithPassword(password))
+		if err != nil {
+			return fmt.Errorf("failed to open lockbox: %w", err)
+		}
+		defer lb.Close()
+
+		opts = append(opts,
+			lockbox.WithPassword(password),
+			lockbox.WithBatchSize(batchSize),
+			lockbox.WithCodec(codecName),
+			lockbox.WithDictionary(dictionary...),
+			lockbox.WithMessage(message),
+			lockbox.WithLineage(metadata.Lineage{Transformation: transform}),
+			lockbox.WithDryRun(dryRun),
+		)
+		if !quiet {
+			opts = append(opts, lockbox.WithProgress(printIngestProgress))
+		}
+		if registry := registryCheck(cmd, lb); registry != nil {
+			opts = append(opts, lockbox.WithSchemaRegistry(*registry))
+		}
+		if driftMode != "" {
+			mode, err := lockbox.ParseDriftMode(driftMode)
+			if err != nil {
+				return err
+			}
+			opts = append(opts, lockbox.WithDrift(mode))
+		}
+
+		res, err := lb.IngestNDJSON(cmd.Context(), args[1], opts...)
+		if err != nil {
+			if res != nil && res.Batches > 0 && !dryRun {
+				fmt.Fprintf(os.Stderr, "Committed %d rows in %d batches before the failure\n", res.Rows, res.Batches)
+			}
+			return fmt.Errorf("failed to ingest %s: %w", args[1], err)
+		}
+
+		if res.Drift != nil && res.Drift.Drifted() && !asJSON {
+			fmt.Fprintf(os.Stderr, "Schema drift (%s): %s\n", driftMode, res.Drift)
+		}
+		if asJSON {
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "  ")
+			if err := enc.Encode(res); err != nil {
+				return err
+			}
+		} else if dryRun {
+			fmt.Printf("Checked %d rows of %s in %d batches; nothing was written\n", res.Rows, args[1], res.Batches)
+		} else {
+			fmt.Printf("Ingested %d rows from %s into %s in %d batches\n", res.Rows, args[1], args[0], res.Batches)
+		}
+
+		if res.Rejected > 0 {
+			fmt.Fprintf(os.Stderr, "Rejected lines: %d\n", res.Rejected)
+			for i, e := range res.Errors {
+				if i == maxReportedErrors {
+					fmt.Fprintf(os.Stderr, "  ... and %d more\n", len(res.Errors)-i)
+					break
+				}
+				fmt.Fprintf(os.Stderr, "  line %d: %s\n", e.Line, e.Reason)
+			}
+		}
+		if errorsPath != "" && len(res.Errors) > 0 {
+			if err := writeErrorReport(errorsPath, res.Errors); err != nil {
+				return err
+			}
+			fmt.Fprintf(os.Stderr, "Wrote the rejected rows to %s\n", errorsPath)
+		}
+		return nil
+	},
+}
+
+// maxReportedErrors is how many rejected rows are listed on stderr
+const maxReportedErrors = 10
+
+// writeErrorReport writes rejected rows as JSON lines
+func writeErrorReport(path string, rows []lockbox.RejectedRow) error {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to create error report: %w", err)
+	}
+	enc := json.NewEncoder(f)
+	for _, row := range rows {
+		if err := enc.Encode(row); err != nil {
+			f.Close()
+			return fmt.Errorf("failed to write error report: %w", err)
+		}
+	}
+	return f.Close()
+}
+
+// registryCheck returns the registry to check an ingestion against: that
+// of --registry, or the one recorded in a lockbox created from a registry
+// subject, unless --no-registry-check is set
+func registryCheck(cmd *cobra.Command, lb *lockbox.Lockbox) *lockbox.SchemaRegistry {
+	if skip, _ := cmd.Flags().GetBool("no-registry-check"); skip {
+		return nil
+	}
+	info, err := lb.Info()
+	if err != nil || info.SchemaRef == nil {
+		return nil
+	}
+	url, _ := cmd.Flags().GetString("registry")
+	return &lockbox.SchemaRegistry{URL: url}
+}
+
+// printIngestProgress reports a batch of an ingestion on stderr
+func printIngestProgress(p lockbox.IngestProgress) {
+	if p.Size > 0 {
+		fmt.Fprintf(os.Stderr, "%d rows in %d batches (%.0f%%)\n", p.Rows, p.Batches, 100*float64(p.BytesRead)/float64(p.Size))
+		return
+	}
+	fmt.Fprintf(os.Stderr, "%d rows in %d batches\n", p.Rows, p.Batches)
+}
+
+func init() {
+	rootCmd.AddCommand(ingestCmd)
+	ingestCmd.AddCommand(ingestCSVCmd)
+
+	addPasswordFlags(ingestCSVCmd.Flags(), "Password for encryption")
+	ingestCSVCmd.Flags().Int("batch-size", lockbox.DefaultIngestBatchSize, "Rows converted and committed at a time")
+	ingestCSVCmd.Flags().String("codec", "", "Compression codec for column blocks (e.g. zstd, lz4, gzip); defaults to the file's")
+	ingestCSVCmd.Flags().StringP("message", "m", "", "Commit message recorded with each batch")
+	ingestCSVCmd.Flags().String("transform", "", "Description of how the input was transformed, for lineage")
+	ingestCSVCmd.Flags().String("on-error", "abort", "What to do with rows that do not fit the schema (abort, skip, quarantine)")
+	ingestCSVCmd.Flags().String("quarantine", "", "File for rows rejected with --on-error quarantine (.lbx for a lockbox, CSV otherwise)")
+	ingestCSVCmd.Flags().String("map", "", "YAML or JSON file mapping source columns to lockbox fields, with defaults and transforms")
+	ingestCSVCmd.Flags().Bool("intern", false, "Hold repeated strings once while converting")
+	ingestCSVCmd.Flags().StringSlice("dictionary", nil, "String columns to store dictionary encoded")
+	ingestCSVCmd.Flags().Bool("dry-run", false, "Convert every row without writing")
+	ingestCSVCmd.Flags().Bool("quiet", false, "Do not report progress")
+	ingestCSVCmd.Flags().Bool("json", false, "Print the result as JSON")
+	ingestCSVCmd.Flags().String("registry", "", "Schema registry URL, instead of the one recorded at creation")
+	ingestCSVCmd.Flags().Bool("no-registry-check", false, "Do not check the lockbox's schema registry subject")
+	ingestCSVCmd.Flags().String("drift", "", "Match columns by name and handle new columns and type changes (fail, add-columns, coerce)")
+	addCSVFlags(ingestCSVCmd, false)
+
+	ingestCmd.AddCommand(ingestNDJSONCmd)
+	addPasswordFlags(ingestNDJSONCmd.Flags(), "Password for encryption")
+	ingestNDJSONCmd.Flags().Int("batch-size", lockbox.DefaultIngestBatchSize, "Objects converted and committed at a time")
+	ingestNDJSONCmd.Flags().String("codec", "", "Compression codec for column blocks (e.g. zstd, lz4, gzip); defaults to the file's")
+	ingestNDJSONCmd.Flags().StringP("message", "m", "", "Commit message recorded with each batch")
+	ingestNDJSONCmd.Flags().String("transform", "", "Description of how the input was transformed, for lineage")
+	ingestNDJSONCmd.Flags().String("on-error", "", "What to do with lines that do not fit the schema (abort, skip; default report them)")
+	ingestNDJSONCmd.Flags().String("errors", "", "File to write rejected lines to, as JSON lines with line number and reason")
+	ingestNDJSONCmd.Flags().String("map", "", "YAML or JSON file mapping source fields to lockbox fields, with defaults and transforms")
+	ingestNDJSONCmd.Flags().Bool("intern", false, "Hold repeated strings once while converting")
+	ingestNDJSONCmd.Flags().StringSlice("dictionary", nil, "String columns to store dictionary encoded")
+	ingestNDJSONCmd.Flags().Bool("dry-run", false, "Convert every object without writing")
+	ingestNDJSONCmd.Flags().Bool("quiet", false, "Do not report progress")
+	ingestNDJSONCmd.Flags().Bool("json", false, "Print the result as JSON")
+	ingestNDJSONCmd.Flags().String("registry", "", "Schema registry URL, instead of the one recorded at creation")
+	ingestNDJSONCmd.Flags().Bool("no-registry-check", false, "Do not check the lockbox's schema registry subject")
+	ingestNDJSONCmd.Flags().String("drift", "", "Match columns by name and handle new columns and type changes (fail, add-columns, coerce)")
+}
@@ -0,0 +1,276 @@
+package cmd
+
+import (
+	"fmt"
+	"html"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/TFMV/lockbox/pkg/format"
+	"github.com/TFMV/lockbox/pkg/lockbox"
+	"github.com/spf13/cobra"
+)
+
+var inspectCmd = &cobra.Command{
+	Use:   "inspect [lockbox-file]",
+	Short: "Show how a lockbox file is laid out on disk",
+	Long: `Map a lockbox file from start to end: the header, the data blocks of each
+column and commit, the blocks of materialized views, the current metadata,
+earlier copies of the metadata and dead space such as blocks replaced by key
+compaction. Earlier metadata and dead space are what gc reclaims, so the map
+shows where a file's size comes from and what compacting it would save.
+
+--layout txt prints a map and a table of regions; --layout svg writes an
+SVG image to standard output.
+
+With --no-decrypt no password is needed, except for files whose metadata is
+encrypted.
+
+Example:
+  lockbox inspect data.lbx --layout svg > data.svg`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		filename := args[0]
+		layoutFormat, _ := cmd.Flags().GetString("layout")
+		noDecrypt, _ := cmd.Flags().GetBool("no-decrypt")
+		if layoutFormat != "txt" && layoutFormat != "svg" {
+			return fmt.Errorf("unknown layout format %s (want txt or svg)", layoutFormat)
+		}
+
+		var layout *lockbox.Layout
+		if noDecrypt {
+			var err error
+			layout, err = lockbox.ReadLayout(filename)
+			if err != nil {
+				return err
+			}
+		} else {
+			password, err := readPassword(cmd)
+			if err != nil {
+				return err
+			}
+			lb, err := lockbox.Open(filename, lockbox.WithPassword(password))
+			if err != nil {
+				return fmt.Errorf("failed to open lockbox: %w", err)
+			}
+			defer lb.Close()
+			if layout, err = lb.Layout(); err != nil {
+				return err
+			}
+		}
+
+		if layoutFormat == "svg" {
+			return writeLayoutSVG(os.Stdout, layout)
+		}
+		writeLayoutText(os.Stdout, layout)
+		return nil
+	},
+}
+
+// layoutMapWidth is the number of cells of the text map
+const layoutMapWidth = 64
+
+// layoutKinds are the region kinds in file order of appearance, with the
+// text map symbol and SVG color of each
+var layoutKinds = []struct {
+	kind, label string
+	symbol      byte
+	color       string
+}{
+	{format.RegionHeader, "header", 'H', "#555555"},
+	{format.RegionBlock, "data", '#', "#4e79a7"},
+	{format.RegionView, "view", 'v', "#59a14f"},
+	{format.RegionMetadata, "metadata", 'M', "#f28e2b"},
+	{format.RegionOldMetadata, "earlier metadata", 'm', "#f1ce63"},
+	{format.RegionDead, "dead", '.', "#e15759"},
+}
+
+// columnColors color the data blocks of each column in SVG maps
+var columnColors = []string{"#4e79a7", "#76b7b2", "#9c755f", "#b07aa1", "#86bcb6", "#a0cbe8", "#8cd17d", "#bab0ac"}
+
+func layoutSymbol(kind string) byte {
+	for _, k := range layoutKinds {
+		if k.kind == kind {
+			return k.symbol
+		}
+	}
+	return '?'
+}
+
+// describeRegion names what a region holds
+func describeRegion(r format.Region) string {
+	switch r.Kind {
+	case format.RegionBlock:
+		s := fmt.Sprintf("block %s (row group %d", strings.Join(r.Columns, ", "), r.RowGroup)
+		if r.Commit > 0 {
+			s += fmt.Sprintf(", commit #%d", r.Commit)
+		}
+		return s + ")"
+	case format.RegionView:
+		return "view " + r.View
+	case format.RegionOldMetadata:
+		return "earlier metadata"
+	}
+	return r.Kind
+}
+
+// layoutColumns returns the columns with data blocks in file order, and
+// the bytes and blocks of each
+func layoutColumns(l *lockbox.Layout) (names []string, bytes map[string]int64, blocks map[string]int) {
+	bytes = make(map[string]int64)
+	blocks = make(map[string]int)
+	for _, r := range l.Regions {
+		if r.Kind != format.RegionBlock {
+			continue
+		}
+		name := strings.Join(r.Columns, "+")
+		if _, ok := bytes[name]; !ok {
+			names = append(names, name)
+		}
+		bytes[name] += r.Length
+		blocks[name]++
+	}
+	return names, bytes, blocks
+}
+
+func percent(n, total int64) float64 {
+	if total == 0 {
+		return 0
+	}
+	return 100 * float64(n) / float64(total)
+}
+
+func writeLayoutText(w io.Writer, l *lockbox.Layout) {
+	fmt.Fprintf(w, "%s: %d bytes\n\n", l.File, l.FileSize)
+
+	// Each cell shows the kind of region covering most of its bytes
+	if l.FileSize > 0 {
+		cells := make([]map[byte]int64, layoutMapWidth)
+		for i := range cells {
+			cells[i] = make(map[byte]int64)
+		}
+		for _, r := range l.Regions {
+			for pos := r.Offset; pos < r.Offset+r.Length; {
+				cell := pos * layoutMapWidth / l.FileSize
+				next := min(r.Offset+r.Length, (cell+1)*l.FileSize/layoutMapWidth)
+				if next <= pos {
+					next = pos + 1
+				}
+				cells[cell][layoutSymbol(r.Kind)] += next - pos
+				pos = next
+			}
+		}
+		var bar strings.Builder
+		for _, c := range cells {
+			best, most := byte(' '), int64(0)
+			for _, k := range layoutKinds {
+				if c[k.symbol] > most {
+					best, most = k.symbol, c[k.symbol]
+				}
+			}
+			bar.WriteByte(best)
+		}
+		fmt.Fprintf(w, "[%s]\n", bar.String())
+		var legend []string
+		for _, k := range layoutKinds {
+			legend = append(legend, fmt.Sprintf("%c %s", k.symbol, k.label))
+		}
+		fmt.Fprintf(w, " %s\n\n", strings.Join(legend, "  "))
+	}
+
+	fmt.Fprintf(w, "%-12s %-12s %s\n", "Offset", "Length", "Region")
+	for _, r := range l.Regions {
+		fmt.Fprintf(w, "%-12d %-12d %s\n", r.Offset, r.Length, describeRegion(r))
+	}
+
+	fmt.Fprintf(w, "\nBy kind:\n")
+	for _, k := range layoutKinds {
+		if n := l.Bytes(k.kind); n > 0 {
+			fmt.Fprintf(w, "  %-18s %12d bytes %5.1f%%\n", k.label, n, percent(n, l.FileSize))
+		}
+	}
+	names, bytes, blocks := layoutColumns(l)
+	if len(names) > 0 {
+		fmt.Fprintf(w, "\nBy column:\n")
+		for _, name := range names {
+			fmt.Fprintf(w, "  %-18s %12d bytes in %d blocks\n", name, bytes[name], blocks[name])
+		}
+	}
+	if n := l.Bytes(format.RegionOldMetadata) + l.Bytes(format.RegionDead); n > 0 {
+		fmt.Fprintf(w, "\nlockbox gc would reclaim %d bytes (%.1f%% of the file)\n", n, percent(n, l.FileSize))
+	}
+}
+
+func writeLayoutSVG(w io.Writer, l *lockbox.Layout) error {
+	const (
+		width  = 960.0
+		margin = 20.0
+		barY   = 40.0
+		barH   = 48.0
+	)
+	names, bytes, _ := layoutColumns(l)
+	colors := make(map[string]string, len(names))
+	for i, name := range names {
+		colors[name] = columnColors[i%len(columnColors)]
+	}
+	kindColor := func(r format.Region) string {
+		if r.Kind == format.RegionBlock {
+			return colors[strings.Join(r.Columns, "+")]
+		}
+		for _, k := range layoutKinds {
+			if k.kind == r.Kind {
+				return k.color
+			}
+		}
+		return "#000000"
+	}
+
+	type entry struct{ label, color string }
+	var legend []entry
+	for _, k := range layoutKinds {
+		if k.kind == format.RegionBlock {
+			for _, name := range names {
+				legend = append(legend, entry{fmt.Sprintf("%s (%d bytes)", name, bytes[name]), colors[name]})
+			}
+			continue
+		}
+		if n := l.Bytes(k.kind); n > 0 {
+			legend = append(legend, entry{fmt.Sprintf("%s (%d bytes)", k.label, n), k.color})
+		}
+	}
+	height := barY + barH + 30 + 20*float64(len(legend)) + margin
+
+	var b strings.Builder
+	fmt.Fprintf(&b, `<svg xmlns="http://www.w3.org/2000/svg" width="%.0f" height="%.0f" font-family="sans-serif" font-size="12">`+"\n", width+2*margin, height)
+	fmt.Fprintf(&b, `<text x="%.0f" y="24" font-size="14">%s: %d bytes</text>`+"\n", margin, html.EscapeString(l.File), l.FileSize)
+	scale := 0.0
+	if l.FileSize > 0 {
+		scale = width / float64(l.FileSize)
+	}
+	for _, r := range l.Regions {
+		fmt.Fprintf(&b, `<rect x="%.2f" y="%.0f" width="%.2f" height="%.0f" fill="%s"><title>%s: offset %d, %d bytes</title></rect>`+"\n",
+			margin+float64(r.Offset)*scale, barY, max(float64(r.Length)*scale, 0.5), barH, kindColor(r),
+			html.EscapeString(describeRegion(r)), r.Offset, r.Length)
+	}
+	if n := l.Bytes(format.RegionOldMetadata) + l.Bytes(format.RegionDead); n > 0 {
+		fmt.Fprintf(&b, `<text x="%.0f" y="%.0f">lockbox gc would reclaim %d bytes (%.1f%% of the file)</text>`+"\n",
+			margin, barY+barH+20, n, percent(n, l.FileSize))
+	}
+	for i, e := range legend {
+		y := barY + barH + 30 + 20*float64(i)
+		fmt.Fprintf(&b, `<rect x="%.0f" y="%.0f" width="12" height="12" fill="%s"/>`+"\n", margin, y, e.color)
+		fmt.Fprintf(&b, `<text x="%.0f" y="%.0f">%s</text>`+"\n", margin+18, y+11, html.EscapeString(e.label))
+	}
+	b.WriteString("</svg>\n")
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func init() {
+	rootCmd.AddCommand(inspectCmd)
+
+	addPasswordFlags(inspectCmd.Flags(), "Password for the lockbox")
+	inspectCmd.Flags().String("layout", "txt", "Layout format (txt, svg)")
+	inspectCmd.Flags().Bool("no-decrypt", false, "Read only cleartext metadata, without a password")
+}
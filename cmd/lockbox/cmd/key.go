@@ -0,0 +1,407 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/TFMV/lockbox/pkg/crypto"
+	"github.com/TFMV/lockbox/pkg/lockbox"
+	"github.com/spf13/cobra"
+)
+
+var keyCmd = &cobra.Command{
+	Use:   "key",
+	Short: "Manage encryption keys",
+}
+
+var keyEscrowKeygenCmd = &cobra.Command{
+	Use:   "escrow-keygen [name]",
+	Short: "Generate an escrow key pair",
+	Long: `Generate an escrow key pair and write it to <name>.pub and <name>.key.
+
+Keep the private key offline; the public key is used with "key export".`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		key, err := crypto.GenerateEscrowKey()
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(args[0]+".pub", key.PublicPEM(), 0644); err != nil {
+			return fmt.Errorf("failed to write public key: %w", err)
+		}
+		if err := os.WriteFile(args[0]+".key", key.PrivatePEM(), 0600); err != nil {
+			return fmt.Errorf("failed to write private key: %w", err)
+		}
+		fmt.Printf("Wrote %s.pub and %s.key\n", args[0], args[0])
+		return nil
+	},
+}
+
+var keyExportCmd = &cobra.Command{
+	Use:   "export [lockbox-file]",
+	Short: "Export a column key sealed to an escrow public key",
+	Long: `Export the key of one column, sealed to an escrow public key. A column
+whose key was never rotated shares the file's secret with every other
+column, so it is rotated to a key of its own first, re-encrypting its blocks.
+
+Example:
+  lockbox key export data.lbx --column ssn --wrap-to escrow.pub --out ssn.lbxkey`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		column, _ := cmd.Flags().GetString("column")
+		wrapTo, _ := cmd.Flags().GetString("wrap-to")
+		out, _ := cmd.Flags().GetString("out")
+		if column == "" || wrapTo == "" {
+			return fmt.Errorf("--column and --wrap-to are required")
+		}
+		if out == "" {
+			out = column + ".lbxkey"
+		}
+
+		password, err := readPassword(cmd)
+		if err != nil {
+			return err
+		}
+		pub, err := os.ReadFile(wrapTo)
+		if err != nil {
+			return fmt.Errorf("failed to read escrow public key: %w", err)
+		}
+
+		lb, err := lockbox.Open(args[0], lockbox.WithPassword(password))
+		if err != nil {
+			return fmt.Errorf("failed to open lockbox: %w", err)
+		}
+		defer lb.Close()
+
+		bundle, err := lb.ExportColumnKey(column, pub, lockbox.WithPassword(password))
+		if err != nil {
+			return fmt.Errorf("failed to export key: %w", err)
+		}
+		if err := os.WriteFile(out, bundle, 0600); err != nil {
+			return fmt.Errorf("failed to write key bundle: %w", err)
+		}
+
+		fmt.Printf("Exported key for column %s to %s\n", column, out)
+		return nil
+	},
+}
+
+var keyFieldCmd = &cobra.Command{
+	Use:   "field [lockbox-file]",
+	Short: "Export the field key of a column as a JSON Web Key Set",
+	Long: `Export the field key of a column, for applications that encrypt or hash
+values of the column before they are written, as a JSON Web Key Set with an
+A256GCM encryption key and an HS256 hashing key. Every service given the
+field key of a column encrypts and hashes its values the same way. Field
+keys are derived from the column key but do not decrypt the file; rotating
+the column key changes them.
+
+Example:
+  lockbox key field customers.lbx --column customer_id --out customer_id.jwk`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		column, _ := cmd.Flags().GetString("column")
+		out, _ := cmd.Flags().GetString("out")
+		if column == "" {
+			return fmt.Errorf("--column is required")
+		}
+		if out == "" {
+			out = column + ".jwk"
+		}
+
+		password, err := readPassword(cmd)
+		if err != nil {
+			return err
+		}
+		lb, err := lockbox.Open(args[0], lockbox.WithPassword(password))
+		if err != nil {
+			return fmt.Errorf("failed to open lockbox: %w", err)
+		}
+		defer lb.Close()
+
+		key, err := lb.FieldKey(column, lockbox.WithPassword(password))
+		if err != nil {
+			return fmt.Errorf("failed to export field key: %w", err)
+		}
+		jwk, err := key.JWK()
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(out, append(jwk, '\n'), 0600); err != nil {
+			return fmt.Errorf("failed to write field key: %w", err)
+		}
+
+		fmt.Printf("Exported field key %s to %s\n", key.ID, out)
+		return nil
+	},
+}
+
+var keyImportCmd = &cobra.Command{
+	Use:   "import [lockbox-file]",
+	Short: "Read a column using an escrowed key",
+	Long: `Open an escrow bundle with the escrow private key and read the column it
+unlocks, without the lockbox password.
+
+Example:
+  lockbox key import data.lbx --bundle ssn.lbxkey --escrow-key escrow.key --output-file ssn.parquet`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		bundlePath, _ := cmd.Flags().GetString("bundle")
+		escrowPath, _ := cmd.Flags().GetString("escrow-key")
+		sqlQuery, _ := cmd.Flags().GetString("sql")
+		output, _ := cmd.Flags().GetString("output")
+		outputFile, _ := cmd.Flags().GetString("output-file")
+		if bundlePath == "" || escrowPath == "" {
+			return fmt.Errorf("--bundle and --escrow-key are required")
+		}
+
+		bundle, err := os.ReadFile(bundlePath)
+		if err != nil {
+			return fmt.Errorf("failed to read key bundle: %w", err)
+		}
+		priv, err := os.ReadFile(escrowPath)
+		if err != nil {
+			return fmt.Errorf("failed to read escrow key: %w", err)
+		}
+		ck, err := lockbox.ImportColumnKey(bundle, priv)
+		if err != nil {
+			return fmt.Errorf("failed to import key: %w", err)
+		}
+
+		lb, err := lockbox.Open(args[0], lockbox.WithColumnKey(ck))
+		if err != nil {
+			return fmt.Errorf("failed to open lockbox: %w", err)
+		}
+		defer lb.Close()
+
+		if sqlQuery == "" {
+			sqlQuery = fmt.Sprintf("SELECT %s FROM data", ck.Column)
+		}
+		result, err := lb.Query(cmd.Context(), sqlQuery, lockbox.WithColumnKey(ck))
+		if err != nil {
+			return fmt.Errorf("failed to execute query: %w", err)
+		}
+		defer result.Release()
+
+		csvOpts, err := csvOptions(cmd)
+		if err != nil {
+			return err
+		}
+		if outputFile != "" {
+			return writeQueryOutputFile(result, outputFile, output, cmd.Flags().Changed("output"), csvOpts...)
+		}
+		switch output {
+		case "json":
+			return outputJSON(result)
+		case "csv":
+			return outputCSV(result, csvOpts...)
+		default:
+			return outputTable(result)
+		}
+	},
+}
+
+var keyRotateCmd = &cobra.Command{
+	Use:   "rotate [lockbox-file]",
+	Short: "Re-encrypt one column under a new key",
+	Long: `Re-encrypt the blocks of one column under a new key, for example after its
+exported key may have been exposed. Other columns are not rewritten.
+
+With --lazy only new writes use the new key; existing blocks keep being read
+with their key until "key compact" re-encrypts them.
+
+Example:
+  lockbox key rotate data.lbx --column ssn`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		column, _ := cmd.Flags().GetString("column")
+		lazy, _ := cmd.Flags().GetBool("lazy")
+		if column == "" {
+			return fmt.Errorf("--column is required")
+		}
+
+		password, err := readPassword(cmd)
+		if err != nil {
+			return err
+		}
+
+		lb, err := lockbox.Open(args[0], lockbox.WithPassword(password))
+		if err != nil {
+			return fmt.Errorf("failed to open lockbox: %w", err)
+		}
+		defer lb.Close()
+
+		rotateOpts := []lockbox.Option{lockbox.WithPassword(password)}
+		if lazy {
+			rotateOpts = append(rotateOpts, lockbox.WithLazyRotation())
+		}
+		epoch, err := lb.RotateColumnKey(column, rotateOpts...)
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("Rotated key for column %s (key version %d)\n", column, epoch)
+		return nil
+	},
+}
+
+var keyCompactCmd = &cobra.Command{
+	Use:   "compact [lockbox-file]",
+	Short: "Re-encrypt blocks left on old keys by lazy rotations",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		password, err := readPassword(cmd)
+		if err != nil {
+			return err
+		}
+
+		lb, err := lockbox.Open(args[0], lockbox.WithPassword(password))
+		if err != nil {
+			return fmt.Errorf("failed to open lockbox: %w", err)
+		}
+		defer lb.Close()
+
+		n, err := lb.CompactKeys(cmd.Context(), lockbox.WithPassword(password))
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("Re-encrypted %d blocks\n", n)
+		return nil
+	},
+}
+
+var keyGrantCmd = &cobra.Command{
+	Use:   "grant [lockbox-file] [name]",
+	Short: "Create a password that decrypts only some columns",
+	Long: `Create an access key: a password of its own that decrypts the columns given
+with --columns and no others. Commands that read, such as query, head and
+export, accept it like the lockbox password; columns outside the grant stay
+opaque, so "SELECT *" returns only the granted columns. Access passwords
+cannot write. Granted columns whose key was never rotated share the file's
+secret, so they are rotated to keys of their own first.
+
+The lockbox password is read as usual; the new access password comes from
+--access-password-env or a prompt. Granting an existing name replaces it.
+
+Example:
+  lockbox key grant people.lbx public --columns id,name,age`,
+	Args: cobra.ExactArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		columns, _ := cmd.Flags().GetStringSlice("columns")
+		accessEnv, _ := cmd.Flags().GetString("access-password-env")
+		if len(columns) == 0 {
+			return fmt.Errorf("--columns is required")
+		}
+
+		password, err := readPassword(cmd)
+		if err != nil {
+			return err
+		}
+		var access string
+		if accessEnv != "" {
+			if access = os.Getenv(accessEnv); access == "" {
+				return fmt.Errorf("environment variable %s is not set", accessEnv)
+			}
+		} else if access, err = readNewPassword(); err != nil {
+			return err
+		}
+
+		lb, err := lockbox.Open(args[0], lockbox.WithPassword(password))
+		if err != nil {
+			return fmt.Errorf("failed to open lockbox: %w", err)
+		}
+		defer lb.Close()
+
+		if err := lb.GrantAccess(args[1], access, columns, lockbox.WithPassword(password)); err != nil {
+			return fmt.Errorf("failed to grant access: %w", err)
+		}
+		fmt.Printf("Granted access key %s to columns %s\n", args[1], strings.Join(columns, ", "))
+		return nil
+	},
+}
+
+var keyRevokeCmd = &cobra.Command{
+	Use:   "revoke [lockbox-file] [name]",
+	Short: "Remove an access key",
+	Long: `Remove an access key so its password no longer opens the lockbox. Rotate the
+keys of its columns with "key rotate" as well if the holders must lose access
+to copies of the file they already have.`,
+	Args: cobra.ExactArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		password, err := readPassword(cmd)
+		if err != nil {
+			return err
+		}
+
+		lb, err := lockbox.Open(args[0], lockbox.WithPassword(password))
+		if err != nil {
+			return fmt.Errorf("failed to open lockbox: %w", err)
+		}
+		defer lb.Close()
+
+		if err := lb.RevokeAccess(args[1]); err != nil {
+			return fmt.Errorf("failed to revoke access: %w", err)
+		}
+		fmt.Printf("Revoked access key %s\n", args[1])
+		return nil
+	},
+}
+
+var keyListCmd = &cobra.Command{
+	Use:   "list [lockbox-file]",
+	Short: "List access keys and the columns they decrypt",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		info, err := lockbox.ReadInfo(args[0])
+		if err != nil {
+			return err
+		}
+		if len(info.AccessKeys) == 0 {
+			fmt.Println("No access keys defined")
+			return nil
+		}
+		for _, g := range info.AccessKeys {
+			fmt.Printf("%s\n  columns:    %s\n  created:    %s\n", g.Name, strings.Join(g.Columns, ", "), g.CreatedAt.Format(time.RFC3339))
+		}
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(keyCmd)
+	keyCmd.AddCommand(keyEscrowKeygenCmd, keyExportCmd, keyFieldCmd, keyImportCmd, keyRotateCmd, keyCompactCmd, keyGrantCmd, keyRevokeCmd, keyListCmd)
+
+	addPasswordFlags(keyExportCmd.Flags(), "Password for the lockbox")
+	keyExportCmd.Flags().String("column", "", "Column whose key is exported")
+	keyExportCmd.Flags().String("wrap-to", "", "Escrow public key file to seal the key to")
+	keyExportCmd.Flags().String("out", "", "Output file (default <column>.lbxkey)")
+
+	addPasswordFlags(keyFieldCmd.Flags(), "Password for the lockbox")
+	keyFieldCmd.Flags().String("column", "", "Column whose field key is exported")
+	keyFieldCmd.Flags().String("out", "", "Output file (default <column>.jwk)")
+
+	keyImportCmd.Flags().String("bundle", "", "Key bundle produced by key export")
+	keyImportCmd.Flags().String("escrow-key", "", "Escrow private key file")
+	keyImportCmd.Flags().StringP("sql", "q", "", "Query to run (default selects the column)")
+	keyImportCmd.Flags().StringP("output", "o", "table", "Output format (table, json, csv; with --output-file also parquet, arrow)")
+	keyImportCmd.Flags().String("output-file", "", "Write results to this file instead of stdout")
+	addCSVFlags(keyImportCmd, true)
+
+	addPasswordFlags(keyRotateCmd.Flags(), "Password for the lockbox")
+	keyRotateCmd.Flags().String("column", "", "Column whose key to rotate")
+	keyRotateCmd.Flags().Bool("lazy", false, "Only use the new key for new writes")
+
+	addPasswordFlags(keyCompactCmd.Flags(), "Password for the lockbox")
+
+	addPasswordFlags(keyGrantCmd.Flags(), "Password for the lockbox")
+	keyGrantCmd.Flags().StringSlice("columns", nil, "Columns the access password decrypts")
+	keyGrantCmd.Flags().String("access-password-env", "", "Read the new access password from this environment variable")
+
+	addPasswordFlags(keyRevokeCmd.Flags(), "Password for the lockbox")
+
+	addProfileFlags(keyRotateCmd)
+	addProfileFlags(keyCompactCmd)
+}
@@ -0,0 +1,108 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/TFMV/lockbox/pkg/lockbox"
+	"github.com/TFMV/lockbox/pkg/metadata"
+	"github.com/spf13/cobra"
+)
+
+var logCmd = &cobra.Command{
+	Use:   "log [lockbox-file]",
+	Short: "Show the commit history of a lockbox",
+	Long: `List the commits of a lockbox, newest first, with their time, principal,
+row counts, message and lineage.
+
+The history is stored in the clear, so no password is needed.
+
+Examples:
+  lockbox log data.lbx
+  lockbox log data.lbx --oneline -n 10`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		maxCount, _ := cmd.Flags().GetInt("max-count")
+		oneline, _ := cmd.Flags().GetBool("oneline")
+		output, _ := cmd.Flags().GetString("output")
+
+		snaps, err := lockbox.ReadSnapshots(args[0])
+		if err != nil {
+			return err
+		}
+
+		// Newest first
+		commits := make([]metadata.Snapshot, 0, len(snaps))
+		for i := len(snaps) - 1; i >= 0; i-- {
+			if maxCount > 0 && len(commits) == maxCount {
+				break
+			}
+			commits = append(commits, snaps[i])
+		}
+
+		if output == "json" {
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "  ")
+			return enc.Encode(commits)
+		}
+		if len(commits) == 0 {
+			fmt.Println("No commits recorded")
+			return nil
+		}
+		for i, c := range commits {
+			if oneline {
+				fmt.Printf("%d %s %s +%d %s\n", c.ID, c.Timestamp.Format("2006-01-02 15:04:05"), c.Principal, c.RowsAdded, firstLine(c.Message))
+				continue
+			}
+			if i > 0 {
+				fmt.Println()
+			}
+			printCommit(c)
+		}
+		return nil
+	},
+}
+
+// printCommit prints a commit in the long log format
+func printCommit(c metadata.Snapshot) {
+	fmt.Printf("commit %d\n", c.ID)
+	fmt.Printf("Principal: %s\n", c.Principal)
+	fmt.Printf("Date:      %s\n", c.Timestamp.Format("Mon Jan 2 15:04:05 2006 -0700"))
+	fmt.Printf("Operation: %s (+%d rows, %d total)\n", c.Operation, c.RowsAdded, c.TotalRows)
+	if l := c.Lineage; l != nil {
+		if l.Source != "" {
+			source := l.Source
+			if l.SourceHash != "" {
+				source += " (" + l.SourceHash + ")"
+			}
+			fmt.Printf("Source:    %s\n", source)
+		}
+		if l.Tool != "" {
+			fmt.Printf("Tool:      %s\n", l.Tool)
+		}
+		if l.Transformation != "" {
+			fmt.Printf("Transform: %s\n", l.Transformation)
+		}
+	}
+	if c.Message != "" {
+		fmt.Println()
+		for _, line := range strings.Split(c.Message, "\n") {
+			fmt.Printf("    %s\n", line)
+		}
+	}
+}
+
+func firstLine(s string) string {
+	line, _, _ := strings.Cut(s, "\n")
+	return line
+}
+
+func init() {
+	rootCmd.AddCommand(logCmd)
+
+	logCmd.Flags().IntP("max-count", "n", 0, "Show at most this many commits")
+	logCmd.Flags().Bool("oneline", false, "Show one line per commit")
+	logCmd.Flags().StringP("output", "o", "table", "Output format (table, json)")
+}
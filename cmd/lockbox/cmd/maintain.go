@@ -0,0 +1,226 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/TFMV/lockbox/pkg/lockbox"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+)
+
+var maintainCmd = &cobra.Command{
+	Use:   "maintain",
+	Short: "Run scheduled maintenance for the lockboxes in a directory",
+	Long: `Run scheduled maintenance for every .lbx file in a directory:
+- re-encrypt blocks left on old keys by lazy key rotations
+- compact deleted rows away
+- expire old snapshots, when --keep-last or --keep-days is set
+- compute the stats of blocks written without them
+- refresh materialized views that are older than the last write
+- export new access log entries (--audit-dir)
+- drop old access log entries (--audit-retention)
+
+All lockboxes are opened with the same password. Each run holds an
+advisory lock on <file>.lock, and lockboxes locked by another run are
+skipped. With --status-addr the
+result of the last run is served as JSON on /status, and --pprof serves
+profiles on /debug/pprof/ for performance reports.
+
+Example:
+  lockbox maintain --watch /data/lockboxes --interval 1h --audit-dir /var/log/lockbox --status-addr :8089`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		dir, _ := cmd.Flags().GetString("watch")
+		interval, _ := cmd.Flags().GetDuration("interval")
+		once, _ := cmd.Flags().GetBool("once")
+		auditDir, _ := cmd.Flags().GetString("audit-dir")
+		retention, _ := cmd.Flags().GetDuration("audit-retention")
+		keepLast, _ := cmd.Flags().GetInt("keep-last")
+		keepDays, _ := cmd.Flags().GetInt("keep-days")
+		statusAddr, _ := cmd.Flags().GetString("status-addr")
+		logJSON, _ := cmd.Flags().GetBool("log-json")
+		pprofAddr, _ := cmd.Flags().GetString("pprof")
+		if dir == "" {
+			return fmt.Errorf("--watch is required")
+		}
+		if interval <= 0 {
+			return fmt.Errorf("--interval must be positive")
+		}
+
+		if logJSON {
+			log.Logger = zerolog.New(os.Stderr).With().Timestamp().Logger()
+		}
+
+		password, err := readPassword(cmd)
+		if err != nil {
+			return err
+		}
+		opts := []lockbox.Option{lockbox.WithPassword(password), lockbox.WithCreatedBy("maintain")}
+		if auditDir != "" {
+			opts = append(opts, lockbox.WithAuditExport(auditDir))
+		}
+		if retention > 0 {
+			opts = append(opts, lockbox.WithAuditRetention(retention))
+		}
+		if keepLast > 0 {
+			opts = append(opts, lockbox.WithKeepLast(keepLast))
+		}
+		if keepDays > 0 {
+			opts = append(opts, lockbox.WithKeepFor(time.Duration(keepDays)*24*time.Hour))
+		}
+
+		ctx, stop := signal.NotifyContext(cmd.Context(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+
+		status := &maintainStatus{Dir: dir, StartedAt: time.Now(), Files: map[string]*lockbox.MaintenanceReport{}}
+		if statusAddr != "" {
+			srv := &http.Server{Addr: statusAddr, Handler: status}
+			go func() {
+				if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+					log.Error().Err(err).Str("addr", statusAddr).Msg("Status endpoint failed")
+				}
+			}()
+			defer srv.Close()
+			log.Info().Str("addr", statusAddr).Msg("Serving maintenance status")
+		}
+		if pprofAddr != "" {
+			stopPprof, err := servePprof(pprofAddr)
+			if err != nil {
+				return err
+			}
+			defer stopPprof()
+		}
+
+		for {
+			if err := maintainDir(ctx, dir, status, opts); err != nil {
+				return err
+			}
+			if once {
+				return nil
+			}
+			select {
+			case <-ctx.Done():
+				log.Info().Msg("Stopping maintenance")
+				return nil
+			case <-time.After(interval):
+			}
+		}
+	},
+}
+
+// maintainStatus is the state served on the status endpoint
+type maintainStatus struct {
+	mu        sync.Mutex
+	Dir       string                                `json:"dir"`
+	StartedAt time.Time                             `json:"startedAt"`
+	LastRun   time.Time                             `json:"lastRun,omitempty"`
+	Runs      int                                   `json:"runs"`
+	Files     map[string]*lockbox.MaintenanceReport `json:"files"`
+}
+
+// ServeHTTP implements http.Handler
+func (s *maintainStatus) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/status" {
+		http.NotFound(w, r)
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(s); err != nil {
+		log.Error().Err(err).Msg("Failed to write status")
+	}
+}
+
+// maintainDir runs maintenance once for every lockbox in dir. Failures of
+// individual lockboxes are logged and recorded in the status.
+func maintainDir(ctx context.Context, dir string, status *maintainStatus, opts []lockbox.Option) error {
+	files, err := filepath.Glob(filepath.Join(dir, "*.lbx"))
+	if err != nil {
+		return fmt.Errorf("failed to list lockboxes: %w", err)
+	}
+	sort.Strings(files)
+
+	reports := make(map[string]*lockbox.MaintenanceReport, len(files))
+	for _, path := range files {
+		if ctx.Err() != nil {
+			break
+		}
+		report, err := maintainFile(ctx, path, opts)
+		reports[path] = report
+		if errors.Is(err, lockbox.ErrMaintenanceRunning) {
+			log.Info().Str("file", path).Msg("Skipping lockbox maintained by another run")
+			continue
+		}
+
+		event := log.Info()
+		if err != nil {
+			event = log.Error().Err(err)
+		}
+		event.
+			Str("file", path).
+			Int("rekeyed_blocks", report.RekeyedBlocks).
+			Int64("compacted_rows", report.CompactedRows).
+			Int("expired_snapshots", report.ExpiredSnapshots).
+			Int64("reclaimed_bytes", report.ReclaimedBytes).
+			Int("refreshed_stats", report.RefreshedStats).
+			Strs("refreshed_views", report.RefreshedViews).
+			Int("exported_audit", report.ExportedAudit).
+			Int("trimmed_audit", report.TrimmedAudit).
+			Dur("duration", report.Duration).
+			Msg("Maintained lockbox")
+	}
+
+	status.mu.Lock()
+	status.LastRun = time.Now()
+	status.Runs++
+	status.Files = reports
+	status.mu.Unlock()
+	return nil
+}
+
+func maintainFile(ctx context.Context, path string, opts []lockbox.Option) (*lockbox.MaintenanceReport, error) {
+	failed := func(err error) (*lockbox.MaintenanceReport, error) {
+		return &lockbox.MaintenanceReport{File: path, StartedAt: time.Now(), Errors: []string{err.Error()}}, err
+	}
+
+	lb, err := lockbox.Open(path, opts...)
+	if err != nil {
+		return failed(fmt.Errorf("failed to open lockbox: %w", err))
+	}
+	defer lb.Close()
+
+	report, err := lb.Maintain(ctx, opts...)
+	if report == nil {
+		return failed(err)
+	}
+	return report, err
+}
+
+func init() {
+	rootCmd.AddCommand(maintainCmd)
+
+	maintainCmd.Flags().String("watch", "", "Directory whose .lbx files are maintained")
+	maintainCmd.Flags().Duration("interval", time.Hour, "Time between maintenance runs")
+	maintainCmd.Flags().Bool("once", false, "Run maintenance once and exit")
+	addPasswordFlags(maintainCmd.Flags(), "Password for the lockboxes")
+	maintainCmd.Flags().String("audit-dir", "", "Directory to export access logs to")
+	maintainCmd.Flags().Duration("audit-retention", 0, "Drop access log entries older than this")
+	maintainCmd.Flags().Int("keep-last", 0, "Always keep this many of the newest snapshots")
+	maintainCmd.Flags().Int("keep-days", 0, "Keep snapshots committed within this many days")
+	maintainCmd.Flags().String("status-addr", "", "Address to serve the status endpoint on, e.g. :8089")
+	maintainCmd.Flags().Bool("log-json", false, "Write logs as JSON")
+	maintainCmd.Flags().String("pprof", "", "Address to serve pprof profiles and traces on, e.g. :6060")
+}
@@ -0,0 +1,56 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/TFMV/lockbox/pkg/codec"
+	"github.com/TFMV/lockbox/pkg/crypto"
+	"github.com/spf13/cobra"
+)
+
+var modulesCmd = &cobra.Command{
+	Use:   "modules",
+	Short: "Inspect modules available in this binary",
+}
+
+var modulesListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List registered crypto modules and codecs",
+	Long: `List the crypto modules and compression codecs registered in this binary.
+
+Modules are linked in at build time (e.g. go build -tags lockbox_flate) or
+loaded from Go plugins with --crypto-plugin and --codec-plugin.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cryptoPlugins, _ := cmd.Flags().GetStringArray("crypto-plugin")
+		codecPlugins, _ := cmd.Flags().GetStringArray("codec-plugin")
+		for _, path := range cryptoPlugins {
+			if err := crypto.LoadPlugin(path); err != nil {
+				return fmt.Errorf("failed to load crypto plugin %s: %w", path, err)
+			}
+		}
+		for _, path := range codecPlugins {
+			if err := codec.LoadPlugin(path); err != nil {
+				return fmt.Errorf("failed to load codec plugin %s: %w", path, err)
+			}
+		}
+
+		fmt.Println("Crypto modules:")
+		for _, name := range crypto.Modules() {
+			fmt.Printf("  %s\n", name)
+		}
+		fmt.Println("Codecs:")
+		for _, name := range codec.Names() {
+			fmt.Printf("  %s\n", name)
+		}
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(modulesCmd)
+	modulesCmd.AddCommand(modulesListCmd)
+
+	modulesListCmd.Flags().StringArray("crypto-plugin", nil, "Load a crypto module from a Go plugin before listing")
+	modulesListCmd.Flags().StringArray("codec-plugin", nil, "Load a codec from a Go plugin before listing")
+}
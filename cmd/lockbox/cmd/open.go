@@ -0,0 +1,97 @@
+package cmd
+
+import (
+	"fmt"
+	"syscall"
+
+	"github.com/TFMV/lockbox/pkg/lockbox"
+	"github.com/spf13/cobra"
+	"golang.org/x/term"
+)
+
+var openCmd = &cobra.Command{
+	Use:   "open [lockbox-file]",
+	Short: "Unlock a lockbox and optionally set a new password",
+	Long: `Check that a lockbox can be unlocked, change its password or regain
+access with a recovery code. Lockboxes in object stores (s3://, gs://,
+az://) open read-only here and in the commands that read and query them.
+
+Examples:
+  lockbox open data.lbx -p secret --change-password
+  lockbox open s3://bucket/data.lbx
+  lockbox open data.lbx --recovery-code ABCD-EFGH-IJKL-MNOP`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		filename := args[0]
+		code, _ := cmd.Flags().GetString("recovery-code")
+		changePassword, _ := cmd.Flags().GetBool("change-password")
+
+		if code != "" {
+			newPassword, err := readNewPassword()
+			if err != nil {
+				return err
+			}
+			lb, err := lockbox.Recover(filename, code, newPassword)
+			if err != nil {
+				return fmt.Errorf("failed to recover lockbox: %w", err)
+			}
+			defer lb.Close()
+
+			fmt.Printf("Password reset for %s (%d recovery codes left)\n", filename, lb.RemainingRecoveryCodes())
+			return nil
+		}
+
+		password, err := readPassword(cmd)
+		if err != nil {
+			return err
+		}
+		lb, err := lockbox.Open(filename, lockbox.WithPassword(password))
+		if err != nil {
+			return fmt.Errorf("failed to open lockbox: %w", err)
+		}
+		defer lb.Close()
+
+		if changePassword {
+			newPassword, err := readNewPassword()
+			if err != nil {
+				return err
+			}
+			if err := lb.ChangePassword(newPassword, lockbox.WithPassword(password)); err != nil {
+				return fmt.Errorf("failed to change password: %w", err)
+			}
+			fmt.Printf("Password changed for %s\n", filename)
+			return nil
+		}
+
+		fmt.Printf("Unlocked %s (%d recovery codes left)\n", filename, lb.RemainingRecoveryCodes())
+		return nil
+	},
+}
+
+// readNewPassword prompts twice for a new password
+func readNewPassword() (string, error) {
+	fmt.Print("New password: ")
+	first, err := term.ReadPassword(int(syscall.Stdin))
+	fmt.Println()
+	if err != nil {
+		return "", fmt.Errorf("failed to read password: %w", err)
+	}
+	fmt.Print("Repeat new password: ")
+	second, err := term.ReadPassword(int(syscall.Stdin))
+	fmt.Println()
+	if err != nil {
+		return "", fmt.Errorf("failed to read password: %w", err)
+	}
+	if string(first) != string(second) || len(first) == 0 {
+		return "", fmt.Errorf("passwords are empty or do not match")
+	}
+	return string(first), nil
+}
+
+func init() {
+	rootCmd.AddCommand(openCmd)
+
+	addPasswordFlags(openCmd.Flags(), "Password for the lockbox")
+	openCmd.Flags().String("recovery-code", "", "Recovery code to reset a lost password")
+	openCmd.Flags().Bool("change-password", false, "Prompt for a new password")
+}
@@ -0,0 +1,175 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"syscall"
+
+	"github.com/TFMV/lockbox/pkg/lockbox"
+	"github.com/TFMV/lockbox/pkg/metadata"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"golang.org/x/term"
+)
+
+// keychainService is the service name under which lockbox passwords are
+// stored in the OS keychain
+const keychainService = "lockbox"
+
+// addPasswordFlags adds the flags that supply a password: --password-env,
+// --password-keychain and the deprecated --password/-p
+func addPasswordFlags(flags *pflag.FlagSet, usage string) {
+	flags.StringP("password", "p", "", usage+" (deprecated: visible in process listings)")
+	flags.String("password-env", "", "Read the password from this environment variable")
+	flags.String("password-keychain", "", "Read the password stored under this name in the OS keychain")
+}
+
+// readPassword returns the password from --password-env, --password-keychain
+// or --password, from the lockbox agent when it holds the file named by the
+// first argument, or else prompts for it on the terminal
+func readPassword(cmd *cobra.Command) (string, error) {
+	if password, ok, err := flagPassword(cmd); ok || err != nil {
+		return password, err
+	}
+	if password, ok := agentPassword(cmd.Flags().Arg(0)); ok {
+		return password, nil
+	}
+	return promptPassword()
+}
+
+// flagPassword returns the password given with a flag, if any
+func flagPassword(cmd *cobra.Command) (string, bool, error) {
+	if name, _ := cmd.Flags().GetString("password-env"); name != "" {
+		password, ok := os.LookupEnv(name)
+		if !ok || password == "" {
+			return "", false, fmt.Errorf("environment variable %s is not set", name)
+		}
+		return password, true, nil
+	}
+	if name, _ := cmd.Flags().GetString("password-keychain"); name != "" {
+		password, err := keychainPassword(name)
+		return password, err == nil, err
+	}
+	if password, _ := cmd.Flags().GetString("password"); password != "" {
+		log.Warn().Msg("Passing a password with -p/--password exposes it in process listings and shell history; use --password-env or --password-keychain")
+		return password, true, nil
+	}
+	return "", false, nil
+}
+
+// agentPassword asks a running lockbox agent for the master key of path,
+// returning the stand-in password that opens the file with it
+func agentPassword(path string) (string, bool) {
+	if fi, err := os.Stat(path); path == "" || err != nil || !fi.Mode().IsRegular() {
+		return "", false
+	}
+	password, ok, err := lockbox.NewAgentClient("").Unlock(path)
+	if err != nil {
+		if !errors.Is(err, lockbox.ErrAgentUnavailable) {
+			log.Warn().Err(err).Msg("Failed to ask the lockbox agent for the password")
+		}
+		return "", false
+	}
+	if ok {
+		log.Debug().Str("file", path).Msg("Using password from lockbox agent")
+	}
+	return password, ok
+}
+
+// promptPassword reads a password from the terminal
+func promptPassword() (string, error) {
+	fmt.Print("Enter password: ")
+	passwordBytes, err := term.ReadPassword(int(syscall.Stdin))
+	if err != nil {
+		return "", fmt.Errorf("failed to read password: %w", err)
+	}
+	fmt.Println() // New line after password input
+	return string(passwordBytes), nil
+}
+
+// keychainPassword looks up a password in the macOS keychain or, elsewhere,
+// the Secret Service through secret-tool. Entries are stored under the
+// service "lockbox" with name as the account, e.g.
+//
+//	security add-generic-password -s lockbox -a prod -w
+//	secret-tool store --label lockbox service lockbox account prod
+func keychainPassword(name string) (string, error) {
+	var c *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		c = exec.Command("security", "find-generic-password", "-s", keychainService, "-a", name, "-w")
+	case "windows":
+		return "", fmt.Errorf("keychain lookup is not supported on %s", runtime.GOOS)
+	default:
+		c = exec.Command("secret-tool", "lookup", "service", keychainService, "account", name)
+	}
+	out, err := c.Output()
+	if err != nil {
+		return "", fmt.Errorf("failed to read password %s from keychain: %w", name, err)
+	}
+	password := strings.TrimRight(string(out), "\r\n")
+	if password == "" {
+		return "", fmt.Errorf("keychain entry %s is empty", name)
+	}
+	return password, nil
+}
+
+// redactArgs returns the command line with the values of password flags
+// replaced, for logging
+func redactArgs(args []string) []string {
+	out := make([]string, len(args))
+	copy(out, args)
+	for i := 0; i < len(out); i++ {
+		switch arg := out[i]; {
+		case arg == "--":
+			return out
+		case arg == "-p" || arg == "--password":
+			if i+1 < len(out) {
+				out[i+1] = "***"
+				i++
+			}
+		case strings.HasPrefix(arg, "--password="):
+			out[i] = "--password=***"
+		case strings.HasPrefix(arg, "-p") && !strings.HasPrefix(arg, "--"):
+			out[i] = "-p***"
+		}
+	}
+	return out
+}
+
+// addKDFFlags adds the flags that choose how keys are derived from the
+// password of a new file
+func addKDFFlags(flags *pflag.FlagSet) {
+	flags.String("kdf", "", "Password key derivation: pbkdf2 (default) or argon2id")
+	flags.Uint32("argon2-time", 0, "Argon2id passes (default 3)")
+	flags.Uint32("argon2-memory", 0, "Argon2id memory in MiB (default 64)")
+	flags.Uint8("argon2-threads", 0, "Argon2id parallelism (default 4)")
+}
+
+// kdfOptions returns the options for the key derivation chosen with the
+// flags of addKDFFlags
+func kdfOptions(flags *pflag.FlagSet) ([]lockbox.Option, error) {
+	kdf, _ := flags.GetString("kdf")
+	var params metadata.Argon2Params
+	params.Time, _ = flags.GetUint32("argon2-time")
+	memory, _ := flags.GetUint32("argon2-memory")
+	params.Memory = memory * 1024
+	params.Threads, _ = flags.GetUint8("argon2-threads")
+
+	switch strings.ToLower(kdf) {
+	case "argon2id":
+		return []lockbox.Option{lockbox.WithArgon2id(params)}, nil
+	case "", "pbkdf2":
+		if params != (metadata.Argon2Params{}) {
+			return nil, fmt.Errorf("--argon2-time, --argon2-memory and --argon2-threads need --kdf argon2id")
+		}
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("invalid --kdf %q: expected pbkdf2 or argon2id", kdf)
+	}
+}
@@ -0,0 +1,154 @@
+package cmd
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/TFMV/lockbox/pkg/lockbox"
+	"github.com/TFMV/lockbox/pkg/metadata"
+	"github.com/spf13/cobra"
+)
+
+var policyCmd = &cobra.Command{
+	Use:   "policy",
+	Short: "Manage access policy rules",
+	Long: `Manage declarative access rules stored in the lockbox metadata.
+
+Conditions, row filters and masks are expressions that can use the
+principal and action being checked as well as the row's columns.
+
+Examples:
+  lockbox policy add data.lbx own_rows --row-filter "owner = principal"
+  lockbox policy add data.lbx hide_ssn --principal analyst --mask "ssn=substr(ssn, 8, 4)"
+  lockbox policy add data.lbx etl_only --action write --condition "principal = 'etl'"`,
+}
+
+var policyAddCmd = &cobra.Command{
+	Use:   "add [lockbox-file] [name]",
+	Short: "Add a policy rule",
+	Args:  cobra.ExactArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		password, err := readPassword(cmd)
+		if err != nil {
+			return err
+		}
+
+		rule := metadata.PolicyRule{Name: args[1]}
+		rule.Principals, _ = cmd.Flags().GetStringSlice("principal")
+		rule.Actions, _ = cmd.Flags().GetStringSlice("action")
+		rule.Condition, _ = cmd.Flags().GetString("condition")
+		rule.RowFilter, _ = cmd.Flags().GetString("row-filter")
+
+		masks, _ := cmd.Flags().GetStringArray("mask")
+		for _, m := range masks {
+			col, expression, ok := strings.Cut(m, "=")
+			if !ok {
+				return fmt.Errorf("invalid mask %q, expected column=expression", m)
+			}
+			if rule.Masks == nil {
+				rule.Masks = map[string]string{}
+			}
+			rule.Masks[strings.TrimSpace(col)] = strings.TrimSpace(expression)
+		}
+
+		lb, err := lockbox.Open(args[0], lockbox.WithPassword(password))
+		if err != nil {
+			return fmt.Errorf("failed to open lockbox: %w", err)
+		}
+		defer lb.Close()
+
+		if err := lb.AddPolicyRule(rule); err != nil {
+			return fmt.Errorf("failed to add policy rule: %w", err)
+		}
+
+		fmt.Printf("Added policy rule %s\n", args[1])
+		return nil
+	},
+}
+
+var policyListCmd = &cobra.Command{
+	Use:   "list [lockbox-file]",
+	Short: "List policy rules",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		password, err := readPassword(cmd)
+		if err != nil {
+			return err
+		}
+
+		lb, err := lockbox.Open(args[0], lockbox.WithPassword(password))
+		if err != nil {
+			return fmt.Errorf("failed to open lockbox: %w", err)
+		}
+		defer lb.Close()
+
+		rules := lb.PolicyRules()
+		if len(rules) == 0 {
+			fmt.Println("No policy rules defined")
+			return nil
+		}
+		for _, rule := range rules {
+			fmt.Printf("%s\n", rule.Name)
+			if len(rule.Principals) > 0 {
+				fmt.Printf("  principals: %s\n", strings.Join(rule.Principals, ", "))
+			}
+			if len(rule.Actions) > 0 {
+				fmt.Printf("  actions:    %s\n", strings.Join(rule.Actions, ", "))
+			}
+			if rule.Condition != "" {
+				fmt.Printf("  condition:  %s\n", rule.Condition)
+			}
+			if rule.RowFilter != "" {
+				fmt.Printf("  row filter: %s\n", rule.RowFilter)
+			}
+			cols := make([]string, 0, len(rule.Masks))
+			for col := range rule.Masks {
+				cols = append(cols, col)
+			}
+			sort.Strings(cols)
+			for _, col := range cols {
+				fmt.Printf("  mask:       %s = %s\n", col, rule.Masks[col])
+			}
+		}
+		return nil
+	},
+}
+
+var policyRemoveCmd = &cobra.Command{
+	Use:   "remove [lockbox-file] [name]",
+	Short: "Remove a policy rule",
+	Args:  cobra.ExactArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		password, err := readPassword(cmd)
+		if err != nil {
+			return err
+		}
+
+		lb, err := lockbox.Open(args[0], lockbox.WithPassword(password))
+		if err != nil {
+			return fmt.Errorf("failed to open lockbox: %w", err)
+		}
+		defer lb.Close()
+
+		if err := lb.RemovePolicyRule(args[1]); err != nil {
+			return err
+		}
+
+		fmt.Printf("Removed policy rule %s\n", args[1])
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(policyCmd)
+	policyCmd.AddCommand(policyAddCmd, policyListCmd, policyRemoveCmd)
+
+	addPasswordFlags(policyCmd.PersistentFlags(), "Password for the lockbox")
+
+	policyAddCmd.Flags().StringSlice("principal", nil, "Principals the rule applies to (default all)")
+	policyAddCmd.Flags().StringSlice("action", nil, "Actions the rule applies to: read, write (default all)")
+	policyAddCmd.Flags().String("condition", "", "Expression that must be true to allow access")
+	policyAddCmd.Flags().String("row-filter", "", "Expression selecting the rows that can be read")
+	policyAddCmd.Flags().StringArray("mask", nil, "Mask a column on read, as column=expression (repeatable)")
+}
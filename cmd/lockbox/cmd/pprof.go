@@ -0,0 +1,127 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"os"
+	"runtime"
+	rpprof "runtime/pprof"
+	"runtime/trace"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+)
+
+// addProfileFlags adds --cpuprofile, --memprofile and --trace to cmd and
+// wraps its RunE so the profiles cover the whole command
+func addProfileFlags(cmd *cobra.Command) {
+	cmd.Flags().String("cpuprofile", "", "Write a CPU profile to this file")
+	cmd.Flags().String("memprofile", "", "Write a heap profile to this file when the command ends")
+	cmd.Flags().String("trace", "", "Write an execution trace to this file")
+
+	run := cmd.RunE
+	cmd.RunE = func(cmd *cobra.Command, args []string) (err error) {
+		stop, err := startProfiles(cmd)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			err = errors.Join(err, stop())
+		}()
+		return run(cmd, args)
+	}
+}
+
+// startProfiles starts the profiles requested on the command line and
+// returns a function that stops them and writes the heap profile
+func startProfiles(cmd *cobra.Command) (func() error, error) {
+	cpuPath, _ := cmd.Flags().GetString("cpuprofile")
+	memPath, _ := cmd.Flags().GetString("memprofile")
+	tracePath, _ := cmd.Flags().GetString("trace")
+
+	var stops []func() error
+	stopAll := func() error {
+		var errs []error
+		for i := len(stops) - 1; i >= 0; i-- {
+			errs = append(errs, stops[i]())
+		}
+		return errors.Join(errs...)
+	}
+
+	if cpuPath != "" {
+		f, err := os.Create(cpuPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create CPU profile: %w", err)
+		}
+		if err := rpprof.StartCPUProfile(f); err != nil {
+			f.Close()
+			return nil, fmt.Errorf("failed to start CPU profile: %w", err)
+		}
+		stops = append(stops, func() error {
+			rpprof.StopCPUProfile()
+			return f.Close()
+		})
+	}
+	if tracePath != "" {
+		f, err := os.Create(tracePath)
+		if err != nil {
+			stopAll()
+			return nil, fmt.Errorf("failed to create trace: %w", err)
+		}
+		if err := trace.Start(f); err != nil {
+			f.Close()
+			stopAll()
+			return nil, fmt.Errorf("failed to start trace: %w", err)
+		}
+		stops = append(stops, func() error {
+			trace.Stop()
+			return f.Close()
+		})
+	}
+	if memPath != "" {
+		stops = append(stops, func() error {
+			f, err := os.Create(memPath)
+			if err != nil {
+				return fmt.Errorf("failed to create heap profile: %w", err)
+			}
+			runtime.GC()
+			if err := rpprof.WriteHeapProfile(f); err != nil {
+				f.Close()
+				return fmt.Errorf("failed to write heap profile: %w", err)
+			}
+			return f.Close()
+		})
+	}
+	return stopAll, nil
+}
+
+// servePprof serves the net/http/pprof handlers, including the execution
+// trace, on addr until the returned function is called
+func servePprof(addr string) (func(), error) {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen for pprof on %s: %w", addr, err)
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", func(w http.ResponseWriter, r *http.Request) {
+		// pprof.Cmdline would serve a --password given on the command line
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		fmt.Fprint(w, strings.Join(redactArgs(os.Args), "\x00"))
+	})
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	srv := &http.Server{Handler: mux}
+	go func() {
+		if err := srv.Serve(l); err != nil && err != http.ErrServerClosed {
+			log.Error().Err(err).Str("addr", addr).Msg("pprof endpoint failed")
+		}
+	}()
+	log.Info().Str("addr", l.Addr().String()).Msg("Serving pprof on /debug/pprof/")
+	return func() { srv.Close() }, nil
+}
@@ -0,0 +1,88 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/TFMV/lockbox/pkg/lockbox"
+	"github.com/spf13/viper"
+)
+
+// presetConfig is a profile under "profiles" in the config file:
+//
+//	profile: pii-strict # used when --profile is not given
+//	profiles:
+//	  pii-strict:
+//	    crypto-module: default
+//	    kdf: pbkdf2-sha256
+//	    codec: gzip
+//	    file-mode: "0600"
+//	    recovery-codes: 3
+//	    masks:
+//	      - column: ssn
+//	        expr: "'***-**-' || substr(ssn, 8, 4)"
+//
+// Masks are a list rather than a map because config keys are not case
+// sensitive, and column names are.
+type presetConfig struct {
+	CryptoModule  string `mapstructure:"crypto-module"`
+	KDF           string `mapstructure:"kdf"`
+	Codec         string `mapstructure:"codec"`
+	FileMode      string `mapstructure:"file-mode"`
+	RecoveryCodes int    `mapstructure:"recovery-codes"`
+	Masks         []struct {
+		Column string `mapstructure:"column"`
+		Expr   string `mapstructure:"expr"`
+	} `mapstructure:"masks"`
+}
+
+// selectedPreset loads the profile named by --profile, or by the profile
+// key of the config file. It returns nil when no profile is selected.
+func selectedPreset() (*lockbox.Preset, *presetConfig, error) {
+	name := viper.GetString("profile")
+	if name == "" {
+		return nil, nil, nil
+	}
+	key := "profiles." + name
+	if !viper.IsSet(key) {
+		where := viper.ConfigFileUsed()
+		if where == "" {
+			where = "the config file"
+		}
+		return nil, nil, fmt.Errorf("profile %s is not defined in %s", name, where)
+	}
+
+	var cfg presetConfig
+	if err := viper.UnmarshalKey(key, &cfg); err != nil {
+		return nil, nil, fmt.Errorf("invalid profile %s: %w", name, err)
+	}
+	p := &lockbox.Preset{
+		Name:          name,
+		CryptoModule:  cfg.CryptoModule,
+		KeyDerivation: cfg.KDF,
+		Codec:         cfg.Codec,
+	}
+	for _, m := range cfg.Masks {
+		if m.Column == "" || m.Expr == "" {
+			return nil, nil, fmt.Errorf("profile %s: masks need a column and an expr", name)
+		}
+		if p.Masks == nil {
+			p.Masks = make(map[string]string)
+		}
+		p.Masks[m.Column] = m.Expr
+	}
+	return p, &cfg, nil
+}
+
+// fileMode parses the file-mode of a profile, or returns 0 when it has none
+func (c *presetConfig) fileMode() (os.FileMode, error) {
+	if c.FileMode == "" {
+		return 0, nil
+	}
+	mode, err := strconv.ParseUint(c.FileMode, 8, 32)
+	if err != nil || mode > 0o777 {
+		return 0, fmt.Errorf("invalid file-mode %q in profile: expected octal permissions such as 0600", c.FileMode)
+	}
+	return os.FileMode(mode), nil
+}
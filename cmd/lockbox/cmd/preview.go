@@ -0,0 +1,146 @@
+package cmd
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/TFMV/lockbox/pkg/lockbox"
+	"github.com/spf13/cobra"
+)
+
+var previewCmd = &cobra.Command{
+	Use:   "preview",
+	Short: "Manage the plaintext preview of a lockbox",
+	Long: `Manage a small unencrypted preview written next to a lockbox, so catalog
+UIs can show sample rows without keys. data.lbx gets data.preview.arrow,
+an Arrow IPC file.
+
+A preview must first be approved in the file's policy, naming the columns
+that are not sensitive and the most rows it may hold. Approvals, previews
+written and revocations are recorded in the audit log. Rows are read as
+--principal, so row filters and masks apply.
+
+Examples:
+  lockbox preview allow data.lbx --columns region,product --max-rows 20
+  lockbox preview create data.lbx --rows 10
+  lockbox preview show data.lbx
+  lockbox preview revoke data.lbx`,
+}
+
+var previewAllowCmd = &cobra.Command{
+	Use:   "allow [lockbox-file]",
+	Short: "Approve a preview of some columns",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		columns, _ := cmd.Flags().GetStringSlice("columns")
+		maxRows, _ := cmd.Flags().GetInt("max-rows")
+		principal, _ := cmd.Flags().GetString("principal")
+		if len(columns) == 0 {
+			return fmt.Errorf("--columns is required")
+		}
+
+		password, err := readPassword(cmd)
+		if err != nil {
+			return err
+		}
+		lb, err := lockbox.Open(args[0], lockbox.WithPassword(password))
+		if err != nil {
+			return fmt.Errorf("failed to open lockbox: %w", err)
+		}
+		defer lb.Close()
+
+		if err := lb.AllowPreview(columns, maxRows, lockbox.WithPrincipal(principal)); err != nil {
+			return fmt.Errorf("failed to approve preview: %w", err)
+		}
+		fmt.Printf("Approved a preview of up to %d rows of %s\n", maxRows, strings.Join(columns, ", "))
+		return nil
+	},
+}
+
+var previewCreateCmd = &cobra.Command{
+	Use:   "create [lockbox-file]",
+	Short: "Write the preview sidecar",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		rows, _ := cmd.Flags().GetInt("rows")
+		principal, _ := cmd.Flags().GetString("principal")
+
+		password, err := readPassword(cmd)
+		if err != nil {
+			return err
+		}
+		lb, err := lockbox.Open(args[0], lockbox.WithPassword(password))
+		if err != nil {
+			return fmt.Errorf("failed to open lockbox: %w", err)
+		}
+		defer lb.Close()
+
+		result, err := lb.WritePreview(cmd.Context(), rows, lockbox.WithPassword(password), lockbox.WithPrincipal(principal))
+		if err != nil {
+			return fmt.Errorf("failed to write preview: %w", err)
+		}
+		fmt.Printf("Wrote %d rows of %s to %s\n", result.Rows, strings.Join(result.Columns, ", "), result.Path)
+		return nil
+	},
+}
+
+var previewShowCmd = &cobra.Command{
+	Use:   "show [lockbox-file]",
+	Short: "Print the preview; no password needed",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		output, _ := cmd.Flags().GetString("output")
+		rec, err := lockbox.ReadPreview(args[0])
+		if err != nil {
+			return err
+		}
+		defer rec.Release()
+
+		switch output {
+		case "json":
+			return outputJSON(rec)
+		case "csv":
+			return outputCSV(rec)
+		default:
+			return outputTable(rec)
+		}
+	},
+}
+
+var previewRevokeCmd = &cobra.Command{
+	Use:   "revoke [lockbox-file]",
+	Short: "Withdraw the approval and delete the preview",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		principal, _ := cmd.Flags().GetString("principal")
+		password, err := readPassword(cmd)
+		if err != nil {
+			return err
+		}
+		lb, err := lockbox.Open(args[0], lockbox.WithPassword(password))
+		if err != nil {
+			return fmt.Errorf("failed to open lockbox: %w", err)
+		}
+		defer lb.Close()
+
+		if err := lb.RevokePreview(lockbox.WithPrincipal(principal)); err != nil {
+			return fmt.Errorf("failed to revoke preview: %w", err)
+		}
+		fmt.Printf("Revoked the preview of %s\n", args[0])
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(previewCmd)
+	previewCmd.AddCommand(previewAllowCmd, previewCreateCmd, previewShowCmd, previewRevokeCmd)
+
+	previewAllowCmd.Flags().StringSlice("columns", nil, "Columns that may appear in the preview")
+	previewAllowCmd.Flags().Int("max-rows", 10, "Most rows the preview may hold")
+	previewCreateCmd.Flags().Int("rows", 0, "Number of rows (default: the approved maximum)")
+	previewShowCmd.Flags().StringP("output", "o", "table", "Output format (table, json, csv)")
+	for _, c := range []*cobra.Command{previewAllowCmd, previewCreateCmd, previewRevokeCmd} {
+		c.Flags().String("principal", "", "User or role the access policy is evaluated for")
+		addPasswordFlags(c.Flags(), "Password for the lockbox")
+	}
+}
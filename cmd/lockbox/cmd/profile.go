@@ -0,0 +1,169 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/TFMV/lockbox/pkg/lockbox"
+	"github.com/spf13/cobra"
+)
+
+var profileCmd = &cobra.Command{
+	Use:   "profile [lockbox-file]",
+	Short: "Report data quality metrics for each column",
+	Long: `Report data quality metrics for each column: the share of nulls, the
+number of distinct values, the minimum and maximum, the most frequent
+values and how many strings look like emails, dates, timestamps, phone
+numbers or UUIDs.
+
+Columns are decrypted one at a time and the state kept per column is
+bounded, so very large or high-cardinality columns are estimated; such
+figures are marked with "~".
+
+Example:
+  lockbox profile data.lbx -o html > report.html`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		output, _ := cmd.Flags().GetString("output")
+		columns, _ := cmd.Flags().GetStringSlice("columns")
+		top, _ := cmd.Flags().GetInt("top")
+		principal, _ := cmd.Flags().GetString("principal")
+
+		password, err := readPassword(cmd)
+		if err != nil {
+			return err
+		}
+
+		lb, err := lockbox.Open(args[0], lockbox.WithPassword(password))
+		if err != nil {
+			return fmt.Errorf("failed to open lockbox: %w", err)
+		}
+		defer lb.Close()
+
+		profile, err := lb.Profile(cmd.Context(),
+			lockbox.WithPassword(password),
+			lockbox.WithColumns(columns...),
+			lockbox.WithTopValues(top),
+			lockbox.WithPrincipal(principal),
+		)
+		if err != nil {
+			return fmt.Errorf("failed to profile lockbox: %w", err)
+		}
+
+		switch output {
+		case "json":
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "  ")
+			return enc.Encode(profile)
+		case "html":
+			return profileHTML.Execute(os.Stdout, profile)
+		case "table":
+			return printProfile(os.Stdout, profile)
+		default:
+			return fmt.Errorf("unknown output format %q", output)
+		}
+	},
+}
+
+// printProfile writes a profile as a table with one row per column
+func printProfile(w io.Writer, p *lockbox.DataProfile) error {
+	fmt.Fprintf(w, "%s: %d rows\n\n", p.File, p.Rows)
+	fmt.Fprintln(w, "column\ttype\tnull %\tdistinct\tmin\tmax\ttop values\tpatterns")
+	fmt.Fprintln(w, "------\t----\t------\t--------\t---\t---\t----------\t--------")
+	for _, c := range p.Columns {
+		_, err := fmt.Fprintf(w, "%s\t%s\t%.1f\t%s\t%s\t%s\t%s\t%s\n",
+			c.Name, c.Type, c.NullPercent, approx(fmt.Sprint(c.Distinct), c.DistinctApprox),
+			profileValue(c.Min), profileValue(c.Max), topValues(c), patterns(c))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// approx marks estimated figures
+func approx(s string, estimated bool) string {
+	if estimated {
+		return "~" + s
+	}
+	return s
+}
+
+// profileValue formats a minimum or maximum, shortening long values
+func profileValue(v interface{}) string {
+	if v == nil {
+		return "-"
+	}
+	s := fmt.Sprint(v)
+	if len(s) > 32 {
+		s = s[:29] + "..."
+	}
+	return s
+}
+
+func topValues(c lockbox.ColumnProfile) string {
+	parts := make([]string, len(c.TopValues))
+	for i, v := range c.TopValues {
+		parts[i] = fmt.Sprintf("%s (%s)", profileValue(v.Value), approx(fmt.Sprint(v.Count), c.TopApprox))
+	}
+	return strings.Join(parts, ", ")
+}
+
+func patterns(c lockbox.ColumnProfile) string {
+	names := make([]string, 0, len(c.Patterns))
+	for name := range c.Patterns {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	parts := make([]string, len(names))
+	for i, name := range names {
+		parts[i] = fmt.Sprintf("%s %.1f%%", name, c.Patterns[name])
+	}
+	return strings.Join(parts, ", ")
+}
+
+var profileHTML = template.Must(template.New("profile").Funcs(template.FuncMap{
+	"approx":   approx,
+	"value":    profileValue,
+	"top":      topValues,
+	"patterns": patterns,
+	"sprint":   fmt.Sprint,
+	"percent":  func(f float64) string { return fmt.Sprintf("%.1f%%", f) },
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Data profile of {{.File}}</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; }
+th, td { border: 1px solid #ccc; padding: 4px 8px; text-align: left; vertical-align: top; }
+th { background: #f4f4f4; }
+</style>
+</head>
+<body>
+<h1>Data profile of {{.File}}</h1>
+<p>{{.Rows}} rows, generated {{.GeneratedAt.Format "2006-01-02 15:04:05 MST"}}</p>
+<table>
+<tr><th>Column</th><th>Type</th><th>Nulls</th><th>Distinct</th><th>Min</th><th>Max</th><th>Top values</th><th>Patterns</th></tr>
+{{range .Columns}}<tr><td>{{.Name}}</td><td>{{.Type}}</td><td>{{percent .NullPercent}}</td><td>{{approx (sprint .Distinct) .DistinctApprox}}</td><td>{{value .Min}}</td><td>{{value .Max}}</td><td>{{top .}}</td><td>{{patterns .}}</td></tr>
+{{end}}</table>
+</body>
+</html>
+`))
+
+func init() {
+	rootCmd.AddCommand(profileCmd)
+
+	addPasswordFlags(profileCmd.Flags(), "Password for decryption")
+	profileCmd.Flags().StringP("output", "o", "table", "Output format (table, json, html)")
+	profileCmd.Flags().StringSlice("columns", nil, "Columns to profile (default all)")
+	profileCmd.Flags().Int("top", 5, "Number of most frequent values to report per column")
+	profileCmd.Flags().String("principal", "", "User or role the access policy is evaluated for")
+	addProfileFlags(profileCmd)
+}
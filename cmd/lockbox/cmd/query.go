@@ -1,17 +1,18 @@
 package cmd
 
 import (
-	"context"
+	"errors"
 	"fmt"
+	"io"
+	"os"
+	"strconv"
 	"strings"
-	"syscall"
 	"time"
 
 	"github.com/TFMV/lockbox/pkg/lockbox"
 	"github.com/apache/arrow-go/v18/arrow"
 	"github.com/apache/arrow-go/v18/arrow/array"
 	"github.com/spf13/cobra"
-	"golang.org/x/term"
 )
 
 var queryCmd = &cobra.Command{
@@ -20,15 +21,47 @@ var queryCmd = &cobra.Command{
 	Long: `Query data from a lockbox file using SQL-like syntax.
 
 This command provides basic querying capabilities to retrieve and filter data
-from encrypted lockbox files.`,
+from encrypted lockbox files.
+
+Large results can be paged through with LIMIT and OFFSET in the query, or
+with --page, which also reports the number of pages.
+
+Results written as csv, ndjson or arrow (the Arrow IPC stream format), or to
+--output-file, are streamed: each stored batch is decrypted, filtered and
+written before the next is read, and a LIMIT stops the reading early.
+Queries that sort, aggregate or UNION are evaluated whole first.
+
+--as-of reads the file as of a snapshot, given by ID or as a time (RFC 3339
+or YYYY-MM-DD), for every SELECT without its own AS OF clause. Rows deleted
+or rewritten since are read as they are now. UNION compares snapshots:
+  lockbox query sales.lbx -q "SELECT COUNT(*) FROM data AS OF '2026-10-14' UNION ALL SELECT COUNT(*) FROM data"
+
+Examples:
+  lockbox query sales.lbx -q "SELECT * FROM data ORDER BY id LIMIT 100 OFFSET 200"
+  lockbox query sales.lbx -q "SELECT * FROM data ORDER BY id" --page 3 --page-size 100
+  lockbox query sales.lbx -q "SELECT id, amount FROM data WHERE amount > 100" -o ndjson | jq .amount
+
+Vector columns are searched for nearest neighbors with cosine_distance, whose
+query vector is a ? bound with --arg or a quoted literal:
+  lockbox query docs.lbx -q "SELECT id FROM data ORDER BY cosine_distance(embedding, ?) LIMIT 5" --arg "[0.1, 0.7, 0.2]"`,
 	Args: cobra.ExactArgs(1),
 	RunE: func(cmd *cobra.Command, args []string) error {
 		filename := args[0]
 
 		sqlQuery, _ := cmd.Flags().GetString("sql")
 		columnsFlag, _ := cmd.Flags().GetString("columns")
-		password, _ := cmd.Flags().GetString("password")
 		output, _ := cmd.Flags().GetString("output")
+		outputFile, _ := cmd.Flags().GetString("output-file")
+		principal, _ := cmd.Flags().GetString("principal")
+		statsJSON, _ := cmd.Flags().GetBool("json")
+		sampleRows, _ := cmd.Flags().GetInt("sample-rows")
+		page, _ := cmd.Flags().GetInt("page")
+		pageSize, _ := cmd.Flags().GetInt("page-size")
+		queryArgs, _ := cmd.Flags().GetStringArray("arg")
+		asOf, _ := cmd.Flags().GetString("as-of")
+		if page < 0 || pageSize < 1 {
+			return fmt.Errorf("--page must not be negative and --page-size must be positive")
+		}
 
 		if columnsFlag != "" {
 			cols := strings.Split(columnsFlag, ",")
@@ -40,16 +73,11 @@ from encrypted lockbox files.`,
 			}
 		}
 
-		// Get password if not provided
-		if password == "" {
-			fmt.Print("Enter password: ")
-			passwordBytes, err := term.ReadPassword(int(syscall.Stdin))
-			if err != nil {
-				return fmt.Errorf("failed to read password: %w", err)
-			}
-			password = string(passwordBytes)
-			fmt.Println() // New line after password input
+		password, err := readPassword(cmd)
+		if err != nil {
+			return err
 		}
+		stats := startThroughput("query")
 
 		// Open the lockbox
 		lb, err := lockbox.Open(filename, lockbox.WithPassword(password))
@@ -58,26 +86,85 @@ from encrypted lockbox files.`,
 		}
 		defer lb.Close()
 
-		ctx := context.Background()
+		ctx := cmd.Context()
 
 		// Execute query
-		result, err := lb.Query(ctx, sqlQuery, lockbox.WithPassword(password))
+		queryOpts := []lockbox.Option{lockbox.WithPassword(password), lockbox.WithPrincipal(principal), lockbox.WithSampleRows(sampleRows)}
+		for _, a := range queryArgs {
+			queryOpts = append(queryOpts, lockbox.WithArgs(a))
+		}
+		if asOf != "" {
+			opt, err := asOfOption(asOf)
+			if err != nil {
+				return err
+			}
+			queryOpts = append(queryOpts, opt)
+		}
+		csvOpts, err := csvOptions(cmd)
+		if err != nil {
+			return err
+		}
+
+		// Files, CSV, NDJSON and Arrow are written as the query runs, so
+		// large results are never held in memory whole
+		if page == 0 && (outputFile != "" || output == "csv" || output == "ndjson" || output == "arrow") {
+			rows, bytes, err := streamQueryOutput(cmd, lb, sqlQuery, output, outputFile, append(queryOpts, csvOpts...))
+			if err != nil {
+				return err
+			}
+			if outputFile != "" {
+				stats.Operation = "export"
+			}
+			stats.finishRows(rows, bytes)
+			return stats.print(os.Stderr, statsJSON)
+		}
+
+		var result arrow.Record
+		var pageInfo *lockbox.Page
+		if page > 0 {
+			pageInfo, err = lb.QueryPage(ctx, sqlQuery, (page-1)*pageSize, pageSize, queryOpts...)
+			if pageInfo != nil {
+				result = pageInfo.Record
+			}
+		} else {
+			result, err = lb.Query(ctx, sqlQuery, queryOpts...)
+		}
 		if err != nil {
 			return fmt.Errorf("failed to execute query: %w", err)
 		}
 		defer result.Release()
 
-		// Output results
-		switch output {
-		case "json":
-			return outputJSON(result)
-		case "csv":
-			return outputCSV(result)
-		case "table":
-			return outputTable(result)
-		default:
-			return outputTable(result)
+		if outputFile != "" {
+			stats.Operation = "export"
+			err = writeQueryOutputFile(result, outputFile, output, cmd.Flags().Changed("output"), csvOpts...)
+		} else {
+			// Output results
+			switch output {
+			case "json":
+				err = outputJSON(result)
+			case "csv":
+				err = outputCSV(result, csvOpts...)
+			case "ndjson", "arrow":
+				err = lockbox.ExportRecord(os.Stdout, result, stdoutFormat(output))
+			default:
+				err = outputTable(result)
+			}
+		}
+		if err != nil {
+			return err
 		}
+
+		// Paging and metrics go to stderr so they don't mix with the results
+		if pageInfo != nil {
+			pages := (pageInfo.Total + int64(pageSize) - 1) / int64(pageSize)
+			if result.NumRows() == 0 {
+				fmt.Fprintf(os.Stderr, "Page %d of %d is empty (%d rows)\n", page, pages, pageInfo.Total)
+			} else {
+				fmt.Fprintf(os.Stderr, "Page %d of %d: rows %d-%d of %d\n", page, pages, pageInfo.Offset+1, pageInfo.Offset+result.NumRows(), pageInfo.Total)
+			}
+		}
+		stats.finish(result)
+		return stats.print(os.Stderr, statsJSON)
 	},
 }
 
@@ -86,8 +173,116 @@ func init() {
 
 	queryCmd.Flags().StringP("sql", "q", "SELECT * FROM data", "SQL query to execute")
 	queryCmd.Flags().String("columns", "", "Column projection shorthand")
-	queryCmd.Flags().StringP("password", "p", "", "Password for decryption")
-	queryCmd.Flags().StringP("output", "o", "table", "Output format (table, json, csv)")
+	addPasswordFlags(queryCmd.Flags(), "Password for decryption")
+	queryCmd.Flags().StringP("output", "o", "table", "Output format (table, json, csv, ndjson, arrow; with --output-file also parquet)")
+	queryCmd.Flags().String("output-file", "", "Write results to this file instead of stdout")
+	addCSVFlags(queryCmd, true)
+	queryCmd.Flags().String("principal", "", "User or role the access policy is evaluated for")
+	queryCmd.Flags().Int("sample-rows", 0, "Query a uniform random sample of this many rows")
+	queryCmd.Flags().Int("page", 0, "Show this page of the results, counting from 1")
+	queryCmd.Flags().Int("page-size", 100, "Rows per page with --page")
+	queryCmd.Flags().StringArray("arg", nil, "Value for a ? placeholder in the query, in order (repeatable)")
+	queryCmd.Flags().String("as-of", "", "Read the file as of this snapshot ID or time")
+	addThroughputFlags(queryCmd)
+	addProfileFlags(queryCmd)
+}
+
+// queryOutputFormat returns the format of an output file: --output when it
+// was given explicitly, otherwise the one of the file extension
+func queryOutputFormat(path, output string, explicit bool) (lockbox.ExportFormat, error) {
+	if explicit {
+		return lockbox.ParseExportFormat(output)
+	}
+	if f, ok := lockbox.ExportFormatFromPath(path); ok {
+		return f, nil
+	}
+	return "", fmt.Errorf("cannot infer output format from %s; use --output", path)
+}
+
+// stdoutFormat is the export format of an --output written to stdout.
+// Arrow goes out in the stream format, which readers of a pipe consume
+// as it arrives.
+func stdoutFormat(output string) lockbox.ExportFormat {
+	switch output {
+	case "ndjson":
+		return lockbox.ExportJSON
+	case "arrow":
+		return lockbox.ExportArrowStream
+	}
+	return lockbox.ExportCSV
+}
+
+// streamQueryOutput runs a query with Lockbox.QueryTo, writing to the
+// --output-file or to stdout, and returns the rows and bytes written
+func streamQueryOutput(cmd *cobra.Command, lb *lockbox.Lockbox, query, output, path string, opts []lockbox.Option) (int64, int64, error) {
+	format := stdoutFormat(output)
+	var out io.Writer = os.Stdout
+	var f *os.File
+	if path != "" {
+		var err error
+		if format, err = queryOutputFormat(path, output, cmd.Flags().Changed("output")); err != nil {
+			return 0, 0, err
+		}
+		if f, err = os.Create(path); err != nil {
+			return 0, 0, fmt.Errorf("failed to create output file: %w", err)
+		}
+		out = f
+	}
+
+	cw := &countingWriter{w: out}
+	rows, err := lb.QueryTo(cmd.Context(), query, cw, format, opts...)
+	if f != nil {
+		// The Parquet and Arrow writers close the file themselves
+		if cerr := f.Close(); err == nil && cerr != nil && !errors.Is(cerr, os.ErrClosed) {
+			err = fmt.Errorf("failed to close output file: %w", cerr)
+		}
+		if err != nil {
+			os.Remove(path)
+		}
+	}
+	if err != nil {
+		return rows, cw.n, fmt.Errorf("failed to execute query: %w", err)
+	}
+	if f != nil {
+		fmt.Fprintf(os.Stderr, "Wrote %d rows to %s (%s)\n", rows, path, format)
+	}
+	return rows, cw.n, nil
+}
+
+// countingWriter counts the bytes written through it
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// writeQueryOutputFile writes query results to a file. The format comes from
+// --output when it was given explicitly, otherwise from the file extension.
+func writeQueryOutputFile(rec arrow.Record, path, output string, explicit bool, opts ...lockbox.Option) error {
+	format, err := queryOutputFormat(path, output, explicit)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("failed to create output file: %w", err)
+	}
+	if err := lockbox.ExportRecord(f, rec, format, opts...); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("failed to close output file: %w", err)
+	}
+
+	fmt.Fprintf(os.Stderr, "Wrote %d rows to %s (%s)\n", rec.NumRows(), path, format)
+	return nil
 }
 
 func outputTable(rec arrow.Record) error {
@@ -176,6 +371,11 @@ func outputJSON(rec arrow.Record) error {
 			if j > 0 {
 				fmt.Print(",")
 			}
+			if isNestedColumn(col) && col.IsValid(int(i)) {
+				// Structs, lists and maps print as JSON already
+				fmt.Printf("\"%s\":%s", schema.Field(j).Name, col.ValueStr(int(i)))
+				continue
+			}
 			fmt.Printf("\"%s\":\"%v\"", schema.Field(j).Name, getValue(col, int(i)))
 		}
 		fmt.Print("}")
@@ -185,36 +385,27 @@ func outputJSON(rec arrow.Record) error {
 	return nil
 }
 
-func outputCSV(rec arrow.Record) error {
-	schema := rec.Schema()
-
-	// Print header
-	for i, field := range schema.Fields() {
-		if i > 0 {
-			fmt.Print(",")
-		}
-		fmt.Print(field.Name)
-	}
-	fmt.Println()
+func outputCSV(rec arrow.Record, opts ...lockbox.Option) error {
+	return lockbox.ExportRecord(os.Stdout, rec, lockbox.ExportCSV, opts...)
+}
 
-	// Print data - simplified for MVP
-	for i := int64(0); i < rec.NumRows(); i++ {
-		for j, col := range rec.Columns() {
-			if j > 0 {
-				fmt.Print(",")
-			}
-			fmt.Printf("%v", getValue(col, int(i)))
-		}
-		fmt.Println()
+// isNestedColumn reports whether a column holds structs, lists or maps
+func isNestedColumn(col arrow.Array) bool {
+	switch col.(type) {
+	case *array.Struct, *array.List, *array.LargeList, *array.FixedSizeList, *array.Map:
+		return true
 	}
-
-	return nil
+	return false
 }
 
 func getValue(col arrow.Array, row int) interface{} {
 	if col.IsNull(row) {
 		return "NULL"
 	}
+	if isNestedColumn(col) {
+		// Compact JSON, e.g. {"city":"Oslo","zip":1}
+		return col.ValueStr(row)
+	}
 	switch c := col.(type) {
 	case *array.Int64:
 		val := c.Value(row)
@@ -239,7 +430,22 @@ func getValue(col arrow.Array, row int) interface{} {
 		default:
 			return ts
 		}
+	case *array.Decimal128, *array.Duration:
+		// 12.50 rather than 12.5, 1m30s rather than 90s
+		return lockbox.Value(col, row)
 	default:
-		return "NULL"
+		return col.ValueStr(row)
+	}
+}
+
+// asOfOption returns the option reading as of a snapshot ID or time
+func asOfOption(v string) (lockbox.Option, error) {
+	if id, err := strconv.ParseInt(v, 10, 64); err == nil {
+		return lockbox.WithAsOfSnapshot(id), nil
+	}
+	t, err := lockbox.ParseAsOfTime(v)
+	if err != nil {
+		return nil, fmt.Errorf("--as-of: %w", err)
 	}
+	return lockbox.WithAsOfTime(t), nil
 }
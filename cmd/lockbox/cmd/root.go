@@ -1,8 +1,12 @@
 package cmd
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"os"
 
+	"github.com/TFMV/lockbox/pkg/lockbox"
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
 	"github.com/spf13/cobra"
@@ -30,13 +34,26 @@ that doesn't compromise on performance, security, or developer experience.`,
 		} else {
 			zerolog.SetGlobalLevel(zerolog.InfoLevel)
 		}
+		log.Debug().Strs("args", redactArgs(os.Args)).Msg("Command line")
+
+		// Strict security is applied by the library to every open and create
+		if viper.GetBool("strict-security") {
+			os.Setenv(lockbox.StrictSecurityEnv, "1")
+		}
+
+		applyTimeout(cmd)
 	},
 }
 
 // Execute adds all child commands to the root command and sets flags appropriately.
 // This is called by main.main(). It only needs to happen once to the rootCmd.
 func Execute() error {
-	return rootCmd.Execute()
+	err := rootCmd.Execute()
+	stopTimeout()
+	if errors.Is(err, context.DeadlineExceeded) {
+		return fmt.Errorf("timed out after %s: %w", viper.GetDuration("timeout"), err)
+	}
+	return err
 }
 
 func init() {
@@ -45,11 +62,23 @@ func init() {
 	// Global flags
 	rootCmd.PersistentFlags().StringVar(&cfgFile, "config", "", "config file (default is $HOME/.lockbox.yaml)")
 	rootCmd.PersistentFlags().BoolVarP(&verbose, "verbose", "v", false, "verbose output")
+	rootCmd.PersistentFlags().String("profile", "", "settings profile from the config file applied to new files")
+	rootCmd.PersistentFlags().Bool("strict-security", false, "refuse to open or create files with legacy or weak security settings")
+	rootCmd.PersistentFlags().Duration("timeout", 0, "fail the command when it runs longer than this, e.g. 30s (default no limit)")
 
 	// Bind flags to viper
 	if err := viper.BindPFlag("verbose", rootCmd.PersistentFlags().Lookup("verbose")); err != nil {
 		log.Fatal().Err(err).Msg("Failed to bind verbose flag")
 	}
+	if err := viper.BindPFlag("profile", rootCmd.PersistentFlags().Lookup("profile")); err != nil {
+		log.Fatal().Err(err).Msg("Failed to bind profile flag")
+	}
+	if err := viper.BindPFlag("strict-security", rootCmd.PersistentFlags().Lookup("strict-security")); err != nil {
+		log.Fatal().Err(err).Msg("Failed to bind strict-security flag")
+	}
+	if err := viper.BindPFlag("timeout", rootCmd.PersistentFlags().Lookup("timeout")); err != nil {
+		log.Fatal().Err(err).Msg("Failed to bind timeout flag")
+	}
 }
 
 // initConfig reads in config file and ENV variables if set.
@@ -0,0 +1,401 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/TFMV/lockbox/pkg/lockbox"
+	"github.com/TFMV/lockbox/pkg/metadata"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+)
+
+var serveCmd = &cobra.Command{
+	Use:   "serve [--flight] [--http] [name=]lockbox-file... | --dir root",
+	Short: "Serve lockboxes to other tools",
+	Long: `Serve lockboxes over Apache Arrow Flight (gRPC) with --flight, over HTTP
+with --http, or both, so BI tools, clients such as pyarrow and plain HTTP
+clients read them without the Go SDK. Each file is served as a table named
+after the file, or the name given before "="; all files share one
+password. Data is decrypted by the server.
+
+Clients log in with a user name and password, given with --user as the
+name and the environment variable holding its password, and get a bearer
+token for later requests. Reads run as that user, so access policies
+apply, and every request on a table is recorded in the file's audit
+trail, in batches written every minute and on shutdown. Files are opened
+once at startup, so rows written to them later are served after a
+restart. Passwords cross the network, so serve with --tls-cert and
+--tls-key unless clients connect through localhost.
+
+--dir serves every .lbx file under a directory as a table named by its
+relative path without the extension, e.g. "risk/trades". With --tenants,
+the directory hosts several teams in one process: a YAML file lists each
+tenant's path prefix, its users ("*" for every user), where the password
+of its files comes from (env:VAR, keychain:NAME or file:PATH), its quota
+per window, policy rules applied on top of each file's access policy and
+a file its requests are appended to as JSON lines. Users only see the
+tables of their tenants, and a tenant over its quota gets 429 Too Many
+Requests, or RESOURCE_EXHAUSTED over Flight, until the window ends. Files under no
+tenant's prefix are not served. Without --tenants, the files of --dir
+share the password like named files do.
+
+  tenants:
+    - name: risk
+      prefix: risk/
+      users: [ana, raj]
+      key: env:RISK_PW
+      quota: {window: 1m, requests: 600, rows: 10000000}
+      policy:
+        - name: mask_ssn
+          principals: [raj]
+          masks: {ssn: "'***'"}
+      audit: /var/log/lockbox/risk.ndjson
+
+A Flight ticket, or the JSON body of POST /query, selects rows:
+  {"table": "sales", "columns": ["id", "amount"], "filter": "amount > 100"}
+  {"table": "sales", "query": "SELECT region, SUM(amount) FROM data GROUP BY region"}
+
+HTTP endpoints answer JSON, or Arrow IPC streams with format=arrow or an
+Accept header of ` + lockbox.ArrowStreamType + `:
+  POST /login                         a bearer token for the basic auth user
+  GET  /tables                        the served tables
+  GET  /info?table=sales              file information
+  GET  /schema?table=sales            the schema
+  GET  /query?table=sales&sql=...     rows; columns= and filter= scan instead
+  POST /query                         rows selected by a JSON body
+
+Examples:
+  ANALYST_PW=... lockbox serve --flight sales.lbx --user analyst=ANALYST_PW --password-env LOCKBOX_PW
+  lockbox serve --http --http-addr :8080 orders=data/orders.lbx --user bi=BI_PW --tls-cert cert.pem --tls-key key.pem
+  curl -u bi:$BI_PW 'https://localhost:8080/query?table=orders&sql=SELECT+COUNT(*)+FROM+data'
+  lockbox serve --http --dir /srv/lockbox --tenants tenants.yaml --user ana=ANA_PW --user raj=RAJ_PW`,
+	Args: cobra.ArbitraryArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		useFlight, _ := cmd.Flags().GetBool("flight")
+		useHTTP, _ := cmd.Flags().GetBool("http")
+		addr, _ := cmd.Flags().GetString("addr")
+		httpAddr, _ := cmd.Flags().GetString("http-addr")
+		users, _ := cmd.Flags().GetStringArray("user")
+		ttl, _ := cmd.Flags().GetDuration("token-ttl")
+		certFile, _ := cmd.Flags().GetString("tls-cert")
+		keyFile, _ := cmd.Flags().GetString("tls-key")
+		dir, _ := cmd.Flags().GetString("dir")
+		tenantsPath, _ := cmd.Flags().GetString("tenants")
+		if len(args) == 0 && dir == "" {
+			return fmt.Errorf("name the lockbox files to serve or a directory with --dir")
+		}
+		if tenantsPath != "" && dir == "" {
+			return fmt.Errorf("--tenants requires --dir")
+		}
+		if !useFlight && !useHTTP {
+			return fmt.Errorf("choose what to serve with --flight, --http or both")
+		}
+		if len(users) == 0 {
+			return fmt.Errorf("at least one --user is required")
+		}
+		if (certFile == "") != (keyFile == "") {
+			return fmt.Errorf("--tls-cert and --tls-key must be given together")
+		}
+
+		// Tenants bring their own passwords; others share one
+		var password string
+		if len(args) > 0 || tenantsPath == "" {
+			pw, err := readPassword(cmd)
+			if err != nil {
+				return err
+			}
+			password = pw
+		}
+
+		tables := lockbox.NewServedTables(ttl)
+		// Closing writes the audit entries still buffered
+		defer func() {
+			if err := tables.Close(); err != nil {
+				log.Warn().Err(err).Msg("Failed to close served tables")
+			}
+		}()
+		for _, arg := range args {
+			name, path := servedTable(arg)
+			if err := tables.AddTable(name, path, password); err != nil {
+				return fmt.Errorf("failed to serve %s: %w", path, err)
+			}
+		}
+		if dir != "" {
+			tenants := []*lockbox.Tenant{{Name: "default", Users: []string{lockbox.AllUsers}, Keys: lockbox.StaticPassword(password)}}
+			if tenantsPath != "" {
+				var closeAudit func()
+				var err error
+				if tenants, closeAudit, err = loadTenants(tenantsPath); err != nil {
+					return err
+				}
+				defer closeAudit()
+			}
+			for _, t := range tenants {
+				if err := tables.AddTenant(t); err != nil {
+					return err
+				}
+			}
+			n, err := tables.AddDirectory(dir)
+			if err != nil {
+				return err
+			}
+			log.Info().Str("dir", dir).Int("tables", n).Int("tenants", len(tenants)).Msg("Serving directory")
+		}
+		for _, u := range users {
+			name, env, ok := strings.Cut(u, "=")
+			if !ok || name == "" || env == "" {
+				return fmt.Errorf("invalid --user %q: expected name=ENVVAR", u)
+			}
+			pw, ok := os.LookupEnv(env)
+			if !ok || pw == "" {
+				return fmt.Errorf("environment variable %s is not set", env)
+			}
+			tables.AddUser(name, pw)
+		}
+
+		var tlsConfig *tls.Config
+		if certFile != "" {
+			cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+			if err != nil {
+				return fmt.Errorf("failed to load TLS certificate: %w", err)
+			}
+			tlsConfig = &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}
+		} else {
+			log.Warn().Msg("Serving without TLS: passwords and data cross the network in the clear")
+		}
+
+		var listeners []net.Listener
+		defer func() {
+			for _, l := range listeners {
+				l.Close()
+			}
+		}()
+		listen := func(addr string) (net.Listener, error) {
+			l, err := net.Listen("tcp", addr)
+			if err == nil {
+				listeners = append(listeners, l)
+			}
+			return l, err
+		}
+
+		ctx, stop := signal.NotifyContext(cmd.Context(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		errs := make(chan error, 2)
+		servers := 0
+
+		if useFlight {
+			l, err := listen(addr)
+			if err != nil {
+				return err
+			}
+			server := lockbox.NewFlightServer(tables)
+			go func() {
+				<-ctx.Done()
+				server.Shutdown()
+			}()
+			servers++
+			go func() {
+				if err := server.Serve(l, tlsConfig); err != nil {
+					err = fmt.Errorf("flight server failed: %w", err)
+				}
+				errs <- err
+			}()
+			log.Info().Str("addr", l.Addr().String()).Int("tables", len(tables.Names())).Msg("Flight server started")
+		}
+		if useHTTP {
+			l, err := listen(httpAddr)
+			if err != nil {
+				stop()
+				return err
+			}
+			server := lockbox.NewHTTPServer(tables)
+			go func() {
+				<-ctx.Done()
+				shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+				defer cancel()
+				server.Shutdown(shutdownCtx)
+			}()
+			servers++
+			go func() {
+				if err := server.Serve(l, tlsConfig); err != nil {
+					err = fmt.Errorf("http server failed: %w", err)
+				}
+				errs <- err
+			}()
+			log.Info().Str("addr", l.Addr().String()).Int("tables", len(tables.Names())).Msg("HTTP server started")
+		}
+
+		// One server failing stops the others
+		var failed error
+		for i := 0; i < servers; i++ {
+			if err := <-errs; err != nil && failed == nil {
+				failed = err
+				stop()
+			}
+		}
+		tables.Logout()
+		if failed != nil {
+			return failed
+		}
+		log.Info().Msg("Servers stopped")
+		return nil
+	},
+}
+
+// servedTable splits a [name=]file argument, naming the table after the
+// file when no name is given
+func servedTable(arg string) (string, string) {
+	if name, path, ok := strings.Cut(arg, "="); ok && name != "" {
+		return name, path
+	}
+	return strings.TrimSuffix(filepath.Base(arg), filepath.Ext(arg)), arg
+}
+
+// tenantConfig is a tenant in the file of --tenants
+type tenantConfig struct {
+	Name   string   `yaml:"name"`
+	Prefix string   `yaml:"prefix"`
+	Users  []string `yaml:"users"`
+	Key    string   `yaml:"key"`
+	Quota  struct {
+		Window   time.Duration `yaml:"window"`
+		Requests int           `yaml:"requests"`
+		Rows     int64         `yaml:"rows"`
+	} `yaml:"quota"`
+	Policy []tenantRule `yaml:"policy"`
+	Audit  string       `yaml:"audit"`
+}
+
+// tenantRule is a policy rule of a tenant
+type tenantRule struct {
+	Name       string            `yaml:"name"`
+	Principals []string          `yaml:"principals"`
+	Actions    []string          `yaml:"actions"`
+	Condition  string            `yaml:"condition"`
+	RowFilter  string            `yaml:"rowFilter"`
+	Masks      map[string]string `yaml:"masks"`
+}
+
+// loadTenants reads the tenants of a --tenants file and opens their audit
+// streams, which the returned function closes
+func loadTenants(path string) ([]*lockbox.Tenant, func(), error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read tenants: %w", err)
+	}
+	var config struct {
+		Tenants []tenantConfig `yaml:"tenants"`
+	}
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(&config); err != nil {
+		return nil, nil, fmt.Errorf("invalid tenants file %s: %w", path, err)
+	}
+	if len(config.Tenants) == 0 {
+		return nil, nil, fmt.Errorf("tenants file %s lists no tenants", path)
+	}
+
+	var files []*os.File
+	closeAll := func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}
+	tenants := make([]*lockbox.Tenant, 0, len(config.Tenants))
+	for _, c := range config.Tenants {
+		// Tenants are closed to users they don't list; "*" opens one to all
+		if len(c.Users) == 0 {
+			closeAll()
+			return nil, nil, fmt.Errorf("tenant %s lists no users", c.Name)
+		}
+		password, err := tenantPassword(c.Key)
+		if err != nil {
+			closeAll()
+			return nil, nil, fmt.Errorf("tenant %s: %w", c.Name, err)
+		}
+		t := &lockbox.Tenant{
+			Name:   c.Name,
+			Prefix: c.Prefix,
+			Users:  c.Users,
+			Keys:   lockbox.StaticPassword(password),
+			Quota:  lockbox.Quota{Window: c.Quota.Window, Requests: c.Quota.Requests, Rows: c.Quota.Rows},
+		}
+		for _, r := range c.Policy {
+			t.Policy = append(t.Policy, metadata.PolicyRule{
+				Name:       r.Name,
+				Principals: r.Principals,
+				Actions:    r.Actions,
+				Condition:  r.Condition,
+				RowFilter:  r.RowFilter,
+				Masks:      r.Masks,
+			})
+		}
+		if c.Audit != "" {
+			f, err := os.OpenFile(c.Audit, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+			if err != nil {
+				closeAll()
+				return nil, nil, fmt.Errorf("tenant %s: failed to open audit stream: %w", c.Name, err)
+			}
+			files = append(files, f)
+			t.Audit = f
+		}
+		tenants = append(tenants, t)
+	}
+	return tenants, closeAll, nil
+}
+
+// tenantPassword reads a tenant's password from env:VAR, keychain:NAME or
+// file:PATH
+func tenantPassword(key string) (string, error) {
+	kind, ref, _ := strings.Cut(key, ":")
+	if ref == "" {
+		return "", fmt.Errorf("invalid key %q: expected env:VAR, keychain:NAME or file:PATH", key)
+	}
+	switch kind {
+	case "env":
+		password, ok := os.LookupEnv(ref)
+		if !ok || password == "" {
+			return "", fmt.Errorf("environment variable %s is not set", ref)
+		}
+		return password, nil
+	case "keychain":
+		return keychainPassword(ref)
+	case "file":
+		data, err := os.ReadFile(ref)
+		if err != nil {
+			return "", fmt.Errorf("failed to read password file: %w", err)
+		}
+		password := strings.TrimRight(string(data), "\r\n")
+		if password == "" {
+			return "", fmt.Errorf("password file %s is empty", ref)
+		}
+		return password, nil
+	}
+	return "", fmt.Errorf("invalid key %q: expected env:VAR, keychain:NAME or file:PATH", key)
+}
+
+func init() {
+	rootCmd.AddCommand(serveCmd)
+	serveCmd.Flags().Bool("flight", false, "Serve over Apache Arrow Flight")
+	serveCmd.Flags().String("addr", ":8815", "Address to serve Flight on")
+	serveCmd.Flags().Bool("http", false, "Serve over HTTP")
+	serveCmd.Flags().String("http-addr", ":8080", "Address to serve HTTP on")
+	serveCmd.Flags().StringArray("user", nil, "User allowed to log in, as name=ENVVAR with the password in ENVVAR (repeatable)")
+	serveCmd.Flags().Duration("token-ttl", lockbox.DefaultTokenTTL, "How long a login stays valid")
+	serveCmd.Flags().String("tls-cert", "", "TLS certificate file")
+	serveCmd.Flags().String("tls-key", "", "TLS private key file")
+	serveCmd.Flags().String("dir", "", "Serve every lockbox file under this directory")
+	serveCmd.Flags().String("tenants", "", "YAML file of the tenants of --dir, with their prefixes, users, keys, quotas and audit streams")
+	addPasswordFlags(serveCmd.Flags(), "Password for the lockboxes")
+}
@@ -0,0 +1,132 @@
+package cmd
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/TFMV/lockbox/pkg/lockbox"
+	"github.com/spf13/cobra"
+)
+
+var tagCmd = &cobra.Command{
+	Use:   "tag",
+	Short: "Manage tags and the description of a lockbox",
+	Long: `Manage free-form tags and a description used to organize lockboxes.
+
+Tags and the description are stored in the clear: "info --no-decrypt"
+and "catalog index" read them without a password. Do not put secrets in
+them.
+
+Examples:
+  lockbox tag add data.lbx env=prod owner=data-eng
+  lockbox tag add data.lbx --description "Payroll exports from HR"
+  lockbox tag remove data.lbx owner`,
+}
+
+var tagAddCmd = &cobra.Command{
+	Use:   "add [lockbox-file] [key=value...]",
+	Short: "Add or update tags",
+	Args:  cobra.MinimumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		tags, err := lockbox.ParseTags(args[1:])
+		if err != nil {
+			return err
+		}
+		setDescription := cmd.Flags().Changed("description")
+		if len(tags) == 0 && !setDescription {
+			return fmt.Errorf("no tags given")
+		}
+
+		password, err := readPassword(cmd)
+		if err != nil {
+			return err
+		}
+		lb, err := lockbox.Open(args[0], lockbox.WithPassword(password))
+		if err != nil {
+			return fmt.Errorf("failed to open lockbox: %w", err)
+		}
+		defer lb.Close()
+
+		if err := lb.SetTags(tags); err != nil {
+			return fmt.Errorf("failed to set tags: %w", err)
+		}
+		if setDescription {
+			description, _ := cmd.Flags().GetString("description")
+			if err := lb.SetDescription(description); err != nil {
+				return fmt.Errorf("failed to set description: %w", err)
+			}
+		}
+
+		fmt.Printf("Updated tags of %s\n", args[0])
+		return nil
+	},
+}
+
+var tagRemoveCmd = &cobra.Command{
+	Use:   "remove [lockbox-file] [key...]",
+	Short: "Remove tags",
+	Args:  cobra.MinimumNArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		password, err := readPassword(cmd)
+		if err != nil {
+			return err
+		}
+		lb, err := lockbox.Open(args[0], lockbox.WithPassword(password))
+		if err != nil {
+			return fmt.Errorf("failed to open lockbox: %w", err)
+		}
+		defer lb.Close()
+
+		if err := lb.RemoveTags(args[1:]...); err != nil {
+			return fmt.Errorf("failed to remove tags: %w", err)
+		}
+		fmt.Printf("Removed %d tags from %s\n", len(args)-1, args[0])
+		return nil
+	},
+}
+
+var tagListCmd = &cobra.Command{
+	Use:   "list [lockbox-file]",
+	Short: "List tags and the description (no password needed)",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		info, err := lockbox.ReadInfo(args[0])
+		if err != nil {
+			return fmt.Errorf("failed to read lockbox: %w", err)
+		}
+		if info.Description != "" {
+			fmt.Printf("Description: %s\n", info.Description)
+		}
+		if len(info.Tags) == 0 {
+			fmt.Println("No tags defined")
+			return nil
+		}
+		for _, tag := range formatTags(info.Tags) {
+			fmt.Println(tag)
+		}
+		return nil
+	},
+}
+
+// formatTags renders tags as sorted key=value strings
+func formatTags(tags map[string]string) []string {
+	list := make([]string, 0, len(tags))
+	for key, value := range tags {
+		if value == "" {
+			list = append(list, key)
+		} else {
+			list = append(list, key+"="+value)
+		}
+	}
+	sort.Strings(list)
+	return list
+}
+
+func init() {
+	rootCmd.AddCommand(tagCmd)
+	tagCmd.AddCommand(tagAddCmd, tagRemoveCmd, tagListCmd)
+
+	addPasswordFlags(tagAddCmd.Flags(), "Password for the lockbox")
+	tagAddCmd.Flags().String("description", "", "Set the description; an empty value removes it")
+	addPasswordFlags(tagRemoveCmd.Flags(), "Password for the lockbox")
+}
@@ -0,0 +1,88 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/apache/arrow-go/v18/arrow"
+	"github.com/spf13/cobra"
+)
+
+// throughput reports how fast a command moved its data
+type throughput struct {
+	Operation      string  `json:"operation"`
+	Rows           int64   `json:"rows"`
+	Bytes          int64   `json:"bytes"`
+	DurationMillis int64   `json:"durationMs"`
+	RowsPerSecond  float64 `json:"rowsPerSecond"`
+	MBPerSecond    float64 `json:"mbPerSecond"`
+	PeakMemory     int64   `json:"peakMemoryBytes"`
+
+	start time.Time
+}
+
+// startThroughput starts timing an operation
+func startThroughput(operation string) *throughput {
+	return &throughput{Operation: operation, start: time.Now()}
+}
+
+// finish records the rows and Arrow bytes of rec as the operation's output
+func (t *throughput) finish(rec arrow.Record) {
+	t.finishRows(rec.NumRows(), recordBytes(rec))
+}
+
+// finishRows records rows and bytes streamed as the operation's output
+func (t *throughput) finishRows(rows, bytes int64) {
+	elapsed := time.Since(t.start)
+	t.Rows = rows
+	t.Bytes = bytes
+	t.DurationMillis = elapsed.Milliseconds()
+	if secs := elapsed.Seconds(); secs > 0 {
+		t.RowsPerSecond = float64(t.Rows) / secs
+		t.MBPerSecond = float64(t.Bytes) / (1 << 20) / secs
+	}
+	t.PeakMemory = peakMemory()
+}
+
+// recordBytes sums the buffer sizes of rec's columns
+func recordBytes(rec arrow.Record) int64 {
+	var n int64
+	for _, col := range rec.Columns() {
+		n += arrayDataBytes(col.Data())
+	}
+	return n
+}
+
+func arrayDataBytes(data arrow.ArrayData) int64 {
+	var n int64
+	for _, buf := range data.Buffers() {
+		if buf != nil {
+			n += int64(buf.Len())
+		}
+	}
+	for _, child := range data.Children() {
+		n += arrayDataBytes(child)
+	}
+	if data.DataType().ID() == arrow.DICTIONARY {
+		n += arrayDataBytes(data.Dictionary())
+	}
+	return n
+}
+
+// print writes the metrics as one line, or as JSON when asJSON is set
+func (t *throughput) print(w io.Writer, asJSON bool) error {
+	if asJSON {
+		return json.NewEncoder(w).Encode(t)
+	}
+	_, err := fmt.Fprintf(w, "%s: %d rows, %.1f MB in %s (%.0f rows/s, %.1f MB/s), peak memory %.1f MB\n",
+		t.Operation, t.Rows, float64(t.Bytes)/(1<<20), time.Duration(t.DurationMillis)*time.Millisecond,
+		t.RowsPerSecond, t.MBPerSecond, float64(t.PeakMemory)/(1<<20))
+	return err
+}
+
+// addThroughputFlags adds the flag selecting JSON throughput output
+func addThroughputFlags(cmd *cobra.Command) {
+	cmd.Flags().Bool("json", false, "Print throughput metrics as JSON")
+}
@@ -0,0 +1,13 @@
+//go:build !(linux || darwin || freebsd || netbsd || openbsd)
+
+package cmd
+
+import "runtime"
+
+// peakMemory returns the memory the Go runtime obtained from the system,
+// the closest available bound on the process's peak
+func peakMemory() int64 {
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	return int64(ms.Sys)
+}
@@ -0,0 +1,41 @@
+package cmd
+
+import (
+	"context"
+	"os"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// timeoutGrace is how long a command may keep running after its deadline
+// to stop at a safe point before lockbox exits anyway
+const timeoutGrace = 5 * time.Second
+
+// stopTimeout releases the deadline and watchdog set up by applyTimeout
+var stopTimeout = func() {}
+
+// applyTimeout puts the deadline of --timeout on the context of cmd.
+// Operations that take the context fail with context.DeadlineExceeded once
+// it passes. IO that cannot be interrupted, such as a read from a stuck
+// network mount, is left behind by a watchdog that exits the process
+// timeoutGrace after the deadline.
+func applyTimeout(cmd *cobra.Command) {
+	timeout := viper.GetDuration("timeout")
+	if timeout <= 0 {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(cmd.Context(), timeout)
+	cmd.SetContext(ctx)
+	watchdog := time.AfterFunc(timeout+timeoutGrace, func() {
+		log.Error().Dur("timeout", timeout).Msg("Command did not stop after its timeout; exiting")
+		os.Exit(1)
+	})
+	stopTimeout = func() {
+		watchdog.Stop()
+		cancel()
+	}
+}
@@ -0,0 +1,89 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/TFMV/lockbox/pkg/lockbox"
+	"github.com/spf13/cobra"
+)
+
+var verifyCmd = &cobra.Command{
+	Use:   "verify [lockbox-file...]",
+	Short: "Verify the signatures of every block",
+	Long: `Check the checksum and signature of every data block, and of the blocks
+holding materialized view results, against the signing key derived from the
+password. Blocks are signed with Ed25519 when they are written, so a block
+replaced or altered by someone without the password fails.
+
+Files created before block signatures have nothing to verify; migrate signs
+the blocks of the copy. The command fails for such files and for any block
+that fails, so it can gate pipelines.
+
+Example:
+  lockbox verify data.lbx`,
+	Args: cobra.MinimumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		output, _ := cmd.Flags().GetString("output")
+
+		password, err := readPassword(cmd)
+		if err != nil {
+			return err
+		}
+
+		ctx := cmd.Context()
+		failed := 0
+		for _, path := range args {
+			lb, err := lockbox.Open(path, lockbox.WithPassword(password))
+			if err != nil {
+				return fmt.Errorf("failed to open lockbox %s: %w", path, err)
+			}
+			report, err := lb.VerifySignatures(ctx, lockbox.WithPassword(password))
+			lb.Close()
+			if err != nil {
+				return fmt.Errorf("failed to verify %s: %w", path, err)
+			}
+
+			if output == "json" {
+				enc := json.NewEncoder(os.Stdout)
+				enc.SetIndent("", "  ")
+				if err := enc.Encode(report); err != nil {
+					return err
+				}
+			} else {
+				printSignatureReport(report)
+			}
+			if !report.Valid() {
+				failed++
+			}
+		}
+		if failed > 0 {
+			return fmt.Errorf("%d of %d files failed verification", failed, len(args))
+		}
+		return nil
+	},
+}
+
+func printSignatureReport(r *lockbox.SignatureReport) {
+	fmt.Printf("%s\n", r.File)
+	switch {
+	case r.Scheme == "":
+		fmt.Println("  Blocks are not signed; migrate the file to sign them")
+	case len(r.Invalid) == 0:
+		fmt.Printf("  %d blocks verified (%s)\n", r.Blocks, r.Scheme)
+	default:
+		fmt.Printf("  %d of %d blocks failed verification (%s):\n", len(r.Invalid), r.Blocks, r.Scheme)
+		for _, block := range r.Invalid {
+			fmt.Printf("    %s\n", block)
+		}
+	}
+}
+
+func init() {
+	rootCmd.AddCommand(verifyCmd)
+
+	addPasswordFlags(verifyCmd.Flags(), "Password for the lockboxes")
+	verifyCmd.Flags().StringP("output", "o", "table", "Output format (table, json)")
+	addProfileFlags(verifyCmd)
+}
@@ -0,0 +1,147 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/TFMV/lockbox/pkg/lockbox"
+	"github.com/spf13/cobra"
+)
+
+var viewCmd = &cobra.Command{
+	Use:   "view",
+	Short: "Manage saved views in a lockbox file",
+	Long: `Manage named queries stored in the lockbox metadata.
+
+Views can be queried like tables, for example:
+  lockbox query data.lbx -q "SELECT * FROM active_users"`,
+}
+
+var viewCreateCmd = &cobra.Command{
+	Use:   "create [lockbox-file] [name] [query]",
+	Short: "Save a named query as a view",
+	Args:  cobra.ExactArgs(3),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		filename, name, query := args[0], args[1], args[2]
+		createdBy, _ := cmd.Flags().GetString("created-by")
+		materialized, _ := cmd.Flags().GetBool("materialized")
+
+		password, err := readPassword(cmd)
+		if err != nil {
+			return err
+		}
+
+		lb, err := lockbox.Open(filename, lockbox.WithPassword(password))
+		if err != nil {
+			return fmt.Errorf("failed to open lockbox: %w", err)
+		}
+		defer lb.Close()
+
+		err = lb.CreateView(name, query,
+			lockbox.WithCreatedBy(createdBy),
+			lockbox.WithMaterialized(materialized),
+			lockbox.WithPassword(password),
+		)
+		if err != nil {
+			return fmt.Errorf("failed to create view: %w", err)
+		}
+
+		fmt.Printf("Created view %s\n", name)
+		return nil
+	},
+}
+
+var viewListCmd = &cobra.Command{
+	Use:   "list [lockbox-file]",
+	Short: "List saved views",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		password, err := readPassword(cmd)
+		if err != nil {
+			return err
+		}
+
+		lb, err := lockbox.Open(args[0], lockbox.WithPassword(password))
+		if err != nil {
+			return fmt.Errorf("failed to open lockbox: %w", err)
+		}
+		defer lb.Close()
+
+		views := lb.Views()
+		if len(views) == 0 {
+			fmt.Println("No views defined")
+			return nil
+		}
+		for _, v := range views {
+			kind := "view"
+			if v.Materialized {
+				kind = "materialized"
+			}
+			fmt.Printf("%s\t%s\t%s\n", v.Name, kind, v.Query)
+		}
+		return nil
+	},
+}
+
+var viewDropCmd = &cobra.Command{
+	Use:   "drop [lockbox-file] [name]",
+	Short: "Remove a saved view",
+	Args:  cobra.ExactArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		password, err := readPassword(cmd)
+		if err != nil {
+			return err
+		}
+
+		lb, err := lockbox.Open(args[0], lockbox.WithPassword(password))
+		if err != nil {
+			return fmt.Errorf("failed to open lockbox: %w", err)
+		}
+		defer lb.Close()
+
+		if err := lb.DropView(args[1]); err != nil {
+			return err
+		}
+
+		fmt.Printf("Dropped view %s\n", args[1])
+		return nil
+	},
+}
+
+var viewRefreshCmd = &cobra.Command{
+	Use:   "refresh [lockbox-file] [name...]",
+	Short: "Recompute materialized views",
+	Long: `Recompute the stored results of materialized views.
+
+Materialized views are refreshed automatically after each write; use this
+command to refresh them on demand. Without names every materialized view
+is refreshed.`,
+	Args: cobra.MinimumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		password, err := readPassword(cmd)
+		if err != nil {
+			return err
+		}
+
+		lb, err := lockbox.Open(args[0], lockbox.WithPassword(password))
+		if err != nil {
+			return fmt.Errorf("failed to open lockbox: %w", err)
+		}
+		defer lb.Close()
+
+		if err := lb.RefreshViews(cmd.Context(), args[1:], lockbox.WithPassword(password)); err != nil {
+			return fmt.Errorf("failed to refresh views: %w", err)
+		}
+
+		fmt.Println("Refreshed materialized views")
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(viewCmd)
+	viewCmd.AddCommand(viewCreateCmd, viewListCmd, viewDropCmd, viewRefreshCmd)
+
+	addPasswordFlags(viewCmd.PersistentFlags(), "Password for the lockbox")
+	viewCreateCmd.Flags().String("created-by", "system", "Creator name")
+	viewCreateCmd.Flags().Bool("materialized", false, "Store the view result and refresh it on every write")
+}
@@ -0,0 +1,103 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/TFMV/lockbox/pkg/lockbox"
+	"github.com/spf13/cobra"
+)
+
+var virtualCmd = &cobra.Command{
+	Use:   "virtual",
+	Short: "Manage virtual (computed) columns",
+	Long: `Manage columns that are computed from an expression at read time.
+
+Virtual columns are stored as definitions in the metadata and can be used
+in SELECT lists and WHERE clauses like regular columns, for example:
+  lockbox virtual add data.lbx full_name "first || ' ' || last"`,
+}
+
+var virtualAddCmd = &cobra.Command{
+	Use:   "add [lockbox-file] [name] [expression]",
+	Short: "Define a virtual column",
+	Args:  cobra.ExactArgs(3),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		password, err := readPassword(cmd)
+		if err != nil {
+			return err
+		}
+
+		lb, err := lockbox.Open(args[0], lockbox.WithPassword(password))
+		if err != nil {
+			return fmt.Errorf("failed to open lockbox: %w", err)
+		}
+		defer lb.Close()
+
+		if err := lb.AddVirtualColumn(args[1], args[2]); err != nil {
+			return fmt.Errorf("failed to add virtual column: %w", err)
+		}
+
+		fmt.Printf("Added virtual column %s\n", args[1])
+		return nil
+	},
+}
+
+var virtualListCmd = &cobra.Command{
+	Use:   "list [lockbox-file]",
+	Short: "List virtual columns",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		password, err := readPassword(cmd)
+		if err != nil {
+			return err
+		}
+
+		lb, err := lockbox.Open(args[0], lockbox.WithPassword(password))
+		if err != nil {
+			return fmt.Errorf("failed to open lockbox: %w", err)
+		}
+		defer lb.Close()
+
+		columns := lb.VirtualColumns()
+		if len(columns) == 0 {
+			fmt.Println("No virtual columns defined")
+			return nil
+		}
+		for _, vc := range columns {
+			fmt.Printf("%s\t%s\n", vc.Name, vc.Expression)
+		}
+		return nil
+	},
+}
+
+var virtualDropCmd = &cobra.Command{
+	Use:   "drop [lockbox-file] [name]",
+	Short: "Remove a virtual column",
+	Args:  cobra.ExactArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		password, err := readPassword(cmd)
+		if err != nil {
+			return err
+		}
+
+		lb, err := lockbox.Open(args[0], lockbox.WithPassword(password))
+		if err != nil {
+			return fmt.Errorf("failed to open lockbox: %w", err)
+		}
+		defer lb.Close()
+
+		if err := lb.DropVirtualColumn(args[1]); err != nil {
+			return err
+		}
+
+		fmt.Printf("Dropped virtual column %s\n", args[1])
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(virtualCmd)
+	virtualCmd.AddCommand(virtualAddCmd, virtualListCmd, virtualDropCmd)
+
+	addPasswordFlags(virtualCmd.PersistentFlags(), "Password for the lockbox")
+}
@@ -2,27 +2,20 @@ package cmd
 
 import (
 	"context"
-	"encoding/csv"
-	"encoding/json"
-	"errors"
 	"fmt"
-	"io"
 	"os"
 	"os/exec"
-	"strconv"
+	"path/filepath"
 	"strings"
-	"syscall"
-	"time"
 
 	"github.com/TFMV/lockbox/pkg/lockbox"
+	"github.com/TFMV/lockbox/pkg/metadata"
 	"github.com/apache/arrow-go/v18/arrow"
 	"github.com/apache/arrow-go/v18/arrow/array"
 	"github.com/apache/arrow-go/v18/arrow/memory"
 	"github.com/apache/arrow-go/v18/parquet/file"
 	"github.com/apache/arrow-go/v18/parquet/pqarrow"
 	"github.com/spf13/cobra"
-
-	"golang.org/x/term"
 )
 
 var writeCmd = &cobra.Command{
@@ -34,32 +27,51 @@ Supported input formats:
 - CSV files
 - JSON files  
 - Parquet files (future)
-- Sample data generation`,
+- Sample data generation
+
+With --upsert, stored rows whose key is in the input are replaced by the
+input's rows instead of kept; run compact to remove the old versions.
+
+Example:
+  lockbox write data.lbx -i changes.csv --upsert id`,
 	Args: cobra.ExactArgs(1),
 	RunE: func(cmd *cobra.Command, args []string) error {
 		filename := args[0]
 
 		inputFile, _ := cmd.Flags().GetString("input")
-		password, _ := cmd.Flags().GetString("password")
 		sampleData, _ := cmd.Flags().GetBool("sample")
 		format, _ := cmd.Flags().GetString("format")
 		blobArgs, _ := cmd.Flags().GetStringArray("blob")
+		codecName, _ := cmd.Flags().GetString("codec")
+		message, _ := cmd.Flags().GetString("message")
+		transform, _ := cmd.Flags().GetString("transform")
+		onError, _ := cmd.Flags().GetString("on-error")
+		quarantinePath, _ := cmd.Flags().GetString("quarantine")
+		mapPath, _ := cmd.Flags().GetString("map")
+		intern, _ := cmd.Flags().GetBool("intern")
+		dictionary, _ := cmd.Flags().GetStringSlice("dictionary")
+		statsJSON, _ := cmd.Flags().GetBool("json")
+		upsertKey, _ := cmd.Flags().GetString("upsert")
+
+		policy, err := lockbox.ParseErrorPolicy(onError)
+		if err != nil {
+			return err
+		}
+		if policy == lockbox.OnErrorQuarantine && quarantinePath == "" {
+			return fmt.Errorf("--on-error quarantine requires --quarantine")
+		}
+		rejects := &lockbox.Rejects{}
 
 		// Make sure pyarrow is installed
 		if err := ensurePyarrowInstalled(); err != nil {
 			return fmt.Errorf("could not ensure pyarrow is installed: %v", err)
 		}
 
-		// Get password if not provided
-		if password == "" {
-			fmt.Print("Enter password: ")
-			passwordBytes, err := term.ReadPassword(int(syscall.Stdin))
-			if err != nil {
-				return fmt.Errorf("failed to read password: %w", err)
-			}
-			password = string(passwordBytes)
-			fmt.Println() // New line after password input
+		password, err := readPassword(cmd)
+		if err != nil {
+			return err
 		}
+		stats := startThroughput("write")
 
 		// Open the lockbox
 		lb, err := lockbox.Open(filename, lockbox.WithPassword(password))
@@ -70,7 +82,7 @@ Supported input formats:
 
 		blobMap := parseBlobArgs(blobArgs)
 
-		ctx := context.Background()
+		ctx := cmd.Context()
 
 		var record arrow.Record
 
@@ -85,15 +97,27 @@ Supported input formats:
 			if err != nil {
 				return fmt.Errorf("failed to load blob data: %w", err)
 			}
-		} else if inputFile != "" && format == "csv" {
-			// Load data from file
-			record, err = loadDataFromFile(inputFile, lb.Schema())
+		} else if inputFile != "" && (format == "csv" || format == "json") {
+			loadOpts, err := csvOptions(cmd)
 			if err != nil {
-				return fmt.Errorf("failed to load data from file: %w", err)
+				return err
+			}
+			loadOpts = append(loadOpts, lockbox.WithOnError(policy, rejects), lockbox.WithDictionary(dictionary...))
+			if intern {
+				loadOpts = append(loadOpts, lockbox.WithInternStrings())
+			}
+			if mapPath != "" {
+				mapping, err := lockbox.LoadColumnMapping(mapPath)
+				if err != nil {
+					return err
+				}
+				loadOpts = append(loadOpts, lockbox.WithColumnMapping(mapping))
+			}
+			if format == "csv" {
+				record, err = lockbox.LoadCSV(inputFile, lb.Schema(), loadOpts...)
+			} else {
+				record, err = lockbox.LoadJSON(inputFile, lb.Schema(), loadOpts...)
 			}
-		} else if inputFile != "" && format == "json" {
-			// Load data from file
-			record, err = loadDataFromJSON(inputFile, lb.Schema())
 			if err != nil {
 				return fmt.Errorf("failed to load data from file: %w", err)
 			}
@@ -107,7 +131,7 @@ Supported input formats:
 			}
 
 			// Load data from parquet file
-			record, err = loadDataFromORCToParquet(outputfile, lb.Schema())
+			record, err = loadDataFromORCToParquet(ctx, outputfile, lb.Schema())
 			if err != nil {
 				return fmt.Errorf("failed to load data from file: %w", err)
 			}
@@ -116,27 +140,117 @@ Supported input formats:
 			return fmt.Errorf("either --input or --sample must be specified")
 		}
 
+		// Record where the data came from with the commit
+		lineage := metadata.Lineage{Transformation: transform}
+		switch {
+		case sampleData:
+			lineage.Source = "sample"
+		case inputFile != "":
+			lineage.Source = inputFile
+			if lineage.SourceHash, err = lockbox.SourceHash(inputFile); err != nil {
+				record.Release()
+				return err
+			}
+		}
+
 		// Write the data
-		if err := lb.Write(ctx, record, lockbox.WithPassword(password)); err != nil {
+		writeOpts := []lockbox.Option{
+			lockbox.WithPassword(password),
+			lockbox.WithCodec(codecName),
+			lockbox.WithMessage(message),
+			lockbox.WithLineage(lineage),
+			lockbox.WithDictionary(dictionary...),
+		}
+		if upsertKey != "" {
+			res, err := lb.Upsert(ctx, record, upsertKey, writeOpts...)
+			if err != nil {
+				record.Release()
+				return fmt.Errorf("failed to upsert data: %w", err)
+			}
+			fmt.Printf("Replaced %d rows and inserted %d keyed on %s\n", res.Replaced, res.Inserted, upsertKey)
+		} else if err := lb.Write(ctx, record, writeOpts...); err != nil {
 			record.Release()
 			return fmt.Errorf("failed to write data: %w", err)
 		}
 
+		stats.finish(record)
 		record.Release()
 		fmt.Printf("Successfully wrote %d rows to %s\n", record.NumRows(), filename)
+		if err := stats.print(os.Stdout, statsJSON); err != nil {
+			return err
+		}
+
+		if rejects.Count > 0 {
+			fmt.Println(rejects.Summary())
+			if policy == lockbox.OnErrorQuarantine {
+				if err := writeQuarantine(ctx, quarantinePath, rejects, inputFile, password); err != nil {
+					return err
+				}
+				fmt.Printf("Quarantined rejected rows in %s\n", quarantinePath)
+			}
+		}
 
 		return nil
 	},
 }
 
+// writeQuarantine stores rejected rows in a lockbox when path ends in .lbx,
+// appending to an existing one, and as CSV otherwise
+func writeQuarantine(ctx context.Context, path string, rejects *lockbox.Rejects, source, password string) error {
+	rec := rejects.Record()
+	defer rec.Release()
+
+	if !strings.EqualFold(filepath.Ext(path), ".lbx") {
+		f, err := os.Create(path)
+		if err != nil {
+			return fmt.Errorf("failed to create quarantine file: %w", err)
+		}
+		if err := rejects.WriteCSV(f); err != nil {
+			f.Close()
+			return fmt.Errorf("failed to write quarantine file: %w", err)
+		}
+		return f.Close()
+	}
+
+	var lb *lockbox.Lockbox
+	var err error
+	if _, statErr := os.Stat(path); statErr == nil {
+		lb, err = lockbox.Open(path, lockbox.WithPassword(password))
+	} else {
+		lb, err = lockbox.Create(path, lockbox.RejectSchema, lockbox.WithPassword(password), lockbox.WithCreatedBy("lockbox write"))
+	}
+	if err != nil {
+		return fmt.Errorf("failed to open quarantine lockbox: %w", err)
+	}
+	defer lb.Close()
+
+	return lb.Write(ctx, rec,
+		lockbox.WithPassword(password),
+		lockbox.WithMessage(fmt.Sprintf("Rows rejected while loading %s", source)),
+		lockbox.WithLineage(metadata.Lineage{Source: source}),
+	)
+}
+
 func init() {
 	rootCmd.AddCommand(writeCmd)
 
 	writeCmd.Flags().StringP("input", "i", "", "Input data file (CSV, JSON)")
 	writeCmd.Flags().StringP("format", "f", "", "Input data format (csv, json)")
-	writeCmd.Flags().StringP("password", "p", "", "Password for encryption")
+	addPasswordFlags(writeCmd.Flags(), "Password for encryption")
 	writeCmd.Flags().Bool("sample", false, "Generate sample data")
 	writeCmd.Flags().StringArray("blob", []string{}, "Blob field mapping field=file")
+	writeCmd.Flags().String("codec", "", "Compression codec for column blocks (e.g. zstd, lz4, gzip); defaults to the file's")
+	writeCmd.Flags().StringP("message", "m", "", "Commit message recorded with the write")
+	writeCmd.Flags().String("transform", "", "Description of how the input was transformed, for lineage")
+	writeCmd.Flags().String("on-error", "abort", "What to do with rows that do not fit the schema (abort, skip, quarantine)")
+	writeCmd.Flags().String("quarantine", "", "File for rows rejected with --on-error quarantine (.lbx for a lockbox, CSV otherwise)")
+	writeCmd.Flags().String("map", "", "YAML or JSON file mapping source columns to lockbox fields, with defaults and transforms")
+	writeCmd.Flags().Bool("intern", false, "Hold repeated strings once while loading CSV or JSON input")
+	writeCmd.Flags().StringSlice("dictionary", nil, "String columns to store dictionary encoded")
+	writeCmd.Flags().String("upsert", "", "Key column: replace stored rows with the same key instead of appending them")
+	addCSVFlags(writeCmd, false)
+	addThroughputFlags(writeCmd)
+	addProfileFlags(writeCmd)
 }
 
 func convertORCtoParquet(orcFile, parquetFile string) error {
@@ -282,321 +396,6 @@ func generateSampleData(schema *arrow.Schema) (arrow.Record, error) {
 	return record, nil
 }
 
-// loadDataFromFile loads data from various file formats
-// This is a simplified implementation for MVP
-func loadDataFromFile(filename string, schema *arrow.Schema) (arrow.Record, error) {
-	// For MVP, we'll just generate sample data regardless of input file
-	// In a full implementation, this would parse CSV, JSON, Parquet, etc.
-	mem := memory.NewGoAllocator()
-	numFields := len(schema.Fields())
-
-	// Create array builders for each column
-	builders := make([]array.Builder, numFields)
-	for i, field := range schema.Fields() {
-		switch typ := field.Type.(type) {
-		case *arrow.Int64Type:
-			builders[i] = array.NewInt64Builder(mem)
-		case *arrow.Int32Type:
-			builders[i] = array.NewInt32Builder(mem)
-		case *arrow.Float64Type:
-			builders[i] = array.NewFloat64Builder(mem)
-		case *arrow.StringType:
-			builders[i] = array.NewStringBuilder(mem)
-		case *arrow.TimestampType:
-			builders[i] = array.NewTimestampBuilder(mem, typ)
-		default:
-			return nil, fmt.Errorf("unsupported type: %v", field.Type)
-		}
-	}
-
-	f, err := os.Open(filename)
-	if err != nil {
-		return nil, fmt.Errorf("failed to open file: %w", err)
-	}
-	defer f.Close()
-
-	rdr := csv.NewReader(f)
-
-	// skip the header row
-	_, err = rdr.Read()
-	if err != nil {
-		return nil, fmt.Errorf("failed to read CSV header: %w", err)
-	}
-
-	for rowNum := 2; ; rowNum++ { // Start from 2 since header was row 1
-		row, err := rdr.Read()
-		if err != nil {
-			if errors.Is(err, io.EOF) { // EOF check
-				break
-			}
-			return nil, fmt.Errorf("error reading row %d: %w", rowNum, err)
-		}
-		if len(row) != numFields {
-			return nil, fmt.Errorf("row %d: expected %d fields, got %d", rowNum, numFields, len(row))
-		}
-
-		for i, val := range row {
-			field := schema.Field(i)
-			switch typ := field.Type.(type) {
-			case *arrow.Int64Type:
-				if val == "" && field.Nullable {
-					builders[i].(*array.Int64Builder).AppendNull()
-					continue
-				}
-				v, err := strconv.ParseInt(val, 10, 64)
-				if err != nil {
-					return nil, fmt.Errorf("row %d, col %s: invalid int64: %s", rowNum, field.Name, val)
-				}
-				builders[i].(*array.Int64Builder).Append(v)
-			case *arrow.Int32Type:
-				if val == "" && field.Nullable {
-					builders[i].(*array.Int32Builder).AppendNull()
-					continue
-				}
-				v, err := strconv.ParseInt(val, 10, 32)
-				if err != nil {
-					return nil, fmt.Errorf("row %d, col %s: invalid int32: %s", rowNum, field.Name, val)
-				}
-				builders[i].(*array.Int32Builder).Append(int32(v))
-			case *arrow.Float64Type:
-				if val == "" && field.Nullable {
-					builders[i].(*array.Float64Builder).AppendNull()
-					continue
-				}
-				v, err := strconv.ParseFloat(val, 64)
-				if err != nil {
-					return nil, fmt.Errorf("row %d, col %s: invalid float64: %s", rowNum, field.Name, val)
-				}
-				builders[i].(*array.Float64Builder).Append(v)
-			case *arrow.StringType:
-				if val == "" && field.Nullable {
-					builders[i].(*array.StringBuilder).AppendNull()
-					continue
-				}
-				builders[i].(*array.StringBuilder).Append(val)
-			case *arrow.TimestampType:
-				if val == "" && field.Nullable {
-					builders[i].(*array.TimestampBuilder).AppendNull()
-					continue
-				}
-				tm, err := time.Parse(time.RFC3339, val)
-				if err != nil {
-					return nil, fmt.Errorf("row %d, col %s: invalid timestamp: %s", rowNum, field.Name, val)
-				}
-				var epoch int64
-				switch typ.Unit {
-				case arrow.Second:
-					epoch = tm.Unix()
-				case arrow.Millisecond:
-					epoch = tm.UnixMilli()
-				case arrow.Microsecond:
-					epoch = tm.UnixMicro()
-				case arrow.Nanosecond:
-					epoch = tm.UnixNano()
-				default:
-					return nil, fmt.Errorf("unknown timestamp unit: %v", typ.Unit)
-				}
-				builders[i].(*array.TimestampBuilder).Append(arrow.Timestamp(epoch))
-			default:
-				return nil, fmt.Errorf("unsupported type in row %d, col %s: %v", rowNum, field.Name, field.Type)
-			}
-		}
-	}
-
-	// Build Arrow arrays and record
-	arrays := make([]arrow.Array, numFields)
-	for i, b := range builders {
-		arrays[i] = b.NewArray()
-		b.Release()
-	}
-
-	numRows := int64(arrays[0].Len())
-	record := array.NewRecord(schema, arrays, numRows)
-
-	// Clean up arrays
-	for _, arr := range arrays {
-		arr.Release()
-	}
-
-	return record, nil
-}
-
-func loadDataFromJSON(filename string, schema *arrow.Schema) (arrow.Record, error) {
-	mem := memory.NewGoAllocator()
-	numFields := len(schema.Fields())
-
-	// Create builders for each column
-	builders := make([]array.Builder, numFields)
-	for i, field := range schema.Fields() {
-		switch typ := field.Type.(type) {
-		case *arrow.Int64Type:
-			builders[i] = array.NewInt64Builder(mem)
-		case *arrow.Int32Type:
-			builders[i] = array.NewInt32Builder(mem)
-		case *arrow.Float64Type:
-			builders[i] = array.NewFloat64Builder(mem)
-		case *arrow.StringType:
-			builders[i] = array.NewStringBuilder(mem)
-		case *arrow.TimestampType:
-			builders[i] = array.NewTimestampBuilder(mem, typ)
-		default:
-			return nil, fmt.Errorf("unsupported type: %v", field.Type)
-		}
-	}
-
-	// Open JSON file
-	f, err := os.Open(filename)
-	if err != nil {
-		return nil, fmt.Errorf("failed to open file: %w", err)
-	}
-	defer f.Close()
-
-	// Read JSON: try as array, else NDJSON fallback
-	dec := json.NewDecoder(f)
-	var records []map[string]interface{}
-	// Try to decode as array of objects
-	if err := dec.Decode(&records); err != nil {
-		// Reset file pointer and try NDJSON (one object per line)
-		if _, err2 := f.Seek(0, io.SeekStart); err2 != nil {
-			return nil, fmt.Errorf("invalid JSON format, and seek failed: %w", err)
-		}
-		dec = json.NewDecoder(f)
-		records = []map[string]interface{}{}
-		for {
-			var row map[string]interface{}
-			if err := dec.Decode(&row); err != nil {
-				if err == io.EOF {
-					break
-				}
-				return nil, fmt.Errorf("JSON decode error: %w", err)
-			}
-			records = append(records, row)
-		}
-	}
-
-	// Process records
-	for rowNum, rec := range records {
-		for i, field := range schema.Fields() {
-			val, ok := rec[field.Name]
-			if !ok || val == nil {
-				if field.Nullable {
-					builders[i].AppendNull()
-					continue
-				}
-				return nil, fmt.Errorf("row %d: missing non-nullable field '%s'", rowNum+1, field.Name)
-			}
-			switch typ := field.Type.(type) {
-			case *arrow.Int64Type:
-				switch v := val.(type) {
-				case float64: // json.Unmarshal converts numbers to float64
-					builders[i].(*array.Int64Builder).Append(int64(v))
-				case string:
-					if v == "" && field.Nullable {
-						builders[i].(*array.Int64Builder).AppendNull()
-					} else {
-						num, err := strconv.ParseInt(v, 10, 64)
-						if err != nil {
-							return nil, fmt.Errorf("row %d, col %s: invalid int64: %v", rowNum+1, field.Name, v)
-						}
-						builders[i].(*array.Int64Builder).Append(num)
-					}
-				default:
-					return nil, fmt.Errorf("row %d, col %s: expected int64, got %T", rowNum+1, field.Name, val)
-				}
-			case *arrow.Int32Type:
-				switch v := val.(type) {
-				case float64:
-					builders[i].(*array.Int32Builder).Append(int32(v))
-				case string:
-					if v == "" && field.Nullable {
-						builders[i].(*array.Int32Builder).AppendNull()
-					} else {
-						num, err := strconv.ParseInt(v, 10, 32)
-						if err != nil {
-							return nil, fmt.Errorf("row %d, col %s: invalid int32: %v", rowNum+1, field.Name, v)
-						}
-						builders[i].(*array.Int32Builder).Append(int32(num))
-					}
-				default:
-					return nil, fmt.Errorf("row %d, col %s: expected int32, got %T", rowNum+1, field.Name, val)
-				}
-			case *arrow.Float64Type:
-				switch v := val.(type) {
-				case float64:
-					builders[i].(*array.Float64Builder).Append(v)
-				case string:
-					if v == "" && field.Nullable {
-						builders[i].(*array.Float64Builder).AppendNull()
-					} else {
-						num, err := strconv.ParseFloat(v, 64)
-						if err != nil {
-							return nil, fmt.Errorf("row %d, col %s: invalid float64: %v", rowNum+1, field.Name, v)
-						}
-						builders[i].(*array.Float64Builder).Append(num)
-					}
-				default:
-					return nil, fmt.Errorf("row %d, col %s: expected float64, got %T", rowNum+1, field.Name, val)
-				}
-			case *arrow.StringType:
-				switch v := val.(type) {
-				case string:
-					if v == "" && field.Nullable {
-						builders[i].(*array.StringBuilder).AppendNull()
-					} else {
-						builders[i].(*array.StringBuilder).Append(v)
-					}
-				default:
-					builders[i].(*array.StringBuilder).Append(fmt.Sprintf("%v", val))
-				}
-			case *arrow.TimestampType:
-				switch v := val.(type) {
-				case string:
-					if v == "" && field.Nullable {
-						builders[i].(*array.TimestampBuilder).AppendNull()
-						continue
-					}
-					tm, err := time.Parse(time.RFC3339, v)
-					if err != nil {
-						return nil, fmt.Errorf("row %d, col %s: invalid timestamp: %v", rowNum+1, field.Name, v)
-					}
-					var epoch int64
-					switch typ.Unit {
-					case arrow.Second:
-						epoch = tm.Unix()
-					case arrow.Millisecond:
-						epoch = tm.UnixMilli()
-					case arrow.Microsecond:
-						epoch = tm.UnixMicro()
-					case arrow.Nanosecond:
-						epoch = tm.UnixNano()
-					default:
-						return nil, fmt.Errorf("unknown timestamp unit: %v", typ.Unit)
-					}
-					builders[i].(*array.TimestampBuilder).Append(arrow.Timestamp(epoch))
-				default:
-					return nil, fmt.Errorf("row %d, col %s: invalid timestamp type: %T", rowNum+1, field.Name, val)
-				}
-			default:
-				return nil, fmt.Errorf("unsupported type: %v", field.Type)
-			}
-		}
-	}
-
-	// Build Arrow arrays and record
-	arrays := make([]arrow.Array, numFields)
-	for i, b := range builders {
-		arrays[i] = b.NewArray()
-		b.Release()
-	}
-	numRows := int64(arrays[0].Len())
-	record := array.NewRecord(schema, arrays, numRows)
-	for _, arr := range arrays {
-		arr.Release()
-	}
-
-	return record, nil
-}
-
 func parseBlobArgs(args []string) map[string]string {
 	m := make(map[string]string)
 	for _, a := range args {
@@ -656,7 +455,7 @@ func loadBlobRecord(blobs map[string]string, schema *arrow.Schema) (arrow.Record
 }
 
 // Loads all data from a Parquet file into a single Arrow Record, matching the given schema
-func loadDataFromORCToParquet(parquetPath string, schema *arrow.Schema) (arrow.Record, error) {
+func loadDataFromORCToParquet(ctx context.Context, parquetPath string, schema *arrow.Schema) (arrow.Record, error) {
 	f, err := os.Open(parquetPath)
 	if err != nil {
 		return nil, fmt.Errorf("failed to open parquet file: %w", err)
@@ -687,7 +486,6 @@ func loadDataFromORCToParquet(parquetPath string, schema *arrow.Schema) (arrow.R
 	// (See your `coerceRecord` logic for stricter mapping)
 
 	// Read all rows from the Parquet file
-	ctx := context.Background()
 	recReader, err := pqReader.GetRecordReader(ctx, nil, nil)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get record reader: %w", err)
@@ -0,0 +1,8 @@
+//go:build lockbox_flate
+
+package main
+
+// Optional modules are linked in with build tags so that binaries built on
+// platforms without Go plugin support can still carry extra codecs and
+// crypto modules. Each module package registers itself in init.
+import _ "github.com/TFMV/lockbox/pkg/codec/flate"
@@ -0,0 +1,45 @@
+// Package flate registers a raw DEFLATE codec. It is not linked into the
+// lockbox binary by default; build with -tags lockbox_flate or blank-import
+// this package to make the "flate" codec available.
+package flate
+
+import (
+	"bytes"
+	"compress/flate"
+	"io"
+
+	"github.com/TFMV/lockbox/pkg/codec"
+)
+
+// Codec compresses blocks with DEFLATE at the default level.
+type Codec struct{}
+
+// Name implements codec.Codec
+func (Codec) Name() string { return "flate" }
+
+// Encode implements codec.Codec
+func (Codec) Encode(b []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	fw, err := flate.NewWriter(&buf, flate.DefaultCompression)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := fw.Write(b); err != nil {
+		return nil, err
+	}
+	if err := fw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Decode implements codec.Codec
+func (Codec) Decode(b []byte) ([]byte, error) {
+	fr := flate.NewReader(bytes.NewReader(b))
+	defer fr.Close()
+	return io.ReadAll(fr)
+}
+
+func init() {
+	codec.Register(Codec{})
+}
@@ -0,0 +1,40 @@
+// Package lz4 registers an LZ4 codec, which compresses less than zstd or
+// gzip but decompresses faster. The lockbox package links it in, so the
+// "lz4" codec is available wherever lockbox is used.
+package lz4
+
+import (
+	"bytes"
+	"io"
+
+	"github.com/TFMV/lockbox/pkg/codec"
+	"github.com/pierrec/lz4/v4"
+)
+
+// Codec compresses blocks with LZ4 frames.
+type Codec struct{}
+
+// Name implements codec.Codec
+func (Codec) Name() string { return "lz4" }
+
+// Encode implements codec.Codec
+func (Codec) Encode(b []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	zw := lz4.NewWriter(&buf)
+	if _, err := zw.Write(b); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Decode implements codec.Codec
+func (Codec) Decode(b []byte) ([]byte, error) {
+	return io.ReadAll(lz4.NewReader(bytes.NewReader(b)))
+}
+
+func init() {
+	codec.Register(Codec{})
+}
@@ -0,0 +1,35 @@
+// Package zstd registers a Zstandard codec. The lockbox package links it
+// in, so the "zstd" codec is available wherever lockbox is used.
+package zstd
+
+import (
+	"github.com/TFMV/lockbox/pkg/codec"
+	"github.com/klauspost/compress/zstd"
+)
+
+// The encoder and decoder are safe for concurrent EncodeAll and DecodeAll
+// calls, so blocks compressed in parallel share them
+var (
+	encoder, _ = zstd.NewWriter(nil)
+	decoder, _ = zstd.NewReader(nil)
+)
+
+// Codec compresses blocks with Zstandard at the default level.
+type Codec struct{}
+
+// Name implements codec.Codec
+func (Codec) Name() string { return "zstd" }
+
+// Encode implements codec.Codec
+func (Codec) Encode(b []byte) ([]byte, error) {
+	return encoder.EncodeAll(b, nil), nil
+}
+
+// Decode implements codec.Codec
+func (Codec) Decode(b []byte) ([]byte, error) {
+	return decoder.DecodeAll(b, nil)
+}
+
+func init() {
+	codec.Register(Codec{})
+}
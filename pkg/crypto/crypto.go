@@ -3,6 +3,9 @@ package crypto
 import (
 	"crypto/aes"
 	"crypto/cipher"
+	"crypto/ed25519"
+	"crypto/hkdf"
+	"crypto/mlkem"
 	"crypto/rand"
 	"crypto/sha256"
 	"fmt"
@@ -11,6 +14,7 @@ import (
 	"go.dedis.ch/kyber/v3"
 	"go.dedis.ch/kyber/v3/group/edwards25519"
 	"go.dedis.ch/kyber/v3/util/random"
+	"golang.org/x/crypto/argon2"
 	"golang.org/x/crypto/pbkdf2"
 )
 
@@ -23,6 +27,12 @@ const (
 	SaltSize = 32
 	// PBKDF2Iterations is the number of iterations for key derivation
 	PBKDF2Iterations = 100000
+	// Argon2Time is the default number of Argon2id passes
+	Argon2Time = 3
+	// Argon2Memory is the default Argon2id memory cost in KiB
+	Argon2Memory = 64 * 1024
+	// Argon2Threads is the default Argon2id parallelism
+	Argon2Threads = 4
 	// KyberPublicKeySize is the size of Kyber public keys
 	KyberPublicKeySize = 32
 	// KyberSecretKeySize is the size of Kyber secret keys
@@ -43,6 +53,9 @@ type Key struct {
 	// PQ components
 	KyberPublicKey kyber.Point
 	KyberSecretKey kyber.Scalar
+	// KEMSeed is the ML-KEM-768 key of a column key epoch, for files that
+	// keep independent ML-KEM keys; see ColumnEncryptor.SetKEMKey
+	KEMSeed []byte
 }
 
 // ColumnEncryptor handles encryption/decryption for column data
@@ -52,6 +65,10 @@ type ColumnEncryptor struct {
 	// PQ components
 	KyberPublicKey kyber.Point
 	KyberSecretKey kyber.Scalar
+	// kem replaces the edwards25519 exchange when set, see NewMLKEMEncryptor;
+	// with hybridKEM both are mixed into block keys, see SetKEMKey
+	kem       *mlkem.DecapsulationKey768
+	hybridKEM bool
 }
 
 // NewKey generates a new encryption key from a password with post-quantum protection
@@ -81,6 +98,20 @@ func DeriveKey(password string, salt []byte) *Key {
 	// Derive classical key
 	key := pbkdf2.Key([]byte(password), salt, PBKDF2Iterations, KeySize, sha256.New)
 
+	return KeyFromMaster(key, salt)
+}
+
+// DeriveKeyArgon2id derives a key from password and salt with Argon2id,
+// taking time passes over memory KiB with the given parallelism
+func DeriveKeyArgon2id(password string, salt []byte, time, memory uint32, threads uint8) *Key {
+	key := argon2.IDKey([]byte(password), salt, time, memory, threads, KeySize)
+
+	return KeyFromMaster(key, salt)
+}
+
+// KeyFromMaster builds a Key, including its PQ components, from raw master
+// key bytes such as a key unwrapped from file metadata
+func KeyFromMaster(key, salt []byte) *Key {
 	// Derive Kyber keys deterministically from the master key
 	secret := Suite.Scalar().SetBytes(key)
 	public := Suite.Point().Mul(secret, nil)
@@ -117,6 +148,10 @@ func NewColumnEncryptor(key []byte) (*ColumnEncryptor, error) {
 
 // Encrypt encrypts data using hybrid classical + post-quantum encryption
 func (ce *ColumnEncryptor) Encrypt(plaintext []byte) ([]byte, error) {
+	if ce.kem != nil {
+		return ce.encryptMLKEM(plaintext)
+	}
+
 	// Generate ephemeral keypair for perfect forward secrecy
 	ephemeralSecret := Suite.Scalar().Pick(random.New())
 	ephemeralPublic := Suite.Point().Mul(ephemeralSecret, nil)
@@ -171,6 +206,9 @@ func (ce *ColumnEncryptor) Encrypt(plaintext []byte) ([]byte, error) {
 
 // Decrypt decrypts data using hybrid classical + post-quantum decryption
 func (ce *ColumnEncryptor) Decrypt(ciphertext []byte) ([]byte, error) {
+	if ce.kem != nil {
+		return ce.decryptMLKEM(ciphertext)
+	}
 	if len(ciphertext) < KyberPublicKeySize+NonceSize {
 		return nil, fmt.Errorf("ciphertext too short")
 	}
@@ -225,6 +263,47 @@ func DeriveColumnKey(masterKey []byte, columnName string, salt []byte) []byte {
 	return pbkdf2.Key(append(masterKey, []byte(columnName)...), salt, PBKDF2Iterations, KeySize, sha256.New)
 }
 
+// ExpandColumnKey derives a column-specific key from master key and column
+// name with HKDF-SHA256. The master key already comes from a slow KDF, so
+// unlike DeriveColumnKey this takes microseconds and keys for thousands of
+// columns are cheap.
+func ExpandColumnKey(masterKey []byte, columnName string, salt []byte) []byte {
+	// HKDF only fails for lengths beyond 255 hash blocks
+	key, _ := hkdf.Key(sha256.New, masterKey, salt, "lockbox column "+columnName, KeySize)
+	return key
+}
+
+// ExpandSignedColumnKey derives a column key like ExpandColumnKey under a
+// label of its own, for files that sign their blocks. A file's keys then
+// depend on whether its blocks are signed.
+func ExpandSignedColumnKey(masterKey []byte, columnName string, salt []byte) []byte {
+	key, _ := hkdf.Key(sha256.New, masterKey, salt, "lockbox signed column "+columnName, KeySize)
+	return key
+}
+
+// FieldKey derives from a column key the key applications use for purpose,
+// e.g. encrypting or hashing values of the column before they reach the
+// file. Field keys are separate from the keys of the column's blocks, so
+// holding one does not decrypt the file.
+func FieldKey(columnKey []byte, purpose string) []byte {
+	key, _ := hkdf.Key(sha256.New, columnKey, nil, "lockbox field "+purpose, KeySize)
+	return key
+}
+
+// BlockSigningKey derives the Ed25519 key that signs the data blocks of a
+// file from its master key
+func BlockSigningKey(masterKey []byte) ed25519.PrivateKey {
+	seed, _ := hkdf.Key(sha256.New, masterKey, nil, "lockbox block signing", ed25519.SeedSize)
+	return ed25519.NewKeyFromSeed(seed)
+}
+
+// KEMWrapKey derives from the master key of a file the key that wraps the
+// independent ML-KEM keys of its columns
+func KEMWrapKey(masterKey []byte) []byte {
+	key, _ := hkdf.Key(sha256.New, masterKey, nil, "lockbox ml-kem key wrapping", KeySize)
+	return key
+}
+
 // Sign signs data using the Kyber keypair
 func (ce *ColumnEncryptor) Sign(data []byte) ([]byte, error) {
 	if ce.KyberSecretKey == nil {
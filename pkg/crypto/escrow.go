@@ -0,0 +1,162 @@
+package crypto
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/pem"
+	"fmt"
+	"io"
+)
+
+const (
+	escrowPublicPEM  = "LOCKBOX ESCROW PUBLIC KEY"
+	escrowPrivatePEM = "LOCKBOX ESCROW PRIVATE KEY"
+)
+
+// EscrowKey is an X25519 key pair that key material can be sealed to for
+// safekeeping by a third party
+type EscrowKey struct {
+	private *ecdh.PrivateKey
+}
+
+// GenerateEscrowKey creates a new escrow key pair
+func GenerateEscrowKey() (*EscrowKey, error) {
+	priv, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate escrow key: %w", err)
+	}
+	return &EscrowKey{private: priv}, nil
+}
+
+// PublicPEM returns the PEM encoded public key
+func (k *EscrowKey) PublicPEM() []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: escrowPublicPEM, Bytes: k.private.PublicKey().Bytes()})
+}
+
+// PrivatePEM returns the PEM encoded private key
+func (k *EscrowKey) PrivatePEM() []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: escrowPrivatePEM, Bytes: k.private.Bytes()})
+}
+
+// ParseEscrowPrivateKey decodes a key produced by PrivatePEM
+func ParseEscrowPrivateKey(data []byte) (*EscrowKey, error) {
+	raw, err := decodeEscrowPEM(data, escrowPrivatePEM)
+	if err != nil {
+		return nil, err
+	}
+	priv, err := ecdh.X25519().NewPrivateKey(raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid escrow private key: %w", err)
+	}
+	return &EscrowKey{private: priv}, nil
+}
+
+// SealToEscrow encrypts plaintext so that only the holder of the escrow
+// private key for publicPEM can read it. The output is the ephemeral public
+// key, a nonce and the AES-256-GCM ciphertext.
+func SealToEscrow(publicPEM, plaintext []byte) ([]byte, error) {
+	raw, err := decodeEscrowPEM(publicPEM, escrowPublicPEM)
+	if err != nil {
+		return nil, err
+	}
+	recipient, err := ecdh.X25519().NewPublicKey(raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid escrow public key: %w", err)
+	}
+
+	ephemeral, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate ephemeral key: %w", err)
+	}
+	shared, err := ephemeral.ECDH(recipient)
+	if err != nil {
+		return nil, fmt.Errorf("key agreement failed: %w", err)
+	}
+
+	sealed, err := WrapKey(escrowKEK(shared, ephemeral.PublicKey().Bytes()), plaintext)
+	if err != nil {
+		return nil, err
+	}
+	return append(ephemeral.PublicKey().Bytes(), sealed...), nil
+}
+
+// Open decrypts data produced by SealToEscrow
+func (k *EscrowKey) Open(sealed []byte) ([]byte, error) {
+	const pubSize = 32
+	if len(sealed) < pubSize {
+		return nil, fmt.Errorf("sealed data too short")
+	}
+	ephemeral, err := ecdh.X25519().NewPublicKey(sealed[:pubSize])
+	if err != nil {
+		return nil, fmt.Errorf("invalid ephemeral key: %w", err)
+	}
+	shared, err := k.private.ECDH(ephemeral)
+	if err != nil {
+		return nil, fmt.Errorf("key agreement failed: %w", err)
+	}
+	plaintext, err := UnwrapKey(escrowKEK(shared, sealed[:pubSize]), sealed[pubSize:])
+	if err != nil {
+		return nil, fmt.Errorf("escrow key does not match: %w", err)
+	}
+	return plaintext, nil
+}
+
+func escrowKEK(shared, ephemeralPub []byte) []byte {
+	h := sha256.New()
+	h.Write([]byte("lockbox-escrow"))
+	h.Write(shared)
+	h.Write(ephemeralPub)
+	return h.Sum(nil)
+}
+
+func decodeEscrowPEM(data []byte, blockType string) ([]byte, error) {
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != blockType {
+		return nil, fmt.Errorf("expected a PEM block of type %q", blockType)
+	}
+	return block.Bytes, nil
+}
+
+// WrapKey encrypts key material with a 32 byte key-encryption key using
+// AES-256-GCM. The nonce is prepended to the ciphertext.
+func WrapKey(kek, plaintext []byte) ([]byte, error) {
+	gcm, err := newGCM(kek)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, NonceSize)
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	return gcm.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+// UnwrapKey decrypts key material produced by WrapKey
+func UnwrapKey(kek, wrapped []byte) ([]byte, error) {
+	gcm, err := newGCM(kek)
+	if err != nil {
+		return nil, err
+	}
+	if len(wrapped) < NonceSize {
+		return nil, fmt.Errorf("wrapped key too short")
+	}
+	plaintext, err := gcm.Open(nil, wrapped[:NonceSize], wrapped[NonceSize:], nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unwrap key: %w", err)
+	}
+	return plaintext, nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	if len(key) != KeySize {
+		return nil, fmt.Errorf("invalid key size: expected %d, got %d", KeySize, len(key))
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cipher: %w", err)
+	}
+	return cipher.NewGCM(block)
+}
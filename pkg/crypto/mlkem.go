@@ -0,0 +1,200 @@
+package crypto
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hkdf"
+	"crypto/mlkem"
+	"crypto/rand"
+	"crypto/sha256"
+	"fmt"
+	"io"
+
+	"go.dedis.ch/kyber/v3"
+	"go.dedis.ch/kyber/v3/util/random"
+)
+
+const (
+	// MLKEMModule is the name of the crypto module encapsulating block keys
+	// with ML-KEM-768 (FIPS 203)
+	MLKEMModule = "mlkem768"
+	// MLKEMCiphertextSize is the size of the ML-KEM-768 encapsulation stored
+	// at the start of every block
+	MLKEMCiphertextSize = mlkem.CiphertextSize768
+	// MLKEMKeysParam is the module parameter recording how a file gets the
+	// ML-KEM keys of its columns. Files created before it derive them from
+	// the column keys.
+	MLKEMKeysParam = "kemKeys"
+	// MLKEMKeysWrapped keeps an independent ML-KEM key per column key epoch,
+	// wrapped under the master key
+	MLKEMKeysWrapped = "wrapped"
+)
+
+// NewMLKEMEncryptor creates a column encryptor that, instead of the
+// edwards25519 exchange, encapsulates a fresh shared secret with ML-KEM-768
+// for every block. The decapsulation key is derived from the column key,
+// which is how files created before independent ML-KEM keys are read: for
+// them the encapsulation adds no security beyond the column key. SetKEMKey
+// switches the encryptor to an independent key.
+func NewMLKEMEncryptor(key []byte) (*ColumnEncryptor, error) {
+	ce, err := NewColumnEncryptor(key)
+	if err != nil {
+		return nil, err
+	}
+	seed, err := hkdf.Key(sha256.New, key, nil, "lockbox ml-kem-768", mlkem.SeedSize)
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive ML-KEM seed: %w", err)
+	}
+	ce.kem, err = mlkem.NewDecapsulationKey768(seed)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create ML-KEM key: %w", err)
+	}
+	return ce, nil
+}
+
+// NewKEMSeed generates the seed of an independent ML-KEM-768 key
+func NewKEMSeed() ([]byte, error) {
+	seed := make([]byte, mlkem.SeedSize)
+	if _, err := io.ReadFull(rand.Reader, seed); err != nil {
+		return nil, fmt.Errorf("failed to generate ML-KEM seed: %w", err)
+	}
+	return seed, nil
+}
+
+// SetKEMKey makes the encryptor encapsulate with the ML-KEM-768 key of
+// seed, generated independently of the column key. Block keys then combine
+// the column key, the edwards25519 exchange of the default module and the
+// ML-KEM shared secret, so breaking the classical part alone does not
+// reveal them. The encryptor needs its Kyber keys.
+func (ce *ColumnEncryptor) SetKEMKey(seed []byte) error {
+	if ce.KyberSecretKey == nil {
+		return fmt.Errorf("kyber secret key not available")
+	}
+	kem, err := mlkem.NewDecapsulationKey768(seed)
+	if err != nil {
+		return fmt.Errorf("failed to create ML-KEM key: %w", err)
+	}
+	ce.kem, ce.hybridKEM = kem, true
+	return nil
+}
+
+// encryptMLKEM seals plaintext under a key combining the column key with a
+// newly encapsulated ML-KEM shared secret and, with SetKEMKey, an
+// edwards25519 exchange.
+// Format: [ML-KEM ciphertext][ephemeral public key, with SetKEMKey][nonce][encrypted_data]
+func (ce *ColumnEncryptor) encryptMLKEM(plaintext []byte) ([]byte, error) {
+	shared, encapsulation := ce.kem.EncapsulationKey().Encapsulate()
+	var ephemeralPub []byte
+	if ce.hybridKEM {
+		ephemeralSecret := Suite.Scalar().Pick(random.New())
+		ephemeral := Suite.Point().Mul(ephemeralSecret, nil)
+		exchanged, err := ce.exchange(ephemeral)
+		if err != nil {
+			return nil, err
+		}
+		if ephemeralPub, err = ephemeral.MarshalBinary(); err != nil {
+			return nil, fmt.Errorf("failed to marshal ephemeral public key: %w", err)
+		}
+		shared = append(exchanged, shared...)
+	}
+	gcm, err := ce.mlkemCipher(shared)
+	if err != nil {
+		return nil, err
+	}
+
+	head := MLKEMCiphertextSize + len(ephemeralPub)
+	result := make([]byte, head+NonceSize, head+NonceSize+len(plaintext)+gcm.Overhead())
+	copy(result, encapsulation)
+	copy(result[MLKEMCiphertextSize:], ephemeralPub)
+	nonce := result[head:]
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	return gcm.Seal(result, nonce, plaintext, nil), nil
+}
+
+// decryptMLKEM reverses encryptMLKEM
+func (ce *ColumnEncryptor) decryptMLKEM(ciphertext []byte) ([]byte, error) {
+	head := MLKEMCiphertextSize
+	if ce.hybridKEM {
+		head += KyberPublicKeySize
+	}
+	if len(ciphertext) < head+NonceSize {
+		return nil, fmt.Errorf("ciphertext too short")
+	}
+	shared, err := ce.kem.Decapsulate(ciphertext[:MLKEMCiphertextSize])
+	if err != nil {
+		return nil, fmt.Errorf("failed to decapsulate: %w", err)
+	}
+	if ce.hybridKEM {
+		ephemeral := Suite.Point()
+		if err := ephemeral.UnmarshalBinary(ciphertext[MLKEMCiphertextSize:head]); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal ephemeral public key: %w", err)
+		}
+		exchanged, err := ce.exchange(ephemeral)
+		if err != nil {
+			return nil, err
+		}
+		shared = append(exchanged, shared...)
+	}
+	gcm, err := ce.mlkemCipher(shared)
+	if err != nil {
+		return nil, err
+	}
+	nonce := ciphertext[head : head+NonceSize]
+	plaintext, err := gcm.Open(nil, nonce, ciphertext[head+NonceSize:], nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt: %w", err)
+	}
+	return plaintext, nil
+}
+
+// exchange returns the edwards25519 shared secret of the column's Kyber
+// key and an ephemeral public key, as the default module computes it
+func (ce *ColumnEncryptor) exchange(ephemeral kyber.Point) ([]byte, error) {
+	shared, err := Suite.Point().Mul(ce.KyberSecretKey, ephemeral).MarshalBinary()
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal shared secret: %w", err)
+	}
+	return shared, nil
+}
+
+// mlkemCipher returns AES-GCM keyed with HKDF over the column key and the
+// shared secrets of a block. Independent ML-KEM keys use a label of their
+// own.
+func (ce *ColumnEncryptor) mlkemCipher(shared []byte) (cipher.AEAD, error) {
+	label := "lockbox ml-kem-768 block"
+	if ce.hybridKEM {
+		label = "lockbox hybrid ml-kem-768 block"
+	}
+	hybridKey, err := hkdf.Key(sha256.New, append(append([]byte{}, ce.key...), shared...), nil, label, KeySize)
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive hybrid key: %w", err)
+	}
+	block, err := aes.NewCipher(hybridKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create hybrid cipher: %w", err)
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create hybrid GCM: %w", err)
+	}
+	return gcm, nil
+}
+
+// mlkemModule uses the default key derivation with ML-KEM-768 encryptors
+type mlkemModule struct{ defaultModule }
+
+func (mlkemModule) Name() string { return MLKEMModule }
+
+// Params records that new files keep independent ML-KEM keys
+func (mlkemModule) Params() map[string]string {
+	return map[string]string{MLKEMKeysParam: MLKEMKeysWrapped}
+}
+func (mlkemModule) NewEncryptor(key []byte) (Encryptor, error) {
+	return NewMLKEMEncryptor(key)
+}
+
+func init() {
+	RegisterModule(mlkemModule{})
+}
@@ -3,6 +3,7 @@ package crypto
 import (
 	"fmt"
 	"plugin"
+	"sort"
 )
 
 // Encryptor defines encryption operations used by the rest of the system.
@@ -22,6 +23,13 @@ type Module interface {
 	NewEncryptor(key []byte) (Encryptor, error)
 }
 
+// ParamsProvider is implemented by modules that have settings which must be
+// known to read a file back, such as a key size. The parameters are
+// recorded in the file metadata when a lockbox is created.
+type ParamsProvider interface {
+	Params() map[string]string
+}
+
 var registry = map[string]Module{}
 
 // RegisterModule registers a cryptographic module.
@@ -37,6 +45,16 @@ func GetModule(name string) (Module, bool) {
 	return m, ok
 }
 
+// Modules returns the names of all registered modules in sorted order.
+func Modules() []string {
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
 // LoadPlugin dynamically loads a module from a Go plugin.
 // The plugin must expose a symbol named "Module" that implements Module.
 func LoadPlugin(path string) error {
@@ -0,0 +1,102 @@
+package crypto
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+
+	"golang.org/x/crypto/pbkdf2"
+)
+
+// SelfTestResult is the outcome of one self-test
+type SelfTestResult struct {
+	Name string
+	Err  error
+}
+
+// SelfTest runs known-answer tests for the primitives lockbox relies on and
+// an encrypt/decrypt round trip through every registered module.
+func SelfTest() []SelfTestResult {
+	results := []SelfTestResult{
+		{Name: "sha256", Err: katSHA256()},
+		{Name: "aes-256-gcm", Err: katAESGCM()},
+		{Name: "pbkdf2-sha256", Err: katPBKDF2()},
+	}
+	for _, name := range Modules() {
+		mod, _ := GetModule(name)
+		results = append(results, SelfTestResult{Name: "module " + name, Err: moduleRoundTrip(mod)})
+	}
+	return results
+}
+
+// katSHA256 checks the FIPS 180-2 "abc" vector
+func katSHA256() error {
+	sum := sha256.Sum256([]byte("abc"))
+	return expectHex(sum[:], "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad")
+}
+
+// katAESGCM checks test case 14 of the GCM specification: a zero key, IV
+// and plaintext block
+func katAESGCM() error {
+	block, err := aes.NewCipher(make([]byte, 32))
+	if err != nil {
+		return err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return err
+	}
+	out := gcm.Seal(nil, make([]byte, NonceSize), make([]byte, 16), nil)
+	return expectHex(out, "cea7403d4d606b6e074ec5d3baf39d18d0d1c8a799996bf0265b98b5d48ab919")
+}
+
+// katPBKDF2 checks the PBKDF2-HMAC-SHA256 vector from RFC 7914
+func katPBKDF2() error {
+	key := pbkdf2.Key([]byte("passwd"), []byte("salt"), 1, 64, sha256.New)
+	return expectHex(key, "55ac046e56e3089fec1691c22544b605f94185216dde0465e68b9d57c20dacbc"+
+		"49ca9cccf179b645991664b39d77ef317c71b845b1e30bd509112041d3a19783")
+}
+
+// moduleRoundTrip encrypts and decrypts a message with a fixed key and
+// checks that tampering is detected
+func moduleRoundTrip(mod Module) error {
+	key := bytes.Repeat([]byte{0x42}, KeySize)
+	enc, err := mod.NewEncryptor(key)
+	if err != nil {
+		return fmt.Errorf("failed to create encryptor: %w", err)
+	}
+	if ce, ok := enc.(*ColumnEncryptor); ok {
+		k := KeyFromMaster(key, nil)
+		ce.KyberPublicKey = k.KyberPublicKey
+		ce.KyberSecretKey = k.KyberSecretKey
+	}
+
+	msg := []byte("lockbox self-test")
+	ct, err := enc.Encrypt(msg)
+	if err != nil {
+		return fmt.Errorf("encrypt: %w", err)
+	}
+	pt, err := enc.Decrypt(ct)
+	if err != nil {
+		return fmt.Errorf("decrypt: %w", err)
+	}
+	if !bytes.Equal(pt, msg) {
+		return fmt.Errorf("round trip returned different data")
+	}
+
+	ct[len(ct)-1] ^= 0xff
+	if _, err := enc.Decrypt(ct); err == nil {
+		return fmt.Errorf("tampered ciphertext was accepted")
+	}
+	return nil
+}
+
+func expectHex(got []byte, want string) error {
+	if hex.EncodeToString(got) != want {
+		return fmt.Errorf("known-answer mismatch: got %x", got)
+	}
+	return nil
+}
@@ -0,0 +1,578 @@
+package expr
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"math"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Env resolves identifiers to values during evaluation. Values are nil,
+// int64, float64, string, bool, []byte or time.Time; other integer and
+// float widths are widened automatically.
+type Env interface {
+	Lookup(name string) (interface{}, bool)
+}
+
+// MapEnv is an Env backed by a map
+type MapEnv map[string]interface{}
+
+// Lookup implements Env
+func (m MapEnv) Lookup(name string) (interface{}, bool) {
+	v, ok := m[name]
+	return v, ok
+}
+
+// constants are identifiers with built-in values
+var constants = map[string]func() interface{}{
+	"now": func() interface{} { return time.Now().UTC() },
+}
+
+// Eval evaluates the expression against env
+func (e *Expr) Eval(env Env) (interface{}, error) {
+	return eval(e.root, env)
+}
+
+// EvalBool evaluates the expression and reports whether the result is true.
+// Null results are treated as false.
+func (e *Expr) EvalBool(env Env) (bool, error) {
+	v, err := e.Eval(env)
+	if err != nil {
+		return false, err
+	}
+	return Truthy(v), nil
+}
+
+// Truthy reports whether a value counts as true in a boolean context
+func Truthy(v interface{}) bool {
+	switch t := v.(type) {
+	case nil:
+		return false
+	case bool:
+		return t
+	case int64:
+		return t != 0
+	case float64:
+		return t != 0
+	case string:
+		return t != ""
+	default:
+		return true
+	}
+}
+
+func eval(n node, env Env) (interface{}, error) {
+	switch v := n.(type) {
+	case *literalNode:
+		return v.value, nil
+	case *identNode:
+		if env != nil {
+			if val, ok := env.Lookup(v.name); ok {
+				return normalize(val), nil
+			}
+		}
+		if c, ok := constants[v.name]; ok {
+			return c(), nil
+		}
+		return nil, fmt.Errorf("unknown column %s", v.name)
+	case *unaryNode:
+		operand, err := eval(v.operand, env)
+		if err != nil {
+			return nil, err
+		}
+		switch v.op {
+		case "NOT":
+			if operand == nil {
+				return nil, nil
+			}
+			return !Truthy(operand), nil
+		case "-":
+			switch o := operand.(type) {
+			case nil:
+				return nil, nil
+			case int64:
+				return -o, nil
+			case float64:
+				return -o, nil
+			}
+			return nil, fmt.Errorf("cannot negate %T", operand)
+		}
+	case *binaryNode:
+		return evalBinary(v, env)
+	case *callNode:
+		args := make([]interface{}, len(v.args))
+		for i, a := range v.args {
+			val, err := eval(a, env)
+			if err != nil {
+				return nil, err
+			}
+			args[i] = val
+		}
+		return functions[v.name](args)
+	}
+	return nil, fmt.Errorf("invalid expression")
+}
+
+func evalBinary(b *binaryNode, env Env) (interface{}, error) {
+	left, err := eval(b.left, env)
+	if err != nil {
+		return nil, err
+	}
+
+	// Short-circuit boolean operators
+	switch b.op {
+	case "AND":
+		if left != nil && !Truthy(left) {
+			return false, nil
+		}
+	case "OR":
+		if Truthy(left) {
+			return true, nil
+		}
+	}
+
+	right, err := eval(b.right, env)
+	if err != nil {
+		return nil, err
+	}
+
+	switch b.op {
+	case "AND":
+		if right != nil && !Truthy(right) {
+			return false, nil
+		}
+		if left == nil || right == nil {
+			return nil, nil
+		}
+		return true, nil
+	case "OR":
+		if Truthy(right) {
+			return true, nil
+		}
+		if left == nil || right == nil {
+			return nil, nil
+		}
+		return false, nil
+	case "||":
+		if left == nil || right == nil {
+			return nil, nil
+		}
+		return ToString(left) + ToString(right), nil
+	case "=", "!=", "<", "<=", ">", ">=":
+		if left == nil || right == nil {
+			return nil, nil
+		}
+		c, err := Compare(left, right)
+		if err != nil {
+			return nil, err
+		}
+		switch b.op {
+		case "=":
+			return c == 0, nil
+		case "!=":
+			return c != 0, nil
+		case "<":
+			return c < 0, nil
+		case "<=":
+			return c <= 0, nil
+		case ">":
+			return c > 0, nil
+		default:
+			return c >= 0, nil
+		}
+	default:
+		if left == nil || right == nil {
+			return nil, nil
+		}
+		return arithmetic(b.op, left, right)
+	}
+}
+
+func arithmetic(op string, left, right interface{}) (interface{}, error) {
+	li, lInt := left.(int64)
+	ri, rInt := right.(int64)
+	if lInt && rInt {
+		switch op {
+		case "+":
+			return li + ri, nil
+		case "-":
+			return li - ri, nil
+		case "*":
+			return li * ri, nil
+		case "/":
+			if ri == 0 {
+				return nil, fmt.Errorf("division by zero")
+			}
+			if li%ri == 0 {
+				return li / ri, nil
+			}
+			return float64(li) / float64(ri), nil
+		case "%":
+			if ri == 0 {
+				return nil, fmt.Errorf("division by zero")
+			}
+			return li % ri, nil
+		}
+	}
+
+	lf, lok := toFloat(left)
+	rf, rok := toFloat(right)
+	if !lok || !rok {
+		return nil, fmt.Errorf("operator %s requires numbers, got %T and %T", op, left, right)
+	}
+	switch op {
+	case "+":
+		return lf + rf, nil
+	case "-":
+		return lf - rf, nil
+	case "*":
+		return lf * rf, nil
+	case "/":
+		if rf == 0 {
+			return nil, fmt.Errorf("division by zero")
+		}
+		return lf / rf, nil
+	case "%":
+		if rf == 0 {
+			return nil, fmt.Errorf("division by zero")
+		}
+		return math.Mod(lf, rf), nil
+	}
+	return nil, fmt.Errorf("unknown operator %s", op)
+}
+
+// Compare orders two non-null values, returning -1, 0 or 1. Numbers compare
+// numerically, times chronologically (strings are parsed as times when
+// compared with a time) and everything else by its string form.
+func Compare(a, b interface{}) (int, error) {
+	a, b = normalize(a), normalize(b)
+
+	if af, ok := toFloat(a); ok {
+		if bf, ok := toFloat(b); ok {
+			return cmpFloat(af, bf), nil
+		}
+	}
+
+	_, aTime := a.(time.Time)
+	_, bTime := b.(time.Time)
+	if aTime || bTime {
+		at, err := ToTime(a)
+		if err != nil {
+			return 0, err
+		}
+		bt, err := ToTime(b)
+		if err != nil {
+			return 0, err
+		}
+		return at.Compare(bt), nil
+	}
+
+	if ab, ok := a.(bool); ok {
+		if bb, ok := b.(bool); ok {
+			switch {
+			case ab == bb:
+				return 0, nil
+			case !ab:
+				return -1, nil
+			default:
+				return 1, nil
+			}
+		}
+	}
+
+	return strings.Compare(ToString(a), ToString(b)), nil
+}
+
+func cmpFloat(a, b float64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	default:
+		return 0
+	}
+}
+
+// normalize widens integer and float types to int64 and float64
+func normalize(v interface{}) interface{} {
+	switch t := v.(type) {
+	case int:
+		return int64(t)
+	case int8:
+		return int64(t)
+	case int16:
+		return int64(t)
+	case int32:
+		return int64(t)
+	case uint8:
+		return int64(t)
+	case uint16:
+		return int64(t)
+	case uint32:
+		return int64(t)
+	case uint64:
+		return int64(t)
+	case float32:
+		return float64(t)
+	}
+	return v
+}
+
+func toFloat(v interface{}) (float64, bool) {
+	switch t := v.(type) {
+	case int64:
+		return float64(t), true
+	case float64:
+		return t, true
+	}
+	return 0, false
+}
+
+// ToString formats a value the way the string functions see it
+func ToString(v interface{}) string {
+	switch t := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return t
+	case []byte:
+		return string(t)
+	case time.Time:
+		return t.UTC().Format(time.RFC3339)
+	default:
+		return fmt.Sprint(t)
+	}
+}
+
+var timeLayouts = []string{time.RFC3339Nano, "2006-01-02 15:04:05", "2006-01-02"}
+
+// ToTime converts a time value or a date/time string to time.Time
+func ToTime(v interface{}) (time.Time, error) {
+	switch t := v.(type) {
+	case time.Time:
+		return t, nil
+	case string:
+		for _, layout := range timeLayouts {
+			if tm, err := time.Parse(layout, t); err == nil {
+				return tm, nil
+			}
+		}
+		return time.Time{}, fmt.Errorf("invalid time value %q", t)
+	}
+	return time.Time{}, fmt.Errorf("expected time, got %T", v)
+}
+
+// functions are the built-ins callable from expressions
+var functions = map[string]func(args []interface{}) (interface{}, error){
+	"lower":    stringFunc(strings.ToLower),
+	"upper":    stringFunc(strings.ToUpper),
+	"trim":     stringFunc(strings.TrimSpace),
+	"length":   fnLength,
+	"substr":   fnSubstr,
+	"concat":   fnConcat,
+	"coalesce": fnCoalesce,
+	"abs":      fnAbs,
+	"round":    fnRound,
+	"now":      func([]interface{}) (interface{}, error) { return time.Now().UTC(), nil },
+	"datediff": fnDateDiff,
+	"if":       fnIf,
+	"isnull":   fnIsNull,
+	"sha256":   fnSHA256,
+	"contains": fnContains,
+	"matches":  fnMatches,
+}
+
+func checkArgs(name string, args []interface{}, min, max int) error {
+	if len(args) < min || (max >= 0 && len(args) > max) {
+		return fmt.Errorf("wrong number of arguments to %s", name)
+	}
+	return nil
+}
+
+func stringFunc(fn func(string) string) func([]interface{}) (interface{}, error) {
+	return func(args []interface{}) (interface{}, error) {
+		if err := checkArgs("string function", args, 1, 1); err != nil {
+			return nil, err
+		}
+		if args[0] == nil {
+			return nil, nil
+		}
+		return fn(ToString(args[0])), nil
+	}
+}
+
+func fnLength(args []interface{}) (interface{}, error) {
+	if err := checkArgs("length", args, 1, 1); err != nil {
+		return nil, err
+	}
+	if args[0] == nil {
+		return nil, nil
+	}
+	return int64(len([]rune(ToString(args[0])))), nil
+}
+
+func fnSubstr(args []interface{}) (interface{}, error) {
+	if err := checkArgs("substr", args, 2, 3); err != nil {
+		return nil, err
+	}
+	if args[0] == nil {
+		return nil, nil
+	}
+	s := []rune(ToString(args[0]))
+	start, ok := normalize(args[1]).(int64)
+	if !ok {
+		return nil, fmt.Errorf("substr start must be an integer")
+	}
+	// SQL positions are 1-based
+	from := int(start) - 1
+	if from < 0 {
+		from = 0
+	}
+	if from > len(s) {
+		from = len(s)
+	}
+	to := len(s)
+	if len(args) == 3 {
+		n, ok := normalize(args[2]).(int64)
+		if !ok || n < 0 {
+			return nil, fmt.Errorf("substr length must be a non-negative integer")
+		}
+		if from+int(n) < to {
+			to = from + int(n)
+		}
+	}
+	return string(s[from:to]), nil
+}
+
+func fnConcat(args []interface{}) (interface{}, error) {
+	var sb strings.Builder
+	for _, a := range args {
+		sb.WriteString(ToString(a))
+	}
+	return sb.String(), nil
+}
+
+func fnCoalesce(args []interface{}) (interface{}, error) {
+	for _, a := range args {
+		if a != nil {
+			return a, nil
+		}
+	}
+	return nil, nil
+}
+
+func fnAbs(args []interface{}) (interface{}, error) {
+	if err := checkArgs("abs", args, 1, 1); err != nil {
+		return nil, err
+	}
+	switch v := normalize(args[0]).(type) {
+	case nil:
+		return nil, nil
+	case int64:
+		if v < 0 {
+			return -v, nil
+		}
+		return v, nil
+	case float64:
+		return math.Abs(v), nil
+	}
+	return nil, fmt.Errorf("abs requires a number")
+}
+
+func fnRound(args []interface{}) (interface{}, error) {
+	if err := checkArgs("round", args, 1, 2); err != nil {
+		return nil, err
+	}
+	if args[0] == nil {
+		return nil, nil
+	}
+	f, ok := toFloat(normalize(args[0]))
+	if !ok {
+		return nil, fmt.Errorf("round requires a number")
+	}
+	digits := int64(0)
+	if len(args) == 2 {
+		d, ok := normalize(args[1]).(int64)
+		if !ok {
+			return nil, fmt.Errorf("round digits must be an integer")
+		}
+		digits = d
+	}
+	scale := math.Pow(10, float64(digits))
+	return math.Round(f*scale) / scale, nil
+}
+
+// fnDateDiff returns the number of whole days from b to a
+func fnDateDiff(args []interface{}) (interface{}, error) {
+	if err := checkArgs("datediff", args, 2, 2); err != nil {
+		return nil, err
+	}
+	if args[0] == nil || args[1] == nil {
+		return nil, nil
+	}
+	a, err := ToTime(args[0])
+	if err != nil {
+		return nil, err
+	}
+	b, err := ToTime(args[1])
+	if err != nil {
+		return nil, err
+	}
+	return int64(a.Sub(b).Hours() / 24), nil
+}
+
+func fnIf(args []interface{}) (interface{}, error) {
+	if err := checkArgs("if", args, 3, 3); err != nil {
+		return nil, err
+	}
+	if Truthy(args[0]) {
+		return args[1], nil
+	}
+	return args[2], nil
+}
+
+func fnIsNull(args []interface{}) (interface{}, error) {
+	if err := checkArgs("isnull", args, 1, 1); err != nil {
+		return nil, err
+	}
+	return args[0] == nil, nil
+}
+
+func fnSHA256(args []interface{}) (interface{}, error) {
+	if err := checkArgs("sha256", args, 1, 1); err != nil {
+		return nil, err
+	}
+	if args[0] == nil {
+		return nil, nil
+	}
+	sum := sha256.Sum256([]byte(ToString(args[0])))
+	return hex.EncodeToString(sum[:]), nil
+}
+
+func fnContains(args []interface{}) (interface{}, error) {
+	if err := checkArgs("contains", args, 2, 2); err != nil {
+		return nil, err
+	}
+	if args[0] == nil || args[1] == nil {
+		return nil, nil
+	}
+	return strings.Contains(ToString(args[0]), ToString(args[1])), nil
+}
+
+func fnMatches(args []interface{}) (interface{}, error) {
+	if err := checkArgs("matches", args, 2, 2); err != nil {
+		return nil, err
+	}
+	if args[0] == nil || args[1] == nil {
+		return nil, nil
+	}
+	re, err := regexp.Compile(ToString(args[1]))
+	if err != nil {
+		return nil, fmt.Errorf("invalid pattern: %w", err)
+	}
+	return re.MatchString(ToString(args[0])), nil
+}
@@ -0,0 +1,450 @@
+// Package expr implements a small, side-effect free expression language used
+// for computed columns, write hooks and policy conditions.
+//
+// Expressions support literals (numbers, 'strings', true, false, null),
+// column references, arithmetic (+ - * / %), string concatenation (||),
+// comparisons (= != <> < <= > >=), boolean logic (AND OR NOT) and calls to
+// a fixed set of built-in functions. Evaluation cannot perform IO.
+package expr
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Expr is a parsed expression
+type Expr struct {
+	src  string
+	root node
+}
+
+// Parse compiles an expression
+func Parse(src string) (*Expr, error) {
+	toks, err := lex(src)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{toks: toks}
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.toks) {
+		return nil, fmt.Errorf("unexpected %q at position %d", p.toks[p.pos].text, p.toks[p.pos].pos)
+	}
+	return &Expr{src: src, root: root}, nil
+}
+
+// MustParse is like Parse but panics on error. It is intended for
+// expressions that are known to be valid at compile time.
+func MustParse(src string) *Expr {
+	e, err := Parse(src)
+	if err != nil {
+		panic(err)
+	}
+	return e
+}
+
+// String returns the source text of the expression
+func (e *Expr) String() string {
+	return e.src
+}
+
+// Columns returns the distinct identifiers referenced by the expression,
+// in order of first use. Built-in constants such as now are not included.
+func (e *Expr) Columns() []string {
+	var cols []string
+	seen := map[string]bool{}
+	walk(e.root, func(n node) {
+		if id, ok := n.(*identNode); ok && !seen[id.name] {
+			if _, builtin := constants[id.name]; builtin {
+				return
+			}
+			seen[id.name] = true
+			cols = append(cols, id.name)
+		}
+	})
+	return cols
+}
+
+type node interface{}
+
+type literalNode struct{ value interface{} }
+
+type identNode struct{ name string }
+
+type unaryNode struct {
+	op      string
+	operand node
+}
+
+type binaryNode struct {
+	op          string
+	left, right node
+}
+
+type callNode struct {
+	name string
+	args []node
+}
+
+func walk(n node, fn func(node)) {
+	fn(n)
+	switch v := n.(type) {
+	case *unaryNode:
+		walk(v.operand, fn)
+	case *binaryNode:
+		walk(v.left, fn)
+		walk(v.right, fn)
+	case *callNode:
+		for _, a := range v.args {
+			walk(a, fn)
+		}
+	}
+}
+
+type tokKind int
+
+const (
+	tNumber tokKind = iota
+	tString
+	tIdent
+	tOp
+	tLParen
+	tRParen
+	tComma
+)
+
+type tok struct {
+	kind tokKind
+	text string
+	pos  int
+}
+
+func lex(src string) ([]tok, error) {
+	var toks []tok
+	i := 0
+	for i < len(src) {
+		c := src[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '(':
+			toks = append(toks, tok{tLParen, "(", i})
+			i++
+		case c == ')':
+			toks = append(toks, tok{tRParen, ")", i})
+			i++
+		case c == ',':
+			toks = append(toks, tok{tComma, ",", i})
+			i++
+		case c == '\'' || c == '"':
+			start := i
+			var sb strings.Builder
+			i++
+			closed := false
+			for i < len(src) {
+				if src[i] == c {
+					if i+1 < len(src) && src[i+1] == c {
+						sb.WriteByte(c)
+						i += 2
+						continue
+					}
+					closed = true
+					i++
+					break
+				}
+				sb.WriteByte(src[i])
+				i++
+			}
+			if !closed {
+				return nil, fmt.Errorf("unterminated string at position %d", start)
+			}
+			toks = append(toks, tok{tString, sb.String(), start})
+		case c >= '0' && c <= '9' || (c == '.' && i+1 < len(src) && src[i+1] >= '0' && src[i+1] <= '9'):
+			start := i
+			for i < len(src) && (src[i] >= '0' && src[i] <= '9' || src[i] == '.' || src[i] == 'e' || src[i] == 'E') {
+				i++
+			}
+			toks = append(toks, tok{tNumber, src[start:i], start})
+		case c == '_' || unicode.IsLetter(rune(c)):
+			start := i
+			for i < len(src) && (src[i] == '_' || src[i] == '.' || unicode.IsLetter(rune(src[i])) || src[i] >= '0' && src[i] <= '9') {
+				i++
+			}
+			toks = append(toks, tok{tIdent, src[start:i], start})
+		default:
+			start := i
+			two := ""
+			if i+1 < len(src) {
+				two = src[i : i+2]
+			}
+			switch two {
+			case "||", "==", "!=", "<>", "<=", ">=":
+				toks = append(toks, tok{tOp, two, start})
+				i += 2
+				continue
+			}
+			if strings.ContainsRune("+-*/%<>=", rune(c)) {
+				toks = append(toks, tok{tOp, string(c), start})
+				i++
+				continue
+			}
+			return nil, fmt.Errorf("unexpected character %q at position %d", c, i)
+		}
+	}
+	return toks, nil
+}
+
+type parser struct {
+	toks []tok
+	pos  int
+}
+
+func (p *parser) peek() (tok, bool) {
+	if p.pos >= len(p.toks) {
+		return tok{}, false
+	}
+	return p.toks[p.pos], true
+}
+
+// acceptWord consumes the next token if it is the given keyword
+func (p *parser) acceptWord(word string) bool {
+	t, ok := p.peek()
+	if ok && t.kind == tIdent && strings.EqualFold(t.text, word) {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+// acceptOp consumes the next token if it is one of the given operators
+func (p *parser) acceptOp(ops ...string) (string, bool) {
+	t, ok := p.peek()
+	if !ok || t.kind != tOp {
+		return "", false
+	}
+	for _, op := range ops {
+		if t.text == op {
+			p.pos++
+			return op, true
+		}
+	}
+	return "", false
+}
+
+func (p *parser) parseOr() (node, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.acceptWord("OR") {
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = &binaryNode{op: "OR", left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseAnd() (node, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for p.acceptWord("AND") {
+		right, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		left = &binaryNode{op: "AND", left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseNot() (node, error) {
+	if p.acceptWord("NOT") {
+		operand, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return &unaryNode{op: "NOT", operand: operand}, nil
+	}
+	return p.parseComparison()
+}
+
+func (p *parser) parseComparison() (node, error) {
+	left, err := p.parseConcat()
+	if err != nil {
+		return nil, err
+	}
+	if op, ok := p.acceptOp("=", "==", "!=", "<>", "<", "<=", ">", ">="); ok {
+		right, err := p.parseConcat()
+		if err != nil {
+			return nil, err
+		}
+		switch op {
+		case "==":
+			op = "="
+		case "<>":
+			op = "!="
+		}
+		return &binaryNode{op: op, left: left, right: right}, nil
+	}
+	if p.acceptWord("IS") {
+		negate := p.acceptWord("NOT")
+		if !p.acceptWord("NULL") {
+			return nil, fmt.Errorf("expected NULL after IS")
+		}
+		var n node = &callNode{name: "isnull", args: []node{left}}
+		if negate {
+			n = &unaryNode{op: "NOT", operand: n}
+		}
+		return n, nil
+	}
+	return left, nil
+}
+
+func (p *parser) parseConcat() (node, error) {
+	left, err := p.parseAdditive()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		if _, ok := p.acceptOp("||"); !ok {
+			return left, nil
+		}
+		right, err := p.parseAdditive()
+		if err != nil {
+			return nil, err
+		}
+		left = &binaryNode{op: "||", left: left, right: right}
+	}
+}
+
+func (p *parser) parseAdditive() (node, error) {
+	left, err := p.parseMultiplicative()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		op, ok := p.acceptOp("+", "-")
+		if !ok {
+			return left, nil
+		}
+		right, err := p.parseMultiplicative()
+		if err != nil {
+			return nil, err
+		}
+		left = &binaryNode{op: op, left: left, right: right}
+	}
+}
+
+func (p *parser) parseMultiplicative() (node, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		op, ok := p.acceptOp("*", "/", "%")
+		if !ok {
+			return left, nil
+		}
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = &binaryNode{op: op, left: left, right: right}
+	}
+}
+
+func (p *parser) parseUnary() (node, error) {
+	if _, ok := p.acceptOp("-"); ok {
+		operand, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &unaryNode{op: "-", operand: operand}, nil
+	}
+	return p.parsePrimary()
+}
+
+func (p *parser) parsePrimary() (node, error) {
+	t, ok := p.peek()
+	if !ok {
+		return nil, fmt.Errorf("unexpected end of expression")
+	}
+	p.pos++
+
+	switch t.kind {
+	case tNumber:
+		if i, err := strconv.ParseInt(t.text, 10, 64); err == nil {
+			return &literalNode{value: i}, nil
+		}
+		f, err := strconv.ParseFloat(t.text, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %q", t.text)
+		}
+		return &literalNode{value: f}, nil
+	case tString:
+		return &literalNode{value: t.text}, nil
+	case tLParen:
+		inner, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if next, ok := p.peek(); !ok || next.kind != tRParen {
+			return nil, fmt.Errorf("missing closing parenthesis")
+		}
+		p.pos++
+		return inner, nil
+	case tIdent:
+		switch strings.ToLower(t.text) {
+		case "true":
+			return &literalNode{value: true}, nil
+		case "false":
+			return &literalNode{value: false}, nil
+		case "null":
+			return &literalNode{value: nil}, nil
+		}
+		if next, ok := p.peek(); ok && next.kind == tLParen {
+			p.pos++
+			return p.parseCall(strings.ToLower(t.text))
+		}
+		return &identNode{name: t.text}, nil
+	}
+	return nil, fmt.Errorf("unexpected %q at position %d", t.text, t.pos)
+}
+
+func (p *parser) parseCall(name string) (node, error) {
+	if _, ok := functions[name]; !ok {
+		return nil, fmt.Errorf("unknown function %s", name)
+	}
+	call := &callNode{name: name}
+	if next, ok := p.peek(); ok && next.kind == tRParen {
+		p.pos++
+		return call, nil
+	}
+	for {
+		arg, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		call.args = append(call.args, arg)
+
+		next, ok := p.peek()
+		if !ok {
+			return nil, fmt.Errorf("missing closing parenthesis in call to %s", name)
+		}
+		p.pos++
+		if next.kind == tRParen {
+			return call, nil
+		}
+		if next.kind != tComma {
+			return nil, fmt.Errorf("unexpected %q in call to %s", next.text, name)
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package expr
+
+import (
+	"testing"
+	"time"
+)
+
+func TestEval(t *testing.T) {
+	env := MapEnv{
+		"first": "Ada",
+		"last":  "Lovelace",
+		"qty":   int32(3),
+		"price": 2.5,
+		"dob":   time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC),
+		"note":  nil,
+	}
+
+	tests := []struct {
+		src  string
+		want interface{}
+	}{
+		{"first || ' ' || last", "Ada Lovelace"},
+		{"price * qty", 7.5},
+		{"qty + 1 = 4", true},
+		{"qty > 1 AND NOT (last = 'Byron')", true},
+		{"upper(substr(first, 1, 2))", "AD"},
+		{"coalesce(note, 'none')", "none"},
+		{"note IS NULL", true},
+		{"datediff('2000-01-31', dob)", int64(30)},
+		{"if(price > 2, 'high', 'low')", "high"},
+	}
+
+	for _, tt := range tests {
+		e, err := Parse(tt.src)
+		if err != nil {
+			t.Fatalf("parse %q: %v", tt.src, err)
+		}
+		got, err := e.Eval(env)
+		if err != nil {
+			t.Fatalf("eval %q: %v", tt.src, err)
+		}
+		if got != tt.want {
+			t.Errorf("%q = %v (%T), want %v (%T)", tt.src, got, got, tt.want, tt.want)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, src := range []string{"1 +", "unknown_fn(1)", "(a = 1", "'open"} {
+		if _, err := Parse(src); err == nil {
+			t.Errorf("expected error parsing %q", src)
+		}
+	}
+}
+
+func TestColumns(t *testing.T) {
+	e := MustParse("datediff(now, dob) > 18 AND name = name")
+	cols := e.Columns()
+	if len(cols) != 2 || cols[0] != "dob" || cols[1] != "name" {
+		t.Fatalf("unexpected columns: %v", cols)
+	}
+}
@@ -0,0 +1,237 @@
+package format
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"time"
+
+	"github.com/TFMV/lockbox/pkg/crypto"
+	"github.com/TFMV/lockbox/pkg/metadata"
+)
+
+// ErrNoColumnKey is returned when a reader lacks the key of a column, e.g.
+// one opened with an access password that was not granted the column
+var ErrNoColumnKey = errors.New("no key for column")
+
+// GrantAccess lets password decrypt the given columns, and no others. A
+// grant with the same name is replaced. Columns of a column group share a
+// key, so a grant includes either all of a group's columns or none. Columns
+// with blocks or keys at key epoch 0 are refused with ErrSharedColumnKey
+// and have to be rotated first. The metadata still has to be saved.
+func (lbf *LockboxFile) GrantAccess(masterKey *crypto.Key, name, password string, columns []string) error {
+	enc := &lbf.metadata.Encryption
+	if len(enc.WrappedMasterKey) == 0 {
+		return fmt.Errorf("master key is not wrapped with a password; set one before granting access")
+	}
+	if name == "" || password == "" {
+		return fmt.Errorf("access key name and password are required")
+	}
+	if err := checkNewPassword(password); err != nil {
+		return err
+	}
+	if len(columns) == 0 {
+		return fmt.Errorf("access key %s needs at least one column", name)
+	}
+	var granted []string
+	for _, col := range columns {
+		if len(lbf.metadata.Schema.FieldIndices(col)) == 0 {
+			return fmt.Errorf("column %s not found", col)
+		}
+		if !slices.Contains(granted, col) {
+			granted = append(granted, col)
+		}
+	}
+	for _, col := range granted {
+		if g, ok := enc.FindColumnGroup(col); ok {
+			for _, member := range g.Columns {
+				if !slices.Contains(granted, member) {
+					return fmt.Errorf("column %s shares the key of group %s, so %s must be granted too", col, g.Name, member)
+				}
+			}
+		}
+	}
+	if _, err := lbf.MasterKey(password); err == nil {
+		return fmt.Errorf("password already unlocks the whole file")
+	}
+	if other, _, err := lbf.accessColumnKeys(password); err == nil && other.Name != name {
+		return fmt.Errorf("password is already used by access key %s", other.Name)
+	}
+
+	accessKey := make([]byte, crypto.KeySize)
+	salt := make([]byte, crypto.SaltSize)
+	if _, err := io.ReadFull(rand.Reader, accessKey); err != nil {
+		return fmt.Errorf("failed to generate access key: %w", err)
+	}
+	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+		return fmt.Errorf("failed to generate salt: %w", err)
+	}
+	kek := lbf.deriveKey(password, salt)
+	if kek == nil {
+		return fmt.Errorf("failed to derive key-encryption key")
+	}
+	wrapped, err := crypto.WrapKey(kek.Data, accessKey)
+	if err != nil {
+		return fmt.Errorf("failed to wrap access key: %w", err)
+	}
+	masterWrapped, err := crypto.WrapKey(masterAccessKEK(masterKey, salt), accessKey)
+	if err != nil {
+		return fmt.Errorf("failed to wrap access key: %w", err)
+	}
+
+	ak := metadata.AccessKey{
+		Name:             name,
+		Columns:          granted,
+		Salt:             salt,
+		WrappedKey:       wrapped,
+		MasterWrappedKey: masterWrapped,
+		CreatedAt:        time.Now(),
+	}
+	if err := lbf.sealAccessKey(masterKey, &ak, accessKey); err != nil {
+		return err
+	}
+	if existing, ok := enc.FindAccessKey(name); ok {
+		*existing = ak
+	} else {
+		enc.AccessKeys = append(enc.AccessKeys, ak)
+	}
+	return nil
+}
+
+// RevokeAccess removes an access key. Key material already read with its
+// password stays valid for the current ciphertext, so columns that must be
+// cut off should also have their keys rotated. The metadata still has to
+// be saved.
+func (lbf *LockboxFile) RevokeAccess(name string) error {
+	enc := &lbf.metadata.Encryption
+	for i, ak := range enc.AccessKeys {
+		if ak.Name == name {
+			enc.AccessKeys = append(enc.AccessKeys[:i], enc.AccessKeys[i+1:]...)
+			return nil
+		}
+	}
+	return fmt.Errorf("access key %s not found", name)
+}
+
+// masterAccessKEK derives the key that wraps an access key under the master
+// key
+func masterAccessKEK(masterKey *crypto.Key, salt []byte) []byte {
+	return crypto.DeriveColumnKey(masterKey.Data, "#access", salt)
+}
+
+// sealAccessKey seals the key material of the granted columns with the
+// access key: every key epoch still used by a block and the current one.
+// A column group's key is sealed once for all of its columns, and the key
+// of encrypted metadata is wrapped too.
+func (lbf *LockboxFile) sealAccessKey(masterKey *crypto.Key, ak *metadata.AccessKey, accessKey []byte) error {
+	enc := lbf.metadata.Encryption
+	var keys []*ColumnKey
+	var sealed []string
+	for _, col := range ak.Columns {
+		name := enc.KeyName(col)
+		if slices.Contains(sealed, name) {
+			continue
+		}
+		sealed = append(sealed, name)
+		epochs := []int{enc.ColumnEpoch(col)}
+		for _, bi := range lbf.metadata.BlockInfo {
+			if enc.KeyName(bi.ColumnName) == name && !slices.Contains(epochs, bi.KeyEpoch) {
+				epochs = append(epochs, bi.KeyEpoch)
+			}
+		}
+		for _, epoch := range epochs {
+			ck, err := lbf.columnKey(masterKey, name, epoch)
+			if err != nil {
+				return fmt.Errorf("failed to seal keys of access key %s (revoke it and grant it again): %w", ak.Name, err)
+			}
+			keys = append(keys, ck)
+		}
+	}
+
+	plaintext, err := json.Marshal(keys)
+	if err != nil {
+		return fmt.Errorf("failed to encode column keys: %w", err)
+	}
+	if ak.ColumnKeys, err = crypto.WrapKey(accessKey, plaintext); err != nil {
+		return fmt.Errorf("failed to seal column keys of access key %s: %w", ak.Name, err)
+	}
+	if lbf.metadataKey != nil {
+		if ak.MetadataKey, err = crypto.WrapKey(accessKey, lbf.metadataKey); err != nil {
+			return fmt.Errorf("failed to wrap metadata key for access key %s: %w", ak.Name, err)
+		}
+	}
+	return nil
+}
+
+// resealAccessKeys updates the key material of every access key after the
+// key epochs of columns changed
+func (lbf *LockboxFile) resealAccessKeys(masterKey *crypto.Key) error {
+	enc := &lbf.metadata.Encryption
+	for i := range enc.AccessKeys {
+		ak := &enc.AccessKeys[i]
+		accessKey, err := crypto.UnwrapKey(masterAccessKEK(masterKey, ak.Salt), ak.MasterWrappedKey)
+		if err != nil {
+			return fmt.Errorf("failed to unwrap access key %s: %w", ak.Name, err)
+		}
+		if err := lbf.sealAccessKey(masterKey, ak, accessKey); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// accessColumnKeys returns the access key that password unlocks and its
+// column keys, or ErrInvalidPassword
+func (lbf *LockboxFile) accessColumnKeys(password string) (*metadata.AccessKey, []*ColumnKey, error) {
+	enc := &lbf.metadata.Encryption
+	for i := range enc.AccessKeys {
+		ak := &enc.AccessKeys[i]
+		kek := lbf.deriveKey(password, ak.Salt)
+		if kek == nil {
+			continue
+		}
+		accessKey, err := crypto.UnwrapKey(kek.Data, ak.WrappedKey)
+		if err != nil {
+			continue
+		}
+		plaintext, err := crypto.UnwrapKey(accessKey, ak.ColumnKeys)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to open column keys of access key %s: %w", ak.Name, err)
+		}
+		var keys []*ColumnKey
+		if err := json.Unmarshal(plaintext, &keys); err != nil {
+			return nil, nil, fmt.Errorf("failed to decode column keys of access key %s: %w", ak.Name, err)
+		}
+		return ak, keys, nil
+	}
+	return nil, nil, ErrInvalidPassword
+}
+
+// AccessKeyName returns the name of the access key that password unlocks
+func (lbf *LockboxFile) AccessKeyName(password string) (string, bool) {
+	ak, _, err := lbf.accessColumnKeys(password)
+	if err != nil {
+		return "", false
+	}
+	return ak.Name, true
+}
+
+// CanDecrypt reports whether the reader holds the keys of every block of a
+// column
+func (r *Reader) CanDecrypt(column string) bool {
+	key := r.file.metadata.Encryption.KeyName(column)
+	blocks := 0
+	for _, bi := range r.file.metadata.BlockInfo {
+		if bi.ColumnName != column {
+			continue
+		}
+		blocks++
+		if !r.has(key, bi.KeyEpoch) {
+			return false
+		}
+	}
+	return blocks > 0 || r.has(key, r.file.metadata.Encryption.ColumnEpoch(column))
+}
@@ -0,0 +1,39 @@
+package format
+
+import (
+	"fmt"
+
+	"github.com/TFMV/lockbox/pkg/codec"
+	"github.com/TFMV/lockbox/pkg/metadata"
+)
+
+// SetCodec selects the codec used to encode column blocks before they are
+// encrypted. The empty name or "none" stores blocks uncompressed.
+func (w *Writer) SetCodec(name string) error {
+	if name == "" || name == codec.None {
+		w.codec = nil
+		return nil
+	}
+	c, ok := codec.Get(name)
+	if !ok {
+		return fmt.Errorf("codec %s is not registered", name)
+	}
+	w.codec = c
+	return nil
+}
+
+// decodeBlock reverses the codec recorded for a block after decryption
+func decodeBlock(bi metadata.BlockInfo, data []byte) ([]byte, error) {
+	if bi.Codec == "" || bi.Codec == codec.None {
+		return data, nil
+	}
+	c, ok := codec.Get(bi.Codec)
+	if !ok {
+		return nil, fmt.Errorf("block for %s uses codec %s which is not available in this build", bi.ColumnName, bi.Codec)
+	}
+	dec, err := c.Decode(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode %s with %s: %w", bi.ColumnName, bi.Codec, err)
+	}
+	return dec, nil
+}
@@ -0,0 +1,203 @@
+package format
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/TFMV/lockbox/pkg/metadata"
+	"github.com/apache/arrow-go/v18/arrow"
+	"github.com/apache/arrow-go/v18/arrow/array"
+	"github.com/rs/zerolog/log"
+)
+
+// ReplaceColumn rewrites every block of a column with the values of col,
+// which must have the column's type and one value for each row in the file,
+// or for each row not deleted, in which case deleted rows are stored null.
+// The new blocks keep the row ranges of the old ones and are appended to
+// the file; other columns are not touched and the old blocks are left for
+// gc. The change is committed as a snapshot with the pending commit.
+// Columns of a column group share their blocks and cannot be replaced.
+func (w *Writer) ReplaceColumn(column string, col arrow.Array) error {
+	meta := w.file.metadata
+	indices := meta.Schema.FieldIndices(column)
+	if len(indices) == 0 {
+		return fmt.Errorf("column %s not found", column)
+	}
+	if g, ok := meta.Encryption.FindColumnGroup(column); ok {
+		return fmt.Errorf("column %s is stored with column group %s and cannot be replaced on its own", column, g.Name)
+	}
+	field := meta.Schema.Field(indices[0])
+	colType := col.DataType()
+	if dt, ok := colType.(*arrow.DictionaryType); ok {
+		colType = dt.ValueType
+	}
+	if !arrow.TypeEqual(field.Type, colType) {
+		return fmt.Errorf("column %s has type %s, got %s", column, field.Type, col.DataType())
+	}
+
+	var blocks []*metadata.BlockInfo
+	for i := range meta.BlockInfo {
+		if bi := &meta.BlockInfo[i]; bi.ColumnName == column {
+			blocks = append(blocks, bi)
+		}
+	}
+	col, err := w.file.fillDeleted(col, blocks)
+	if err != nil {
+		return err
+	}
+	defer col.Release()
+	encoded, err := w.encodeColumnBlocks(field, col, blockRows(blocks))
+	if err != nil {
+		return err
+	}
+	codecName, offsets, err := w.appendColumnBlocks(encoded)
+	if err != nil {
+		return err
+	}
+	for i, bi := range blocks {
+		bi.Offset = offsets[i]
+		bi.Length = int64(len(encoded[i].data))
+		bi.Checksum = encoded[i].checksum[:]
+		bi.OrigSize = encoded[i].origSize
+		bi.Codec = codecName
+		bi.Compressed = codecName != ""
+		bi.KeyEpoch = meta.Encryption.ColumnEpoch(column)
+		bi.Stats = encoded[i].stats
+		if err := w.signBlock(&meta.Encryption, bi); err != nil {
+			return err
+		}
+	}
+
+	log.Debug().Str("column", column).Int("blocks", len(blocks)).Msg("Replaced column")
+	return w.commitColumn("replace-column", column, len(blocks))
+}
+
+// AddColumn adds field to the schema with the values of col, which must
+// hold one value for each row in the file, or for each row not deleted. The
+// column's blocks cover the same row ranges as those of the first column.
+// The change is committed as a snapshot with the pending commit.
+func (w *Writer) AddColumn(field arrow.Field, col arrow.Array) error {
+	meta := w.file.metadata
+	if len(meta.Schema.FieldIndices(field.Name)) > 0 {
+		return fmt.Errorf("column %s already exists", field.Name)
+	}
+
+	var template []*metadata.BlockInfo
+	if first := meta.Schema.Fields(); len(first) > 0 {
+		for i := range meta.BlockInfo {
+			if bi := &meta.BlockInfo[i]; bi.ColumnName == first[0].Name {
+				template = append(template, bi)
+			}
+		}
+	}
+	col, err := w.file.fillDeleted(col, template)
+	if err != nil {
+		return err
+	}
+	defer col.Release()
+	rows := blockRows(template)
+	encoded, err := w.encodeColumnBlocks(field, col, rows)
+	if err != nil {
+		return err
+	}
+	codecName, offsets, err := w.appendColumnBlocks(encoded)
+	if err != nil {
+		return err
+	}
+	for i, n := range rows {
+		block := meta.AddBlockInfo(field.Name, offsets[i], int64(len(encoded[i].data)), n,
+			encoded[i].checksum[:], encoded[i].origSize, "", codecName)
+		block.KeyEpoch = meta.Encryption.ColumnEpoch(field.Name)
+		block.RowGroup = template[i].RowGroup
+		block.Stats = encoded[i].stats
+		if err := w.signBlock(&meta.Encryption, block); err != nil {
+			return err
+		}
+	}
+
+	md := meta.Schema.Metadata()
+	meta.Schema = arrow.NewSchema(append(meta.Schema.Fields(), field), &md)
+
+	log.Debug().Str("column", field.Name).Int("blocks", len(rows)).Msg("Added column")
+	return w.commitColumn("add-column", field.Name, len(rows))
+}
+
+// encodedBlock is a column block ready to be appended to the file
+type encodedBlock struct {
+	data     []byte
+	checksum [32]byte
+	origSize int64
+	stats    *metadata.BlockStats
+}
+
+// blockRows returns the row count of each block
+func blockRows(blocks []*metadata.BlockInfo) []int64 {
+	rows := make([]int64, len(blocks))
+	for i, bi := range blocks {
+		rows[i] = bi.RowCount
+	}
+	return rows
+}
+
+// encodeColumnBlocks splits col into blocks of the given row counts and
+// encodes each of them, without touching the file or the metadata
+func (w *Writer) encodeColumnBlocks(field arrow.Field, col arrow.Array, rows []int64) ([]encodedBlock, error) {
+	var total int64
+	for _, n := range rows {
+		total += n
+	}
+	if total != int64(col.Len()) {
+		return nil, fmt.Errorf("column %s has %d rows, got %d values", field.Name, total, col.Len())
+	}
+
+	mem := w.file.Allocator()
+	withRange := w.file.statsWithRange()
+	blocks := make([]encodedBlock, len(rows))
+	var start int64
+	for i, n := range rows {
+		slice := array.NewSlice(col, start, start+n)
+		data, checksum, origSize, err := w.encodeColumn(mem, field, slice)
+		stats := blockStats(slice, withRange)
+		slice.Release()
+		if err != nil {
+			return nil, err
+		}
+		blocks[i] = encodedBlock{data: data, checksum: checksum, origSize: origSize, stats: stats}
+		start += n
+	}
+	return blocks, nil
+}
+
+// appendColumnBlocks writes encoded blocks to the end of the file and
+// returns the codec they were written with and their offsets
+func (w *Writer) appendColumnBlocks(blocks []encodedBlock) (string, []int64, error) {
+	codecName := ""
+	if w.codec != nil {
+		codecName = w.codec.Name()
+		w.file.metadata.Encryption.AddCodec(codecName)
+	}
+
+	offsets := make([]int64, len(blocks))
+	for i, b := range blocks {
+		offset, err := w.file.appendData(b.data)
+		if err != nil {
+			return "", nil, fmt.Errorf("failed to write encrypted data: %w", err)
+		}
+		offsets[i] = offset
+	}
+	return codecName, offsets, nil
+}
+
+// commitColumn records a column rewrite in the audit log and as a snapshot
+// and saves the metadata
+func (w *Writer) commitColumn(action, column string, blocks int) error {
+	meta := w.file.metadata
+	meta.LogAccess("system", action, column, true, fmt.Sprintf("wrote %d blocks", blocks))
+	meta.AuditTrail.ModifiedAt = time.Now()
+	w.file.rebuildRollup()
+	w.addSnapshot(0)
+	if err := w.file.updateMetadata(); err != nil {
+		return fmt.Errorf("failed to update metadata: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,101 @@
+package format
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+
+	"github.com/TFMV/lockbox/pkg/metadata"
+	"github.com/apache/arrow-go/v18/arrow"
+	"github.com/apache/arrow-go/v18/arrow/ipc"
+)
+
+// WriteDerivedRecord encrypts a whole record as a single block that is not
+// part of the table data, such as a materialized view result. The block is
+// keyed by name, which must be stable so the record can be read back. The
+// returned BlockInfo is not added to the file's block list; callers store
+// it wherever the derived data is tracked and then save the metadata.
+func (w *Writer) WriteDerivedRecord(name string, record arrow.Record) (*metadata.BlockInfo, error) {
+	var buf bytes.Buffer
+	writer := ipc.NewWriter(&buf, ipc.WithSchema(record.Schema()), ipc.WithAllocator(w.file.Allocator()))
+	if err := writer.Write(record); err != nil {
+		writer.Close()
+		return nil, fmt.Errorf("failed to serialize %s: %w", name, err)
+	}
+	if err := writer.Close(); err != nil {
+		return nil, fmt.Errorf("failed to serialize %s: %w", name, err)
+	}
+
+	encryptor, err := w.encryptor(name, 0)
+	if err != nil {
+		return nil, err
+	}
+	enc, err := encryptor.Encrypt(buf.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("failed to encrypt %s: %w", name, err)
+	}
+
+	blockStart, err := w.file.appendData(enc)
+	if err != nil {
+		return nil, fmt.Errorf("failed to write encrypted data: %w", err)
+	}
+
+	checksum := sha256.Sum256(enc)
+	bi := &metadata.BlockInfo{
+		ColumnName: name,
+		Offset:     blockStart,
+		Length:     int64(len(enc)),
+		RowCount:   record.NumRows(),
+		Checksum:   checksum[:],
+		OrigSize:   int64(buf.Len()),
+	}
+	if err := w.signBlock(&w.file.metadata.Encryption, bi); err != nil {
+		return nil, err
+	}
+	return bi, nil
+}
+
+// ReadDerivedRecord decrypts a block written by WriteDerivedRecord
+func (r *Reader) ReadDerivedRecord(bi metadata.BlockInfo) (arrow.Record, error) {
+	encryptedData := make([]byte, bi.Length)
+	if _, err := r.file.file.ReadAt(encryptedData, bi.Offset); err != nil {
+		return nil, fmt.Errorf("failed to read encrypted data for %s: %w", bi.ColumnName, err)
+	}
+
+	checksum := sha256.Sum256(encryptedData)
+	if !bytes.Equal(checksum[:], bi.Checksum) {
+		return nil, fmt.Errorf("%w: checksum mismatch for %s", ErrCorruptedBlock, bi.ColumnName)
+	}
+	if err := r.verifyBlock(r.file.metadata.Encryption, &bi); err != nil {
+		return nil, err
+	}
+
+	if r.masterKey == nil {
+		return nil, fmt.Errorf("reading %s requires the password", bi.ColumnName)
+	}
+	encryptor, err := r.encryptor(bi.ColumnName, 0)
+	if err != nil {
+		return nil, err
+	}
+	dec, err := encryptor.Decrypt(encryptedData)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt %s: %w", bi.ColumnName, err)
+	}
+	dec, err = decodeBlock(bi, dec)
+	if err != nil {
+		return nil, err
+	}
+
+	reader, err := ipc.NewReader(bytes.NewReader(dec), ipc.WithAllocator(r.file.Allocator()))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create reader for %s: %w", bi.ColumnName, err)
+	}
+	defer reader.Release()
+
+	rec, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read record for %s: %w", bi.ColumnName, err)
+	}
+	rec.Retain()
+	return rec, nil
+}
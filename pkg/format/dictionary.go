@@ -0,0 +1,81 @@
+package format
+
+import (
+	"fmt"
+
+	"github.com/apache/arrow-go/v18/arrow"
+	"github.com/apache/arrow-go/v18/arrow/array"
+	"github.com/apache/arrow-go/v18/arrow/memory"
+)
+
+// SetDictionary selects the columns whose blocks are stored dictionary
+// encoded, with each distinct value kept once. Dictionary arrays written to
+// other columns are stored as plain values. Readers return the type of the
+// schema either way.
+func (w *Writer) SetDictionary(columns []string) {
+	w.dictionary = make(map[string]bool, len(columns))
+	for _, c := range columns {
+		w.dictionary[c] = true
+	}
+}
+
+// storedColumn returns col in the encoding stored for the field, along with
+// the field to serialize it with. The returned array must be released.
+func (w *Writer) storedColumn(mem memory.Allocator, field arrow.Field, col arrow.Array) (arrow.Field, arrow.Array, error) {
+	dict, isDict := col.(*array.Dictionary)
+	switch {
+	case w.dictionary[field.Name] && !isDict:
+		dt := &arrow.DictionaryType{IndexType: arrow.PrimitiveTypes.Int32, ValueType: col.DataType()}
+		b := array.NewDictionaryBuilder(mem, dt)
+		defer b.Release()
+		if err := b.AppendArray(col); err != nil {
+			return field, nil, fmt.Errorf("failed to dictionary encode column %s: %w", field.Name, err)
+		}
+		field.Type = dt
+		return field, b.NewArray(), nil
+	case isDict && !w.dictionary[field.Name]:
+		plain, err := decodeDictionary(mem, dict)
+		if err != nil {
+			return field, nil, fmt.Errorf("column %s: %w", field.Name, err)
+		}
+		field.Type = plain.DataType()
+		return field, plain, nil
+	default:
+		field.Type = col.DataType()
+		col.Retain()
+		return field, col, nil
+	}
+}
+
+// plainColumn decodes a dictionary block read for a field whose schema
+// type is not a dictionary. It takes over the reference to col.
+func plainColumn(mem memory.Allocator, field arrow.Field, col arrow.Array) (arrow.Array, error) {
+	dict, ok := col.(*array.Dictionary)
+	if !ok || field.Type.ID() == arrow.DICTIONARY {
+		return col, nil
+	}
+	defer col.Release()
+	plain, err := decodeDictionary(mem, dict)
+	if err != nil {
+		return nil, fmt.Errorf("column %s: %w", field.Name, err)
+	}
+	return plain, nil
+}
+
+// decodeDictionary returns the values of a dictionary array as a plain array
+func decodeDictionary(mem memory.Allocator, dict *array.Dictionary) (arrow.Array, error) {
+	values := dict.Dictionary()
+	b := array.NewBuilder(mem, values.DataType())
+	defer b.Release()
+	b.Reserve(dict.Len())
+	for i := 0; i < dict.Len(); i++ {
+		if dict.IsNull(i) {
+			b.AppendNull()
+			continue
+		}
+		if err := b.AppendValueFromString(values.ValueStr(dict.GetValueIndex(i))); err != nil {
+			return nil, fmt.Errorf("failed to decode dictionary: %w", err)
+		}
+	}
+	return b.NewArray(), nil
+}
@@ -7,11 +7,15 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"math"
 	"os"
 	"runtime"
 	"sync"
+	"time"
 
+	"github.com/TFMV/lockbox/pkg/codec"
 	"github.com/TFMV/lockbox/pkg/crypto"
+	"github.com/TFMV/lockbox/pkg/geo"
 	"github.com/TFMV/lockbox/pkg/metadata"
 	"github.com/apache/arrow-go/v18/arrow"
 	"github.com/apache/arrow-go/v18/arrow/array"
@@ -23,32 +27,89 @@ import (
 // ErrCorruptedBlock is returned when a data block fails checksum validation
 var ErrCorruptedBlock = errors.New("corrupted data block")
 
+// ErrModuleUnavailable is returned when a file needs a crypto module or
+// codec that is not registered in this build
+var ErrModuleUnavailable = errors.New("module not available")
+
 // LockboxFile represents a lockbox file handle
 type LockboxFile struct {
-	file     *os.File
+	file     Storage
+	name     string
 	metadata *metadata.Metadata
 	readonly bool
 	module   crypto.Module
+
+	// metadataOnly is set for files opened without credentials
+	metadataOnly bool
+
+	// metadataKey seals the metadata of files with encrypted metadata
+	metadataKey []byte
+
+	// mem allocates the Arrow buffers of records read from the file
+	mem memory.Allocator
 }
 
 // Writer handles writing encrypted Arrow data to lockbox files
 type Writer struct {
+	*keyring
 	file       *LockboxFile
-	encryptors map[string]*crypto.ColumnEncryptor
-	masterKey  []byte
-	module     crypto.Module
+	codec      codec.Codec
+	commit     metadata.Snapshot
+	dictionary map[string]bool
 }
 
 // Reader handles reading encrypted Arrow data from lockbox files
 type Reader struct {
-	file       *LockboxFile
-	encryptors map[string]*crypto.ColumnEncryptor
-	masterKey  []byte
-	module     crypto.Module
+	*keyring
+	file *LockboxFile
 }
 
 // Create creates a new lockbox file
 func Create(filename string, schema *arrow.Schema, password string, createdBy string, module crypto.Module) (*LockboxFile, error) {
+	meta, module, err := newFileMetadata(schema, password, createdBy, module)
+	if err != nil {
+		return nil, err
+	}
+
+	// Create file; only the owner may read it until the caller decides
+	// otherwise
+	file, err := os.OpenFile(filename, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create file: %w", err)
+	}
+
+	lbf, err := create(fileStorage{file}, filename, meta, module)
+	if err != nil {
+		file.Close()
+		os.Remove(filename)
+		return nil, err
+	}
+	return lbf, nil
+}
+
+// CreateOn creates a new lockbox file in storage, which must be empty.
+// name identifies the file in logs and errors.
+func CreateOn(storage Storage, name string, schema *arrow.Schema, password string, createdBy string, module crypto.Module) (*LockboxFile, error) {
+	size, err := storage.Size()
+	if err != nil {
+		return nil, err
+	}
+	if size != 0 {
+		return nil, fmt.Errorf("storage for %s is not empty", name)
+	}
+	meta, module, err := newFileMetadata(schema, password, createdBy, module)
+	if err != nil {
+		return nil, err
+	}
+	return create(storage, name, meta, module)
+}
+
+// newFileMetadata returns the metadata of a new file and the crypto module
+// it uses, the default one when module is nil
+func newFileMetadata(schema *arrow.Schema, password string, createdBy string, module crypto.Module) (*metadata.Metadata, crypto.Module, error) {
+	if err := checkNewPassword(password); err != nil {
+		return nil, nil, err
+	}
 	if module == nil {
 		module, _ = crypto.GetModule("default")
 	}
@@ -56,26 +117,31 @@ func Create(filename string, schema *arrow.Schema, password string, createdBy st
 	// Generate master key
 	masterKey, err := module.NewKey(password)
 	if err != nil {
-		return nil, fmt.Errorf("failed to generate master key: %w", err)
+		return nil, nil, fmt.Errorf("failed to generate master key: %w", err)
 	}
 
 	// Create metadata
 	meta, err := metadata.NewMetadata(schema, masterKey.Salt, createdBy)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create metadata: %w", err)
+		return nil, nil, fmt.Errorf("failed to create metadata: %w", err)
 	}
 
 	// Ensure schema is properly set
 	meta.Schema = schema
 
-	// Create file
-	file, err := os.Create(filename)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create file: %w", err)
+	// Record the module so readers can select it
+	meta.Encryption.Module = module.Name()
+	if pp, ok := module.(crypto.ParamsProvider); ok {
+		meta.Encryption.ModuleParams = pp.Params()
 	}
+	return meta, module, nil
+}
 
+// create writes the header and the initial metadata of a new file
+func create(storage Storage, name string, meta *metadata.Metadata, module crypto.Module) (*LockboxFile, error) {
 	lbf := &LockboxFile{
-		file:     file,
+		file:     storage,
+		name:     name,
 		metadata: meta,
 		readonly: false,
 		module:   module,
@@ -83,55 +149,145 @@ func Create(filename string, schema *arrow.Schema, password string, createdBy st
 
 	// Write header and metadata
 	if err := lbf.writeHeader(); err != nil {
-		file.Close()
-		os.Remove(filename)
 		return nil, fmt.Errorf("failed to write header: %w", err)
 	}
 
 	// Write initial metadata with schema
 	if err := lbf.updateMetadata(); err != nil {
-		file.Close()
-		os.Remove(filename)
 		return nil, fmt.Errorf("failed to write initial metadata: %w", err)
 	}
 
-	log.Info().Str("file", filename).Msg("Created lockbox file")
+	log.Info().Str("file", name).Msg("Created lockbox file")
 	return lbf, nil
 }
 
 // Open opens an existing lockbox file
+//
+// A nil module selects the crypto module recorded in the file. Opening fails
+// with ErrModuleUnavailable when the file needs a module or codec that is
+// not registered, or when module differs from the one the file was written with.
 func Open(filename string, password string, module crypto.Module) (*LockboxFile, error) {
-	if module == nil {
-		module, _ = crypto.GetModule("default")
-	}
 	file, err := os.OpenFile(filename, os.O_RDWR, 0644)
 	if err != nil {
 		return nil, fmt.Errorf("failed to open file: %w", err)
 	}
+	lbf, err := OpenOn(fileStorage{file}, filename, password, module)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return lbf, nil
+}
 
+// OpenOn opens the lockbox file held in storage as Open does. name
+// identifies the file in logs and errors. The storage is not closed when
+// opening fails.
+func OpenOn(storage Storage, name string, password string, module crypto.Module) (*LockboxFile, error) {
 	lbf := &LockboxFile{
-		file:     file,
+		file:     storage,
+		name:     name,
 		readonly: false,
 		module:   module,
 	}
+	if ro, ok := storage.(ReadOnlyStorage); ok {
+		lbf.readonly = ro.ReadOnly()
+	}
 
 	// Read header and metadata
 	if err := lbf.readHeader(); err != nil {
-		file.Close()
 		return nil, fmt.Errorf("failed to read header: %w", err)
 	}
 
-	// Verify password by attempting to derive key
-	derivedKey := module.DeriveKey(password, lbf.metadata.Encryption.MasterSalt)
-	if derivedKey == nil {
-		file.Close()
-		return nil, fmt.Errorf("invalid password")
+	module, err := selectModule(lbf.metadata.Encryption, module)
+	if err != nil {
+		return nil, err
+	}
+	lbf.module = module
+
+	// Encrypted metadata is unsealed first; the codecs are only known then
+	if password != "" && lbf.MetadataSealed() {
+		if err := lbf.unsealMetadata(password); err != nil {
+			return nil, err
+		}
+		if _, err := selectModule(lbf.metadata.Encryption, module); err != nil {
+			return nil, err
+		}
+	}
+
+	// Verify the password, which may be an access password for some of the
+	// columns; files opened with escrowed column keys or a recovery code
+	// have none
+	if password != "" {
+		if _, err := lbf.MasterKey(password); err != nil {
+			if _, ok := lbf.AccessKeyName(password); !ok || !errors.Is(err, ErrInvalidPassword) {
+				return nil, err
+			}
+		}
 	}
 
-	log.Info().Str("file", filename).Msg("Opened lockbox file")
+	// Replicas are copies of a snapshot and never change
+	if lbf.metadata.Replica != nil {
+		lbf.readonly = true
+	}
+
+	log.Info().Str("file", name).Msg("Opened lockbox file")
 	return lbf, nil
 }
 
+// selectModule resolves the crypto module for a file and checks that every
+// codec it uses is registered
+func selectModule(params metadata.EncryptionParams, requested crypto.Module) (crypto.Module, error) {
+	name := params.ModuleName()
+	if requested != nil && requested.Name() != name {
+		return nil, fmt.Errorf("%w: file was written with crypto module %q, not %q", ErrModuleUnavailable, name, requested.Name())
+	}
+	module := requested
+	if module == nil {
+		m, ok := crypto.GetModule(name)
+		if !ok {
+			return nil, fmt.Errorf("%w: file requires crypto module %q which is not registered in this build", ErrModuleUnavailable, name)
+		}
+		module = m
+	}
+
+	for _, c := range params.Codecs {
+		if _, ok := codec.Get(c); !ok {
+			return nil, fmt.Errorf("%w: file requires codec %q which is not registered in this build", ErrModuleUnavailable, c)
+		}
+	}
+	return module, nil
+}
+
+// Module returns the crypto module used by the file
+func (lbf *LockboxFile) Module() crypto.Module {
+	return lbf.module
+}
+
+// ReadOnly reports whether the file cannot be written, because its storage
+// is read-only or it is a replica
+func (lbf *LockboxFile) ReadOnly() bool {
+	return lbf.readonly
+}
+
+// Path returns the name the file was opened with
+func (lbf *LockboxFile) Path() string {
+	return lbf.name
+}
+
+// SetAllocator sets the allocator for the Arrow buffers of records read from
+// and serialized for the file
+func (lbf *LockboxFile) SetAllocator(mem memory.Allocator) {
+	lbf.mem = mem
+}
+
+// Allocator returns the allocator set with SetAllocator, or Go's allocator
+func (lbf *LockboxFile) Allocator() memory.Allocator {
+	if lbf.mem == nil {
+		return memory.DefaultAllocator
+	}
+	return lbf.mem
+}
+
 // Close closes the lockbox file
 func (lbf *LockboxFile) Close() error {
 	if lbf.file != nil {
@@ -150,6 +306,11 @@ func (lbf *LockboxFile) Metadata() *metadata.Metadata {
 	return lbf.metadata
 }
 
+// SaveMetadata persists in-memory metadata changes to the file
+func (lbf *LockboxFile) SaveMetadata() error {
+	return lbf.updateMetadata()
+}
+
 // NewWriter creates a new writer for the lockbox file
 func (lbf *LockboxFile) NewWriter(password string) (*Writer, error) {
 	if lbf.readonly {
@@ -162,184 +323,122 @@ func (lbf *LockboxFile) NewWriter(password string) (*Writer, error) {
 	}
 
 	// Derive master key
-	masterKey := module.DeriveKey(password, lbf.metadata.Encryption.MasterSalt)
-	if masterKey == nil {
-		return nil, fmt.Errorf("failed to derive master key")
+	masterKey, err := lbf.MasterKey(password)
+	if err != nil {
+		return nil, err
 	}
 
-	// Create column encryptors
-	encryptors := make(map[string]*crypto.ColumnEncryptor)
-	for i, field := range lbf.metadata.Schema.Fields() {
-		columnKey := crypto.DeriveColumnKey(masterKey.Data, field.Name, lbf.metadata.Encryption.MasterSalt)
-		encryptorIntf, err := module.NewEncryptor(columnKey)
-		if err != nil {
-			return nil, fmt.Errorf("failed to create encryptor for column %s: %w", field.Name, err)
-		}
-		encryptor := encryptorIntf.(*crypto.ColumnEncryptor)
-
-		// Initialize post-quantum components
-		if masterKey.KyberPublicKey != nil && masterKey.KyberSecretKey != nil {
-			encryptor.KyberPublicKey = masterKey.KyberPublicKey
-			encryptor.KyberSecretKey = masterKey.KyberSecretKey
-		}
-
-		encryptors[field.Name] = encryptor
-		log.Debug().Str("column", field.Name).Int("index", i).Msg("Created column encryptor")
-	}
+	// Column encryptors are derived on first use, so opening does not
+	// depend on the width of the schema
+	keys := newKeyring(lbf, module, masterKey)
+	keys.writable = true
 
-	return &Writer{
-		file:       lbf,
-		encryptors: encryptors,
-		masterKey:  masterKey.Data,
-		module:     module,
-	}, nil
+	return &Writer{keyring: keys, file: lbf}, nil
 }
 
 // NewReader creates a new reader for the lockbox file
 func (lbf *LockboxFile) NewReader(password string) (*Reader, error) {
+	if lbf.metadataOnly {
+		return nil, ErrMetadataOnly
+	}
 	module := lbf.module
 	if module == nil {
 		module, _ = crypto.GetModule("default")
 	}
 
-	// Derive master key
-	masterKey := module.DeriveKey(password, lbf.metadata.Encryption.MasterSalt)
-	if masterKey == nil {
-		return nil, fmt.Errorf("failed to derive master key")
-	}
-
-	// Create column encryptors
-	encryptors := make(map[string]*crypto.ColumnEncryptor)
-	for i, field := range lbf.metadata.Schema.Fields() {
-		columnKey := crypto.DeriveColumnKey(masterKey.Data, field.Name, lbf.metadata.Encryption.MasterSalt)
-		encryptorIntf, err := module.NewEncryptor(columnKey)
-		if err != nil {
-			return nil, fmt.Errorf("failed to create encryptor for column %s: %w", field.Name, err)
+	// Derive master key; an access password only gets the keys of the
+	// columns granted to it
+	masterKey, err := lbf.MasterKey(password)
+	if errors.Is(err, ErrInvalidPassword) {
+		if _, columnKeys, aerr := lbf.accessColumnKeys(password); aerr == nil {
+			return lbf.NewReaderFromKeys(columnKeys...)
 		}
-		encryptor := encryptorIntf.(*crypto.ColumnEncryptor)
-
-		// Initialize post-quantum components
-		if masterKey.KyberPublicKey != nil && masterKey.KyberSecretKey != nil {
-			encryptor.KyberPublicKey = masterKey.KyberPublicKey
-			encryptor.KyberSecretKey = masterKey.KyberSecretKey
-		}
-
-		encryptors[field.Name] = encryptor
-		log.Debug().Str("column", field.Name).Int("index", i).Msg("Created column encryptor")
 	}
-
-	return &Reader{
-		file:       lbf,
-		encryptors: encryptors,
-		masterKey:  masterKey.Data,
-		module:     module,
-	}, nil
-}
-
-// WriteRecord writes an encrypted Arrow record to the file
-func (w *Writer) WriteRecord(record arrow.Record) error {
-	mem := memory.NewGoAllocator()
-	defer record.Release()
-
-	type result struct {
-		field    arrow.Field
-		data     []byte
-		checksum [32]byte
-		origSize int64
-		err      error
+	if err != nil {
+		return nil, err
 	}
 
-	results := make([]result, len(record.Columns()))
-	var wg sync.WaitGroup
-	sem := make(chan struct{}, runtime.NumCPU())
+	// Column encryptors are derived on first use, so opening does not
+	// depend on the width of the schema
+	keys := newKeyring(lbf, module, masterKey)
 
-	for i, col := range record.Columns() {
-		field := record.Schema().Field(i)
-		wg.Add(1)
-		sem <- struct{}{}
-		go func(idx int, col arrow.Array, field arrow.Field) {
-			defer wg.Done()
-			defer func() { <-sem }()
-
-			var buf bytes.Buffer
-			batch := array.NewRecord(
-				arrow.NewSchema([]arrow.Field{field}, nil),
-				[]arrow.Array{col},
-				record.NumRows(),
-			)
-
-			writer := ipc.NewWriter(&buf, ipc.WithSchema(batch.Schema()), ipc.WithAllocator(mem))
-			if err := writer.Write(batch); err != nil {
-				batch.Release()
-				results[idx].err = fmt.Errorf("failed to serialize column %s: %w", field.Name, err)
-				return
-			}
-			writer.Close()
-			batch.Release()
-
-			origSize := int64(buf.Len())
-
-			encryptor, exists := w.encryptors[field.Name]
-			if !exists {
-				results[idx].err = fmt.Errorf("no encryptor for column %s", field.Name)
-				return
-			}
-
-			enc, err := encryptor.Encrypt(buf.Bytes())
-			if err != nil {
-				results[idx].err = fmt.Errorf("failed to encrypt column %s: %w", field.Name, err)
-				return
-			}
+	return &Reader{keyring: keys, file: lbf}, nil
+}
 
-			checksum := sha256.Sum256(enc)
-			results[idx] = result{field: field, data: enc, checksum: checksum, origSize: origSize}
-		}(i, col, field)
+// WriteRecord writes an encrypted Arrow record to the file. The caller
+// keeps ownership of record and releases it; the writer only retains its
+// columns while they are serialized, so a batch can be reused afterwards.
+// The columns of a column group are stored together in one block.
+func (w *Writer) WriteRecord(record arrow.Record) error {
+	enc := &w.file.metadata.Encryption
+	units, err := w.encodeRecord(record)
+	if err != nil {
+		return err
 	}
-	wg.Wait()
-	close(sem)
 
-	for _, r := range results {
-		if r.err != nil {
-			return r.err
-		}
+	codecName := ""
+	if w.codec != nil {
+		codecName = w.codec.Name()
+		enc.AddCodec(codecName)
 	}
 
-	for _, r := range results {
-		blockStart, err := w.file.file.Seek(0, io.SeekCurrent)
+	// The blocks of this write form a new row group. Blocks always go to
+	// the end of the file.
+	group := w.file.metadata.NumRowGroups()
+	first := len(w.file.metadata.BlockInfo)
+	withRange := w.file.statsWithRange()
+	for _, u := range units {
+		blockStart, err := w.file.appendData(u.data)
 		if err != nil {
-			return fmt.Errorf("failed to get block start position: %w", err)
-		}
-
-		if _, err := w.file.file.Write(r.data); err != nil {
 			return fmt.Errorf("failed to write encrypted data: %w", err)
 		}
 
-		mime := ""
-		if r.field.Metadata.Len() > 0 {
-			if v, ok := r.field.Metadata.GetValue("mime"); ok {
-				mime = v
+		// Every column of a group gets a block info pointing at the
+		// shared block
+		for k, field := range u.fields {
+			mime := ""
+			if field.Metadata.Len() > 0 {
+				if v, ok := field.Metadata.GetValue("mime"); ok {
+					mime = v
+				}
 			}
-		}
 
-		w.file.metadata.AddBlockInfo(
-			r.field.Name,
-			blockStart,
-			int64(len(r.data)),
-			record.NumRows(),
-			r.checksum[:],
-			r.origSize,
-			mime,
-		)
+			block := w.file.metadata.AddBlockInfo(
+				field.Name,
+				blockStart,
+				int64(len(u.data)),
+				record.NumRows(),
+				u.checksum[:],
+				u.origSize,
+				mime,
+				codecName,
+			)
+			block.Group = u.group
+			block.KeyEpoch = enc.ColumnEpoch(field.Name)
+			block.RowGroup = group
+			if bb, ok := geo.Bounds(field, u.cols[k]); ok {
+				block.BBox = &bb
+			}
+			block.Stats = blockStats(u.cols[k], withRange)
+			if err := w.signBlock(enc, block); err != nil {
+				return err
+			}
+		}
 
 		log.Debug().
-			Str("column", r.field.Name).
+			Str("column", u.fields[0].Name).
+			Str("group", u.group).
 			Int64("offset", blockStart).
-			Int("size", len(r.data)).
+			Int("size", len(u.data)).
 			Msg("Wrote encrypted column block")
 	}
 
+	w.file.rollupBlocks(first)
+
 	// Log access
 	w.file.metadata.LogAccess("system", "write", "record", true, fmt.Sprintf("wrote %d rows", record.NumRows()))
+	w.file.metadata.AuditTrail.ModifiedAt = time.Now()
+	w.addSnapshot(record.NumRows())
 
 	// Update metadata in file
 	if err := w.file.updateMetadata(); err != nil {
@@ -349,111 +448,147 @@ func (w *Writer) WriteRecord(record arrow.Record) error {
 	return nil
 }
 
-// ReadRecord reads and decrypts all columns from the file
-func (r *Reader) ReadRecord() (arrow.Record, error) {
-	mem := memory.NewGoAllocator()
-	schema := r.file.metadata.Schema
-
-	type result struct {
-		field arrow.Field
-		arr   arrow.Array
-		err   error
-	}
+// recordUnit is a column of a record, or the columns of a column group,
+// encoded as one block
+type recordUnit struct {
+	fields   []arrow.Field
+	cols     []arrow.Array
+	group    string
+	data     []byte
+	checksum [32]byte
+	origSize int64
+	err      error
+}
 
-	results := make([]result, len(schema.Fields()))
-	var wg sync.WaitGroup
+// encodeRecord encodes the blocks of a record concurrently, one per column
+// and one per column group, without touching the file or the metadata
+func (w *Writer) encodeRecord(record arrow.Record) ([]*recordUnit, error) {
+	mem := w.file.Allocator()
+	enc := &w.file.metadata.Encryption
 
-	for i, field := range schema.Fields() {
-		var blockInfo *metadata.BlockInfo
-		for _, block := range r.file.metadata.BlockInfo {
-			if block.ColumnName == field.Name {
-				blockInfo = &block
-				break
+	var units []*recordUnit
+	groups := make(map[string]*recordUnit)
+	for i, col := range record.Columns() {
+		field := record.Schema().Field(i)
+		u := &recordUnit{fields: []arrow.Field{field}, cols: []arrow.Array{col}}
+		if g, ok := enc.FindColumnGroup(field.Name); ok {
+			if shared, ok := groups[g.Name]; ok {
+				shared.fields = append(shared.fields, field)
+				shared.cols = append(shared.cols, col)
+				continue
 			}
+			u.group = g.Name
+			groups[g.Name] = u
 		}
+		units = append(units, u)
+	}
 
-		if blockInfo == nil {
-			return nil, fmt.Errorf("no block info for column %s", field.Name)
-		}
-
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, runtime.NumCPU())
+	for _, u := range units {
 		wg.Add(1)
-		go func(idx int, f arrow.Field, bi metadata.BlockInfo) {
+		sem <- struct{}{}
+		go func(u *recordUnit) {
 			defer wg.Done()
+			defer func() { <-sem }()
+			u.data, u.checksum, u.origSize, u.err = w.encodeColumns(mem, u.fields, u.cols)
+		}(u)
+	}
+	wg.Wait()
+	close(sem)
 
-			encryptedData := make([]byte, bi.Length)
-			if _, err := r.file.file.ReadAt(encryptedData, bi.Offset); err != nil {
-				results[idx].err = fmt.Errorf("failed to read encrypted data for column %s: %w", f.Name, err)
-				return
-			}
-
-			checksum := sha256.Sum256(encryptedData)
-			if !bytes.Equal(checksum[:], bi.Checksum) {
-				results[idx].err = fmt.Errorf("%w: checksum mismatch for column %s", ErrCorruptedBlock, f.Name)
-				return
-			}
-
-			encryptor, exists := r.encryptors[f.Name]
-			if !exists {
-				results[idx].err = fmt.Errorf("no encryptor for column %s", f.Name)
-				return
-			}
-
-			dec, err := encryptor.Decrypt(encryptedData)
-			if err != nil {
-				results[idx].err = fmt.Errorf("failed to decrypt column %s: %w", f.Name, err)
-				return
-			}
-
-			reader, err := ipc.NewReader(bytes.NewReader(dec), ipc.WithAllocator(mem))
-			if err != nil {
-				results[idx].err = fmt.Errorf("failed to create reader for column %s: %w", f.Name, err)
-				return
-			}
+	for _, u := range units {
+		if u.err != nil {
+			return nil, u.err
+		}
+	}
+	return units, nil
+}
 
-			rec, err := reader.Read()
-			if err != nil {
-				reader.Release()
-				results[idx].err = fmt.Errorf("failed to read record for column %s: %w", f.Name, err)
-				return
-			}
+// encodeColumn serializes, compresses and encrypts one column as a block.
+// It returns the ciphertext, its checksum and the serialized size before
+// compression.
+func (w *Writer) encodeColumn(mem memory.Allocator, field arrow.Field, col arrow.Array) ([]byte, [32]byte, int64, error) {
+	return w.encodeColumns(mem, []arrow.Field{field}, []arrow.Array{col})
+}
 
-			if rec.Column(0) == nil {
-				rec.Release()
-				reader.Release()
-				results[idx].err = fmt.Errorf("nil column data for %s", f.Name)
-				return
-			}
+// encodeColumns is encodeColumn for the columns of a column group, which
+// are stored in one block under the group's key
+func (w *Writer) encodeColumns(mem memory.Allocator, fields []arrow.Field, cols []arrow.Array) ([]byte, [32]byte, int64, error) {
+	var checksum [32]byte
+	params := w.file.metadata.Encryption
+	name := "column " + fields[0].Name
+	if g, ok := params.FindColumnGroup(fields[0].Name); ok {
+		name = "column group " + g.Name
+	}
 
-			col := rec.Column(0)
-			col.Retain()
-			results[idx] = result{field: f, arr: col}
-			rec.Release()
-			reader.Release()
+	stored := make([]arrow.Array, 0, len(cols))
+	defer func() {
+		for _, col := range stored {
+			col.Release()
+		}
+	}()
+	storedFields := make([]arrow.Field, len(fields))
+	for i := range fields {
+		field, col, err := w.storedColumn(mem, fields[i], cols[i])
+		if err != nil {
+			return nil, checksum, 0, err
+		}
+		storedFields[i] = field
+		stored = append(stored, col)
+	}
 
-			log.Debug().Str("column", f.Name).Int("index", idx).Msg("Read and decrypted column")
-		}(i, field, *blockInfo)
+	var buf bytes.Buffer
+	batch := array.NewRecord(
+		arrow.NewSchema(storedFields, nil),
+		stored,
+		int64(stored[0].Len()),
+	)
+
+	writer := ipc.NewWriter(&buf, ipc.WithSchema(batch.Schema()), ipc.WithAllocator(mem))
+	if err := writer.Write(batch); err != nil {
+		batch.Release()
+		return nil, checksum, 0, fmt.Errorf("failed to serialize %s: %w", name, err)
 	}
+	writer.Close()
+	batch.Release()
 
-	wg.Wait()
+	origSize := int64(buf.Len())
 
-	for _, rres := range results {
-		if rres.err != nil {
-			for _, rr := range results {
-				if rr.arr != nil {
-					rr.arr.Release()
-				}
-			}
-			return nil, rres.err
+	data := buf.Bytes()
+	if w.codec != nil {
+		encoded, err := w.codec.Encode(data)
+		if err != nil {
+			return nil, checksum, 0, fmt.Errorf("failed to encode %s with %s: %w", name, w.codec.Name(), err)
 		}
+		data = encoded
 	}
 
-	arrays := make([]arrow.Array, len(results))
-	for i, rres := range results {
-		arrays[i] = rres.arr
+	encryptor, err := w.encryptor(params.KeyName(fields[0].Name), params.ColumnEpoch(fields[0].Name))
+	if err != nil {
+		return nil, checksum, 0, err
 	}
 
-	resultRec := array.NewRecord(schema, arrays, -1)
+	enc, err := encryptor.Encrypt(data)
+	if err != nil {
+		return nil, checksum, 0, fmt.Errorf("failed to encrypt %s: %w", name, err)
+	}
+	return enc, sha256.Sum256(enc), origSize, nil
+}
 
+// ReadRecord reads and decrypts all columns from the file, with the rows of
+// every row group. Readers without the master key skip the columns they
+// have no keys for.
+func (r *Reader) ReadRecord() (arrow.Record, error) {
+	schema := r.file.metadata.Schema
+	if r.masterKey == nil {
+		schema = arrow.NewSchema(r.selectFields(nil), nil)
+	}
+	arrays, err := r.readRowGroups(r.rowGroupsFrom(0), schema.Fields())
+	if err != nil {
+		return nil, err
+	}
+	resultRec := array.NewRecord(schema, arrays, -1)
 	for _, arr := range arrays {
 		arr.Release()
 	}
@@ -463,160 +598,347 @@ func (r *Reader) ReadRecord() (arrow.Record, error) {
 	return resultRec, nil
 }
 
-// ReadColumns decrypts only the specified columns from the file
+// ReadColumns decrypts only the specified columns from the file, with the
+// rows of every row group
 func (r *Reader) ReadColumns(columns []string) (arrow.Record, error) {
-	mem := memory.NewGoAllocator()
+	return r.ReadColumnsFrom(0, columns)
+}
+
+// ReadColumnsFrom decrypts the specified columns, or all of them when
+// columns is empty, of row group first and every later one. It returns an
+// empty record when first is the number of row groups.
+func (r *Reader) ReadColumnsFrom(first int, columns []string) (arrow.Record, error) {
+	if first < 0 || first > r.NumRowGroups() {
+		return nil, fmt.Errorf("row group %d out of range [0, %d]", first, r.NumRowGroups())
+	}
+	return r.ReadColumnsIn(r.rowGroupsFrom(first), columns)
+}
+
+// ReadColumnsIn decrypts the specified columns, or all of them when columns
+// is empty, of the given row groups in that order, e.g. the row groups left
+// after pruning by bounding boxes. No row groups give an empty record.
+func (r *Reader) ReadColumnsIn(groups []int, columns []string) (arrow.Record, error) {
+	for _, n := range groups {
+		if n < 0 || n >= r.NumRowGroups() {
+			return nil, fmt.Errorf("row group %d out of range [0, %d)", n, r.NumRowGroups())
+		}
+	}
+	fields := r.selectFields(columns)
+	var arrays []arrow.Array
+	if len(groups) == 0 && r.NumRowGroups() > 0 {
+		mem := r.file.Allocator()
+		for _, field := range fields {
+			arrays = append(arrays, array.MakeArrayOfNull(mem, field.Type, 0))
+		}
+	} else {
+		var err error
+		if arrays, err = r.readRowGroups(groups, fields); err != nil {
+			return nil, err
+		}
+	}
+	record := array.NewRecord(arrow.NewSchema(fields, nil), arrays, -1)
+	for _, col := range arrays {
+		col.Release()
+	}
+
+	r.file.metadata.LogAccess("system", "read", "record", true, fmt.Sprintf("read %d rows", record.NumRows()))
+
+	return record, nil
+}
+
+// rowGroupsFrom lists row group first and every later one
+func (r *Reader) rowGroupsFrom(first int) []int {
+	var groups []int
+	for n := first; n < r.NumRowGroups(); n++ {
+		groups = append(groups, n)
+	}
+	return groups
+}
+
+// NumRowGroups returns the number of row groups in the file. Every write
+// stores one block per column, and those blocks form a row group.
+func (r *Reader) NumRowGroups() int {
+	return r.file.metadata.NumRowGroups()
+}
+
+// ReadRowGroup decrypts the given columns, or all of them when columns is
+// empty, of row group n
+func (r *Reader) ReadRowGroup(n int, columns []string) (arrow.Record, error) {
+	if n < 0 || n >= r.NumRowGroups() {
+		return nil, fmt.Errorf("row group %d out of range [0, %d)", n, r.NumRowGroups())
+	}
+	fields := r.selectFields(columns)
+	blocks, err := r.rowGroupBlocks(n, fields)
+	if err != nil {
+		return nil, err
+	}
+	arrays, err := r.readBlocks(fields, blocks)
+	if err != nil {
+		return nil, err
+	}
+	record := array.NewRecord(arrow.NewSchema(fields, nil), arrays, -1)
+	for _, col := range arrays {
+		col.Release()
+	}
+
+	r.file.metadata.LogAccess("system", "read", "record", true, fmt.Sprintf("read %d rows of row group %d", record.NumRows(), n))
+
+	return record, nil
+}
 
+// selectFields returns the schema fields named in columns, in schema order,
+// or all of them when columns is empty. Without the master key, "all of
+// them" means those the reader can decrypt.
+func (r *Reader) selectFields(columns []string) []arrow.Field {
 	colSet := make(map[string]struct{})
 	for _, c := range columns {
 		colSet[c] = struct{}{}
 	}
 
-	type result struct {
-		field arrow.Field
-		arr   arrow.Array
-		err   error
-	}
-
-	var selected []metadata.BlockInfo
-	var selectedFields []arrow.Field
-	schema := r.file.metadata.Schema
-	for _, field := range schema.Fields() {
+	var fields []arrow.Field
+	for _, field := range r.file.metadata.Schema.Fields() {
 		if len(colSet) > 0 {
 			if _, ok := colSet[field.Name]; !ok {
 				continue
 			}
+		} else if r.masterKey == nil && !r.CanDecrypt(field.Name) {
+			continue
 		}
+		fields = append(fields, field)
+	}
+	return fields
+}
 
-		var blockInfo *metadata.BlockInfo
-		for _, block := range r.file.metadata.BlockInfo {
-			if block.ColumnName == field.Name {
-				blockInfo = &block
+// rowGroupBlocks returns the block of each field in row group n
+func (r *Reader) rowGroupBlocks(n int, fields []arrow.Field) ([]metadata.BlockInfo, error) {
+	blocks := make([]metadata.BlockInfo, len(fields))
+	for i, field := range fields {
+		found := false
+		for _, bi := range r.file.metadata.BlockInfo {
+			if bi.ColumnName == field.Name && bi.RowGroup == n {
+				blocks[i], found = bi, true
 				break
 			}
 		}
-		if blockInfo == nil {
-			return nil, fmt.Errorf("no block info for column %s", field.Name)
+		if !found {
+			return nil, fmt.Errorf("column %s has no block in row group %d", field.Name, n)
 		}
-		selected = append(selected, *blockInfo)
-		selectedFields = append(selectedFields, field)
 	}
+	return blocks, nil
+}
 
-	results := make([]result, len(selected))
-	var wg sync.WaitGroup
-
-	for i, bi := range selected {
-		field := selectedFields[i]
-		wg.Add(1)
-		go func(idx int, f arrow.Field, bi metadata.BlockInfo) {
-			defer wg.Done()
+// readRowGroups decrypts the given row groups of fields and concatenates
+// the groups of each column. A single group is not copied.
+func (r *Reader) readRowGroups(groups []int, fields []arrow.Field) ([]arrow.Array, error) {
+	if r.NumRowGroups() == 0 && len(fields) > 0 {
+		return nil, fmt.Errorf("no block info for column %s", fields[0].Name)
+	}
 
-			encryptedData := make([]byte, bi.Length)
-			if _, err := r.file.file.ReadAt(encryptedData, bi.Offset); err != nil {
-				results[idx].err = fmt.Errorf("failed to read encrypted data for column %s: %w", f.Name, err)
-				return
+	parts := make([][]arrow.Array, len(fields))
+	release := func() {
+		for _, col := range parts {
+			for _, arr := range col {
+				arr.Release()
 			}
+		}
+	}
+	for _, n := range groups {
+		blocks, err := r.rowGroupBlocks(n, fields)
+		if err != nil {
+			release()
+			return nil, err
+		}
+		arrays, err := r.readBlocks(fields, blocks)
+		if err != nil {
+			release()
+			return nil, err
+		}
+		for i, arr := range arrays {
+			parts[i] = append(parts[i], arr)
+		}
+	}
+	if len(groups) == 1 {
+		arrays := make([]arrow.Array, len(fields))
+		for i := range parts {
+			arrays[i] = parts[i][0]
+		}
+		return arrays, nil
+	}
 
-			checksum := sha256.Sum256(encryptedData)
-			if !bytes.Equal(checksum[:], bi.Checksum) {
-				results[idx].err = fmt.Errorf("%w: checksum mismatch for column %s", ErrCorruptedBlock, f.Name)
-				return
+	defer release()
+	arrays := make([]arrow.Array, len(fields))
+	for i, field := range fields {
+		col, err := array.Concatenate(parts[i], r.file.Allocator())
+		if err != nil {
+			for _, arr := range arrays[:i] {
+				arr.Release()
 			}
+			return nil, fmt.Errorf("failed to concatenate row groups of column %s: %w", field.Name, err)
+		}
+		arrays[i] = col
+	}
+	return arrays, nil
+}
 
-			encryptor, exists := r.encryptors[f.Name]
-			if !exists {
-				results[idx].err = fmt.Errorf("no encryptor for column %s", f.Name)
-				return
-			}
+// readBlocks decrypts one block per field concurrently. Fields of a column
+// group share their block, which is decrypted once. Rows deleted from the
+// row group are left out. On error nothing is
+// returned and every decrypted array is released.
+func (r *Reader) readBlocks(fields []arrow.Field, blocks []metadata.BlockInfo) ([]arrow.Array, error) {
+	// The first field reading each block decrypts it
+	first := make(map[int64]int, len(blocks))
+	for i, bi := range blocks {
+		if _, ok := first[bi.Offset]; !ok {
+			first[bi.Offset] = i
+		}
+	}
 
-			dec, err := encryptor.Decrypt(encryptedData)
-			if err != nil {
-				results[idx].err = fmt.Errorf("failed to decrypt column %s: %w", f.Name, err)
-				return
+	records := make([]arrow.Record, len(blocks))
+	errs := make([]error, len(blocks))
+	var wg sync.WaitGroup
+	for _, idx := range first {
+		wg.Add(1)
+		go func(idx int) {
+			defer wg.Done()
+			records[idx], errs[idx] = r.readBlockRecord(fields[idx].Name, blocks[idx])
+		}(idx)
+	}
+	wg.Wait()
+	defer func() {
+		for _, rec := range records {
+			if rec != nil {
+				rec.Release()
 			}
+		}
+	}()
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
 
-			reader, err := ipc.NewReader(bytes.NewReader(dec), ipc.WithAllocator(mem))
-			if err != nil {
-				results[idx].err = fmt.Errorf("failed to create reader for column %s: %w", f.Name, err)
-				return
+	mem := r.file.Allocator()
+	arrays := make([]arrow.Array, len(blocks))
+	for i, bi := range blocks {
+		col, err := blockColumn(mem, fields[i], records[first[bi.Offset]])
+		if err != nil {
+			for _, arr := range arrays[:i] {
+				arr.Release()
 			}
+			return nil, err
+		}
+		arrays[i] = col
+		log.Debug().Str("column", fields[i].Name).Int("index", i).Msg("Read and decrypted column")
+	}
+	if len(blocks) > 0 {
+		if t, ok := r.file.metadata.FindTombstone(blocks[0].RowGroup); ok {
+			return dropDeleted(mem, arrays, t)
+		}
+	}
+	return arrays, nil
+}
 
-			rec, err := reader.Read()
-			if err != nil {
-				reader.Release()
-				results[idx].err = fmt.Errorf("failed to read record for column %s: %w", f.Name, err)
-				return
-			}
+// readBlock reads, verifies, decrypts and decodes a single column block
+func (r *Reader) readBlock(f arrow.Field, bi metadata.BlockInfo) (arrow.Array, error) {
+	rec, err := r.readBlockRecord(f.Name, bi)
+	if err != nil {
+		return nil, err
+	}
+	defer rec.Release()
+	return blockColumn(r.file.Allocator(), f, rec)
+}
 
-			if rec.Column(0) == nil {
-				rec.Release()
-				reader.Release()
-				results[idx].err = fmt.Errorf("nil column data for %s", f.Name)
-				return
-			}
+// readBlockRecord reads, verifies, decrypts and decodes the block of a
+// column. The record holds the column, or every column of its group.
+func (r *Reader) readBlockRecord(column string, bi metadata.BlockInfo) (arrow.Record, error) {
+	mem := r.file.Allocator()
 
-			col := rec.Column(0)
-			col.Retain()
-			results[idx] = result{field: f, arr: col}
-			rec.Release()
-			reader.Release()
-		}(i, field, bi)
+	encryptedData := make([]byte, bi.Length)
+	if _, err := r.file.file.ReadAt(encryptedData, bi.Offset); err != nil {
+		return nil, fmt.Errorf("failed to read encrypted data for column %s: %w", column, err)
 	}
 
-	wg.Wait()
+	checksum := sha256.Sum256(encryptedData)
+	if !bytes.Equal(checksum[:], bi.Checksum) {
+		return nil, fmt.Errorf("%w: checksum mismatch for column %s", ErrCorruptedBlock, column)
+	}
+	if err := r.verifyBlock(r.file.metadata.Encryption, &bi); err != nil {
+		return nil, err
+	}
 
-	for _, rres := range results {
-		if rres.err != nil {
-			for _, rr := range results {
-				if rr.arr != nil {
-					rr.arr.Release()
-				}
-			}
-			return nil, rres.err
-		}
+	encryptor, err := r.encryptor(r.file.metadata.Encryption.KeyName(column), bi.KeyEpoch)
+	if err != nil {
+		return nil, err
 	}
 
-	arrays := make([]arrow.Array, len(results))
-	fields := make([]arrow.Field, len(results))
-	for i, rres := range results {
-		arrays[i] = rres.arr
-		fields[i] = rres.field
+	dec, err := encryptor.Decrypt(encryptedData)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt column %s: %w", column, err)
 	}
 
-	newSchema := arrow.NewSchema(fields, nil)
-	record := array.NewRecord(newSchema, arrays, -1)
+	dec, err = decodeBlock(bi, dec)
+	if err != nil {
+		return nil, err
+	}
 
-	for _, col := range arrays {
-		col.Release()
+	reader, err := ipc.NewReader(bytes.NewReader(dec), ipc.WithAllocator(mem))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create reader for column %s: %w", column, err)
 	}
+	defer reader.Release()
 
-	r.file.metadata.LogAccess("system", "read", "record", true, fmt.Sprintf("read %d rows", record.NumRows()))
+	rec, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read record for column %s: %w", column, err)
+	}
+	rec.Retain()
+	return rec, nil
+}
 
-	return record, nil
+// blockColumn returns the column of field f from a decoded block. A block
+// of a single column holds it whatever its name there.
+func blockColumn(mem memory.Allocator, f arrow.Field, rec arrow.Record) (arrow.Array, error) {
+	idx := 0
+	if rec.NumCols() > 1 {
+		indices := rec.Schema().FieldIndices(f.Name)
+		if len(indices) == 0 {
+			return nil, fmt.Errorf("block has no data for column %s", f.Name)
+		}
+		idx = indices[0]
+	}
+	if rec.NumCols() == 0 || rec.Column(idx) == nil {
+		return nil, fmt.Errorf("nil column data for %s", f.Name)
+	}
+
+	col := rec.Column(idx)
+	col.Retain()
+	return plainColumn(mem, f, col)
 }
 
 // writeHeader writes the file header and initial metadata
 func (lbf *LockboxFile) writeHeader() error {
-	// Write file header with placeholder for metadata offset
-	header := lbf.metadata.Header
-	if err := binary.Write(lbf.file, binary.LittleEndian, header); err != nil {
+	// Write file header with a placeholder for the metadata offset (will
+	// be updated later)
+	header := headerBytes(lbf.metadata.Header)
+	header = binary.LittleEndian.AppendUint64(header, 0)
+	if _, err := lbf.file.WriteAt(header, 0); err != nil {
 		return fmt.Errorf("failed to write header: %w", err)
 	}
 
-	// Write placeholder for metadata offset (will be updated later)
-	metadataOffset := uint64(0)
-	if err := binary.Write(lbf.file, binary.LittleEndian, metadataOffset); err != nil {
-		return fmt.Errorf("failed to write metadata offset placeholder: %w", err)
-	}
-
 	return nil
 }
 
 // readHeader reads the file header and metadata
 func (lbf *LockboxFile) readHeader() error {
+	size, err := lbf.file.Size()
+	if err != nil {
+		return err
+	}
+	r := io.NewSectionReader(lbf.file, 0, size)
+
 	// Read file header
 	var header metadata.FileHeader
-	if err := binary.Read(lbf.file, binary.LittleEndian, &header); err != nil {
+	if err := binary.Read(r, binary.LittleEndian, &header); err != nil {
 		return fmt.Errorf("failed to read header: %w", err)
 	}
 
@@ -629,10 +951,13 @@ func (lbf *LockboxFile) readHeader() error {
 	if header.Version != metadata.FileFormatVersion {
 		return fmt.Errorf("unsupported file version: %d", header.Version)
 	}
+	if unknown := header.Flags &^ metadata.KnownFlags; unknown != 0 {
+		return fmt.Errorf("file uses features unknown to this version (flags %#x)", unknown)
+	}
 
 	// Read metadata offset
 	var metadataOffset uint64
-	if err := binary.Read(lbf.file, binary.LittleEndian, &metadataOffset); err != nil {
+	if err := binary.Read(r, binary.LittleEndian, &metadataOffset); err != nil {
 		return fmt.Errorf("failed to read metadata offset: %w", err)
 	}
 
@@ -642,19 +967,31 @@ func (lbf *LockboxFile) readHeader() error {
 	}
 
 	// Seek to metadata position
-	if _, err := lbf.file.Seek(int64(metadataOffset), io.SeekStart); err != nil {
+	if _, err := r.Seek(int64(metadataOffset), io.SeekStart); err != nil {
 		return fmt.Errorf("failed to seek to metadata: %w", err)
 	}
 
-	// Read metadata length
-	var metadataLen uint32
-	if err := binary.Read(lbf.file, binary.LittleEndian, &metadataLen); err != nil {
+	// Read metadata length, in 8 bytes for files whose metadata outgrew 4 GiB
+	var metadataLen uint64
+	if header.Flags&metadata.FlagWideMetadataLength != 0 {
+		err = binary.Read(r, binary.LittleEndian, &metadataLen)
+	} else {
+		var shortLen uint32
+		err = binary.Read(r, binary.LittleEndian, &shortLen)
+		metadataLen = uint64(shortLen)
+	}
+	if err != nil {
 		return fmt.Errorf("failed to read metadata length: %w", err)
 	}
 
+	// Check the length against the file before allocating for it
+	if metadataOffset > uint64(size) || metadataLen > uint64(size)-metadataOffset {
+		return fmt.Errorf("metadata length %d exceeds the file size %d", metadataLen, size)
+	}
+
 	// Read metadata
 	metadataBytes := make([]byte, metadataLen)
-	if _, err := io.ReadFull(lbf.file, metadataBytes); err != nil {
+	if _, err := io.ReadFull(r, metadataBytes); err != nil {
 		return fmt.Errorf("failed to read metadata: %w", err)
 	}
 
@@ -663,86 +1000,174 @@ func (lbf *LockboxFile) readHeader() error {
 	if err != nil {
 		return fmt.Errorf("failed to deserialize metadata: %w", err)
 	}
+	switch kdf := meta.Encryption.ColumnKeyDerivation; kdf {
+	case "", metadata.ColumnKeyHKDF, metadata.ColumnKeySignedHKDF:
+	default:
+		return fmt.Errorf("unsupported column key derivation %s", kdf)
+	}
+	switch kdf := meta.Encryption.KeyDerivation; kdf {
+	case "", metadata.KDFPBKDF2:
+	case metadata.KDFArgon2id:
+		if err := checkArgon2(meta.Encryption.Argon2); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unsupported key derivation %s", kdf)
+	}
 
 	meta.Header = header
 	lbf.metadata = meta
 	return nil
 }
 
+// headerBytes encodes a file header
+func headerBytes(h metadata.FileHeader) []byte {
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.LittleEndian, h)
+	return buf.Bytes()
+}
+
+// metadataLengthSize is the number of bytes holding the metadata length
+func (lbf *LockboxFile) metadataLengthSize() int64 {
+	if lbf.metadata.Header.Flags&metadata.FlagWideMetadataLength != 0 {
+		return 8
+	}
+	return 4
+}
+
 // updateMetadata writes the current metadata to the end of the file
 func (lbf *LockboxFile) updateMetadata() error {
 	if lbf.readonly {
 		return fmt.Errorf("file is read-only")
 	}
 
-	// Seek to end of file to write metadata
-	metadataPos, err := lbf.file.Seek(0, io.SeekEnd)
+	// The metadata goes to the end of the file
+	metadataPos, err := lbf.file.Size()
 	if err != nil {
-		return fmt.Errorf("failed to seek to end of file: %w", err)
+		return err
 	}
 
 	// Serialize and write metadata
-	metadataBytes, err := lbf.metadata.Serialize()
+	metadataBytes, err := lbf.serializeMetadata()
 	if err != nil {
 		return fmt.Errorf("failed to serialize metadata: %w", err)
 	}
 
+	// Switch the file to 8-byte metadata lengths once 4 bytes don't fit,
+	// and mark files with column groups or encrypted metadata
+	header := &lbf.metadata.Header
+	flags := header.Flags
+	if uint64(len(metadataBytes)) > math.MaxUint32 {
+		flags |= metadata.FlagWideMetadataLength
+	}
+	if len(lbf.metadata.Encryption.ColumnGroups) > 0 {
+		flags |= metadata.FlagColumnGroups
+	}
+	if lbf.metadataKey != nil {
+		flags |= metadata.FlagEncryptedMetadata
+	}
+	if flags != header.Flags {
+		header.Flags = flags
+		if _, err := lbf.file.WriteAt(headerBytes(*header), 0); err != nil {
+			return fmt.Errorf("failed to update header flags: %w", err)
+		}
+	}
+
 	// Write metadata length
-	metadataLen := uint32(len(metadataBytes))
-	if err := binary.Write(lbf.file, binary.LittleEndian, metadataLen); err != nil {
+	var length []byte
+	if header.Flags&metadata.FlagWideMetadataLength != 0 {
+		length = binary.LittleEndian.AppendUint64(nil, uint64(len(metadataBytes)))
+	} else {
+		length = binary.LittleEndian.AppendUint32(nil, uint32(len(metadataBytes)))
+	}
+	if _, err := lbf.file.WriteAt(length, metadataPos); err != nil {
 		return fmt.Errorf("failed to write metadata length: %w", err)
 	}
 
 	// Write metadata
-	if _, err := lbf.file.Write(metadataBytes); err != nil {
+	if _, err := lbf.file.WriteAt(metadataBytes, metadataPos+int64(len(length))); err != nil {
 		return fmt.Errorf("failed to write metadata: %w", err)
 	}
 
-	// Update metadata offset in header
-	if _, err := lbf.file.Seek(20, io.SeekStart); err != nil { // After FileHeader
-		return fmt.Errorf("failed to seek to metadata offset position: %w", err)
-	}
-
-	if err := binary.Write(lbf.file, binary.LittleEndian, uint64(metadataPos)); err != nil {
+	// Update metadata offset in header, after FileHeader
+	offset := binary.LittleEndian.AppendUint64(nil, uint64(metadataPos))
+	if _, err := lbf.file.WriteAt(offset, headerSize-8); err != nil {
 		return fmt.Errorf("failed to write metadata offset: %w", err)
 	}
 
-	// Seek back to end for any future writes
-	if _, err := lbf.file.Seek(0, io.SeekEnd); err != nil {
-		return fmt.Errorf("failed to seek to end: %w", err)
-	}
-
 	return nil
 }
 
-// ValidateBlocks verifies the checksum of each data block
+// ValidateBlocks verifies the checksum of each data block, including the
+// blocks holding materialized view results
 func (lbf *LockboxFile) ValidateBlocks() error {
-	for _, block := range lbf.metadata.BlockInfo {
-		data := make([]byte, block.Length)
-		if _, err := lbf.file.ReadAt(data, block.Offset); err != nil {
-			return fmt.Errorf("failed to read block %s: %w", block.ColumnName, err)
+	blocks := append([]metadata.BlockInfo{}, lbf.metadata.BlockInfo...)
+	for _, v := range lbf.metadata.Views {
+		if v.Block != nil {
+			blocks = append(blocks, *v.Block)
 		}
-		sum := sha256.Sum256(data)
-		if !bytes.Equal(sum[:], block.Checksum) {
-			return fmt.Errorf("%w: %s", ErrCorruptedBlock, block.ColumnName)
+	}
+	for _, err := range lbf.checkBlocks(blocks) {
+		if err != nil {
+			return err
 		}
 	}
 	return nil
 }
 
-// Repair attempts to remove corrupted blocks from metadata
+// Repair attempts to remove corrupted blocks from metadata. A row group
+// that loses a block is dropped as a whole, since its rows can no longer be
+// read, and the remaining groups are renumbered. The signatures of a signed
+// file cover the row group numbers, so renumbering one needs a Writer's
+// Repair, which signs the moved blocks again.
 func (lbf *LockboxFile) Repair() error {
+	return lbf.repair(nil)
+}
+
+// Repair removes corrupted blocks like LockboxFile.Repair, signing the
+// blocks of renumbered row groups again
+func (w *Writer) Repair() error {
+	return w.file.repair(w.keyring)
+}
+
+func (lbf *LockboxFile) repair(k *keyring) error {
 	var valid []metadata.BlockInfo
-	for _, block := range lbf.metadata.BlockInfo {
-		data := make([]byte, block.Length)
-		if _, err := lbf.file.ReadAt(data, block.Offset); err != nil {
+	complete := make(map[int]int)
+	errs := lbf.checkBlocks(lbf.metadata.BlockInfo)
+	for i, block := range lbf.metadata.BlockInfo {
+		if errs[i] == nil {
+			valid = append(valid, block)
+			complete[block.RowGroup]++
+		}
+	}
+
+	columns := len(lbf.metadata.Schema.Fields())
+	renumber := make(map[int]int)
+	for n := 0; n < lbf.metadata.NumRowGroups(); n++ {
+		if complete[n] == columns {
+			renumber[n] = len(renumber)
+		}
+	}
+	enc := &lbf.metadata.Encryption
+	kept := valid[:0]
+	for _, block := range valid {
+		n, ok := renumber[block.RowGroup]
+		if !ok {
 			continue
 		}
-		sum := sha256.Sum256(data)
-		if bytes.Equal(sum[:], block.Checksum) {
-			valid = append(valid, block)
+		if n != block.RowGroup {
+			block.RowGroup = n
+			if k != nil {
+				if err := k.signBlock(enc, &block); err != nil {
+					return err
+				}
+			} else if enc.SignatureScheme() != "" {
+				return fmt.Errorf("renumbering the row groups of a signed file requires the password")
+			}
 		}
+		kept = append(kept, block)
 	}
-	lbf.metadata.BlockInfo = valid
+	lbf.metadata.BlockInfo = kept
+	lbf.rebuildRollup()
 	return lbf.updateMetadata()
 }
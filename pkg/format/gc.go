@@ -0,0 +1,210 @@
+package format
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/TFMV/lockbox/pkg/metadata"
+	"github.com/rs/zerolog/log"
+)
+
+// headerSize is the size of the file header including the metadata offset
+var headerSize = int64(binary.Size(metadata.FileHeader{})) + 8
+
+// liveBlocks returns the blocks the metadata refers to: the table data and
+// the results of materialized views
+func (lbf *LockboxFile) liveBlocks() []*metadata.BlockInfo {
+	blocks := make([]*metadata.BlockInfo, 0, len(lbf.metadata.BlockInfo))
+	for i := range lbf.metadata.BlockInfo {
+		blocks = append(blocks, &lbf.metadata.BlockInfo[i])
+	}
+	for i := range lbf.metadata.Views {
+		if b := lbf.metadata.Views[i].Block; b != nil {
+			blocks = append(blocks, b)
+		}
+	}
+	return blocks
+}
+
+// ReclaimableBytes returns the size of the file regions that neither a
+// block nor the current metadata refers to, such as blocks replaced by key
+// compaction or view refreshes and earlier copies of the metadata
+func (lbf *LockboxFile) ReclaimableBytes() (int64, error) {
+	size, err := lbf.file.Size()
+	if err != nil {
+		return 0, err
+	}
+	meta, err := lbf.metadata.Serialize()
+	if err != nil {
+		return 0, fmt.Errorf("failed to serialize metadata: %w", err)
+	}
+	live := headerSize + lbf.metadataLengthSize() + int64(len(meta))
+	seen := make(map[int64]bool)
+	for _, b := range lbf.liveBlocks() {
+		if !seen[b.Offset] {
+			seen[b.Offset] = true
+			live += b.Length
+		}
+	}
+	if live > size {
+		return 0, nil
+	}
+	return size - live, nil
+}
+
+// Reclaim rewrites the file with only the live blocks and the current
+// metadata and returns the number of bytes freed
+func (lbf *LockboxFile) Reclaim() (int64, error) {
+	before, err := lbf.file.Size()
+	if err != nil {
+		return 0, err
+	}
+	if err := lbf.rewrite(); err != nil {
+		return 0, err
+	}
+	after, err := lbf.file.Size()
+	if err != nil {
+		return 0, err
+	}
+	return before - after, nil
+}
+
+// rewrite copies the live blocks and the current metadata to a temporary
+// file in the same directory, syncs it and renames it over the original
+// with the same mode and owner. Readers that have the file open keep
+// seeing the old version, and a failure leaves the old file in place.
+// Storages other than files are compacted in place.
+func (lbf *LockboxFile) rewrite() error {
+	if lbf.readonly {
+		return fmt.Errorf("file is read-only")
+	}
+	f, ok := lbf.file.(fileStorage)
+	if !ok {
+		return lbf.compact()
+	}
+	path := lbf.Path()
+	fi, err := f.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to stat file: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	// Copy the live blocks in file order, remembering the old offsets in
+	// case the rewrite fails
+	blocks := lbf.liveBlocks()
+	sort.Slice(blocks, func(i, j int) bool { return blocks[i].Offset < blocks[j].Offset })
+	oldOffsets := make([]int64, len(blocks))
+	restore := func() {
+		for i, b := range blocks {
+			b.Offset = oldOffsets[i]
+		}
+	}
+
+	if err := binary.Write(tmp, binary.LittleEndian, lbf.metadata.Header); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write header: %w", err)
+	}
+	if err := binary.Write(tmp, binary.LittleEndian, uint64(0)); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write header: %w", err)
+	}
+	offset := headerSize
+	for i, b := range blocks {
+		oldOffsets[i] = b.Offset
+		if i > 0 && oldOffsets[i-1] == b.Offset {
+			// The columns of a group share one block
+			b.Offset = blocks[i-1].Offset
+			continue
+		}
+		if _, err := io.Copy(tmp, io.NewSectionReader(lbf.file, b.Offset, b.Length)); err != nil {
+			tmp.Close()
+			restore()
+			return fmt.Errorf("failed to copy block of %s: %w", b.ColumnName, err)
+		}
+		b.Offset = offset
+		offset += b.Length
+	}
+
+	old := lbf.file
+	lbf.file = fileStorage{tmp}
+	fail := func(err error) error {
+		tmp.Close()
+		lbf.file = old
+		restore()
+		return err
+	}
+	if err := lbf.updateMetadata(); err != nil {
+		return fail(err)
+	}
+	if err := tmp.Chmod(fi.Mode().Perm()); err != nil {
+		return fail(fmt.Errorf("failed to set file mode: %w", err))
+	}
+	if err := chownLike(tmp, fi); err != nil {
+		return fail(fmt.Errorf("failed to set file owner: %w", err))
+	}
+	if err := tmp.Sync(); err != nil {
+		return fail(fmt.Errorf("failed to sync file: %w", err))
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fail(fmt.Errorf("failed to replace file: %w", err))
+	}
+	old.Close()
+	if err := syncDir(filepath.Dir(path)); err != nil {
+		log.Warn().Err(err).Str("file", path).Msg("Failed to sync directory after replacing file")
+	}
+
+	// Keep the handle under the original name
+	tmp.Close()
+	file, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return fmt.Errorf("failed to reopen file: %w", err)
+	}
+	lbf.file = fileStorage{file}
+	return nil
+}
+
+// compact moves the live blocks towards the start of the storage, writes
+// the current metadata after them and truncates the rest. Unlike rewrite
+// it is not atomic: a failure part way leaves the storage unreadable.
+func (lbf *LockboxFile) compact() error {
+	t, ok := lbf.file.(Truncater)
+	if !ok {
+		return fmt.Errorf("storage of %s cannot be truncated", lbf.Path())
+	}
+
+	// Blocks only move down, so copying them in file order never
+	// overwrites a block not yet copied
+	blocks := lbf.liveBlocks()
+	sort.Slice(blocks, func(i, j int) bool { return blocks[i].Offset < blocks[j].Offset })
+	offset := headerSize
+	prev := int64(-1)
+	for _, b := range blocks {
+		if b.Offset == prev {
+			// The columns of a group share one block
+			b.Offset = offset - b.Length
+			continue
+		}
+		prev = b.Offset
+		if b.Offset != offset {
+			dst := io.NewOffsetWriter(lbf.file, offset)
+			if _, err := io.Copy(dst, io.NewSectionReader(lbf.file, b.Offset, b.Length)); err != nil {
+				return fmt.Errorf("failed to move block of %s: %w", b.ColumnName, err)
+			}
+		}
+		b.Offset = offset
+		offset += b.Length
+	}
+	if err := t.Truncate(offset); err != nil {
+		return fmt.Errorf("failed to truncate: %w", err)
+	}
+	return lbf.updateMetadata()
+}
@@ -0,0 +1,48 @@
+package format
+
+import (
+	"fmt"
+
+	"github.com/TFMV/lockbox/pkg/crypto"
+	"github.com/TFMV/lockbox/pkg/metadata"
+)
+
+// deriveKey derives a key from a password with the file's key derivation:
+// Argon2id with the recorded costs, or else the crypto module's own, which
+// is PBKDF2 for the default module
+func (lbf *LockboxFile) deriveKey(password string, salt []byte) *crypto.Key {
+	enc := lbf.metadata.Encryption
+	if enc.KeyDerivation == metadata.KDFArgon2id {
+		p := enc.Argon2
+		return crypto.DeriveKeyArgon2id(password, salt, p.Time, p.Memory, p.Threads)
+	}
+	return lbf.module.DeriveKey(password, salt)
+}
+
+// SetArgon2id switches a new file to Argon2id key derivation with params.
+// The master key is derived from the password, so this is only possible
+// before any data is written or any key is wrapped. The metadata still has
+// to be saved.
+func (lbf *LockboxFile) SetArgon2id(params metadata.Argon2Params) error {
+	if err := checkArgon2(&params); err != nil {
+		return err
+	}
+	enc := &lbf.metadata.Encryption
+	if len(lbf.metadata.BlockInfo) > 0 || len(enc.WrappedMasterKey) > 0 || len(enc.RecoveryCodes) > 0 || len(enc.AccessKeys) > 0 {
+		return fmt.Errorf("the key derivation can only be changed on a new file")
+	}
+	enc.KeyDerivation = metadata.KDFArgon2id
+	enc.Argon2 = &params
+	return nil
+}
+
+// checkArgon2 validates the costs of Argon2id key derivation
+func checkArgon2(p *metadata.Argon2Params) error {
+	if p == nil {
+		return fmt.Errorf("missing Argon2id parameters")
+	}
+	if p.Time == 0 || p.Threads == 0 || p.Memory < 8*uint32(p.Threads) {
+		return fmt.Errorf("invalid Argon2id parameters: time %d, memory %d KiB, threads %d", p.Time, p.Memory, p.Threads)
+	}
+	return nil
+}
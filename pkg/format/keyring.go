@@ -0,0 +1,182 @@
+package format
+
+import (
+	"crypto/ed25519"
+	"fmt"
+	"sync"
+
+	"github.com/TFMV/lockbox/pkg/crypto"
+	"github.com/TFMV/lockbox/pkg/metadata"
+)
+
+// keyring derives and caches column encryptors for each key epoch
+type keyring struct {
+	module    crypto.Module
+	masterKey *crypto.Key // nil for readers created from column keys
+	salt      []byte
+	kdf       string // EncryptionParams.ColumnKeyDerivation
+	// signer signs blocks; nil without masterKey
+	signer ed25519.PrivateKey
+	// file keeps the wrapped ML-KEM keys; writable lets the keyring add
+	// them for key epochs that have none yet
+	file     *LockboxFile
+	writable bool
+
+	mu         sync.Mutex
+	encryptors map[string]*crypto.ColumnEncryptor // keyed by epochKey
+}
+
+func newKeyring(lbf *LockboxFile, module crypto.Module, masterKey *crypto.Key) *keyring {
+	params := lbf.metadata.Encryption
+	k := &keyring{
+		module:     module,
+		masterKey:  masterKey,
+		salt:       params.MasterSalt,
+		kdf:        params.ColumnKeyDerivation,
+		file:       lbf,
+		encryptors: make(map[string]*crypto.ColumnEncryptor),
+	}
+	if masterKey != nil {
+		k.signer = crypto.BlockSigningKey(masterKey.Data)
+	}
+	return k
+}
+
+func epochKey(column string, epoch int) string {
+	return fmt.Sprintf("%s#%d", column, epoch)
+}
+
+// columnKeyMaterial derives the key of a column at a key epoch with the
+// file's column key derivation kdf. Epoch 0 is the original scheme, which
+// uses the master key's own post-quantum secret for every column, so its
+// material never leaves the file; later epochs derive that secret from the
+// column key so the key material of one column reveals nothing about the
+// others.
+func columnKeyMaterial(masterKey *crypto.Key, column string, epoch int, salt []byte, kdf string) *crypto.Key {
+	derive := crypto.DeriveColumnKey
+	switch kdf {
+	case metadata.ColumnKeyHKDF:
+		derive = crypto.ExpandColumnKey
+	case metadata.ColumnKeySignedHKDF:
+		derive = crypto.ExpandSignedColumnKey
+	}
+	if epoch == 0 {
+		return &crypto.Key{
+			Data:           derive(masterKey.Data, column, salt),
+			KyberPublicKey: masterKey.KyberPublicKey,
+			KyberSecretKey: masterKey.KyberSecretKey,
+		}
+	}
+	return crypto.KeyFromMaster(derive(masterKey.Data, epochKey(column, epoch), salt), nil)
+}
+
+// encryptorFromKey creates an encryptor for column key material
+func encryptorFromKey(module crypto.Module, key *crypto.Key) (*crypto.ColumnEncryptor, error) {
+	encryptorIntf, err := module.NewEncryptor(key.Data)
+	if err != nil {
+		return nil, err
+	}
+	encryptor, ok := encryptorIntf.(*crypto.ColumnEncryptor)
+	if !ok {
+		return nil, fmt.Errorf("crypto module %s does not produce column encryptors", module.Name())
+	}
+
+	// Initialize post-quantum components
+	if key.KyberPublicKey != nil && key.KyberSecretKey != nil {
+		encryptor.KyberPublicKey = key.KyberPublicKey
+		encryptor.KyberSecretKey = key.KyberSecretKey
+	}
+	if key.KEMSeed != nil {
+		if err := encryptor.SetKEMKey(key.KEMSeed); err != nil {
+			return nil, err
+		}
+	}
+	return encryptor, nil
+}
+
+// wrappedKEMKeys reports whether the file keeps an independent ML-KEM key
+// for every column key epoch
+func (lbf *LockboxFile) wrappedKEMKeys() bool {
+	return lbf.metadata.Encryption.ModuleParams[crypto.MLKEMKeysParam] == crypto.MLKEMKeysWrapped
+}
+
+// kemSeed returns the ML-KEM key of a column key epoch, unwrapped with the
+// master key. With create a key epoch without one gets a new key, which is
+// saved with the metadata.
+func (lbf *LockboxFile) kemSeed(masterKey *crypto.Key, column string, epoch int, create bool) ([]byte, error) {
+	enc := &lbf.metadata.Encryption
+	kek := crypto.KEMWrapKey(masterKey.Data)
+	id := epochKey(column, epoch)
+	if wrapped, ok := enc.KEMKeys[id]; ok {
+		seed, err := crypto.UnwrapKey(kek, wrapped)
+		if err != nil {
+			return nil, fmt.Errorf("failed to unwrap ML-KEM key of column %s: %w", column, err)
+		}
+		return seed, nil
+	}
+	if !create {
+		return nil, fmt.Errorf("no ML-KEM key for column %s (epoch %d)", column, epoch)
+	}
+
+	seed, err := crypto.NewKEMSeed()
+	if err != nil {
+		return nil, err
+	}
+	wrapped, err := crypto.WrapKey(kek, seed)
+	if err != nil {
+		return nil, fmt.Errorf("failed to wrap ML-KEM key of column %s: %w", column, err)
+	}
+	if enc.KEMKeys == nil {
+		enc.KEMKeys = make(map[string][]byte)
+	}
+	enc.KEMKeys[id] = wrapped
+	return seed, nil
+}
+
+// encryptor returns the encryptor for a column at a key epoch, deriving it
+// on first use
+func (k *keyring) encryptor(column string, epoch int) (*crypto.ColumnEncryptor, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	id := epochKey(column, epoch)
+	if enc, ok := k.encryptors[id]; ok {
+		return enc, nil
+	}
+	if k.masterKey == nil {
+		return nil, fmt.Errorf("%w %s (epoch %d)", ErrNoColumnKey, column, epoch)
+	}
+
+	key := columnKeyMaterial(k.masterKey, column, epoch, k.salt, k.kdf)
+	if k.file.wrappedKEMKeys() {
+		seed, err := k.file.kemSeed(k.masterKey, column, epoch, k.writable)
+		if err != nil {
+			return nil, err
+		}
+		key.KEMSeed = seed
+	}
+	enc, err := encryptorFromKey(k.module, key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create encryptor for column %s: %w", column, err)
+	}
+	k.encryptors[id] = enc
+	return enc, nil
+}
+
+// has reports whether the keyring can decrypt a column at a key epoch
+func (k *keyring) has(column string, epoch int) bool {
+	if k.masterKey != nil {
+		return true
+	}
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	_, ok := k.encryptors[epochKey(column, epoch)]
+	return ok
+}
+
+// add registers an encryptor built from exported key material
+func (k *keyring) add(column string, epoch int, enc *crypto.ColumnEncryptor) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.encryptors[epochKey(column, epoch)] = enc
+}
@@ -0,0 +1,152 @@
+package format
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+
+	"github.com/TFMV/lockbox/pkg/crypto"
+)
+
+// ErrSharedColumnKey is returned when the key material of a column at key
+// epoch 0 would leave the file. Its post-quantum secret is the master key
+// reduced to a scalar, shared by every column, so it would reveal the
+// master key; rotating the column gives it a key of its own.
+var ErrSharedColumnKey = errors.New("column key epoch 0 shares the file's secret; rotate the column key first")
+
+// ColumnKey is the key material needed to decrypt one column without the
+// password. Blocks are encrypted with the column key combined with a
+// post-quantum secret, so both are included. Only rotated columns, whose
+// secret is derived from their own key, can be exported.
+type ColumnKey struct {
+	File         string `json:"file"`
+	Column       string `json:"column"`
+	Epoch        int    `json:"epoch,omitempty"`
+	Key          []byte `json:"key"`
+	HybridSecret []byte `json:"hybridSecret,omitempty"`
+	// KEMSeed is the independent ML-KEM key of the epoch, for files that
+	// keep one
+	KEMSeed []byte `json:"kemSeed,omitempty"`
+}
+
+// FileID identifies a lockbox file for matching exported key material. It
+// is derived from the master salt, so it does not change when the file is
+// rewritten.
+func (lbf *LockboxFile) FileID() string {
+	sum := sha256.Sum256(lbf.metadata.Encryption.MasterSalt)
+	return hex.EncodeToString(sum[:8])
+}
+
+// ExportColumnKey returns the key material for the current key epoch of a
+// column. For a grouped column it is the key of the group, which decrypts
+// the group's other columns too. Columns at key epoch 0 are refused with
+// ErrSharedColumnKey.
+func (r *Reader) ExportColumnKey(column string) (*ColumnKey, error) {
+	if r.masterKey == nil {
+		return nil, fmt.Errorf("column keys can only be exported with the password")
+	}
+	if len(r.file.metadata.Schema.FieldIndices(column)) == 0 {
+		return nil, fmt.Errorf("column %s not found", column)
+	}
+
+	enc := r.file.metadata.Encryption
+	return r.file.columnKey(r.masterKey, enc.KeyName(column), enc.ColumnEpoch(column))
+}
+
+// FieldColumnKey returns the key of the current key epoch of a column
+// without its post-quantum secret, for deriving field keys. It cannot
+// decrypt blocks, so columns at key epoch 0 are not refused.
+func (r *Reader) FieldColumnKey(column string) (*ColumnKey, error) {
+	if r.masterKey == nil {
+		return nil, fmt.Errorf("column keys can only be exported with the password")
+	}
+	if len(r.file.metadata.Schema.FieldIndices(column)) == 0 {
+		return nil, fmt.Errorf("column %s not found", column)
+	}
+
+	enc := r.file.metadata.Encryption
+	name, epoch := enc.KeyName(column), enc.ColumnEpoch(column)
+	key := columnKeyMaterial(r.masterKey, name, epoch, enc.MasterSalt, enc.ColumnKeyDerivation)
+	return &ColumnKey{File: r.file.FileID(), Column: name, Epoch: epoch, Key: key.Data}, nil
+}
+
+// SharesFileSecret reports whether a column's key is still at key epoch 0,
+// or some of its blocks are, so its key material cannot be exported
+func (lbf *LockboxFile) SharesFileSecret(column string) bool {
+	enc := lbf.metadata.Encryption
+	name := enc.KeyName(column)
+	if enc.ColumnEpoch(column) == 0 {
+		return true
+	}
+	for _, bi := range lbf.metadata.BlockInfo {
+		if bi.KeyEpoch == 0 && enc.KeyName(bi.ColumnName) == name {
+			return true
+		}
+	}
+	return false
+}
+
+// columnKey returns the key material of a column at a key epoch. Every
+// export and access grant goes through it, so epoch 0 is refused here.
+func (lbf *LockboxFile) columnKey(masterKey *crypto.Key, column string, epoch int) (*ColumnKey, error) {
+	if epoch == 0 {
+		return nil, fmt.Errorf("%w: %s", ErrSharedColumnKey, column)
+	}
+	enc := lbf.metadata.Encryption
+	key := columnKeyMaterial(masterKey, column, epoch, enc.MasterSalt, enc.ColumnKeyDerivation)
+	ck := &ColumnKey{
+		File:   lbf.FileID(),
+		Column: column,
+		Epoch:  epoch,
+		Key:    key.Data,
+	}
+	if key.KyberSecretKey != nil {
+		secret, err := key.KyberSecretKey.MarshalBinary()
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal hybrid secret: %w", err)
+		}
+		ck.HybridSecret = secret
+	}
+	if lbf.wrappedKEMKeys() {
+		seed, err := lbf.kemSeed(masterKey, column, epoch, false)
+		if err != nil {
+			return nil, err
+		}
+		ck.KEMSeed = seed
+	}
+	return ck, nil
+}
+
+// NewReaderFromKeys creates a reader that can decrypt only the column key
+// epochs whose keys are given
+func (lbf *LockboxFile) NewReaderFromKeys(keys ...*ColumnKey) (*Reader, error) {
+	if lbf.metadataOnly {
+		return nil, ErrMetadataOnly
+	}
+	ring := newKeyring(lbf, lbf.module, nil)
+	for _, ck := range keys {
+		if ck.File != lbf.FileID() {
+			return nil, fmt.Errorf("key for column %s belongs to a different file", ck.Column)
+		}
+		if lbf.wrappedKEMKeys() && len(ck.KEMSeed) == 0 {
+			return nil, fmt.Errorf("key for column %s lacks its ML-KEM key", ck.Column)
+		}
+		key := &crypto.Key{Data: ck.Key, KEMSeed: ck.KEMSeed}
+		if len(ck.HybridSecret) > 0 {
+			secret := crypto.Suite.Scalar()
+			if err := secret.UnmarshalBinary(ck.HybridSecret); err != nil {
+				return nil, fmt.Errorf("invalid hybrid secret for column %s: %w", ck.Column, err)
+			}
+			key.KyberSecretKey = secret
+			key.KyberPublicKey = crypto.Suite.Point().Mul(secret, nil)
+		}
+		encryptor, err := encryptorFromKey(lbf.module, key)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create encryptor for column %s: %w", ck.Column, err)
+		}
+		ring.add(ck.Column, ck.Epoch, encryptor)
+	}
+
+	return &Reader{keyring: ring, file: lbf}, nil
+}
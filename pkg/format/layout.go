@@ -0,0 +1,189 @@
+package format
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/TFMV/lockbox/pkg/metadata"
+)
+
+// Kinds of the regions of a file layout
+const (
+	RegionHeader      = "header"
+	RegionBlock       = "block"
+	RegionView        = "view"
+	RegionMetadata    = "metadata"
+	RegionOldMetadata = "old-metadata"
+	RegionDead        = "dead"
+)
+
+// Region is a byte range of a lockbox file
+type Region struct {
+	Kind   string `json:"kind"`
+	Offset int64  `json:"offset"`
+	Length int64  `json:"length"`
+	// Columns are the columns stored in a block, more than one for the
+	// block of a column group
+	Columns  []string `json:"columns,omitempty"`
+	RowGroup int      `json:"rowGroup,omitempty"`
+	// Commit is the snapshot that added a block, 0 when its snapshot has
+	// expired
+	Commit int64 `json:"commit,omitempty"`
+	// View is the materialized view whose results a block holds
+	View string `json:"view,omitempty"`
+}
+
+// metadataMarker starts every serialized copy of the metadata, which is
+// indented JSON
+var metadataMarker = []byte("{\n  \"header\": {")
+
+// Layout maps the file from start to end: the header, the blocks the
+// metadata refers to, the current metadata and the regions nothing refers
+// to any more. Earlier copies of the metadata are told apart from replaced
+// blocks in those regions by their length prefix and opening bytes.
+func (lbf *LockboxFile) Layout() ([]Region, error) {
+	if lbf.MetadataSealed() {
+		return nil, ErrMetadataSealed
+	}
+	size, err := lbf.file.Size()
+	if err != nil {
+		return nil, err
+	}
+	meta := lbf.metadata
+
+	regions := []Region{{Kind: RegionHeader, Offset: 0, Length: headerSize}}
+
+	var offset [8]byte
+	if _, err := lbf.file.ReadAt(offset[:], headerSize-8); err != nil {
+		return nil, fmt.Errorf("failed to read metadata offset: %w", err)
+	}
+	metaPos := int64(binary.LittleEndian.Uint64(offset[:]))
+	metaLen, err := lbf.metadataLengthAt(metaPos, size)
+	if err != nil {
+		return nil, err
+	}
+	regions = append(regions, Region{Kind: RegionMetadata, Offset: metaPos, Length: lbf.metadataLengthSize() + metaLen})
+
+	// The blocks of a column group share one region
+	byOffset := make(map[int64]int)
+	for i, bi := range meta.BlockInfo {
+		if j, ok := byOffset[bi.Offset]; ok {
+			regions[j].Columns = append(regions[j].Columns, bi.ColumnName)
+			continue
+		}
+		byOffset[bi.Offset] = len(regions)
+		regions = append(regions, Region{
+			Kind:     RegionBlock,
+			Offset:   bi.Offset,
+			Length:   bi.Length,
+			Columns:  []string{bi.ColumnName},
+			RowGroup: bi.RowGroup,
+			Commit:   blockCommit(meta.Snapshots, i),
+		})
+	}
+	for _, v := range meta.Views {
+		if v.Block != nil {
+			regions = append(regions, Region{Kind: RegionView, Offset: v.Block.Offset, Length: v.Block.Length, View: v.Name})
+		}
+	}
+	sort.SliceStable(regions, func(i, j int) bool { return regions[i].Offset < regions[j].Offset })
+
+	// Fill the gaps between the regions referred to
+	var out []Region
+	pos := int64(0)
+	for _, r := range regions {
+		if r.Offset > pos {
+			gaps, err := lbf.gapRegions(pos, r.Offset)
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, gaps...)
+		}
+		out = append(out, r)
+		pos = max(pos, r.Offset+r.Length)
+	}
+	if size > pos {
+		gaps, err := lbf.gapRegions(pos, size)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, gaps...)
+	}
+	return out, nil
+}
+
+// blockCommit returns the snapshot that added block i: the data of a
+// snapshot is the first BlockCount blocks
+func blockCommit(snaps []metadata.Snapshot, i int) int64 {
+	for _, s := range snaps {
+		if s.BlockCount > i {
+			return s.ID
+		}
+	}
+	return 0
+}
+
+// metadataLengthAt reads the length of the metadata stored at pos
+func (lbf *LockboxFile) metadataLengthAt(pos, size int64) (int64, error) {
+	n := lbf.metadataLengthSize()
+	buf := make([]byte, n)
+	if _, err := lbf.file.ReadAt(buf, pos); err != nil {
+		return 0, fmt.Errorf("failed to read metadata length: %w", err)
+	}
+	var length int64
+	if n == 8 {
+		length = int64(binary.LittleEndian.Uint64(buf))
+	} else {
+		length = int64(binary.LittleEndian.Uint32(buf))
+	}
+	if length < 0 || length > size-pos-n {
+		return 0, fmt.Errorf("metadata length %d exceeds the file size %d", length, size)
+	}
+	return length, nil
+}
+
+// gapRegions splits the unreferenced bytes from start to end into earlier
+// copies of the metadata and dead space
+func (lbf *LockboxFile) gapRegions(start, end int64) ([]Region, error) {
+	const chunk = 1 << 20
+	n := lbf.metadataLengthSize()
+	var out []Region
+	dead := start
+	pos := start
+	buf := make([]byte, chunk+len(metadataMarker))
+	for pos < end {
+		m, err := lbf.file.ReadAt(buf[:min(int64(len(buf)), end-pos)], pos)
+		if err != nil && err != io.EOF {
+			return nil, fmt.Errorf("failed to read file: %w", err)
+		}
+		i := bytes.Index(buf[:m], metadataMarker)
+		if i < 0 {
+			if int64(m) < int64(len(buf)) {
+				break
+			}
+			pos += chunk
+			continue
+		}
+		at := pos + int64(i)
+		if at-n >= dead {
+			length, err := lbf.metadataLengthAt(at-n, end)
+			if err == nil && at+length <= end {
+				if at-n > dead {
+					out = append(out, Region{Kind: RegionDead, Offset: dead, Length: at - n - dead})
+				}
+				out = append(out, Region{Kind: RegionOldMetadata, Offset: at - n, Length: n + length})
+				dead = at + length
+				pos = dead
+				continue
+			}
+		}
+		pos = at + 1
+	}
+	if end > dead {
+		out = append(out, Region{Kind: RegionDead, Offset: dead, Length: end - dead})
+	}
+	return out, nil
+}
@@ -0,0 +1,269 @@
+package format
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/TFMV/lockbox/pkg/crypto"
+	"github.com/TFMV/lockbox/pkg/metadata"
+)
+
+// ErrInvalidPassword is returned when a password or recovery code does not
+// unlock the file
+var ErrInvalidPassword = errors.New("invalid password")
+
+// recoveryCodeBytes is the amount of randomness in a recovery code
+const recoveryCodeBytes = 10
+
+// unlockedKeys holds the *rememberedKey added with RememberMasterKey, by
+// KeyID
+var unlockedKeys sync.Map
+
+// rememberedKey is a master key added with RememberMasterKey
+type rememberedKey struct {
+	key     *crypto.Key
+	expires time.Time // zero keeps the key until ForgetMasterKey
+}
+
+// rememberedPrefix starts the passwords RememberedPassword returns
+const rememberedPrefix = "lockbox-remembered-key:"
+
+// KeyID identifies the master key that password unlocks in this file. It
+// covers the salts and the wrapped key, so it changes with the password.
+func (lbf *LockboxFile) KeyID(password string) string {
+	enc := lbf.metadata.Encryption
+	h := sha256.New()
+	for _, b := range [][]byte{enc.MasterSalt, enc.KeySalt, enc.WrappedMasterKey, []byte(password)} {
+		binary.Write(h, binary.LittleEndian, uint32(len(b)))
+		h.Write(b)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// RememberMasterKey makes MasterKey return key for the file and password
+// with the given KeyID, or for RememberedPassword(id), without running the
+// key derivation, e.g. for keys cached by the lockbox agent. Keys are held
+// until expires, so they don't outlive the agent's entry, or until
+// ForgetMasterKey; a zero time never expires. Expired keys are dropped when
+// they are looked up or another key is remembered.
+func RememberMasterKey(id string, key *crypto.Key, expires time.Time) {
+	now := time.Now()
+	unlockedKeys.Range(func(k, v any) bool {
+		if v.(*rememberedKey).expired(now) {
+			unlockedKeys.Delete(k)
+		}
+		return true
+	})
+	unlockedKeys.Store(id, &rememberedKey{key: key, expires: expires})
+}
+
+// ForgetMasterKey drops a key added with RememberMasterKey
+func ForgetMasterKey(id string) {
+	unlockedKeys.Delete(id)
+}
+
+// RememberedPassword returns a stand-in for the password of the key
+// remembered under id, for callers that never see the password. It unlocks
+// only the file the key belongs to, only while the key is remembered, and
+// can't protect new files or keys.
+func RememberedPassword(id string) string {
+	return rememberedPrefix + id
+}
+
+func (r *rememberedKey) expired(now time.Time) bool {
+	return !r.expires.IsZero() && !now.Before(r.expires)
+}
+
+// lookupKey returns the key remembered under id, dropping it once expired
+func lookupKey(id string) (*crypto.Key, bool) {
+	v, ok := unlockedKeys.Load(id)
+	if !ok {
+		return nil, false
+	}
+	r := v.(*rememberedKey)
+	if r.expired(time.Now()) {
+		unlockedKeys.CompareAndDelete(id, v)
+		return nil, false
+	}
+	return r.key, true
+}
+
+// checkNewPassword refuses to protect a file or key with a stand-in from
+// RememberedPassword, which stops working when the key is forgotten
+func checkNewPassword(password string) error {
+	if strings.HasPrefix(password, rememberedPrefix) {
+		return fmt.Errorf("a remembered key can't protect a file or key: give the password")
+	}
+	return nil
+}
+
+// MasterKey returns the master key for password. For files whose master key
+// is wrapped the password is verified; older files derive the key from the
+// password and cannot detect a wrong one here.
+func (lbf *LockboxFile) MasterKey(password string) (*crypto.Key, error) {
+	enc := lbf.metadata.Encryption
+	if id, ok := strings.CutPrefix(password, rememberedPrefix); ok {
+		key, ok := lookupKey(id)
+		if !ok || !bytes.Equal(key.Salt, enc.MasterSalt) {
+			return nil, fmt.Errorf("%w: the remembered key expired or belongs to another file", ErrInvalidPassword)
+		}
+		return key, nil
+	}
+	if key, ok := lookupKey(lbf.KeyID(password)); ok {
+		return key, nil
+	}
+	if len(enc.WrappedMasterKey) == 0 {
+		masterKey := lbf.deriveKey(password, enc.MasterSalt)
+		if masterKey == nil {
+			return nil, fmt.Errorf("failed to derive master key")
+		}
+		return masterKey, nil
+	}
+
+	kek := lbf.deriveKey(password, enc.KeySalt)
+	if kek == nil {
+		return nil, fmt.Errorf("failed to derive key-encryption key")
+	}
+	data, err := crypto.UnwrapKey(kek.Data, enc.WrappedMasterKey)
+	if err != nil {
+		return nil, ErrInvalidPassword
+	}
+	return crypto.KeyFromMaster(data, enc.MasterSalt), nil
+}
+
+// SetPassword wraps the master key with a key derived from newPassword so
+// that only the new password unlocks the file. The metadata still has to
+// be saved.
+func (lbf *LockboxFile) SetPassword(masterKey *crypto.Key, newPassword string) error {
+	if err := checkNewPassword(newPassword); err != nil {
+		return err
+	}
+	salt := make([]byte, crypto.SaltSize)
+	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+		return fmt.Errorf("failed to generate salt: %w", err)
+	}
+	kek := lbf.deriveKey(newPassword, salt)
+	wrapped, err := crypto.WrapKey(kek.Data, masterKey.Data)
+	if err != nil {
+		return fmt.Errorf("failed to wrap master key: %w", err)
+	}
+
+	lbf.metadata.Encryption.KeySalt = salt
+	lbf.metadata.Encryption.WrappedMasterKey = wrapped
+	return nil
+}
+
+// GenerateRecoveryCodes replaces the file's recovery codes with n new
+// one-time codes, each able to unwrap the master key. Only hashes and
+// wrapped keys are stored; the codes are returned once. The metadata still
+// has to be saved.
+func (lbf *LockboxFile) GenerateRecoveryCodes(masterKey *crypto.Key, n int) ([]string, error) {
+	codes := make([]string, 0, n)
+	entries := make([]metadata.RecoveryCode, 0, n)
+	for i := 0; i < n; i++ {
+		raw := make([]byte, recoveryCodeBytes)
+		salt := make([]byte, crypto.SaltSize)
+		if _, err := io.ReadFull(rand.Reader, raw); err != nil {
+			return nil, fmt.Errorf("failed to generate recovery code: %w", err)
+		}
+		if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+			return nil, fmt.Errorf("failed to generate salt: %w", err)
+		}
+		code := formatRecoveryCode(raw)
+
+		kek := lbf.deriveKey(normalizeRecoveryCode(code), salt)
+		wrapped, err := crypto.WrapKey(kek.Data, masterKey.Data)
+		if err != nil {
+			return nil, fmt.Errorf("failed to wrap master key: %w", err)
+		}
+
+		codes = append(codes, code)
+		entries = append(entries, metadata.RecoveryCode{
+			Hash:       hashRecoveryCode(code),
+			Salt:       salt,
+			WrappedKey: wrapped,
+		})
+	}
+
+	lbf.metadata.Encryption.RecoveryCodes = entries
+	return codes, nil
+}
+
+// UseRecoveryCode unwraps the master key with a recovery code and marks the
+// code as used. The metadata still has to be saved.
+func (lbf *LockboxFile) UseRecoveryCode(code string) (*crypto.Key, error) {
+	hash := hashRecoveryCode(code)
+	enc := &lbf.metadata.Encryption
+	for i := range enc.RecoveryCodes {
+		rc := &enc.RecoveryCodes[i]
+		if !bytes.Equal(rc.Hash, hash) {
+			continue
+		}
+		if rc.UsedAt != nil {
+			return nil, fmt.Errorf("recovery code was already used at %s", rc.UsedAt.Format(time.RFC3339))
+		}
+
+		kek := lbf.deriveKey(normalizeRecoveryCode(code), rc.Salt)
+		data, err := crypto.UnwrapKey(kek.Data, rc.WrappedKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to unwrap master key: %w", err)
+		}
+		masterKey := crypto.KeyFromMaster(data, enc.MasterSalt)
+		if lbf.MetadataSealed() {
+			if err := lbf.unseal(metadataKey(masterKey, enc.MasterSalt)); err != nil {
+				return nil, err
+			}
+			// The unsealed metadata lists the codes in the same order
+			rc = &lbf.metadata.Encryption.RecoveryCodes[i]
+		}
+		now := time.Now()
+		rc.UsedAt = &now
+		return masterKey, nil
+	}
+	return nil, fmt.Errorf("%w: unknown recovery code", ErrInvalidPassword)
+}
+
+// RemainingRecoveryCodes returns the number of unused recovery codes
+func (lbf *LockboxFile) RemainingRecoveryCodes() int {
+	n := 0
+	for _, rc := range lbf.metadata.Encryption.RecoveryCodes {
+		if rc.UsedAt == nil {
+			n++
+		}
+	}
+	return n
+}
+
+// formatRecoveryCode renders random bytes as groups of four base32
+// characters, e.g. ABCD-EFGH-IJKL-MNOP
+func formatRecoveryCode(raw []byte) string {
+	s := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(raw)
+	var groups []string
+	for len(s) > 4 {
+		groups = append(groups, s[:4])
+		s = s[4:]
+	}
+	groups = append(groups, s)
+	return strings.Join(groups, "-")
+}
+
+// normalizeRecoveryCode makes codes insensitive to case and separators
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToUpper(code)
+	return strings.NewReplacer("-", "", " ", "").Replace(code)
+}
+
+func hashRecoveryCode(code string) []byte {
+	sum := sha256.Sum256([]byte("lockbox-recovery:" + normalizeRecoveryCode(code)))
+	return sum[:]
+}
@@ -0,0 +1,15 @@
+//go:build !(linux || darwin || freebsd || netbsd || openbsd)
+
+package format
+
+import "os"
+
+// chownLike is a no-op where files have no Unix owner
+func chownLike(f *os.File, fi os.FileInfo) error {
+	return nil
+}
+
+// syncDir is a no-op where directories cannot be synced
+func syncDir(dir string) error {
+	return nil
+}
@@ -0,0 +1,34 @@
+//go:build linux || darwin || freebsd || netbsd || openbsd
+
+package format
+
+import (
+	"os"
+	"syscall"
+)
+
+// chownLike gives f the owner and group of the file described by fi
+func chownLike(f *os.File, fi os.FileInfo) error {
+	want, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok {
+		return nil
+	}
+	cur, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if have, ok := cur.Sys().(*syscall.Stat_t); ok && have.Uid == want.Uid && have.Gid == want.Gid {
+		return nil
+	}
+	return f.Chown(int(want.Uid), int(want.Gid))
+}
+
+// syncDir flushes a directory so that a rename in it is durable
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
@@ -0,0 +1,210 @@
+package format
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"fmt"
+
+	"github.com/TFMV/lockbox/pkg/metadata"
+	"github.com/rs/zerolog/log"
+)
+
+// RotateColumnKey moves a column to a new key epoch. Each block of the
+// column is decrypted with the key it was written with, re-encrypted with
+// the key of the new epoch and appended to the file; other columns are not
+// touched. The file is then rewritten without the old ciphertext and
+// renamed over the original. It returns the new epoch.
+func (w *Writer) RotateColumnKey(column string) (int, error) {
+	epoch, err := w.nextEpoch(column)
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := w.reencryptColumn(context.Background(), column, -1)
+	if err != nil {
+		return 0, err
+	}
+	w.file.metadata.LogAccess("system", "rotate-key", column, true, fmt.Sprintf("epoch %d, %d blocks", epoch, n))
+	if err := w.commitReencryption(); err != nil {
+		return 0, err
+	}
+
+	log.Debug().Str("column", column).Int("epoch", epoch).Int("blocks", n).Msg("Rotated column key")
+	return epoch, nil
+}
+
+// AdvanceColumnKey moves a column to a new key epoch without touching its
+// existing blocks. New blocks are written with the new key while older
+// blocks keep being read with theirs until CompactKeys re-encrypts them.
+// It returns the new epoch.
+func (w *Writer) AdvanceColumnKey(column string) (int, error) {
+	epoch, err := w.nextEpoch(column)
+	if err != nil {
+		return 0, err
+	}
+	w.file.metadata.LogAccess("system", "advance-key", column, true, fmt.Sprintf("epoch %d", epoch))
+	if err := w.file.resealAccessKeys(w.masterKey); err != nil {
+		return 0, err
+	}
+	if err := w.file.updateMetadata(); err != nil {
+		return 0, fmt.Errorf("failed to update metadata: %w", err)
+	}
+	return epoch, nil
+}
+
+// CompactKeys re-encrypts up to limit blocks that are not at their column's
+// current key epoch; a negative limit re-encrypts all of them. It returns
+// the number of blocks re-encrypted. When ctx is cancelled the blocks done
+// so far are kept. Like RotateColumnKey it replaces the file with a
+// rewritten copy.
+func (w *Writer) CompactKeys(ctx context.Context, limit int) (int, error) {
+	total := 0
+	var compactErr error
+	for _, field := range w.file.metadata.Schema.Fields() {
+		if limit >= 0 && total >= limit {
+			break
+		}
+		remaining := -1
+		if limit >= 0 {
+			remaining = limit - total
+		}
+		done, err := w.reencryptColumn(ctx, field.Name, remaining)
+		total += done
+		if err != nil {
+			compactErr = err
+			break
+		}
+	}
+
+	if total > 0 {
+		w.file.metadata.LogAccess("system", "compact-keys", "blocks", true, fmt.Sprintf("re-encrypted %d blocks", total))
+		if err := w.commitReencryption(); err != nil {
+			return 0, err
+		}
+	}
+	return total, compactErr
+}
+
+// StaleKeyBlocks returns the number of blocks that are not encrypted with
+// their column's current key epoch
+func (lbf *LockboxFile) StaleKeyBlocks() int {
+	n := 0
+	for _, bi := range lbf.metadata.BlockInfo {
+		if bi.KeyEpoch != lbf.metadata.Encryption.ColumnEpoch(bi.ColumnName) {
+			n++
+		}
+	}
+	return n
+}
+
+// nextEpoch advances the current key epoch of a column in memory. The
+// columns of a column group share their key, so they advance together.
+func (w *Writer) nextEpoch(column string) (int, error) {
+	meta := w.file.metadata
+	if len(meta.Schema.FieldIndices(column)) == 0 {
+		return 0, fmt.Errorf("column %s not found", column)
+	}
+
+	key := meta.Encryption.KeyName(column)
+	epoch := meta.Encryption.ColumnEpoch(column) + 1
+	if _, err := w.encryptor(key, epoch); err != nil {
+		return 0, err
+	}
+	if meta.Encryption.ColumnEpochs == nil {
+		meta.Encryption.ColumnEpochs = make(map[string]int)
+	}
+	meta.Encryption.ColumnEpochs[key] = epoch
+	return epoch, nil
+}
+
+// reencryptColumn re-encrypts up to limit blocks of a column that are not at
+// its current key epoch, appending the new ciphertext and updating the
+// block info in memory. It returns the number of blocks re-encrypted,
+// including on error. For a grouped column this covers the whole group,
+// and each shared block is re-encrypted once.
+func (w *Writer) reencryptColumn(ctx context.Context, column string, limit int) (int, error) {
+	meta := w.file.metadata
+	key := meta.Encryption.KeyName(column)
+	epoch := meta.Encryption.ColumnEpoch(column)
+	next, err := w.encryptor(key, epoch)
+	if err != nil {
+		return 0, err
+	}
+
+	n := 0
+	moved := make(map[int64]metadata.BlockInfo) // re-encrypted blocks by old offset
+	for i := range meta.BlockInfo {
+		bi := &meta.BlockInfo[i]
+		if meta.Encryption.KeyName(bi.ColumnName) != key || bi.KeyEpoch == epoch {
+			continue
+		}
+		if m, ok := moved[bi.Offset]; ok {
+			bi.Offset, bi.Length, bi.Checksum, bi.KeyEpoch = m.Offset, m.Length, m.Checksum, m.KeyEpoch
+			if err := w.signBlock(&meta.Encryption, bi); err != nil {
+				return n, err
+			}
+			continue
+		}
+		if limit >= 0 && n >= limit {
+			break
+		}
+		if err := ctx.Err(); err != nil {
+			return n, err
+		}
+
+		encryptedData := make([]byte, bi.Length)
+		if _, err := w.file.file.ReadAt(encryptedData, bi.Offset); err != nil {
+			return n, fmt.Errorf("failed to read block of column %s: %w", column, err)
+		}
+		checksum := sha256.Sum256(encryptedData)
+		if !bytes.Equal(checksum[:], bi.Checksum) {
+			return n, fmt.Errorf("%w: checksum mismatch for column %s", ErrCorruptedBlock, column)
+		}
+
+		current, err := w.encryptor(key, bi.KeyEpoch)
+		if err != nil {
+			return n, err
+		}
+		dec, err := current.Decrypt(encryptedData)
+		if err != nil {
+			return n, fmt.Errorf("failed to decrypt column %s: %w", column, err)
+		}
+		enc, err := next.Encrypt(dec)
+		if err != nil {
+			return n, fmt.Errorf("failed to encrypt column %s: %w", column, err)
+		}
+
+		offset, err := w.file.appendData(enc)
+		if err != nil {
+			return n, fmt.Errorf("failed to write encrypted data: %w", err)
+		}
+
+		n++
+		checksum = sha256.Sum256(enc)
+		oldOffset := bi.Offset
+		bi.Offset = offset
+		bi.Length = int64(len(enc))
+		bi.Checksum = checksum[:]
+		bi.KeyEpoch = epoch
+		if err := w.signBlock(&meta.Encryption, bi); err != nil {
+			return n, err
+		}
+		moved[oldOffset] = *bi
+	}
+	return n, nil
+}
+
+// commitReencryption replaces the file with a copy holding only the
+// re-encrypted blocks, so the old ciphertext is gone from it. Until the
+// rename the file on disk is unchanged apart from the appended blocks.
+func (w *Writer) commitReencryption() error {
+	w.file.rebuildRollup()
+	if err := w.file.resealAccessKeys(w.masterKey); err != nil {
+		return err
+	}
+	if err := w.file.rewrite(); err != nil {
+		return fmt.Errorf("failed to rewrite file: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,134 @@
+package format
+
+import (
+	"crypto/hkdf"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+
+	"github.com/TFMV/lockbox/pkg/crypto"
+	"github.com/TFMV/lockbox/pkg/metadata"
+)
+
+// ErrMetadataSealed is returned when a file with encrypted metadata is used
+// without the password that unseals it
+var ErrMetadataSealed = errors.New("metadata is encrypted and needs a password")
+
+// metadataKey derives the key that seals the metadata from the master key
+func metadataKey(masterKey *crypto.Key, salt []byte) []byte {
+	// HKDF only fails for lengths beyond 255 hash blocks
+	key, _ := hkdf.Key(sha256.New, masterKey.Data, salt, "lockbox metadata", crypto.KeySize)
+	return key
+}
+
+// EncryptMetadata seals the schema, audit trail, access policy and every
+// other part of the metadata with a key derived from the master key. Only
+// the parameters needed to unlock the master key or an access key stay in
+// the clear. It has to be called before data is written, and drops the
+// cleartext metadata written so far; the metadata is saved.
+func (lbf *LockboxFile) EncryptMetadata(masterKey *crypto.Key) error {
+	if lbf.readonly {
+		return fmt.Errorf("file is read-only")
+	}
+	if len(lbf.liveBlocks()) > 0 || len(lbf.metadata.Snapshots) > 0 {
+		return fmt.Errorf("metadata can only be encrypted before data is written")
+	}
+	lbf.metadataKey = metadataKey(masterKey, lbf.metadata.Encryption.MasterSalt)
+	if err := lbf.resealAccessKeys(masterKey); err != nil {
+		lbf.metadataKey = nil
+		return err
+	}
+
+	// Nothing but metadata follows the header yet
+	t, ok := lbf.file.(Truncater)
+	if !ok {
+		return fmt.Errorf("storage of %s cannot be truncated", lbf.Path())
+	}
+	if err := t.Truncate(headerSize); err != nil {
+		return fmt.Errorf("failed to drop cleartext metadata: %w", err)
+	}
+	return lbf.updateMetadata()
+}
+
+// MetadataEncrypted reports whether the file's metadata is encrypted
+func (lbf *LockboxFile) MetadataEncrypted() bool {
+	return lbf.metadataKey != nil || lbf.MetadataSealed()
+}
+
+// MetadataSealed reports whether the metadata is encrypted and was not
+// unsealed, because the file was opened without a password
+func (lbf *LockboxFile) MetadataSealed() bool {
+	return lbf.metadata.Sealed != nil
+}
+
+// unsealMetadata decrypts the metadata with the master key or the access
+// key that password unlocks
+func (lbf *LockboxFile) unsealMetadata(password string) error {
+	enc := lbf.metadata.Encryption
+	masterKey, err := lbf.MasterKey(password)
+	if err != nil && !errors.Is(err, ErrInvalidPassword) {
+		return err
+	}
+	// Files without a wrapped master key derive one from any password, so
+	// a failed unseal still leaves the access keys to try
+	if err == nil && lbf.unseal(metadataKey(masterKey, enc.MasterSalt)) == nil {
+		return nil
+	}
+
+	for _, ak := range enc.AccessKeys {
+		kek := lbf.deriveKey(password, ak.Salt)
+		if kek == nil {
+			continue
+		}
+		accessKey, err := crypto.UnwrapKey(kek.Data, ak.WrappedKey)
+		if err != nil {
+			continue
+		}
+		key, err := crypto.UnwrapKey(accessKey, ak.MetadataKey)
+		if err != nil {
+			return fmt.Errorf("failed to unwrap metadata key: %w", err)
+		}
+		return lbf.unseal(key)
+	}
+	return ErrInvalidPassword
+}
+
+// unseal replaces the cleartext part of sealed metadata with the metadata
+// it decrypts with key
+func (lbf *LockboxFile) unseal(key []byte) error {
+	plaintext, err := crypto.UnwrapKey(key, lbf.metadata.Sealed)
+	if err != nil {
+		return ErrInvalidPassword
+	}
+	meta, err := metadata.Deserialize(plaintext)
+	if err != nil {
+		return fmt.Errorf("failed to deserialize sealed metadata: %w", err)
+	}
+	meta.Header = lbf.metadata.Header
+	lbf.metadata = meta
+	lbf.metadataKey = key
+	return nil
+}
+
+// serializeMetadata encodes the metadata for the file, sealed when the
+// file's metadata is encrypted
+func (lbf *LockboxFile) serializeMetadata() ([]byte, error) {
+	if lbf.MetadataSealed() {
+		return nil, ErrMetadataSealed
+	}
+	data, err := lbf.metadata.Serialize()
+	if err != nil || lbf.metadataKey == nil {
+		return data, err
+	}
+
+	sealed, err := crypto.WrapKey(lbf.metadataKey, data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encrypt metadata: %w", err)
+	}
+	envelope := &metadata.Metadata{
+		Header:     lbf.metadata.Header,
+		Encryption: lbf.metadata.Encryption.KeyParams(),
+		Sealed:     sealed,
+	}
+	return envelope.Serialize()
+}
@@ -0,0 +1,134 @@
+package format
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/TFMV/lockbox/pkg/metadata"
+)
+
+// ErrBadSignature is returned for blocks of a signed file whose signature
+// is missing or does not verify
+var ErrBadSignature = errors.New("invalid block signature")
+
+// blockMessage returns what the signature of a block covers: its position,
+// that is its row group and the column or column group it holds, its key
+// epoch, row count and the checksum of its ciphertext. Signed blocks can't
+// be swapped between row groups or columns, or replayed after a key
+// rotation. Offsets are left out so copying a file or collecting garbage
+// keeps the signatures valid; Repair signs the row groups it renumbers
+// again.
+func blockMessage(bi *metadata.BlockInfo) []byte {
+	var msg bytes.Buffer
+	msg.WriteString("lockbox block\x00")
+	msg.WriteString(bi.ColumnName)
+	msg.WriteByte(0)
+	msg.WriteString(bi.Group)
+	msg.WriteByte(0)
+	for _, v := range []int64{int64(bi.RowGroup), int64(bi.KeyEpoch), bi.RowCount} {
+		_ = binary.Write(&msg, binary.LittleEndian, v)
+	}
+	msg.Write(bi.Checksum)
+	return msg.Bytes()
+}
+
+// signs reports whether the blocks of the file are signed. Keyrings with
+// the master key go by the column key derivation, which their keys depend
+// on, rather than by the cleartext BlockSignatures.
+func (k *keyring) signs(enc metadata.EncryptionParams) bool {
+	if k.kdf == metadata.ColumnKeySignedHKDF {
+		return true
+	}
+	return enc.SignatureScheme() != ""
+}
+
+// signBlock signs bi if the file signs its blocks, recording the public
+// key with the first signature
+func (k *keyring) signBlock(enc *metadata.EncryptionParams, bi *metadata.BlockInfo) error {
+	if !k.signs(*enc) {
+		return nil
+	}
+	if k.signer == nil {
+		return fmt.Errorf("signing blocks requires the password")
+	}
+	pub := k.signer.Public().(ed25519.PublicKey)
+	if len(enc.SigningKey) == 0 {
+		enc.SigningKey = pub
+	} else if !bytes.Equal(enc.SigningKey, pub) {
+		return fmt.Errorf("%w: the recorded signing key does not match the master key", ErrBadSignature)
+	}
+	bi.Signature = ed25519.Sign(k.signer, blockMessage(bi))
+	return nil
+}
+
+// verifyBlock checks the signature of bi if the file signs its blocks.
+// Keyrings with the master key check against the key derived from it, and
+// can't be told by the metadata that blocks are unsigned: their column keys
+// only decrypt a signed file while they derive them for one. Keyrings built
+// from column keys only have the recorded key and scheme, which someone
+// able to rewrite the metadata could replace.
+func (k *keyring) verifyBlock(enc metadata.EncryptionParams, bi *metadata.BlockInfo) error {
+	if !k.signs(enc) {
+		return nil
+	}
+	pub := ed25519.PublicKey(enc.SigningKey)
+	if k.signer != nil {
+		pub = k.signer.Public().(ed25519.PublicKey)
+		if len(enc.SigningKey) > 0 && !bytes.Equal(enc.SigningKey, pub) {
+			return fmt.Errorf("%w: the recorded signing key does not match the master key", ErrBadSignature)
+		}
+	}
+	if len(bi.Signature) == 0 {
+		return fmt.Errorf("%w: block of column %s is unsigned", ErrBadSignature, bi.ColumnName)
+	}
+	if len(pub) != ed25519.PublicKeySize || !ed25519.Verify(pub, blockMessage(bi), bi.Signature) {
+		return fmt.Errorf("%w: column %s", ErrBadSignature, bi.ColumnName)
+	}
+	return nil
+}
+
+// VerifySignatures reads every data block, and the blocks holding
+// materialized view results, and checks its checksum and signature. It
+// returns the number of blocks checked and a description of each that
+// fails, including metadata that no longer records the signature scheme.
+// Files created before block signatures have nothing to check.
+func (r *Reader) VerifySignatures() (int, []string, error) {
+	meta := r.file.metadata
+	if !r.signs(meta.Encryption) {
+		return 0, nil, nil
+	}
+	var invalid []string
+	if meta.Encryption.BlockSignatures == "" {
+		invalid = append(invalid, "metadata: the block signature scheme was removed")
+	}
+	blocks := append([]metadata.BlockInfo{}, meta.BlockInfo...)
+	for _, v := range meta.Views {
+		if v.Block != nil {
+			blocks = append(blocks, *v.Block)
+		}
+	}
+
+	for i := range blocks {
+		bi := &blocks[i]
+		where := fmt.Sprintf("%s row group %d", bi.ColumnName, bi.RowGroup)
+		if i >= len(meta.BlockInfo) {
+			where = "view block " + bi.ColumnName
+		}
+		data := make([]byte, bi.Length)
+		if _, err := r.file.file.ReadAt(data, bi.Offset); err != nil {
+			return i, invalid, fmt.Errorf("failed to read block of %s: %w", bi.ColumnName, err)
+		}
+		if sum := sha256.Sum256(data); !bytes.Equal(sum[:], bi.Checksum) {
+			invalid = append(invalid, where+": checksum mismatch")
+			continue
+		}
+		if err := r.verifyBlock(meta.Encryption, bi); err != nil {
+			invalid = append(invalid, where+": "+err.Error())
+		}
+	}
+	return len(blocks), invalid, nil
+}
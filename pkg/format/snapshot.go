@@ -0,0 +1,25 @@
+package format
+
+import (
+	"github.com/TFMV/lockbox/pkg/metadata"
+)
+
+// SetCommit sets the message, principal, operation and lineage recorded
+// in the snapshot of the next WriteRecord. They are cleared after the write.
+func (w *Writer) SetCommit(commit metadata.Snapshot) {
+	w.commit = commit
+}
+
+// addSnapshot records the pending commit after rows were written
+func (w *Writer) addSnapshot(rows int64) {
+	commit := w.commit
+	w.commit = metadata.Snapshot{}
+	if commit.Operation == "" {
+		commit.Operation = "write"
+	}
+	if commit.Principal == "" {
+		commit.Principal = "system"
+	}
+	commit.RowsAdded = rows
+	w.file.metadata.AddSnapshot(commit)
+}
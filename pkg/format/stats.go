@@ -0,0 +1,251 @@
+package format
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	"github.com/TFMV/lockbox/pkg/metadata"
+	"github.com/apache/arrow-go/v18/arrow"
+	"github.com/apache/arrow-go/v18/arrow/array"
+)
+
+// statTime is the layout of times in block stats: UTC at a fixed width, so
+// they sort as text
+const statTime = "2006-01-02T15:04:05.000000000Z"
+
+// blockStats returns the stats of a column block, with its value range
+// when withRange is set
+func blockStats(col arrow.Array, withRange bool) *metadata.BlockStats {
+	s := &metadata.BlockStats{Nulls: int64(col.NullN())}
+	if !withRange || col.NullN() == col.Len() {
+		return s
+	}
+	text := statText(col)
+	if text == nil {
+		return s
+	}
+	typ := col.DataType()
+	for i := 0; i < col.Len(); i++ {
+		if col.IsNull(i) {
+			continue
+		}
+		v := text(i)
+		if s.Min == "" && s.Max == "" {
+			s.Min, s.Max = v, v
+			continue
+		}
+		if compareStat(typ, v, s.Min) < 0 {
+			s.Min = v
+		}
+		if compareStat(typ, v, s.Max) > 0 {
+			s.Max = v
+		}
+	}
+	return s
+}
+
+// statText returns a function giving the text of a value in block stats,
+// or nil for types that have no value range
+func statText(col arrow.Array) func(int) string {
+	switch c := col.(type) {
+	case *array.Int8:
+		return func(i int) string { return strconv.FormatInt(int64(c.Value(i)), 10) }
+	case *array.Int16:
+		return func(i int) string { return strconv.FormatInt(int64(c.Value(i)), 10) }
+	case *array.Int32:
+		return func(i int) string { return strconv.FormatInt(int64(c.Value(i)), 10) }
+	case *array.Int64:
+		return func(i int) string { return strconv.FormatInt(c.Value(i), 10) }
+	case *array.Uint8:
+		return func(i int) string { return strconv.FormatUint(uint64(c.Value(i)), 10) }
+	case *array.Uint16:
+		return func(i int) string { return strconv.FormatUint(uint64(c.Value(i)), 10) }
+	case *array.Uint32:
+		return func(i int) string { return strconv.FormatUint(uint64(c.Value(i)), 10) }
+	case *array.Uint64:
+		return func(i int) string { return strconv.FormatUint(c.Value(i), 10) }
+	case *array.Float32:
+		return func(i int) string { return strconv.FormatFloat(float64(c.Value(i)), 'g', -1, 32) }
+	case *array.Float64:
+		return func(i int) string { return strconv.FormatFloat(c.Value(i), 'g', -1, 64) }
+	case *array.String:
+		return c.Value
+	case *array.LargeString:
+		return c.Value
+	case *array.Date32:
+		return func(i int) string { return c.Value(i).ToTime().UTC().Format(statTime) }
+	case *array.Date64:
+		return func(i int) string { return c.Value(i).ToTime().UTC().Format(statTime) }
+	case *array.Timestamp:
+		unit := c.DataType().(*arrow.TimestampType).Unit
+		return func(i int) string { return c.Value(i).ToTime(unit).UTC().Format(statTime) }
+	}
+	return nil
+}
+
+// compareStat compares two values of type typ in block stats text
+func compareStat(typ arrow.DataType, a, b string) int {
+	switch typ.ID() {
+	case arrow.INT8, arrow.INT16, arrow.INT32, arrow.INT64:
+		x, _ := strconv.ParseInt(a, 10, 64)
+		y, _ := strconv.ParseInt(b, 10, 64)
+		return cmpOrdered(x, y)
+	case arrow.UINT8, arrow.UINT16, arrow.UINT32, arrow.UINT64:
+		x, _ := strconv.ParseUint(a, 10, 64)
+		y, _ := strconv.ParseUint(b, 10, 64)
+		return cmpOrdered(x, y)
+	case arrow.FLOAT32, arrow.FLOAT64:
+		x, _ := strconv.ParseFloat(a, 64)
+		y, _ := strconv.ParseFloat(b, 64)
+		return cmpOrdered(x, y)
+	}
+	return cmpOrdered(a, b)
+}
+
+func cmpOrdered[T int64 | uint64 | float64 | string](a, b T) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// addToRollup adds a block to the rollup of a file. newData is set for the
+// first block info pointing at a stored block, so blocks shared by a
+// column group count once towards the data size.
+func addToRollup(r *metadata.Rollup, schema *arrow.Schema, bi *metadata.BlockInfo, newData bool) {
+	if newData {
+		r.DataBytes += bi.Length
+	}
+	if schema != nil && schema.NumFields() > 0 && bi.ColumnName == schema.Field(0).Name {
+		r.Rows += bi.RowCount
+	}
+	if r.Columns == nil {
+		r.Columns = make(map[string]*metadata.ColumnRollup)
+	}
+	c := r.Columns[bi.ColumnName]
+	if c == nil {
+		c = &metadata.ColumnRollup{}
+		r.Columns[bi.ColumnName] = c
+	}
+	c.Rows += bi.RowCount
+	c.Bytes += bi.Length
+	if bi.Stats == nil {
+		c.Partial = true
+		return
+	}
+	c.Nulls += bi.Stats.Nulls
+	if bi.Stats.Min == "" && bi.Stats.Max == "" {
+		return
+	}
+	var typ arrow.DataType = arrow.BinaryTypes.String
+	if schema != nil {
+		if idx := schema.FieldIndices(bi.ColumnName); len(idx) > 0 {
+			typ = schema.Field(idx[0]).Type
+		}
+	}
+	if c.Min == "" && c.Max == "" {
+		c.Min, c.Max = bi.Stats.Min, bi.Stats.Max
+		return
+	}
+	if compareStat(typ, bi.Stats.Min, c.Min) < 0 {
+		c.Min = bi.Stats.Min
+	}
+	if compareStat(typ, bi.Stats.Max, c.Max) > 0 {
+		c.Max = bi.Stats.Max
+	}
+}
+
+// rebuildRollup sums up every block again, after blocks were replaced or
+// dropped
+func (lbf *LockboxFile) rebuildRollup() {
+	meta := lbf.metadata
+	r := &metadata.Rollup{}
+	seen := make(map[int64]bool, len(meta.BlockInfo))
+	for i := range meta.BlockInfo {
+		bi := &meta.BlockInfo[i]
+		addToRollup(r, meta.Schema, bi, !seen[bi.Offset])
+		seen[bi.Offset] = true
+	}
+	meta.Rollup = r
+}
+
+// rollupBlocks adds the blocks from index first on, just written, to the
+// rollup, or rebuilds it for files that don't have one yet
+func (lbf *LockboxFile) rollupBlocks(first int) {
+	meta := lbf.metadata
+	if meta.Rollup == nil {
+		lbf.rebuildRollup()
+		return
+	}
+	var last int64 = -1
+	for i := first; i < len(meta.BlockInfo); i++ {
+		bi := &meta.BlockInfo[i]
+		addToRollup(meta.Rollup, meta.Schema, bi, bi.Offset != last)
+		last = bi.Offset
+	}
+}
+
+// statsWithRange reports whether block stats record value ranges, which
+// they do for files whose metadata is encrypted
+func (lbf *LockboxFile) statsWithRange() bool {
+	return lbf.MetadataEncrypted()
+}
+
+// RefreshStats computes the stats of blocks written without them, so the
+// rollup is no longer partial and scans can skip those blocks again. A
+// grouped block is decrypted once for all of its columns. It returns the
+// number of block entries updated; the metadata still has to be saved.
+func (w *Writer) RefreshStats(ctx context.Context) (int, error) {
+	meta := w.file.metadata
+	r := &Reader{keyring: w.keyring, file: w.file}
+	withRange := w.file.statsWithRange()
+	mem := w.file.Allocator()
+
+	n := 0
+	var rec arrow.Record
+	var recOffset int64 = -1
+	defer func() {
+		if rec != nil {
+			rec.Release()
+		}
+	}()
+	for i := range meta.BlockInfo {
+		bi := &meta.BlockInfo[i]
+		if bi.Stats != nil {
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			return n, err
+		}
+		idx := meta.Schema.FieldIndices(bi.ColumnName)
+		if len(idx) == 0 {
+			return n, fmt.Errorf("column %s not found", bi.ColumnName)
+		}
+		if bi.Offset != recOffset {
+			if rec != nil {
+				rec.Release()
+				rec = nil
+			}
+			var err error
+			if rec, err = r.readBlockRecord(bi.ColumnName, *bi); err != nil {
+				return n, err
+			}
+			recOffset = bi.Offset
+		}
+		col, err := blockColumn(mem, meta.Schema.Field(idx[0]), rec)
+		if err != nil {
+			return n, err
+		}
+		bi.Stats = blockStats(col, withRange)
+		col.Release()
+		n++
+	}
+	if n > 0 {
+		w.file.rebuildRollup()
+	}
+	return n, nil
+}
@@ -0,0 +1,139 @@
+package format
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync"
+)
+
+// Storage holds the bytes of a lockbox file. Files on disk are kept in an
+// *os.File; implementing Storage lets lockboxes live in memory buffers,
+// mmapped regions or blob stores, through CreateOn and OpenOn. Blocks and
+// metadata are only ever appended at Size, and the header is rewritten in
+// place.
+type Storage interface {
+	io.ReaderAt
+	io.WriterAt
+	// Size returns the number of bytes stored
+	Size() (int64, error)
+	Close() error
+}
+
+// Truncater is implemented by storages that can shrink. Encrypting the
+// metadata of a new file and gc need it.
+type Truncater interface {
+	Truncate(size int64) error
+}
+
+// ReadOnlyStorage is implemented by storages that cannot be written, such
+// as objects read from a blob store. Files opened on them are read-only.
+type ReadOnlyStorage interface {
+	ReadOnly() bool
+}
+
+// fileStorage is the storage of a file on disk
+type fileStorage struct {
+	*os.File
+}
+
+func (f fileStorage) Size() (int64, error) {
+	fi, err := f.Stat()
+	if err != nil {
+		return 0, fmt.Errorf("failed to stat file: %w", err)
+	}
+	return fi.Size(), nil
+}
+
+// Buffer is a Storage in memory. The zero value is an empty buffer.
+type Buffer struct {
+	mu   sync.RWMutex
+	data []byte
+}
+
+// NewBuffer returns a buffer holding data, such as a lockbox file read
+// into memory; the buffer takes ownership of data
+func NewBuffer(data []byte) *Buffer {
+	return &Buffer{data: data}
+}
+
+// Bytes returns the contents of the buffer, valid until its next write
+func (b *Buffer) Bytes() []byte {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.data
+}
+
+// ReadAt implements io.ReaderAt
+func (b *Buffer) ReadAt(p []byte, off int64) (int, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	if off < 0 {
+		return 0, fmt.Errorf("negative offset %d", off)
+	}
+	if off >= int64(len(b.data)) {
+		return 0, io.EOF
+	}
+	n := copy(p, b.data[off:])
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// WriteAt implements io.WriterAt, growing the buffer as needed
+func (b *Buffer) WriteAt(p []byte, off int64) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if off < 0 {
+		return 0, fmt.Errorf("negative offset %d", off)
+	}
+	if end := off + int64(len(p)); end > int64(len(b.data)) {
+		if end > int64(cap(b.data)) {
+			grown := make([]byte, end, max(end, 2*int64(cap(b.data))))
+			copy(grown, b.data)
+			b.data = grown
+		}
+		b.data = b.data[:end]
+	}
+	return copy(b.data[off:], p), nil
+}
+
+// Size returns the length of the buffer
+func (b *Buffer) Size() (int64, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return int64(len(b.data)), nil
+}
+
+// Truncate shrinks or zero-extends the buffer to size bytes
+func (b *Buffer) Truncate(size int64) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if size < 0 {
+		return fmt.Errorf("negative size %d", size)
+	}
+	if size <= int64(len(b.data)) {
+		b.data = b.data[:size]
+		return nil
+	}
+	b.data = append(b.data, make([]byte, size-int64(len(b.data)))...)
+	return nil
+}
+
+// Close does nothing; the contents stay available through Bytes
+func (b *Buffer) Close() error {
+	return nil
+}
+
+// appendData writes data at the end of the file and returns its offset
+func (lbf *LockboxFile) appendData(data []byte) (int64, error) {
+	offset, err := lbf.file.Size()
+	if err != nil {
+		return 0, err
+	}
+	if _, err := lbf.file.WriteAt(data, offset); err != nil {
+		return 0, err
+	}
+	return offset, nil
+}
@@ -0,0 +1,123 @@
+package format
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/TFMV/lockbox/pkg/metadata"
+	"github.com/apache/arrow-go/v18/arrow"
+)
+
+// ErrMetadataOnly is returned when data is requested from a file opened
+// with OpenMetadataOnly
+var ErrMetadataOnly = errors.New("file was opened for metadata only")
+
+// Summary is the information about a lockbox file that is stored in the
+// clear and can be read without credentials
+type Summary struct {
+	Version     uint32
+	Schema      *arrow.Schema // nil when the schema is not stored in the clear
+	CreatedAt   time.Time
+	CreatedBy   string
+	ModifiedAt  time.Time
+	ModifiedBy  string
+	RowCount    int64 // rows not deleted
+	DeletedRows int64 // rows deleted but not yet compacted away
+	BlockCount  int
+	DataSize    int64 // encrypted bytes in live column blocks
+	FileSize    int64
+	Module      string
+	Codecs      []string
+	Groups      []metadata.ColumnGroup // column groups
+	Description string
+	Tags        map[string]string
+
+	// EncryptedMetadata is set for files whose metadata is encrypted. Read
+	// without the password their summary only has the file size and module.
+	EncryptedMetadata bool
+	// KeyDerivation is how keys are derived from passwords, such as
+	// metadata.KDFArgon2id; it stays in the clear
+	KeyDerivation string
+	// Columns sums up the blocks of each column; nil for files written
+	// before rollups
+	Columns map[string]*metadata.ColumnRollup
+}
+
+// OpenMetadataOnly opens a lockbox file read-only and reads only its header
+// and cleartext metadata. No password or crypto module is needed, so
+// catalogs can index files they cannot decrypt; creating readers or writers
+// fails with ErrMetadataOnly.
+func OpenMetadataOnly(filename string) (*LockboxFile, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open file: %w", err)
+	}
+	lbf, err := OpenMetadataOnlyOn(fileStorage{file}, filename)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return lbf, nil
+}
+
+// OpenMetadataOnlyOn opens the lockbox file held in storage as
+// OpenMetadataOnly does
+func OpenMetadataOnlyOn(storage Storage, name string) (*LockboxFile, error) {
+	lbf := &LockboxFile{
+		file:         storage,
+		name:         name,
+		readonly:     true,
+		metadataOnly: true,
+	}
+	if err := lbf.readHeader(); err != nil {
+		return nil, fmt.Errorf("failed to read header: %w", err)
+	}
+	return lbf, nil
+}
+
+// Summary returns the cleartext summary of the file
+func (lbf *LockboxFile) Summary() (*Summary, error) {
+	meta := lbf.metadata
+	size, err := lbf.file.Size()
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Summary{
+		Version:     meta.Header.Version,
+		Schema:      meta.Schema,
+		CreatedAt:   meta.AuditTrail.CreatedAt,
+		CreatedBy:   meta.AuditTrail.CreatedBy,
+		ModifiedAt:  meta.AuditTrail.ModifiedAt,
+		ModifiedBy:  meta.AuditTrail.ModifiedBy,
+		BlockCount:  len(meta.BlockInfo),
+		FileSize:    size,
+		Module:      meta.Encryption.ModuleName(),
+		Codecs:      meta.Encryption.Codecs,
+		Groups:      meta.Encryption.ColumnGroups,
+		Description: meta.Description,
+		Tags:        meta.Tags,
+
+		EncryptedMetadata: lbf.MetadataEncrypted(),
+		KeyDerivation:     meta.Encryption.KeyDerivation,
+	}
+
+	// Files written since rollups keep the totals up to date; older ones
+	// are summed up here
+	s.DeletedRows = meta.DeletedRows()
+	if r := meta.Rollup; r != nil {
+		s.RowCount, s.DataSize, s.Columns = r.Rows-s.DeletedRows, r.DataBytes, r.Columns
+		return s, nil
+	}
+	s.RowCount = meta.LiveRows()
+	seen := make(map[int64]bool, len(meta.BlockInfo))
+	for _, bi := range meta.BlockInfo {
+		if !seen[bi.Offset] {
+			seen[bi.Offset] = true
+			s.DataSize += bi.Length
+		}
+	}
+	return s, nil
+}
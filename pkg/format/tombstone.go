@@ -0,0 +1,274 @@
+package format
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/TFMV/lockbox/pkg/geo"
+	"github.com/TFMV/lockbox/pkg/metadata"
+	"github.com/apache/arrow-go/v18/arrow"
+	"github.com/apache/arrow-go/v18/arrow/array"
+	"github.com/apache/arrow-go/v18/arrow/memory"
+	"github.com/rs/zerolog/log"
+)
+
+// DeleteRows marks rows as deleted, given by row group and by position
+// among the rows stored in the group, deleted or not. Reads skip them from
+// then on, at every snapshot, until Compact removes them from the blocks.
+// The change is committed as a snapshot with the pending commit. It
+// returns the number of rows that were not already deleted.
+func (w *Writer) DeleteRows(rows map[int][]int) (int64, error) {
+	meta := w.file.metadata
+	groupRows := w.file.metadata.RowGroupRows()
+	for group, positions := range rows {
+		if group < 0 || group >= len(groupRows) {
+			return 0, fmt.Errorf("row group %d out of range [0, %d)", group, len(groupRows))
+		}
+		for _, i := range positions {
+			if i < 0 || int64(i) >= groupRows[group] {
+				return 0, fmt.Errorf("row %d out of range [0, %d) in row group %d", i, groupRows[group], group)
+			}
+		}
+	}
+
+	var deleted int64
+	for group, positions := range rows {
+		deleted += meta.MarkDeleted(group, positions)
+	}
+
+	meta.LogAccess("system", "delete", "record", true, fmt.Sprintf("deleted %d rows", deleted))
+	meta.AuditTrail.ModifiedAt = time.Now()
+	w.addSnapshot(0)
+	if err := w.file.updateMetadata(); err != nil {
+		return 0, fmt.Errorf("failed to update metadata: %w", err)
+	}
+	return deleted, nil
+}
+
+// Compact rewrites the row groups with deleted rows without them and drops
+// their tombstones. The new blocks are appended to the file and the old
+// ones left for Reclaim. Row groups keep their numbers, even when no row is
+// left, and the total rows of earlier snapshots are recounted so they still
+// end at the same row group. The change is committed as a snapshot with the
+// pending commit. It returns the number of rows removed.
+func (w *Writer) Compact() (int64, error) {
+	meta := w.file.metadata
+	if len(meta.Tombstones) == 0 {
+		return 0, nil
+	}
+
+	// The row groups of each snapshot, found while the row counts still
+	// add up to its total
+	groupsAt := make([]int, len(meta.Snapshots))
+	for i, s := range meta.Snapshots {
+		n, err := meta.RowGroupsAt(s.ID)
+		if err != nil {
+			n = -1
+		}
+		groupsAt[i] = n
+	}
+
+	r := &Reader{keyring: w.keyring, file: w.file}
+	fields := meta.Schema.Fields()
+	codecName := ""
+	if w.codec != nil {
+		codecName = w.codec.Name()
+		meta.Encryption.AddCodec(codecName)
+	}
+	withRange := w.file.statsWithRange()
+	removed := meta.DeletedRows()
+	for _, t := range meta.Tombstones {
+		blocks, err := r.rowGroupBlocks(t.RowGroup, fields)
+		if err != nil {
+			return 0, err
+		}
+		arrays, err := r.readBlocks(fields, blocks)
+		if err != nil {
+			return 0, err
+		}
+		record := array.NewRecord(meta.Schema, arrays, -1)
+		for _, arr := range arrays {
+			arr.Release()
+		}
+		err = w.rewriteRowGroup(t.RowGroup, record, codecName, withRange)
+		record.Release()
+		if err != nil {
+			return 0, err
+		}
+	}
+	meta.Tombstones = nil
+
+	rows := w.file.metadata.RowGroupRows()
+	for i, n := range groupsAt {
+		if n < 0 {
+			continue
+		}
+		var total int64
+		for _, r := range rows[:n] {
+			total += r
+		}
+		meta.Snapshots[i].TotalRows = total
+	}
+
+	meta.LogAccess("system", "compact", "record", true, fmt.Sprintf("removed %d deleted rows", removed))
+	meta.AuditTrail.ModifiedAt = time.Now()
+	w.file.rebuildRollup()
+	w.addSnapshot(0)
+	if err := w.file.updateMetadata(); err != nil {
+		return 0, fmt.Errorf("failed to update metadata: %w", err)
+	}
+	log.Debug().Int64("rows", removed).Msg("Compacted deleted rows")
+	return removed, nil
+}
+
+// rewriteRowGroup stores record as the new content of row group n: its
+// blocks are appended and the block infos of the group point at them
+func (w *Writer) rewriteRowGroup(n int, record arrow.Record, codecName string, withRange bool) error {
+	meta := w.file.metadata
+	enc := &meta.Encryption
+	units, err := w.encodeRecord(record)
+	if err != nil {
+		return err
+	}
+	for _, u := range units {
+		offset, err := w.file.appendData(u.data)
+		if err != nil {
+			return fmt.Errorf("failed to write encrypted data: %w", err)
+		}
+		for k, field := range u.fields {
+			var block *metadata.BlockInfo
+			for i := range meta.BlockInfo {
+				if bi := &meta.BlockInfo[i]; bi.ColumnName == field.Name && bi.RowGroup == n {
+					block = bi
+					break
+				}
+			}
+			if block == nil {
+				return fmt.Errorf("column %s has no block in row group %d", field.Name, n)
+			}
+			block.Offset = offset
+			block.Length = int64(len(u.data))
+			block.RowCount = record.NumRows()
+			block.Checksum = u.checksum[:]
+			block.OrigSize = u.origSize
+			block.Codec = codecName
+			block.Compressed = codecName != ""
+			block.Group = u.group
+			block.KeyEpoch = enc.ColumnEpoch(field.Name)
+			block.BBox = nil
+			if bb, ok := geo.Bounds(field, u.cols[k]); ok {
+				block.BBox = &bb
+			}
+			block.Stats = blockStats(u.cols[k], withRange)
+			if err := w.signBlock(enc, block); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// dropDeleted returns the columns of a row group without the rows t marks
+// as deleted. It takes over the references to cols.
+func dropDeleted(mem memory.Allocator, cols []arrow.Array, t *metadata.Tombstone) ([]arrow.Array, error) {
+	defer func() {
+		for _, col := range cols {
+			col.Release()
+		}
+	}()
+	out := make([]arrow.Array, len(cols))
+	for i, col := range cols {
+		var runs []arrow.Array
+		start := -1
+		for row := 0; row <= col.Len(); row++ {
+			live := row < col.Len() && !t.Deleted(row)
+			if live && start < 0 {
+				start = row
+			} else if !live && start >= 0 {
+				runs = append(runs, array.NewSlice(col, int64(start), int64(row)))
+				start = -1
+			}
+		}
+
+		var err error
+		switch len(runs) {
+		case 0:
+			out[i] = array.NewSlice(col, 0, 0)
+		case 1:
+			out[i] = runs[0]
+			runs = nil
+		default:
+			out[i], err = array.Concatenate(runs, mem)
+		}
+		for _, run := range runs {
+			run.Release()
+		}
+		if err != nil {
+			for _, arr := range out[:i] {
+				arr.Release()
+			}
+			return nil, fmt.Errorf("failed to drop deleted rows: %w", err)
+		}
+	}
+	return out, nil
+}
+
+// fillDeleted spreads col, which holds one value for each live row of
+// blocks in order, over every row they store, with nulls at the deleted
+// rows, so a column can be rewritten from the rows reads return. col is
+// returned as is when it does not hold one value per live row. The
+// returned array must be released.
+func (lbf *LockboxFile) fillDeleted(col arrow.Array, blocks []*metadata.BlockInfo) (arrow.Array, error) {
+	meta := lbf.metadata
+	var live int64
+	for _, bi := range blocks {
+		live += bi.RowCount
+		if t, ok := meta.FindTombstone(bi.RowGroup); ok {
+			live -= t.Count
+		}
+	}
+	if len(meta.Tombstones) == 0 || int64(col.Len()) != live {
+		col.Retain()
+		return col, nil
+	}
+
+	mem := lbf.Allocator()
+	if dict, ok := col.(*array.Dictionary); ok {
+		plain, err := decodeDictionary(mem, dict)
+		if err != nil {
+			return nil, err
+		}
+		defer plain.Release()
+		col = plain
+	}
+	var parts []arrow.Array
+	defer func() {
+		for _, part := range parts {
+			part.Release()
+		}
+	}()
+	var pos int64
+	for _, bi := range blocks {
+		t, _ := meta.FindTombstone(bi.RowGroup)
+		deleted := func(i int) bool { return t != nil && t.Deleted(i) }
+		for i := 0; i < int(bi.RowCount); {
+			j := i
+			for j < int(bi.RowCount) && deleted(j) == deleted(i) {
+				j++
+			}
+			n := int64(j - i)
+			if deleted(i) {
+				parts = append(parts, array.MakeArrayOfNull(mem, col.DataType(), int(n)))
+			} else {
+				parts = append(parts, array.NewSlice(col, pos, pos+n))
+				pos += n
+			}
+			i = j
+		}
+	}
+	if len(parts) == 0 {
+		col.Retain()
+		return col, nil
+	}
+	return array.Concatenate(parts, mem)
+}
@@ -0,0 +1,202 @@
+// Package geo handles point columns: latitude and longitude pairs stored
+// either as a struct of two float64 fields or as WKB points in a binary
+// column.
+package geo
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+
+	"github.com/apache/arrow-go/v18/arrow"
+	"github.com/apache/arrow-go/v18/arrow/array"
+)
+
+// EarthRadius is the mean radius of the earth in meters
+const EarthRadius = 6371008.8
+
+// MetadataKey is the field metadata key marking a binary column as holding
+// points in WKB, with the value "wkb"
+const MetadataKey = "geo"
+
+// Point is a location in degrees
+type Point struct {
+	Lat float64
+	Lon float64
+}
+
+// BBox is a latitude and longitude bounding box in degrees
+type BBox struct {
+	MinLat float64 `json:"minLat"`
+	MinLon float64 `json:"minLon"`
+	MaxLat float64 `json:"maxLat"`
+	MaxLon float64 `json:"maxLon"`
+}
+
+// Contains reports whether p lies in the box
+func (b BBox) Contains(p Point) bool {
+	return p.Lat >= b.MinLat && p.Lat <= b.MaxLat && p.Lon >= b.MinLon && p.Lon <= b.MaxLon
+}
+
+// Intersects reports whether the boxes overlap
+func (b BBox) Intersects(o BBox) bool {
+	return b.MinLat <= o.MaxLat && o.MinLat <= b.MaxLat && b.MinLon <= o.MaxLon && o.MinLon <= b.MaxLon
+}
+
+// Around returns a box holding every point within meters of p. Near the
+// poles or the antimeridian it spans all longitudes.
+func Around(p Point, meters float64) BBox {
+	r := meters / EarthRadius
+	d := r * 180 / math.Pi
+	b := BBox{MinLat: p.Lat - d, MaxLat: p.Lat + d, MinLon: -180, MaxLon: 180}
+	if b.MinLat <= -90 || b.MaxLat >= 90 {
+		b.MinLat, b.MaxLat = max(b.MinLat, -90), min(b.MaxLat, 90)
+		return b
+	}
+	dLon := math.Asin(math.Sin(r)/math.Cos(p.Lat*math.Pi/180)) * 180 / math.Pi
+	if p.Lon-dLon >= -180 && p.Lon+dLon <= 180 {
+		b.MinLon, b.MaxLon = p.Lon-dLon, p.Lon+dLon
+	}
+	return b
+}
+
+// Distance returns the great-circle distance between a and b in meters
+func Distance(a, b Point) float64 {
+	lat1, lat2 := a.Lat*math.Pi/180, b.Lat*math.Pi/180
+	dLat := lat2 - lat1
+	dLon := (b.Lon - a.Lon) * math.Pi / 180
+	h := math.Sin(dLat/2)*math.Sin(dLat/2) + math.Cos(lat1)*math.Cos(lat2)*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * EarthRadius * math.Asin(math.Sqrt(min(h, 1)))
+}
+
+// PointType returns the type of struct point columns
+func PointType() arrow.DataType {
+	return arrow.StructOf(
+		arrow.Field{Name: "lat", Type: arrow.PrimitiveTypes.Float64, Nullable: true},
+		arrow.Field{Name: "lon", Type: arrow.PrimitiveTypes.Float64, Nullable: true},
+	)
+}
+
+// WKBField returns a binary field holding points in WKB
+func WKBField(name string, nullable bool) arrow.Field {
+	return arrow.Field{
+		Name:     name,
+		Type:     arrow.BinaryTypes.Binary,
+		Nullable: nullable,
+		Metadata: arrow.NewMetadata([]string{MetadataKey}, []string{"wkb"}),
+	}
+}
+
+// IsPointField reports whether a field holds points
+func IsPointField(f arrow.Field) bool {
+	if st, ok := f.Type.(*arrow.StructType); ok {
+		return st.NumFields() == 2 &&
+			st.Field(0).Name == "lat" && st.Field(0).Type.ID() == arrow.FLOAT64 &&
+			st.Field(1).Name == "lon" && st.Field(1).Type.ID() == arrow.FLOAT64
+	}
+	if f.Type.ID() == arrow.BINARY {
+		v, ok := f.Metadata.GetValue(MetadataKey)
+		return ok && v == "wkb"
+	}
+	return false
+}
+
+// Points returns a function reading the point at a row of a point column.
+// It reports false for nulls and for WKB values that are not points.
+func Points(f arrow.Field, col arrow.Array) (func(row int) (Point, bool), error) {
+	if !IsPointField(f) {
+		return nil, fmt.Errorf("column %s does not hold points", f.Name)
+	}
+	switch c := col.(type) {
+	case *array.Struct:
+		lat := c.Field(0).(*array.Float64)
+		lon := c.Field(1).(*array.Float64)
+		return func(row int) (Point, bool) {
+			if c.IsNull(row) || lat.IsNull(row) || lon.IsNull(row) {
+				return Point{}, false
+			}
+			return Point{Lat: lat.Value(row), Lon: lon.Value(row)}, true
+		}, nil
+	case *array.Binary:
+		return func(row int) (Point, bool) {
+			if c.IsNull(row) {
+				return Point{}, false
+			}
+			p, err := ParseWKB(c.Value(row))
+			return p, err == nil
+		}, nil
+	}
+	return nil, fmt.Errorf("column %s does not hold points", f.Name)
+}
+
+// Bounds returns the bounding box of the points of a column, or false for
+// columns that hold no points
+func Bounds(f arrow.Field, col arrow.Array) (BBox, bool) {
+	at, err := Points(f, col)
+	if err != nil {
+		return BBox{}, false
+	}
+	var b BBox
+	found := false
+	for i := 0; i < col.Len(); i++ {
+		p, ok := at(i)
+		if !ok {
+			continue
+		}
+		if !found {
+			b = BBox{MinLat: p.Lat, MinLon: p.Lon, MaxLat: p.Lat, MaxLon: p.Lon}
+			found = true
+			continue
+		}
+		b.MinLat, b.MaxLat = min(b.MinLat, p.Lat), max(b.MaxLat, p.Lat)
+		b.MinLon, b.MaxLon = min(b.MinLon, p.Lon), max(b.MaxLon, p.Lon)
+	}
+	return b, found
+}
+
+// wkbPoint is the WKB geometry type of points, and ewkbSRID the EWKB flag
+// of geometries followed by an SRID
+const (
+	wkbPoint = 1
+	ewkbSRID = 0x20000000
+)
+
+// ParseWKB decodes a WKB or EWKB point, whose X is the longitude
+func ParseWKB(b []byte) (Point, error) {
+	if len(b) < 5 {
+		return Point{}, fmt.Errorf("WKB too short")
+	}
+	var order binary.ByteOrder = binary.LittleEndian
+	if b[0] == 0 {
+		order = binary.BigEndian
+	}
+	typ := order.Uint32(b[1:5])
+	b = b[5:]
+	if typ&ewkbSRID != 0 {
+		if len(b) < 4 {
+			return Point{}, fmt.Errorf("WKB too short")
+		}
+		typ &^= ewkbSRID
+		b = b[4:]
+	}
+	if typ != wkbPoint {
+		return Point{}, fmt.Errorf("WKB geometry type %d is not a point", typ)
+	}
+	if len(b) < 16 {
+		return Point{}, fmt.Errorf("WKB too short")
+	}
+	return Point{
+		Lon: math.Float64frombits(order.Uint64(b[0:8])),
+		Lat: math.Float64frombits(order.Uint64(b[8:16])),
+	}, nil
+}
+
+// WKB encodes p as a little-endian WKB point
+func WKB(p Point) []byte {
+	b := make([]byte, 21)
+	b[0] = 1
+	binary.LittleEndian.PutUint32(b[1:5], wkbPoint)
+	binary.LittleEndian.PutUint64(b[5:13], math.Float64bits(p.Lon))
+	binary.LittleEndian.PutUint64(b[13:21], math.Float64bits(p.Lat))
+	return b
+}
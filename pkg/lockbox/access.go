@@ -0,0 +1,89 @@
+package lockbox
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/TFMV/lockbox/pkg/metadata"
+)
+
+// AccessGrant describes an access key: a password of its own that decrypts
+// only some of the columns
+type AccessGrant struct {
+	Name      string    `json:"name"`
+	Columns   []string  `json:"columns"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// GrantAccess lets password decrypt the given columns and no others, e.g. a
+// "public" password for analysts that leaves ssn and email opaque. The
+// password given with WithPassword must unlock the whole file. Opening the
+// lockbox with the access password works for reads: SELECT * returns the
+// granted columns and naming another column fails with
+// format.ErrNoColumnKey. A grant with the same name is replaced. Granted
+// columns still at key epoch 0 share the file's secret with every other
+// column, so they are rotated to keys of their own first, as
+// ExportColumnKey does.
+func (lb *Lockbox) GrantAccess(name, password string, columns []string, opts ...Option) error {
+	options := &Options{CreatedBy: "system"}
+	for _, opt := range opts {
+		opt(options)
+	}
+	if options.Password == "" {
+		return fmt.Errorf("password is required for granting access")
+	}
+
+	masterKey, err := lb.file.MasterKey(options.Password)
+	if err != nil {
+		return err
+	}
+	// Access passwords are only tried once the master password is rejected,
+	// which needs a wrapped master key
+	if len(lb.file.Metadata().Encryption.WrappedMasterKey) == 0 {
+		if err := lb.file.SetPassword(masterKey, options.Password); err != nil {
+			return err
+		}
+	}
+	if err := lb.ownColumnKeys(options, columns...); err != nil {
+		return err
+	}
+	if err := lb.file.GrantAccess(masterKey, name, password, columns); err != nil {
+		return err
+	}
+
+	lb.file.Metadata().LogAccess(options.CreatedBy, "grant-access", name, true, strings.Join(columns, ","))
+	return lb.file.SaveMetadata()
+}
+
+// RevokeAccess removes an access key. Rotate the keys of its columns as
+// well when their holders must lose access to data already in the file.
+func (lb *Lockbox) RevokeAccess(name string, opts ...Option) error {
+	options := &Options{CreatedBy: "system"}
+	for _, opt := range opts {
+		opt(options)
+	}
+	if err := lb.file.RevokeAccess(name); err != nil {
+		return err
+	}
+
+	lb.file.Metadata().LogAccess(options.CreatedBy, "revoke-access", name, true, "")
+	return lb.file.SaveMetadata()
+}
+
+// AccessGrants lists the access keys of the file
+func (lb *Lockbox) AccessGrants() []AccessGrant {
+	return accessGrants(lb.file.Metadata())
+}
+
+func accessGrants(meta *metadata.Metadata) []AccessGrant {
+	var grants []AccessGrant
+	for _, ak := range meta.Encryption.AccessKeys {
+		grants = append(grants, AccessGrant{
+			Name:      ak.Name,
+			Columns:   append([]string(nil), ak.Columns...),
+			CreatedAt: ak.CreatedAt,
+		})
+	}
+	return grants
+}
@@ -0,0 +1,358 @@
+package lockbox
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/TFMV/lockbox/pkg/crypto"
+	"github.com/TFMV/lockbox/pkg/format"
+	"github.com/rs/zerolog/log"
+)
+
+// AgentSocketEnv names the environment variable holding the agent's socket
+const AgentSocketEnv = "LOCKBOX_AGENT_SOCK"
+
+// DefaultAgentTTL is how long the agent keeps a file unlocked by default
+const DefaultAgentTTL = 15 * time.Minute
+
+// ErrAgentUnavailable is returned when no agent listens on the socket
+var ErrAgentUnavailable = errors.New("lockbox agent is not running")
+
+// DefaultAgentSocket returns the socket from LOCKBOX_AGENT_SOCK, or a
+// per-user path under XDG_RUNTIME_DIR or the temporary directory. Whichever
+// it is, its directory must be private to the user, see ListenAgent.
+func DefaultAgentSocket() string {
+	if s := os.Getenv(AgentSocketEnv); s != "" {
+		return s
+	}
+	if dir := os.Getenv("XDG_RUNTIME_DIR"); dir != "" {
+		return filepath.Join(dir, "lockbox-agent.sock")
+	}
+	return filepath.Join(os.TempDir(), fmt.Sprintf("lockbox-%d", os.Getuid()), "agent.sock")
+}
+
+// Agent caches unlocked master keys per file for a limited time and hands
+// them to lockbox processes over a unix socket, like ssh-agent. Clients get
+// the master key, never the password, so they skip both the prompt and the
+// key derivation, and hold the key no longer than the agent does. The
+// socket lives in a directory only the user running the agent can enter,
+// which is its only protection; the agent and its clients both refuse a
+// directory that is not.
+type Agent struct {
+	ttl     time.Duration
+	mu      sync.Mutex
+	entries map[string]*agentEntry
+	now     func() time.Time
+}
+
+// agentEntry is an unlocked file. The password is checked and dropped
+// when the file is added.
+type agentEntry struct {
+	keyID   string
+	key     []byte
+	salt    []byte
+	expires time.Time
+}
+
+// AgentEntry describes a file the agent holds unlocked
+type AgentEntry struct {
+	Path    string    `json:"path"`
+	Expires time.Time `json:"expires"`
+}
+
+// agentRequest is one line sent to the agent
+type agentRequest struct {
+	Op       string        `json:"op"` // add, get, remove, list, clear
+	Path     string        `json:"path,omitempty"`
+	Password string        `json:"password,omitempty"`
+	TTL      time.Duration `json:"ttl,omitempty"`
+}
+
+// agentResponse is the agent's answer to a request
+type agentResponse struct {
+	Error   string       `json:"error,omitempty"`
+	Found   bool         `json:"found,omitempty"`
+	KeyID   string       `json:"keyId,omitempty"`
+	Key     []byte       `json:"key,omitempty"`
+	Salt    []byte       `json:"salt,omitempty"`
+	Expires time.Time    `json:"expires"`
+	Entries []AgentEntry `json:"entries,omitempty"`
+}
+
+// NewAgent returns an agent that keeps files unlocked for ttl unless a
+// client asks for another duration
+func NewAgent(ttl time.Duration) *Agent {
+	if ttl <= 0 {
+		ttl = DefaultAgentTTL
+	}
+	return &Agent{ttl: ttl, entries: map[string]*agentEntry{}, now: time.Now}
+}
+
+// ListenAgent creates the agent socket, readable only by the current user.
+// The socket's directory is created if missing and must be owned by the
+// current user with mode 0700, so that nobody else can reach the socket,
+// even before its mode is set, or put one of their own in its place. A
+// stale socket left by an agent that is no longer running is replaced.
+func ListenAgent(socket string) (net.Listener, error) {
+	dir := filepath.Dir(socket)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create socket directory: %w", err)
+	}
+	if err := checkPrivateDir(dir); err != nil {
+		return nil, fmt.Errorf("unsafe socket directory: %w", err)
+	}
+	if _, err := os.Stat(socket); err == nil {
+		if c, err := net.Dial("unix", socket); err == nil {
+			c.Close()
+			return nil, fmt.Errorf("an agent is already listening on %s", socket)
+		}
+		os.Remove(socket)
+	}
+	l, err := net.Listen("unix", socket)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on %s: %w", socket, err)
+	}
+	if err := os.Chmod(socket, 0600); err != nil {
+		l.Close()
+		return nil, fmt.Errorf("failed to restrict socket: %w", err)
+	}
+	return l, nil
+}
+
+// Serve answers clients until the listener is closed
+func (a *Agent) Serve(l net.Listener) error {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+		go a.serveConn(conn)
+	}
+}
+
+// Clear forgets every unlocked file
+func (a *Agent) Clear() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for path, e := range a.entries {
+		a.drop(path, e)
+	}
+}
+
+func (a *Agent) serveConn(conn net.Conn) {
+	defer conn.Close()
+	scanner := bufio.NewScanner(conn)
+	enc := json.NewEncoder(conn)
+	for scanner.Scan() {
+		var req agentRequest
+		var resp *agentResponse
+		if err := json.Unmarshal(scanner.Bytes(), &req); err != nil {
+			resp = &agentResponse{Error: fmt.Sprintf("invalid request: %v", err)}
+		} else {
+			resp = a.handle(req)
+		}
+		if err := enc.Encode(resp); err != nil {
+			return
+		}
+	}
+}
+
+// handle answers one request
+func (a *Agent) handle(req agentRequest) *agentResponse {
+	if req.Op == "add" {
+		// Unlock outside the lock; the key derivation is slow
+		e, err := unlockForAgent(req.Path, req.Password)
+		if err != nil {
+			return &agentResponse{Error: err.Error()}
+		}
+		ttl := req.TTL
+		if ttl <= 0 {
+			ttl = a.ttl
+		}
+		e.expires = a.now().Add(ttl)
+		a.mu.Lock()
+		if old, ok := a.entries[req.Path]; ok {
+			a.drop(req.Path, old)
+		}
+		a.entries[req.Path] = e
+		a.mu.Unlock()
+		log.Info().Str("file", req.Path).Dur("ttl", ttl).Msg("Agent unlocked lockbox")
+		return &agentResponse{}
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.expire()
+	switch req.Op {
+	case "get":
+		e, ok := a.entries[req.Path]
+		if !ok {
+			return &agentResponse{}
+		}
+		return &agentResponse{Found: true, KeyID: e.keyID, Key: e.key, Salt: e.salt, Expires: e.expires}
+	case "remove":
+		if e, ok := a.entries[req.Path]; ok {
+			a.drop(req.Path, e)
+		}
+		return &agentResponse{}
+	case "list":
+		resp := &agentResponse{}
+		for path, e := range a.entries {
+			resp.Entries = append(resp.Entries, AgentEntry{Path: path, Expires: e.expires})
+		}
+		sort.Slice(resp.Entries, func(i, j int) bool { return resp.Entries[i].Path < resp.Entries[j].Path })
+		return resp
+	case "clear":
+		for path, e := range a.entries {
+			a.drop(path, e)
+		}
+		return &agentResponse{}
+	default:
+		return &agentResponse{Error: fmt.Sprintf("unknown operation %q", req.Op)}
+	}
+}
+
+// expire drops the entries whose time is up; the caller holds a.mu
+func (a *Agent) expire() {
+	now := a.now()
+	for path, e := range a.entries {
+		if !now.Before(e.expires) {
+			a.drop(path, e)
+		}
+	}
+}
+
+// drop removes an entry and wipes its key; the caller holds a.mu
+func (a *Agent) drop(path string, e *agentEntry) {
+	for i := range e.key {
+		e.key[i] = 0
+	}
+	delete(a.entries, path)
+}
+
+// unlockForAgent checks password against a file and derives its master key
+func unlockForAgent(path, password string) (*agentEntry, error) {
+	if password == "" {
+		return nil, fmt.Errorf("password is required")
+	}
+	file, err := format.Open(path, password, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unlock %s: %w", path, err)
+	}
+	defer file.Close()
+	key, err := file.MasterKey(password)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unlock %s: %w", path, err)
+	}
+	return &agentEntry{
+		keyID: file.KeyID(password),
+		key:   append([]byte{}, key.Data...),
+		salt:  key.Salt,
+	}, nil
+}
+
+// AgentClient talks to a running agent
+type AgentClient struct {
+	socket string
+}
+
+// NewAgentClient returns a client for the agent on socket; the empty string
+// selects DefaultAgentSocket
+func NewAgentClient(socket string) *AgentClient {
+	if socket == "" {
+		socket = DefaultAgentSocket()
+	}
+	return &AgentClient{socket: socket}
+}
+
+// call sends one request and reads the response. Requests carry passwords
+// and responses master keys, so the socket's directory must be private to
+// the current user, as the agent requires too; otherwise the socket may
+// belong to someone else.
+func (c *AgentClient) call(req agentRequest) (*agentResponse, error) {
+	conn, err := net.DialTimeout("unix", c.socket, time.Second)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrAgentUnavailable, err)
+	}
+	defer conn.Close()
+	if err := checkPrivateDir(filepath.Dir(c.socket)); err != nil {
+		return nil, fmt.Errorf("unsafe agent socket directory: %w", err)
+	}
+	if err := json.NewEncoder(conn).Encode(req); err != nil {
+		return nil, fmt.Errorf("failed to send request to agent: %w", err)
+	}
+	var resp agentResponse
+	if err := json.NewDecoder(conn).Decode(&resp); err != nil {
+		return nil, fmt.Errorf("failed to read agent response: %w", err)
+	}
+	if resp.Error != "" {
+		return nil, errors.New(resp.Error)
+	}
+	return &resp, nil
+}
+
+// Add has the agent unlock a file with password and keep it unlocked for
+// ttl; zero uses the agent's default
+func (c *AgentClient) Add(path, password string, ttl time.Duration) error {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+	_, err = c.call(agentRequest{Op: "add", Path: abs, Password: password, TTL: ttl})
+	return err
+}
+
+// Unlock makes the master key of a file the agent holds unlocked available
+// to this process until the agent's entry expires, and returns a stand-in
+// for the password that opens the file with it, see
+// format.RememberedPassword. Opening the file with the real password skips
+// the key derivation too. ok is false when the agent does not hold the
+// file.
+func (c *AgentClient) Unlock(path string) (password string, ok bool, err error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return "", false, err
+	}
+	resp, err := c.call(agentRequest{Op: "get", Path: abs})
+	if err != nil || !resp.Found {
+		return "", false, err
+	}
+	format.RememberMasterKey(resp.KeyID, crypto.KeyFromMaster(resp.Key, resp.Salt), resp.Expires)
+	return format.RememberedPassword(resp.KeyID), true, nil
+}
+
+// Remove makes the agent forget a file
+func (c *AgentClient) Remove(path string) error {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+	_, err = c.call(agentRequest{Op: "remove", Path: abs})
+	return err
+}
+
+// List returns the files the agent holds unlocked
+func (c *AgentClient) List() ([]AgentEntry, error) {
+	resp, err := c.call(agentRequest{Op: "list"})
+	if err != nil {
+		return nil, err
+	}
+	return resp.Entries, nil
+}
+
+// Clear makes the agent forget every file
+func (c *AgentClient) Clear() error {
+	_, err := c.call(agentRequest{Op: "clear"})
+	return err
+}
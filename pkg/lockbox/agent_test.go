@@ -0,0 +1,109 @@
+package lockbox
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/TFMV/lockbox/pkg/format"
+)
+
+func TestAgent(t *testing.T) {
+	dir, err := os.MkdirTemp("", "lbagent")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	socket := filepath.Join(dir, "agent.sock")
+
+	client := NewAgentClient(socket)
+	if _, _, err := client.Unlock("missing.lbx"); !errors.Is(err, ErrAgentUnavailable) {
+		t.Fatalf("expected ErrAgentUnavailable, got %v", err)
+	}
+
+	l, err := ListenAgent(socket)
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	agent := NewAgent(time.Minute)
+	now := time.Now()
+	agent.now = func() time.Time { return now }
+	go agent.Serve(l)
+	defer l.Close()
+	if fi, err := os.Stat(socket); err != nil || fi.Mode().Perm() != 0600 {
+		t.Fatalf("expected a private socket, got %v %v", fi, err)
+	}
+	if _, err := ListenAgent(socket); err == nil {
+		t.Fatal("expected a second agent on the socket to fail")
+	}
+
+	path := filepath.Join(dir, "agent.lbx")
+	password := "test_password_123"
+	lb := newQueryTestLockbox(t, path, password)
+	lb.Close()
+
+	if err := client.Add(path, password, 0); err != nil {
+		t.Fatalf("add: %v", err)
+	}
+	entries, err := client.List()
+	if err != nil || len(entries) != 1 || !entries[0].Expires.Equal(now.Add(time.Minute)) {
+		t.Fatalf("unexpected entries %v, %v", entries, err)
+	}
+
+	// The agent hands out the master key, never the password
+	resp, err := client.call(agentRequest{Op: "get", Path: path})
+	if err != nil || !resp.Found || bytes.Contains([]byte(fmt.Sprint(resp)), []byte(password)) {
+		t.Fatalf("expected the key without the password, got %+v %v", resp, err)
+	}
+	got, ok, err := client.Unlock(path)
+	if err != nil || !ok || got == password {
+		t.Fatalf("expected a stand-in password from the agent, got %q %v %v", got, ok, err)
+	}
+	lb, err = Open(path, WithPassword(got))
+	if err != nil {
+		t.Fatalf("open with agent key: %v", err)
+	}
+	defer lb.Close()
+	defer format.ForgetMasterKey(lb.file.KeyID(password))
+	res, err := lb.Query(context.Background(), "SELECT name FROM data WHERE id = 2", WithPassword(got))
+	if err != nil {
+		t.Fatalf("query with agent key: %v", err)
+	}
+	res.Release()
+	if err := lb.ChangePassword(got, WithPassword(got)); err == nil {
+		t.Error("expected the stand-in password to be refused as a new password")
+	}
+	if err := lb.file.SetPassword(nil, got); err == nil {
+		t.Error("expected the stand-in password to be refused for a new key")
+	}
+	other := filepath.Join(dir, "other.lbx")
+	newQueryTestLockbox(t, other, password).Close()
+	if _, err := Open(other, WithPassword(got)); err == nil {
+		t.Error("expected the agent key of one file not to open another")
+	}
+
+	// Entries expire after their TTL, in the agent and in this process
+	now = now.Add(2 * time.Minute)
+	if _, ok, err := client.Unlock(path); ok || err != nil {
+		t.Fatalf("expected the entry to expire, got %v %v", ok, err)
+	}
+	format.RememberMasterKey("expired", nil, time.Now().Add(-time.Second))
+	if _, err := format.Open(path, format.RememberedPassword("expired"), nil); err == nil {
+		t.Error("expected an expired key not to unlock the file")
+	}
+
+	if err := client.Add(path, password, time.Hour); err != nil {
+		t.Fatalf("add: %v", err)
+	}
+	if err := client.Clear(); err != nil {
+		t.Fatalf("clear: %v", err)
+	}
+	if entries, _ := client.List(); len(entries) != 0 {
+		t.Fatalf("expected no entries after clear, got %v", entries)
+	}
+}
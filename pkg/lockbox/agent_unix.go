@@ -0,0 +1,32 @@
+//go:build linux || darwin || freebsd || netbsd || openbsd
+
+package lockbox
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// checkPrivateDir ensures dir is a directory, not a link to one, owned by
+// the current user and closed to everyone else
+func checkPrivateDir(dir string) error {
+	fi, err := os.Lstat(dir)
+	if err != nil {
+		return err
+	}
+	if !fi.IsDir() {
+		return fmt.Errorf("%s is not a directory", dir)
+	}
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok {
+		return fmt.Errorf("cannot tell the owner of %s", dir)
+	}
+	if int(st.Uid) != os.Getuid() {
+		return fmt.Errorf("%s is owned by uid %d, not %d", dir, st.Uid, os.Getuid())
+	}
+	if perm := fi.Mode().Perm(); perm&0077 != 0 {
+		return fmt.Errorf("%s has mode %04o, want 0700", dir, perm)
+	}
+	return nil
+}
@@ -0,0 +1,78 @@
+//go:build linux || darwin || freebsd || netbsd || openbsd
+
+package lockbox
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAgentSocketDir(t *testing.T) {
+	dir := t.TempDir()
+
+	// A directory others can enter is refused, even if it already exists
+	shared := filepath.Join(dir, "shared")
+	if err := os.Mkdir(shared, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(shared, 0755); err != nil {
+		t.Fatal(err)
+	}
+	socket := filepath.Join(shared, "agent.sock")
+	if _, err := ListenAgent(socket); err == nil || !strings.Contains(err.Error(), "unsafe socket directory") {
+		t.Fatalf("expected a shared directory to be refused, got %v", err)
+	}
+
+	// So is a link to a private one
+	private := filepath.Join(dir, "private")
+	if err := os.Mkdir(private, 0700); err != nil {
+		t.Fatal(err)
+	}
+	link := filepath.Join(dir, "link")
+	if err := os.Symlink(private, link); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ListenAgent(filepath.Join(link, "agent.sock")); err == nil {
+		t.Fatal("expected a linked directory to be refused")
+	}
+
+	// Clients send nothing to a socket in a shared directory
+	l, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	received := make(chan int, 1)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		buf := make([]byte, 64)
+		n, _ := conn.Read(buf)
+		received <- n
+	}()
+	client := NewAgentClient(socket)
+	if err := client.Add(filepath.Join(dir, "data.lbx"), "secret", 0); err == nil || !strings.Contains(err.Error(), "unsafe agent socket directory") {
+		t.Fatalf("expected the client to refuse the socket, got %v", err)
+	}
+	if n := <-received; n != 0 {
+		t.Fatalf("expected no request to reach the socket, got %d bytes", n)
+	}
+
+	// The default directory is created private
+	l, err = ListenAgent(filepath.Join(dir, "fresh", "agent.sock"))
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	l.Close()
+	if fi, err := os.Stat(filepath.Join(dir, "fresh")); err != nil || fi.Mode().Perm() != 0700 {
+		t.Fatalf("expected a private socket directory, got %v %v", fi, err)
+	}
+}
@@ -0,0 +1,95 @@
+package lockbox
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/apache/arrow-go/v18/arrow/memory"
+	"github.com/rs/zerolog/log"
+)
+
+// ErrUnreleasedMemory is returned by Close in debug allocator mode when
+// Arrow buffers allocated for the lockbox were not released
+var ErrUnreleasedMemory = errors.New("arrow buffers were not released")
+
+// debugAllocatorByDefault turns on the debug allocator for every lockbox,
+// as if WithDebugAllocator were given. It is set by the environment
+// variable LOCKBOX_DEBUG_ALLOCATOR.
+var debugAllocatorByDefault = os.Getenv("LOCKBOX_DEBUG_ALLOCATOR") != ""
+
+// reportLeak is called by Close with the allocation sites of unreleased
+// buffers
+var reportLeak = func(path, report string) {
+	log.Error().Str("file", path).Msg("Unreleased Arrow buffers:\n" + report)
+}
+
+// WithAllocator sets the allocator for the Arrow buffers of records the
+// lockbox reads, queries and loads
+func WithAllocator(mem memory.Allocator) Option {
+	return func(o *Options) {
+		o.Allocator = mem
+	}
+}
+
+// WithDebugAllocator tracks every Arrow buffer allocated for the lockbox
+// with a checked allocator. Close logs where each buffer that was not
+// released was allocated and returns ErrUnreleasedMemory, so records
+// returned by the lockbox must be released before it is closed.
+func WithDebugAllocator() Option {
+	return func(o *Options) {
+		o.DebugAllocator = true
+	}
+}
+
+// newAllocator returns the allocator chosen by the options
+func newAllocator(options *Options) memory.Allocator {
+	mem := options.Allocator
+	if mem == nil {
+		mem = memory.DefaultAllocator
+	}
+	if options.DebugAllocator || debugAllocatorByDefault {
+		return memory.NewCheckedAllocator(mem)
+	}
+	return mem
+}
+
+// loadAllocator returns the allocator for records built by LoadCSV and
+// LoadJSON, which have no lockbox to take one from
+func loadAllocator(options *Options) memory.Allocator {
+	if options.Allocator != nil {
+		return options.Allocator
+	}
+	return memory.DefaultAllocator
+}
+
+// Allocator returns the allocator of the lockbox's Arrow buffers. Records
+// built with it are tracked by WithDebugAllocator too.
+func (lb *Lockbox) Allocator() memory.Allocator {
+	return lb.file.Allocator()
+}
+
+// checkReleased reports the buffers of a debug allocator that are still
+// allocated
+func (lb *Lockbox) checkReleased() error {
+	checked, ok := lb.file.Allocator().(*memory.CheckedAllocator)
+	if !ok || checked.CurrentAlloc() == 0 {
+		return nil
+	}
+	var report leakReport
+	checked.AssertSize(&report, 0)
+	reportLeak(lb.Path(), report.String())
+	return fmt.Errorf("%w: %d bytes allocated for %s", ErrUnreleasedMemory, checked.CurrentAlloc(), lb.Path())
+}
+
+// leakReport collects the messages of CheckedAllocator.AssertSize
+type leakReport struct {
+	strings.Builder
+}
+
+func (r *leakReport) Helper() {}
+
+func (r *leakReport) Errorf(format string, args ...interface{}) {
+	fmt.Fprintf(&r.Builder, format+"\n", args...)
+}
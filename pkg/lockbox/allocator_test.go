@@ -0,0 +1,58 @@
+package lockbox
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/apache/arrow-go/v18/arrow"
+	"github.com/apache/arrow-go/v18/arrow/array"
+)
+
+func TestDebugAllocator(t *testing.T) {
+	var reports []string
+	saved := reportLeak
+	reportLeak = func(path, report string) { reports = append(reports, report) }
+	defer func() { reportLeak = saved }()
+
+	schema := arrow.NewSchema([]arrow.Field{
+		{Name: "id", Type: arrow.PrimitiveTypes.Int64},
+	}, nil)
+	path := filepath.Join(t.TempDir(), "debug.lbx")
+	password := "test_password_123"
+	lb, err := Create(path, schema, WithPassword(password), WithCreatedBy("test"), WithDebugAllocator())
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	b := array.NewRecordBuilder(lb.Allocator(), schema)
+	b.Field(0).(*array.Int64Builder).AppendValues([]int64{1, 2, 3}, nil)
+	rec := b.NewRecord()
+	b.Release()
+
+	ctx := context.Background()
+	if err := lb.Write(ctx, rec, WithPassword(password)); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	rec.Release()
+	if err := lb.Close(); err != nil {
+		t.Fatalf("close after releasing everything: %v", err)
+	}
+
+	lb, err = Open(path, WithPassword(password), WithDebugAllocator())
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	result, err := lb.Query(ctx, "SELECT id FROM data WHERE id > 1", WithPassword(password))
+	if err != nil {
+		t.Fatalf("query: %v", err)
+	}
+	if err := lb.Close(); !errors.Is(err, ErrUnreleasedMemory) {
+		t.Fatalf("expected ErrUnreleasedMemory, got %v", err)
+	}
+	if len(reports) != 1 || !strings.Contains(reports[0], "LEAK") {
+		t.Fatalf("expected one leak report, got %q", reports)
+	}
+	result.Release()
+}
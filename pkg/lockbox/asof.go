@@ -0,0 +1,122 @@
+package lockbox
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/TFMV/lockbox/pkg/metadata"
+)
+
+// asOf is a parsed AS OF clause: the snapshot a SELECT reads, given by ID
+// or, when Time is set, as the last one committed by then
+type asOf struct {
+	Snapshot int64
+	Time     time.Time
+}
+
+// WithAsOfSnapshot makes Query and scans read the rows committed up to and
+// including the snapshot with the given ID, as if every SELECT without its
+// own AS OF clause had "AS OF SNAPSHOT id"
+func WithAsOfSnapshot(id int64) Option {
+	return func(o *Options) {
+		o.AsOf = &asOf{Snapshot: id}
+	}
+}
+
+// WithAsOfTime makes Query and scans read the rows of the last snapshot
+// committed at or before t, as if every SELECT without its own AS OF
+// clause had "AS OF 't'"
+func WithAsOfTime(t time.Time) Option {
+	return func(o *Options) {
+		o.AsOf = &asOf{Time: t}
+	}
+}
+
+// parseAsOf parses the clause following the AS OF keywords:
+//
+//	SNAPSHOT n | 'time'
+//
+// Times are RFC 3339 timestamps, or dates standing for midnight UTC
+func (p *queryParser) parseAsOf() (*asOf, error) {
+	if p.acceptKeyword("SNAPSHOT") {
+		tok, err := p.expect(tokNumber, "snapshot ID after AS OF SNAPSHOT")
+		if err != nil {
+			return nil, err
+		}
+		id, err := strconv.ParseInt(tok.Text, 10, 64)
+		if err != nil || id < 0 {
+			return nil, fmt.Errorf("invalid snapshot ID %q", tok.Text)
+		}
+		return &asOf{Snapshot: id}, nil
+	}
+	tok, err := p.expect(tokString, "SNAPSHOT or a time after AS OF")
+	if err != nil {
+		return nil, err
+	}
+	t, err := ParseAsOfTime(tok.Text)
+	if err != nil {
+		return nil, err
+	}
+	return &asOf{Time: t}, nil
+}
+
+// ParseAsOfTime parses the time of an AS OF clause: an RFC 3339 timestamp,
+// or a date such as 2006-01-02, which stands for midnight UTC
+func ParseAsOfTime(v string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, v); err == nil {
+		return t, nil
+	}
+	if t, err := time.Parse(time.DateOnly, v); err == nil {
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("invalid AS OF time %q: expected RFC 3339 or YYYY-MM-DD", v)
+}
+
+// rowGroups returns the number of row groups committed as of a
+func (a *asOf) rowGroups(meta *metadata.Metadata) (int, error) {
+	id := a.Snapshot
+	if !a.Time.IsZero() {
+		s, ok := meta.SnapshotAt(a.Time)
+		if !ok {
+			return 0, fmt.Errorf("no snapshot was committed by %s", a.Time.Format(time.RFC3339))
+		}
+		id = s.ID
+	}
+	return meta.RowGroupsAt(id)
+}
+
+// at returns qe reading as of a, or qe itself when a is nil. Row groups
+// are read as they are now, so deletes and rewrites committed after the
+// snapshot show through, as with ExportSnapshot.
+func (qe *queryExec) at(a *asOf) (*queryExec, error) {
+	if a == nil {
+		return qe, nil
+	}
+	n, err := a.rowGroups(qe.meta)
+	if err != nil {
+		return nil, err
+	}
+	at := *qe
+	at.until = &n
+	return &at, nil
+}
+
+// end returns the row group reads stop before: the first one committed
+// after the AS OF snapshot, or the number of row groups
+func (qe *queryExec) end() int {
+	if qe.until != nil {
+		return max(*qe.until, qe.first)
+	}
+	return qe.meta.NumRowGroups()
+}
+
+// rowGroups lists the row groups a SELECT reads, after WithSinceSnapshot
+// and up to its AS OF snapshot
+func (qe *queryExec) rowGroups() []int {
+	var groups []int
+	for n := qe.first; n < qe.end(); n++ {
+		groups = append(groups, n)
+	}
+	return groups
+}
@@ -0,0 +1,158 @@
+package lockbox
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"path"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// BatchResult is the outcome of an operation on one file of a batch
+type BatchResult struct {
+	File     string        `json:"file"`
+	Result   any           `json:"result,omitempty"`
+	Error    string        `json:"error,omitempty"`
+	Duration time.Duration `json:"duration"`
+}
+
+// GlobFiles returns the files matching the patterns, sorted and without
+// duplicates. Besides the wildcards of filepath.Match, a "**" path element
+// matches any number of directories, so "data/**/*.lbx" finds lockboxes at
+// any depth below data.
+func GlobFiles(patterns ...string) ([]string, error) {
+	seen := make(map[string]bool)
+	var files []string
+	for _, pattern := range patterns {
+		matches, err := globFiles(pattern)
+		if err != nil {
+			return nil, err
+		}
+		for _, m := range matches {
+			if !seen[m] {
+				seen[m] = true
+				files = append(files, m)
+			}
+		}
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+func globFiles(pattern string) ([]string, error) {
+	elems := strings.Split(filepath.ToSlash(pattern), "/")
+	if !contains(elems, "**") {
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern %q: %w", pattern, err)
+		}
+		return matches, nil
+	}
+	for _, e := range elems {
+		if _, err := path.Match(e, ""); err != nil {
+			return nil, fmt.Errorf("invalid pattern %q: %w", pattern, err)
+		}
+	}
+
+	// Walk from the longest leading part of the pattern without wildcards
+	n := 0
+	for n < len(elems) && !strings.ContainsAny(elems[n], `*?[\`) {
+		n++
+	}
+	root := strings.Join(elems[:n], "/")
+	switch {
+	case n == 0:
+		root = "."
+	case root == "":
+		root = "/"
+	}
+	root = filepath.FromSlash(root)
+
+	var matches []string
+	err := filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(root, p)
+		if err != nil {
+			return err
+		}
+		if matchElems(elems[n:], strings.Split(filepath.ToSlash(rel), "/")) {
+			matches = append(matches, p)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan %s: %w", root, err)
+	}
+	return matches, nil
+}
+
+// matchElems matches path elements against pattern elements, where "**"
+// matches zero or more elements
+func matchElems(pattern, name []string) bool {
+	if len(pattern) == 0 {
+		return len(name) == 0
+	}
+	if pattern[0] == "**" {
+		return matchElems(pattern[1:], name) || (len(name) > 0 && matchElems(pattern, name[1:]))
+	}
+	if len(name) == 0 {
+		return false
+	}
+	ok, _ := path.Match(pattern[0], name[0])
+	return ok && matchElems(pattern[1:], name[1:])
+}
+
+// ForEachFile runs fn for every file on a pool of jobs workers, or one per
+// CPU when jobs is not positive. Results are returned in the order of files.
+// A failure for one file does not stop the others; once ctx is cancelled
+// the files not yet started fail with its error.
+func ForEachFile(ctx context.Context, files []string, jobs int, fn func(ctx context.Context, path string) (any, error)) []BatchResult {
+	if jobs <= 0 {
+		jobs = runtime.NumCPU()
+	}
+	results := make([]BatchResult, len(files))
+	next := make(chan int)
+
+	var wg sync.WaitGroup
+	for w := 0; w < jobs && w < len(files); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				results[i] = runBatchItem(ctx, files[i], fn)
+			}
+		}()
+	}
+	for i := range files {
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+	return results
+}
+
+func runBatchItem(ctx context.Context, file string, fn func(ctx context.Context, path string) (any, error)) BatchResult {
+	res := BatchResult{File: file}
+	if err := ctx.Err(); err != nil {
+		res.Error = err.Error()
+		return res
+	}
+	start := time.Now()
+	out, err := fn(ctx, file)
+	res.Duration = time.Since(start)
+	res.Result = out
+	if err != nil {
+		res.Error = err.Error()
+	}
+	return res
+}
@@ -0,0 +1,108 @@
+package lockbox
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestGlobFiles(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"a.lbx", "b.txt", "x/c.lbx", "x/y/d.lbx", "x/y/e.txt"} {
+		p := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, nil, 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	rel := func(files []string) string {
+		out := make([]string, len(files))
+		for i, f := range files {
+			r, _ := filepath.Rel(dir, f)
+			out[i] = filepath.ToSlash(r)
+		}
+		return strings.Join(out, ",")
+	}
+
+	cases := map[string]string{
+		"**/*.lbx":   "a.lbx,x/c.lbx,x/y/d.lbx",
+		"x/**/*.lbx": "x/c.lbx,x/y/d.lbx",
+		"**/y/*":     "x/y/d.lbx,x/y/e.txt",
+		"*.lbx":      "a.lbx",
+	}
+	for pattern, want := range cases {
+		files, err := GlobFiles(filepath.Join(dir, pattern))
+		if err != nil {
+			t.Fatalf("%s: %v", pattern, err)
+		}
+		if got := rel(files); got != want {
+			t.Errorf("%s: expected %s, got %s", pattern, want, got)
+		}
+	}
+
+	// Overlapping patterns list each file once
+	files, err := GlobFiles(filepath.Join(dir, "*.lbx"), filepath.Join(dir, "**", "*.lbx"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := rel(files); got != "a.lbx,x/c.lbx,x/y/d.lbx" {
+		t.Errorf("expected each file once, got %s", got)
+	}
+
+	if _, err := GlobFiles(filepath.Join(dir, "**", "[")); err == nil {
+		t.Errorf("expected an error for a malformed pattern")
+	}
+}
+
+func TestForEachFile(t *testing.T) {
+	files := []string{"a", "b", "c", "d", "e"}
+	var running, peak atomic.Int32
+	results := ForEachFile(context.Background(), files, 2, func(ctx context.Context, path string) (any, error) {
+		n := running.Add(1)
+		defer running.Add(-1)
+		for {
+			p := peak.Load()
+			if n <= p || peak.CompareAndSwap(p, n) {
+				break
+			}
+		}
+		if path == "c" {
+			return nil, fmt.Errorf("broken")
+		}
+		return strings.ToUpper(path), nil
+	})
+
+	if peak.Load() > 2 {
+		t.Errorf("expected at most 2 workers, saw %d", peak.Load())
+	}
+	for i, r := range results {
+		if r.File != files[i] {
+			t.Fatalf("result %d: expected file %s, got %s", i, files[i], r.File)
+		}
+		if r.File == "c" {
+			if r.Error != "broken" {
+				t.Errorf("expected an error for c, got %+v", r)
+			}
+			continue
+		}
+		if r.Error != "" || r.Result != strings.ToUpper(r.File) {
+			t.Errorf("unexpected result for %s: %+v", r.File, r)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	for _, r := range ForEachFile(ctx, files, 0, func(ctx context.Context, path string) (any, error) {
+		return path, nil
+	}) {
+		if r.Error == "" {
+			t.Errorf("expected %s to fail after cancellation", r.File)
+		}
+	}
+}
@@ -0,0 +1,316 @@
+package lockbox
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Catalog is an index of the cleartext metadata of many lockbox files. It
+// holds no data and no key material, so it can be shared with people who
+// cannot open the files.
+type Catalog struct {
+	Root      string         `json:"root"`
+	IndexedAt time.Time      `json:"indexedAt"`
+	Entries   []CatalogEntry `json:"entries"`
+	Skipped   []CatalogError `json:"skipped,omitempty"`
+}
+
+// CatalogEntry is the indexed metadata of one lockbox file
+type CatalogEntry struct {
+	Path        string          `json:"path"`
+	Version     uint32          `json:"version"`
+	Columns     []CatalogColumn `json:"columns,omitempty"`
+	Tags        []string        `json:"tags,omitempty"`
+	Description string          `json:"description,omitempty"`
+	CreatedAt   time.Time       `json:"createdAt"`
+	CreatedBy   string          `json:"createdBy"`
+	ModifiedAt  time.Time       `json:"modifiedAt"`
+	ModifiedBy  string          `json:"modifiedBy"`
+	RowCount    int64           `json:"rowCount"`
+	BlockCount  int             `json:"blockCount"`
+	FileSize    int64           `json:"fileSize"`
+	Module      string          `json:"module"`
+	// Preview is the path of the plaintext preview sidecar, if any
+	Preview string `json:"preview,omitempty"`
+}
+
+// CatalogColumn is a column of an indexed lockbox
+type CatalogColumn struct {
+	Name string   `json:"name"`
+	Type string   `json:"type"`
+	Tags []string `json:"tags,omitempty"`
+}
+
+// CatalogError records a file that could not be indexed
+type CatalogError struct {
+	Path  string `json:"path"`
+	Error string `json:"error"`
+}
+
+// IndexCatalog scans dir and its subdirectories for .lbx files and indexes
+// their cleartext metadata with ReadInfo. No password is needed. Files that
+// cannot be read are listed in Skipped. The lockbox tags and the metadata
+// of the Arrow schema are indexed as file tags, field metadata as column
+// tags, all as "key=value".
+func IndexCatalog(ctx context.Context, dir string) (*Catalog, error) {
+	c := &Catalog{Root: dir, IndexedAt: time.Now()}
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if d.IsDir() || filepath.Ext(p) != ".lbx" {
+			return nil
+		}
+
+		entry, err := catalogEntry(p)
+		if err != nil {
+			log.Warn().Err(err).Str("file", p).Msg("Skipping lockbox")
+			c.Skipped = append(c.Skipped, CatalogError{Path: p, Error: err.Error()})
+			return nil
+		}
+		c.Entries = append(c.Entries, *entry)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan %s: %w", dir, err)
+	}
+	sort.Slice(c.Entries, func(i, j int) bool { return c.Entries[i].Path < c.Entries[j].Path })
+	return c, nil
+}
+
+func catalogEntry(p string) (*CatalogEntry, error) {
+	info, err := ReadInfo(p)
+	if err != nil {
+		return nil, err
+	}
+
+	entry := &CatalogEntry{
+		Path:        p,
+		Version:     info.Version,
+		Description: info.Description,
+		Tags:        tagList(info.Tags),
+		CreatedBy:   info.CreatedBy,
+		ModifiedBy:  info.ModifiedBy,
+		RowCount:    info.RowCount,
+		BlockCount:  info.BlockCount,
+		FileSize:    info.FileSize,
+		Module:      info.Module,
+	}
+	if _, err := os.Stat(PreviewPath(p)); err == nil {
+		entry.Preview = PreviewPath(p)
+	}
+	if t, ok := info.CreatedAt.(time.Time); ok {
+		entry.CreatedAt = t
+	}
+	if t, ok := info.ModifiedAt.(time.Time); ok {
+		entry.ModifiedAt = t
+	}
+	if info.Schema != nil {
+		md := info.Schema.Metadata()
+		entry.Tags = append(entry.Tags, metadataTags(md.Keys(), md.Values())...)
+		sort.Strings(entry.Tags)
+		for _, f := range info.Schema.Fields() {
+			entry.Columns = append(entry.Columns, CatalogColumn{
+				Name: f.Name,
+				Type: f.Type.String(),
+				Tags: metadataTags(f.Metadata.Keys(), f.Metadata.Values()),
+			})
+		}
+	}
+	return entry, nil
+}
+
+// metadataTags renders Arrow metadata as "key=value" tags, or just "key"
+// for empty values
+func metadataTags(keys, values []string) []string {
+	var tags []string
+	for i, k := range keys {
+		if values[i] == "" {
+			tags = append(tags, k)
+		} else {
+			tags = append(tags, k+"="+values[i])
+		}
+	}
+	sort.Strings(tags)
+	return tags
+}
+
+// tagList renders lockbox tags like metadataTags
+func tagList(tags map[string]string) []string {
+	keys := make([]string, 0, len(tags))
+	values := make([]string, 0, len(tags))
+	for k, v := range tags {
+		keys = append(keys, k)
+		values = append(values, v)
+	}
+	return metadataTags(keys, values)
+}
+
+// LoadCatalog reads a catalog written by Save
+func LoadCatalog(filename string) (*Catalog, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read catalog: %w", err)
+	}
+	var c Catalog
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, fmt.Errorf("failed to parse catalog: %w", err)
+	}
+	return &c, nil
+}
+
+// Save writes the catalog to filename as JSON
+func (c *Catalog) Save(filename string) error {
+	data, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode catalog: %w", err)
+	}
+	if err := os.WriteFile(filename, data, 0644); err != nil {
+		return fmt.Errorf("failed to write catalog: %w", err)
+	}
+	return nil
+}
+
+// Search returns the entries matching every term of query. Terms are
+// separated by spaces and matched case-insensitively; * and ? are wildcards,
+// and * also matches path separators:
+//
+//	column:email            a column named email
+//	type:string             a column of that Arrow type
+//	tag:pii                 a file or column tag with key pii, or equal to pii
+//	tag:env=prod            a file or column tag equal to env=prod
+//	creator:alice           created by alice
+//	path:*/hr/*             the file path
+//	description:*payroll*   the description
+//	email                   any of the above, or a word of the description
+func (c *Catalog) Search(query string) ([]CatalogEntry, error) {
+	var terms []catalogTerm
+	for _, field := range strings.Fields(query) {
+		term := catalogTerm{pattern: strings.ToLower(field)}
+		if k, v, ok := strings.Cut(field, ":"); ok {
+			switch strings.ToLower(k) {
+			case "column", "type", "tag", "creator", "path", "description":
+				term = catalogTerm{key: strings.ToLower(k), pattern: strings.ToLower(v)}
+			default:
+				return nil, fmt.Errorf("unknown search field %s", k)
+			}
+		}
+		terms = append(terms, term)
+	}
+
+	var matches []CatalogEntry
+	for _, e := range c.Entries {
+		ok := true
+		for _, t := range terms {
+			if !t.matches(&e) {
+				ok = false
+				break
+			}
+		}
+		if ok {
+			matches = append(matches, e)
+		}
+	}
+	return matches, nil
+}
+
+// catalogTerm is one term of a catalog search; an empty key matches any
+// field
+type catalogTerm struct {
+	key     string
+	pattern string
+}
+
+func (t catalogTerm) matches(e *CatalogEntry) bool {
+	anyField := t.key == ""
+	if (anyField || t.key == "path") && t.match(e.Path) {
+		return true
+	}
+	if (anyField || t.key == "creator") && t.match(e.CreatedBy) {
+		return true
+	}
+	if (anyField || t.key == "tag") && t.matchTags(e.Tags) {
+		return true
+	}
+	if t.key == "description" && t.match(e.Description) {
+		return true
+	}
+	if anyField {
+		for _, word := range strings.FieldsFunc(e.Description, isWordSeparator) {
+			if t.match(word) {
+				return true
+			}
+		}
+	}
+	for _, col := range e.Columns {
+		if (anyField || t.key == "column") && t.match(col.Name) {
+			return true
+		}
+		if (anyField || t.key == "type") && t.match(col.Type) {
+			return true
+		}
+		if (anyField || t.key == "tag") && t.matchTags(col.Tags) {
+			return true
+		}
+	}
+	return false
+}
+
+func isWordSeparator(r rune) bool {
+	return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+}
+
+func (t catalogTerm) match(s string) bool {
+	return wildcardMatch(t.pattern, strings.ToLower(s))
+}
+
+// wildcardMatch matches s against pattern, where * matches any run of
+// characters, including path separators, and ? any single character
+func wildcardMatch(pattern, s string) bool {
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case '*':
+			for i := len(s); i >= 0; i-- {
+				if wildcardMatch(pattern[1:], s[i:]) {
+					return true
+				}
+			}
+			return false
+		case '?':
+			if len(s) == 0 {
+				return false
+			}
+			pattern, s = pattern[1:], s[1:]
+		default:
+			if len(s) == 0 || s[0] != pattern[0] {
+				return false
+			}
+			pattern, s = pattern[1:], s[1:]
+		}
+	}
+	return len(s) == 0
+}
+
+// matchTags matches tags on their full text and on their key
+func (t catalogTerm) matchTags(tags []string) bool {
+	for _, tag := range tags {
+		key, _, _ := strings.Cut(tag, "=")
+		if t.match(tag) || t.match(key) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,81 @@
+package lockbox
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/apache/arrow-go/v18/arrow"
+)
+
+func TestCatalog(t *testing.T) {
+	dir := t.TempDir()
+	password := "test_password_123"
+
+	lb := newQueryTestLockbox(t, filepath.Join(dir, "scores.lbx"), password)
+	lb.Close()
+
+	// Schema and field metadata are indexed as tags
+	fileTags := arrow.NewMetadata([]string{"env"}, []string{"prod"})
+	schema := arrow.NewSchema([]arrow.Field{
+		{Name: "id", Type: arrow.PrimitiveTypes.Int64},
+		{Name: "email", Type: arrow.BinaryTypes.String, Metadata: arrow.NewMetadata([]string{"pii"}, []string{""})},
+	}, &fileTags)
+	if err := os.Mkdir(filepath.Join(dir, "hr"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	lb, err := Create(filepath.Join(dir, "hr", "people.lbx"), schema, WithPassword(password), WithCreatedBy("hr"))
+	if err != nil {
+		t.Fatalf("Failed to create lockbox: %v", err)
+	}
+	lb.Close()
+	if err := os.WriteFile(filepath.Join(dir, "broken.lbx"), []byte("not a lockbox"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := IndexCatalog(context.Background(), dir)
+	if err != nil {
+		t.Fatalf("index: %v", err)
+	}
+	if len(c.Entries) != 2 || len(c.Skipped) != 1 {
+		t.Fatalf("expected 2 entries and 1 skipped file, got %d and %d", len(c.Entries), len(c.Skipped))
+	}
+
+	catalogFile := filepath.Join(dir, "catalog.db")
+	if err := c.Save(catalogFile); err != nil {
+		t.Fatalf("save: %v", err)
+	}
+	c, err = LoadCatalog(catalogFile)
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+
+	tests := []struct {
+		query string
+		want  int
+	}{
+		{"column:email tag:pii", 1},
+		{"tag:env=prod", 1},
+		{"tag:env=dev", 0},
+		{"column:id", 2},
+		{"type:float64", 1},
+		{"creator:tester", 1},
+		{"path:*/hr/*", 1},
+		{"EMAIL", 1},
+		{"sc*", 1},
+	}
+	for _, tt := range tests {
+		matches, err := c.Search(tt.query)
+		if err != nil {
+			t.Fatalf("search %q: %v", tt.query, err)
+		}
+		if len(matches) != tt.want {
+			t.Errorf("search %q: expected %d matches, got %d", tt.query, tt.want, len(matches))
+		}
+	}
+
+	if _, err := c.Search("owner:me"); err == nil {
+		t.Fatal("expected error for unknown search field")
+	}
+}
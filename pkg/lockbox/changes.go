@@ -0,0 +1,248 @@
+package lockbox
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/TFMV/lockbox/pkg/crypto"
+	"github.com/apache/arrow-go/v18/arrow"
+	"github.com/apache/arrow-go/v18/arrow/ipc"
+	"github.com/apache/arrow-go/v18/arrow/memory"
+)
+
+// ErrBrokenChain is returned by ChangeVerifier for batches that are
+// unsigned, altered, reordered or follow a gap in the stream
+var ErrBrokenChain = errors.New("broken change stream")
+
+// ChangeBatch is one commit of a change stream: the rows it added as an
+// Arrow IPC stream, and its link in the signature chain
+type ChangeBatch struct {
+	Link BatchLink `json:"link"`
+	Data []byte    `json:"data"`
+}
+
+// BatchLink ties a batch to the batch before it. Hash is the SHA-256 of
+// Prev followed by the batch data, so every hash covers the whole stream up
+// to its batch. Signature is the Ed25519 signature of the file's signing
+// key over the rest of the link.
+type BatchLink struct {
+	Snapshot  int64  `json:"snapshot"`
+	Parent    int64  `json:"parent"`
+	Rows      int64  `json:"rows"`
+	Prev      []byte `json:"prev"`
+	Hash      []byte `json:"hash"`
+	Signature []byte `json:"signature"`
+}
+
+// ChangeStreamResult sums up a change stream. Passing Snapshot to
+// WithSinceSnapshot and Hash to WithPrevBatchHash continues the stream
+// where it stopped.
+type ChangeStreamResult struct {
+	Batches   int               `json:"batches"`
+	Rows      int64             `json:"rows"`
+	Snapshot  int64             `json:"snapshot"`
+	Hash      []byte            `json:"hash"`
+	PublicKey ed25519.PublicKey `json:"publicKey"`
+}
+
+// WithPrevBatchHash links the first batch of a change stream to the last
+// batch a consumer received, instead of to the start of the stream
+func WithPrevBatchHash(hash []byte) Option {
+	return func(o *Options) {
+		o.PrevBatchHash = hash
+	}
+}
+
+// ChainStart is the Prev of the first batch of a stream starting after
+// snapshot since
+func ChainStart(since int64) []byte {
+	h := sha256.New()
+	h.Write([]byte("lockbox changes\x00"))
+	_ = binary.Write(h, binary.LittleEndian, since)
+	return h.Sum(nil)
+}
+
+// StreamChanges writes the rows of each commit after WithSinceSnapshot to
+// w as newline-delimited JSON ChangeBatches, oldest first, signed with the
+// file's block signing key and chained by hash. Consumers check with a
+// ChangeVerifier that they got every commit, in order and unaltered. Rows
+// are read as WithPrincipal, so row filters and masks apply; commits that
+// add no rows, such as key rotations, are skipped.
+func (lb *Lockbox) StreamChanges(ctx context.Context, w io.Writer, opts ...Option) (*ChangeStreamResult, error) {
+	options := &Options{}
+	for _, opt := range opts {
+		opt(options)
+	}
+	if options.Password == "" {
+		return nil, fmt.Errorf("password is required for streaming changes")
+	}
+	meta := lb.file.Metadata()
+	key, err := lb.file.MasterKey(options.Password)
+	if err != nil {
+		return nil, err
+	}
+	signer := crypto.BlockSigningKey(key.Data)
+	pub := signer.Public().(ed25519.PublicKey)
+	if len(meta.Encryption.SigningKey) > 0 && !bytes.Equal(meta.Encryption.SigningKey, pub) {
+		return nil, fmt.Errorf("the recorded signing key does not match the master key")
+	}
+
+	reader, err := lb.file.NewReader(options.Password)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create reader: %w", err)
+	}
+	policy, err := lb.checkPolicy(options.Principal, ActionRead)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := meta.FindSnapshot(options.SinceSnapshot); !ok && options.SinceSnapshot != 0 {
+		return nil, fmt.Errorf("snapshot %d not found", options.SinceSnapshot)
+	}
+
+	columns := make([]string, 0, meta.Schema.NumFields())
+	for _, f := range meta.Schema.Fields() {
+		columns = append(columns, f.Name)
+	}
+	prev := options.PrevBatchHash
+	if prev == nil {
+		prev = ChainStart(options.SinceSnapshot)
+	}
+	res := &ChangeStreamResult{Snapshot: options.SinceSnapshot, Hash: prev, PublicKey: pub}
+	bw := bufio.NewWriter(w)
+	enc := json.NewEncoder(bw)
+
+	parent := int64(0)
+	for _, s := range meta.Snapshots {
+		if s.ID <= options.SinceSnapshot {
+			parent = s.ID
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			return res, err
+		}
+		from, err := meta.RowGroupsAt(parent)
+		if err != nil {
+			return res, err
+		}
+		to, err := meta.RowGroupsAt(s.ID)
+		if err != nil {
+			return res, err
+		}
+		if to <= from || s.RowsAdded == 0 {
+			parent = s.ID
+			continue
+		}
+		groups := make([]int, 0, to-from)
+		for n := from; n < to; n++ {
+			groups = append(groups, n)
+		}
+		rec, err := reader.ReadColumnsIn(groups, columns)
+		if err != nil {
+			return res, fmt.Errorf("failed to read snapshot %d: %w", s.ID, err)
+		}
+		if policy != nil {
+			allowed, err := policy.apply(lb.Allocator(), rec)
+			rec.Release()
+			if err != nil {
+				return res, err
+			}
+			rec = allowed
+		}
+		data, err := encodeBatch(rec, lb.Allocator())
+		rows := rec.NumRows()
+		rec.Release()
+		if err != nil {
+			return res, err
+		}
+
+		link := BatchLink{Snapshot: s.ID, Parent: parent, Rows: rows, Prev: prev}
+		link.Hash = batchHash(prev, data)
+		link.Signature = ed25519.Sign(signer, link.message())
+		if err := enc.Encode(ChangeBatch{Link: link, Data: data}); err != nil {
+			return res, err
+		}
+		prev = link.Hash
+		parent = s.ID
+		res.Batches++
+		res.Rows += rows
+		res.Snapshot, res.Hash = s.ID, link.Hash
+	}
+	// Commits without rows still move the consumer's position
+	res.Snapshot = max(res.Snapshot, parent)
+	return res, bw.Flush()
+}
+
+// encodeBatch encodes a record as an Arrow IPC stream
+func encodeBatch(rec arrow.Record, mem memory.Allocator) ([]byte, error) {
+	var buf bytes.Buffer
+	w := ipc.NewWriter(&buf, ipc.WithSchema(rec.Schema()), ipc.WithAllocator(mem))
+	if err := w.Write(rec); err != nil {
+		w.Close()
+		return nil, fmt.Errorf("failed to encode batch: %w", err)
+	}
+	if err := w.Close(); err != nil {
+		return nil, fmt.Errorf("failed to encode batch: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+func batchHash(prev, data []byte) []byte {
+	h := sha256.New()
+	h.Write(prev)
+	h.Write(data)
+	return h.Sum(nil)
+}
+
+// message returns what the signature of a link covers
+func (l *BatchLink) message() []byte {
+	var msg bytes.Buffer
+	msg.WriteString("lockbox batch\x00")
+	_ = binary.Write(&msg, binary.LittleEndian, l.Snapshot)
+	_ = binary.Write(&msg, binary.LittleEndian, l.Parent)
+	_ = binary.Write(&msg, binary.LittleEndian, l.Rows)
+	msg.Write(l.Prev)
+	msg.Write(l.Hash)
+	return msg.Bytes()
+}
+
+// ChangeVerifier checks the batches of a change stream in the order they
+// arrive. Each batch must be signed by PublicKey, hash to its link, follow
+// the hash of the batch before it and continue from the last snapshot
+// seen, so a dropped, repeated, reordered or altered batch is refused.
+type ChangeVerifier struct {
+	PublicKey ed25519.PublicKey
+	// Snapshot and Hash are the last snapshot and batch hash accepted
+	Snapshot int64
+	Hash     []byte
+}
+
+// NewChangeVerifier returns a verifier for a stream starting after snapshot
+// since, signed by publicKey, such as Info.SigningKey
+func NewChangeVerifier(publicKey ed25519.PublicKey, since int64) *ChangeVerifier {
+	return &ChangeVerifier{PublicKey: publicKey, Snapshot: since, Hash: ChainStart(since)}
+}
+
+// Verify checks the next batch and accepts it
+func (v *ChangeVerifier) Verify(b *ChangeBatch) error {
+	l := &b.Link
+	switch {
+	case len(v.PublicKey) != ed25519.PublicKeySize || !ed25519.Verify(v.PublicKey, l.message(), l.Signature):
+		return fmt.Errorf("%w: bad signature on snapshot %d", ErrBrokenChain, l.Snapshot)
+	case !bytes.Equal(l.Hash, batchHash(l.Prev, b.Data)):
+		return fmt.Errorf("%w: data of snapshot %d does not match its hash", ErrBrokenChain, l.Snapshot)
+	case !bytes.Equal(l.Prev, v.Hash):
+		return fmt.Errorf("%w: snapshot %d does not follow the last batch", ErrBrokenChain, l.Snapshot)
+	case l.Parent < v.Snapshot || l.Snapshot <= l.Parent:
+		return fmt.Errorf("%w: snapshot %d after %d does not follow snapshot %d", ErrBrokenChain, l.Snapshot, l.Parent, v.Snapshot)
+	}
+	v.Snapshot, v.Hash = l.Snapshot, l.Hash
+	return nil
+}
@@ -0,0 +1,124 @@
+package lockbox
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/apache/arrow-go/v18/arrow"
+	"github.com/apache/arrow-go/v18/arrow/array"
+	"github.com/apache/arrow-go/v18/arrow/ipc"
+	"github.com/apache/arrow-go/v18/arrow/memory"
+)
+
+func TestChangeStream(t *testing.T) {
+	password := "test_password_123"
+	ctx := context.Background()
+	schema := arrow.NewSchema([]arrow.Field{
+		{Name: "id", Type: arrow.PrimitiveTypes.Int64},
+	}, nil)
+	path := filepath.Join(t.TempDir(), "events.lbx")
+
+	lb, err := Create(path, schema, WithPassword(password))
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	defer lb.Close()
+	write := func(ids ...int64) {
+		t.Helper()
+		b := array.NewRecordBuilder(memory.NewGoAllocator(), schema)
+		b.Field(0).(*array.Int64Builder).AppendValues(ids, nil)
+		rec := b.NewRecord()
+		b.Release()
+		if err := lb.Write(ctx, rec, WithPassword(password)); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+		rec.Release()
+	}
+	stream := func(opts ...Option) ([]ChangeBatch, *ChangeStreamResult) {
+		t.Helper()
+		var buf bytes.Buffer
+		res, err := lb.StreamChanges(ctx, &buf, append(opts, WithPassword(password))...)
+		if err != nil {
+			t.Fatalf("stream changes: %v", err)
+		}
+		var batches []ChangeBatch
+		dec := json.NewDecoder(&buf)
+		for dec.More() {
+			var b ChangeBatch
+			if err := dec.Decode(&b); err != nil {
+				t.Fatalf("decode: %v", err)
+			}
+			batches = append(batches, b)
+		}
+		return batches, res
+	}
+	verify := func(v *ChangeVerifier, batches []ChangeBatch) error {
+		for i := range batches {
+			if err := v.Verify(&batches[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	write(1, 2)
+	write(3)
+	write(4, 5, 6)
+	batches, res := stream()
+	if len(batches) != 3 || res.Rows != 6 {
+		t.Fatalf("expected 3 batches of 6 rows, got %d of %d", len(batches), res.Rows)
+	}
+	r, err := ipc.NewReader(bytes.NewReader(batches[2].Data))
+	if err != nil {
+		t.Fatalf("read batch: %v", err)
+	}
+	if !r.Next() || r.Record().NumRows() != 3 {
+		t.Errorf("expected the third commit's 3 rows")
+	}
+	r.Release()
+
+	info, err := lb.Info()
+	if err != nil {
+		t.Fatalf("info: %v", err)
+	}
+	if !bytes.Equal(info.SigningKey, res.PublicKey) {
+		t.Fatal("expected the stream to be signed with the recorded signing key")
+	}
+	if err := verify(NewChangeVerifier(info.SigningKey, 0), batches); err != nil {
+		t.Fatalf("verify: %v", err)
+	}
+
+	// A dropped, reordered or altered batch is refused
+	dropped := []ChangeBatch{batches[0], batches[2]}
+	if err := verify(NewChangeVerifier(info.SigningKey, 0), dropped); !errors.Is(err, ErrBrokenChain) {
+		t.Errorf("expected a dropped batch to break the chain, got %v", err)
+	}
+	reordered := []ChangeBatch{batches[1], batches[0], batches[2]}
+	if err := verify(NewChangeVerifier(info.SigningKey, 0), reordered); !errors.Is(err, ErrBrokenChain) {
+		t.Errorf("expected reordered batches to break the chain, got %v", err)
+	}
+	altered := append([]ChangeBatch(nil), batches...)
+	altered[1].Data = append([]byte(nil), altered[1].Data...)
+	altered[1].Data[len(altered[1].Data)-9] ^= 1
+	if err := verify(NewChangeVerifier(info.SigningKey, 0), altered); !errors.Is(err, ErrBrokenChain) {
+		t.Errorf("expected altered data to break the chain, got %v", err)
+	}
+
+	// A consumer picks up the next commits where it stopped
+	v := NewChangeVerifier(info.SigningKey, 0)
+	if err := verify(v, batches); err != nil {
+		t.Fatalf("verify: %v", err)
+	}
+	write(7)
+	more, res := stream(WithSinceSnapshot(v.Snapshot), WithPrevBatchHash(v.Hash))
+	if len(more) != 1 || res.Rows != 1 {
+		t.Fatalf("expected one new batch, got %d", len(more))
+	}
+	if err := verify(v, more); err != nil {
+		t.Errorf("verify continued stream: %v", err)
+	}
+}
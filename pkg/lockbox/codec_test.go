@@ -0,0 +1,144 @@
+package lockbox
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/TFMV/lockbox/pkg/codec"
+	"github.com/apache/arrow-go/v18/arrow"
+	"github.com/apache/arrow-go/v18/arrow/array"
+	"github.com/apache/arrow-go/v18/arrow/memory"
+)
+
+// xorCodec is a toy third-party codec used to exercise the registry
+type xorCodec struct{}
+
+func (xorCodec) Name() string { return "test-xor" }
+
+func (xorCodec) Encode(b []byte) ([]byte, error) {
+	out := make([]byte, len(b))
+	for i, c := range b {
+		out[i] = c ^ 0x5a
+	}
+	return out, nil
+}
+
+func (c xorCodec) Decode(b []byte) ([]byte, error) { return c.Encode(b) }
+
+func TestWriteWithCodec(t *testing.T) {
+	codec.Register(xorCodec{})
+
+	for _, name := range []string{"gzip", "zstd", "lz4", "test-xor"} {
+		t.Run(name, func(t *testing.T) {
+			tmpFile := "/tmp/test_lockbox_codec_" + name + ".lbx"
+			defer os.Remove(tmpFile)
+
+			password := "test_password_123"
+			schema := arrow.NewSchema([]arrow.Field{
+				{Name: "msg", Type: arrow.BinaryTypes.String},
+			}, nil)
+
+			lb, err := Create(tmpFile, schema, WithPassword(password))
+			if err != nil {
+				t.Fatalf("Failed to create lockbox: %v", err)
+			}
+
+			b := array.NewStringBuilder(memory.NewGoAllocator())
+			for i := 0; i < 100; i++ {
+				b.Append("repetitive payload")
+			}
+			arr := b.NewArray()
+			b.Release()
+			record := array.NewRecord(schema, []arrow.Array{arr}, int64(arr.Len()))
+			arr.Release()
+
+			if err := lb.Write(context.Background(), record, WithPassword(password), WithCodec("missing")); err == nil {
+				t.Fatalf("expected error for unknown codec")
+			}
+			if err := lb.Write(context.Background(), record, WithPassword(password), WithCodec(name)); err != nil {
+				t.Fatalf("write error: %v", err)
+			}
+			lb.Close()
+
+			lb, err = Open(tmpFile, WithPassword(password))
+			if err != nil {
+				t.Fatalf("open error: %v", err)
+			}
+			defer lb.Close()
+
+			blocks := lb.file.Metadata().BlockInfo
+			if len(blocks) != 1 || blocks[0].Codec != name || !blocks[0].Compressed {
+				t.Fatalf("codec not recorded in block info: %+v", blocks)
+			}
+			if codecs := lb.file.Metadata().Encryption.Codecs; len(codecs) != 1 || codecs[0] != name {
+				t.Fatalf("codec not recorded in encryption params: %v", codecs)
+			}
+
+			rec, err := lb.Read(context.Background(), WithPassword(password))
+			if err != nil {
+				t.Fatalf("read error: %v", err)
+			}
+			defer rec.Release()
+			if rec.NumRows() != 100 || rec.Column(0).(*array.String).Value(99) != "repetitive payload" {
+				t.Fatalf("unexpected data after decoding")
+			}
+		})
+	}
+}
+
+func TestCreateWithCompression(t *testing.T) {
+	tmpFile := "/tmp/test_lockbox_compression.lbx"
+	defer os.Remove(tmpFile)
+
+	password := "test_password_123"
+	schema := arrow.NewSchema([]arrow.Field{
+		{Name: "msg", Type: arrow.BinaryTypes.String},
+	}, nil)
+
+	if _, err := Create(tmpFile, schema, WithPassword(password), WithCompression("missing")); err == nil {
+		t.Fatalf("expected error for unknown codec")
+	}
+	lb, err := Create(tmpFile, schema, WithPassword(password), WithCompression("zstd"))
+	if err != nil {
+		t.Fatalf("Failed to create lockbox: %v", err)
+	}
+	defer lb.Close()
+
+	b := array.NewStringBuilder(memory.NewGoAllocator())
+	for i := 0; i < 1000; i++ {
+		b.Append("repetitive payload")
+	}
+	arr := b.NewArray()
+	b.Release()
+	record := array.NewRecord(schema, []arrow.Array{arr}, int64(arr.Len()))
+	arr.Release()
+	defer record.Release()
+
+	// Writes that don't choose a codec use the file's default
+	ctx := context.Background()
+	if err := lb.Write(ctx, record, WithPassword(password)); err != nil {
+		t.Fatalf("write error: %v", err)
+	}
+	if err := lb.Write(ctx, record, WithPassword(password), WithCompression("lz4")); err != nil {
+		t.Fatalf("write error: %v", err)
+	}
+	blocks := lb.file.Metadata().BlockInfo
+	if len(blocks) != 2 || blocks[0].Codec != "zstd" || blocks[1].Codec != "lz4" {
+		t.Fatalf("unexpected codecs in block info: %+v", blocks)
+	}
+	for _, bi := range blocks {
+		if !bi.Compressed || bi.Length >= bi.OrigSize {
+			t.Fatalf("expected a compressed block, got %d bytes from %d", bi.Length, bi.OrigSize)
+		}
+	}
+
+	rec, err := lb.Read(ctx, WithPassword(password))
+	if err != nil {
+		t.Fatalf("read error: %v", err)
+	}
+	defer rec.Release()
+	if rec.NumRows() != 2000 || rec.Column(0).(*array.String).Value(1999) != "repetitive payload" {
+		t.Fatalf("unexpected data after decoding")
+	}
+}
@@ -0,0 +1,28 @@
+package lockbox
+
+import (
+	"fmt"
+
+	"github.com/TFMV/lockbox/pkg/codec"
+	_ "github.com/TFMV/lockbox/pkg/codec/lz4"
+	_ "github.com/TFMV/lockbox/pkg/codec/zstd"
+)
+
+// WithCompression compresses the serialized column blocks with the named
+// codec, such as "zstd", "lz4" or "gzip", before they are encrypted. Given
+// to Create it sets the file's default, and given to Write it applies to
+// that write; reads decompress transparently. It is the same as WithCodec.
+func WithCompression(name string) Option {
+	return WithCodec(name)
+}
+
+// checkCodec verifies that a codec is registered
+func checkCodec(name string) error {
+	if name == "" || name == codec.None {
+		return nil
+	}
+	if _, ok := codec.Get(name); !ok {
+		return fmt.Errorf("codec %s is not registered", name)
+	}
+	return nil
+}
@@ -0,0 +1,144 @@
+package lockbox
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/TFMV/lockbox/pkg/expr"
+	"github.com/TFMV/lockbox/pkg/metadata"
+	"github.com/apache/arrow-go/v18/arrow"
+	"github.com/apache/arrow-go/v18/arrow/array"
+)
+
+// Compute evaluates expression for every row and stores the results in
+// column, e.g. Compute(ctx, "total", "price * qty"). An existing column is
+// rewritten like ReplaceColumn, with the results converted to its type; a
+// new column is added to the schema with the type of the results. The
+// expression may use stored and virtual columns. It returns the number of
+// rows computed.
+func (lb *Lockbox) Compute(ctx context.Context, column, expression string, opts ...Option) (int64, error) {
+	options := &Options{}
+	for _, opt := range opts {
+		opt(options)
+	}
+	if options.Password == "" {
+		return 0, fmt.Errorf("password is required for writing")
+	}
+
+	meta := lb.file.Metadata()
+	if _, err := lb.checkPolicy(options.Principal, ActionWrite); err != nil {
+		return 0, err
+	}
+	// The results would reveal values that the policy hides from the principal
+	scope, err := lb.checkPolicy(options.Principal, ActionRead)
+	if err != nil {
+		return 0, err
+	}
+	if scope != nil {
+		return 0, fmt.Errorf("compute needs unfiltered, unmasked read access for %s", scope.principal)
+	}
+
+	var field *arrow.Field
+	if idx := meta.Schema.FieldIndices(column); len(idx) > 0 {
+		f := meta.Schema.Field(idx[0])
+		field = &f
+	} else {
+		column = strings.ToLower(strings.TrimSpace(column))
+		if !viewNamePattern.MatchString(column) {
+			return 0, fmt.Errorf("invalid column name %q", column)
+		}
+		if _, isVirtual := meta.FindVirtualColumn(column); isVirtual {
+			return 0, fmt.Errorf("%s is a virtual column", column)
+		}
+	}
+
+	e, err := expr.Parse(expression)
+	if err != nil {
+		return 0, fmt.Errorf("invalid expression: %w", err)
+	}
+	for _, dep := range e.Columns() {
+		_, isVirtual := meta.FindVirtualColumn(dep)
+		if len(meta.Schema.FieldIndices(dep)) == 0 && !isVirtual {
+			return 0, fmt.Errorf("expression refers to unknown column %s", dep)
+		}
+	}
+
+	// Computed columns are written, so only the master password may read
+	// for them
+	if _, err := lb.writerFor(options.Password); err != nil {
+		return 0, err
+	}
+	reader, err := lb.readerFor(options.Password)
+	if err != nil {
+		return 0, err
+	}
+	stored, err := reader.ReadRecord()
+	if err != nil {
+		return 0, fmt.Errorf("failed to read record: %w", err)
+	}
+	defer stored.Release()
+	_, virtual, err := planVirtualColumns(meta, e.Columns())
+	if err != nil {
+		return 0, err
+	}
+	rec, err := addVirtualColumns(lb.Allocator(), stored, virtual)
+	if err != nil {
+		return 0, err
+	}
+	defer rec.Release()
+
+	values := make([]interface{}, rec.NumRows())
+	for row := range values {
+		if row%4096 == 0 {
+			if err := ctx.Err(); err != nil {
+				return 0, err
+			}
+		}
+		if values[row], err = e.Eval(recordEnv{rec: rec, row: row}); err != nil {
+			return 0, fmt.Errorf("row %d: %w", row, err)
+		}
+	}
+
+	var arr arrow.Array
+	if field != nil {
+		b := array.NewBuilder(lb.Allocator(), field.Type)
+		for row, v := range values {
+			if err := appendExprValue(b, v); err != nil {
+				b.Release()
+				return 0, fmt.Errorf("row %d: %w", row, err)
+			}
+		}
+		arr = b.NewArray()
+		b.Release()
+	} else {
+		arr = buildValueArray(lb.Allocator(), values)
+	}
+	defer arr.Release()
+
+	options.operation = OperationCompute
+	if options.Lineage == nil {
+		options.Lineage = &metadata.Lineage{}
+	}
+	if options.Lineage.Transformation == "" {
+		options.Lineage.Transformation = fmt.Sprintf("%s = %s", column, expression)
+	}
+	writer, err := lb.prepareWriter(options)
+	if err != nil {
+		return 0, err
+	}
+	if field != nil {
+		err = writer.ReplaceColumn(field.Name, arr)
+	} else {
+		err = writer.AddColumn(arrow.Field{Name: column, Type: arr.DataType(), Nullable: true}, arr)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to store column %s: %w", column, err)
+	}
+
+	if err := lb.refreshMaterializedViews(options.Password, ""); err != nil {
+		return 0, fmt.Errorf("failed to refresh materialized views: %w", err)
+	}
+	lb.notifyCommit(ctx)
+	return rec.NumRows(), nil
+}
@@ -0,0 +1,68 @@
+package lockbox
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/apache/arrow-go/v18/arrow"
+	"github.com/apache/arrow-go/v18/arrow/array"
+)
+
+func TestCompute(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "compute.lbx")
+	password := "test_password_123"
+	lb := newQueryTestLockbox(t, path, password)
+	defer func() { lb.Close() }()
+
+	ctx := context.Background()
+	if err := lb.AddVirtualColumn("bonus", "score / 10"); err != nil {
+		t.Fatalf("add virtual column: %v", err)
+	}
+	// A new column takes the type of the results
+	if n, err := lb.Compute(ctx, "total", "score + bonus", WithPassword(password)); err != nil || n != 3 {
+		t.Fatalf("compute total: %d, %v", n, err)
+	}
+	// An existing column keeps its type
+	if _, err := lb.Compute(ctx, "score", "id * 100", WithPassword(password), WithMessage("rescore")); err != nil {
+		t.Fatalf("compute score: %v", err)
+	}
+	if _, err := lb.Compute(ctx, "bonus", "1", WithPassword(password)); err == nil {
+		t.Fatal("expected computing into a virtual column to fail")
+	}
+	if _, err := lb.Compute(ctx, "x", "missing + 1", WithPassword(password)); err == nil {
+		t.Fatal("expected an unknown column to fail")
+	}
+	// The handle's earlier computes don't lend their keys to other passwords
+	if err := lb.GrantAccess("public", "public_password_456", []string{"id"}, WithPassword(password)); err != nil {
+		t.Fatalf("grant: %v", err)
+	}
+	for _, p := range []string{"public_password_456", "totally-wrong"} {
+		if _, err := lb.Compute(ctx, "leak", "name", WithPassword(p)); err == nil {
+			t.Errorf("expected computing with password %q to fail", p)
+		}
+	}
+	lb.Close()
+
+	lb, err := Open(path, WithPassword(password))
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	if got := lb.Schema().Field(3); got.Name != "total" || !arrow.TypeEqual(got.Type, arrow.PrimitiveTypes.Float64) {
+		t.Fatalf("unexpected new field %v", got)
+	}
+	res, err := lb.Query(ctx, "SELECT score, total FROM data WHERE id = 2", WithPassword(password))
+	if err != nil {
+		t.Fatalf("query: %v", err)
+	}
+	defer res.Release()
+	if score, total := res.Column(0).(*array.Float64).Value(0), res.Column(1).(*array.Float64).Value(0); score != 200 || total != 60.5 {
+		t.Fatalf("expected score 200 and total 60.5, got %v and %v", score, total)
+	}
+
+	snaps := lb.Snapshots()
+	last := snaps[len(snaps)-1]
+	if last.Operation != OperationCompute || last.Message != "rescore" || last.Lineage == nil || last.Lineage.Transformation != "score = id * 100" {
+		t.Fatalf("unexpected snapshot %+v", last)
+	}
+}
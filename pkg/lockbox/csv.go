@@ -0,0 +1,618 @@
+package lockbox
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/TFMV/lockbox/pkg/geo"
+	"github.com/apache/arrow-go/v18/arrow"
+	"github.com/apache/arrow-go/v18/arrow/array"
+	"github.com/apache/arrow-go/v18/arrow/memory"
+)
+
+// csvDelimiters are the delimiters considered when none is configured
+var csvDelimiters = []rune{',', ';', '\t', '|'}
+
+// utf8BOM is the byte order mark some tools put at the start of UTF-8 text
+var utf8BOM = []byte{0xEF, 0xBB, 0xBF}
+
+// WithNullToken sets the text that stands for a null value in CSV. Reading
+// treats fields equal to it, as well as empty fields, as null in nullable
+// columns; export writes it for nulls. The default is the empty string.
+func WithNullToken(token string) Option {
+	return func(o *Options) {
+		o.NullToken = token
+	}
+}
+
+// WithBOM makes CSV export start with a UTF-8 byte order mark, which some
+// spreadsheet applications need to detect the encoding. A BOM in input is
+// always skipped.
+func WithBOM() Option {
+	return func(o *Options) {
+		o.BOM = true
+	}
+}
+
+// skipBOM discards a UTF-8 byte order mark at the start of r
+func skipBOM(r *bufio.Reader) {
+	if head, err := r.Peek(len(utf8BOM)); err == nil && bytes.Equal(head, utf8BOM) {
+		r.Discard(len(utf8BOM))
+	}
+}
+
+// csvReader reads CSV records with a configurable delimiter and quote
+// character. Quoted fields may contain delimiters, newlines and doubled
+// quotes, as in RFC 4180. Empty lines are skipped.
+type csvReader struct {
+	r     *bufio.Reader
+	comma rune
+	quote rune
+	line  int
+}
+
+func newCSVReader(r *bufio.Reader, comma, quote rune) *csvReader {
+	return &csvReader{r: r, comma: comma, quote: quote}
+}
+
+// Read returns the next record, or io.EOF at the end of the input
+func (c *csvReader) Read() ([]string, error) {
+	for {
+		record, err := c.readRecord()
+		if err != nil {
+			return nil, err
+		}
+		if len(record) == 1 && record[0] == "" {
+			continue // empty line
+		}
+		return record, nil
+	}
+}
+
+func (c *csvReader) readRecord() ([]string, error) {
+	c.line++
+	start := c.line
+	var record []string
+	var field strings.Builder
+	quoted, atStart, sawAny := false, true, false
+
+	for {
+		r, _, err := c.r.ReadRune()
+		if err == io.EOF {
+			if quoted {
+				return nil, fmt.Errorf("line %d: unterminated quoted field", start)
+			}
+			if !sawAny {
+				return nil, io.EOF
+			}
+			return append(record, field.String()), nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		sawAny = true
+
+		switch {
+		case quoted:
+			if r == c.quote {
+				next, _, err := c.r.ReadRune()
+				if err == nil && next == c.quote {
+					field.WriteRune(c.quote)
+					continue
+				}
+				if err == nil {
+					c.r.UnreadRune()
+				}
+				quoted = false
+				continue
+			}
+			if r == '\n' {
+				c.line++
+			}
+			field.WriteRune(r)
+		case r == c.quote && atStart:
+			quoted = true
+			atStart = false
+		case r == c.comma:
+			record = append(record, field.String())
+			field.Reset()
+			atStart = true
+		case r == '\r':
+			// Dropped before \n, kept elsewhere
+			next, _, err := c.r.ReadRune()
+			if err == nil && next == '\n' {
+				return append(record, field.String()), nil
+			}
+			if err == nil {
+				c.r.UnreadRune()
+			}
+			field.WriteRune(r)
+			atStart = false
+		case r == '\n':
+			return append(record, field.String()), nil
+		default:
+			field.WriteRune(r)
+			atStart = false
+		}
+	}
+}
+
+// sniffCSV guesses the delimiter and quote character from the start of the
+// input without consuming it. Zero arguments are guessed; others are kept.
+func sniffCSV(r *bufio.Reader, comma, quote rune) (rune, rune) {
+	head, _ := r.Peek(64 * 1024)
+	lines := bytes.Split(head, []byte("\n"))
+	if len(lines) > 1 {
+		lines = lines[:len(lines)-1] // the last line may be cut off
+	}
+	if len(lines) > 20 {
+		lines = lines[:20]
+	}
+
+	if comma == 0 {
+		comma = ','
+		best := 0
+		for _, d := range csvDelimiters {
+			// The delimiter should occur equally often on every line
+			count := -1
+			for _, line := range lines {
+				n := bytes.Count(bytes.TrimRight(line, "\r"), []byte(string(d)))
+				if len(bytes.TrimSpace(line)) == 0 {
+					continue
+				}
+				if count == -1 || n < count {
+					count = n
+				}
+			}
+			if count > best {
+				comma, best = d, count
+			}
+		}
+	}
+
+	if quote == 0 {
+		quote = '"'
+		// Single quotes only when fields start with them and double quotes
+		// are not used at all
+		single := false
+		prefix := []byte(string(comma) + "'")
+		for _, line := range lines {
+			if bytes.HasPrefix(line, []byte("'")) || bytes.Contains(line, prefix) {
+				single = true
+			}
+		}
+		if single && !bytes.Contains(head, []byte(`"`)) {
+			quote = '\''
+		}
+	}
+	return comma, quote
+}
+
+// LoadCSV reads a CSV file into a record with the given schema. Fields are
+// matched to columns by position, or by name with WithColumnMapping, and
+// the first line is a header unless WithNoHeader is given. The dialect is detected as in DetectCSVSchema
+// unless set with WithDelimiter and WithQuote; numbers and timestamps are
+// parsed per WithLocale and WithDateFormats, and WithNullToken sets the
+// null value. WithOnError decides what happens to rows that do not fit.
+// String columns come back dictionary
+// encoded with WithInternStrings or WithDictionary. The caller releases the
+// record.
+func LoadCSV(path string, schema *arrow.Schema, opts ...Option) (arrow.Record, error) {
+	options := &Options{}
+	for _, opt := range opts {
+		opt(options)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open file: %w", err)
+	}
+	defer f.Close()
+
+	l, err := newCSVLoader(f, schema, options)
+	if err != nil {
+		return nil, err
+	}
+	defer l.Release()
+	return l.next(0)
+}
+
+// csvLoader converts the rows of a CSV input to records of a schema, a
+// batch at a time
+type csvLoader struct {
+	r       *csvReader
+	schema  *arrow.Schema
+	options *Options
+	comma   rune
+	quote   rune
+	width   int
+	pick    []func([]string) string
+	pending []string
+	parser  *valueParser
+	builder *array.RecordBuilder
+	appends []func()
+}
+
+// newCSVLoader detects the dialect and reads the header of a CSV input
+func newCSVLoader(in io.Reader, schema *arrow.Schema, options *Options) (*csvLoader, error) {
+	br := bufio.NewReader(in)
+	skipBOM(br)
+	comma, quote := sniffCSV(br, options.Delimiter, options.Quote)
+	l := &csvLoader{
+		r:       newCSVReader(br, comma, quote),
+		schema:  schema,
+		options: options,
+		comma:   comma,
+		quote:   quote,
+		width:   schema.NumFields(),
+	}
+
+	// Without a header the first record is data and only gives the count
+	var header []string
+	var err error
+	if options.NoHeader {
+		if l.pending, err = l.r.Read(); err != nil && err != io.EOF {
+			return nil, err
+		}
+		header = csvColumnNames(len(l.pending))
+	} else if header, err = l.r.Read(); err != nil {
+		return nil, fmt.Errorf("failed to read CSV header: %w", err)
+	}
+	if options.Mapping != nil {
+		if l.pick, err = options.Mapping.csvMapper(schema, header); err != nil {
+			return nil, err
+		}
+		l.width = len(header)
+	}
+
+	l.parser = newValueParser(options)
+	l.builder = array.NewRecordBuilder(loadAllocator(options), internSchema(schema, options))
+	l.appends = make([]func(), schema.NumFields())
+	return l, nil
+}
+
+// Release frees the builder of the loader
+func (l *csvLoader) Release() {
+	l.builder.Release()
+}
+
+// next converts up to max rows, or all remaining rows when max is zero.
+// The record is empty at the end of the input; the caller releases it.
+func (l *csvLoader) next(max int) (arrow.Record, error) {
+	schema, options, b := l.schema, l.options, l.builder
+	var err error
+	for rows := 0; max <= 0 || rows < max; {
+		row := l.pending
+		l.pending = nil
+		if row == nil {
+			if row, err = l.r.Read(); err == io.EOF {
+				break
+			}
+			if err != nil {
+				return nil, err
+			}
+		}
+		line := l.r.line
+		reject := func(column, reason string) error {
+			rejected := RejectedRow{Line: line, Column: column, Reason: reason, Raw: strings.TrimSuffix(csvLine(row, l.comma, l.quote), "\n")}
+			if column != "" {
+				return rejectRow(options, rejected, fmt.Errorf("line %d, column %s: %s", line, column, reason))
+			}
+			return rejectRow(options, rejected, fmt.Errorf("line %d: %s", line, reason))
+		}
+
+		if len(row) != l.width {
+			if err := reject("", fmt.Sprintf("expected %d fields, got %d", l.width, len(row))); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		values := row
+		if l.pick != nil {
+			values = make([]string, len(l.pick))
+			for i, fn := range l.pick {
+				values[i] = fn(row)
+			}
+		}
+		// Convert the whole row before appending so that a bad row leaves
+		// no partial values behind
+		valid := true
+		for i, val := range values {
+			field := schema.Field(i)
+			if field.Nullable && (val == "" || val == options.NullToken) {
+				l.appends[i] = b.Field(i).AppendNull
+				continue
+			}
+			if l.appends[i], err = l.parser.parseValue(b.Field(i), val); err != nil {
+				if field.Nullable && options.schemaDrift.coerces(field.Name) {
+					l.appends[i] = b.Field(i).AppendNull
+					continue
+				}
+				if err := reject(field.Name, err.Error()); err != nil {
+					return nil, err
+				}
+				valid = false
+				break
+			}
+		}
+		if !valid {
+			continue
+		}
+		for _, appendValue := range l.appends {
+			appendValue()
+		}
+		rows++
+	}
+	return b.NewRecord(), nil
+}
+
+// parseValue parses v for the builder's type. The returned function
+// appends the value.
+func (p *valueParser) parseValue(b array.Builder, v string) (func(), error) {
+	switch b := b.(type) {
+	case *array.StringBuilder:
+		return func() { b.Append(v) }, nil
+	case *array.BinaryDictionaryBuilder:
+		return appendDictionaryString(b, v), nil
+	case *array.Int64Builder:
+		n, err := strconv.ParseInt(p.number(strings.TrimSpace(v)), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid int64: %s", v)
+		}
+		return func() { b.Append(n) }, nil
+	case *array.Int32Builder:
+		n, err := strconv.ParseInt(p.number(strings.TrimSpace(v)), 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid int32: %s", v)
+		}
+		return func() { b.Append(int32(n)) }, nil
+	case *array.Float64Builder:
+		n, err := strconv.ParseFloat(p.number(strings.TrimSpace(v)), 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid float64: %s", v)
+		}
+		return func() { b.Append(n) }, nil
+	case *array.BooleanBuilder:
+		t, err := strconv.ParseBool(strings.TrimSpace(v))
+		if err != nil {
+			return nil, fmt.Errorf("invalid boolean: %s", v)
+		}
+		return func() { b.Append(t) }, nil
+	case *array.TimestampBuilder:
+		tm, err := p.time(strings.TrimSpace(v))
+		if err != nil {
+			return nil, err
+		}
+		ts, err := arrow.TimestampFromTime(tm, b.Type().(*arrow.TimestampType).Unit)
+		if err != nil {
+			return nil, err
+		}
+		return func() { b.Append(ts) }, nil
+	case *array.Decimal128Builder:
+		n, err := parseDecimal(p.number(strings.TrimSpace(v)), b.Type().(*arrow.Decimal128Type))
+		if err != nil {
+			return nil, err
+		}
+		return func() { b.Append(n) }, nil
+	case *array.Date32Builder:
+		tm, err := p.date(strings.TrimSpace(v))
+		if err != nil {
+			return nil, err
+		}
+		return func() { b.Append(arrow.Date32FromTime(tm)) }, nil
+	case *array.Date64Builder:
+		tm, err := p.date(strings.TrimSpace(v))
+		if err != nil {
+			return nil, err
+		}
+		return func() { b.Append(arrow.Date64FromTime(tm)) }, nil
+	case *array.Time32Builder:
+		tod, err := parseTimeOfDay(strings.TrimSpace(v))
+		if err != nil {
+			return nil, err
+		}
+		t := arrow.Time32(tod / b.Type().(*arrow.Time32Type).Unit.Multiplier())
+		return func() { b.Append(t) }, nil
+	case *array.Time64Builder:
+		tod, err := parseTimeOfDay(strings.TrimSpace(v))
+		if err != nil {
+			return nil, err
+		}
+		t := arrow.Time64(tod / b.Type().(*arrow.Time64Type).Unit.Multiplier())
+		return func() { b.Append(t) }, nil
+	case *array.DurationBuilder:
+		unit := b.Type().(*arrow.DurationType).Unit
+		d, err := parseDuration(strings.TrimSpace(v), unit)
+		if err != nil {
+			return nil, err
+		}
+		return func() { b.Append(arrow.Duration(d / unit.Multiplier())) }, nil
+	case *array.StructBuilder:
+		if !arrow.TypeEqual(b.Type(), geo.PointType()) {
+			return parseNestedValue(b, v)
+		}
+		pt, err := parsePoint(v)
+		if err != nil {
+			return nil, err
+		}
+		return func() { appendPoint(b, pt) }, nil
+	case *array.FixedSizeListBuilder:
+		if _, ok := vectorDim(b.Type()); !ok {
+			return parseNestedValue(b, v)
+		}
+		vec, err := parseVector(v)
+		if err != nil {
+			return nil, err
+		}
+		if dim, ok := vectorDim(b.Type()); !ok || dim != len(vec) {
+			return nil, fmt.Errorf("invalid %s: %d values", b.Type(), len(vec))
+		}
+		return func() {
+			b.Append(true)
+			b.ValueBuilder().(*array.Float32Builder).AppendValues(vec, nil)
+		}, nil
+	case *array.ListBuilder, *array.LargeListBuilder, *array.MapBuilder:
+		return parseNestedValue(b, v)
+	default:
+		return nil, fmt.Errorf("unsupported type %s", b.Type())
+	}
+}
+
+// parseNestedValue parses a struct, list or map written as JSON, e.g.
+// {"city":"Oslo"}, ["a","b"] or [{"key":"env","value":"prod"}]
+func parseNestedValue(b array.Builder, v string) (func(), error) {
+	arr, _, err := array.FromJSON(memory.DefaultAllocator, b.Type(), strings.NewReader("["+v+"]"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", b.Type(), err)
+	}
+	defer arr.Release()
+	if arr.Len() != 1 {
+		return nil, fmt.Errorf("invalid %s: expected one value", b.Type())
+	}
+	return func() { b.AppendValueFromString(v) }, nil
+}
+
+// time parses v as RFC 3339 or one of the configured date formats
+func (p *valueParser) time(v string) (time.Time, error) {
+	if tm, err := time.Parse(time.RFC3339, v); err == nil {
+		return tm, nil
+	}
+	for _, layout := range p.dateFormats {
+		if tm, err := time.Parse(layout, v); err == nil {
+			return tm, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid timestamp: %s", v)
+}
+
+// writeCSV writes rec with a header row in the configured dialect. Nulls
+// are written as the null token, timestamps in the first date format,
+// RFC 3339 by default, and other values as formatValue does.
+func writeCSV(w io.Writer, rec arrow.Record, options *Options) error {
+	cw, err := newCSVWriter(w, rec.Schema(), options)
+	if err != nil {
+		return err
+	}
+	if err := cw.Write(rec); err != nil {
+		return err
+	}
+	return cw.Close()
+}
+
+// csvWriter writes records as CSV in the configured dialect, the header
+// row first, a batch at a time
+type csvWriter struct {
+	bw          *bufio.Writer
+	comma       rune
+	quote       rune
+	layout      string
+	nullToken   string
+	wroteHeader bool
+	schema      *arrow.Schema
+}
+
+func newCSVWriter(w io.Writer, schema *arrow.Schema, options *Options) (*csvWriter, error) {
+	comma, quote := options.Delimiter, options.Quote
+	if comma == 0 {
+		comma = ','
+	}
+	if quote == 0 {
+		quote = '"'
+	}
+	if comma == quote || strings.ContainsRune("\r\n", comma) || strings.ContainsRune("\r\n", quote) {
+		return nil, fmt.Errorf("invalid CSV dialect: delimiter %q, quote %q", comma, quote)
+	}
+	layout := time.RFC3339
+	if len(options.DateFormats) > 0 {
+		layout = options.DateFormats[0]
+	}
+
+	bw := bufio.NewWriter(w)
+	if options.BOM {
+		bw.Write(utf8BOM)
+	}
+	return &csvWriter{bw: bw, comma: comma, quote: quote, layout: layout, nullToken: options.NullToken, schema: schema}, nil
+}
+
+func (cw *csvWriter) field(i int, s string) {
+	if i > 0 {
+		cw.bw.WriteRune(cw.comma)
+	}
+	cw.bw.WriteString(csvField(s, cw.comma, cw.quote))
+}
+
+// Write writes the rows of rec, after the header row on the first call
+func (cw *csvWriter) Write(rec arrow.Record) error {
+	cw.header()
+	for row := 0; row < int(rec.NumRows()); row++ {
+		for i, col := range rec.Columns() {
+			switch c := col.(type) {
+			case *array.Timestamp:
+				if c.IsNull(row) {
+					cw.field(i, cw.nullToken)
+					continue
+				}
+				unit := c.DataType().(*arrow.TimestampType).Unit
+				cw.field(i, c.Value(row).ToTime(unit).Format(cw.layout))
+			default:
+				if col.IsNull(row) {
+					cw.field(i, cw.nullToken)
+					continue
+				}
+				cw.field(i, formatValue(col, row))
+			}
+		}
+		cw.bw.WriteString("\n")
+	}
+	return nil
+}
+
+// Close writes the header row if no rows were written and flushes
+func (cw *csvWriter) Close() error {
+	cw.header()
+	return cw.bw.Flush()
+}
+
+func (cw *csvWriter) header() {
+	if cw.wroteHeader {
+		return
+	}
+	for i, f := range cw.schema.Fields() {
+		cw.field(i, f.Name)
+	}
+	cw.bw.WriteString("\n")
+	cw.wroteHeader = true
+}
+
+// csvField quotes s if it contains the delimiter, the quote or a newline
+func csvField(s string, comma, quote rune) string {
+	if !strings.ContainsAny(s, string([]rune{comma, quote, '\r', '\n'})) {
+		return s
+	}
+	q := string(quote)
+	return q + strings.ReplaceAll(s, q, q+q) + q
+}
+
+// csvLine encodes fields as one CSV record ending in a newline
+func csvLine(fields []string, comma, quote rune) string {
+	quoted := make([]string, len(fields))
+	for i, f := range fields {
+		quoted[i] = csvField(f, comma, quote)
+	}
+	return strings.Join(quoted, string(comma)) + "\n"
+}
+
+// csvColumnNames names the columns of header-less CSV column_1, column_2, ...
+func csvColumnNames(n int) []string {
+	names := make([]string, n)
+	for i := range names {
+		names[i] = fmt.Sprintf("column_%d", i+1)
+	}
+	return names
+}
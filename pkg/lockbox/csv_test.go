@@ -0,0 +1,69 @@
+package lockbox
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/apache/arrow-go/v18/arrow"
+	"github.com/apache/arrow-go/v18/arrow/array"
+	"github.com/apache/arrow-go/v18/arrow/memory"
+)
+
+func TestCSVDialectRoundTrip(t *testing.T) {
+	schema := arrow.NewSchema([]arrow.Field{
+		{Name: "id", Type: arrow.PrimitiveTypes.Int64},
+		{Name: "note", Type: arrow.BinaryTypes.String, Nullable: true},
+		{Name: "day", Type: arrow.FixedWidthTypes.Timestamp_s, Nullable: true},
+	}, nil)
+	b := array.NewRecordBuilder(memory.NewGoAllocator(), schema)
+	defer b.Release()
+	b.Field(0).(*array.Int64Builder).AppendValues([]int64{1, 2}, nil)
+	b.Field(1).(*array.StringBuilder).AppendValues([]string{"it's; here", ""}, []bool{true, false})
+	day := time.Date(2024, 12, 31, 0, 0, 0, 0, time.UTC)
+	b.Field(2).(*array.TimestampBuilder).AppendValues([]arrow.Timestamp{arrow.Timestamp(day.Unix()), 0}, []bool{true, false})
+	rec := b.NewRecord()
+	defer rec.Release()
+
+	opts := []Option{WithDelimiter(';'), WithQuote('\''), WithNullToken("NA"), WithDateFormats("02.01.2006"), WithBOM()}
+	var buf bytes.Buffer
+	if err := ExportRecord(&buf, rec, ExportCSV, opts...); err != nil {
+		t.Fatalf("export: %v", err)
+	}
+	want := "\xEF\xBB\xBFid;note;day\n1;'it''s; here';31.12.2024\n2;NA;NA\n"
+	if buf.String() != want {
+		t.Fatalf("unexpected csv %q", buf.String())
+	}
+
+	path := filepath.Join(t.TempDir(), "out.csv")
+	if err := os.WriteFile(path, buf.Bytes(), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	detected, err := DetectCSVSchema(path, 10, opts...)
+	if err != nil {
+		t.Fatalf("detect: %v", err)
+	}
+	if detected.Field(0).Name != "id" || !detected.Field(1).Nullable || detected.Field(2).Type.ID() != arrow.TIMESTAMP {
+		t.Fatalf("unexpected detected schema %s", detected)
+	}
+
+	loaded, err := LoadCSV(path, schema, opts...)
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	defer loaded.Release()
+	if !array.RecordEqual(rec, loaded) {
+		t.Fatalf("round trip mismatch:\n%v\n%v", rec, loaded)
+	}
+
+	// Non-nullable columns reject the null token
+	bad := filepath.Join(t.TempDir(), "bad.csv")
+	os.WriteFile(bad, []byte("id,note,day\nNA,x,\n"), 0600)
+	if _, err := LoadCSV(bad, schema, WithNullToken("NA")); err == nil || !strings.Contains(err.Error(), "line 2, column id") {
+		t.Fatalf("expected error for null in id, got %v", err)
+	}
+}
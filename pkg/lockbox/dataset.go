@@ -0,0 +1,84 @@
+package lockbox
+
+import (
+	"fmt"
+
+	"github.com/apache/arrow-go/v18/arrow"
+)
+
+// Dataset exposes one or more lockboxes with the same schema as a single
+// scannable dataset, following the Arrow dataset model: a dataset is made
+// of fragments, one per lockbox, and is read through a Scanner that pushes
+// the projection and filter down to each fragment. A Scanner is an
+// array.RecordReader, so it plugs into code written against Arrow's record
+// streaming interfaces.
+type Dataset struct {
+	fragments []*Fragment
+	schema    *arrow.Schema
+	opts      []Option
+}
+
+// Fragment is the part of a Dataset stored in one lockbox
+type Fragment struct {
+	lb *Lockbox
+}
+
+// ScanOptions selects what a Scanner reads
+type ScanOptions struct {
+	// Columns is the projection; empty selects every stored and virtual
+	// column. Only the stored columns needed for the projection and the
+	// filter are decrypted.
+	Columns []string
+	// Filter is an expression rows must satisfy, e.g. "age >= 18 and
+	// country = 'NL'". It is evaluated right after decryption, before the
+	// projection is built, and may use columns that are not projected.
+	Filter string
+	// BatchSize bounds the number of rows per returned record. Zero
+	// returns each stored batch, i.e. the rows of one write, as one record.
+	BatchSize int
+}
+
+// NewDataset builds a dataset over lockboxes, which must have the same
+// stored schema. opts are used for every scan, typically WithPassword or
+// WithColumnKey and WithPrincipal; the lockboxes stay owned by the caller.
+func NewDataset(lockboxes []*Lockbox, opts ...Option) (*Dataset, error) {
+	if len(lockboxes) == 0 {
+		return nil, fmt.Errorf("dataset needs at least one lockbox")
+	}
+	d := &Dataset{schema: lockboxes[0].Schema(), opts: opts}
+	for _, lb := range lockboxes {
+		if err := compatibleSchemas(d.schema, lb.Schema()); err != nil {
+			return nil, fmt.Errorf("%s: %w", lb.Path(), err)
+		}
+		d.fragments = append(d.fragments, &Fragment{lb: lb})
+	}
+	return d, nil
+}
+
+// Schema returns the stored schema shared by the fragments
+func (d *Dataset) Schema() *arrow.Schema {
+	return d.schema
+}
+
+// Fragments returns the fragments of the dataset, in the order the
+// lockboxes were given
+func (d *Dataset) Fragments() []*Fragment {
+	return d.fragments
+}
+
+// Path returns the file of the fragment
+func (f *Fragment) Path() string {
+	return f.lb.Path()
+}
+
+// NumRows returns the row count recorded in the fragment's cleartext
+// metadata, without decrypting anything
+func (f *Fragment) NumRows() int64 {
+	return f.lb.file.Metadata().LiveRows()
+}
+
+// NumBatches returns the number of stored record batches, i.e. row groups,
+// one per write, from the fragment's cleartext metadata
+func (f *Fragment) NumBatches() int {
+	return f.lb.file.Metadata().NumRowGroups()
+}
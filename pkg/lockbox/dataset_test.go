@@ -0,0 +1,85 @@
+package lockbox
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/apache/arrow-go/v18/arrow/array"
+)
+
+func TestDatasetScanner(t *testing.T) {
+	fileA := "/tmp/test_lockbox_dataset_a.lbx"
+	fileB := "/tmp/test_lockbox_dataset_b.lbx"
+	defer os.Remove(fileA)
+	defer os.Remove(fileB)
+
+	password := "test_password_123"
+	a := newQueryTestLockbox(t, fileA, password)
+	defer a.Close()
+	b := newQueryTestLockbox(t, fileB, password)
+	defer b.Close()
+
+	ds, err := NewDataset([]*Lockbox{a, b}, WithPassword(password))
+	if err != nil {
+		t.Fatalf("NewDataset error: %v", err)
+	}
+	if len(ds.Fragments()) != 2 || ds.Fragments()[1].NumRows() != 3 {
+		t.Fatalf("unexpected fragments")
+	}
+
+	ctx := context.Background()
+	reader, err := ds.NewScanner(ctx, ScanOptions{Columns: []string{"name"}, Filter: "score > 20", BatchSize: 1})
+	if err != nil {
+		t.Fatalf("NewScanner error: %v", err)
+	}
+	defer reader.Release()
+
+	if reader.Schema().NumFields() != 1 || reader.Schema().Field(0).Name != "name" {
+		t.Fatalf("expected only the projected column, got %s", reader.Schema())
+	}
+	var names []string
+	for reader.Next() {
+		rec := reader.Record()
+		if rec.NumRows() > 1 {
+			t.Errorf("batch of %d rows exceeds the batch size", rec.NumRows())
+		}
+		col := rec.Column(0).(*array.String)
+		for i := 0; i < col.Len(); i++ {
+			names = append(names, col.Value(i))
+		}
+	}
+	if err := reader.Err(); err != nil {
+		t.Fatalf("scan error: %v", err)
+	}
+	want := []string{"bob", "carol", "bob", "carol"}
+	if len(names) != len(want) {
+		t.Fatalf("expected %v, got %v", want, names)
+	}
+	for i := range want {
+		if names[i] != want[i] {
+			t.Fatalf("expected %v, got %v", want, names)
+		}
+	}
+
+	all, err := ds.NewScanner(ctx, ScanOptions{})
+	if err != nil {
+		t.Fatalf("NewScanner error: %v", err)
+	}
+	defer all.Release()
+	table, err := all.ToTable()
+	if err != nil {
+		t.Fatalf("ToTable error: %v", err)
+	}
+	defer table.Release()
+	if table.NumRows() != 6 || table.NumCols() != 3 {
+		t.Errorf("expected 6 rows and 3 columns, got %d and %d", table.NumRows(), table.NumCols())
+	}
+
+	if _, err := ds.NewScanner(ctx, ScanOptions{Columns: []string{"missing"}}); err == nil {
+		t.Errorf("expected error for an unknown column")
+	}
+	if _, err := ds.NewScanner(ctx, ScanOptions{Filter: "score >"}); err == nil {
+		t.Errorf("expected error for an invalid filter")
+	}
+}
@@ -0,0 +1,201 @@
+package lockbox
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"unicode"
+
+	"github.com/TFMV/lockbox/pkg/metadata"
+	"github.com/rs/zerolog/log"
+)
+
+// Delete deletes the rows matching a condition in the syntax of a query's
+// WHERE clause, e.g. Delete(ctx, "WHERE status = 'void'"); the WHERE
+// keyword may be left out. Rows are not rewritten: they are marked in a
+// tombstone bitmap of their row group, which every read skips, until
+// Compact removes them from the blocks. The delete is committed as a
+// snapshot that takes WithMessage and WithLineage. It returns the number of
+// rows deleted; when none match nothing is committed.
+func (lb *Lockbox) Delete(ctx context.Context, where string, opts ...Option) (int64, error) {
+	options := &Options{}
+	for _, opt := range opts {
+		opt(options)
+	}
+	if options.Password == "" {
+		return 0, fmt.Errorf("password is required for writing")
+	}
+	if _, err := lb.checkPolicy(options.Principal, ActionWrite); err != nil {
+		return 0, err
+	}
+
+	cond := strings.TrimSpace(where)
+	if len(cond) < 6 || !strings.EqualFold(cond[:5], "WHERE") || !unicode.IsSpace(rune(cond[5])) {
+		cond = "WHERE " + cond
+	}
+	pq, err := parseQuery("SELECT * FROM data " + cond)
+	if err != nil {
+		return 0, fmt.Errorf("invalid condition: %w", err)
+	}
+	if pq.Where == nil || pq.OrderCol != "" || pq.OrderVec != nil || pq.Limit > 0 || pq.Offset > 0 || pq.Sample != nil {
+		return 0, fmt.Errorf("invalid condition %q: expected only a WHERE clause", where)
+	}
+
+	qe, err := lb.newQueryExec(ctx, options)
+	if err != nil {
+		return 0, err
+	}
+	// Rows the policy hides from the principal could not be matched
+	if qe.policy != nil {
+		return 0, fmt.Errorf("delete needs unfiltered, unmasked read access for %s", qe.policy.principal)
+	}
+	if err := qe.resolveSubqueries(pq.Where); err != nil {
+		return 0, err
+	}
+	required := pq.Where.columns(nil)
+	stored, virtual, err := planVirtualColumns(qe.meta, required)
+	if err != nil {
+		return 0, err
+	}
+	stored = nestedRoots(qe.meta.Schema, stored)
+	if err := checkColumns(qe.meta.Schema, stored); err != nil {
+		return 0, err
+	}
+
+	rows := make(map[int][]int)
+	for n := 0; n < qe.meta.NumRowGroups(); n++ {
+		if err := ctx.Err(); err != nil {
+			return 0, err
+		}
+		matched, err := qe.matchRowGroup(n, stored, virtual, required, pq.Where)
+		if err != nil {
+			return 0, err
+		}
+		if len(matched) > 0 {
+			rows[n] = matched
+		}
+	}
+	if len(rows) == 0 {
+		return 0, nil
+	}
+
+	options.operation = OperationDelete
+	if options.Lineage == nil {
+		options.Lineage = &metadata.Lineage{}
+	}
+	if options.Lineage.Transformation == "" {
+		options.Lineage.Transformation = "DELETE " + cond
+	}
+	writer, err := lb.prepareWriter(options)
+	if err != nil {
+		return 0, err
+	}
+	deleted, err := writer.DeleteRows(rows)
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete rows: %w", err)
+	}
+
+	if err := lb.refreshMaterializedViews(options.Password, ""); err != nil {
+		return 0, fmt.Errorf("failed to refresh materialized views: %w", err)
+	}
+	lb.notifyCommit(ctx)
+	log.Debug().Str("condition", cond).Int64("rows", deleted).Msg("Deleted rows")
+	return deleted, nil
+}
+
+// matchRowGroup returns the positions among the stored rows of row group n,
+// deleted or not, of the live rows matching where
+func (qe *queryExec) matchRowGroup(n int, stored []string, virtual []metadata.VirtualColumn, required []string, where *wherePredicate) ([]int, error) {
+	rec, err := qe.reader.ReadRowGroup(n, stored)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read row group %d: %w", n, err)
+	}
+	if len(virtual) > 0 {
+		withVirtual, err := addVirtualColumns(qe.mem, rec, virtual)
+		rec.Release()
+		if err != nil {
+			return nil, err
+		}
+		rec = withVirtual
+	}
+	nested, err := withNestedColumns(qe.mem, rec, required)
+	rec.Release()
+	if err != nil {
+		return nil, err
+	}
+	defer nested.Release()
+	match, err := where.bind(nested)
+	if err != nil {
+		return nil, err
+	}
+
+	return storedPositions(qe.meta, n, int(nested.NumRows()), match), nil
+}
+
+// storedPositions returns the positions among the stored rows of row group
+// n, deleted or not, of the live rows for which match is true. Reads skip
+// deleted rows, so the live rows read are numbered 0 to live-1 in order.
+func storedPositions(meta *metadata.Metadata, n, live int, match func(row int) bool) []int {
+	t, _ := meta.FindTombstone(n)
+	var matched []int
+	pos := 0
+	for row := 0; row < live; row++ {
+		for t != nil && t.Deleted(pos) {
+			pos++
+		}
+		if match(row) {
+			matched = append(matched, pos)
+		}
+		pos++
+	}
+	return matched
+}
+
+// Compact rewrites the row groups that have deleted rows without them, then
+// rewrites the file without the old blocks as GC does. Snapshots are kept:
+// time travel already skips deleted rows, so they read the same rows as
+// before. The change is committed as a snapshot. It returns the number of
+// rows removed; when no rows are deleted nothing is done.
+func (lb *Lockbox) Compact(ctx context.Context, opts ...Option) (int64, error) {
+	options := &Options{}
+	for _, opt := range opts {
+		opt(options)
+	}
+	if options.Password == "" {
+		return 0, fmt.Errorf("password is required for compacting")
+	}
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	if _, err := lb.checkPolicy(options.Principal, ActionWrite); err != nil {
+		return 0, err
+	}
+	if lb.file.Metadata().DeletedRows() == 0 {
+		return 0, nil
+	}
+
+	options.operation = OperationCompact
+	writer, err := lb.prepareWriter(options)
+	if err != nil {
+		return 0, err
+	}
+	removed, err := writer.Compact()
+	if err != nil {
+		return 0, fmt.Errorf("failed to compact: %w", err)
+	}
+	if err := lb.refreshMaterializedViews(options.Password, ""); err != nil {
+		return removed, fmt.Errorf("failed to refresh materialized views: %w", err)
+	}
+	reclaimed, err := lb.file.Reclaim()
+	if err != nil {
+		return removed, fmt.Errorf("failed to reclaim space: %w", err)
+	}
+
+	lb.notifyCommit(ctx)
+	lb.notify(ctx, Event{Event: metadata.EventCompact, Operation: OperationCompact, Stats: map[string]int64{
+		"removedRows":    removed,
+		"reclaimedBytes": reclaimed,
+	}})
+	log.Info().Int64("rows", removed).Int64("reclaimed", reclaimed).Msg("Compacted deleted rows")
+	return removed, nil
+}
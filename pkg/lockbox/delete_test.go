@@ -0,0 +1,146 @@
+package lockbox
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/apache/arrow-go/v18/arrow"
+	"github.com/apache/arrow-go/v18/arrow/array"
+)
+
+func TestDeleteAndCompact(t *testing.T) {
+	password := "test_password_123"
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "delete.lbx")
+
+	schema := arrow.NewSchema([]arrow.Field{
+		{Name: "id", Type: arrow.PrimitiveTypes.Int64},
+		{Name: "name", Type: arrow.BinaryTypes.String, Nullable: true},
+		{Name: "score", Type: arrow.PrimitiveTypes.Float64, Nullable: true},
+	}, nil)
+	lb, err := Create(path, schema, WithPassword(password))
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	defer func() { lb.Close() }()
+	for _, ids := range [][]int64{{1, 2, 3, 4, 5}, {6, 7, 8, 9, 10}} {
+		names := make([]string, len(ids))
+		scores := make([]float64, len(ids))
+		for i, id := range ids {
+			names[i] = string(rune('a' + id - 1))
+			scores[i] = float64(id)
+		}
+		rec := diffTestRecord(ids, names, nil, scores)
+		err := lb.Write(ctx, rec, WithPassword(password))
+		rec.Release()
+		if err != nil {
+			t.Fatalf("write: %v", err)
+		}
+	}
+	first := lb.Snapshots()[0].ID
+
+	ids := func(q string, opts ...Option) []int64 {
+		t.Helper()
+		rec, err := lb.Query(ctx, q, append(opts, WithPassword(password))...)
+		if err != nil {
+			t.Fatalf("%s: %v", q, err)
+		}
+		defer rec.Release()
+		return append([]int64(nil), rec.Column(0).(*array.Int64).Int64Values()...)
+	}
+	equal := func(got, want []int64) bool {
+		if len(got) != len(want) {
+			return false
+		}
+		for i := range got {
+			if got[i] != want[i] {
+				return false
+			}
+		}
+		return true
+	}
+
+	if n, err := lb.Delete(ctx, "WHERE id <= 2 OR name = 'g'", WithPassword(password), WithMessage("drop")); err != nil || n != 3 {
+		t.Fatalf("delete: %d, %v", n, err)
+	}
+	// Rows already deleted are not matched again, and without a match
+	// nothing is committed
+	snaps := len(lb.Snapshots())
+	if n, err := lb.Delete(ctx, "id = 2", WithPassword(password)); err != nil || n != 0 {
+		t.Fatalf("delete again: %d, %v", n, err)
+	}
+	if len(lb.Snapshots()) != snaps {
+		t.Errorf("expected nothing to be committed")
+	}
+	if n, err := lb.Delete(ctx, "id = 3 OR id = 8", WithPassword(password)); err != nil || n != 2 {
+		t.Fatalf("delete around deleted rows: %d, %v", n, err)
+	}
+	last := lb.Snapshots()[len(lb.Snapshots())-1]
+	if last.Operation != OperationDelete || last.Lineage == nil || last.Lineage.Transformation != "DELETE WHERE id = 3 OR id = 8" {
+		t.Errorf("unexpected snapshot %+v", last)
+	}
+
+	live := []int64{4, 5, 6, 9, 10}
+	if got := ids("SELECT id FROM data ORDER BY id"); !equal(got, live) {
+		t.Errorf("expected %v, got %v", live, got)
+	}
+	if got := ids("SELECT id FROM data ORDER BY id", WithSinceSnapshot(first)); !equal(got, []int64{6, 9, 10}) {
+		t.Errorf("expected the live rows of the second write, got %v", got)
+	}
+	info, err := lb.Info()
+	if err != nil {
+		t.Fatalf("info: %v", err)
+	}
+	if info.RowCount != 5 || info.DeletedRows != 5 {
+		t.Errorf("expected 5 rows and 5 deleted, got %d and %d", info.RowCount, info.DeletedRows)
+	}
+
+	// Columns are rewritten from the live rows
+	if _, err := lb.Compute(ctx, "score", "id * 10", WithPassword(password)); err != nil {
+		t.Fatalf("compute: %v", err)
+	}
+	if got := ids("SELECT id FROM data WHERE score = 90"); !equal(got, []int64{9}) {
+		t.Errorf("expected score 90 for id 9 only, got %v", got)
+	}
+
+	for _, cond := range []string{"", "ORDER BY id", "WHERE id = 1 LIMIT 1", "WHERE missing = 1"} {
+		if _, err := lb.Delete(ctx, cond, WithPassword(password)); err == nil {
+			t.Errorf("%q: expected an error", cond)
+		}
+	}
+
+	before, _ := os.Stat(path)
+	if n, err := lb.Compact(ctx, WithPassword(password)); err != nil || n != 5 {
+		t.Fatalf("compact: %d, %v", n, err)
+	}
+	if after, _ := os.Stat(path); after.Size() >= before.Size() {
+		t.Errorf("expected the file to shrink from %d bytes, got %d", before.Size(), after.Size())
+	}
+	if n, err := lb.Compact(ctx, WithPassword(password)); err != nil || n != 0 {
+		t.Errorf("expected nothing left to compact, got %d, %v", n, err)
+	}
+	lb.Close()
+
+	lb, err = Open(path, WithPassword(password))
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	if info, err = lb.Info(); err != nil || info.RowCount != 5 || info.DeletedRows != 0 {
+		t.Fatalf("expected 5 rows and none deleted, got %+v, %v", info, err)
+	}
+	if got := ids("SELECT id FROM data ORDER BY id"); !equal(got, live) {
+		t.Errorf("expected %v after compaction, got %v", live, got)
+	}
+	// Earlier snapshots still end at their row groups
+	if got := ids("SELECT id FROM data ORDER BY id", WithSinceSnapshot(first)); !equal(got, []int64{6, 9, 10}) {
+		t.Errorf("expected the rows of the second write after compaction, got %v", got)
+	}
+	if s := lb.Snapshots()[0]; s.TotalRows != 2 {
+		t.Errorf("expected the first snapshot to be recounted to 2 rows, got %d", s.TotalRows)
+	}
+	if err := lb.Validate(); err != nil {
+		t.Errorf("validate: %v", err)
+	}
+}
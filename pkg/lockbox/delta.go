@@ -0,0 +1,480 @@
+package lockbox
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/apache/arrow-go/v18/arrow"
+	"github.com/apache/arrow-go/v18/parquet"
+	"github.com/apache/arrow-go/v18/parquet/compress"
+	"github.com/apache/arrow-go/v18/parquet/pqarrow"
+	"github.com/rs/zerolog/log"
+)
+
+// deltaNullPartition is the directory name Hive-style tables use for a null
+// partition value
+const deltaNullPartition = "__HIVE_DEFAULT_PARTITION__"
+
+// DeltaExport describes a snapshot written to a Delta Lake table by
+// ExportDelta
+type DeltaExport struct {
+	Table      string   `json:"table"`
+	Version    int64    `json:"version"` // the Delta table version committed
+	Snapshot   int64    `json:"snapshot"`
+	Rows       int64    `json:"rows"`
+	Files      []string `json:"files"` // relative to Table
+	Partitions int      `json:"partitions"`
+}
+
+// WithPartitionBy makes ExportDelta partition the table by the given
+// columns, in Hive-style col=value directories
+func WithPartitionBy(columns ...string) Option {
+	return func(o *Options) {
+		o.PartitionBy = columns
+	}
+}
+
+// ExportDelta decrypts the current snapshot and writes it to dir as a Delta
+// Lake table: snappy-compressed Parquet data files plus a _delta_log commit
+// that lakehouse engines (Spark, Trino, DuckDB, Databricks) read directly.
+// A new table is created with the lockbox schema; when dir already holds a
+// Delta table with the same schema and partitioning, the rows are appended
+// as its next version. WithColumns limits the exported columns and the
+// access policy for WithPrincipal applies, so only what the principal may
+// read is declassified. The commit is written with an exclusive create, so
+// concurrent exports to the same table cannot overwrite each other.
+func (lb *Lockbox) ExportDelta(ctx context.Context, dir string, opts ...Option) (*DeltaExport, error) {
+	options := &Options{}
+	for _, opt := range opts {
+		opt(options)
+	}
+
+	qe, err := lb.newQueryExec(ctx, options)
+	if err != nil {
+		return nil, err
+	}
+	pq := &parsedQuery{From: "data", Limit: -1}
+	if len(options.Columns) > 0 {
+		pq.SelectCols = options.Columns
+		pq.SelectAs = make([]string, len(options.Columns))
+	}
+	rec, err := qe.execSelect(pq)
+	if err != nil {
+		return nil, err
+	}
+	defer rec.Release()
+
+	for _, col := range options.PartitionBy {
+		idx := rec.Schema().FieldIndices(col)
+		if len(idx) == 0 {
+			return nil, fmt.Errorf("partition column %s not found", col)
+		}
+		if !deltaPartitionable(rec.Schema().Field(idx[0]).Type) {
+			return nil, fmt.Errorf("cannot partition by %s of type %s", col, rec.Schema().Field(idx[0]).Type)
+		}
+	}
+	if len(options.PartitionBy) == rec.Schema().NumFields() {
+		return nil, fmt.Errorf("at least one column must not be a partition column")
+	}
+
+	schemaString, err := deltaSchemaString(rec.Schema())
+	if err != nil {
+		return nil, err
+	}
+
+	logDir := filepath.Join(dir, "_delta_log")
+	version, table, err := latestDeltaVersion(logDir)
+	if err != nil {
+		return nil, err
+	}
+	if table != nil {
+		if table.SchemaString != schemaString {
+			return nil, fmt.Errorf("%s has a different schema", dir)
+		}
+		if strings.Join(table.PartitionColumns, ",") != strings.Join(options.PartitionBy, ",") {
+			return nil, fmt.Errorf("%s is partitioned by %v, not %v", dir, table.PartitionColumns, options.PartitionBy)
+		}
+	}
+	version++
+
+	if err := os.MkdirAll(logDir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create table directory: %w", err)
+	}
+
+	snapshot := int64(0)
+	if snaps := lb.Snapshots(); len(snaps) > 0 {
+		snapshot = snaps[len(snaps)-1].ID
+	}
+	result := &DeltaExport{Table: dir, Version: version, Snapshot: snapshot, Rows: rec.NumRows()}
+
+	var actions []map[string]any
+	now := time.Now().UnixMilli()
+	if table == nil {
+		id, err := newUUID()
+		if err != nil {
+			return nil, err
+		}
+		actions = append(actions,
+			map[string]any{"protocol": map[string]any{"minReaderVersion": 1, "minWriterVersion": 2}},
+			map[string]any{"metaData": map[string]any{
+				"id":               id,
+				"format":           map[string]any{"provider": "parquet", "options": map[string]string{}},
+				"schemaString":     schemaString,
+				"partitionColumns": append([]string{}, options.PartitionBy...),
+				"configuration":    map[string]string{},
+				"createdTime":      now,
+			}},
+		)
+	}
+
+	groups := partitionRows(rec, options.PartitionBy)
+	result.Partitions = len(groups)
+	for _, g := range groups {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		add, err := writeDeltaFile(lb, dir, rec, options.PartitionBy, g)
+		if err != nil {
+			return nil, err
+		}
+		result.Files = append(result.Files, add["path"].(string))
+		actions = append(actions, map[string]any{"add": add})
+	}
+
+	mode := "Append"
+	if table == nil {
+		mode = "ErrorIfExists"
+	}
+	partitionBy, _ := json.Marshal(append([]string{}, options.PartitionBy...))
+	actions = append(actions, map[string]any{"commitInfo": map[string]any{
+		"timestamp":           now,
+		"operation":           "WRITE",
+		"operationParameters": map[string]string{"mode": mode, "partitionBy": string(partitionBy)},
+		"isBlindAppend":       true,
+		"engineInfo":          "lockbox",
+		"userMetadata":        fmt.Sprintf("lockbox %s snapshot %d", filepath.Base(lb.Path()), snapshot),
+	}})
+
+	if err := writeDeltaCommit(logDir, version, actions); err != nil {
+		return nil, err
+	}
+
+	log.Info().
+		Str("file", lb.Path()).
+		Str("table", dir).
+		Int64("version", version).
+		Int64("rows", result.Rows).
+		Int("files", len(result.Files)).
+		Msg("Exported snapshot to Delta table")
+	return result, nil
+}
+
+// deltaTable is the part of a Delta metaData action ExportDelta checks
+// before appending
+type deltaTable struct {
+	SchemaString     string   `json:"schemaString"`
+	PartitionColumns []string `json:"partitionColumns"`
+}
+
+// latestDeltaVersion returns the newest commit version in logDir, -1 when
+// there is none, and the table metadata it last set
+func latestDeltaVersion(logDir string) (int64, *deltaTable, error) {
+	entries, err := os.ReadDir(logDir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return -1, nil, nil
+	}
+	if err != nil {
+		return 0, nil, fmt.Errorf("failed to read delta log: %w", err)
+	}
+
+	var versions []int64
+	for _, e := range entries {
+		name, ok := strings.CutSuffix(e.Name(), ".json")
+		if !ok || len(name) != 20 {
+			continue
+		}
+		v, err := strconv.ParseInt(name, 10, 64)
+		if err != nil {
+			continue
+		}
+		versions = append(versions, v)
+	}
+	if len(versions) == 0 {
+		return -1, nil, nil
+	}
+	sort.Slice(versions, func(i, j int) bool { return versions[i] < versions[j] })
+
+	// Later commits may change the metadata, so the newest one that sets it wins
+	var table *deltaTable
+	for _, v := range versions {
+		data, err := os.ReadFile(filepath.Join(logDir, fmt.Sprintf("%020d.json", v)))
+		if err != nil {
+			return 0, nil, fmt.Errorf("failed to read delta log: %w", err)
+		}
+		for _, line := range strings.Split(string(data), "\n") {
+			var action struct {
+				MetaData *deltaTable `json:"metaData"`
+			}
+			if line == "" || json.Unmarshal([]byte(line), &action) != nil || action.MetaData == nil {
+				continue
+			}
+			table = action.MetaData
+		}
+	}
+	if table == nil {
+		return 0, nil, fmt.Errorf("%s has commits but no table metadata", logDir)
+	}
+	return versions[len(versions)-1], table, nil
+}
+
+// writeDeltaCommit writes the actions of a commit as newline-delimited JSON
+func writeDeltaCommit(logDir string, version int64, actions []map[string]any) error {
+	path := filepath.Join(logDir, fmt.Sprintf("%020d.json", version))
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	if errors.Is(err, fs.ErrExist) {
+		return fmt.Errorf("delta table version %d was committed concurrently", version)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to create delta commit: %w", err)
+	}
+
+	enc := json.NewEncoder(f)
+	for _, a := range actions {
+		if err := enc.Encode(a); err != nil {
+			f.Close()
+			os.Remove(path)
+			return fmt.Errorf("failed to write delta commit: %w", err)
+		}
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(path)
+		return fmt.Errorf("failed to write delta commit: %w", err)
+	}
+	return nil
+}
+
+// partitionGroup is the rows of one partition and their partition values
+type partitionGroup struct {
+	values []*string // nil for a null value
+	rows   []int
+}
+
+// partitionRows groups the rows of rec by the values of the partition
+// columns, in order of first appearance
+func partitionRows(rec arrow.Record, columns []string) []*partitionGroup {
+	if len(columns) == 0 {
+		rows := make([]int, rec.NumRows())
+		for i := range rows {
+			rows[i] = i
+		}
+		return []*partitionGroup{{rows: rows}}
+	}
+
+	idx := make([]int, len(columns))
+	for i, col := range columns {
+		idx[i] = rec.Schema().FieldIndices(col)[0]
+	}
+
+	var groups []*partitionGroup
+	byKey := map[string]*partitionGroup{}
+	for row := 0; row < int(rec.NumRows()); row++ {
+		key := rowKeyOf(rec, idx, row)
+		g, ok := byKey[key]
+		if !ok {
+			g = &partitionGroup{values: make([]*string, len(idx))}
+			for i, c := range idx {
+				if !rec.Column(c).IsNull(row) {
+					v := rec.Column(c).ValueStr(row)
+					g.values[i] = &v
+				}
+			}
+			byKey[key] = g
+			groups = append(groups, g)
+		}
+		g.rows = append(g.rows, row)
+	}
+	return groups
+}
+
+// writeDeltaFile writes the rows of a partition, without the partition
+// columns, to a Parquet file and returns its add action
+func writeDeltaFile(lb *Lockbox, dir string, rec arrow.Record, partitionBy []string, g *partitionGroup) (map[string]any, error) {
+	var keep []string
+	for _, f := range rec.Schema().Fields() {
+		if !contains(partitionBy, f.Name) {
+			keep = append(keep, f.Name)
+		}
+	}
+	projected, err := projectRecord(rec, keep)
+	if err != nil {
+		return nil, err
+	}
+	defer projected.Release()
+	data := takeRows(lb.Allocator(), projected, g.rows)
+	defer data.Release()
+
+	var parts []string
+	partitionValues := map[string]*string{}
+	for i, col := range partitionBy {
+		v := deltaNullPartition
+		if g.values[i] != nil {
+			v = url.PathEscape(*g.values[i])
+		}
+		parts = append(parts, url.PathEscape(col)+"="+v)
+		partitionValues[col] = g.values[i]
+	}
+
+	id, err := randomHex(16)
+	if err != nil {
+		return nil, err
+	}
+	rel := strings.Join(append(parts, "part-00000-"+id+".c000.snappy.parquet"), "/")
+	path := filepath.Join(dir, filepath.FromSlash(rel))
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create partition directory: %w", err)
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create data file: %w", err)
+	}
+	props := parquet.NewWriterProperties(parquet.WithCompression(compress.Codecs.Snappy), parquet.WithAllocator(lb.Allocator()))
+	// Delta readers expect microsecond timestamps
+	arrowProps := pqarrow.NewArrowWriterProperties(pqarrow.WithCoerceTimestamps(arrow.Microsecond), pqarrow.WithTruncatedTimestamps(true))
+	pw, err := pqarrow.NewFileWriter(data.Schema(), f, props, arrowProps)
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("failed to create parquet writer: %w", err)
+	}
+	if err := pw.Write(data); err != nil {
+		pw.Close()
+		return nil, fmt.Errorf("failed to write parquet: %w", err)
+	}
+	if err := pw.Close(); err != nil {
+		return nil, fmt.Errorf("failed to write parquet: %w", err)
+	}
+
+	fi, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	stats, _ := json.Marshal(map[string]int64{"numRecords": data.NumRows()})
+	return map[string]any{
+		"path":             rel,
+		"partitionValues":  partitionValues,
+		"size":             fi.Size(),
+		"modificationTime": fi.ModTime().UnixMilli(),
+		"dataChange":       true,
+		"stats":            string(stats),
+	}, nil
+}
+
+// deltaPartitionable reports whether values of t can be partition values
+func deltaPartitionable(t arrow.DataType) bool {
+	switch t.ID() {
+	case arrow.STRING, arrow.LARGE_STRING, arrow.BOOL, arrow.DATE32,
+		arrow.INT8, arrow.INT16, arrow.INT32, arrow.INT64:
+		return true
+	}
+	return false
+}
+
+// deltaSchemaString converts an Arrow schema to the JSON schema Delta
+// stores in its metadata
+func deltaSchemaString(schema *arrow.Schema) (string, error) {
+	fields := make([]map[string]any, schema.NumFields())
+	for i, f := range schema.Fields() {
+		t, err := deltaType(f.Type)
+		if err != nil {
+			return "", fmt.Errorf("column %s: %w", f.Name, err)
+		}
+		fields[i] = map[string]any{"name": f.Name, "type": t, "nullable": f.Nullable, "metadata": map[string]any{}}
+	}
+	out, err := json.Marshal(map[string]any{"type": "struct", "fields": fields})
+	return string(out), err
+}
+
+func deltaType(t arrow.DataType) (any, error) {
+	switch dt := t.(type) {
+	case *arrow.DictionaryType:
+		return deltaType(dt.ValueType)
+	case *arrow.Decimal128Type:
+		return fmt.Sprintf("decimal(%d,%d)", dt.Precision, dt.Scale), nil
+	case *arrow.ListType:
+		elem, err := deltaType(dt.Elem())
+		if err != nil {
+			return nil, err
+		}
+		return map[string]any{"type": "array", "elementType": elem, "containsNull": dt.ElemField().Nullable}, nil
+	case *arrow.StructType:
+		fields := make([]map[string]any, dt.NumFields())
+		for i, f := range dt.Fields() {
+			ft, err := deltaType(f.Type)
+			if err != nil {
+				return nil, err
+			}
+			fields[i] = map[string]any{"name": f.Name, "type": ft, "nullable": f.Nullable, "metadata": map[string]any{}}
+		}
+		return map[string]any{"type": "struct", "fields": fields}, nil
+	}
+
+	switch t.ID() {
+	case arrow.BOOL:
+		return "boolean", nil
+	case arrow.INT8:
+		return "byte", nil
+	case arrow.INT16:
+		return "short", nil
+	case arrow.INT32:
+		return "integer", nil
+	case arrow.INT64:
+		return "long", nil
+	case arrow.FLOAT32:
+		return "float", nil
+	case arrow.FLOAT64:
+		return "double", nil
+	case arrow.STRING, arrow.LARGE_STRING:
+		return "string", nil
+	case arrow.BINARY, arrow.LARGE_BINARY, arrow.FIXED_SIZE_BINARY:
+		return "binary", nil
+	case arrow.DATE32, arrow.DATE64:
+		return "date", nil
+	case arrow.TIMESTAMP:
+		return "timestamp", nil
+	}
+	return nil, fmt.Errorf("type %s has no Delta Lake equivalent", t)
+}
+
+// newUUID returns a random (version 4) UUID, as used for Delta table ids
+// and synthetic keys
+func newUUID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate id: %w", err)
+	}
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	h := hex.EncodeToString(b)
+	return h[:8] + "-" + h[8:12] + "-" + h[12:16] + "-" + h[16:20] + "-" + h[20:], nil
+}
+
+// randomHex returns n random bytes in hex
+func randomHex(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate id: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
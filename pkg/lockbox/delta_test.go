@@ -0,0 +1,120 @@
+package lockbox
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/apache/arrow-go/v18/arrow/memory"
+	"github.com/apache/arrow-go/v18/parquet/file"
+	"github.com/apache/arrow-go/v18/parquet/pqarrow"
+)
+
+func TestExportDelta(t *testing.T) {
+	tmpFile := "/tmp/test_lockbox_delta.lbx"
+	defer os.Remove(tmpFile)
+
+	password := "test_password_123"
+	lb := newQueryTestLockbox(t, tmpFile, password)
+	defer lb.Close()
+
+	ctx := context.Background()
+	dir := filepath.Join(t.TempDir(), "people")
+
+	res, err := lb.ExportDelta(ctx, dir, WithPassword(password), WithPartitionBy("name"))
+	if err != nil {
+		t.Fatalf("ExportDelta error: %v", err)
+	}
+	if res.Version != 0 || res.Rows != 3 || res.Partitions != 3 || len(res.Files) != 3 {
+		t.Fatalf("unexpected export: %+v", res)
+	}
+	if !strings.HasPrefix(res.Files[0], "name=alice/") {
+		t.Errorf("expected a Hive-style partition path, got %s", res.Files[0])
+	}
+
+	actions := readDeltaCommit(t, filepath.Join(dir, "_delta_log", "00000000000000000000.json"))
+	var kinds []string
+	for _, a := range actions {
+		for k := range a {
+			kinds = append(kinds, k)
+		}
+	}
+	if strings.Join(kinds, ",") != "protocol,metaData,add,add,add,commitInfo" {
+		t.Fatalf("unexpected actions: %v", kinds)
+	}
+	var meta struct {
+		SchemaString     string   `json:"schemaString"`
+		PartitionColumns []string `json:"partitionColumns"`
+	}
+	if err := json.Unmarshal(actions[1]["metaData"], &meta); err != nil {
+		t.Fatalf("metaData: %v", err)
+	}
+	if !strings.Contains(meta.SchemaString, `"name":"score","nullable":true,"type":"double"`) || len(meta.PartitionColumns) != 1 {
+		t.Errorf("unexpected metadata: %+v", meta)
+	}
+
+	// Data files hold the non-partition columns
+	rdr, err := file.OpenParquetFile(filepath.Join(dir, res.Files[1]), false)
+	if err != nil {
+		t.Fatalf("open data file: %v", err)
+	}
+	defer rdr.Close()
+	fr, err := pqarrow.NewFileReader(rdr, pqarrow.ArrowReadProperties{}, memory.NewGoAllocator())
+	if err != nil {
+		t.Fatalf("parquet reader: %v", err)
+	}
+	table, err := fr.ReadTable(ctx)
+	if err != nil {
+		t.Fatalf("read data file: %v", err)
+	}
+	defer table.Release()
+	if table.NumRows() != 1 || table.NumCols() != 2 || table.Schema().Field(0).Name != "id" {
+		t.Errorf("unexpected data file: %d rows, schema %s", table.NumRows(), table.Schema())
+	}
+
+	// A second export appends the next version
+	again, err := lb.ExportDelta(ctx, dir, WithPassword(password), WithPartitionBy("name"))
+	if err != nil {
+		t.Fatalf("second ExportDelta error: %v", err)
+	}
+	if again.Version != 1 {
+		t.Errorf("expected version 1, got %d", again.Version)
+	}
+	if actions := readDeltaCommit(t, filepath.Join(dir, "_delta_log", "00000000000000000001.json")); len(actions) != 4 {
+		t.Errorf("expected 3 adds and commitInfo in the append, got %d actions", len(actions))
+	}
+
+	if _, err := lb.ExportDelta(ctx, dir, WithPassword(password), WithColumns("id", "name")); err == nil {
+		t.Errorf("expected error appending a different schema")
+	}
+	if _, err := lb.ExportDelta(ctx, dir, WithPassword(password)); err == nil {
+		t.Errorf("expected error appending with different partitioning")
+	}
+	if _, err := lb.ExportDelta(ctx, t.TempDir(), WithPassword(password), WithPartitionBy("score")); err == nil {
+		t.Errorf("expected error partitioning by a float column")
+	}
+}
+
+func readDeltaCommit(t *testing.T, path string) []map[string]json.RawMessage {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("open commit: %v", err)
+	}
+	defer f.Close()
+
+	var actions []map[string]json.RawMessage
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		var a map[string]json.RawMessage
+		if err := json.Unmarshal(sc.Bytes(), &a); err != nil {
+			t.Fatalf("invalid commit line %q: %v", sc.Text(), err)
+		}
+		actions = append(actions, a)
+	}
+	return actions
+}
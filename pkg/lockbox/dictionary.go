@@ -0,0 +1,73 @@
+package lockbox
+
+import (
+	"fmt"
+
+	"github.com/apache/arrow-go/v18/arrow"
+	"github.com/apache/arrow-go/v18/arrow/array"
+)
+
+// WithInternStrings makes LoadCSV and LoadJSON build string columns as
+// dictionaries, so a value repeated across rows is held in memory once.
+// Write stores such columns as plain strings unless they are also named
+// with WithDictionary.
+func WithInternStrings() Option {
+	return func(o *Options) {
+		o.InternStrings = true
+	}
+}
+
+// WithDictionary keeps the named string or binary columns dictionary
+// encoded: loaders build them as dictionaries and Write stores their blocks
+// that way, which shrinks columns with few distinct values. Reads return
+// the schema's plain type.
+func WithDictionary(columns ...string) Option {
+	return func(o *Options) {
+		o.Dictionary = append(o.Dictionary, columns...)
+	}
+}
+
+// internSchema returns schema with the string columns chosen by the
+// options replaced by dictionaries of strings
+func internSchema(schema *arrow.Schema, options *Options) *arrow.Schema {
+	keep := make(map[string]bool, len(options.Dictionary))
+	for _, c := range options.Dictionary {
+		keep[c] = true
+	}
+	if !options.InternStrings && len(keep) == 0 {
+		return schema
+	}
+	fields := schema.Fields()
+	for i, f := range fields {
+		if f.Type.ID() == arrow.STRING && (options.InternStrings || keep[f.Name]) {
+			fields[i].Type = &arrow.DictionaryType{IndexType: arrow.PrimitiveTypes.Int32, ValueType: f.Type}
+		}
+	}
+	md := schema.Metadata()
+	return arrow.NewSchema(fields, &md)
+}
+
+// checkDictionaryColumns verifies that dictionary encoding is only asked
+// for string or binary columns of the schema
+func checkDictionaryColumns(schema *arrow.Schema, columns []string) error {
+	for _, c := range columns {
+		fields, ok := schema.FieldsByName(c)
+		if !ok {
+			return fmt.Errorf("dictionary encoding for unknown column %s", c)
+		}
+		switch fields[0].Type.ID() {
+		case arrow.STRING, arrow.BINARY, arrow.LARGE_STRING, arrow.LARGE_BINARY:
+		default:
+			return fmt.Errorf("dictionary encoding is only supported for string and binary columns, %s is %s", c, fields[0].Type)
+		}
+	}
+	return nil
+}
+
+// appendDictionaryString appends v to a dictionary builder of strings
+func appendDictionaryString(b *array.BinaryDictionaryBuilder, v string) func() {
+	return func() {
+		// Appending to a string dictionary only fails for other value types
+		_ = b.AppendString(v)
+	}
+}
@@ -0,0 +1,85 @@
+package lockbox
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/apache/arrow-go/v18/arrow"
+	"github.com/apache/arrow-go/v18/arrow/array"
+)
+
+func TestDictionaryEncoding(t *testing.T) {
+	dir := t.TempDir()
+	csvPath := filepath.Join(dir, "cities.csv")
+	var sb strings.Builder
+	sb.WriteString("id,city\n")
+	cities := []string{"Berlin", "Paris", "Berlin", "", "Paris", "Berlin"}
+	for i, c := range cities {
+		sb.WriteString(string(rune('1'+i)) + "," + c + "\n")
+	}
+	if err := os.WriteFile(csvPath, []byte(sb.String()), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	schema := arrow.NewSchema([]arrow.Field{
+		{Name: "id", Type: arrow.PrimitiveTypes.Int64},
+		{Name: "city", Type: arrow.BinaryTypes.String, Nullable: true},
+	}, nil)
+
+	ctx := context.Background()
+	password := "test_password_123"
+	for _, keep := range []bool{true, false} {
+		rec, err := LoadCSV(csvPath, schema, WithInternStrings())
+		if err != nil {
+			t.Fatalf("load: %v", err)
+		}
+		dict, ok := rec.Column(1).(*array.Dictionary)
+		if !ok || dict.Dictionary().Len() != 2 || !dict.IsNull(3) {
+			t.Fatalf("expected interned city column, got %v", rec.Column(1))
+		}
+
+		path := filepath.Join(dir, "cities.lbx")
+		os.Remove(path)
+		lb, err := Create(path, schema, WithPassword(password), WithCreatedBy("tester"))
+		if err != nil {
+			t.Fatalf("create: %v", err)
+		}
+		opts := []Option{WithPassword(password)}
+		if keep {
+			opts = append(opts, WithDictionary("city"))
+		}
+		if err := lb.Write(ctx, rec, opts...); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+
+		out, err := lb.Query(ctx, "SELECT id, city FROM data WHERE city = 'Berlin'", WithPassword(password))
+		if err != nil {
+			t.Fatalf("query: %v", err)
+		}
+		if out.NumRows() != 3 || out.Column(1).DataType().ID() != arrow.STRING {
+			t.Fatalf("keep=%v: unexpected query result %v", keep, out)
+		}
+		out.Release()
+
+		all, err := lb.Read(ctx, WithPassword(password))
+		if err != nil {
+			t.Fatalf("read: %v", err)
+		}
+		col := all.Column(1).(*array.String)
+		for i, c := range cities {
+			if (c == "") != col.IsNull(i) || (c != "" && col.Value(i) != c) {
+				t.Fatalf("keep=%v row %d: expected %q, got %q", keep, i, c, col.Value(i))
+			}
+		}
+		all.Release()
+
+		if err := lb.Write(ctx, rec, WithPassword(password), WithDictionary("id")); err == nil {
+			t.Fatal("expected error for dictionary encoding an int64 column")
+		}
+		rec.Release()
+		lb.Close()
+	}
+}
@@ -0,0 +1,215 @@
+package lockbox
+
+import (
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/apache/arrow-go/v18/arrow"
+	"github.com/apache/arrow-go/v18/arrow/array"
+)
+
+// DiffKind classifies a row in a RecordDiff
+type DiffKind string
+
+// Row difference kinds
+const (
+	DiffAdded   DiffKind = "added"
+	DiffRemoved DiffKind = "removed"
+	DiffChanged DiffKind = "changed"
+)
+
+// RowChange is one row that differs between two records. RowA and RowB are
+// the row indices in the compared records, -1 when the row is absent.
+type RowChange struct {
+	Kind    DiffKind
+	Key     []string // key column values as text; all values without key columns
+	RowA    int
+	RowB    int
+	Columns []string // columns whose value changed, for DiffChanged
+}
+
+// RecordDiff is the result of DiffRecords
+type RecordDiff struct {
+	Changes []RowChange
+	Added   int
+	Removed int
+	Changed int
+}
+
+// Equal reports whether the records had no differences
+func (d *RecordDiff) Equal() bool {
+	return len(d.Changes) == 0
+}
+
+// RecordsEqual reports whether a and b have the same columns, with the same
+// names and types, and the same values in the same order. Nulls are equal to
+// nulls only and NaN is equal to NaN. Nullability and field metadata are
+// ignored.
+func RecordsEqual(a, b arrow.Record) bool {
+	if compatibleSchemas(a.Schema(), b.Schema()) != nil || a.NumRows() != b.NumRows() {
+		return false
+	}
+	for i := range a.Columns() {
+		ca, cb := a.Column(i), b.Column(i)
+		for row := 0; row < int(a.NumRows()); row++ {
+			if !valuesEqual(ca, row, cb, row) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// DiffRecords compares the rows of a and b, which must have compatible
+// schemas. Rows are matched on keyCols, which must be unique in each
+// record; a matched row whose other values differ is reported as changed.
+// Without key columns rows are matched on all their values, so a modified
+// row shows up as removed and added. Value comparison follows RecordsEqual.
+func DiffRecords(a, b arrow.Record, keyCols []string) (*RecordDiff, error) {
+	if err := compatibleSchemas(a.Schema(), b.Schema()); err != nil {
+		return nil, err
+	}
+
+	keyIdx := make([]int, len(keyCols))
+	for i, name := range keyCols {
+		idx := a.Schema().FieldIndices(name)
+		if len(idx) == 0 {
+			return nil, fmt.Errorf("key column %s not found", name)
+		}
+		keyIdx[i] = idx[0]
+	}
+	if len(keyIdx) == 0 {
+		for i := range a.Columns() {
+			keyIdx = append(keyIdx, i)
+		}
+	}
+
+	if _, err := indexRows(a, keyIdx, len(keyCols) > 0); err != nil {
+		return nil, fmt.Errorf("left record: %w", err)
+	}
+	indexB, err := indexRows(b, keyIdx, len(keyCols) > 0)
+	if err != nil {
+		return nil, fmt.Errorf("right record: %w", err)
+	}
+
+	diff := &RecordDiff{}
+	for row := 0; row < int(a.NumRows()); row++ {
+		key := rowKeyOf(a, keyIdx, row)
+		matches := indexB[key]
+		if len(matches) == 0 {
+			diff.Changes = append(diff.Changes, RowChange{Kind: DiffRemoved, Key: keyValues(a, keyIdx, row), RowA: row, RowB: -1})
+			diff.Removed++
+			continue
+		}
+		other := matches[0]
+		indexB[key] = matches[1:]
+
+		var changed []string
+		for i := range a.Columns() {
+			if !valuesEqual(a.Column(i), row, b.Column(i), other) {
+				changed = append(changed, a.ColumnName(i))
+			}
+		}
+		if len(changed) > 0 {
+			diff.Changes = append(diff.Changes, RowChange{Kind: DiffChanged, Key: keyValues(a, keyIdx, row), RowA: row, RowB: other, Columns: changed})
+			diff.Changed++
+		}
+	}
+	// Rows of b that no row of a was matched to are new
+	for row := 0; row < int(b.NumRows()); row++ {
+		if !containsInt(indexB[rowKeyOf(b, keyIdx, row)], row) {
+			continue
+		}
+		diff.Changes = append(diff.Changes, RowChange{Kind: DiffAdded, Key: keyValues(b, keyIdx, row), RowA: -1, RowB: row})
+		diff.Added++
+	}
+	return diff, nil
+}
+
+func containsInt(list []int, v int) bool {
+	for _, x := range list {
+		if x == v {
+			return true
+		}
+	}
+	return false
+}
+
+// indexRows maps the key of each row to its row indices
+func indexRows(rec arrow.Record, keyIdx []int, unique bool) (map[string][]int, error) {
+	index := make(map[string][]int, rec.NumRows())
+	for row := 0; row < int(rec.NumRows()); row++ {
+		key := rowKeyOf(rec, keyIdx, row)
+		if unique && len(index[key]) > 0 {
+			return nil, fmt.Errorf("duplicate key (%s) at rows %d and %d", strings.Join(keyValues(rec, keyIdx, row), ", "), index[key][0], row)
+		}
+		index[key] = append(index[key], row)
+	}
+	return index, nil
+}
+
+// rowKeyOf builds a comparable key from the given columns of a row
+func rowKeyOf(rec arrow.Record, cols []int, row int) string {
+	var sb strings.Builder
+	for _, i := range cols {
+		col := rec.Column(i)
+		if col.IsNull(row) {
+			sb.WriteString("\x00N")
+		} else {
+			sb.WriteString("\x00V")
+			sb.WriteString(canonicalValue(col, row))
+		}
+	}
+	return sb.String()
+}
+
+func keyValues(rec arrow.Record, cols []int, row int) []string {
+	vals := make([]string, len(cols))
+	for i, c := range cols {
+		vals[i] = rec.Column(c).ValueStr(row)
+	}
+	return vals
+}
+
+// compatibleSchemas checks that two schemas have the same field names and
+// types in the same order
+func compatibleSchemas(a, b *arrow.Schema) error {
+	if a.NumFields() != b.NumFields() {
+		return fmt.Errorf("schemas differ: %d and %d fields", a.NumFields(), b.NumFields())
+	}
+	for i := 0; i < a.NumFields(); i++ {
+		fa, fb := a.Field(i), b.Field(i)
+		if fa.Name != fb.Name {
+			return fmt.Errorf("schemas differ: field %d is %s and %s", i, fa.Name, fb.Name)
+		}
+		if !arrow.TypeEqual(fa.Type, fb.Type) {
+			return fmt.Errorf("schemas differ: field %s is %s and %s", fa.Name, fa.Type, fb.Type)
+		}
+	}
+	return nil
+}
+
+// valuesEqual compares one value of two arrays of the same type
+func valuesEqual(a arrow.Array, i int, b arrow.Array, j int) bool {
+	if a.IsNull(i) || b.IsNull(j) {
+		return a.IsNull(i) && b.IsNull(j)
+	}
+	return canonicalValue(a, i) == canonicalValue(b, j)
+}
+
+// canonicalValue renders a value so that equal values of the same type,
+// including NaNs, render the same
+func canonicalValue(col arrow.Array, row int) string {
+	switch c := col.(type) {
+	case *array.Float64:
+		if math.IsNaN(c.Value(row)) {
+			return "NaN"
+		}
+	case *array.Float32:
+		if math.IsNaN(float64(c.Value(row))) {
+			return "NaN"
+		}
+	}
+	return col.ValueStr(row)
+}
@@ -0,0 +1,110 @@
+package lockbox
+
+import (
+	"math"
+	"testing"
+
+	"github.com/apache/arrow-go/v18/arrow"
+	"github.com/apache/arrow-go/v18/arrow/array"
+	"github.com/apache/arrow-go/v18/arrow/memory"
+)
+
+func diffTestRecord(ids []int64, names []string, valid []bool, scores []float64) arrow.Record {
+	schema := arrow.NewSchema([]arrow.Field{
+		{Name: "id", Type: arrow.PrimitiveTypes.Int64},
+		{Name: "name", Type: arrow.BinaryTypes.String, Nullable: true},
+		{Name: "score", Type: arrow.PrimitiveTypes.Float64, Nullable: true},
+	}, nil)
+	mem := memory.NewGoAllocator()
+	idb := array.NewInt64Builder(mem)
+	nameb := array.NewStringBuilder(mem)
+	scoreb := array.NewFloat64Builder(mem)
+	defer idb.Release()
+	defer nameb.Release()
+	defer scoreb.Release()
+	idb.AppendValues(ids, nil)
+	nameb.AppendValues(names, valid)
+	scoreb.AppendValues(scores, nil)
+	return array.NewRecord(schema, []arrow.Array{idb.NewArray(), nameb.NewArray(), scoreb.NewArray()}, int64(len(ids)))
+}
+
+func TestRecordsEqual(t *testing.T) {
+	nan := math.NaN()
+	a := diffTestRecord([]int64{1, 2}, []string{"a", ""}, []bool{true, false}, []float64{1.5, nan})
+	defer a.Release()
+	b := diffTestRecord([]int64{1, 2}, []string{"a", "ignored"}, []bool{true, false}, []float64{1.5, nan})
+	defer b.Release()
+	if !RecordsEqual(a, b) {
+		t.Fatalf("expected records with equal nulls and NaNs to be equal")
+	}
+
+	c := diffTestRecord([]int64{1, 2}, []string{"a", ""}, []bool{true, true}, []float64{1.5, nan})
+	defer c.Release()
+	if RecordsEqual(a, c) {
+		t.Fatalf("expected a null and an empty string to differ")
+	}
+
+	schema := arrow.NewSchema([]arrow.Field{{Name: "id", Type: arrow.PrimitiveTypes.Int32}}, nil)
+	ib := array.NewInt32Builder(memory.NewGoAllocator())
+	defer ib.Release()
+	ib.AppendValues([]int32{1, 2}, nil)
+	ints := ib.NewArray()
+	defer ints.Release()
+	d := array.NewRecord(schema, []arrow.Array{ints}, 2)
+	defer d.Release()
+	if RecordsEqual(a, d) {
+		t.Fatalf("expected records with different schemas to differ")
+	}
+}
+
+func TestDiffRecords(t *testing.T) {
+	valid := []bool{true, true, true}
+	a := diffTestRecord([]int64{1, 2, 3}, []string{"a", "b", "c"}, valid, []float64{1, 2, 3})
+	defer a.Release()
+	b := diffTestRecord([]int64{1, 3, 4}, []string{"a", "C", "d"}, valid, []float64{1, 3, 4})
+	defer b.Release()
+
+	diff, err := DiffRecords(a, b, []string{"id"})
+	if err != nil {
+		t.Fatalf("diff: %v", err)
+	}
+	if diff.Added != 1 || diff.Removed != 1 || diff.Changed != 1 {
+		t.Fatalf("unexpected diff counts: %+v", diff)
+	}
+	for _, c := range diff.Changes {
+		switch c.Kind {
+		case DiffChanged:
+			if c.Key[0] != "3" || len(c.Columns) != 1 || c.Columns[0] != "name" {
+				t.Fatalf("unexpected change: %+v", c)
+			}
+		case DiffRemoved:
+			if c.Key[0] != "2" || c.RowB != -1 {
+				t.Fatalf("unexpected removal: %+v", c)
+			}
+		case DiffAdded:
+			if c.Key[0] != "4" || c.RowA != -1 {
+				t.Fatalf("unexpected addition: %+v", c)
+			}
+		}
+	}
+
+	// Without key columns a changed row is a removal plus an addition
+	diff, err = DiffRecords(a, b, nil)
+	if err != nil {
+		t.Fatalf("diff: %v", err)
+	}
+	if diff.Added != 2 || diff.Removed != 2 || diff.Changed != 0 {
+		t.Fatalf("unexpected keyless diff counts: %+v", diff)
+	}
+
+	same, err := DiffRecords(a, a, []string{"id"})
+	if err != nil || !same.Equal() {
+		t.Fatalf("expected no differences, got %+v (%v)", same, err)
+	}
+
+	dup := diffTestRecord([]int64{1, 1}, []string{"a", "b"}, []bool{true, true}, []float64{1, 2})
+	defer dup.Release()
+	if _, err := DiffRecords(dup, a, []string{"id"}); err == nil {
+		t.Fatalf("expected duplicate keys to be rejected")
+	}
+}
@@ -0,0 +1,390 @@
+package lockbox
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/apache/arrow-go/v18/arrow"
+	"github.com/apache/arrow-go/v18/arrow/array"
+	"github.com/apache/arrow-go/v18/arrow/memory"
+	"github.com/rs/zerolog/log"
+)
+
+// DriftMode says what an ingestion does when the columns of its input
+// differ from the lockbox's
+type DriftMode string
+
+const (
+	// DriftFail refuses input with new columns or columns whose type changed
+	DriftFail DriftMode = "fail"
+	// DriftAddColumns adds new columns to the lockbox, nullable and null for
+	// the rows already stored; changed types are refused
+	DriftAddColumns DriftMode = "add-columns"
+	// DriftCoerce leaves new columns out and stores values of changed
+	// columns that do not convert to the lockbox's type as null
+	DriftCoerce DriftMode = "coerce"
+)
+
+// driftSample is how many rows of text input are read to infer its types
+const driftSample = 1000
+
+// ParseDriftMode validates a mode name such as "add-columns"
+func ParseDriftMode(name string) (DriftMode, error) {
+	switch m := DriftMode(strings.ToLower(name)); m {
+	case DriftFail, DriftAddColumns, DriftCoerce:
+		return m, nil
+	default:
+		return "", fmt.Errorf("unsupported drift mode: %s", name)
+	}
+}
+
+// WithDrift makes IngestCSV, IngestNDJSON and IngestParquet match input
+// columns to the lockbox's by name instead of position, so reordered input
+// loads as it is, and handle new columns and changed types per mode. Lockbox
+// columns missing from the input are null, or generated for synthetic key
+// and auto-increment columns; a missing column that is not nullable is an
+// error in every mode. CSV input needs a header, and a column mapping
+// cannot be combined with it.
+func WithDrift(mode DriftMode) Option {
+	return func(o *Options) {
+		o.Drift = mode
+	}
+}
+
+// SchemaDrift is how the columns of an input differ from a lockbox's.
+// Columns are matched by name, ignoring case.
+type SchemaDrift struct {
+	// Added are input columns the lockbox does not have
+	Added []DriftColumn `json:"added,omitempty"`
+	// Missing are lockbox columns the input does not have
+	Missing []string `json:"missing,omitempty"`
+	// Changed are columns whose input type does not fit the lockbox's
+	Changed []DriftColumn `json:"changed,omitempty"`
+	// Reordered is set when the shared columns are in a different order
+	Reordered bool `json:"reordered,omitempty"`
+
+	// source is the input column of each lockbox column the input has
+	source map[string]string
+	// load is the schema the input is converted to, and coerce the
+	// columns whose values are nulled when they do not convert
+	load   *arrow.Schema
+	coerce map[string]bool
+}
+
+// DriftColumn is an added or changed column of a SchemaDrift
+type DriftColumn struct {
+	Name string `json:"name"`
+	// Type is the type of the input column
+	Type string `json:"type"`
+	// Stored is the lockbox's type of a changed column
+	Stored string `json:"stored,omitempty"`
+
+	field arrow.Field
+}
+
+// Drifted reports whether columns were added, removed or changed
+func (d *SchemaDrift) Drifted() bool {
+	return len(d.Added)+len(d.Missing)+len(d.Changed) > 0
+}
+
+// String describes the drift, e.g. "added region (utf8); amount changed
+// from float64 to utf8"
+func (d *SchemaDrift) String() string {
+	var parts []string
+	for _, c := range d.Added {
+		parts = append(parts, fmt.Sprintf("added %s (%s)", c.Name, c.Type))
+	}
+	for _, name := range d.Missing {
+		parts = append(parts, "missing "+name)
+	}
+	for _, c := range d.Changed {
+		parts = append(parts, fmt.Sprintf("%s changed from %s to %s", c.Name, c.Stored, c.Type))
+	}
+	if d.Reordered {
+		parts = append(parts, "reordered")
+	}
+	if len(parts) == 0 {
+		return "no drift"
+	}
+	return strings.Join(parts, "; ")
+}
+
+// DetectDrift compares the columns of input with those of a lockbox's
+// schema. An input type fits a column of the same type, or of a wider one
+// such as int64 for int32 and float64 for float32.
+func DetectDrift(schema, input *arrow.Schema) *SchemaDrift {
+	return detectDrift(schema, input, typeFits)
+}
+
+// detectDrift compares input with schema, where fits says whether an input
+// type can be stored in a column type
+func detectDrift(schema, input *arrow.Schema, fits func(stored, in arrow.DataType) bool) *SchemaDrift {
+	d := &SchemaDrift{source: map[string]string{}}
+	matched := map[int]bool{}
+	last := -1
+	for _, field := range schema.Fields() {
+		idx := -1
+		if indices := input.FieldIndices(field.Name); len(indices) > 0 {
+			idx = indices[0]
+		} else {
+			for i, f := range input.Fields() {
+				if !matched[i] && strings.EqualFold(f.Name, field.Name) {
+					idx = i
+					break
+				}
+			}
+		}
+		if idx < 0 {
+			d.Missing = append(d.Missing, field.Name)
+			continue
+		}
+		matched[idx] = true
+		in := input.Field(idx)
+		d.source[field.Name] = in.Name
+		if idx < last {
+			d.Reordered = true
+		}
+		last = idx
+		if !fits(field.Type, in.Type) {
+			d.Changed = append(d.Changed, DriftColumn{Name: field.Name, Type: in.Type.String(), Stored: field.Type.String(), field: in})
+		}
+	}
+	for i, f := range input.Fields() {
+		if !matched[i] {
+			d.Added = append(d.Added, DriftColumn{Name: f.Name, Type: f.Type.String(), field: f})
+		}
+	}
+	return d
+}
+
+// widerTypes lists the types whose values each type holds without loss
+var widerTypes = map[arrow.Type][]arrow.Type{
+	arrow.INT16:        {arrow.INT8, arrow.UINT8},
+	arrow.INT32:        {arrow.INT8, arrow.INT16, arrow.UINT8, arrow.UINT16},
+	arrow.INT64:        {arrow.INT8, arrow.INT16, arrow.INT32, arrow.UINT8, arrow.UINT16, arrow.UINT32},
+	arrow.FLOAT64:      {arrow.FLOAT32, arrow.INT8, arrow.INT16, arrow.INT32, arrow.UINT8, arrow.UINT16, arrow.UINT32},
+	arrow.LARGE_STRING: {arrow.STRING},
+}
+
+// typeFits says whether values of type in can be stored in a column of
+// type stored as they are, or widened
+func typeFits(stored, in arrow.DataType) bool {
+	if dict, ok := stored.(*arrow.DictionaryType); ok {
+		stored = dict.ValueType
+	}
+	if dict, ok := in.(*arrow.DictionaryType); ok {
+		in = dict.ValueType
+	}
+	if arrow.TypeEqual(stored, in) || in.ID() == arrow.NULL {
+		return true
+	}
+	for _, t := range widerTypes[stored.ID()] {
+		if in.ID() == t {
+			return true
+		}
+	}
+	return false
+}
+
+// textFits says whether values of text input, whose type was inferred as
+// in, parse as stored. Inference knows few types: int64, float64, bool,
+// timestamps and strings, which may still hold dates, times or decimals.
+func textFits(stored, in arrow.DataType) bool {
+	if typeFits(stored, in) {
+		return true
+	}
+	if dict, ok := stored.(*arrow.DictionaryType); ok {
+		stored = dict.ValueType
+	}
+	switch stored.ID() {
+	case arrow.STRING, arrow.LARGE_STRING:
+		return true
+	case arrow.INT8, arrow.INT16, arrow.INT32, arrow.UINT8, arrow.UINT16, arrow.UINT32, arrow.UINT64:
+		return in.ID() == arrow.INT64
+	case arrow.FLOAT32, arrow.FLOAT64, arrow.DECIMAL128:
+		return in.ID() == arrow.INT64 || in.ID() == arrow.FLOAT64
+	case arrow.TIMESTAMP, arrow.DATE32, arrow.DATE64, arrow.TIME32, arrow.TIME64, arrow.DURATION:
+		return in.ID() == arrow.TIMESTAMP || in.ID() == arrow.STRING || in.ID() == arrow.INT64
+	case arrow.LIST, arrow.STRUCT, arrow.MAP:
+		return in.ID() == arrow.STRING
+	}
+	return false
+}
+
+// resolveDrift compares the columns of input with the lockbox's and acts
+// on the drift per options.Drift: it fails, adds the new columns, or marks
+// the changed ones to coerce. It sets up options to load the input by
+// name and returns the drift.
+func (lb *Lockbox) resolveDrift(ctx context.Context, input *arrow.Schema, fits func(stored, in arrow.DataType) bool, options *Options) (*SchemaDrift, error) {
+	if options.Mapping != nil {
+		return nil, fmt.Errorf("schema drift detection cannot be combined with a column mapping")
+	}
+	d := detectDrift(lb.Schema(), input, fits)
+
+	generated := map[string]bool{}
+	if key := lb.SyntheticKey(); key != nil {
+		generated[key.Column] = true
+	}
+	for _, seq := range lb.Sequences() {
+		generated[seq.Column] = true
+	}
+	for _, name := range d.Missing {
+		field, _ := lb.Schema().FieldsByName(name)
+		if !generated[name] && !field[0].Nullable {
+			return nil, fmt.Errorf("column %s is missing from the input and not nullable", name)
+		}
+	}
+
+	switch options.Drift {
+	case DriftFail:
+		if len(d.Added) > 0 || len(d.Changed) > 0 {
+			return nil, fmt.Errorf("schema drift: %s", d)
+		}
+	case DriftAddColumns:
+		if len(d.Changed) > 0 {
+			return nil, fmt.Errorf("schema drift: %s", d)
+		}
+		if !options.DryRun {
+			for _, c := range d.Added {
+				if err := lb.addNullColumn(ctx, c.field, options); err != nil {
+					return nil, err
+				}
+				d.source[c.Name] = c.Name
+			}
+		}
+	case DriftCoerce:
+		d.coerce = map[string]bool{}
+		for _, c := range d.Changed {
+			d.coerce[c.Name] = true
+		}
+	default:
+		return nil, fmt.Errorf("unsupported drift mode: %s", options.Drift)
+	}
+	if d.Drifted() {
+		log.Warn().Str("drift", d.String()).Str("mode", string(options.Drift)).Msg("Input schema differs from the lockbox")
+	}
+
+	// Generated columns the input lacks are left out, for the write to fill
+	mapping := &ColumnMapping{Columns: map[string]FieldMapping{}}
+	empty := ""
+	var fields []arrow.Field
+	for _, field := range lb.Schema().Fields() {
+		source, ok := d.source[field.Name]
+		switch {
+		case ok:
+			mapping.Columns[field.Name] = FieldMapping{Source: source}
+		case generated[field.Name]:
+			continue
+		default:
+			mapping.Columns[field.Name] = FieldMapping{Default: &empty}
+		}
+		fields = append(fields, field)
+	}
+	md := lb.Schema().Metadata()
+	d.load = arrow.NewSchema(fields, &md)
+	options.Mapping = mapping
+	options.schemaDrift = d
+	return d, nil
+}
+
+// addNullColumn adds field to the lockbox as a nullable column, null for
+// every stored row
+func (lb *Lockbox) addNullColumn(ctx context.Context, field arrow.Field, options *Options) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if _, err := lb.checkPolicy(options.Principal, ActionWrite); err != nil {
+		return err
+	}
+	if dict, ok := field.Type.(*arrow.DictionaryType); ok {
+		field.Type = dict.ValueType
+	}
+	field.Nullable = true
+
+	col := array.MakeArrayOfNull(lb.Allocator(), field.Type, int(lb.file.Metadata().RowCount()))
+	defer col.Release()
+	commit := *options
+	commit.operation = OperationAddColumn
+	commit.Message = fmt.Sprintf("add column %s", field.Name)
+	writer, err := lb.prepareWriter(&commit)
+	if err != nil {
+		return err
+	}
+	if err := writer.AddColumn(field, col); err != nil {
+		return fmt.Errorf("failed to add column %s: %w", field.Name, err)
+	}
+	if err := lb.refreshMaterializedViews(options.Password, ""); err != nil {
+		return fmt.Errorf("failed to refresh materialized views: %w", err)
+	}
+	lb.notifyCommit(ctx)
+	return nil
+}
+
+// loadSchema returns the schema input is converted to: the lockbox's, or
+// that of the resolved drift
+func (lb *Lockbox) loadSchema(options *Options) *arrow.Schema {
+	if options.schemaDrift != nil {
+		return options.schemaDrift.load
+	}
+	return lb.Schema()
+}
+
+// coerces reports whether values of column that do not convert are stored
+// as null
+func (d *SchemaDrift) coerces(column string) bool {
+	return d != nil && d.coerce[column]
+}
+
+// conformRecord converts rec, a batch of typed input such as Parquet, to
+// the load schema of the drift: columns are taken by name, missing ones
+// are null, and values of other types are converted through their text.
+// The caller releases the result.
+func (d *SchemaDrift) conformRecord(mem memory.Allocator, rec arrow.Record) (arrow.Record, error) {
+	parser := newValueParser(&Options{})
+	cols := make([]arrow.Array, 0, d.load.NumFields())
+	release := func() {
+		for _, c := range cols {
+			c.Release()
+		}
+	}
+	for _, field := range d.load.Fields() {
+		var src arrow.Array
+		if indices := rec.Schema().FieldIndices(d.source[field.Name]); len(indices) > 0 {
+			src = rec.Column(indices[0])
+		}
+		switch {
+		case src == nil:
+			cols = append(cols, array.MakeArrayOfNull(mem, field.Type, int(rec.NumRows())))
+			continue
+		case arrow.TypeEqual(src.DataType(), field.Type):
+			src.Retain()
+			cols = append(cols, src)
+			continue
+		}
+
+		b := array.NewBuilder(mem, field.Type)
+		for row := 0; row < src.Len(); row++ {
+			if src.IsNull(row) {
+				b.AppendNull()
+				continue
+			}
+			appendValue, err := parser.parseValue(b, formatValue(src, row))
+			if err != nil {
+				if d.coerces(field.Name) && field.Nullable {
+					b.AppendNull()
+					continue
+				}
+				b.Release()
+				release()
+				return nil, fmt.Errorf("row %d, column %s: %w", row, field.Name, err)
+			}
+			appendValue()
+		}
+		cols = append(cols, b.NewArray())
+		b.Release()
+	}
+	out := array.NewRecord(d.load, cols, rec.NumRows())
+	release()
+	return out, nil
+}
@@ -0,0 +1,143 @@
+package lockbox
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/apache/arrow-go/v18/arrow"
+	"github.com/apache/arrow-go/v18/arrow/array"
+	"github.com/apache/arrow-go/v18/arrow/memory"
+)
+
+func TestSchemaDrift(t *testing.T) {
+	password := "test_password_123"
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	schema := arrow.NewSchema([]arrow.Field{
+		{Name: "id", Type: arrow.PrimitiveTypes.Int64},
+		{Name: "name", Type: arrow.BinaryTypes.String},
+		{Name: "score", Type: arrow.PrimitiveTypes.Float64, Nullable: true},
+		{Name: "note", Type: arrow.BinaryTypes.String, Nullable: true},
+	}, nil)
+	lb, err := Create(filepath.Join(dir, "drift.lbx"), schema, WithPassword(password))
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	// Query results are released by cleanups, before the allocator is checked
+	t.Cleanup(func() { lb.Close() })
+
+	file := func(name, data string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	ingest := func(path string, mode DriftMode) (*IngestProgress, error) {
+		if strings.HasSuffix(path, ".ndjson") {
+			return lb.IngestNDJSON(ctx, path, WithPassword(password), WithDrift(mode))
+		}
+		return lb.IngestCSV(ctx, path, WithPassword(password), WithDrift(mode))
+	}
+	query := func(q string) arrow.Record {
+		t.Helper()
+		rec, err := lb.Query(ctx, q, WithPassword(password))
+		if err != nil {
+			t.Fatalf("%s: %v", q, err)
+		}
+		t.Cleanup(rec.Release)
+		return rec
+	}
+
+	// Reordered columns load by name, and a missing nullable column is null
+	res, err := ingest(file("reordered.csv", "name,ID,score\nann,1,2.5\nbob,2,3\n"), DriftFail)
+	if err != nil {
+		t.Fatalf("reordered: %v", err)
+	}
+	if d := res.Drift; !d.Reordered || len(d.Missing) != 1 || d.Missing[0] != "note" || len(d.Added)+len(d.Changed) > 0 {
+		t.Errorf("unexpected drift %+v", d)
+	}
+	rec := query("SELECT id, name, score FROM data WHERE id = 2")
+	if rec.NumRows() != 1 || rec.Column(1).(*array.String).Value(0) != "bob" || rec.Column(2).(*array.Float64).Value(0) != 3 {
+		t.Errorf("expected bob with 3, got %v", rec)
+	}
+
+	// A new column is refused, or added
+	added := file("added.csv", "id,name,score,note,region\n3,cy,1,x,eu\n")
+	if _, err := ingest(added, DriftFail); err == nil || !strings.Contains(err.Error(), "added region") {
+		t.Errorf("expected the new column to be refused, got %v", err)
+	}
+	if res, err = ingest(added, DriftAddColumns); err != nil {
+		t.Fatalf("add columns: %v", err)
+	}
+	if !lb.Schema().HasField("region") {
+		t.Fatalf("expected region to be added, got %v", lb.Schema())
+	}
+	rec = query("SELECT id, region FROM data ORDER BY id")
+	if rec.NumRows() != 3 || !rec.Column(1).IsNull(0) || rec.Column(1).(*array.String).Value(2) != "eu" {
+		t.Errorf("expected region null before and eu for row 3, got %v", rec)
+	}
+	snaps := lb.Snapshots()
+	if s := snaps[len(snaps)-2]; s.Operation != OperationAddColumn {
+		t.Errorf("expected the column to be added in its own commit, got %+v", s)
+	}
+
+	// A changed type is refused, or its values nulled when they do not convert
+	changed := file("changed.ndjson", `{"id":4,"name":"dee","score":"n/a"}`+"\n"+`{"id":5,"name":"eve","score":"7.5"}`+"\n")
+	if _, err := ingest(changed, DriftAddColumns); err == nil || !strings.Contains(err.Error(), "score changed from float64 to utf8") {
+		t.Errorf("expected the changed type to be refused, got %v", err)
+	}
+	if res, err = ingest(changed, DriftCoerce); err != nil {
+		t.Fatalf("coerce: %v", err)
+	}
+	if res.Rows != 2 || res.Rejected != 0 {
+		t.Errorf("expected 2 rows, got %+v", res)
+	}
+	rec = query("SELECT id, score FROM data WHERE id >= 4 ORDER BY id")
+	if rec.NumRows() != 2 || !rec.Column(1).IsNull(0) || rec.Column(1).(*array.Float64).Value(1) != 7.5 {
+		t.Errorf("expected a null and 7.5, got %v", rec)
+	}
+
+	if _, err := ingest(file("noid.csv", "name\nzed\n"), DriftCoerce); err == nil || !strings.Contains(err.Error(), "id is missing") {
+		t.Errorf("expected a missing required column to be refused, got %v", err)
+	}
+	if _, err := lb.IngestCSV(ctx, added, WithPassword(password), WithDrift(DriftFail), WithNoHeader()); err == nil {
+		t.Errorf("expected header-less CSV to be refused")
+	}
+
+	// Parquet columns are matched by name and widened
+	mem := memory.NewGoAllocator()
+	pqSchema := arrow.NewSchema([]arrow.Field{
+		{Name: "score", Type: arrow.PrimitiveTypes.Float32, Nullable: true},
+		{Name: "id", Type: arrow.PrimitiveTypes.Int32},
+		{Name: "name", Type: arrow.BinaryTypes.String},
+	}, nil)
+	b := array.NewRecordBuilder(mem, pqSchema)
+	b.Field(0).(*array.Float32Builder).Append(0.5)
+	b.Field(1).(*array.Int32Builder).Append(6)
+	b.Field(2).(*array.StringBuilder).Append("fay")
+	prec := b.NewRecord()
+	b.Release()
+	defer prec.Release()
+	pqPath := filepath.Join(dir, "reordered.parquet")
+	if err := writeParquet(pqPath, prec); err != nil {
+		t.Fatalf("write parquet: %v", err)
+	}
+	if d := DetectDrift(lb.Schema(), pqSchema); !d.Reordered || len(d.Changed) != 0 || len(d.Missing) != 2 {
+		t.Errorf("unexpected parquet drift %+v", d)
+	}
+	if err := lb.IngestParquet(ctx, pqPath, WithPassword(password)); err == nil {
+		t.Errorf("expected float32 not to be widened without drift handling")
+	}
+	if err := lb.IngestParquet(ctx, pqPath, WithPassword(password), WithDrift(DriftFail)); err != nil {
+		t.Fatalf("ingest parquet: %v", err)
+	}
+	rec = query("SELECT name, score FROM data WHERE id = 6")
+	if rec.NumRows() != 1 || rec.Column(0).(*array.String).Value(0) != "fay" || rec.Column(1).(*array.Float64).Value(0) != 0.5 {
+		t.Errorf("expected fay with 0.5, got %v", rec)
+	}
+}
@@ -0,0 +1,222 @@
+package lockbox
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+
+	"github.com/apache/arrow-go/v18/arrow"
+	"github.com/apache/arrow-go/v18/arrow/array"
+	"github.com/apache/arrow-go/v18/arrow/ipc"
+	"github.com/apache/arrow-go/v18/parquet/pqarrow"
+)
+
+// ExportFormat identifies an output file format for decrypted data
+type ExportFormat string
+
+const (
+	// ExportCSV writes comma separated values with a header row. The dialect
+	// is set with WithDelimiter, WithQuote, WithNullToken, WithDateFormats
+	// and WithBOM.
+	ExportCSV ExportFormat = "csv"
+	// ExportJSON writes newline-delimited JSON objects
+	ExportJSON ExportFormat = "json"
+	// ExportParquet writes a Parquet file
+	ExportParquet ExportFormat = "parquet"
+	// ExportArrow writes an Arrow IPC file
+	ExportArrow ExportFormat = "arrow"
+	// ExportArrowStream writes the Arrow IPC stream format, which readers
+	// consume batch by batch as it arrives, e.g. from a pipe
+	ExportArrowStream ExportFormat = "arrow-stream"
+)
+
+// ParseExportFormat validates a format name such as "csv" or "parquet"
+func ParseExportFormat(name string) (ExportFormat, error) {
+	switch f := ExportFormat(strings.ToLower(name)); f {
+	case ExportCSV, ExportJSON, ExportParquet, ExportArrow, ExportArrowStream:
+		return f, nil
+	case "ndjson", "jsonl":
+		return ExportJSON, nil
+	case "ipc", "feather":
+		return ExportArrow, nil
+	case "arrows":
+		return ExportArrowStream, nil
+	default:
+		return "", fmt.Errorf("unsupported export format: %s", name)
+	}
+}
+
+// ExportFormatFromPath infers the export format from a file extension
+func ExportFormatFromPath(path string) (ExportFormat, bool) {
+	ext := strings.TrimPrefix(filepath.Ext(path), ".")
+	if ext == "" {
+		return "", false
+	}
+	f, err := ParseExportFormat(ext)
+	if err != nil {
+		return "", false
+	}
+	return f, true
+}
+
+// ExportRecord writes a record to w in the given format. The record is not
+// released.
+func ExportRecord(w io.Writer, rec arrow.Record, format ExportFormat, opts ...Option) error {
+	options := &Options{}
+	for _, opt := range opts {
+		opt(options)
+	}
+
+	ew, err := newExportWriter(w, rec.Schema(), format, options)
+	if err != nil {
+		return err
+	}
+	if err := ew.Write(rec); err != nil {
+		ew.Close()
+		return err
+	}
+	return ew.Close()
+}
+
+// exportWriter writes records in an export format a batch at a time, so
+// exports don't hold the whole file in memory
+type exportWriter interface {
+	Write(rec arrow.Record) error
+	Close() error
+}
+
+func newExportWriter(w io.Writer, schema *arrow.Schema, format ExportFormat, options *Options) (exportWriter, error) {
+	switch format {
+	case ExportCSV:
+		cw, err := newCSVWriter(w, schema, options)
+		if err != nil {
+			return nil, fmt.Errorf("failed to write csv: %w", err)
+		}
+		return &wrappedWriter{exportWriter: cw, format: format}, nil
+	case ExportJSON:
+		return &jsonWriter{w: w}, nil
+	case ExportParquet:
+		pw, err := pqarrow.NewFileWriter(schema, w, nil, pqarrow.DefaultWriterProps())
+		if err != nil {
+			return nil, fmt.Errorf("failed to create parquet writer: %w", err)
+		}
+		return &wrappedWriter{exportWriter: pw, format: format}, nil
+	case ExportArrow:
+		fw, err := ipc.NewFileWriter(w, ipc.WithSchema(schema))
+		if err != nil {
+			return nil, fmt.Errorf("failed to create arrow writer: %w", err)
+		}
+		return &wrappedWriter{exportWriter: fw, format: format}, nil
+	case ExportArrowStream:
+		return &wrappedWriter{exportWriter: ipc.NewWriter(w, ipc.WithSchema(schema)), format: format}, nil
+	default:
+		return nil, fmt.Errorf("unsupported export format: %s", format)
+	}
+}
+
+// wrappedWriter names the format in write errors
+type wrappedWriter struct {
+	exportWriter
+	format ExportFormat
+}
+
+func (w *wrappedWriter) Write(rec arrow.Record) error {
+	if err := w.exportWriter.Write(rec); err != nil {
+		return fmt.Errorf("failed to write %s: %w", w.format, err)
+	}
+	return nil
+}
+
+// jsonWriter writes records as newline-delimited JSON objects
+type jsonWriter struct {
+	w io.Writer
+}
+
+func (w *jsonWriter) Write(rec arrow.Record) error {
+	if err := array.RecordToJSON(rec, w.w); err != nil {
+		return fmt.Errorf("failed to write json: %w", err)
+	}
+	return nil
+}
+
+func (w *jsonWriter) Close() error { return nil }
+
+// ExportResult describes the rows written by Lockbox.Export. Snapshot is the
+// latest commit included; passing it to WithSinceSnapshot on the next
+// export picks up where this one stopped.
+type ExportResult struct {
+	Format        ExportFormat `json:"format"`
+	SinceSnapshot int64        `json:"sinceSnapshot,omitempty"`
+	Snapshot      int64        `json:"snapshot"`
+	Rows          int64        `json:"rows"`
+}
+
+// WithSinceSnapshot limits reads to the rows committed after the snapshot
+// with the given ID, so a downstream copy can be synced without exporting
+// everything again. Every write commits its rows as a new row group, and
+// the rows after a snapshot are the row groups written after it. Rewrites
+// of existing rows, such as replace-column, are not included. Zero reads
+// all rows. It applies to Export, ExportDelta, Query and scans.
+func WithSinceSnapshot(id int64) Option {
+	return func(o *Options) {
+		o.SinceSnapshot = id
+	}
+}
+
+// WithFilter limits reads to the rows matching an expression such as
+// "age >= 18 and country = 'NL'", in the syntax of WHERE clauses. It
+// applies to Export.
+func WithFilter(filter string) Option {
+	return func(o *Options) {
+		o.Filter = filter
+	}
+}
+
+// Export decrypts the lockbox and writes it to w in the given format.
+// WithColumns limits the exported columns, WithFilter and
+// WithSinceSnapshot the rows, and the access policy for WithPrincipal
+// applies. The CSV dialect options of ExportRecord are honored. Rows are
+// decrypted and written one stored batch at a time, so exports of large
+// files run in bounded memory.
+func (lb *Lockbox) Export(ctx context.Context, w io.Writer, format ExportFormat, opts ...Option) (*ExportResult, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	options := &Options{}
+	for _, opt := range opts {
+		opt(options)
+	}
+
+	scanner, err := lb.NewScanner(ctx, ScanOptions{Columns: options.Columns, Filter: options.Filter}, opts...)
+	if err != nil {
+		return nil, err
+	}
+	defer scanner.Release()
+	res := &ExportResult{Format: format, SinceSnapshot: options.SinceSnapshot}
+	if snaps := lb.Snapshots(); len(snaps) > 0 {
+		res.Snapshot = snaps[len(snaps)-1].ID
+	}
+
+	ew, err := newExportWriter(w, scanner.Schema(), format, options)
+	if err != nil {
+		return nil, err
+	}
+	for scanner.Next() {
+		rec := scanner.Record()
+		if err := ew.Write(rec); err != nil {
+			ew.Close()
+			return nil, err
+		}
+		res.Rows += rec.NumRows()
+	}
+	if err := scanner.Err(); err != nil {
+		ew.Close()
+		return nil, err
+	}
+	if err := ew.Close(); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
@@ -0,0 +1,131 @@
+package lockbox
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/apache/arrow-go/v18/arrow/array"
+)
+
+func TestExportRecord(t *testing.T) {
+	tmpFile := "/tmp/test_lockbox_export.lbx"
+	defer os.Remove(tmpFile)
+
+	password := "test_password_123"
+	lb := newQueryTestLockbox(t, tmpFile, password)
+	defer lb.Close()
+
+	rec, err := lb.Query(context.Background(), "SELECT name, score FROM data ORDER BY score", WithPassword(password))
+	if err != nil {
+		t.Fatalf("query: %v", err)
+	}
+	defer rec.Release()
+
+	var buf bytes.Buffer
+	if err := ExportRecord(&buf, rec, ExportCSV); err != nil {
+		t.Fatalf("export csv: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 4 || lines[0] != "name,score" || lines[1] != "alice,10" {
+		t.Fatalf("unexpected csv output: %q", buf.String())
+	}
+
+	for _, format := range []ExportFormat{ExportJSON, ExportParquet, ExportArrow} {
+		buf.Reset()
+		if err := ExportRecord(&buf, rec, format); err != nil {
+			t.Fatalf("export %s: %v", format, err)
+		}
+		if buf.Len() == 0 {
+			t.Fatalf("export %s produced no output", format)
+		}
+	}
+
+	if f, ok := ExportFormatFromPath("out.parquet"); !ok || f != ExportParquet {
+		t.Fatalf("expected parquet format from extension")
+	}
+}
+
+func TestExportSinceSnapshot(t *testing.T) {
+	tmpFile := "/tmp/test_lockbox_export_since.lbx"
+	defer os.Remove(tmpFile)
+
+	password := "test_password_123"
+	ctx := context.Background()
+	lb := newQueryTestLockbox(t, tmpFile, password)
+	defer lb.Close()
+
+	b := array.NewRecordBuilder(lb.Allocator(), lb.Schema())
+	b.Field(0).(*array.Int64Builder).AppendValues([]int64{4, 5}, nil)
+	b.Field(1).(*array.StringBuilder).AppendValues([]string{"dave", "erin"}, nil)
+	b.Field(2).(*array.Float64Builder).AppendValues([]float64{1, 2}, nil)
+	rec := b.NewRecord()
+	b.Release()
+	if err := lb.Write(ctx, rec, WithPassword(password)); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	rec.Release()
+
+	var buf bytes.Buffer
+	res, err := lb.Export(ctx, &buf, ExportCSV, WithPassword(password), WithColumns("id"), WithSinceSnapshot(1))
+	if err != nil {
+		t.Fatalf("export since snapshot 1: %v", err)
+	}
+	if got := strings.TrimSpace(buf.String()); got != "id\n4\n5" {
+		t.Errorf("expected the rows of the second write, got %q", got)
+	}
+	if res.Rows != 2 || res.Snapshot != 2 {
+		t.Errorf("expected 2 rows through snapshot 2, got %d through %d", res.Rows, res.Snapshot)
+	}
+
+	// Nothing was committed after the latest snapshot
+	buf.Reset()
+	res, err = lb.Export(ctx, &buf, ExportCSV, WithPassword(password), WithSinceSnapshot(2))
+	if err != nil {
+		t.Fatalf("export since snapshot 2: %v", err)
+	}
+	if res.Rows != 0 || strings.TrimSpace(buf.String()) != "id,name,score" {
+		t.Errorf("expected only a header, got %d rows: %q", res.Rows, buf.String())
+	}
+
+	// Without a snapshot everything is exported
+	buf.Reset()
+	if res, err = lb.Export(ctx, &buf, ExportJSON, WithPassword(password)); err != nil || res.Rows != 5 {
+		t.Errorf("expected 5 rows from a full export, got %v (%v)", res, err)
+	}
+
+	if _, err := lb.Export(ctx, &buf, ExportCSV, WithPassword(password), WithSinceSnapshot(9)); err == nil {
+		t.Errorf("expected an error for an unknown snapshot")
+	}
+}
+
+func TestExportFilter(t *testing.T) {
+	tmpFile := "/tmp/test_lockbox_export_filter.lbx"
+	defer os.Remove(tmpFile)
+
+	password := "test_password_123"
+	ctx := context.Background()
+	lb := newQueryTestLockbox(t, tmpFile, password)
+	defer lb.Close()
+
+	var buf bytes.Buffer
+	res, err := lb.Export(ctx, &buf, ExportCSV, WithPassword(password), WithColumns("name"), WithFilter("score >= 20"))
+	if err != nil {
+		t.Fatalf("export with filter: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if res.Rows != 2 || len(lines) != 3 || lines[0] != "name" {
+		t.Errorf("expected 2 names, got %d rows: %q", res.Rows, buf.String())
+	}
+
+	buf.Reset()
+	if res, err = lb.Export(ctx, &buf, ExportJSON, WithPassword(password), WithFilter("score > 100")); err != nil || res.Rows != 0 || buf.Len() != 0 {
+		t.Errorf("expected no rows, got %v (%v): %q", res, err, buf.String())
+	}
+
+	if _, err := lb.Export(ctx, &buf, ExportCSV, WithPassword(password), WithFilter("missing > 1")); err == nil {
+		t.Errorf("expected an error for a filter on an unknown column")
+	}
+}
@@ -0,0 +1,247 @@
+package lockbox
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/TFMV/lockbox/pkg/crypto"
+	"github.com/TFMV/lockbox/pkg/format"
+)
+
+// fieldCiphertextVersion is the first byte of field ciphertexts
+const fieldCiphertextVersion = 1
+
+// ErrFieldCiphertext is returned for values FieldCipher cannot decrypt
+var ErrFieldCiphertext = errors.New("invalid field ciphertext")
+
+// FieldKey is the key material applications use to protect values of a
+// column before they reach a lockbox: an AES-256 key to encrypt them and
+// an HMAC-SHA256 key to hash them to stable tokens. It is derived from the
+// column key, so services holding the field key of the same column agree
+// on ciphertexts and tokens, and it changes when the column key is
+// rotated. It does not decrypt the file.
+type FieldKey struct {
+	// ID names the file, column and key epoch, as file:column:epoch
+	ID         string
+	File       string
+	Column     string
+	Epoch      int
+	Encryption []byte
+	Hashing    []byte
+}
+
+// NewFieldKey derives the field key of a column key, e.g. one recovered
+// from escrow
+func NewFieldKey(ck *format.ColumnKey) *FieldKey {
+	return &FieldKey{
+		ID:         fmt.Sprintf("%s:%s:%d", ck.File, ck.Column, ck.Epoch),
+		File:       ck.File,
+		Column:     ck.Column,
+		Epoch:      ck.Epoch,
+		Encryption: crypto.FieldKey(ck.Key, "encryption"),
+		Hashing:    crypto.FieldKey(ck.Key, "hashing"),
+	}
+}
+
+// FieldKey returns the field key of a column at its current key epoch.
+// It needs the password, and the export is recorded in the audit trail.
+func (lb *Lockbox) FieldKey(column string, opts ...Option) (*FieldKey, error) {
+	options := &Options{}
+	for _, opt := range opts {
+		opt(options)
+	}
+	if options.Password == "" {
+		return nil, fmt.Errorf("password is required for exporting keys")
+	}
+
+	reader, err := lb.file.NewReader(options.Password)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create reader: %w", err)
+	}
+	ck, err := reader.FieldColumnKey(column)
+	if err != nil {
+		return nil, err
+	}
+
+	lb.file.Metadata().LogAccess(options.CreatedBy, "export-field-key", column, true, "")
+	if err := lb.file.SaveMetadata(); err != nil {
+		return nil, err
+	}
+	return NewFieldKey(ck), nil
+}
+
+// jwk is a symmetric JSON Web Key (RFC 7517)
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use,omitempty"`
+	Alg string `json:"alg"`
+	K   string `json:"k"`
+}
+
+// JWK returns the field key as a JSON Web Key Set of two symmetric keys:
+// the A256GCM encryption key, with the key ID and "/enc", and the HS256
+// hashing key, with "/hmac"
+func (k *FieldKey) JWK() ([]byte, error) {
+	set := struct {
+		Keys []jwk `json:"keys"`
+	}{Keys: []jwk{
+		{Kty: "oct", Kid: k.ID + "/enc", Use: "enc", Alg: "A256GCM", K: base64.RawURLEncoding.EncodeToString(k.Encryption)},
+		{Kty: "oct", Kid: k.ID + "/hmac", Use: "sig", Alg: "HS256", K: base64.RawURLEncoding.EncodeToString(k.Hashing)},
+	}}
+	return json.MarshalIndent(set, "", "  ")
+}
+
+// ParseFieldKeyJWK reads a field key from the JSON Web Key Set of JWK
+func ParseFieldKeyJWK(data []byte) (*FieldKey, error) {
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, fmt.Errorf("invalid JWK set: %w", err)
+	}
+	k := &FieldKey{}
+	for _, key := range set.Keys {
+		if key.Kty != "oct" {
+			continue
+		}
+		raw, err := base64.RawURLEncoding.DecodeString(key.K)
+		if err != nil || len(raw) != crypto.KeySize {
+			return nil, fmt.Errorf("invalid JWK %s: expected a %d byte key", key.Kid, crypto.KeySize)
+		}
+		var id string
+		switch key.Alg {
+		case "A256GCM":
+			id, k.Encryption = strings.TrimSuffix(key.Kid, "/enc"), raw
+		case "HS256":
+			id, k.Hashing = strings.TrimSuffix(key.Kid, "/hmac"), raw
+		default:
+			continue
+		}
+		if k.ID != "" && k.ID != id {
+			return nil, fmt.Errorf("JWK set holds keys of %s and %s", k.ID, id)
+		}
+		k.ID = id
+	}
+	if k.Encryption == nil || k.Hashing == nil {
+		return nil, fmt.Errorf("JWK set lacks the A256GCM or HS256 key of a field key")
+	}
+
+	// file:column:epoch, where the column may hold colons
+	first, last := strings.Index(k.ID, ":"), strings.LastIndex(k.ID, ":")
+	if first < 0 || first == last {
+		return nil, fmt.Errorf("invalid field key ID %q", k.ID)
+	}
+	epoch, err := strconv.Atoi(k.ID[last+1:])
+	if err != nil {
+		return nil, fmt.Errorf("invalid field key ID %q", k.ID)
+	}
+	k.File, k.Column, k.Epoch = k.ID[:first], k.ID[first+1:last], epoch
+	return k, nil
+}
+
+// FieldCipher encrypts and hashes values with a field key. Ciphertexts
+// are bound to the key ID, so a value encrypted for one column does not
+// decrypt as another's.
+type FieldCipher struct {
+	key  *FieldKey
+	aead cipher.AEAD
+}
+
+// NewFieldCipher returns a cipher for a field key
+func NewFieldCipher(key *FieldKey) (*FieldCipher, error) {
+	block, err := aes.NewCipher(key.Encryption)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cipher: %w", err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create GCM: %w", err)
+	}
+	return &FieldCipher{key: key, aead: aead}, nil
+}
+
+// Encrypt encrypts a value with a random nonce; equal values encrypt
+// differently
+func (c *FieldCipher) Encrypt(value []byte) ([]byte, error) {
+	nonce := make([]byte, c.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	return c.seal(nonce, value), nil
+}
+
+// EncryptDeterministic encrypts a value with a nonce derived from it, so
+// equal values encrypt to equal ciphertexts and can be joined or looked up
+// without decrypting. That reveals which values are equal; use Encrypt
+// when it must not.
+func (c *FieldCipher) EncryptDeterministic(value []byte) []byte {
+	mac := hmac.New(sha256.New, c.key.Hashing)
+	mac.Write([]byte("nonce\x00"))
+	mac.Write(value)
+	return c.seal(mac.Sum(nil)[:c.aead.NonceSize()], value)
+}
+
+// seal encrypts a value as version, nonce and sealed value
+func (c *FieldCipher) seal(nonce, value []byte) []byte {
+	out := make([]byte, 0, 1+len(nonce)+len(value)+c.aead.Overhead())
+	out = append(out, fieldCiphertextVersion)
+	out = append(out, nonce...)
+	return c.aead.Seal(out, nonce, value, []byte(c.key.ID))
+}
+
+// Decrypt decrypts a value of Encrypt or EncryptDeterministic
+func (c *FieldCipher) Decrypt(ciphertext []byte) ([]byte, error) {
+	n := c.aead.NonceSize()
+	if len(ciphertext) < 1+n+c.aead.Overhead() || ciphertext[0] != fieldCiphertextVersion {
+		return nil, ErrFieldCiphertext
+	}
+	value, err := c.aead.Open(nil, ciphertext[1:1+n], ciphertext[1+n:], []byte(c.key.ID))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrFieldCiphertext, err)
+	}
+	return value, nil
+}
+
+// EncryptString encrypts a string to unpadded base64url, deterministically
+// when deterministic is set
+func (c *FieldCipher) EncryptString(value string, deterministic bool) (string, error) {
+	if deterministic {
+		return base64.RawURLEncoding.EncodeToString(c.EncryptDeterministic([]byte(value))), nil
+	}
+	ciphertext, err := c.Encrypt([]byte(value))
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(ciphertext), nil
+}
+
+// DecryptString decrypts a string of EncryptString
+func (c *FieldCipher) DecryptString(ciphertext string) (string, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(ciphertext)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrFieldCiphertext, err)
+	}
+	value, err := c.Decrypt(raw)
+	if err != nil {
+		return "", err
+	}
+	return string(value), nil
+}
+
+// Hash returns the HMAC-SHA256 token of a value as hex, a stable
+// pseudonym for joining on IDs across services without revealing them
+func (c *FieldCipher) Hash(value []byte) string {
+	mac := hmac.New(sha256.New, c.key.Hashing)
+	mac.Write(value)
+	return fmt.Sprintf("%x", mac.Sum(nil))
+}
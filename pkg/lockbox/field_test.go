@@ -0,0 +1,75 @@
+package lockbox
+
+import (
+	"bytes"
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+func TestFieldKey(t *testing.T) {
+	dir := t.TempDir()
+	password := "test_password_123"
+	lb := newQueryTestLockbox(t, filepath.Join(dir, "data.lbx"), password)
+	defer lb.Close()
+
+	if _, err := lb.FieldKey("name"); err == nil {
+		t.Fatalf("expected a field key without the password to be refused")
+	}
+	key, err := lb.FieldKey("name", WithPassword(password))
+	if err != nil {
+		t.Fatalf("field key: %v", err)
+	}
+	again, err := lb.FieldKey("name", WithPassword(password))
+	if err != nil {
+		t.Fatalf("field key: %v", err)
+	}
+	if !bytes.Equal(key.Encryption, again.Encryption) || bytes.Equal(key.Encryption, key.Hashing) {
+		t.Fatalf("expected stable and separate encryption and hashing keys")
+	}
+	other, err := lb.FieldKey("id", WithPassword(password))
+	if err != nil {
+		t.Fatalf("field key: %v", err)
+	}
+	if bytes.Equal(key.Encryption, other.Encryption) {
+		t.Errorf("expected columns to have different field keys")
+	}
+
+	// Another service gets the same key from the JWK set
+	jwk, err := key.JWK()
+	if err != nil {
+		t.Fatalf("jwk: %v", err)
+	}
+	parsed, err := ParseFieldKeyJWK(jwk)
+	if err != nil {
+		t.Fatalf("parse jwk: %v", err)
+	}
+	if parsed.ID != key.ID || parsed.Column != "name" || !bytes.Equal(parsed.Hashing, key.Hashing) {
+		t.Fatalf("expected the JWK to round trip, got %+v", parsed)
+	}
+
+	c, _ := NewFieldCipher(key)
+	remote, _ := NewFieldCipher(parsed)
+	ct, err := c.EncryptString("alice@example.com", false)
+	if err != nil {
+		t.Fatalf("encrypt: %v", err)
+	}
+	if pt, err := remote.DecryptString(ct); err != nil || pt != "alice@example.com" {
+		t.Errorf("expected the other service to decrypt, got %q %v", pt, err)
+	}
+	if again, _ := c.EncryptString("alice@example.com", false); again == ct {
+		t.Errorf("expected random nonces to give different ciphertexts")
+	}
+	det, _ := c.EncryptString("alice@example.com", true)
+	if remoteDet, _ := remote.EncryptString("alice@example.com", true); remoteDet != det {
+		t.Errorf("expected deterministic ciphertexts to agree across services")
+	}
+	if c.Hash([]byte("42")) != remote.Hash([]byte("42")) || c.Hash([]byte("42")) == c.Hash([]byte("43")) {
+		t.Errorf("expected stable, distinct hash tokens")
+	}
+
+	otherCipher, _ := NewFieldCipher(other)
+	if _, err := otherCipher.DecryptString(ct); !errors.Is(err, ErrFieldCiphertext) {
+		t.Errorf("expected another column's key to fail, got %v", err)
+	}
+}
@@ -0,0 +1,77 @@
+package lockbox
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+
+	"github.com/rs/zerolog/log"
+)
+
+// DefaultFileMode is the permission of new lockbox files: only the owner can
+// read the ciphertext and the cleartext metadata
+const DefaultFileMode os.FileMode = 0600
+
+// WithFileMode sets the permission bits of a file made by Create. It is
+// applied after creation, so the umask does not widen or narrow it.
+func WithFileMode(mode os.FileMode) Option {
+	return func(o *Options) {
+		o.FileMode = mode
+	}
+}
+
+// WithOwner sets the owner and group of a file made by Create; -1 keeps the
+// creating process's, as with os.Chown. Changing the owner usually requires
+// privileges.
+func WithOwner(uid, gid int) Option {
+	return func(o *Options) {
+		o.Owner = &fileOwner{uid: uid, gid: gid}
+	}
+}
+
+// fileOwner is the owner requested with WithOwner
+type fileOwner struct {
+	uid, gid int
+}
+
+// applyFileAccess gives a newly created lockbox file the requested mode and
+// owner
+func applyFileAccess(path string, options *Options) error {
+	if options.Storage != nil {
+		// Only files on disk have a mode and owner
+		return nil
+	}
+	mode := options.FileMode
+	if mode == 0 {
+		mode = DefaultFileMode
+	}
+	if err := os.Chmod(path, mode.Perm()); err != nil {
+		return fmt.Errorf("failed to set file mode: %w", err)
+	}
+	if options.Owner != nil {
+		if err := os.Chown(path, options.Owner.uid, options.Owner.gid); err != nil {
+			return fmt.Errorf("failed to set file owner: %w", err)
+		}
+	}
+	warnIfExposed(path)
+	return nil
+}
+
+// WorldReadable reports whether any user on the system can read the file
+func WorldReadable(path string) (bool, error) {
+	if runtime.GOOS == "windows" {
+		return false, nil
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		return false, err
+	}
+	return fi.Mode().Perm()&0o004 != 0, nil
+}
+
+// warnIfExposed logs a warning when a lockbox file is world-readable
+func warnIfExposed(path string) {
+	if exposed, err := WorldReadable(path); err == nil && exposed {
+		log.Warn().Str("file", path).Msg("Lockbox file is world-readable; its ciphertext and cleartext metadata are exposed (chmod 600)")
+	}
+}
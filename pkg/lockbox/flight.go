@@ -0,0 +1,275 @@
+package lockbox
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+
+	"github.com/apache/arrow-go/v18/arrow"
+	"github.com/apache/arrow-go/v18/arrow/flight"
+	"github.com/apache/arrow-go/v18/arrow/ipc"
+	"github.com/apache/arrow-go/v18/arrow/memory"
+	"github.com/rs/zerolog/log"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/status"
+)
+
+// FlightServer serves lockbox files over Apache Arrow Flight, so clients
+// such as pyarrow and BI tools stream them without the Go SDK. Clients log
+// in with basic auth in the handshake and get a bearer token for their
+// later calls. Blocks are decrypted on the server, and every read runs with
+// the client's user name as principal, so access policies apply, and is
+// recorded in the audit trail of the file.
+//
+// Tables are listed with ListFlights and streamed with DoGet. Every call
+// that reads a table, describing it included, is audited and counted
+// against the quota of its tenant. A ticket is either a table name,
+// streaming the whole table, or a FlightTicket in JSON; flight descriptors
+// take a table name as path or a FlightTicket as command.
+type FlightServer struct {
+	flight.BaseFlightServer
+
+	tables *ServedTables
+	mu     sync.Mutex
+	server flight.Server
+}
+
+// FlightTicket selects the rows DoGet and the HTTP /query endpoint return
+// from a table: either the result of Query, run against the table as
+// "data", or the Columns of the rows matching Filter, streamed batch by
+// batch
+type FlightTicket struct {
+	Table   string   `json:"table"`
+	Query   string   `json:"query,omitempty"`
+	Columns []string `json:"columns,omitempty"`
+	Filter  string   `json:"filter,omitempty"`
+}
+
+// NewFlightServer returns a server for tables
+func NewFlightServer(tables *ServedTables) *FlightServer {
+	return &FlightServer{tables: tables}
+}
+
+// Serve answers Flight clients on l until Shutdown is called. Passwords
+// travel in the handshake, so clients connecting over a network need
+// tlsConfig; nil serves without TLS.
+func (s *FlightServer) Serve(l net.Listener, tlsConfig *tls.Config) error {
+	var opts []grpc.ServerOption
+	if tlsConfig != nil {
+		opts = append(opts, grpc.Creds(credentials.NewTLS(tlsConfig)))
+	}
+	srv := flight.NewServerWithMiddleware([]flight.ServerMiddleware{flight.CreateServerBasicAuthMiddleware(s)}, opts...)
+	srv.InitListener(l)
+	srv.RegisterFlightService(s)
+	s.mu.Lock()
+	s.server = srv
+	s.mu.Unlock()
+	return srv.Serve()
+}
+
+// Shutdown stops serving
+func (s *FlightServer) Shutdown() {
+	s.mu.Lock()
+	srv := s.server
+	s.server = nil
+	s.mu.Unlock()
+	if srv != nil {
+		srv.Shutdown()
+	}
+}
+
+// Validate checks a client's user name and password and returns a new
+// bearer token; it implements flight.BasicAuthValidator
+func (s *FlightServer) Validate(username, password string) (string, error) {
+	token, _, err := s.tables.Login(username, password)
+	if err != nil {
+		return "", flightError(err)
+	}
+	return token, nil
+}
+
+// IsValid returns the user a bearer token was handed to; it implements
+// flight.BasicAuthValidator
+func (s *FlightServer) IsValid(token string) (interface{}, error) {
+	user, err := s.tables.User(token)
+	if err != nil {
+		return nil, flightError(err)
+	}
+	return user, nil
+}
+
+// ListFlights lists every table the user may read with its schema and row
+// count. Describing a table reads it, so each one is audited and counted
+// against the quota of its tenant like a DoGet.
+func (s *FlightServer) ListFlights(_ *flight.Criteria, stream flight.FlightService_ListFlightsServer) error {
+	ctx := stream.Context()
+	for _, name := range s.tables.visible(flightUser(ctx)) {
+		info, err := s.flightInfo(ctx, &FlightTicket{Table: name}, "flight-list")
+		if err != nil {
+			return err
+		}
+		if err := stream.Send(info); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetFlightInfo describes the rows of a table or ticket
+func (s *FlightServer) GetFlightInfo(ctx context.Context, desc *flight.FlightDescriptor) (*flight.FlightInfo, error) {
+	t, err := descriptorTicket(desc)
+	if err != nil {
+		return nil, err
+	}
+	return s.flightInfo(ctx, t, "flight-info")
+}
+
+// GetSchema returns the schema of the rows of a table or ticket
+func (s *FlightServer) GetSchema(ctx context.Context, desc *flight.FlightDescriptor) (*flight.SchemaResult, error) {
+	t, err := descriptorTicket(desc)
+	if err != nil {
+		return nil, err
+	}
+	_, schema, err := s.schema(ctx, t, "flight-schema")
+	if err != nil {
+		return nil, flightError(err)
+	}
+	return &flight.SchemaResult{Schema: flight.SerializeSchema(schema, memory.DefaultAllocator)}, nil
+}
+
+// DoGet streams the rows a ticket selects
+func (s *FlightServer) DoGet(ticket *flight.Ticket, stream flight.FlightService_DoGetServer) error {
+	t, err := parseFlightTicket(ticket.GetTicket())
+	if err != nil {
+		return err
+	}
+	user := flightUser(stream.Context())
+	rows, err := s.doGet(t, user, stream)
+	s.tables.audit(t.Table, user, "flight-get", t.describe(), rows, err, fmt.Sprintf("%d rows", rows))
+	if err != nil {
+		return flightError(err)
+	}
+	log.Info().Str("user", user).Str("table", t.Table).Int64("rows", rows).Msg("Served Flight stream")
+	return nil
+}
+
+// doGet streams the rows of a ticket and returns how many it sent
+func (s *FlightServer) doGet(t *FlightTicket, user string, stream flight.FlightService_DoGetServer) (int64, error) {
+	_, rr, err := s.tables.read(stream.Context(), t, user)
+	if err != nil {
+		return 0, err
+	}
+	defer rr.Release()
+
+	w := flight.NewRecordWriter(stream, ipc.WithSchema(rr.Schema()))
+	defer w.Close()
+	var rows int64
+	for rr.Next() {
+		rec := rr.Record()
+		if err := w.Write(rec); err != nil {
+			return rows, err
+		}
+		rows += rec.NumRows()
+	}
+	return rows, rr.Err()
+}
+
+// schema returns the schema of the rows a ticket selects. It is only known
+// once the ticket's query or scan has run, so the request is charged to the
+// quota of the table's tenant and recorded in its audit trail under action,
+// as DoGet does; no rows are served or charged.
+func (s *FlightServer) schema(ctx context.Context, t *FlightTicket, action string) (*servedTable, *arrow.Schema, error) {
+	user := flightUser(ctx)
+	table, rr, err := s.tables.read(ctx, t, user)
+	var schema *arrow.Schema
+	if err == nil {
+		schema = rr.Schema()
+		rr.Release()
+	}
+	s.tables.audit(t.Table, user, action, t.describe(), 0, err, "schema")
+	return table, schema, err
+}
+
+// flightInfo describes a ticket with a single endpoint on this server
+func (s *FlightServer) flightInfo(ctx context.Context, t *FlightTicket, action string) (*flight.FlightInfo, error) {
+	table, schema, err := s.schema(ctx, t, action)
+	if err != nil {
+		return nil, flightError(err)
+	}
+
+	ticket, err := json.Marshal(t)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to encode ticket: %v", err)
+	}
+	// Rows are only known without a filter or query
+	records := int64(-1)
+	if t.Query == "" && t.Filter == "" {
+		records = table.lb.file.Metadata().LiveRows()
+	}
+	return &flight.FlightInfo{
+		Schema:           flight.SerializeSchema(schema, memory.DefaultAllocator),
+		FlightDescriptor: &flight.FlightDescriptor{Type: flight.DescriptorCMD, Cmd: ticket},
+		Endpoint:         []*flight.FlightEndpoint{{Ticket: &flight.Ticket{Ticket: ticket}}},
+		TotalRecords:     records,
+		TotalBytes:       -1,
+	}, nil
+}
+
+// descriptorTicket returns the ticket a flight descriptor stands for: a
+// table named by its path or a FlightTicket as command
+func descriptorTicket(desc *flight.FlightDescriptor) (*FlightTicket, error) {
+	switch desc.GetType() {
+	case flight.DescriptorPATH:
+		if len(desc.GetPath()) != 1 {
+			return nil, status.Error(codes.InvalidArgument, "descriptor path must be a table name")
+		}
+		return &FlightTicket{Table: desc.GetPath()[0]}, nil
+	case flight.DescriptorCMD:
+		return parseFlightTicket(desc.GetCmd())
+	}
+	return nil, status.Error(codes.InvalidArgument, "unknown descriptor type")
+}
+
+// parseFlightTicket parses a FlightTicket in JSON, or a table name
+func parseFlightTicket(b []byte) (*FlightTicket, error) {
+	if len(b) == 0 || b[0] != '{' {
+		return &FlightTicket{Table: string(b)}, nil
+	}
+	var t FlightTicket
+	if err := json.Unmarshal(b, &t); err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid ticket: %v", err)
+	}
+	return &t, nil
+}
+
+// flightUser returns the user a call was authenticated as
+func flightUser(ctx context.Context) string {
+	user, _ := flight.AuthFromContext(ctx).(string)
+	return user
+}
+
+// flightError maps an error to a Flight status
+func flightError(err error) error {
+	if _, ok := status.FromError(err); ok {
+		return err
+	}
+	switch {
+	case errors.Is(err, ErrUnauthenticated):
+		return status.Error(codes.Unauthenticated, err.Error())
+	case errors.Is(err, ErrNotServed):
+		return status.Error(codes.NotFound, err.Error())
+	case errors.Is(err, ErrAccessDenied):
+		return status.Error(codes.PermissionDenied, err.Error())
+	case errors.Is(err, ErrQuotaExceeded):
+		return status.Error(codes.ResourceExhausted, err.Error())
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		return status.FromContextError(err).Err()
+	}
+	return status.Error(codes.Internal, err.Error())
+}
@@ -0,0 +1,171 @@
+package lockbox
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/apache/arrow-go/v18/arrow"
+	"github.com/apache/arrow-go/v18/arrow/array"
+	"github.com/apache/arrow-go/v18/arrow/flight"
+	"github.com/apache/arrow-go/v18/arrow/memory"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+)
+
+func TestFlightServer(t *testing.T) {
+	password := "test_password_123"
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	schema := arrow.NewSchema([]arrow.Field{
+		{Name: "id", Type: arrow.PrimitiveTypes.Int64},
+		{Name: "name", Type: arrow.BinaryTypes.String},
+	}, nil)
+	path := filepath.Join(t.TempDir(), "people.lbx")
+
+	lb, err := Create(path, schema, WithPassword(password))
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	b := array.NewRecordBuilder(memory.NewGoAllocator(), schema)
+	b.Field(0).(*array.Int64Builder).AppendValues([]int64{1, 2, 3}, nil)
+	b.Field(1).(*array.StringBuilder).AppendValues([]string{"ann", "bob", "cy"}, nil)
+	rec := b.NewRecord()
+	b.Release()
+	if err := lb.Write(ctx, rec, WithPassword(password)); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	rec.Release()
+	lb.Close()
+
+	tables := NewServedTables(time.Minute)
+	if err := tables.AddTable("people", path, "wrong"); err == nil {
+		t.Fatal("expected a wrong password to be refused")
+	}
+	if err := tables.AddTable("people", path, password); err != nil {
+		t.Fatalf("add table: %v", err)
+	}
+	defer tables.Close()
+	tables.AddUser("analyst", "s3cret")
+	server := NewFlightServer(tables)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	go server.Serve(l, nil)
+	defer server.Shutdown()
+
+	client, err := flight.NewClientWithMiddleware(l.Addr().String(), nil, nil, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatalf("client: %v", err)
+	}
+	defer client.Close()
+
+	if _, err := client.AuthenticateBasicToken(ctx, "analyst", "guess"); status.Code(err) != codes.Unauthenticated {
+		t.Errorf("expected a wrong password to be unauthenticated, got %v", err)
+	}
+	if _, err := client.DoGet(ctx, &flight.Ticket{Ticket: []byte("people")}); err == nil {
+		// Errors of server streams arrive with the first message
+		stream, _ := client.DoGet(ctx, &flight.Ticket{Ticket: []byte("people")})
+		if _, err := stream.Recv(); status.Code(err) != codes.Unauthenticated {
+			t.Errorf("expected an anonymous read to be unauthenticated, got %v", err)
+		}
+	}
+
+	authCtx, err := client.AuthenticateBasicToken(ctx, "analyst", "s3cret")
+	if err != nil {
+		t.Fatalf("authenticate: %v", err)
+	}
+
+	flights, err := client.ListFlights(authCtx, &flight.Criteria{})
+	if err != nil {
+		t.Fatalf("list flights: %v", err)
+	}
+	info, err := flights.Recv()
+	if err != nil {
+		t.Fatalf("list flights: %v", err)
+	}
+	if info.TotalRecords != 3 {
+		t.Errorf("expected 3 records, got %d", info.TotalRecords)
+	}
+	if _, err := flights.Recv(); !errors.Is(err, io.EOF) {
+		t.Errorf("expected one flight, got %v", err)
+	}
+
+	read := func(ticket []byte) (int64, *arrow.Schema) {
+		t.Helper()
+		stream, err := client.DoGet(authCtx, &flight.Ticket{Ticket: ticket})
+		if err != nil {
+			t.Fatalf("do get: %v", err)
+		}
+		r, err := flight.NewRecordReader(stream)
+		if err != nil {
+			t.Fatalf("read %s: %v", ticket, err)
+		}
+		defer r.Release()
+		var rows int64
+		for r.Next() {
+			rows += r.Record().NumRows()
+		}
+		if err := r.Err(); err != nil && !errors.Is(err, io.EOF) {
+			t.Fatalf("read %s: %v", ticket, err)
+		}
+		return rows, r.Schema()
+	}
+
+	if rows, s := read(info.Endpoint[0].Ticket.Ticket); rows != 3 || s.NumFields() != 2 {
+		t.Errorf("expected the whole table, got %d rows of %v", rows, s)
+	}
+	ticket, _ := json.Marshal(FlightTicket{Table: "people", Columns: []string{"name"}, Filter: "id > 1"})
+	if rows, s := read(ticket); rows != 2 || s.NumFields() != 1 {
+		t.Errorf("expected 2 rows of name, got %d rows of %v", rows, s)
+	}
+	ticket, _ = json.Marshal(FlightTicket{Table: "people", Query: "SELECT COUNT(*) AS n FROM data"})
+	if rows, _ := read(ticket); rows != 1 {
+		t.Errorf("expected one row from the query, got %d", rows)
+	}
+
+	result, err := client.GetSchema(authCtx, &flight.FlightDescriptor{Type: flight.DescriptorPATH, Path: []string{"people"}})
+	if err != nil {
+		t.Fatalf("get schema: %v", err)
+	}
+	if s, err := flight.DeserializeSchema(result.GetSchema(), memory.DefaultAllocator); err != nil || s.NumFields() != 2 {
+		t.Errorf("expected the table schema, got %v, %v", s, err)
+	}
+
+	// Describing tables is audited like reading them
+	table, _ := tables.lookup("people")
+	if err := table.flush(); err != nil {
+		t.Fatalf("flush audit: %v", err)
+	}
+	audited, err := Open(path, WithPassword(password))
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	actions := map[string]int{}
+	for _, e := range audited.file.Metadata().AuditTrail.AccessLog {
+		if e.Principal == "analyst" {
+			actions[e.Action]++
+		}
+	}
+	audited.Close()
+	if actions["flight-list"] != 1 || actions["flight-schema"] != 1 || actions["flight-get"] != 3 {
+		t.Errorf("expected list, schema and get requests to be audited, got %v", actions)
+	}
+
+	stream, err := client.DoGet(authCtx, &flight.Ticket{Ticket: []byte("missing")})
+	if err == nil {
+		_, err = stream.Recv()
+	}
+	if status.Code(err) != codes.NotFound {
+		t.Errorf("expected an unknown table to be not found, got %v", err)
+	}
+}
@@ -0,0 +1,189 @@
+package lockbox
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/apache/arrow-go/v18/arrow"
+)
+
+// defaultMaxOrphans is the number of orphaned keys a ForeignKeyReport lists
+// when WithMaxOrphans is not given
+const defaultMaxOrphans = 100
+
+// KeyIndex is the set of distinct values of a key column, built with
+// Lockbox.KeyIndex. It holds the key values only, so it can be checked
+// against any number of referencing lockboxes without reading the
+// referenced one again.
+type KeyIndex struct {
+	File   string
+	Column string
+	Type   arrow.DataType
+	Rows   int64
+	Nulls  int64
+	keys   map[string]struct{}
+}
+
+// Len returns the number of distinct non-null keys
+func (ix *KeyIndex) Len() int {
+	return len(ix.keys)
+}
+
+// Contains reports whether key, in the text form Arrow prints values in,
+// is in the index
+func (ix *KeyIndex) Contains(key string) bool {
+	_, ok := ix.keys[key]
+	return ok
+}
+
+// OrphanKey is a referencing value with no match in the referenced column
+type OrphanKey struct {
+	Key  string `json:"key"`
+	Rows int64  `json:"rows"`
+}
+
+// ForeignKeyReport is the result of CheckForeignKey
+type ForeignKeyReport struct {
+	Child        string      `json:"child"`
+	ChildColumn  string      `json:"childColumn"`
+	Parent       string      `json:"parent"`
+	ParentColumn string      `json:"parentColumn"`
+	Rows         int64       `json:"rows"`
+	NullRows     int64       `json:"nullRows"` // nulls reference nothing and are not orphans
+	ParentKeys   int         `json:"parentKeys"`
+	OrphanRows   int64       `json:"orphanRows"`
+	OrphanCount  int         `json:"orphanCount"` // distinct orphaned keys
+	Orphans      []OrphanKey `json:"orphans,omitempty"`
+	Truncated    bool        `json:"truncated,omitempty"`
+}
+
+// Valid reports whether every non-null key has a match
+func (r *ForeignKeyReport) Valid() bool {
+	return r.OrphanRows == 0
+}
+
+// WithMaxOrphans sets how many orphaned keys CheckForeignKey lists; the
+// counts always cover all of them
+func WithMaxOrphans(n int) Option {
+	return func(o *Options) {
+		o.MaxOrphans = n
+	}
+}
+
+// KeyIndex reads column and returns its distinct values. Only that column
+// is decrypted, and the access policy for WithPrincipal applies, so rows a
+// principal cannot see are not in the index. An empty column selects the
+// file's synthetic key.
+func (lb *Lockbox) KeyIndex(ctx context.Context, column string, opts ...Option) (*KeyIndex, error) {
+	if column == "" {
+		key := lb.SyntheticKey()
+		if key == nil {
+			return nil, fmt.Errorf("%s has no synthetic key, name a key column", lb.Path())
+		}
+		column = key.Column
+	}
+	col, err := lb.readKeyColumn(ctx, column, opts...)
+	if err != nil {
+		return nil, err
+	}
+	defer col.Release()
+
+	ix := &KeyIndex{
+		File:   lb.Path(),
+		Column: column,
+		Type:   col.DataType(),
+		Rows:   int64(col.Len()),
+		keys:   make(map[string]struct{}),
+	}
+	for row := 0; row < col.Len(); row++ {
+		if col.IsNull(row) {
+			ix.Nulls++
+			continue
+		}
+		ix.keys[canonicalValue(col, row)] = struct{}{}
+	}
+	return ix, nil
+}
+
+// CheckForeignKey verifies that every non-null value of column refers to a
+// key in parent, and reports the values that do not. Like KeyIndex, only
+// the key column is decrypted and no other data leaves the file. Keys are
+// compared in text form, so an int32 column can reference an int64 key.
+func (lb *Lockbox) CheckForeignKey(ctx context.Context, column string, parent *KeyIndex, opts ...Option) (*ForeignKeyReport, error) {
+	options := &Options{MaxOrphans: defaultMaxOrphans}
+	for _, opt := range opts {
+		opt(options)
+	}
+
+	col, err := lb.readKeyColumn(ctx, column, opts...)
+	if err != nil {
+		return nil, err
+	}
+	defer col.Release()
+
+	report := &ForeignKeyReport{
+		Child:        lb.Path(),
+		ChildColumn:  column,
+		Parent:       parent.File,
+		ParentColumn: parent.Column,
+		Rows:         int64(col.Len()),
+		ParentKeys:   parent.Len(),
+	}
+
+	orphans := make(map[string]int64)
+	for row := 0; row < col.Len(); row++ {
+		if col.IsNull(row) {
+			report.NullRows++
+			continue
+		}
+		key := canonicalValue(col, row)
+		if !parent.Contains(key) {
+			orphans[key]++
+			report.OrphanRows++
+		}
+	}
+
+	report.OrphanCount = len(orphans)
+	for key, rows := range orphans {
+		report.Orphans = append(report.Orphans, OrphanKey{Key: key, Rows: rows})
+	}
+	sort.Slice(report.Orphans, func(i, j int) bool {
+		a, b := report.Orphans[i], report.Orphans[j]
+		if a.Rows != b.Rows {
+			return a.Rows > b.Rows
+		}
+		return a.Key < b.Key
+	})
+	if options.MaxOrphans >= 0 && len(report.Orphans) > options.MaxOrphans {
+		report.Orphans = report.Orphans[:options.MaxOrphans]
+		report.Truncated = true
+	}
+	return report, nil
+}
+
+// readKeyColumn decrypts a single stored or virtual column, with the
+// access policy applied
+func (lb *Lockbox) readKeyColumn(ctx context.Context, column string, opts ...Option) (arrow.Array, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	options := &Options{}
+	for _, opt := range opts {
+		opt(options)
+	}
+
+	qe, err := lb.newQueryExec(ctx, options)
+	if err != nil {
+		return nil, err
+	}
+	rec, err := qe.execSelect(&parsedQuery{SelectCols: []string{column}, SelectAs: []string{""}, From: "data", Limit: -1})
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", lb.Path(), err)
+	}
+	defer rec.Release()
+
+	col := rec.Column(0)
+	col.Retain()
+	return col, nil
+}
@@ -0,0 +1,94 @@
+package lockbox
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/apache/arrow-go/v18/arrow"
+	"github.com/apache/arrow-go/v18/arrow/array"
+	"github.com/apache/arrow-go/v18/arrow/memory"
+)
+
+func TestCheckForeignKey(t *testing.T) {
+	customersFile := "/tmp/test_lockbox_fk_customers.lbx"
+	ordersFile := "/tmp/test_lockbox_fk_orders.lbx"
+	defer os.Remove(customersFile)
+	defer os.Remove(ordersFile)
+
+	password := "test_password_123"
+	ctx := context.Background()
+	mem := memory.NewGoAllocator()
+
+	// Customers are keyed by an int64 id
+	customers := newQueryTestLockbox(t, customersFile, password)
+	defer customers.Close()
+
+	// Orders reference them with an int32 column and a null for guest orders
+	schema := arrow.NewSchema([]arrow.Field{
+		{Name: "order_id", Type: arrow.PrimitiveTypes.Int64},
+		{Name: "customerId", Type: arrow.PrimitiveTypes.Int32, Nullable: true},
+	}, nil)
+	orders, err := Create(ordersFile, schema, WithPassword(password))
+	if err != nil {
+		t.Fatalf("Failed to create lockbox: %v", err)
+	}
+	defer orders.Close()
+
+	ob := array.NewInt64Builder(mem)
+	cb := array.NewInt32Builder(mem)
+	ob.AppendValues([]int64{10, 11, 12, 13, 14, 15}, nil)
+	cb.AppendValues([]int32{1, 2, 9, 0, 9, 7}, []bool{true, true, true, false, true, true})
+	oArr, cArr := ob.NewArray(), cb.NewArray()
+	ob.Release()
+	cb.Release()
+	rec := array.NewRecord(schema, []arrow.Array{oArr, cArr}, 6)
+	oArr.Release()
+	cArr.Release()
+	defer rec.Release()
+	if err := orders.Write(ctx, rec, WithPassword(password)); err != nil {
+		t.Fatalf("write error: %v", err)
+	}
+
+	index, err := customers.KeyIndex(ctx, "id", WithPassword(password))
+	if err != nil {
+		t.Fatalf("KeyIndex error: %v", err)
+	}
+	if index.Len() != 3 || !index.Contains("2") {
+		t.Fatalf("unexpected key index: %d keys", index.Len())
+	}
+
+	report, err := orders.CheckForeignKey(ctx, "customerId", index, WithPassword(password))
+	if err != nil {
+		t.Fatalf("CheckForeignKey error: %v", err)
+	}
+	if report.Valid() {
+		t.Fatalf("expected orphaned keys")
+	}
+	if report.Rows != 6 || report.NullRows != 1 || report.OrphanRows != 3 || report.OrphanCount != 2 {
+		t.Errorf("unexpected report: %+v", report)
+	}
+	if len(report.Orphans) != 2 || report.Orphans[0] != (OrphanKey{Key: "9", Rows: 2}) || report.Orphans[1] != (OrphanKey{Key: "7", Rows: 1}) {
+		t.Errorf("unexpected orphans: %+v", report.Orphans)
+	}
+
+	limited, err := orders.CheckForeignKey(ctx, "customerId", index, WithPassword(password), WithMaxOrphans(1))
+	if err != nil {
+		t.Fatalf("CheckForeignKey error: %v", err)
+	}
+	if len(limited.Orphans) != 1 || !limited.Truncated || limited.OrphanCount != 2 {
+		t.Errorf("expected one listed orphan out of two, got %+v", limited)
+	}
+
+	self, err := customers.CheckForeignKey(ctx, "id", index, WithPassword(password))
+	if err != nil {
+		t.Fatalf("CheckForeignKey error: %v", err)
+	}
+	if !self.Valid() {
+		t.Errorf("expected a column to satisfy its own index")
+	}
+
+	if _, err := orders.KeyIndex(ctx, "missing", WithPassword(password)); err == nil {
+		t.Errorf("expected error for a missing column")
+	}
+}
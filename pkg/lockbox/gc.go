@@ -0,0 +1,130 @@
+package lockbox
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/TFMV/lockbox/pkg/metadata"
+	"github.com/rs/zerolog/log"
+)
+
+// GCReport describes the work done, or with WithDryRun the work that would
+// be done, by one GC run
+type GCReport struct {
+	File             string  `json:"file"`
+	DryRun           bool    `json:"dryRun"`
+	ExpiredSnapshots []int64 `json:"expiredSnapshots,omitempty"`
+	KeptSnapshots    int     `json:"keptSnapshots"`
+	FileSize         int64   `json:"fileSize"` // after the run; the current size for dry runs
+	ReclaimedBytes   int64   `json:"reclaimedBytes"`
+}
+
+// WithKeepLast makes GC keep at least the n newest snapshots
+func WithKeepLast(n int) Option {
+	return func(o *Options) {
+		o.KeepLast = n
+	}
+}
+
+// WithKeepFor makes GC keep the snapshots committed within d
+func WithKeepFor(d time.Duration) Option {
+	return func(o *Options) {
+		o.KeepFor = d
+	}
+}
+
+// GC expires old snapshots and rewrites the file without the regions that
+// nothing refers to any more. A snapshot expires when it is neither among
+// the WithKeepLast newest nor younger than WithKeepFor; the newest snapshot
+// is always kept. With WithDryRun nothing is changed and the report shows
+// what would be expired and reclaimed.
+//
+// Blocks are only ever appended to the table, so the data of an expired
+// snapshot is still part of the newest one; the space reclaimed comes from
+// blocks replaced by key compaction and view refreshes and from earlier
+// copies of the metadata.
+func (lb *Lockbox) GC(ctx context.Context, opts ...Option) (*GCReport, error) {
+	options := &Options{}
+	for _, opt := range opts {
+		opt(options)
+	}
+
+	meta := lb.file.Metadata()
+	report := &GCReport{File: lb.file.Path(), DryRun: options.DryRun}
+
+	kept, expired := expireSnapshots(meta.Snapshots, options.KeepLast, time.Now().Add(-options.KeepFor))
+	report.KeptSnapshots = len(kept)
+	for _, s := range expired {
+		report.ExpiredSnapshots = append(report.ExpiredSnapshots, s.ID)
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	if options.DryRun {
+		// Measure the metadata as it would be saved
+		all := meta.Snapshots
+		meta.Snapshots = kept
+		n, err := lb.file.ReclaimableBytes()
+		meta.Snapshots = all
+		if err != nil {
+			return nil, err
+		}
+		report.ReclaimedBytes = n
+		return report, lb.setFileSize(report)
+	}
+
+	if len(expired) > 0 {
+		meta.Snapshots = kept
+		meta.LogAccess("system", "gc", "snapshots", true, fmt.Sprintf("expired %d snapshots", len(expired)))
+	}
+	n, err := lb.file.Reclaim()
+	if err != nil {
+		return nil, fmt.Errorf("failed to reclaim space: %w", err)
+	}
+	report.ReclaimedBytes = n
+	if err := lb.setFileSize(report); err != nil {
+		return nil, err
+	}
+	if n > 0 || len(expired) > 0 {
+		lb.notify(ctx, Event{Event: metadata.EventCompact, Operation: "gc", Stats: map[string]int64{
+			"expiredSnapshots": int64(len(expired)),
+			"reclaimedBytes":   n,
+			"fileSize":         report.FileSize,
+		}})
+	}
+
+	log.Debug().
+		Str("file", report.File).
+		Int("expired", len(expired)).
+		Int64("reclaimed", n).
+		Msg("Collected garbage")
+	return report, nil
+}
+
+// setFileSize records the current size of the file in the report
+func (lb *Lockbox) setFileSize(report *GCReport) error {
+	summary, err := lb.file.Summary()
+	if err != nil {
+		return err
+	}
+	report.FileSize = summary.FileSize
+	return nil
+}
+
+// expireSnapshots splits snapshots, oldest first, into those kept and those
+// expired
+func expireSnapshots(snaps []metadata.Snapshot, keepLast int, cutoff time.Time) (kept, expired []metadata.Snapshot) {
+	if keepLast < 1 {
+		keepLast = 1
+	}
+	for i, s := range snaps {
+		if len(snaps)-i <= keepLast || !s.Timestamp.Before(cutoff) {
+			kept = append(kept, s)
+		} else {
+			expired = append(expired, s)
+		}
+	}
+	return kept, expired
+}
@@ -0,0 +1,91 @@
+package lockbox
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestGC(t *testing.T) {
+	tmpFile := filepath.Join(t.TempDir(), "gc.lbx")
+	password := "test_password_123"
+	lb := newQueryTestLockbox(t, tmpFile, password)
+	defer func() { lb.Close() }()
+
+	ctx := context.Background()
+	if err := lb.CreateView("top", "SELECT name FROM data WHERE score > 20", WithMaterialized(true), WithPassword(password)); err != nil {
+		t.Fatalf("create view: %v", err)
+	}
+	// Replaced blocks and old metadata copies become garbage
+	if _, err := lb.RotateColumnKey("name", WithPassword(password)); err != nil {
+		t.Fatalf("rotate: %v", err)
+	}
+	if err := lb.RefreshViews(ctx, nil, WithPassword(password)); err != nil {
+		t.Fatalf("refresh views: %v", err)
+	}
+	meta := lb.file.Metadata()
+	meta.Snapshots = append(meta.Snapshots, meta.Snapshots[0], meta.Snapshots[0])
+	for i := range meta.Snapshots {
+		meta.Snapshots[i].ID = int64(i + 1)
+		meta.Snapshots[i].Timestamp = time.Now().Add(-time.Duration(3-i) * 24 * time.Hour)
+	}
+
+	before, err := os.Stat(tmpFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	report, err := lb.GC(ctx, WithKeepLast(1), WithKeepFor(36*time.Hour), WithDryRun(true))
+	if err != nil {
+		t.Fatalf("gc dry run: %v", err)
+	}
+	if len(report.ExpiredSnapshots) != 2 || report.ExpiredSnapshots[0] != 1 || report.ReclaimedBytes == 0 {
+		t.Fatalf("unexpected dry run report %+v", report)
+	}
+	if after, _ := os.Stat(tmpFile); after.Size() != before.Size() || len(lb.Snapshots()) != 3 {
+		t.Fatal("dry run changed the lockbox")
+	}
+
+	report, err = lb.GC(ctx, WithKeepLast(1), WithKeepFor(36*time.Hour))
+	if err != nil {
+		t.Fatalf("gc: %v", err)
+	}
+	if report.KeptSnapshots != 1 || report.ReclaimedBytes == 0 || report.FileSize != before.Size()-report.ReclaimedBytes {
+		t.Fatalf("unexpected report %+v", report)
+	}
+	if err := lb.Validate(); err != nil {
+		t.Fatalf("validate after gc: %v", err)
+	}
+
+	// The rewritten file is still readable, also after reopening
+	check := func(lb *Lockbox) {
+		t.Helper()
+		rec, err := lb.Query(ctx, "SELECT * FROM top", WithPassword(password))
+		if err != nil {
+			t.Fatalf("query view: %v", err)
+		}
+		defer rec.Release()
+		if rec.NumRows() != 2 {
+			t.Fatalf("expected 2 rows, got %d", rec.NumRows())
+		}
+		all, err := lb.Read(ctx, WithPassword(password))
+		if err != nil {
+			t.Fatalf("read: %v", err)
+		}
+		defer all.Release()
+		if all.NumRows() != 3 {
+			t.Fatalf("expected 3 rows, got %d", all.NumRows())
+		}
+	}
+	check(lb)
+	lb.Close()
+	lb, err = Open(tmpFile, WithPassword(password))
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	check(lb)
+	if snaps := lb.Snapshots(); len(snaps) != 1 || snaps[0].ID != 3 {
+		t.Fatalf("unexpected snapshots after gc %+v", snaps)
+	}
+}
@@ -0,0 +1,191 @@
+package lockbox
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/TFMV/lockbox/pkg/geo"
+	"github.com/apache/arrow-go/v18/arrow"
+	"github.com/apache/arrow-go/v18/arrow/array"
+	"github.com/rs/zerolog/log"
+)
+
+// spatialFilter is the condition of an ST_DWithin or ST_InBBox predicate.
+// Box holds every point that can match, and prunes row groups whose
+// blocks lie outside it.
+type spatialFilter struct {
+	Center geo.Point
+	Meters float64 // ST_DWithin only
+	Box    geo.BBox
+}
+
+// isSpatial reports whether name is a spatial predicate function
+func isSpatial(name string) bool {
+	return name == "ST_DWITHIN" || name == "ST_INBBOX"
+}
+
+// parseSpatial parses "ST_DWithin(col, lat, lon, meters)" or
+// "ST_InBBox(col, min_lat, min_lon, max_lat, max_lon)" after the function
+// name
+func (p *queryParser) parseSpatial(fn token) (*wherePredicate, error) {
+	p.next()
+	col, err := p.expect(tokIdent, "point column in "+fn.Text)
+	if err != nil {
+		return nil, err
+	}
+	var args []float64
+	for p.peek().Kind == tokComma {
+		p.next()
+		num, err := p.expect(tokNumber, "number in "+fn.Text)
+		if err != nil {
+			return nil, err
+		}
+		f, err := strconv.ParseFloat(num.Text, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %s in %s", num.Text, fn.Text)
+		}
+		args = append(args, f)
+	}
+	if _, err := p.expect(tokRParen, "')' after "+fn.Text); err != nil {
+		return nil, err
+	}
+
+	leaf := &wherePredicate{Op: fn.upper(), Col: strings.ToLower(col.Text)}
+	switch leaf.Op {
+	case "ST_DWITHIN":
+		if len(args) != 3 {
+			return nil, fmt.Errorf("ST_DWithin takes a column, latitude, longitude and distance in meters")
+		}
+		center := geo.Point{Lat: args[0], Lon: args[1]}
+		if args[2] < 0 {
+			return nil, fmt.Errorf("ST_DWithin distance must not be negative")
+		}
+		leaf.Geo = &spatialFilter{Center: center, Meters: args[2], Box: geo.Around(center, args[2])}
+	default:
+		if len(args) != 4 {
+			return nil, fmt.Errorf("ST_InBBox takes a column, minimum latitude and longitude and maximum latitude and longitude")
+		}
+		box := geo.BBox{MinLat: args[0], MinLon: args[1], MaxLat: args[2], MaxLon: args[3]}
+		if box.MinLat > box.MaxLat || box.MinLon > box.MaxLon {
+			return nil, fmt.Errorf("ST_InBBox minimum must not exceed maximum")
+		}
+		leaf.Geo = &spatialFilter{Box: box}
+	}
+	return leaf, nil
+}
+
+// bindSpatial resolves a spatial predicate against the point column col
+func (w *wherePredicate) bindSpatial(field arrow.Field, col arrow.Array) (func(row int) bool, error) {
+	at, err := geo.Points(field, col)
+	if err != nil {
+		return nil, err
+	}
+	f := w.Geo
+	if w.Op == "ST_DWITHIN" {
+		return func(row int) bool {
+			p, ok := at(row)
+			return ok && geo.Distance(p, f.Center) <= f.Meters
+		}, nil
+	}
+	return func(row int) bool {
+		p, ok := at(row)
+		return ok && f.Box.Contains(p)
+	}, nil
+}
+
+// spatialRowGroups returns the row groups, from qe.first on, that can hold
+// rows matching the spatial predicates ANDed into the WHERE clause, judged
+// by the bounding boxes of their blocks. It reports false when no row
+// group can be skipped.
+func (qe *queryExec) spatialRowGroups(w *wherePredicate) ([]int, bool) {
+	var filters []*wherePredicate
+	switch {
+	case w == nil:
+	case w.Geo != nil:
+		filters = append(filters, w)
+	case w.Op == "AND":
+		for _, t := range w.Terms {
+			if t.Geo != nil {
+				filters = append(filters, t)
+			}
+		}
+	}
+	if len(filters) == 0 {
+		return nil, false
+	}
+
+	skip := make(map[int]bool)
+	for _, bi := range qe.meta.BlockInfo {
+		if bi.BBox == nil || bi.RowGroup < qe.first || bi.RowGroup >= qe.end() {
+			continue
+		}
+		for _, f := range filters {
+			if bi.ColumnName == f.Col && !bi.BBox.Intersects(f.Geo.Box) {
+				skip[bi.RowGroup] = true
+			}
+		}
+	}
+	if len(skip) == 0 {
+		return nil, false
+	}
+
+	var groups []int
+	for n := qe.first; n < qe.end(); n++ {
+		if !skip[n] {
+			groups = append(groups, n)
+		}
+	}
+	log.Debug().Int("skipped", len(skip)).Int("read", len(groups)).Msg("Pruned row groups by bounding box")
+	return groups, true
+}
+
+// parsePoint parses a point as "lat,lon", WKT "POINT(lon lat)" or a JSON
+// object with lat and lon
+func parsePoint(v string) (geo.Point, error) {
+	v = strings.TrimSpace(v)
+	if strings.HasPrefix(v, "{") {
+		var obj struct {
+			Lat *float64 `json:"lat"`
+			Lon *float64 `json:"lon"`
+		}
+		if err := json.Unmarshal([]byte(v), &obj); err != nil || obj.Lat == nil || obj.Lon == nil {
+			return geo.Point{}, fmt.Errorf("invalid point: %s", v)
+		}
+		return geo.Point{Lat: *obj.Lat, Lon: *obj.Lon}, nil
+	}
+
+	var lat, lon string
+	if len(v) > 5 && strings.EqualFold(v[:5], "POINT") {
+		inner := strings.TrimSpace(v[5:])
+		if !strings.HasPrefix(inner, "(") || !strings.HasSuffix(inner, ")") {
+			return geo.Point{}, fmt.Errorf("invalid point: %s", v)
+		}
+		parts := strings.Fields(inner[1 : len(inner)-1])
+		if len(parts) != 2 {
+			return geo.Point{}, fmt.Errorf("invalid point: %s", v)
+		}
+		lon, lat = parts[0], parts[1]
+	} else {
+		var ok bool
+		if lat, lon, ok = strings.Cut(v, ","); !ok {
+			return geo.Point{}, fmt.Errorf("invalid point: %s", v)
+		}
+	}
+	p := geo.Point{}
+	var err1, err2 error
+	p.Lat, err1 = strconv.ParseFloat(strings.TrimSpace(lat), 64)
+	p.Lon, err2 = strconv.ParseFloat(strings.TrimSpace(lon), 64)
+	if err1 != nil || err2 != nil {
+		return geo.Point{}, fmt.Errorf("invalid point: %s", v)
+	}
+	return p, nil
+}
+
+// appendPoint appends p to a point column builder
+func appendPoint(b *array.StructBuilder, p geo.Point) {
+	b.Append(true)
+	b.FieldBuilder(0).(*array.Float64Builder).Append(p.Lat)
+	b.FieldBuilder(1).(*array.Float64Builder).Append(p.Lon)
+}
@@ -0,0 +1,151 @@
+package lockbox
+
+import (
+	"context"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/TFMV/lockbox/pkg/geo"
+	"github.com/apache/arrow-go/v18/arrow"
+	"github.com/apache/arrow-go/v18/arrow/array"
+	"github.com/apache/arrow-go/v18/arrow/memory"
+)
+
+func TestSpatialQueries(t *testing.T) {
+	dir := t.TempDir()
+	password := "test_password_123"
+	ctx := context.Background()
+	schema := arrow.NewSchema([]arrow.Field{
+		{Name: "id", Type: arrow.PrimitiveTypes.Int64},
+		{Name: "location", Type: geo.PointType(), Nullable: true},
+		geo.WKBField("site", true),
+	}, nil)
+
+	if d := geo.Distance(geo.Point{Lat: 52.52, Lon: 13.405}, geo.Point{Lat: 40.7128, Lon: -74.006}); math.Abs(d-6385e3) > 10e3 {
+		t.Errorf("expected Berlin and New York about 6385 km apart, got %.0f m", d)
+	}
+
+	lb, err := Create(filepath.Join(dir, "places.lbx"), schema, WithPassword(password))
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	defer lb.Close()
+
+	// Berlin, loaded from CSV, then New York
+	csvPath := filepath.Join(dir, "berlin.csv")
+	csvData := "id,location\n" +
+		"1,\"52.5200, 13.4050\"\n" +
+		"2,POINT(13.3777 52.5163)\n" +
+		"3,\n"
+	if err := os.WriteFile(csvPath, []byte(csvData), 0600); err != nil {
+		t.Fatal(err)
+	}
+	berlin, err := LoadCSV(csvPath, arrow.NewSchema(schema.Fields()[:2], nil))
+	if err != nil {
+		t.Fatalf("load csv: %v", err)
+	}
+	defer berlin.Release()
+	b := array.NewRecordBuilder(memory.NewGoAllocator(), schema)
+	defer b.Release()
+	for i := 0; i < int(berlin.NumRows()); i++ {
+		b.Field(0).(*array.Int64Builder).Append(berlin.Column(0).(*array.Int64).Value(i))
+		if berlin.Column(1).IsNull(i) {
+			b.Field(1).AppendNull()
+		} else {
+			loc := berlin.Column(1).(*array.Struct)
+			appendPoint(b.Field(1).(*array.StructBuilder), geo.Point{
+				Lat: loc.Field(0).(*array.Float64).Value(i),
+				Lon: loc.Field(1).(*array.Float64).Value(i),
+			})
+		}
+		b.Field(2).(*array.BinaryBuilder).Append(geo.WKB(geo.Point{Lat: 52.5, Lon: 13.4}))
+	}
+	writeBatch := func() {
+		rec := b.NewRecord()
+		defer rec.Release()
+		if err := lb.Write(ctx, rec, WithPassword(password)); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+	}
+	writeBatch()
+	for i, p := range []geo.Point{{Lat: 40.7128, Lon: -74.006}, {Lat: 40.7484, Lon: -73.9857}} {
+		b.Field(0).(*array.Int64Builder).Append(int64(10 + i))
+		appendPoint(b.Field(1).(*array.StructBuilder), p)
+		b.Field(2).(*array.BinaryBuilder).Append(geo.WKB(p))
+	}
+	writeBatch()
+
+	for _, bi := range lb.file.Metadata().BlockInfo {
+		if hasBox := bi.BBox != nil; hasBox != (bi.ColumnName != "id") {
+			t.Fatalf("unexpected bounding box of %s: %+v", bi.ColumnName, bi.BBox)
+		}
+	}
+	if box := lb.file.Metadata().BlockInfo[1].BBox; box.MinLat != 52.5163 || box.MaxLon != 13.405 {
+		t.Errorf("unexpected bounding box %+v", box)
+	}
+
+	ids := func(query string) []int64 {
+		t.Helper()
+		res, err := lb.Query(ctx, query, WithPassword(password))
+		if err != nil {
+			t.Fatalf("%s: %v", query, err)
+		}
+		defer res.Release()
+		col := res.Column(0).(*array.Int64)
+		out := make([]int64, col.Len())
+		for i := range out {
+			out[i] = col.Value(i)
+		}
+		return out
+	}
+	for query, want := range map[string][]int64{
+		"SELECT id FROM data WHERE ST_DWithin(location, 52.52, 13.405, 2000) ORDER BY id":            {1, 2},
+		"SELECT id FROM data WHERE ST_DWithin(location, 52.52, 13.405, 100) ORDER BY id":             {1},
+		"SELECT id FROM data WHERE ST_InBBox(site, 40, -75, 41, -73) ORDER BY id":                    {10, 11},
+		"SELECT id FROM data WHERE id > 1 AND ST_InBBox(location, 40, -75, 41, -73.99) ORDER BY id":  {10},
+		"SELECT id FROM data WHERE id = 3 OR ST_DWithin(location, 40.7128, -74.006, 10) ORDER BY id": {3, 10},
+	} {
+		got := ids(query)
+		if len(got) != len(want) {
+			t.Fatalf("%s: expected %v, got %v", query, want, got)
+		}
+		for i := range want {
+			if got[i] != want[i] {
+				t.Fatalf("%s: expected %v, got %v", query, want, got)
+			}
+		}
+	}
+
+	// Only the row groups whose boxes can match are decrypted
+	qe := &queryExec{meta: lb.file.Metadata()}
+	for query, want := range map[string][]int{
+		"SELECT id FROM data WHERE ST_DWithin(location, 52.52, 13.405, 2000)":       {0},
+		"SELECT id FROM data WHERE id > 0 AND ST_InBBox(site, 40, -75, 41, -73)":    {1},
+		"SELECT id FROM data WHERE ST_InBBox(location, -10, -10, 10, 10)":           nil,
+		"SELECT id FROM data WHERE id = 3 OR ST_DWithin(location, 52.52, 13.4, 10)": {0, 1},
+	} {
+		pq, err := parseQuery(query)
+		if err != nil {
+			t.Fatalf("parse: %v", err)
+		}
+		groups, pruned := qe.spatialRowGroups(pq.Where)
+		if !pruned {
+			groups = []int{0, 1}
+		}
+		if len(groups) != len(want) || (len(want) > 0 && groups[0] != want[0]) {
+			t.Errorf("%s: expected row groups %v, got %v", query, want, groups)
+		}
+	}
+
+	for _, query := range []string{
+		"SELECT id FROM data WHERE ST_DWithin(id, 0, 0, 10)",
+		"SELECT id FROM data WHERE ST_DWithin(location, 0, 0)",
+		"SELECT id FROM data WHERE ST_InBBox(location, 10, 0, 0, 10)",
+	} {
+		if _, err := lb.Query(ctx, query, WithPassword(password)); err == nil {
+			t.Errorf("%s: expected an error", query)
+		}
+	}
+}
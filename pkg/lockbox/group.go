@@ -0,0 +1,17 @@
+package lockbox
+
+import (
+	"github.com/TFMV/lockbox/pkg/metadata"
+)
+
+// WithColumnGroup declares a column group when creating a lockbox. The
+// columns of a group are serialized and encrypted together as one block per
+// write, under a key of their own, which cuts the per-column crypto
+// overhead of very wide schemas; columns outside the group stay isolated on
+// their own keys. Grouped columns are read, rotated and granted together
+// and cannot be replaced one at a time.
+func WithColumnGroup(name string, columns ...string) Option {
+	return func(o *Options) {
+		o.ColumnGroups = append(o.ColumnGroups, metadata.ColumnGroup{Name: name, Columns: columns})
+	}
+}
@@ -0,0 +1,162 @@
+package lockbox
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/TFMV/lockbox/pkg/metadata"
+	"github.com/apache/arrow-go/v18/arrow"
+	"github.com/apache/arrow-go/v18/arrow/array"
+	"github.com/apache/arrow-go/v18/arrow/memory"
+)
+
+func TestColumnGroups(t *testing.T) {
+	tmpFile := filepath.Join(t.TempDir(), "groups.lbx")
+	password := "test_password_123"
+	public := "public_password_456"
+	ctx := context.Background()
+
+	schema := arrow.NewSchema([]arrow.Field{
+		{Name: "id", Type: arrow.PrimitiveTypes.Int64},
+		{Name: "first", Type: arrow.BinaryTypes.String},
+		{Name: "last", Type: arrow.BinaryTypes.String},
+		{Name: "email", Type: arrow.BinaryTypes.String},
+		{Name: "score", Type: arrow.PrimitiveTypes.Float64},
+	}, nil)
+
+	for name, opt := range map[string]Option{
+		"unknown column": WithColumnGroup("contact", "first", "missing"),
+		"one column":     WithColumnGroup("contact", "first"),
+		"empty name":     WithColumnGroup("", "first", "last"),
+	} {
+		if _, err := Create(filepath.Join(t.TempDir(), "bad.lbx"), schema, WithPassword(password), opt); err == nil {
+			t.Errorf("%s: expected the group to be rejected", name)
+		}
+	}
+	if _, err := Create(filepath.Join(t.TempDir(), "bad.lbx"), schema, WithPassword(password),
+		WithColumnGroup("a", "first", "last"), WithColumnGroup("b", "last", "email")); err == nil {
+		t.Errorf("expected a column in two groups to be rejected")
+	}
+
+	lb, err := Create(tmpFile, schema, WithPassword(password), WithColumnGroup("contact", "first", "last", "email"))
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	mem := memory.NewGoAllocator()
+	for batch := 0; batch < 2; batch++ {
+		b := array.NewRecordBuilder(mem, schema)
+		for i := 0; i < 3; i++ {
+			n := batch*3 + i + 1
+			b.Field(0).(*array.Int64Builder).Append(int64(n))
+			b.Field(1).(*array.StringBuilder).Append(fmt.Sprintf("first%d", n))
+			b.Field(2).(*array.StringBuilder).Append(fmt.Sprintf("last%d", n))
+			b.Field(3).(*array.StringBuilder).Append(fmt.Sprintf("user%d@example.com", n))
+			b.Field(4).(*array.Float64Builder).Append(float64(n * 10))
+		}
+		rec := b.NewRecord()
+		if err := lb.Write(ctx, rec, WithPassword(password)); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+		rec.Release()
+		b.Release()
+	}
+
+	// The grouped columns share one block per write
+	sharedBlocks := func() int {
+		offsets := map[int64]bool{}
+		for _, bi := range lb.file.Metadata().BlockInfo {
+			offsets[bi.Offset] = true
+		}
+		return len(offsets)
+	}
+	if n := len(lb.file.Metadata().BlockInfo); n != 10 {
+		t.Fatalf("expected 10 block infos, got %d", n)
+	}
+	if n := sharedBlocks(); n != 6 {
+		t.Fatalf("expected 6 stored blocks, got %d", n)
+	}
+	lb.Close()
+
+	lb, err = Open(tmpFile, WithPassword(password))
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	info, err := lb.Info()
+	if err != nil {
+		t.Fatalf("info: %v", err)
+	}
+	if len(info.ColumnGroups) != 1 || info.ColumnGroups[0].Name != "contact" {
+		t.Fatalf("unexpected column groups: %+v", info.ColumnGroups)
+	}
+
+	checkRow := func(password string) {
+		t.Helper()
+		res, err := lb.Query(ctx, "SELECT last, id, email FROM data WHERE id = 5", WithPassword(password))
+		if err != nil {
+			t.Fatalf("query: %v", err)
+		}
+		defer res.Release()
+		if res.NumRows() != 1 ||
+			res.Column(0).(*array.String).Value(0) != "last5" ||
+			res.Column(2).(*array.String).Value(0) != "user5@example.com" {
+			t.Fatalf("unexpected row: %v", res)
+		}
+	}
+	checkRow(password)
+
+	col := array.NewStringBuilder(mem)
+	col.AppendValues([]string{"a", "b", "c", "d", "e", "f"}, nil)
+	emails := col.NewArray()
+	col.Release()
+	defer emails.Release()
+	if err := lb.ReplaceColumn(ctx, "email", emails, WithPassword(password)); err == nil {
+		t.Errorf("expected replacing a grouped column to fail")
+	}
+
+	// Rotating one column of the group rotates the group's key
+	if _, err := lb.RotateColumnKey("email", WithPassword(password), WithLazyRotation()); err != nil {
+		t.Fatalf("rotate: %v", err)
+	}
+	if n, err := lb.CompactKeys(ctx, WithPassword(password)); err != nil || n != 2 {
+		t.Fatalf("expected 2 shared blocks to be re-encrypted, got %d, %v", n, err)
+	}
+	for _, bi := range lb.file.Metadata().BlockInfo {
+		grouped := bi.Group == "contact"
+		if grouped != (bi.ColumnName == "first" || bi.ColumnName == "last" || bi.ColumnName == "email") {
+			t.Fatalf("unexpected group of block %+v", bi)
+		}
+		if want := map[bool]int{true: 1, false: 0}[grouped]; bi.KeyEpoch != want {
+			t.Fatalf("expected block of %s at epoch %d, got %d", bi.ColumnName, want, bi.KeyEpoch)
+		}
+	}
+	if n := sharedBlocks(); n != 6 {
+		t.Fatalf("expected rotation to keep 6 stored blocks, got %d", n)
+	}
+	checkRow(password)
+
+	// Grants include the whole group or none of it
+	if err := lb.GrantAccess("public", public, []string{"id", "email"}, WithPassword(password)); err == nil {
+		t.Fatalf("expected a grant of part of a group to fail")
+	}
+	if err := lb.GrantAccess("public", public, []string{"id", "first", "last", "email"}, WithPassword(password)); err != nil {
+		t.Fatalf("grant: %v", err)
+	}
+	lb.Close()
+
+	lb, err = Open(tmpFile, WithPassword(public))
+	if err != nil {
+		t.Fatalf("open with access password: %v", err)
+	}
+	defer lb.Close()
+	checkRow(public)
+	if _, err := lb.Query(ctx, "SELECT score FROM data", WithPassword(public)); err == nil {
+		t.Fatalf("expected score to stay locked")
+	}
+
+	// Older readers would misread the shared blocks, so the file is marked
+	if lb.file.Metadata().Header.Flags&metadata.FlagColumnGroups == 0 {
+		t.Errorf("expected the column groups flag in the header")
+	}
+}
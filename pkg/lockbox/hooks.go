@@ -0,0 +1,326 @@
+package lockbox
+
+import (
+	"context"
+	"fmt"
+	"plugin"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/TFMV/lockbox/pkg/expr"
+	"github.com/TFMV/lockbox/pkg/metadata"
+	"github.com/apache/arrow-go/v18/arrow"
+	"github.com/apache/arrow-go/v18/arrow/array"
+	"github.com/apache/arrow-go/v18/arrow/memory"
+	"github.com/rs/zerolog/log"
+)
+
+// WriteHook is a Go implementation of a write hook. Pre-write hooks may
+// return a replacement record; post-write hooks see the committed record
+// and their returned record is ignored.
+type WriteHook interface {
+	Name() string
+	Run(ctx context.Context, rec arrow.Record) (arrow.Record, error)
+}
+
+var (
+	hookMu       sync.RWMutex
+	hookRegistry = map[string]WriteHook{}
+)
+
+// RegisterHook makes a Go write hook available to lockboxes that refer to
+// it by name in a plugin hook.
+func RegisterHook(h WriteHook) {
+	if h == nil {
+		return
+	}
+	hookMu.Lock()
+	defer hookMu.Unlock()
+	hookRegistry[h.Name()] = h
+}
+
+// GetHook retrieves a registered write hook by name.
+func GetHook(name string) (WriteHook, bool) {
+	hookMu.RLock()
+	defer hookMu.RUnlock()
+	h, ok := hookRegistry[name]
+	return h, ok
+}
+
+// LoadHookPlugin dynamically loads a write hook from a Go plugin.
+// The plugin must expose a symbol named "Hook" that implements WriteHook.
+func LoadHookPlugin(path string) error {
+	p, err := plugin.Open(path)
+	if err != nil {
+		return err
+	}
+	sym, err := p.Lookup("Hook")
+	if err != nil {
+		return err
+	}
+	h, ok := sym.(WriteHook)
+	if !ok {
+		return fmt.Errorf("invalid hook type")
+	}
+	RegisterHook(h)
+	return nil
+}
+
+// AddHook configures a hook that runs on every write to this lockbox, e.g.
+// a validation "amount >= 0" or a transform of column ssn with "sha256(ssn)".
+func (lb *Lockbox) AddHook(h metadata.Hook) error {
+	meta := lb.file.Metadata()
+
+	h.Name = strings.ToLower(strings.TrimSpace(h.Name))
+	if !viewNamePattern.MatchString(h.Name) {
+		return fmt.Errorf("invalid hook name %q", h.Name)
+	}
+	for _, existing := range meta.Hooks {
+		if existing.Name == h.Name {
+			return fmt.Errorf("hook %s already exists", h.Name)
+		}
+	}
+	notifier := h.Kind == metadata.HookWebhook || h.Kind == metadata.HookExec
+	if h.Stage == "" {
+		h.Stage = metadata.HookPreWrite
+		if notifier {
+			h.Stage = metadata.HookNotify
+		}
+	}
+	if h.Stage != metadata.HookPreWrite && h.Stage != metadata.HookPostWrite && h.Stage != metadata.HookNotify {
+		return fmt.Errorf("unknown hook stage %q", h.Stage)
+	}
+	if notifier != (h.Stage == metadata.HookNotify) {
+		return fmt.Errorf("only webhook and exec hooks run at stage %s", metadata.HookNotify)
+	}
+
+	switch h.Kind {
+	case metadata.HookValidate, metadata.HookTransform:
+		if h.Stage != metadata.HookPreWrite {
+			return fmt.Errorf("%s hooks must run at stage %s", h.Kind, metadata.HookPreWrite)
+		}
+		e, err := expr.Parse(h.Expression)
+		if err != nil {
+			return fmt.Errorf("invalid expression for hook %s: %w", h.Name, err)
+		}
+		if err := checkColumns(meta.Schema, e.Columns()); err != nil {
+			return fmt.Errorf("hook %s: %w", h.Name, err)
+		}
+		if h.Kind == metadata.HookTransform {
+			if err := checkColumns(meta.Schema, []string{h.Column}); err != nil {
+				return fmt.Errorf("hook %s: %w", h.Name, err)
+			}
+		}
+		if h.Kind == metadata.HookValidate && h.Action != "" && h.Action != "reject" && h.Action != "drop" {
+			return fmt.Errorf("unknown validate action %q", h.Action)
+		}
+	case metadata.HookPlugin:
+		if h.Plugin == "" {
+			return fmt.Errorf("plugin hook %s needs a plugin name", h.Name)
+		}
+	case metadata.HookWebhook, metadata.HookExec:
+		if err := checkNotifyHook(h); err != nil {
+			return fmt.Errorf("hook %s: %w", h.Name, err)
+		}
+	default:
+		return fmt.Errorf("unknown hook kind %q", h.Kind)
+	}
+
+	meta.Hooks = append(meta.Hooks, h)
+	meta.LogAccess("system", "add-hook", h.Name, true, h.Kind)
+	return lb.file.SaveMetadata()
+}
+
+// RemoveHook deletes a configured hook
+func (lb *Lockbox) RemoveHook(name string) error {
+	meta := lb.file.Metadata()
+	name = strings.ToLower(name)
+	for i, h := range meta.Hooks {
+		if h.Name == name {
+			meta.Hooks = append(meta.Hooks[:i], meta.Hooks[i+1:]...)
+			meta.LogAccess("system", "remove-hook", name, true, "")
+			return lb.file.SaveMetadata()
+		}
+	}
+	return fmt.Errorf("hook %s not found", name)
+}
+
+// Hooks returns the hooks configured for the lockbox
+func (lb *Lockbox) Hooks() []metadata.Hook {
+	return append([]metadata.Hook(nil), lb.file.Metadata().Hooks...)
+}
+
+// runHooks runs the hooks of a stage in order. The returned record is rec
+// itself when no hook replaced it; otherwise the caller owns it.
+func (lb *Lockbox) runHooks(ctx context.Context, stage string, rec arrow.Record) (arrow.Record, error) {
+	current := rec
+	release := func() {
+		if current != rec {
+			current.Release()
+		}
+	}
+
+	for _, h := range lb.file.Metadata().Hooks {
+		if h.Stage != stage {
+			continue
+		}
+
+		next, err := runHook(ctx, lb.Allocator(), h, current)
+		if err != nil {
+			release()
+			return nil, fmt.Errorf("hook %s: %w", h.Name, err)
+		}
+		if stage == metadata.HookPostWrite || next == nil || next == current {
+			continue
+		}
+		release()
+		current = next
+		log.Debug().Str("hook", h.Name).Int64("rows", current.NumRows()).Msg("Applied write hook")
+	}
+	return current, nil
+}
+
+// runHook applies a single hook to rec
+func runHook(ctx context.Context, mem memory.Allocator, h metadata.Hook, rec arrow.Record) (arrow.Record, error) {
+	switch h.Kind {
+	case metadata.HookPlugin:
+		impl, ok := GetHook(h.Plugin)
+		if !ok {
+			return nil, fmt.Errorf("plugin %s is not registered", h.Plugin)
+		}
+		return impl.Run(ctx, rec)
+	case metadata.HookValidate:
+		return validateRows(mem, h, rec)
+	case metadata.HookTransform:
+		return transformColumn(mem, h, rec)
+	default:
+		return nil, fmt.Errorf("unknown hook kind %q", h.Kind)
+	}
+}
+
+// validateRows rejects the record, or drops the offending rows, when the
+// hook expression is not true for a row
+func validateRows(mem memory.Allocator, h metadata.Hook, rec arrow.Record) (arrow.Record, error) {
+	e, err := expr.Parse(h.Expression)
+	if err != nil {
+		return nil, err
+	}
+
+	var keep []int
+	for row := 0; row < int(rec.NumRows()); row++ {
+		ok, err := e.EvalBool(recordEnv{rec: rec, row: row})
+		if err != nil {
+			return nil, fmt.Errorf("row %d: %w", row, err)
+		}
+		if ok {
+			keep = append(keep, row)
+			continue
+		}
+		if h.Action != "drop" {
+			return nil, fmt.Errorf("row %d fails %s", row, h.Expression)
+		}
+	}
+
+	if len(keep) == int(rec.NumRows()) {
+		return rec, nil
+	}
+	return takeRows(mem, rec, keep), nil
+}
+
+// transformColumn replaces the hook column with the expression result,
+// converted to the column's type
+func transformColumn(mem memory.Allocator, h metadata.Hook, rec arrow.Record) (arrow.Record, error) {
+	e, err := expr.Parse(h.Expression)
+	if err != nil {
+		return nil, err
+	}
+	idx := rec.Schema().FieldIndices(h.Column)
+	if len(idx) == 0 {
+		return nil, fmt.Errorf("column %s not found", h.Column)
+	}
+	field := rec.Schema().Field(idx[0])
+
+	b := array.NewBuilder(mem, field.Type)
+	defer b.Release()
+	for row := 0; row < int(rec.NumRows()); row++ {
+		v, err := e.Eval(recordEnv{rec: rec, row: row})
+		if err != nil {
+			return nil, fmt.Errorf("row %d: %w", row, err)
+		}
+		if err := appendExprValue(b, v); err != nil {
+			return nil, fmt.Errorf("row %d: %w", row, err)
+		}
+	}
+	arr := b.NewArray()
+	defer arr.Release()
+
+	cols := append([]arrow.Array{}, rec.Columns()...)
+	cols[idx[0]] = arr
+	return array.NewRecord(rec.Schema(), cols, rec.NumRows()), nil
+}
+
+// appendExprValue appends an expression result to a builder, converting
+// between compatible representations
+func appendExprValue(b array.Builder, v interface{}) error {
+	if v == nil {
+		b.AppendNull()
+		return nil
+	}
+
+	switch bb := b.(type) {
+	case *array.StringBuilder:
+		bb.Append(expr.ToString(v))
+	case *array.BinaryBuilder:
+		bb.Append([]byte(expr.ToString(v)))
+	case *array.BooleanBuilder:
+		bb.Append(expr.Truthy(v))
+	case *array.Int64Builder:
+		n, err := toInt64(v)
+		if err != nil {
+			return err
+		}
+		bb.Append(n)
+	case *array.Int32Builder:
+		n, err := toInt64(v)
+		if err != nil {
+			return err
+		}
+		bb.Append(int32(n))
+	case *array.Float64Builder:
+		switch n := v.(type) {
+		case float64:
+			bb.Append(n)
+		case int64:
+			bb.Append(float64(n))
+		default:
+			return fmt.Errorf("cannot store %v as float64", v)
+		}
+	case *array.TimestampBuilder:
+		t, err := expr.ToTime(v)
+		if err != nil {
+			return err
+		}
+		ts, err := arrow.TimestampFromTime(t, bb.Type().(*arrow.TimestampType).Unit)
+		if err != nil {
+			return err
+		}
+		bb.Append(ts)
+	default:
+		return fmt.Errorf("unsupported column type %s", b.Type())
+	}
+	return nil
+}
+
+func toInt64(v interface{}) (int64, error) {
+	switch n := v.(type) {
+	case int64:
+		return n, nil
+	case float64:
+		return int64(n), nil
+	case time.Time:
+		return n.Unix(), nil
+	default:
+		return 0, fmt.Errorf("cannot store %v as integer", v)
+	}
+}
@@ -0,0 +1,161 @@
+package lockbox
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/TFMV/lockbox/pkg/metadata"
+	"github.com/apache/arrow-go/v18/arrow"
+	"github.com/apache/arrow-go/v18/arrow/array"
+	"github.com/apache/arrow-go/v18/arrow/memory"
+)
+
+func TestWriteHooks(t *testing.T) {
+	tmpFile := "/tmp/test_lockbox_hooks.lbx"
+	defer os.Remove(tmpFile)
+
+	password := "test_password_123"
+	schema := arrow.NewSchema([]arrow.Field{
+		{Name: "name", Type: arrow.BinaryTypes.String, Nullable: true},
+		{Name: "score", Type: arrow.PrimitiveTypes.Float64, Nullable: true},
+	}, nil)
+
+	lb, err := Create(tmpFile, schema, WithPassword(password))
+	if err != nil {
+		t.Fatalf("Failed to create lockbox: %v", err)
+	}
+	defer lb.Close()
+
+	if err := lb.AddHook(metadata.Hook{Name: "min_score", Kind: metadata.HookValidate, Expression: "score >= 20", Action: "drop"}); err != nil {
+		t.Fatalf("add validate hook: %v", err)
+	}
+	if err := lb.AddHook(metadata.Hook{Name: "shout", Kind: metadata.HookTransform, Column: "name", Expression: "upper(name)"}); err != nil {
+		t.Fatalf("add transform hook: %v", err)
+	}
+	if err := lb.AddHook(metadata.Hook{Name: "bad", Kind: metadata.HookTransform, Column: "missing", Expression: "1"}); err == nil {
+		t.Fatalf("expected error for unknown transform column")
+	}
+
+	newRecord := func(names []string, scores []float64) arrow.Record {
+		mem := memory.NewGoAllocator()
+		nb := array.NewStringBuilder(mem)
+		sb := array.NewFloat64Builder(mem)
+		defer nb.Release()
+		defer sb.Release()
+		nb.AppendValues(names, nil)
+		sb.AppendValues(scores, nil)
+		return array.NewRecord(schema, []arrow.Array{nb.NewArray(), sb.NewArray()}, int64(len(names)))
+	}
+
+	if err := lb.Write(context.Background(), newRecord([]string{"alice", "bob", "carol"}, []float64{10, 55, 30}), WithPassword(password)); err != nil {
+		t.Fatalf("write error: %v", err)
+	}
+
+	res, err := lb.Query(context.Background(), "SELECT name FROM data ORDER BY name", WithPassword(password))
+	if err != nil {
+		t.Fatalf("query: %v", err)
+	}
+	defer res.Release()
+	names := res.Column(0).(*array.String)
+	if res.NumRows() != 2 || names.Value(0) != "BOB" || names.Value(1) != "CAROL" {
+		t.Fatalf("unexpected rows after hooks: %v", names)
+	}
+
+	if err := lb.RemoveHook("min_score"); err != nil {
+		t.Fatalf("remove hook: %v", err)
+	}
+	if err := lb.AddHook(metadata.Hook{Name: "strict", Kind: metadata.HookValidate, Expression: "score >= 0"}); err != nil {
+		t.Fatalf("add strict hook: %v", err)
+	}
+	err = lb.Write(context.Background(), newRecord([]string{"dave"}, []float64{-1}), WithPassword(password))
+	if err == nil || !strings.Contains(err.Error(), "strict") {
+		t.Fatalf("expected strict hook to reject write, got %v", err)
+	}
+}
+
+func TestNotifyHooks(t *testing.T) {
+	tmpFile := "/tmp/test_lockbox_notify.lbx"
+	defer os.Remove(tmpFile)
+
+	var mu sync.Mutex
+	var events []Event
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		var ev Event
+		if err := json.NewDecoder(r.Body).Decode(&ev); err != nil || r.Header.Get("X-Lockbox-Event") != ev.Event {
+			http.Error(w, "bad event", http.StatusBadRequest)
+			return
+		}
+		mu.Lock()
+		events = append(events, ev)
+		mu.Unlock()
+	}))
+	defer srv.Close()
+
+	password := "test_password_123"
+	lb := newQueryTestLockbox(t, tmpFile, password)
+	defer lb.Close()
+
+	if err := lb.AddHook(metadata.Hook{Name: "pipeline", Kind: metadata.HookWebhook, URL: srv.URL, Events: []string{metadata.EventCommit, metadata.EventPolicyDenied}}); err != nil {
+		t.Fatalf("add webhook: %v", err)
+	}
+	if h := lb.Hooks()[0]; h.Stage != metadata.HookNotify {
+		t.Errorf("expected webhook at stage %s, got %s", metadata.HookNotify, h.Stage)
+	}
+	// A failing hook does not fail the commit
+	if err := lb.AddHook(metadata.Hook{Name: "broken", Kind: metadata.HookWebhook, URL: srv.URL + "/missing"}); err != nil {
+		t.Fatalf("add webhook: %v", err)
+	}
+	for _, h := range []metadata.Hook{
+		{Name: "no_url", Kind: metadata.HookWebhook},
+		{Name: "no_command", Kind: metadata.HookExec},
+		{Name: "early", Kind: metadata.HookExec, Command: []string{"true"}, Stage: metadata.HookPreWrite},
+		{Name: "unknown", Kind: metadata.HookWebhook, URL: srv.URL, Events: []string{"read"}},
+	} {
+		if err := lb.AddHook(h); err == nil {
+			t.Errorf("expected error for hook %s", h.Name)
+		}
+	}
+
+	ctx := context.Background()
+	rec, err := lb.Read(ctx, WithPassword(password))
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	defer rec.Release()
+	if err := lb.Write(ctx, rec, WithPassword(password), WithPrincipal("etl")); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+
+	if err := lb.AddPolicyRule(metadata.PolicyRule{Name: "writers", Actions: []string{ActionWrite}, Condition: "principal = 'etl'"}); err != nil {
+		t.Fatalf("add rule: %v", err)
+	}
+	err = lb.Write(ctx, rec, WithPassword(password), WithPrincipal("intruder"))
+	if !errors.Is(err, ErrAccessDenied) {
+		t.Fatalf("expected write to be denied, got %v", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(events) != 2 {
+		t.Fatalf("expected a commit and a denial, got %+v", events)
+	}
+	commit, denied := events[0], events[1]
+	if commit.Event != metadata.EventCommit || commit.Snapshot != 2 || commit.Principal != "etl" ||
+		commit.Stats["rowsAdded"] != 3 || commit.Stats["totalRows"] != 6 || commit.File != tmpFile {
+		t.Errorf("unexpected commit event %+v", commit)
+	}
+	if denied.Event != metadata.EventPolicyDenied || denied.Principal != "intruder" || denied.Action != ActionWrite || denied.Error == "" {
+		t.Errorf("unexpected denial event %+v", denied)
+	}
+}
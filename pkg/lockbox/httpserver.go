@@ -0,0 +1,330 @@
+package lockbox
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/apache/arrow-go/v18/arrow"
+	"github.com/apache/arrow-go/v18/arrow/array"
+	"github.com/apache/arrow-go/v18/arrow/ipc"
+	"github.com/rs/zerolog/log"
+)
+
+// ArrowStreamType is the media type of Arrow IPC streams. HTTP clients get
+// one instead of JSON when they accept it or pass format=arrow.
+const ArrowStreamType = "application/vnd.apache.arrow.stream"
+
+// HTTPServer serves lockbox files over HTTP with JSON or Arrow IPC
+// responses:
+//
+//	POST /login              a bearer token for the user of the basic auth
+//	GET  /tables             the served tables
+//	GET  /info?table=t       the information of Lockbox.Info
+//	GET  /schema?table=t     the schema
+//	GET  /query?table=t&sql=SELECT...   rows; columns= and filter= scan instead
+//	POST /query              rows, with a FlightTicket as JSON body
+//
+// Requests authenticate with basic auth or a bearer token from /login.
+// table may be left out when a single table is served. Reads run with the
+// user name as principal, so access policies apply, and each request on a
+// table is recorded in the audit trail of the file.
+type HTTPServer struct {
+	tables *ServedTables
+	server *http.Server
+}
+
+// NewHTTPServer returns a server for tables
+func NewHTTPServer(tables *ServedTables) *HTTPServer {
+	s := &HTTPServer{tables: tables}
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /login", s.login)
+	mux.HandleFunc("GET /tables", s.authenticated(s.listTables))
+	mux.HandleFunc("GET /info", s.authenticated(s.info))
+	mux.HandleFunc("GET /schema", s.authenticated(s.schema))
+	mux.HandleFunc("GET /query", s.authenticated(s.query))
+	mux.HandleFunc("POST /query", s.authenticated(s.query))
+	s.server = &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	return s
+}
+
+// Handler returns the handler answering requests
+func (s *HTTPServer) Handler() http.Handler {
+	return s.server.Handler
+}
+
+// Serve answers requests on l until Shutdown is called. Passwords and
+// tokens travel with every request, so clients connecting over a network
+// need tlsConfig; nil serves without TLS.
+func (s *HTTPServer) Serve(l net.Listener, tlsConfig *tls.Config) error {
+	if tlsConfig != nil {
+		l = tls.NewListener(l, tlsConfig)
+	}
+	if err := s.server.Serve(l); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// Shutdown stops serving once the requests in flight are answered or ctx
+// is done
+func (s *HTTPServer) Shutdown(ctx context.Context) error {
+	return s.server.Shutdown(ctx)
+}
+
+// authenticated wraps a handler with basic or bearer authentication; the
+// handler gets the user name
+func (s *HTTPServer) authenticated(h func(http.ResponseWriter, *http.Request, string)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var user string
+		var err error
+		if username, password, ok := r.BasicAuth(); ok {
+			user, err = username, s.tables.CheckPassword(username, password)
+		} else if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+			user, err = s.tables.User(strings.TrimSpace(token))
+		} else {
+			err = ErrUnauthenticated
+		}
+		if err != nil {
+			httpError(w, err)
+			return
+		}
+		h(w, r, user)
+	}
+}
+
+func (s *HTTPServer) login(w http.ResponseWriter, r *http.Request) {
+	username, password, ok := r.BasicAuth()
+	if !ok {
+		httpError(w, ErrUnauthenticated)
+		return
+	}
+	token, expires, err := s.tables.Login(username, password)
+	if err != nil {
+		httpError(w, err)
+		return
+	}
+	writeJSON(w, map[string]interface{}{"token": token, "expires": expires})
+}
+
+func (s *HTTPServer) listTables(w http.ResponseWriter, r *http.Request, user string) {
+	writeJSON(w, map[string]interface{}{"tables": s.tables.visible(user)})
+}
+
+func (s *HTTPServer) info(w http.ResponseWriter, r *http.Request, user string) {
+	name, err := s.tableName(r.URL.Query().Get("table"), user)
+	if err != nil {
+		httpError(w, err)
+		return
+	}
+	info, err := s.tableInfo(name, user)
+	s.tables.audit(name, user, "http-info", name, 0, err, "")
+	if err != nil {
+		httpError(w, err)
+		return
+	}
+	writeJSON(w, info)
+}
+
+// tableInfo returns the information of a table for user, who must be
+// allowed to read it
+func (s *HTTPServer) tableInfo(name, user string) (*Info, error) {
+	t, err := s.tables.open(name, user)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := t.lb.checkPolicy(user, ActionRead); err != nil {
+		return nil, err
+	}
+	return t.lb.Info()
+}
+
+func (s *HTTPServer) schema(w http.ResponseWriter, r *http.Request, user string) {
+	name, err := s.tableName(r.URL.Query().Get("table"), user)
+	if err != nil {
+		httpError(w, err)
+		return
+	}
+	schema, err := s.tableSchema(r.Context(), name, user)
+	s.tables.audit(name, user, "http-schema", name, 0, err, "")
+	if err != nil {
+		httpError(w, err)
+		return
+	}
+
+	if wantsArrow(r) {
+		w.Header().Set("Content-Type", ArrowStreamType)
+		iw := ipc.NewWriter(w, ipc.WithSchema(schema))
+		if err := iw.Close(); err != nil {
+			log.Warn().Err(err).Msg("Failed to write schema")
+		}
+		return
+	}
+	type field struct {
+		Name     string `json:"name"`
+		Type     string `json:"type"`
+		Nullable bool   `json:"nullable"`
+	}
+	fields := make([]field, 0, schema.NumFields())
+	for _, f := range schema.Fields() {
+		fields = append(fields, field{Name: f.Name, Type: f.Type.String(), Nullable: f.Nullable})
+	}
+	writeJSON(w, map[string]interface{}{"table": name, "fields": fields})
+}
+
+// tableSchema returns the schema of the rows user reads from a table,
+// including virtual columns
+func (s *HTTPServer) tableSchema(ctx context.Context, name, user string) (*arrow.Schema, error) {
+	_, rr, err := s.tables.read(ctx, &FlightTicket{Table: name}, user)
+	if err != nil {
+		return nil, err
+	}
+	defer rr.Release()
+	return rr.Schema(), nil
+}
+
+func (s *HTTPServer) query(w http.ResponseWriter, r *http.Request, user string) {
+	var t FlightTicket
+	if r.Method == http.MethodPost {
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&t); err != nil {
+			httpError(w, fmt.Errorf("%w: %v", errBadRequest, err))
+			return
+		}
+	} else {
+		q := r.URL.Query()
+		t.Table, t.Query, t.Filter = q.Get("table"), q.Get("sql"), q.Get("filter")
+		if cols := q.Get("columns"); cols != "" {
+			t.Columns = strings.Split(cols, ",")
+		}
+	}
+	name, err := s.tableName(t.Table, user)
+	if err != nil {
+		httpError(w, err)
+		return
+	}
+	t.Table = name
+
+	rows, err := s.writeRows(w, r, &t, user)
+	s.tables.audit(name, user, "http-query", t.describe(), rows, err, fmt.Sprintf("%d rows", rows))
+	if err != nil {
+		log.Warn().Err(err).Str("user", user).Str("table", name).Msg("Query failed")
+	}
+}
+
+// writeRows answers a query, as an error when it fails before any rows are
+// written, and returns the number of rows written
+func (s *HTTPServer) writeRows(w http.ResponseWriter, r *http.Request, t *FlightTicket, user string) (int64, error) {
+	_, rr, err := s.tables.read(r.Context(), t, user)
+	if err != nil {
+		httpError(w, err)
+		return 0, err
+	}
+	defer rr.Release()
+
+	var rows int64
+	if wantsArrow(r) {
+		w.Header().Set("Content-Type", ArrowStreamType)
+		iw := ipc.NewWriter(w, ipc.WithSchema(rr.Schema()))
+		for rr.Next() {
+			if err := iw.Write(rr.Record()); err != nil {
+				iw.Close()
+				return rows, err
+			}
+			rows += rr.Record().NumRows()
+		}
+		if err := rr.Err(); err != nil {
+			iw.Close()
+			return rows, err
+		}
+		return rows, iw.Close()
+	}
+
+	// JSON rows are written as an array of objects, a batch at a time
+	w.Header().Set("Content-Type", "application/json")
+	bw := bufio.NewWriter(w)
+	bw.WriteString("[")
+	var buf bytes.Buffer
+	for rr.Next() {
+		buf.Reset()
+		if err := array.RecordToJSON(rr.Record(), &buf); err != nil {
+			return rows, err
+		}
+		for _, line := range bytes.Split(bytes.TrimSpace(buf.Bytes()), []byte("\n")) {
+			if len(line) == 0 {
+				continue
+			}
+			if rows > 0 {
+				bw.WriteString(",")
+			}
+			bw.Write(line)
+			rows++
+		}
+	}
+	if err := rr.Err(); err != nil {
+		return rows, err
+	}
+	bw.WriteString("]\n")
+	return rows, bw.Flush()
+}
+
+// tableName returns the table a request names, or the only table served
+// to user
+func (s *HTTPServer) tableName(name, user string) (string, error) {
+	if name != "" {
+		return name, nil
+	}
+	names := s.tables.visible(user)
+	if len(names) != 1 {
+		return "", fmt.Errorf("%w: table is required", errBadRequest)
+	}
+	return names[0], nil
+}
+
+// errBadRequest marks errors in a request
+var errBadRequest = errors.New("bad request")
+
+// wantsArrow reports whether a request asks for Arrow IPC
+func wantsArrow(r *http.Request) bool {
+	if f := r.URL.Query().Get("format"); f != "" {
+		return f == "arrow"
+	}
+	return strings.Contains(r.Header.Get("Accept"), ArrowStreamType)
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Warn().Err(err).Msg("Failed to write response")
+	}
+}
+
+// httpError answers with the status of an error and the error as JSON
+func httpError(w http.ResponseWriter, err error) {
+	code := http.StatusInternalServerError
+	switch {
+	case errors.Is(err, ErrUnauthenticated):
+		code = http.StatusUnauthorized
+		w.Header().Set("WWW-Authenticate", `Basic realm="lockbox"`)
+	case errors.Is(err, ErrAccessDenied):
+		code = http.StatusForbidden
+	case errors.Is(err, ErrNotServed):
+		code = http.StatusNotFound
+	case errors.Is(err, ErrQuotaExceeded):
+		code = http.StatusTooManyRequests
+	case errors.Is(err, errBadRequest):
+		code = http.StatusBadRequest
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		code = http.StatusServiceUnavailable
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+}
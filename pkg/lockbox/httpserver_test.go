@@ -0,0 +1,169 @@
+package lockbox
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/TFMV/lockbox/pkg/metadata"
+	"github.com/apache/arrow-go/v18/arrow"
+	"github.com/apache/arrow-go/v18/arrow/array"
+	"github.com/apache/arrow-go/v18/arrow/ipc"
+	"github.com/apache/arrow-go/v18/arrow/memory"
+)
+
+func TestHTTPServer(t *testing.T) {
+	password := "test_password_123"
+	ctx := context.Background()
+	schema := arrow.NewSchema([]arrow.Field{
+		{Name: "id", Type: arrow.PrimitiveTypes.Int64},
+		{Name: "owner", Type: arrow.BinaryTypes.String},
+	}, nil)
+	path := filepath.Join(t.TempDir(), "orders.lbx")
+
+	lb, err := Create(path, schema, WithPassword(password))
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	b := array.NewRecordBuilder(memory.NewGoAllocator(), schema)
+	b.Field(0).(*array.Int64Builder).AppendValues([]int64{1, 2, 3}, nil)
+	b.Field(1).(*array.StringBuilder).AppendValues([]string{"ann", "bob", "ann"}, nil)
+	rec := b.NewRecord()
+	b.Release()
+	if err := lb.Write(ctx, rec, WithPassword(password)); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	rec.Release()
+	if err := lb.AddPolicyRule(metadata.PolicyRule{Name: "own_rows", Principals: []string{"ann"}, RowFilter: "owner = principal"}); err != nil {
+		t.Fatalf("add policy rule: %v", err)
+	}
+	lb.Close()
+
+	tables := NewServedTables(time.Minute)
+	if err := tables.AddTable("orders", path, password); err != nil {
+		t.Fatalf("add table: %v", err)
+	}
+	tables.AddUser("ann", "ann-pw")
+	tables.AddUser("admin", "admin-pw")
+	srv := httptest.NewServer(NewHTTPServer(tables).Handler())
+	defer srv.Close()
+
+	do := func(method, target, body string, auth func(*http.Request)) *http.Response {
+		t.Helper()
+		req, err := http.NewRequest(method, srv.URL+target, strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if auth != nil {
+			auth(req)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("%s %s: %v", method, target, err)
+		}
+		return resp
+	}
+	basic := func(user, pw string) func(*http.Request) {
+		return func(r *http.Request) { r.SetBasicAuth(user, pw) }
+	}
+
+	if resp := do("GET", "/info", "", nil); resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("expected an anonymous request to be unauthorized, got %s", resp.Status)
+	}
+	if resp := do("GET", "/info", "", basic("ann", "guess")); resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("expected a wrong password to be unauthorized, got %s", resp.Status)
+	}
+
+	// A token from /login works like the password
+	resp := do("POST", "/login", "", basic("admin", "admin-pw"))
+	var login struct{ Token string }
+	json.NewDecoder(resp.Body).Decode(&login)
+	resp.Body.Close()
+	if login.Token == "" {
+		t.Fatalf("expected a token, got %s", resp.Status)
+	}
+	bearer := func(r *http.Request) { r.Header.Set("Authorization", "Bearer "+login.Token) }
+
+	resp = do("GET", "/info", "", bearer)
+	var info Info
+	json.NewDecoder(resp.Body).Decode(&info)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || info.RowCount != 3 {
+		t.Errorf("expected info with 3 rows, got %s %+v", resp.Status, info)
+	}
+
+	resp = do("GET", "/schema?table=orders", "", bearer)
+	var sch struct{ Fields []struct{ Name, Type string } }
+	json.NewDecoder(resp.Body).Decode(&sch)
+	resp.Body.Close()
+	if len(sch.Fields) != 2 || sch.Fields[0].Name != "id" || sch.Fields[0].Type != "int64" {
+		t.Errorf("expected the schema, got %+v", sch)
+	}
+
+	// Row filters apply to the user's reads
+	resp = do("GET", "/query?sql=SELECT+id+FROM+data", "", basic("ann", "ann-pw"))
+	var rows []map[string]interface{}
+	json.NewDecoder(resp.Body).Decode(&rows)
+	resp.Body.Close()
+	if len(rows) != 2 {
+		t.Errorf("expected ann's 2 rows, got %s %v", resp.Status, rows)
+	}
+
+	resp = do("POST", "/query?format=arrow", `{"table": "orders", "columns": ["id"], "filter": "id >= 2"}`, bearer)
+	if ct := resp.Header.Get("Content-Type"); ct != ArrowStreamType {
+		t.Errorf("expected an Arrow stream, got %s", ct)
+	}
+	r, err := ipc.NewReader(resp.Body)
+	if err != nil {
+		t.Fatalf("read arrow: %v", err)
+	}
+	var n int64
+	for r.Next() {
+		n += r.Record().NumRows()
+	}
+	r.Release()
+	resp.Body.Close()
+	if n != 2 {
+		t.Errorf("expected 2 rows, got %d", n)
+	}
+
+	if resp := do("GET", "/query?table=missing", "", bearer); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("expected an unknown table to be not found, got %s", resp.Status)
+	}
+	if resp := do("GET", "/query?sql=SELEC", "", bearer); resp.StatusCode == http.StatusOK {
+		t.Errorf("expected a bad query to fail, got %s", resp.Status)
+	}
+
+	// Every request on the table is audited, in batches rather than by
+	// rewriting the metadata for each request
+	audited := func() []string {
+		t.Helper()
+		lb, err := Open(path, WithPassword(password))
+		if err != nil {
+			t.Fatalf("open: %v", err)
+		}
+		defer lb.Close()
+		var audited []string
+		for _, e := range lb.file.Metadata().AuditTrail.AccessLog {
+			if strings.HasPrefix(e.Action, "http-") {
+				audited = append(audited, e.Principal+" "+e.Action)
+			}
+		}
+		return audited
+	}
+	if got := audited(); len(got) != 0 {
+		t.Errorf("expected audit entries to be buffered, got %v", got)
+	}
+	if err := tables.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+	want := []string{"admin http-info", "admin http-schema", "ann http-query", "admin http-query", "admin http-query"}
+	if got := audited(); strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("expected audit entries %v, got %v", want, got)
+	}
+}
@@ -0,0 +1,225 @@
+package lockbox
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/TFMV/lockbox/pkg/metadata"
+	"github.com/apache/arrow-go/v18/arrow"
+	"github.com/rs/zerolog/log"
+)
+
+// DefaultIngestBatchSize is the number of rows IngestCSV converts and
+// commits at a time unless WithBatchSize sets another
+const DefaultIngestBatchSize = 64 * 1024
+
+// IngestProgress reports how far an ingestion got. BytesRead counts the
+// input read so far, a little ahead of the rows converted; Size is the
+// size of the input. Errors are the rejected rows kept under
+// OnErrorQuarantine.
+type IngestProgress struct {
+	Source    string        `json:"source"`
+	Batches   int           `json:"batches"`
+	Rows      int64         `json:"rows"`
+	Rejected  int           `json:"rejected,omitempty"`
+	BytesRead int64         `json:"bytesRead"`
+	Size      int64         `json:"size"`
+	DryRun    bool          `json:"dryRun,omitempty"`
+	Errors    []RejectedRow `json:"errors,omitempty"`
+	// Drift is how the input's columns differ from the lockbox's, with
+	// WithDrift
+	Drift *SchemaDrift `json:"drift,omitempty"`
+}
+
+// batchLoader converts input rows to records batch by batch; an empty
+// record means the input is exhausted
+type batchLoader interface {
+	next(max int) (arrow.Record, error)
+	Release()
+}
+
+// WithBatchSize sets the number of rows converted and committed at a time
+func WithBatchSize(rows int) Option {
+	return func(o *Options) {
+		o.BatchSize = rows
+	}
+}
+
+// WithProgress calls fn after each batch of an ingestion
+func WithProgress(fn func(IngestProgress)) Option {
+	return func(o *Options) {
+		o.Progress = fn
+	}
+}
+
+// IngestCSV streams a CSV file into the lockbox. Rows are converted to the
+// schema as LoadCSV does, with the same options, and committed in batches
+// of WithBatchSize rows, so only one batch is held in memory however large
+// the file. Each batch is its own commit with the message and lineage of
+// the ingestion; when a batch fails, the batches before it stay committed
+// and the returned progress counts them. WithDryRun converts every row
+// without writing, to check a file against the schema.
+func (lb *Lockbox) IngestCSV(ctx context.Context, path string, opts ...Option) (*IngestProgress, error) {
+	options := &Options{}
+	for _, opt := range opts {
+		opt(options)
+	}
+	detect := func() (*arrow.Schema, error) {
+		if options.NoHeader {
+			return nil, fmt.Errorf("schema drift detection needs a CSV header")
+		}
+		return DetectCSVSchema(path, driftSample, func(o *Options) { *o = *options })
+	}
+	return lb.ingest(ctx, path, OperationIngestCSV, options, detect, func(in io.Reader) (batchLoader, error) {
+		return newCSVLoader(in, lb.loadSchema(options), options)
+	})
+}
+
+// IngestNDJSON streams an NDJSON file, one object per line, into the
+// lockbox in batches like IngestCSV. Objects are converted as LoadJSON
+// converts them. Lines that are not JSON objects or do not fit the schema
+// are rejected one by one: unless WithOnError sets a policy, they are
+// dropped and reported with their line and reason in the Errors of the
+// returned progress, so one bad row does not abort a large file. Use
+// DetectJSONSchema to create a lockbox for a file.
+func (lb *Lockbox) IngestNDJSON(ctx context.Context, path string, opts ...Option) (*IngestProgress, error) {
+	options := &Options{}
+	for _, opt := range opts {
+		opt(options)
+	}
+	if options.OnError == "" {
+		options.OnError = OnErrorQuarantine
+		if options.Rejects == nil {
+			options.Rejects = &Rejects{}
+		}
+	}
+	detect := func() (*arrow.Schema, error) {
+		return DetectJSONSchema(path, driftSample, func(o *Options) { *o = *options })
+	}
+	return lb.ingest(ctx, path, OperationIngestNDJSON, options, detect, func(in io.Reader) (batchLoader, error) {
+		return newNDJSONLoader(in, lb.loadSchema(options), options)
+	})
+}
+
+// ingest commits the batches of a loader over the file at path as
+// operation op. With WithDrift, detect infers the input's schema to check
+// for drift before loading.
+func (lb *Lockbox) ingest(ctx context.Context, path, op string, options *Options, detect func() (*arrow.Schema, error), load func(io.Reader) (batchLoader, error)) (*IngestProgress, error) {
+	if options.Password == "" && !options.DryRun {
+		return nil, fmt.Errorf("password is required for ingestion")
+	}
+	batchSize := options.BatchSize
+	if batchSize <= 0 {
+		batchSize = DefaultIngestBatchSize
+	}
+	if options.Allocator == nil {
+		options.Allocator = lb.Allocator()
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open file: %w", err)
+	}
+	defer f.Close()
+	progress := &IngestProgress{Source: path, DryRun: options.DryRun}
+	if fi, err := f.Stat(); err == nil {
+		progress.Size = fi.Size()
+	}
+
+	lineage := metadata.Lineage{Source: path}
+	if options.Lineage != nil {
+		lineage = *options.Lineage
+		if lineage.Source == "" {
+			lineage.Source = path
+		}
+	}
+	if lineage.SourceHash == "" && !options.DryRun {
+		if lineage.SourceHash, err = SourceHash(path); err != nil {
+			return nil, err
+		}
+	}
+	if options.SchemaRegistry != nil {
+		if lineage.Schema, err = lb.checkSchemaRegistry(ctx, options.SchemaRegistry); err != nil {
+			return nil, err
+		}
+	}
+	if options.Drift != "" {
+		input, err := detect()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read the input schema: %w", err)
+		}
+		if progress.Drift, err = lb.resolveDrift(ctx, input, textFits, options); err != nil {
+			return progress, err
+		}
+	}
+	writeOpts := []Option{
+		WithPassword(options.Password),
+		WithPrincipal(options.Principal),
+		WithCodec(options.Codec),
+		WithDictionary(options.Dictionary...),
+		WithMessage(options.Message),
+		WithLineage(lineage),
+		withOperation(op),
+	}
+
+	in := &countingReader{r: f}
+	l, err := load(in)
+	if err != nil {
+		return nil, err
+	}
+	defer l.Release()
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return progress, err
+		}
+		rec, err := l.next(batchSize)
+		if err != nil {
+			return progress, err
+		}
+		rows := rec.NumRows()
+		if rows == 0 {
+			rec.Release()
+			break
+		}
+		if !options.DryRun {
+			err = lb.Write(ctx, rec, writeOpts...)
+		}
+		rec.Release()
+		if err != nil {
+			return progress, fmt.Errorf("failed to write batch %d: %w", progress.Batches+1, err)
+		}
+
+		progress.Batches++
+		progress.Rows += rows
+		progress.BytesRead = in.n
+		if options.Rejects != nil {
+			progress.Rejected = options.Rejects.Count
+		}
+		if options.Progress != nil {
+			options.Progress(*progress)
+		}
+	}
+	progress.BytesRead = in.n
+	if options.Rejects != nil {
+		progress.Rejected = options.Rejects.Count
+		progress.Errors = options.Rejects.Rows
+	}
+
+	log.Info().Str("file", path).Str("operation", op).Int64("rows", progress.Rows).Int("batches", progress.Batches).Int("rejected", progress.Rejected).Bool("dry_run", options.DryRun).Msg("Ingested file")
+	return progress, nil
+}
+
+// countingReader counts the bytes read from r
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
@@ -3,6 +3,8 @@ package lockbox
 import (
 	"context"
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/apache/arrow-go/v18/arrow"
@@ -57,9 +59,16 @@ func TestIngestParquet(t *testing.T) {
 	}
 	defer lb.Close()
 
-	if err := lb.IngestParquet(context.Background(), tmpParquet, WithPassword("pass")); err != nil {
+	if err := lb.IngestParquet(context.Background(), tmpParquet, WithPassword("pass"), WithMessage("initial load")); err != nil {
 		t.Fatalf("ingest: %v", err)
 	}
+	snaps := lb.Snapshots()
+	if len(snaps) != 1 || snaps[0].Operation != OperationIngestParquet || snaps[0].Message != "initial load" {
+		t.Fatalf("unexpected snapshots %+v", snaps)
+	}
+	if l := snaps[0].Lineage; l == nil || l.Source != tmpParquet || l.SourceHash == "" {
+		t.Fatalf("unexpected lineage %+v", l)
+	}
 
 	recOut, err := lb.Read(context.Background(), WithPassword("pass"))
 	if err != nil {
@@ -70,3 +79,60 @@ func TestIngestParquet(t *testing.T) {
 	}
 	recOut.Release()
 }
+
+func TestIngestParquetByName(t *testing.T) {
+	password := "test_password_123"
+	ctx := context.Background()
+	dir := t.TempDir()
+	schema := arrow.NewSchema([]arrow.Field{
+		{Name: "id", Type: arrow.PrimitiveTypes.Int64},
+		{Name: "name", Type: arrow.BinaryTypes.String},
+		{Name: "note", Type: arrow.BinaryTypes.String, Nullable: true},
+	}, nil)
+	lb, err := Create(filepath.Join(dir, "byname.lbx"), schema, WithPassword(password))
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	// Query results are released by cleanups, before the allocator is checked
+	t.Cleanup(func() { lb.Close() })
+
+	// The file's columns are reordered, lack note and add extra
+	mem := memory.NewGoAllocator()
+	pqSchema := arrow.NewSchema([]arrow.Field{
+		{Name: "extra", Type: arrow.PrimitiveTypes.Float64},
+		{Name: "Name", Type: arrow.BinaryTypes.String},
+		{Name: "id", Type: arrow.PrimitiveTypes.Int32},
+	}, nil)
+	b := array.NewRecordBuilder(mem, pqSchema)
+	b.Field(0).(*array.Float64Builder).AppendValues([]float64{0.5, 1.5}, nil)
+	b.Field(1).(*array.StringBuilder).AppendValues([]string{"ann", "bob"}, nil)
+	b.Field(2).(*array.Int32Builder).AppendValues([]int32{1, 2}, nil)
+	rec := b.NewRecord()
+	b.Release()
+	defer rec.Release()
+	path := filepath.Join(dir, "reordered.parquet")
+	if err := writeParquet(path, rec); err != nil {
+		t.Fatalf("write parquet: %v", err)
+	}
+
+	if err := lb.IngestParquet(ctx, path, WithPassword(password)); err == nil || !strings.Contains(err.Error(), "extra") {
+		t.Fatalf("expected the extra column to be refused, got %v", err)
+	}
+	if err := lb.IngestParquet(ctx, path, WithPassword(password), WithIgnoreExtraColumns()); err != nil {
+		t.Fatalf("ingest: %v", err)
+	}
+	res, err := lb.Query(ctx, "SELECT id, name, note FROM data ORDER BY id", WithPassword(password))
+	if err != nil {
+		t.Fatalf("query: %v", err)
+	}
+	t.Cleanup(res.Release)
+	if res.NumRows() != 2 || res.Column(0).(*array.Int64).Value(1) != 2 || res.Column(1).(*array.String).Value(1) != "bob" || res.Column(2).NullN() != 2 {
+		t.Errorf("expected ann and bob by name with null notes, got %v", res)
+	}
+
+	// A missing column that is not nullable is refused
+	noName := arrow.NewSchema([]arrow.Field{{Name: "id", Type: arrow.PrimitiveTypes.Int64}}, nil)
+	if err := validateParquetSchema(schema, noName, true); err == nil || !strings.Contains(err.Error(), "missing field name") {
+		t.Errorf("expected name to be missing, got %v", err)
+	}
+}
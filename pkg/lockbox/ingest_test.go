@@ -0,0 +1,124 @@
+package lockbox
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestIngestCSV(t *testing.T) {
+	dir := t.TempDir()
+	password := "test_password_123"
+	ctx := context.Background()
+	lb := newQueryTestLockbox(t, filepath.Join(dir, "data.lbx"), password)
+	defer lb.Close()
+
+	var csv strings.Builder
+	csv.WriteString("id,name,score\n")
+	for i := 10; i < 20; i++ {
+		fmt.Fprintf(&csv, "%d,user%d,%d.5\n", i, i, i)
+	}
+	csv.WriteString("20,bad,not-a-number\n")
+	path := filepath.Join(dir, "in.csv")
+	if err := os.WriteFile(path, []byte(csv.String()), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := lb.IngestCSV(ctx, path, WithPassword(password), WithBatchSize(4), WithDryRun(true)); err == nil {
+		t.Fatalf("expected the bad row to abort the dry run")
+	}
+	if n := len(lb.Snapshots()); n != 1 {
+		t.Fatalf("expected the dry run to commit nothing, got %d commits", n)
+	}
+
+	var reports []IngestProgress
+	rejects := &Rejects{}
+	res, err := lb.IngestCSV(ctx, path,
+		WithPassword(password),
+		WithBatchSize(4),
+		WithOnError(OnErrorSkip, rejects),
+		WithProgress(func(p IngestProgress) { reports = append(reports, p) }))
+	if err != nil {
+		t.Fatalf("ingest: %v", err)
+	}
+	if res.Rows != 10 || res.Batches != 3 || res.Rejected != 1 || res.BytesRead != res.Size {
+		t.Errorf("unexpected result: %+v", res)
+	}
+	if len(reports) != 3 || reports[0].Rows != 4 || reports[2].Rows != 10 {
+		t.Errorf("expected progress after each of 3 batches, got %+v", reports)
+	}
+
+	snaps := lb.Snapshots()
+	if len(snaps) != 4 || snaps[3].Operation != OperationIngestCSV || snaps[3].Lineage == nil || snaps[3].Lineage.SourceHash == "" {
+		t.Fatalf("expected 3 ingest commits with lineage, got %+v", snaps)
+	}
+	out, err := lb.Query(ctx, "SELECT COUNT(*) AS n, SUM(score) AS s FROM data WHERE id >= 10", WithPassword(password))
+	if err != nil {
+		t.Fatalf("query: %v", err)
+	}
+	defer out.Release()
+	if out.Column(0).ValueStr(0) != "10" || out.Column(1).ValueStr(0) != "150" {
+		t.Errorf("expected 10 rows summing to 150, got %v", out)
+	}
+}
+
+func TestIngestNDJSON(t *testing.T) {
+	dir := t.TempDir()
+	password := "test_password_123"
+	ctx := context.Background()
+
+	var in strings.Builder
+	for i := 1; i <= 9; i++ {
+		fmt.Fprintf(&in, `{"id": %d, "name": "user%d", "score": %d.5}`+"\n", i, i, i)
+		if i == 3 {
+			in.WriteString("{not json\n")
+		}
+		if i == 6 {
+			in.WriteString(`{"id": "seven", "name": "bad", "score": 1}` + "\n")
+		}
+	}
+	path := filepath.Join(dir, "in.ndjson")
+	if err := os.WriteFile(path, []byte(in.String()), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	schema, err := DetectJSONSchema(path, 3)
+	if err != nil {
+		t.Fatalf("detect: %v", err)
+	}
+	lb, err := Create(filepath.Join(dir, "events.lbx"), schema, WithPassword(password))
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	defer lb.Close()
+
+	if _, err := lb.IngestNDJSON(ctx, path, WithPassword(password), WithOnError(OnErrorAbort, nil)); err == nil {
+		t.Fatalf("expected the bad line to abort")
+	}
+
+	res, err := lb.IngestNDJSON(ctx, path, WithPassword(password), WithBatchSize(4))
+	if err != nil {
+		t.Fatalf("ingest: %v", err)
+	}
+	if res.Rows != 9 || res.Batches != 3 || res.Rejected != 2 || len(res.Errors) != 2 {
+		t.Fatalf("unexpected result: %+v", res)
+	}
+	if res.Errors[0].Line != 4 || res.Errors[0].Column != "" || res.Errors[1].Line != 8 || res.Errors[1].Column != "id" {
+		t.Errorf("expected the malformed line 4 and the bad id on line 8, got %+v", res.Errors)
+	}
+	if snaps := lb.Snapshots(); snaps[len(snaps)-1].Operation != OperationIngestNDJSON {
+		t.Errorf("expected an ndjson ingest commit, got %+v", snaps[len(snaps)-1])
+	}
+
+	out, err := lb.Query(ctx, "SELECT COUNT(*) AS n, SUM(score) AS s FROM data", WithPassword(password))
+	if err != nil {
+		t.Fatalf("query: %v", err)
+	}
+	defer out.Release()
+	if out.Column(0).ValueStr(0) != "9" || out.Column(1).ValueStr(0) != "49.5" {
+		t.Errorf("expected 9 rows summing to 49.5, got %v", out)
+	}
+}
@@ -0,0 +1,206 @@
+package lockbox
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"os"
+
+	"github.com/apache/arrow-go/v18/arrow"
+	"github.com/apache/arrow-go/v18/arrow/array"
+)
+
+// LoadJSON reads a JSON array of objects or NDJSON into a record with the
+// given schema. Fields are matched to columns by name, or as described by
+// WithColumnMapping; missing fields,
+// nulls and empty strings are null in nullable columns. Strings are
+// converted for numeric, decimal, boolean, date, time, duration and
+// timestamp columns, and other values
+// are kept as JSON text in string columns. WithOnError decides what happens
+// to objects that do not fit. String columns come back dictionary
+// encoded with WithInternStrings or WithDictionary. The caller releases the
+// record.
+func LoadJSON(path string, schema *arrow.Schema, opts ...Option) (arrow.Record, error) {
+	options := &Options{}
+	for _, opt := range opts {
+		opt(options)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open file: %w", err)
+	}
+	defer f.Close()
+
+	br := bufio.NewReader(f)
+	skipBOM(br)
+	objects, err := sampleJSONObjects(br, math.MaxInt)
+	if err != nil {
+		return nil, err
+	}
+
+	if options.Mapping != nil {
+		if err := options.Mapping.validate(schema); err != nil {
+			return nil, err
+		}
+	}
+
+	b := array.NewRecordBuilder(loadAllocator(options), internSchema(schema, options))
+	defer b.Release()
+	c := newJSONConverter(schema, options)
+	for n, obj := range objects {
+		if _, err := c.add(b, obj, n+1, "object"); err != nil {
+			return nil, err
+		}
+	}
+	return b.NewRecord(), nil
+}
+
+// jsonConverter appends JSON objects to a record builder
+type jsonConverter struct {
+	parser  *valueParser
+	schema  *arrow.Schema
+	options *Options
+	appends []func()
+}
+
+func newJSONConverter(schema *arrow.Schema, options *Options) *jsonConverter {
+	return &jsonConverter{
+		parser:  newValueParser(options),
+		schema:  schema,
+		options: options,
+		appends: make([]func(), schema.NumFields()),
+	}
+}
+
+// add converts obj, the object at pos, and appends it to b. Objects that
+// do not fit are rejected under the error policy, reporting pos as a kind
+// such as "object" or "line"; add reports whether obj was appended.
+func (c *jsonConverter) add(b *array.RecordBuilder, obj *jsonObject, pos int, kind string) (bool, error) {
+	var err error
+	for i, field := range c.schema.Fields() {
+		raw := obj.values[field.Name]
+		if c.options.Mapping != nil {
+			raw = c.options.Mapping.field(field.Name).jsonValue(obj)
+		}
+		if c.appends[i], err = c.parser.parseJSONValue(b.Field(i), field, raw); err != nil {
+			if field.Nullable && c.options.schemaDrift.coerces(field.Name) {
+				c.appends[i] = b.Field(i).AppendNull
+				continue
+			}
+			var compact bytes.Buffer
+			json.Compact(&compact, obj.raw)
+			rejected := RejectedRow{Line: pos, Column: field.Name, Reason: err.Error(), Raw: compact.String()}
+			return false, rejectRow(c.options, rejected, fmt.Errorf("%s %d, column %s: %w", kind, pos, field.Name, err))
+		}
+	}
+	for _, appendValue := range c.appends {
+		appendValue()
+	}
+	return true, nil
+}
+
+// ndjsonLoader converts the objects of NDJSON, one per line, batch by
+// batch. Lines that are not JSON objects are rejected like objects that
+// do not fit the schema.
+type ndjsonLoader struct {
+	br      *bufio.Reader
+	options *Options
+	conv    *jsonConverter
+	builder *array.RecordBuilder
+	line    int
+}
+
+// newNDJSONLoader reads NDJSON from in
+func newNDJSONLoader(in io.Reader, schema *arrow.Schema, options *Options) (*ndjsonLoader, error) {
+	if options.Mapping != nil {
+		if err := options.Mapping.validate(schema); err != nil {
+			return nil, err
+		}
+	}
+	br := bufio.NewReader(in)
+	skipBOM(br)
+	return &ndjsonLoader{
+		br:      br,
+		options: options,
+		conv:    newJSONConverter(schema, options),
+		builder: array.NewRecordBuilder(loadAllocator(options), internSchema(schema, options)),
+	}, nil
+}
+
+// Release frees the builder of the loader
+func (l *ndjsonLoader) Release() {
+	l.builder.Release()
+}
+
+// next converts up to max objects, or all that are left when max <= 0.
+// An empty record means the input is exhausted.
+func (l *ndjsonLoader) next(max int) (arrow.Record, error) {
+	for rows := 0; max <= 0 || rows < max; {
+		line, err := l.br.ReadBytes('\n')
+		if err != nil && err != io.EOF {
+			return nil, fmt.Errorf("failed to read line %d: %w", l.line+1, err)
+		}
+		if len(line) > 0 {
+			l.line++
+		}
+		if line = bytes.TrimSpace(line); len(line) > 0 {
+			obj := &jsonObject{}
+			if jerr := json.Unmarshal(line, obj); jerr != nil {
+				rejected := RejectedRow{Line: l.line, Reason: jerr.Error(), Raw: string(line)}
+				if rerr := rejectRow(l.options, rejected, fmt.Errorf("line %d: %w", l.line, jerr)); rerr != nil {
+					return nil, rerr
+				}
+			} else {
+				added, aerr := l.conv.add(l.builder, obj, l.line, "line")
+				if aerr != nil {
+					return nil, aerr
+				}
+				if added {
+					rows++
+				}
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+	}
+	return l.builder.NewRecord(), nil
+}
+
+// parseJSONValue converts a JSON value for the builder's type. The returned
+// function appends the value.
+func (p *valueParser) parseJSONValue(b array.Builder, field arrow.Field, raw json.RawMessage) (func(), error) {
+	raw = bytes.TrimSpace(raw)
+	if len(raw) == 0 || string(raw) == "null" || string(raw) == `""` {
+		if field.Nullable {
+			return b.AppendNull, nil
+		}
+		if len(raw) == 0 || string(raw) == "null" {
+			return nil, fmt.Errorf("missing value")
+		}
+	}
+	if sb, ok := b.(*array.StringBuilder); ok && isJSONField(field) {
+		// JSON columns keep the value as JSON, quotes included
+		text := string(raw)
+		return func() { sb.Append(text) }, nil
+	}
+	if raw[0] == '"' {
+		var s string
+		if err := json.Unmarshal(raw, &s); err != nil {
+			return nil, err
+		}
+		return p.parseValue(b, s)
+	}
+	switch sb := b.(type) {
+	case *array.StringBuilder:
+		text := string(raw)
+		return func() { sb.Append(text) }, nil
+	case *array.BinaryDictionaryBuilder:
+		return appendDictionaryString(sb, string(raw)), nil
+	}
+	return p.parseValue(b, string(raw))
+}
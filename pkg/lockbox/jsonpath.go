@@ -0,0 +1,299 @@
+package lockbox
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/apache/arrow-go/v18/arrow"
+	"github.com/apache/arrow-go/v18/arrow/array"
+	"github.com/apache/arrow-go/v18/arrow/memory"
+)
+
+// JSONMime is the MIME type of JSON columns: string columns holding JSON
+// documents, whose values writes check. json_extract(col, '$.a.b') reads
+// values out of them in SELECT and WHERE.
+const JSONMime = "application/json"
+
+// JSONField returns a string field holding JSON documents
+func JSONField(name string, nullable bool) arrow.Field {
+	return arrow.Field{
+		Name:     name,
+		Type:     arrow.BinaryTypes.String,
+		Nullable: nullable,
+		Metadata: arrow.NewMetadata([]string{"mime"}, []string{JSONMime}),
+	}
+}
+
+// isJSONField reports whether a field is a JSON column
+func isJSONField(f arrow.Field) bool {
+	v, ok := f.Metadata.GetValue("mime")
+	return ok && v == JSONMime
+}
+
+// checkJSONColumns returns an error for the first value of a JSON column of
+// rec that is not valid JSON. The columns are found in schema, which keeps
+// the field metadata of the file.
+func checkJSONColumns(schema *arrow.Schema, rec arrow.Record) error {
+	for _, f := range schema.Fields() {
+		if !isJSONField(f) {
+			continue
+		}
+		idx := rec.Schema().FieldIndices(f.Name)
+		if len(idx) == 0 {
+			continue
+		}
+		docs, err := jsonDocs(f.Name, rec.Column(idx[0]))
+		if err != nil {
+			return err
+		}
+		for row := 0; row < int(rec.NumRows()); row++ {
+			if doc, ok := docs(row); ok && !json.Valid(doc) {
+				return fmt.Errorf("column %s row %d: invalid JSON", f.Name, row)
+			}
+		}
+	}
+	return nil
+}
+
+// jsonDocs returns a function reading the document at a row of a string or
+// binary column, reporting false for nulls
+func jsonDocs(name string, col arrow.Array) (func(row int) ([]byte, bool), error) {
+	switch c := col.(type) {
+	case *array.String:
+		return func(row int) ([]byte, bool) {
+			return []byte(c.Value(row)), c.IsValid(row)
+		}, nil
+	case *array.LargeString:
+		return func(row int) ([]byte, bool) {
+			return []byte(c.Value(row)), c.IsValid(row)
+		}, nil
+	case *array.Binary:
+		return func(row int) ([]byte, bool) {
+			return c.Value(row), c.IsValid(row)
+		}, nil
+	case *array.LargeBinary:
+		return func(row int) ([]byte, bool) {
+			return c.Value(row), c.IsValid(row)
+		}, nil
+	}
+	return nil, fmt.Errorf("json_extract needs a string or binary column, %s is %s", name, col.DataType())
+}
+
+// jsonPath is a path into JSON documents such as $.address.city or
+// $.items[0].sku. Keys that are not plain names are quoted, as in
+// $."first name" or $["first name"].
+type jsonPath struct {
+	Text  string
+	Steps []jsonStep
+}
+
+// jsonStep selects an object member by Key, or an array element by Index
+// when Key is empty
+type jsonStep struct {
+	Key   string
+	Index int
+}
+
+// parseJSONPath parses a path starting at $
+func parseJSONPath(s string) (*jsonPath, error) {
+	if !strings.HasPrefix(s, "$") {
+		return nil, fmt.Errorf("invalid JSON path %q: must start with $", s)
+	}
+	jp := &jsonPath{Text: s}
+	rest := s[1:]
+	for rest != "" {
+		switch rest[0] {
+		case '.':
+			rest = rest[1:]
+			if strings.HasPrefix(rest, `"`) {
+				end := strings.IndexByte(rest[1:], '"')
+				if end < 0 {
+					return nil, fmt.Errorf("invalid JSON path %q: unterminated key", s)
+				}
+				jp.Steps = append(jp.Steps, jsonStep{Key: rest[1 : end+1]})
+				rest = rest[end+2:]
+				continue
+			}
+			end := strings.IndexAny(rest, ".[")
+			if end < 0 {
+				end = len(rest)
+			}
+			if end == 0 {
+				return nil, fmt.Errorf("invalid JSON path %q: empty key", s)
+			}
+			jp.Steps = append(jp.Steps, jsonStep{Key: rest[:end]})
+			rest = rest[end:]
+		case '[':
+			end := strings.IndexByte(rest, ']')
+			if end < 0 {
+				return nil, fmt.Errorf("invalid JSON path %q: missing ]", s)
+			}
+			inner := rest[1:end]
+			rest = rest[end+1:]
+			if len(inner) >= 2 && (inner[0] == '"' || inner[0] == '\'') && inner[len(inner)-1] == inner[0] {
+				jp.Steps = append(jp.Steps, jsonStep{Key: inner[1 : len(inner)-1]})
+				continue
+			}
+			n, err := strconv.Atoi(inner)
+			if err != nil || n < 0 {
+				return nil, fmt.Errorf("invalid JSON path %q: bad index [%s]", s, inner)
+			}
+			jp.Steps = append(jp.Steps, jsonStep{Index: n})
+		default:
+			return nil, fmt.Errorf("invalid JSON path %q at %q", s, rest)
+		}
+	}
+	return jp, nil
+}
+
+// parseJSONExtract parses "(col, 'path')" after json_extract
+func (p *queryParser) parseJSONExtract() (string, *jsonPath, error) {
+	p.next()
+	col, err := p.expect(tokIdent, "column in json_extract")
+	if err != nil {
+		return "", nil, err
+	}
+	if _, err := p.expect(tokComma, "',' in json_extract"); err != nil {
+		return "", nil, err
+	}
+	path, err := p.expect(tokString, "quoted path in json_extract")
+	if err != nil {
+		return "", nil, err
+	}
+	if _, err := p.expect(tokRParen, "')' after json_extract"); err != nil {
+		return "", nil, err
+	}
+	jp, err := parseJSONPath(path.Text)
+	if err != nil {
+		return "", nil, err
+	}
+	return strings.ToLower(col.Text), jp, nil
+}
+
+// outputName names the result column of json_extract on col without an
+// alias, e.g. payload.address.city
+func (jp *jsonPath) outputName(col string) string {
+	return col + strings.TrimPrefix(jp.Text, "$")
+}
+
+// extract returns the value at the path in doc. It reports false for
+// invalid documents, missing members and JSON null.
+func (jp *jsonPath) extract(doc []byte) (any, bool) {
+	dec := json.NewDecoder(bytes.NewReader(doc))
+	dec.UseNumber()
+	var v any
+	if err := dec.Decode(&v); err != nil {
+		return nil, false
+	}
+	for _, step := range jp.Steps {
+		switch node := v.(type) {
+		case map[string]any:
+			if step.Key == "" {
+				return nil, false
+			}
+			v = node[step.Key]
+		case []any:
+			if step.Key != "" || step.Index >= len(node) {
+				return nil, false
+			}
+			v = node[step.Index]
+		default:
+			return nil, false
+		}
+	}
+	return v, v != nil
+}
+
+// jsonText renders an extracted value: strings without quotes, numbers
+// and booleans as written, objects and arrays as JSON
+func jsonText(v any) string {
+	switch x := v.(type) {
+	case string:
+		return x
+	case json.Number:
+		return x.String()
+	case bool:
+		return strconv.FormatBool(x)
+	}
+	b, _ := json.Marshal(v)
+	return string(b)
+}
+
+// appendExtracted appends the text of the value at the path in a row of a
+// document column, or null
+func (jp *jsonPath) appendExtracted(b *array.StringBuilder, docs func(int) ([]byte, bool), row int) {
+	doc, ok := docs(row)
+	if !ok {
+		b.AppendNull()
+		return
+	}
+	v, ok := jp.extract(doc)
+	if !ok {
+		b.AppendNull()
+		return
+	}
+	b.Append(jsonText(v))
+}
+
+// extractColumn extracts the value at the path from every row of col, for
+// WHERE conditions. With numeric the values are float64 and values that
+// are not JSON numbers are null; otherwise they are text.
+func (jp *jsonPath) extractColumn(name string, col arrow.Array, numeric bool) (arrow.Array, error) {
+	docs, err := jsonDocs(name, col)
+	if err != nil {
+		return nil, err
+	}
+	value := func(row int) any {
+		if doc, ok := docs(row); ok {
+			v, _ := jp.extract(doc)
+			return v
+		}
+		return nil
+	}
+
+	if numeric {
+		b := array.NewFloat64Builder(memory.DefaultAllocator)
+		defer b.Release()
+		for row := 0; row < col.Len(); row++ {
+			n, ok := value(row).(json.Number)
+			f, err := n.Float64()
+			if !ok || err != nil {
+				b.AppendNull()
+				continue
+			}
+			b.Append(f)
+		}
+		return b.NewArray(), nil
+	}
+	b := array.NewStringBuilder(memory.DefaultAllocator)
+	defer b.Release()
+	for row := 0; row < col.Len(); row++ {
+		if v := value(row); v != nil {
+			b.Append(jsonText(v))
+		} else {
+			b.AppendNull()
+		}
+	}
+	return b.NewArray(), nil
+}
+
+// numeric reports whether a json_extract condition compares numbers: a
+// comparison with a number, or IN with a list of numbers
+func (w *wherePredicate) numeric() bool {
+	vals := w.Vals
+	switch {
+	case w.Op == "LIKE", w.Sub != nil:
+		return false
+	case w.Op != "IN":
+		vals = []string{w.Val}
+	}
+	for _, v := range vals {
+		if _, err := strconv.ParseFloat(v, 64); err != nil {
+			return false
+		}
+	}
+	return true
+}
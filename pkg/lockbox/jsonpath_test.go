@@ -0,0 +1,123 @@
+package lockbox
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/apache/arrow-go/v18/arrow"
+	"github.com/apache/arrow-go/v18/arrow/array"
+	"github.com/apache/arrow-go/v18/arrow/memory"
+)
+
+func TestJSONExtract(t *testing.T) {
+	password := "test_password_123"
+	ctx := context.Background()
+	schema := arrow.NewSchema([]arrow.Field{
+		{Name: "id", Type: arrow.PrimitiveTypes.Int64},
+		JSONField("payload", true),
+	}, nil)
+
+	lb, err := Create(filepath.Join(t.TempDir(), "events.lbx"), schema, WithPassword(password))
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	defer lb.Close()
+
+	write := func(docs ...string) error {
+		b := array.NewRecordBuilder(memory.NewGoAllocator(), schema)
+		defer b.Release()
+		for i, doc := range docs {
+			b.Field(0).(*array.Int64Builder).Append(int64(i + 1))
+			if doc == "" {
+				b.Field(1).AppendNull()
+			} else {
+				b.Field(1).(*array.StringBuilder).Append(doc)
+			}
+		}
+		rec := b.NewRecord()
+		defer rec.Release()
+		return lb.Write(ctx, rec, WithPassword(password))
+	}
+	if err := write(`{"name": "ann"`); err == nil || !strings.Contains(err.Error(), "invalid JSON") {
+		t.Fatalf("expected invalid JSON to be refused, got %v", err)
+	}
+	if err := write(
+		`{"name": "ann", "age": 34, "address": {"city": "Oslo"}, "tags": ["a", "b"]}`,
+		`{"name": "bob", "age": 27, "address": {"city": "Rome"}, "first name": "Bob"}`,
+		"",
+		`{"name": "cy", "age": "unknown", "address": null}`,
+	); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+
+	strs := func(query string) []string {
+		t.Helper()
+		res, err := lb.Query(ctx, query, WithPassword(password))
+		if err != nil {
+			t.Fatalf("%s: %v", query, err)
+		}
+		defer res.Release()
+		col := res.Column(res.Schema().NumFields() - 1)
+		out := make([]string, col.Len())
+		for i := range out {
+			if col.IsNull(i) {
+				out[i] = "NULL"
+			} else {
+				out[i] = col.(*array.String).Value(i)
+			}
+		}
+		return out
+	}
+	for query, want := range map[string]string{
+		"SELECT id, json_extract(payload, '$.address.city') AS city FROM data ORDER BY id":                                                                          "Oslo Rome NULL NULL",
+		"SELECT json_extract(payload, '$.tags[1]') FROM data WHERE id = 1":                                                                                          "b",
+		`SELECT json_extract(payload, '$."first name"') FROM data WHERE id = 2`:                                                                                     "Bob",
+		"SELECT json_extract(payload, '$.address') FROM data WHERE id = 1":                                                                                          `{"city":"Oslo"}`,
+		"SELECT id, json_extract(payload, '$.name') FROM data WHERE json_extract(payload, '$.age') > 30":                                                            "ann",
+		"SELECT id, json_extract(payload, '$.name') FROM data WHERE json_extract(payload, '$.age') <= 27 OR json_extract(payload, '$.age') = 'unknown' ORDER BY id": "bob cy",
+		"SELECT id, json_extract(payload, '$.name') FROM data WHERE json_extract(payload, '$.address.city') IN ('Rome', 'Paris')":                                   "bob",
+		"SELECT id, json_extract(payload, '$.name') FROM data WHERE json_extract(payload, '$.name') LIKE '%n%' ORDER BY id":                                         "ann",
+	} {
+		if got := strings.Join(strs(query), " "); got != want {
+			t.Errorf("%s: expected %q, got %q", query, want, got)
+		}
+	}
+
+	// JSON input keeps documents, strings included, as JSON
+	ndjson := filepath.Join(t.TempDir(), "events.ndjson")
+	if err := os.WriteFile(ndjson, []byte(`{"id": 5, "payload": {"a": [1, 2]}}`+"\n"+`{"id": 6, "payload": "x"}`+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadJSON(ndjson, schema)
+	if err != nil {
+		t.Fatalf("load json: %v", err)
+	}
+	if docs := loaded.Column(1).(*array.String); docs.Value(0) != `{"a": [1, 2]}` || docs.Value(1) != `"x"` {
+		t.Errorf("expected JSON documents, got %v", docs)
+	}
+	loaded.Release()
+
+	res, err := lb.Query(ctx, "SELECT json_extract(payload, '$.tags[0]') FROM data LIMIT 1", WithPassword(password))
+	if err != nil {
+		t.Fatalf("query: %v", err)
+	}
+	if name := res.Schema().Field(0).Name; name != "payload.tags[0]" {
+		t.Errorf("expected the column to be named payload.tags[0], got %s", name)
+	}
+	res.Release()
+
+	for _, query := range []string{
+		"SELECT json_extract(id, '$.a') FROM data",
+		"SELECT json_extract(payload, 'a.b') FROM data",
+		"SELECT json_extract(payload, '$.a[x]') FROM data",
+		"SELECT id FROM data WHERE json_extract(payload) = 1",
+		"SELECT id FROM data WHERE json_extract(name, '$.age') > 30",
+	} {
+		if _, err := lb.Query(ctx, query, WithPassword(password)); err == nil {
+			t.Errorf("%s: expected an error", query)
+		}
+	}
+}
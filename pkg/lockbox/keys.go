@@ -0,0 +1,212 @@
+package lockbox
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/TFMV/lockbox/pkg/crypto"
+	"github.com/TFMV/lockbox/pkg/format"
+	"github.com/TFMV/lockbox/pkg/metadata"
+	"github.com/rs/zerolog/log"
+)
+
+// escrowBundleVersion is the version of the escrow bundle encoding
+const escrowBundleVersion = 1
+
+// EscrowBundle is a column key sealed to an escrow public key
+type EscrowBundle struct {
+	Version   int       `json:"version"`
+	File      string    `json:"file"`
+	Column    string    `json:"column"`
+	CreatedAt time.Time `json:"createdAt"`
+	Sealed    []byte    `json:"sealed"`
+}
+
+// WithColumnKey lets queries decrypt a column with key material recovered
+// from escrow instead of the password
+func WithColumnKey(key *format.ColumnKey) Option {
+	return func(o *Options) {
+		o.ColumnKeys = append(o.ColumnKeys, key)
+	}
+}
+
+// ExportColumnKey seals the key of one column to an escrow public key (PEM).
+// The returned bundle lets the escrow holder read that column with
+// ImportColumnKey if the password is lost. A column still at key epoch 0
+// shares the file's secret with every other column, so it is rotated to a
+// key of its own first, which re-encrypts its blocks.
+func (lb *Lockbox) ExportColumnKey(column string, escrowPublicKey []byte, opts ...Option) ([]byte, error) {
+	options := &Options{}
+	for _, opt := range opts {
+		opt(options)
+	}
+	if options.Password == "" {
+		return nil, fmt.Errorf("password is required for exporting keys")
+	}
+	if err := lb.ownColumnKeys(options, column); err != nil {
+		return nil, err
+	}
+
+	reader, err := lb.file.NewReader(options.Password)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create reader: %w", err)
+	}
+	ck, err := reader.ExportColumnKey(column)
+	if err != nil {
+		return nil, err
+	}
+
+	plaintext, err := json.Marshal(ck)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode column key: %w", err)
+	}
+	sealed, err := crypto.SealToEscrow(escrowPublicKey, plaintext)
+	if err != nil {
+		return nil, fmt.Errorf("failed to seal column key: %w", err)
+	}
+
+	bundle, err := json.MarshalIndent(EscrowBundle{
+		Version:   escrowBundleVersion,
+		File:      ck.File,
+		Column:    column,
+		CreatedAt: time.Now(),
+		Sealed:    sealed,
+	}, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode escrow bundle: %w", err)
+	}
+
+	lb.file.Metadata().LogAccess(options.CreatedBy, "export-column-key", column, true, "")
+	if err := lb.file.SaveMetadata(); err != nil {
+		return nil, err
+	}
+	return bundle, nil
+}
+
+// ownColumnKeys rotates the columns whose key material is still at key
+// epoch 0, shared with the whole file, to keys of their own so it can be
+// handed out. Blocks of a lazily rotated column left at epoch 0 are
+// re-encrypted too.
+func (lb *Lockbox) ownColumnKeys(options *Options, columns ...string) error {
+	for _, column := range columns {
+		if len(lb.file.Metadata().Schema.FieldIndices(column)) == 0 {
+			return fmt.Errorf("column %s not found", column)
+		}
+		if !lb.file.SharesFileSecret(column) {
+			continue
+		}
+		epoch, err := lb.RotateColumnKey(column, WithPassword(options.Password), WithPrincipal(options.Principal))
+		if err != nil {
+			return err
+		}
+		log.Info().Str("column", column).Int("epoch", epoch).Msg("Rotated column key off the file's shared secret")
+	}
+	return nil
+}
+
+// WithLazyRotation makes RotateColumnKey switch new writes to the new key
+// without re-encrypting existing blocks; CompactKeys re-encrypts them later
+func WithLazyRotation() Option {
+	return func(o *Options) {
+		o.LazyRotation = true
+	}
+}
+
+// RotateColumnKey re-encrypts the blocks of one column under a new key,
+// e.g. after its key was exported and may have been exposed. Other columns
+// are left untouched. Rotated columns get their own post-quantum secret,
+// which ExportColumnKey and GrantAccess need.
+// With WithLazyRotation only new writes use the new key until CompactKeys
+// runs; reads pick the key of each block. It returns the column's new key
+// epoch.
+func (lb *Lockbox) RotateColumnKey(column string, opts ...Option) (int, error) {
+	options := &Options{CreatedBy: "system"}
+	for _, opt := range opts {
+		opt(options)
+	}
+	if options.Password == "" {
+		return 0, fmt.Errorf("password is required for rotating keys")
+	}
+
+	writer, err := lb.file.NewWriter(options.Password)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create writer: %w", err)
+	}
+	rotate := writer.RotateColumnKey
+	if options.LazyRotation {
+		rotate = writer.AdvanceColumnKey
+	}
+	epoch, err := rotate(column)
+	if err != nil {
+		return 0, fmt.Errorf("failed to rotate key of column %s: %w", column, err)
+	}
+	lb.notify(context.Background(), Event{
+		Event:     metadata.EventRotate,
+		Column:    column,
+		Principal: options.Principal,
+		Stats:     map[string]int64{"keyEpoch": int64(epoch)},
+	})
+	return epoch, nil
+}
+
+// CompactKeys re-encrypts the blocks left at an older key epoch by lazy
+// rotations and returns how many were re-encrypted. It stops early, keeping
+// the work done, when ctx is cancelled, so it can run in the background.
+func (lb *Lockbox) CompactKeys(ctx context.Context, opts ...Option) (int, error) {
+	options := &Options{}
+	for _, opt := range opts {
+		opt(options)
+	}
+	if options.Password == "" {
+		return 0, fmt.Errorf("password is required for compacting keys")
+	}
+	if lb.file.StaleKeyBlocks() == 0 {
+		return 0, nil
+	}
+
+	writer, err := lb.file.NewWriter(options.Password)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create writer: %w", err)
+	}
+	n, err := writer.CompactKeys(ctx, -1)
+	if n > 0 {
+		lb.notify(ctx, Event{Event: metadata.EventCompact, Operation: "compact-keys", Stats: map[string]int64{"blocks": int64(n)}})
+	}
+	if err != nil {
+		return n, fmt.Errorf("failed to compact keys: %w", err)
+	}
+	log.Info().Int("blocks", n).Msg("Re-encrypted blocks with current column keys")
+	return n, nil
+}
+
+// ImportColumnKey opens an escrow bundle with the escrow private key (PEM).
+// Pass the result to Query with WithColumnKey.
+func ImportColumnKey(bundle, escrowPrivateKey []byte) (*format.ColumnKey, error) {
+	var eb EscrowBundle
+	if err := json.Unmarshal(bundle, &eb); err != nil {
+		return nil, fmt.Errorf("failed to decode escrow bundle: %w", err)
+	}
+	if eb.Version != escrowBundleVersion {
+		return nil, fmt.Errorf("unsupported escrow bundle version %d", eb.Version)
+	}
+
+	key, err := crypto.ParseEscrowPrivateKey(escrowPrivateKey)
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := key.Open(eb.Sealed)
+	if err != nil {
+		return nil, err
+	}
+
+	var ck format.ColumnKey
+	if err := json.Unmarshal(plaintext, &ck); err != nil {
+		return nil, fmt.Errorf("failed to decode column key: %w", err)
+	}
+	if ck.File != eb.File || ck.Column != eb.Column {
+		return nil, fmt.Errorf("escrow bundle does not match its sealed key")
+	}
+	return &ck, nil
+}
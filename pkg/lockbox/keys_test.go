@@ -0,0 +1,588 @@
+package lockbox
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/TFMV/lockbox/pkg/crypto"
+	"github.com/TFMV/lockbox/pkg/format"
+	"github.com/TFMV/lockbox/pkg/metadata"
+	"github.com/apache/arrow-go/v18/arrow"
+	"github.com/apache/arrow-go/v18/arrow/array"
+	"github.com/apache/arrow-go/v18/arrow/memory"
+)
+
+func TestColumnKeyEscrow(t *testing.T) {
+	tmpFile := "/tmp/test_lockbox_escrow.lbx"
+	defer os.Remove(tmpFile)
+
+	password := "test_password_123"
+	lb := newQueryTestLockbox(t, tmpFile, password)
+
+	escrow, err := crypto.GenerateEscrowKey()
+	if err != nil {
+		t.Fatalf("generate escrow key: %v", err)
+	}
+	bundle, err := lb.ExportColumnKey("name", escrow.PublicPEM(), WithPassword(password))
+	if err != nil {
+		t.Fatalf("export: %v", err)
+	}
+	lb.Close()
+
+	other, _ := crypto.GenerateEscrowKey()
+	if _, err := ImportColumnKey(bundle, other.PrivatePEM()); err == nil {
+		t.Fatalf("expected import with the wrong escrow key to fail")
+	}
+
+	ck, err := ImportColumnKey(bundle, escrow.PrivatePEM())
+	if err != nil {
+		t.Fatalf("import: %v", err)
+	}
+
+	lb, err = Open(tmpFile, WithColumnKey(ck))
+	if err != nil {
+		t.Fatalf("open with column key: %v", err)
+	}
+	defer lb.Close()
+
+	ctx := context.Background()
+	res, err := lb.Query(ctx, "SELECT name FROM data", WithColumnKey(ck))
+	if err != nil {
+		t.Fatalf("query: %v", err)
+	}
+	defer res.Release()
+	if res.NumRows() != 3 || res.Column(0).(*array.String).Value(2) != "carol" {
+		t.Fatalf("unexpected result with escrowed key")
+	}
+
+	if _, err := lb.Query(ctx, "SELECT score FROM data", WithColumnKey(ck)); err == nil {
+		t.Fatalf("expected other columns to stay locked")
+	}
+}
+
+// edwards25519Order is the order of the group of hybrid secrets
+var edwards25519Order, _ = new(big.Int).SetString("7237005577332262213973186563042994240857116359379907606001950938285454250989", 10)
+
+// recoversMasterKey reports whether a hybrid secret gives away the master
+// key of a file. The secret of key epoch 0 is the master key reduced mod
+// the group order, and the block signing key confirms a guess of what was
+// reduced away.
+func recoversMasterKey(meta *metadata.Metadata, secret []byte) bool {
+	le := func(b []byte) []byte {
+		r := bytes.Clone(b)
+		for i, j := 0, len(r)-1; i < j; i, j = i+1, j-1 {
+			r[i], r[j] = r[j], r[i]
+		}
+		return r
+	}
+	v := new(big.Int).SetBytes(le(secret))
+	for k := int64(0); k < 16; k++ {
+		candidate := new(big.Int).Add(v, new(big.Int).Mul(big.NewInt(k), edwards25519Order))
+		if candidate.BitLen() > 256 {
+			break
+		}
+		master := le(candidate.FillBytes(make([]byte, 32)))
+		pub := crypto.BlockSigningKey(master).Public().(ed25519.PublicKey)
+		if bytes.Equal(pub, meta.Encryption.SigningKey) {
+			return true
+		}
+	}
+	return false
+}
+
+// An exported column key must not reveal the master key or decrypt other
+// columns
+func TestColumnKeyExportIsolated(t *testing.T) {
+	tmpFile := filepath.Join(t.TempDir(), "isolated.lbx")
+	password := "test_password_123"
+	lb := newQueryTestLockbox(t, tmpFile, password)
+	defer lb.Close()
+
+	reader, err := lb.file.NewReader(password)
+	if err != nil {
+		t.Fatalf("reader: %v", err)
+	}
+	if _, err := reader.ExportColumnKey("name"); !errors.Is(err, format.ErrSharedColumnKey) {
+		t.Fatalf("expected exporting key epoch 0 to be refused, got %v", err)
+	}
+
+	escrow, err := crypto.GenerateEscrowKey()
+	if err != nil {
+		t.Fatalf("generate escrow key: %v", err)
+	}
+	bundle, err := lb.ExportColumnKey("name", escrow.PublicPEM(), WithPassword(password))
+	if err != nil {
+		t.Fatalf("export: %v", err)
+	}
+	ck, err := ImportColumnKey(bundle, escrow.PrivatePEM())
+	if err != nil {
+		t.Fatalf("import: %v", err)
+	}
+	if ck.Epoch == 0 {
+		t.Fatalf("expected the column to be rotated off key epoch 0 before export")
+	}
+	if recoversMasterKey(lb.file.Metadata(), ck.HybridSecret) {
+		t.Fatalf("exported hybrid secret reveals the master key")
+	}
+
+	ctx := context.Background()
+	res, err := lb.Query(ctx, "SELECT name FROM data", WithColumnKey(ck))
+	if err != nil {
+		t.Fatalf("query with column key: %v", err)
+	}
+	res.Release()
+	for _, col := range []string{"id", "score"} {
+		if _, err := lb.Query(ctx, "SELECT "+col+" FROM data", WithColumnKey(ck)); err == nil {
+			t.Errorf("expected column %s to stay locked", col)
+		}
+	}
+}
+
+func TestRotateColumnKey(t *testing.T) {
+	tmpFile := "/tmp/test_lockbox_rotate.lbx"
+	defer os.Remove(tmpFile)
+
+	password := "test_password_123"
+	lb := newQueryTestLockbox(t, tmpFile, password)
+	defer lb.Close()
+
+	escrow, err := crypto.GenerateEscrowKey()
+	if err != nil {
+		t.Fatalf("generate escrow key: %v", err)
+	}
+	// Exporting rotates the column off key epoch 0 first
+	oldBundle, err := lb.ExportColumnKey("name", escrow.PublicPEM(), WithPassword(password))
+	if err != nil {
+		t.Fatalf("export: %v", err)
+	}
+
+	// Rotation rewrites the file, so blocks move; compare ciphertext
+	before := map[string]string{}
+	for _, bi := range lb.file.Metadata().BlockInfo {
+		before[bi.ColumnName] = string(bi.Checksum)
+	}
+
+	epoch, err := lb.RotateColumnKey("name", WithPassword(password))
+	if err != nil {
+		t.Fatalf("rotate: %v", err)
+	}
+	if epoch != 2 {
+		t.Fatalf("expected key epoch 2, got %d", epoch)
+	}
+	for _, bi := range lb.file.Metadata().BlockInfo {
+		switch bi.ColumnName {
+		case "name":
+			if bi.KeyEpoch != 2 || string(bi.Checksum) == before["name"] {
+				t.Fatalf("name block was not re-encrypted: %+v", bi)
+			}
+		default:
+			if bi.KeyEpoch != 0 || string(bi.Checksum) != before[bi.ColumnName] {
+				t.Fatalf("column %s should be untouched: %+v", bi.ColumnName, bi)
+			}
+		}
+	}
+	if _, err := lb.RotateColumnKey("missing", WithPassword(password)); err == nil {
+		t.Fatalf("expected rotating an unknown column to fail")
+	}
+	lb.Close()
+
+	lb, err = Open(tmpFile, WithPassword(password))
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	ctx := context.Background()
+	res, err := lb.Query(ctx, "SELECT name, score FROM data WHERE id = 2", WithPassword(password))
+	if err != nil {
+		t.Fatalf("query after rotation: %v", err)
+	}
+	if res.NumRows() != 1 || res.Column(0).(*array.String).Value(0) != "bob" {
+		t.Fatalf("unexpected result after rotation")
+	}
+	res.Release()
+
+	// Keys exported before the rotation no longer decrypt the column
+	ck, err := ImportColumnKey(oldBundle, escrow.PrivatePEM())
+	if err != nil {
+		t.Fatalf("import: %v", err)
+	}
+	if _, err := lb.Query(ctx, "SELECT name FROM data", WithColumnKey(ck)); err == nil {
+		t.Fatalf("expected the old column key to be useless after rotation")
+	}
+
+	bundle, err := lb.ExportColumnKey("name", escrow.PublicPEM(), WithPassword(password))
+	if err != nil {
+		t.Fatalf("export after rotation: %v", err)
+	}
+	ck, err = ImportColumnKey(bundle, escrow.PrivatePEM())
+	if err != nil {
+		t.Fatalf("import: %v", err)
+	}
+	res, err = lb.Query(ctx, "SELECT name FROM data", WithColumnKey(ck))
+	if err != nil {
+		t.Fatalf("query with rotated column key: %v", err)
+	}
+	defer res.Release()
+	if res.NumRows() != 3 {
+		t.Fatalf("expected 3 rows, got %d", res.NumRows())
+	}
+}
+
+func TestLazyKeyRotation(t *testing.T) {
+	tmpFile := "/tmp/test_lockbox_lazy_rotate.lbx"
+	defer os.Remove(tmpFile)
+
+	password := "test_password_123"
+	lb := newQueryTestLockbox(t, tmpFile, password)
+	defer lb.Close()
+
+	epoch, err := lb.RotateColumnKey("name", WithPassword(password), WithLazyRotation())
+	if err != nil {
+		t.Fatalf("rotate: %v", err)
+	}
+	if epoch != 1 {
+		t.Fatalf("expected key epoch 1, got %d", epoch)
+	}
+
+	// A second write uses the new key while the first block keeps the old one
+	mem := memory.NewGoAllocator()
+	idb := array.NewInt64Builder(mem)
+	nameb := array.NewStringBuilder(mem)
+	scoreb := array.NewFloat64Builder(mem)
+	defer idb.Release()
+	defer nameb.Release()
+	defer scoreb.Release()
+	idb.Append(4)
+	nameb.Append("dave")
+	scoreb.Append(70)
+	rec := array.NewRecord(lb.file.Metadata().Schema, []arrow.Array{idb.NewArray(), nameb.NewArray(), scoreb.NewArray()}, 1)
+	if err := lb.Write(context.Background(), rec, WithPassword(password)); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	epochs := map[int]int{}
+	for _, bi := range lb.file.Metadata().BlockInfo {
+		if bi.ColumnName == "name" {
+			epochs[bi.KeyEpoch]++
+		}
+	}
+	if epochs[0] != 1 || epochs[1] != 1 {
+		t.Fatalf("expected one block per key epoch, got %v", epochs)
+	}
+
+	info, err := lb.Info()
+	if err != nil {
+		t.Fatalf("info: %v", err)
+	}
+	if info.StaleBlocks != 1 || info.KeyEpochs["name"] != 1 {
+		t.Fatalf("unexpected key info: %+v", info)
+	}
+
+	ctx := context.Background()
+	res, err := lb.Query(ctx, "SELECT name FROM data WHERE id = 3", WithPassword(password))
+	if err != nil {
+		t.Fatalf("query with mixed key epochs: %v", err)
+	}
+	if res.NumRows() != 1 || res.Column(0).(*array.String).Value(0) != "carol" {
+		t.Fatalf("unexpected result with mixed key epochs")
+	}
+	res.Release()
+
+	n, err := lb.CompactKeys(ctx, WithPassword(password))
+	if err != nil {
+		t.Fatalf("compact: %v", err)
+	}
+	if n != 1 || lb.file.StaleKeyBlocks() != 0 {
+		t.Fatalf("expected one block to be re-encrypted, got %d (%d stale)", n, lb.file.StaleKeyBlocks())
+	}
+	lb.Close()
+
+	lb, err = Open(tmpFile, WithPassword(password))
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	res, err = lb.Query(ctx, "SELECT name FROM data WHERE id = 3", WithPassword(password))
+	if err != nil {
+		t.Fatalf("query after compaction: %v", err)
+	}
+	defer res.Release()
+	if res.NumRows() != 1 {
+		t.Fatalf("expected 1 row after compaction, got %d", res.NumRows())
+	}
+}
+
+func TestRotationReplacesFile(t *testing.T) {
+	tmpFile := filepath.Join(t.TempDir(), "replace.lbx")
+	password := "test_password_123"
+	lb := newQueryTestLockbox(t, tmpFile, password)
+	defer lb.Close()
+	if err := os.Chmod(tmpFile, 0640); err != nil {
+		t.Fatal(err)
+	}
+
+	// A reader opened before the rotation keeps reading the old version
+	reader, err := Open(tmpFile, WithPassword(password))
+	if err != nil {
+		t.Fatalf("open reader: %v", err)
+	}
+	defer reader.Close()
+
+	if _, err := lb.RotateColumnKey("name", WithPassword(password)); err != nil {
+		t.Fatalf("rotate: %v", err)
+	}
+	fi, err := os.Stat(tmpFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode().Perm() != 0640 {
+		t.Fatalf("expected mode 0640 to be kept, got %v", fi.Mode().Perm())
+	}
+	if leftovers, _ := filepath.Glob(filepath.Join(filepath.Dir(tmpFile), ".replace.lbx.tmp-*")); len(leftovers) > 0 {
+		t.Fatalf("temporary files left behind: %v", leftovers)
+	}
+
+	res, err := reader.Query(context.Background(), "SELECT name FROM data WHERE id = 2", WithPassword(password))
+	if err != nil {
+		t.Fatalf("query through old handle: %v", err)
+	}
+	if res.NumRows() != 1 || res.Column(0).(*array.String).Value(0) != "bob" {
+		t.Fatalf("unexpected result through old handle")
+	}
+	res.Release()
+}
+
+func TestAccessKeys(t *testing.T) {
+	tmpFile := filepath.Join(t.TempDir(), "access.lbx")
+	password := "test_password_123"
+	public := "public_password_456"
+	lb := newQueryTestLockbox(t, tmpFile, password)
+
+	if err := lb.GrantAccess("public", public, []string{"id", "score"}); err == nil {
+		t.Fatalf("expected granting access without the password to fail")
+	}
+	if err := lb.GrantAccess("public", public, []string{"missing"}, WithPassword(password)); err == nil {
+		t.Fatalf("expected an unknown column to be rejected")
+	}
+	if err := lb.GrantAccess("public", public, []string{"id", "score"}, WithPassword(password)); err != nil {
+		t.Fatalf("grant: %v", err)
+	}
+	if err := lb.GrantAccess("other", public, []string{"name"}, WithPassword(password)); err == nil {
+		t.Fatalf("expected a password used by another access key to be rejected")
+	}
+	// Lazy rotation leaves blocks at the old epoch; the grant covers both
+	if _, err := lb.RotateColumnKey("score", WithPassword(password), WithLazyRotation()); err != nil {
+		t.Fatalf("rotate: %v", err)
+	}
+	lb.Close()
+
+	lb, err := Open(tmpFile, WithPassword(public))
+	if err != nil {
+		t.Fatalf("open with access password: %v", err)
+	}
+	defer lb.Close()
+	if grants := lb.AccessGrants(); len(grants) != 1 || grants[0].Name != "public" || len(grants[0].Columns) != 2 {
+		t.Fatalf("unexpected access grants: %+v", grants)
+	}
+
+	ctx := context.Background()
+	res, err := lb.Query(ctx, "SELECT * FROM data", WithPassword(public))
+	if err != nil {
+		t.Fatalf("query: %v", err)
+	}
+	defer res.Release()
+	if res.NumCols() != 2 || res.Schema().Field(1).Name != "score" || res.Column(1).(*array.Float64).Value(1) != 55 {
+		t.Fatalf("expected only the granted columns, got %v", res)
+	}
+	if _, err := lb.Query(ctx, "SELECT name FROM data", WithPassword(public)); !errors.Is(err, format.ErrNoColumnKey) {
+		t.Fatalf("expected name to stay opaque, got %v", err)
+	}
+	rec, err := lb.Read(ctx, WithPassword(public))
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if rec.NumCols() != 2 {
+		t.Errorf("expected read to return the 2 granted columns, got %d", rec.NumCols())
+	}
+	if err := lb.Write(ctx, rec, WithPassword(public)); err == nil {
+		t.Errorf("expected writes with an access password to fail")
+	}
+	rec.Release()
+
+	if _, err := Open(tmpFile, WithPassword("wrong_password_789")); err == nil {
+		t.Fatalf("expected a wrong password to be rejected")
+	}
+	if err := lb.RevokeAccess("public"); err != nil {
+		t.Fatalf("revoke: %v", err)
+	}
+	if _, err := Open(tmpFile, WithPassword(public)); err == nil {
+		t.Fatalf("expected a revoked access password to be rejected")
+	}
+}
+
+// A handle read with the master password must not hand its keys to later
+// calls with another password
+func TestAccessKeysSharedHandle(t *testing.T) {
+	tmpFile := filepath.Join(t.TempDir(), "access_shared.lbx")
+	password := "test_password_123"
+	public := "public_password_456"
+	lb := newQueryTestLockbox(t, tmpFile, password)
+	defer lb.Close()
+	if err := lb.GrantAccess("public", public, []string{"id"}, WithPassword(password)); err != nil {
+		t.Fatalf("grant: %v", err)
+	}
+
+	ctx := context.Background()
+	rec, err := lb.Read(ctx, WithPassword(password))
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if rec.NumCols() != 3 {
+		t.Errorf("expected the master password to read 3 columns, got %d", rec.NumCols())
+	}
+	if err := lb.Write(ctx, rec, WithPassword(password)); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if err := lb.Write(ctx, rec, WithPassword("totally-wrong")); err == nil {
+		t.Errorf("expected a write with a wrong password to fail")
+	}
+	rec.Release()
+
+	rec, err = lb.Read(ctx, WithPassword(public))
+	if err != nil {
+		t.Fatalf("read with access password: %v", err)
+	}
+	if rec.NumCols() != 1 || rec.Schema().Field(0).Name != "id" {
+		t.Errorf("expected only the granted column, got %v", rec.Schema())
+	}
+	if err := lb.Write(ctx, rec, WithPassword(public)); err == nil {
+		t.Errorf("expected a write with the access password to fail")
+	}
+	rec.Release()
+
+	if _, err := lb.Read(ctx, WithPassword("totally-wrong")); err == nil {
+		t.Errorf("expected a read with a wrong password to fail")
+	}
+}
+
+// The key material sealed for an access password must not reveal the
+// master key or decrypt columns that were not granted
+func TestAccessKeyIsolated(t *testing.T) {
+	tmpFile := filepath.Join(t.TempDir(), "access_isolated.lbx")
+	password := "test_password_123"
+	public := "public_password_456"
+	lb := newQueryTestLockbox(t, tmpFile, password)
+	defer lb.Close()
+	if err := lb.GrantAccess("public", public, []string{"score"}, WithPassword(password)); err != nil {
+		t.Fatalf("grant: %v", err)
+	}
+
+	meta := lb.file.Metadata()
+	ak, ok := meta.Encryption.FindAccessKey("public")
+	if !ok {
+		t.Fatalf("access key not found")
+	}
+	accessKey, err := crypto.UnwrapKey(crypto.DeriveKey(public, ak.Salt).Data, ak.WrappedKey)
+	if err != nil {
+		t.Fatalf("unwrap access key: %v", err)
+	}
+	plaintext, err := crypto.UnwrapKey(accessKey, ak.ColumnKeys)
+	if err != nil {
+		t.Fatalf("open column keys: %v", err)
+	}
+	var keys []*format.ColumnKey
+	if err := json.Unmarshal(plaintext, &keys); err != nil {
+		t.Fatalf("decode column keys: %v", err)
+	}
+	if len(keys) == 0 {
+		t.Fatalf("expected sealed column keys")
+	}
+	for _, ck := range keys {
+		if ck.Column != "score" || ck.Epoch == 0 {
+			t.Errorf("unexpected sealed key for %s at epoch %d", ck.Column, ck.Epoch)
+		}
+		if recoversMasterKey(meta, ck.HybridSecret) {
+			t.Fatalf("sealed hybrid secret of %s reveals the master key", ck.Column)
+		}
+	}
+
+	ctx := context.Background()
+	for _, col := range []string{"id", "name"} {
+		if _, err := lb.Query(ctx, "SELECT "+col+" FROM data", WithPassword(public)); !errors.Is(err, format.ErrNoColumnKey) {
+			t.Errorf("expected column %s to stay opaque, got %v", col, err)
+		}
+	}
+	res, err := lb.Query(ctx, "SELECT score FROM data", WithPassword(public))
+	if err != nil {
+		t.Fatalf("query granted column: %v", err)
+	}
+	defer res.Release()
+	if res.NumRows() != 3 {
+		t.Fatalf("expected 3 rows, got %d", res.NumRows())
+	}
+}
+
+func TestColumnKeyDerivation(t *testing.T) {
+	password := "test_password_123"
+	ctx := context.Background()
+
+	roundTrip := func(kdf string, cols int) {
+		t.Helper()
+		fields := make([]arrow.Field, cols)
+		for i := range fields {
+			fields[i] = arrow.Field{Name: fmt.Sprintf("c%d", i), Type: arrow.PrimitiveTypes.Int64}
+		}
+		schema := arrow.NewSchema(fields, nil)
+
+		tmpFile := filepath.Join(t.TempDir(), "kdf.lbx")
+		lb, err := Create(tmpFile, schema, WithPassword(password))
+		if err != nil {
+			t.Fatalf("create: %v", err)
+		}
+		if got := lb.file.Metadata().Encryption.ColumnKeyDerivation; got != metadata.ColumnKeySignedHKDF {
+			t.Fatalf("expected new files to use %s, got %q", metadata.ColumnKeySignedHKDF, got)
+		}
+		lb.file.Metadata().Encryption.ColumnKeyDerivation = kdf
+		if err := lb.file.SaveMetadata(); err != nil {
+			t.Fatalf("save: %v", err)
+		}
+
+		b := array.NewRecordBuilder(memory.NewGoAllocator(), schema)
+		for i := 0; i < cols; i++ {
+			b.Field(i).(*array.Int64Builder).Append(int64(i))
+		}
+		rec := b.NewRecord()
+		b.Release()
+		defer rec.Release()
+		if err := lb.Write(ctx, rec, WithPassword(password)); err != nil {
+			t.Fatalf("%q: write: %v", kdf, err)
+		}
+		lb.Close()
+
+		lb, err = Open(tmpFile, WithPassword(password))
+		if err != nil {
+			t.Fatalf("%q: open: %v", kdf, err)
+		}
+		defer lb.Close()
+		res, err := lb.Query(ctx, fmt.Sprintf("SELECT c%d FROM data", cols-1), WithPassword(password))
+		if err != nil {
+			t.Fatalf("%q: query: %v", kdf, err)
+		}
+		defer res.Release()
+		if v := res.Column(0).(*array.Int64).Value(0); v != int64(cols-1) {
+			t.Errorf("%q: expected %d, got %d", kdf, cols-1, v)
+		}
+	}
+
+	// Column keys are derived on first use, with HKDF, so wide schemas are
+	// cheap to open and write. New files bind them to block signatures.
+	roundTrip(metadata.ColumnKeySignedHKDF, 300)
+	roundTrip(metadata.ColumnKeyHKDF, 3)
+	// Files created before HKDF keep deriving each column key with PBKDF2
+	roundTrip("", 3)
+}
@@ -0,0 +1,95 @@
+package lockbox
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/apache/arrow-go/v18/arrow"
+	"github.com/apache/arrow-go/v18/arrow/array"
+	"github.com/apache/arrow-go/v18/arrow/memory"
+)
+
+// TestLargeFile checks that blocks and metadata beyond 4 GiB are written,
+// read and compacted correctly. The file is sparse, but the test still
+// needs a filesystem that supports that and some time for gc, so it only
+// runs with LOCKBOX_LARGE_FILE_TEST=1.
+func TestLargeFile(t *testing.T) {
+	if os.Getenv("LOCKBOX_LARGE_FILE_TEST") != "1" {
+		t.Skip("set LOCKBOX_LARGE_FILE_TEST=1 to run the large-file test")
+	}
+
+	path := filepath.Join(t.TempDir(), "large.lbx")
+	password := "test_password_123"
+	schema := arrow.NewSchema([]arrow.Field{
+		{Name: "id", Type: arrow.PrimitiveTypes.Int64},
+		{Name: "name", Type: arrow.BinaryTypes.String},
+	}, nil)
+
+	lb, err := Create(path, schema, WithPassword(password))
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	if err := lb.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+
+	// Push everything written from now on past 4 GiB
+	const hole = 5 << 30
+	if err := os.Truncate(path, hole); err != nil {
+		t.Skipf("cannot create a sparse file: %v", err)
+	}
+
+	lb, err = Open(path, WithPassword(password))
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	mem := memory.NewGoAllocator()
+	b := array.NewRecordBuilder(mem, schema)
+	defer b.Release()
+	b.Field(0).(*array.Int64Builder).AppendValues([]int64{1, 2, 3}, nil)
+	b.Field(1).(*array.StringBuilder).AppendValues([]string{"alice", "bob", "carol"}, nil)
+	rec := b.NewRecord()
+	defer rec.Release()
+	if err := lb.Write(context.Background(), rec, WithPassword(password)); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	for _, block := range lb.file.Metadata().BlockInfo {
+		if block.Offset < 1<<32 {
+			t.Fatalf("expected block of %s beyond 4 GiB, got offset %d", block.ColumnName, block.Offset)
+		}
+	}
+	if err := lb.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+
+	checkNames := func(lb *Lockbox) {
+		t.Helper()
+		res, err := lb.Query(context.Background(), "SELECT name FROM data WHERE id = 3", WithPassword(password))
+		if err != nil {
+			t.Fatalf("query: %v", err)
+		}
+		defer res.Release()
+		if res.NumRows() != 1 || res.Column(0).(*array.String).Value(0) != "carol" {
+			t.Fatalf("unexpected result %v", res)
+		}
+	}
+
+	lb, err = Open(path, WithPassword(password))
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	defer func() { lb.Close() }()
+	checkNames(lb)
+
+	// gc drops the hole and moves the blocks back below 4 GiB
+	report, err := lb.GC(context.Background(), WithPassword(password))
+	if err != nil {
+		t.Fatalf("gc: %v", err)
+	}
+	if report.ReclaimedBytes < hole/2 {
+		t.Fatalf("expected the hole to be reclaimed, got %d bytes", report.ReclaimedBytes)
+	}
+	checkNames(lb)
+}
@@ -0,0 +1,55 @@
+package lockbox
+
+import (
+	"fmt"
+
+	"github.com/TFMV/lockbox/pkg/format"
+)
+
+// Layout maps a lockbox file region by region
+type Layout struct {
+	File     string          `json:"file"`
+	FileSize int64           `json:"fileSize"`
+	Regions  []format.Region `json:"regions"`
+}
+
+// Bytes returns the number of bytes in regions of the given kind
+func (l *Layout) Bytes(kind string) int64 {
+	var n int64
+	for _, r := range l.Regions {
+		if r.Kind == kind {
+			n += r.Length
+		}
+	}
+	return n
+}
+
+// Layout maps the file: its header, the data blocks of each column and
+// commit, the blocks of materialized views, the current and earlier copies
+// of the metadata and the dead space gc would reclaim
+func (lb *Lockbox) Layout() (*Layout, error) {
+	return layoutOf(lb.file)
+}
+
+// ReadLayout maps a file without decrypting it. Files with encrypted
+// metadata need a password, through Lockbox.Layout.
+func ReadLayout(filename string) (*Layout, error) {
+	file, err := openMetadataOnly(filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open lockbox file: %w", err)
+	}
+	defer file.Close()
+	return layoutOf(file)
+}
+
+func layoutOf(file *format.LockboxFile) (*Layout, error) {
+	regions, err := file.Layout()
+	if err != nil {
+		return nil, fmt.Errorf("failed to map file: %w", err)
+	}
+	l := &Layout{File: file.Path(), Regions: regions}
+	if n := len(regions); n > 0 {
+		l.FileSize = regions[n-1].Offset + regions[n-1].Length
+	}
+	return l, nil
+}
@@ -0,0 +1,106 @@
+package lockbox
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/TFMV/lockbox/pkg/format"
+	"github.com/apache/arrow-go/v18/arrow"
+	"github.com/apache/arrow-go/v18/arrow/array"
+	"github.com/apache/arrow-go/v18/arrow/memory"
+)
+
+func TestLayout(t *testing.T) {
+	dir := t.TempDir()
+	password := "test_password_123"
+	ctx := context.Background()
+	schema := arrow.NewSchema([]arrow.Field{
+		{Name: "id", Type: arrow.PrimitiveTypes.Int64},
+		{Name: "name", Type: arrow.BinaryTypes.String},
+	}, nil)
+
+	path := filepath.Join(dir, "data.lbx")
+	lb, err := Create(path, schema, WithPassword(password))
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	defer lb.Close()
+	for batch := 0; batch < 2; batch++ {
+		b := array.NewRecordBuilder(memory.NewGoAllocator(), schema)
+		b.Field(0).(*array.Int64Builder).AppendValues([]int64{1, 2}, nil)
+		b.Field(1).(*array.StringBuilder).AppendValues([]string{"ann", "bob"}, nil)
+		rec := b.NewRecord()
+		b.Release()
+		if err := lb.Write(ctx, rec, WithPassword(password)); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+		rec.Release()
+	}
+	b := array.NewInt64Builder(memory.NewGoAllocator())
+	b.AppendValues([]int64{10, 20, 30, 40}, nil)
+	col := b.NewArray()
+	b.Release()
+	defer col.Release()
+	if err := lb.ReplaceColumn(ctx, "id", col, WithPassword(password)); err != nil {
+		t.Fatalf("replace column: %v", err)
+	}
+
+	check := func(l *Layout) {
+		t.Helper()
+		fi, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if l.FileSize != fi.Size() {
+			t.Errorf("expected the layout to cover %d bytes, got %d", fi.Size(), l.FileSize)
+		}
+		pos := int64(0)
+		for _, r := range l.Regions {
+			if r.Offset != pos {
+				t.Fatalf("expected a region at %d, got %+v", pos, r)
+			}
+			pos += r.Length
+		}
+		if l.Regions[0].Kind != format.RegionHeader {
+			t.Errorf("expected the header first, got %+v", l.Regions[0])
+		}
+	}
+
+	l, err := lb.Layout()
+	if err != nil {
+		t.Fatalf("layout: %v", err)
+	}
+	check(l)
+	blocks := 0
+	for _, r := range l.Regions {
+		if r.Kind == format.RegionBlock {
+			blocks++
+			if r.Commit == 0 {
+				t.Errorf("expected the block to belong to a commit, got %+v", r)
+			}
+		}
+	}
+	if blocks != 4 || l.Bytes(format.RegionMetadata) == 0 {
+		t.Errorf("expected 4 blocks and the metadata, got %+v", l.Regions)
+	}
+	// The replaced id blocks and each earlier metadata copy
+	if l.Bytes(format.RegionDead) == 0 || l.Bytes(format.RegionOldMetadata) == 0 {
+		t.Errorf("expected dead space and earlier metadata, got %+v", l.Regions)
+	}
+	if r, err := ReadLayout(path); err != nil || len(r.Regions) != len(l.Regions) {
+		t.Errorf("expected the same layout without decrypting, got %+v, %v", r, err)
+	}
+
+	if _, err := lb.GC(ctx); err != nil {
+		t.Fatalf("gc: %v", err)
+	}
+	if l, err = lb.Layout(); err != nil {
+		t.Fatalf("layout: %v", err)
+	}
+	check(l)
+	if n := l.Bytes(format.RegionDead) + l.Bytes(format.RegionOldMetadata); n != 0 {
+		t.Errorf("expected gc to leave nothing to reclaim, got %d bytes", n)
+	}
+}
@@ -0,0 +1,110 @@
+package lockbox
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"runtime/debug"
+
+	"github.com/TFMV/lockbox/pkg/metadata"
+)
+
+// Commit operations
+const (
+	OperationWrite         = "write"
+	OperationIngestParquet = "ingest-parquet"
+	OperationIngestCSV     = "ingest-csv"
+	OperationIngestNDJSON  = "ingest-ndjson"
+	OperationReplaceColumn = "replace-column"
+	OperationCompute       = "compute"
+	OperationAddColumn     = "add-column"
+	OperationDelete        = "delete"
+	OperationCompact       = "compact"
+	OperationUpsert        = "upsert"
+	OperationMigrate       = "migrate"
+	OperationReplica       = "replica"
+)
+
+// WithMessage sets the commit message recorded with a write
+func WithMessage(message string) Option {
+	return func(o *Options) {
+		o.Message = message
+	}
+}
+
+// WithLineage records where the written data came from. An empty Tool is
+// filled in with the lockbox version.
+func WithLineage(lineage metadata.Lineage) Option {
+	return func(o *Options) {
+		o.Lineage = &lineage
+	}
+}
+
+// withOperation sets the operation recorded with a write
+func withOperation(op string) Option {
+	return func(o *Options) {
+		o.operation = op
+	}
+}
+
+// SourceHash returns the SHA-256 of a file in the "sha256:<hex>" form used
+// in lineage records
+func SourceHash(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to open source: %w", err)
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", fmt.Errorf("failed to hash source: %w", err)
+	}
+	return "sha256:" + hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// Snapshots returns the commit history, oldest first
+func (lb *Lockbox) Snapshots() []metadata.Snapshot {
+	return append([]metadata.Snapshot(nil), lb.file.Metadata().Snapshots...)
+}
+
+// ReadSnapshots returns the commit history of a lockbox file, oldest first.
+// The history is stored in the clear, so no password is needed.
+func ReadSnapshots(filename string) ([]metadata.Snapshot, error) {
+	file, err := openMetadataOnly(filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open lockbox file: %w", err)
+	}
+	defer file.Close()
+	return append([]metadata.Snapshot(nil), file.Metadata().Snapshots...), nil
+}
+
+// commitFor builds the commit recorded by a write with the given options
+func commitFor(options *Options) metadata.Snapshot {
+	commit := metadata.Snapshot{
+		Operation: options.operation,
+		Principal: options.Principal,
+		Message:   options.Message,
+	}
+	if commit.Operation == "" {
+		commit.Operation = OperationWrite
+	}
+	if options.Lineage != nil {
+		lineage := *options.Lineage
+		if lineage.Tool == "" {
+			lineage.Tool = toolVersion()
+		}
+		commit.Lineage = &lineage
+	}
+	return commit
+}
+
+// toolVersion names this build of lockbox for lineage records
+func toolVersion() string {
+	if bi, ok := debug.ReadBuildInfo(); ok && bi.Main.Version != "" {
+		return "lockbox " + bi.Main.Version
+	}
+	return "lockbox"
+}
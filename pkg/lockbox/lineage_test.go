@@ -0,0 +1,92 @@
+package lockbox
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/TFMV/lockbox/pkg/metadata"
+	"github.com/apache/arrow-go/v18/arrow"
+	"github.com/apache/arrow-go/v18/arrow/array"
+	"github.com/apache/arrow-go/v18/arrow/memory"
+)
+
+func TestCommitLineage(t *testing.T) {
+	dir := t.TempDir()
+	tmpFile := filepath.Join(dir, "lineage.lbx")
+	password := "test_password_123"
+	lb := newQueryTestLockbox(t, tmpFile, password)
+
+	source := filepath.Join(dir, "input.csv")
+	if err := os.WriteFile(source, []byte("id,name,score\n4,dave,12\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	hash, err := SourceHash(source)
+	if err != nil {
+		t.Fatalf("hash source: %v", err)
+	}
+
+	mem := memory.NewGoAllocator()
+	idb := array.NewInt64Builder(mem)
+	defer idb.Release()
+	nameb := array.NewStringBuilder(mem)
+	defer nameb.Release()
+	scoreb := array.NewFloat64Builder(mem)
+	defer scoreb.Release()
+	idb.Append(4)
+	nameb.Append("dave")
+	scoreb.Append(12)
+	ids, names, scores := idb.NewArray(), nameb.NewArray(), scoreb.NewArray()
+	defer ids.Release()
+	defer names.Release()
+	defer scores.Release()
+	rec := array.NewRecord(lb.Schema(), []arrow.Array{ids, names, scores}, 1)
+
+	err = lb.Write(context.Background(), rec,
+		WithPassword(password),
+		WithPrincipal("etl"),
+		WithMessage("load dave"),
+		WithLineage(metadata.Lineage{Source: source, SourceHash: hash, Transformation: "trimmed names"}),
+	)
+	if err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	lb.Close()
+
+	lb, err = Open(tmpFile, WithPassword(password))
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer lb.Close()
+
+	snaps := lb.Snapshots()
+	if len(snaps) != 2 {
+		t.Fatalf("expected 2 snapshots, got %d", len(snaps))
+	}
+	first, second := snaps[0], snaps[1]
+	if first.ID != 1 || first.Operation != OperationWrite || first.RowsAdded != 3 || first.TotalRows != 3 || first.Lineage != nil {
+		t.Fatalf("unexpected first snapshot %+v", first)
+	}
+	if second.ID != 2 || second.ParentID != 1 || second.Principal != "etl" || second.Message != "load dave" {
+		t.Fatalf("unexpected second snapshot %+v", second)
+	}
+	if second.RowsAdded != 1 || second.TotalRows != 4 || second.BlockCount != 6 {
+		t.Fatalf("unexpected row or block counts %+v", second)
+	}
+	l := second.Lineage
+	if l == nil || l.Source != source || l.SourceHash != hash || l.Transformation != "trimmed names" || !strings.HasPrefix(l.Tool, "lockbox") {
+		t.Fatalf("unexpected lineage %+v", l)
+	}
+	logged, err := ReadSnapshots(tmpFile)
+	if err != nil {
+		t.Fatalf("read snapshots: %v", err)
+	}
+	if len(logged) != 2 || logged[1].Message != "load dave" {
+		t.Fatalf("unexpected history without password %+v", logged)
+	}
+	if !strings.HasPrefix(hash, "sha256:") {
+		t.Fatalf("unexpected hash %s", hash)
+	}
+}
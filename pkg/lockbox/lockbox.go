@@ -7,6 +7,7 @@ import (
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/apache/arrow-go/v18/parquet/file"
@@ -14,27 +15,100 @@ import (
 
 	"github.com/TFMV/lockbox/pkg/crypto"
 	"github.com/TFMV/lockbox/pkg/format"
+	"github.com/TFMV/lockbox/pkg/metadata"
 	"github.com/apache/arrow-go/v18/arrow"
 	"github.com/apache/arrow-go/v18/arrow/array"
+	"github.com/apache/arrow-go/v18/arrow/decimal128"
 	"github.com/apache/arrow-go/v18/arrow/memory"
 	"github.com/rs/zerolog/log"
 )
 
 // Lockbox represents a lockbox file with high-level operations
 type Lockbox struct {
-	file   *format.LockboxFile
-	writer *format.Writer
-	reader *format.Reader
-	key    *crypto.Key // Store the key for signing operations
+	file *format.LockboxFile
+	key  *crypto.Key // Store the key for signing operations
+
+	// Readers and writers are cached by the KeyID of their password, so a
+	// call only gets the keys its own password unlocks
+	keysMu  sync.Mutex
+	readers map[string]*format.Reader
+	writers map[string]*format.Writer
 }
 
 // Options for lockbox operations
 type Options struct {
-	Password     string
-	CreatedBy    string
-	Columns      []string
-	DryRun       bool
-	CryptoModule string
+	Password       string
+	CreatedBy      string
+	Columns        []string
+	DryRun         bool
+	CryptoModule   string
+	Materialized   bool
+	Principal      string
+	Codec          string
+	ColumnKeys     []*format.ColumnKey
+	LazyRotation   bool
+	AuditDir       string
+	AuditRetention time.Duration
+	Message        string
+	Lineage        *metadata.Lineage
+	KeepLast       int
+	KeepFor        time.Duration
+	TypeHints      map[string]arrow.DataType
+	NoHeader       bool
+	Delimiter      rune
+	Quote          rune
+	Locale         string
+	DateFormats    []string
+	FlattenSep     string
+	NullToken      string
+	BOM            bool
+	OnError        ErrorPolicy
+	Rejects        *Rejects
+	Mapping        *ColumnMapping
+	InternStrings  bool
+	Dictionary     []string
+	Allocator      memory.Allocator
+	DebugAllocator bool
+	FileMode       os.FileMode
+	Owner          *fileOwner
+	TopValues      int
+	SampleRows     int
+	MaxOrphans     int
+	PartitionBy    []string
+	SinceSnapshot  int64
+	AsOf           *asOf
+	Preset         *Preset
+	StrictSecurity bool
+	ColumnGroups   []metadata.ColumnGroup
+	SealMetadata   bool
+	Args           []any
+	Argon2         *metadata.Argon2Params
+	SyntheticKey   *metadata.SyntheticKey
+	AutoIncrement  []string
+	Storage        format.Storage
+	PrevBatchHash  []byte
+	// Filter is the row filter of WithFilter
+	Filter string
+	// ReplicaPassword protects replicas written by ExportSnapshot
+	ReplicaPassword string
+	// BatchSize and Progress are set by WithBatchSize and WithProgress
+	BatchSize int
+	Progress  func(IngestProgress)
+	// Schema is the record schema of Marshal
+	Schema *arrow.Schema
+	// SchemaRef and SchemaRegistry are set by WithSchemaRef and
+	// WithSchemaRegistry
+	SchemaRef      *metadata.SchemaRef
+	SchemaRegistry *SchemaRegistry
+	// Drift is set by WithDrift, and schemaDrift by ingestions that
+	// resolved it
+	Drift DriftMode
+	// IgnoreExtraColumns makes IngestParquet skip input columns the
+	// lockbox does not have
+	IgnoreExtraColumns bool
+
+	operation   string
+	schemaDrift *SchemaDrift
 }
 
 // Option is a functional option for lockbox operations
@@ -75,6 +149,24 @@ func WithCryptoModule(name string) Option {
 	}
 }
 
+// WithMaterialized stores view results so they are served without
+// re-running the view query. Principals with masks or row filters always
+// get the view evaluated live.
+func WithMaterialized(v bool) Option {
+	return func(o *Options) {
+		o.Materialized = v
+	}
+}
+
+// WithCodec selects the codec, by registered name, used to compress column
+// blocks on write. Given to Create it becomes the file's default codec for
+// writes that don't choose one.
+func WithCodec(name string) Option {
+	return func(o *Options) {
+		o.Codec = name
+	}
+}
+
 // Create creates a new lockbox file with the given schema
 func Create(filename string, schema *arrow.Schema, opts ...Option) (*Lockbox, error) {
 	options := &Options{
@@ -91,11 +183,37 @@ func Create(filename string, schema *arrow.Schema, opts ...Option) (*Lockbox, er
 	if options.Password == "" {
 		return nil, fmt.Errorf("password is required")
 	}
+	if options.Preset != nil {
+		if err := options.Preset.check(options); err != nil {
+			return nil, err
+		}
+	}
+	if err := checkCodec(options.Codec); err != nil {
+		return nil, err
+	}
+	if options.SyntheticKey != nil {
+		var err error
+		if schema, err = withSyntheticKey(schema, options.SyntheticKey); err != nil {
+			return nil, err
+		}
+	}
+	sequences, err := checkSequences(schema, options.AutoIncrement)
+	if err != nil {
+		return nil, err
+	}
 
-	module, ok := crypto.GetModule(options.CryptoModule)
-	if !ok {
+	module, err := resolveModule(options.CryptoModule)
+	if err != nil {
+		return nil, err
+	}
+	if module == nil {
 		module, _ = crypto.GetModule("default")
 	}
+	if strictSecurity(options) {
+		if err := refuseWeak(securityFindings(newEncryptionParams(module, options), options.SealMetadata)); err != nil {
+			return nil, err
+		}
+	}
 
 	// Generate key with post-quantum components
 	key, err := module.NewKey(options.Password)
@@ -103,15 +221,59 @@ func Create(filename string, schema *arrow.Schema, opts ...Option) (*Lockbox, er
 		return nil, fmt.Errorf("failed to generate key: %w", err)
 	}
 
-	file, err := format.Create(filename, schema, options.Password, options.CreatedBy, module)
+	file, err := createFile(filename, schema, options, module)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create lockbox file: %w", err)
 	}
+	if err := applyFileAccess(filename, options); err != nil {
+		file.Close()
+		removeFile(filename, options)
+		return nil, err
+	}
+	if len(options.ColumnGroups) > 0 || options.Codec != "" || options.SealMetadata || options.Argon2 != nil || options.SyntheticKey != nil || len(sequences) > 0 || options.SchemaRef != nil {
+		var err error
+		if options.Argon2 != nil {
+			err = file.SetArgon2id(*options.Argon2)
+		}
+		if err == nil && len(options.ColumnGroups) > 0 {
+			err = file.SetColumnGroups(options.ColumnGroups)
+		}
+		if err == nil && options.Codec != "" {
+			file.Metadata().Encryption.DefaultCodec = options.Codec
+		}
+		if err == nil && options.SyntheticKey != nil {
+			file.Metadata().SyntheticKey = options.SyntheticKey
+		}
+		if err == nil {
+			file.Metadata().Sequences = sequences
+			file.Metadata().SchemaRef = options.SchemaRef
+		}
+		if err == nil {
+			if options.SealMetadata {
+				err = encryptMetadata(file, options.Password)
+			} else {
+				err = file.SaveMetadata()
+			}
+		}
+		if err != nil {
+			file.Close()
+			removeFile(filename, options)
+			return nil, err
+		}
+	}
 
+	file.SetAllocator(newAllocator(options))
 	lb := &Lockbox{
 		file: file,
 		key:  key,
 	}
+	if options.Preset != nil {
+		if err := lb.applyPreset(options.Preset); err != nil {
+			file.Close()
+			removeFile(filename, options)
+			return nil, err
+		}
+	}
 
 	log.Info().
 		Str("file", filename).
@@ -135,23 +297,43 @@ func Open(filename string, opts ...Option) (*Lockbox, error) {
 		opt(options)
 	}
 
-	if options.Password == "" {
+	// Escrowed column keys allow opening without the password
+	if options.Password == "" && len(options.ColumnKeys) == 0 {
 		return nil, fmt.Errorf("password is required")
 	}
 
-	module, ok := crypto.GetModule(options.CryptoModule)
-	if !ok {
-		module, _ = crypto.GetModule("default")
+	// Without an explicit module the one recorded in the file is used
+	module, err := resolveModule(options.CryptoModule)
+	if err != nil {
+		return nil, err
 	}
 
-	// Derive key with post-quantum components if available
-	key := module.DeriveKey(options.Password, nil) // Salt will be read from file
-
-	file, err := format.Open(filename, options.Password, module)
+	file, err := openFile(filename, options, module)
 	if err != nil {
 		return nil, fmt.Errorf("failed to open lockbox file: %w", err)
 	}
+	if file.MetadataSealed() {
+		// Escrowed column keys don't unlock encrypted metadata
+		file.Close()
+		return nil, fmt.Errorf("failed to open lockbox file: %w", format.ErrMetadataSealed)
+	}
+	if strictSecurity(options) {
+		if err := refuseWeak(securityFindings(file.Metadata().Encryption, file.MetadataEncrypted())); err != nil {
+			file.Close()
+			return nil, err
+		}
+	}
+	if options.Storage == nil {
+		warnIfExposed(filename)
+	}
+
+	// Derive key with post-quantum components if available
+	var key *crypto.Key
+	if options.Password != "" {
+		key = file.Module().DeriveKey(options.Password, nil) // Salt will be read from file
+	}
 
+	file.SetAllocator(newAllocator(options))
 	lb := &Lockbox{
 		file: file,
 		key:  key,
@@ -160,34 +342,53 @@ func Open(filename string, opts ...Option) (*Lockbox, error) {
 	log.Info().
 		Str("file", filename).
 		Int("fields", len(file.Schema().Fields())).
-		Bool("pq_enabled", key.KyberPublicKey != nil).
+		Bool("pq_enabled", key != nil && key.KyberPublicKey != nil).
 		Msg("Opened lockbox")
 
 	return lb, nil
 }
 
-// Close closes the lockbox file
-func (lb *Lockbox) Close() error {
-	if lb.writer != nil {
-		// Writers don't need explicit closing, they use the underlying file
-		lb.writer = nil
+// resolveModule looks up a crypto module by name. The empty name returns
+// nil so callers can apply their own default.
+func resolveModule(name string) (crypto.Module, error) {
+	if name == "" {
+		return nil, nil
 	}
-	if lb.reader != nil {
-		// Readers don't need explicit closing, they use the underlying file
-		lb.reader = nil
+	module, ok := crypto.GetModule(name)
+	if !ok {
+		return nil, fmt.Errorf("%w: crypto module %q is not registered", format.ErrModuleUnavailable, name)
 	}
+	return module, nil
+}
+
+// Close closes the lockbox file. With WithDebugAllocator it reports Arrow
+// buffers that were not released.
+func (lb *Lockbox) Close() error {
+	// Readers and writers don't need explicit closing, they use the
+	// underlying file
+	lb.forgetKeys()
 	if lb.file != nil {
-		return lb.file.Close()
+		unreleased := lb.checkReleased()
+		if err := lb.file.Close(); err != nil {
+			return err
+		}
+		return unreleased
 	}
 	return nil
 }
 
+// Path returns the name of the lockbox file
+func (lb *Lockbox) Path() string {
+	return lb.file.Path()
+}
+
 // Schema returns the Arrow schema of the lockbox
 func (lb *Lockbox) Schema() *arrow.Schema {
 	return lb.file.Schema()
 }
 
-// Write writes an Arrow record to the lockbox
+// Write writes an Arrow record to the lockbox. The record is not released;
+// the caller still owns it and may write it again.
 func (lb *Lockbox) Write(ctx context.Context, record arrow.Record, opts ...Option) error {
 	options := &Options{
 		Password:     "",
@@ -202,54 +403,178 @@ func (lb *Lockbox) Write(ctx context.Context, record arrow.Record, opts ...Optio
 	if options.Password == "" {
 		return fmt.Errorf("password is required for writing")
 	}
-
-	// Create writer if it doesn't exist
-	if lb.writer == nil {
-		writer, err := lb.file.NewWriter(options.Password)
-		if err != nil {
-			return fmt.Errorf("failed to create writer: %w", err)
-		}
-		lb.writer = writer
+	if err := ctx.Err(); err != nil {
+		return err
 	}
 
-	// Sign the record before writing
-	if lb.key != nil && lb.key.KyberSecretKey != nil {
-		encryptor, err := crypto.NewColumnEncryptor(lb.key.Data)
-		if err != nil {
-			return fmt.Errorf("failed to create encryptor: %w", err)
-		}
-		// Set up Kyber keys
-		encryptor.KyberPublicKey = lb.key.KyberPublicKey
-		encryptor.KyberSecretKey = lb.key.KyberSecretKey
+	if _, err := lb.checkPolicy(options.Principal, ActionWrite); err != nil {
+		return err
+	}
 
-		// Sign the serialized record data
-		recordBytes := []byte(fmt.Sprintf("%v", record))
-		signature, err := encryptor.Sign(recordBytes)
-		if err != nil {
-			return fmt.Errorf("failed to sign record: %w", err)
-		}
+	writer, err := lb.prepareWriter(options)
+	if err != nil {
+		return err
+	}
 
-		// Store signature in metadata (implementation detail left to format package)
-		// This is just a placeholder - actual implementation would need format package support
-		log.Debug().
-			Int("signature_size", len(signature)).
-			Msg("Added quantum-resistant signature to record")
+	// Validate and transform the record before it is encrypted
+	hooked, err := lb.runHooks(ctx, metadata.HookPreWrite, record)
+	if err != nil {
+		return fmt.Errorf("pre-write hooks failed: %w", err)
+	}
+	if hooked != record {
+		defer hooked.Release()
+	}
+	record = hooked
+	keyed, err := lb.fillSyntheticKey(lb.Allocator(), record)
+	if err != nil {
+		return err
+	}
+	if keyed != record {
+		defer keyed.Release()
+	}
+	record = keyed
+	numbered, err := lb.fillSequences(lb.Allocator(), record)
+	if err != nil {
+		return err
+	}
+	if numbered != record {
+		defer numbered.Release()
+	}
+	record = numbered
+	if err := checkJSONColumns(lb.Schema(), record); err != nil {
+		return err
 	}
 
-	// Write the record
-	if err := lb.writer.WriteRecord(record); err != nil {
+	// Write the record; the writer signs each block of files with block
+	// signatures
+	if err := writer.WriteRecord(record); err != nil {
 		return fmt.Errorf("failed to write record: %w", err)
 	}
 
+	if err := lb.refreshMaterializedViews(options.Password, ""); err != nil {
+		return fmt.Errorf("failed to refresh materialized views: %w", err)
+	}
+
+	if _, err := lb.runHooks(ctx, metadata.HookPostWrite, record); err != nil {
+		return fmt.Errorf("post-write hooks failed: %w", err)
+	}
+	lb.notifyCommit(ctx)
+
 	log.Debug().
 		Int64("rows", record.NumRows()).
 		Int("columns", len(record.Columns())).
-		Bool("pq_signed", lb.key != nil && lb.key.KyberSecretKey != nil).
+		Bool("signed", lb.file.Metadata().Encryption.SignatureScheme() != "").
 		Msg("Wrote record to lockbox")
 
 	return nil
 }
 
+// ReplaceColumn rewrites one column for every row, e.g. to recompute a
+// derived score, without touching the other columns. col must have the
+// column's type and one value per row, in read order. The rewrite is
+// committed as a snapshot that takes WithMessage and WithLineage, and the
+// replaced blocks stay in the file until gc. The caller keeps ownership of
+// col.
+func (lb *Lockbox) ReplaceColumn(ctx context.Context, name string, col arrow.Array, opts ...Option) error {
+	options := &Options{}
+	for _, opt := range opts {
+		opt(options)
+	}
+	if options.Password == "" {
+		return fmt.Errorf("password is required for writing")
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if _, err := lb.checkPolicy(options.Principal, ActionWrite); err != nil {
+		return err
+	}
+
+	options.operation = OperationReplaceColumn
+	writer, err := lb.prepareWriter(options)
+	if err != nil {
+		return err
+	}
+	if err := writer.ReplaceColumn(name, col); err != nil {
+		return fmt.Errorf("failed to replace column %s: %w", name, err)
+	}
+
+	if err := lb.refreshMaterializedViews(options.Password, ""); err != nil {
+		return fmt.Errorf("failed to refresh materialized views: %w", err)
+	}
+	lb.notifyCommit(ctx)
+	return nil
+}
+
+// prepareWriter returns the writer of the password of options, set up
+// with their codec, dictionary columns and commit
+func (lb *Lockbox) prepareWriter(options *Options) (*format.Writer, error) {
+	writer, err := lb.writerFor(options.Password)
+	if err != nil {
+		return nil, err
+	}
+	if err := writer.SetCodec(lb.codecFor(options)); err != nil {
+		return nil, err
+	}
+	if err := checkDictionaryColumns(lb.Schema(), options.Dictionary); err != nil {
+		return nil, err
+	}
+	writer.SetDictionary(options.Dictionary)
+	writer.SetCommit(commitFor(options))
+	return writer, nil
+}
+
+// readerFor returns a reader with the keys password unlocks: all columns
+// for the master password, the granted ones for an access password. A
+// wrong password fails.
+func (lb *Lockbox) readerFor(password string) (*format.Reader, error) {
+	id := lb.file.KeyID(password)
+	lb.keysMu.Lock()
+	defer lb.keysMu.Unlock()
+	if r, ok := lb.readers[id]; ok {
+		return r, nil
+	}
+	r, err := lb.file.NewReader(password)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create reader: %w", err)
+	}
+	if lb.readers == nil {
+		lb.readers = make(map[string]*format.Reader)
+	}
+	lb.readers[id] = r
+	return r, nil
+}
+
+// writerFor returns the writer of password, which must be the master
+// password. Callers keep the returned writer rather than sharing it
+// through the handle, so concurrent calls can't swap it.
+func (lb *Lockbox) writerFor(password string) (*format.Writer, error) {
+	id := lb.file.KeyID(password)
+	lb.keysMu.Lock()
+	defer lb.keysMu.Unlock()
+	if w, ok := lb.writers[id]; ok {
+		return w, nil
+	}
+	w, err := lb.file.NewWriter(password)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create writer: %w", err)
+	}
+	if lb.writers == nil {
+		lb.writers = make(map[string]*format.Writer)
+	}
+	lb.writers[id] = w
+	return w, nil
+}
+
+// forgetKeys drops the cached readers and writers, e.g. after the password
+// changed
+func (lb *Lockbox) forgetKeys() {
+	lb.keysMu.Lock()
+	defer lb.keysMu.Unlock()
+	lb.readers = nil
+	lb.writers = nil
+}
+
 // WriteAsync performs Write in a separate goroutine
 func (lb *Lockbox) WriteAsync(ctx context.Context, record arrow.Record, opts ...Option) <-chan error {
 	ch := make(chan error, 1)
@@ -274,22 +599,35 @@ func (lb *Lockbox) Read(ctx context.Context, opts ...Option) (arrow.Record, erro
 	if options.Password == "" {
 		return nil, fmt.Errorf("password is required for reading")
 	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
 
-	// Create reader if it doesn't exist
-	if lb.reader == nil {
-		reader, err := lb.file.NewReader(options.Password)
-		if err != nil {
-			return nil, fmt.Errorf("failed to create reader: %w", err)
-		}
-		lb.reader = reader
+	reader, err := lb.readerFor(options.Password)
+	if err != nil {
+		return nil, err
+	}
+
+	policy, err := lb.checkPolicy(options.Principal, ActionRead)
+	if err != nil {
+		return nil, err
 	}
 
 	// Read the record
-	record, err := lb.reader.ReadRecord()
+	record, err := reader.ReadRecord()
 	if err != nil {
 		return nil, fmt.Errorf("failed to read record: %w", err)
 	}
 
+	if policy != nil {
+		allowed, err := policy.apply(lb.Allocator(), record)
+		record.Release()
+		if err != nil {
+			return nil, err
+		}
+		record = allowed
+	}
+
 	log.Debug().
 		Int64("rows", record.NumRows()).
 		Int("columns", len(record.Columns())).
@@ -330,156 +668,516 @@ func (lb *Lockbox) Query(ctx context.Context, query string, opts ...Option) (arr
 		opt(options)
 	}
 
-	if options.Password == "" {
+	qe, err := lb.newQueryExec(ctx, options)
+	if err != nil {
+		return nil, err
+	}
+	result, err := qe.run(query)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Debug().Str("query", query).Int64("rows", result.NumRows()).Msg("Executed query on lockbox")
+
+	return result, nil
+}
+
+// newQueryExec prepares a reader for the password or column keys in
+// options and the access policy for its principal
+func (lb *Lockbox) newQueryExec(ctx context.Context, options *Options) (*queryExec, error) {
+	var reader *format.Reader
+	var err error
+	switch {
+	case options.Password != "":
+		reader, err = lb.file.NewReader(options.Password)
+	case len(options.ColumnKeys) > 0:
+		reader, err = lb.file.NewReaderFromKeys(options.ColumnKeys...)
+	default:
 		return nil, fmt.Errorf("password is required for querying")
 	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to create reader: %w", err)
+	}
 
-	pq, err := parseQuery(query)
+	policy, err := lb.checkPolicy(options.Principal, ActionRead)
 	if err != nil {
 		return nil, err
 	}
 
-	// Determine required columns
-	required := append([]string{}, pq.SelectCols...)
-	if pq.WhereCol != "" {
-		if !contains(required, pq.WhereCol) {
-			required = append(required, pq.WhereCol)
+	first, err := lb.file.Metadata().RowGroupsAt(options.SinceSnapshot)
+	if err != nil {
+		return nil, err
+	}
+
+	qe := &queryExec{ctx: ctx, reader: reader, meta: lb.file.Metadata(), policy: policy, mem: lb.Allocator(), first: first, args: options.Args}
+	if options.SampleRows > 0 {
+		qe.sample = &tableSample{Rows: options.SampleRows}
+	}
+	return qe.at(options.AsOf)
+}
+
+// maxViewDepth bounds how deeply views may reference other views
+const maxViewDepth = 8
+
+// queryExec evaluates queries against a single lockbox file
+type queryExec struct {
+	ctx    context.Context
+	reader *format.Reader
+	meta   *metadata.Metadata
+	depth  int
+	policy *policyScope
+	mem    memory.Allocator
+	sample *tableSample // default for SELECTs without TABLESAMPLE
+	first  int          // first row group read, after WithSinceSnapshot
+	until  *int         // row groups committed as of an AS OF snapshot, see end
+	args   []any        // values of the ? placeholders, see WithArgs
+}
+
+// run executes a query, combining the results of any UNIONed SELECTs
+func (qe *queryExec) run(query string) (arrow.Record, error) {
+	selects, unionAll, err := splitUnion(query)
+	if err != nil {
+		return nil, err
+	}
+
+	var result arrow.Record
+	args := qe.args
+	for i, sel := range selects {
+		pq, err := parseQuery(sel)
+		if err == nil {
+			args, err = pq.bindArgs(args)
+		}
+		if err != nil {
+			if result != nil {
+				result.Release()
+			}
+			return nil, err
+		}
+		rec, err := qe.execSelect(pq)
+		if err != nil {
+			if result != nil {
+				result.Release()
+			}
+			return nil, err
+		}
+		if result == nil {
+			result = rec
+			continue
+		}
+
+		combined, err := unionRecords(qe.mem, result, rec, !unionAll[i-1])
+		result.Release()
+		rec.Release()
+		if err != nil {
+			return nil, err
 		}
+		result = combined
 	}
-	if pq.OrderCol != "" {
-		if !contains(required, pq.OrderCol) {
-			required = append(required, pq.OrderCol)
+
+	return result, nil
+}
+
+// execSelect evaluates a parsed SELECT, resolving any subqueries first
+func (qe *queryExec) execSelect(pq *parsedQuery) (arrow.Record, error) {
+	if err := qe.ctx.Err(); err != nil {
+		return nil, err
+	}
+	qe, err := qe.at(pq.AsOf)
+	if err != nil {
+		return nil, err
+	}
+	if pq.Where != nil {
+		if err := qe.resolveSubqueries(pq.Where); err != nil {
+			return nil, err
 		}
 	}
 
-	reader, err := lb.file.NewReader(options.Password)
+	if view, ok := qe.meta.FindView(pq.From); ok {
+		return qe.execView(view, pq)
+	}
+
+	stored, virtual, err := qe.planColumns(pq)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create reader: %w", err)
+		return nil, err
 	}
 
-	rec, err := reader.ReadColumns(required)
+	// Row groups outside the bounding box of a spatial predicate are not
+	// decrypted. Samples are drawn from the row counts in the metadata, and
+	// only the row groups holding sampled rows are decrypted, unless the
+	// policy filters rows: the sample is then drawn from the rows it allows.
+	var rec arrow.Record
+	sample := qe.sampleFor(pq)
+	groups, pruned := qe.spatialRowGroups(pq.Where)
+	switch {
+	case sample != nil && (qe.policy == nil || len(qe.policy.filters) == 0):
+		rec, err = qe.readSample(sample, groups, pruned, stored)
+		sample = nil
+	case pruned && sample == nil:
+		rec, err = qe.reader.ReadColumnsIn(groups, stored)
+	default:
+		rec, err = qe.reader.ReadColumnsIn(qe.rowGroups(), stored)
+	}
 	if err != nil {
 		return nil, fmt.Errorf("failed to read data: %w", err)
 	}
 
-	result, err := applyQuery(rec, pq)
+	rec, err = qe.prepare(rec, virtual, sample)
 	if err != nil {
-		rec.Release()
 		return nil, err
 	}
-	rec.Release()
+	defer rec.Release()
+	return applyQuery(qe.mem, rec, pq)
+}
 
-	log.Debug().Str("query", query).Int64("rows", result.NumRows()).Msg("Executed query on lockbox")
+// planColumns returns the stored columns a SELECT decrypts and the virtual
+// columns computed from them. Nil stored columns read every column.
+func (qe *queryExec) planColumns(pq *parsedQuery) ([]string, []metadata.VirtualColumn, error) {
+	required := pq.referencedColumns()
+	if required != nil && qe.policy != nil {
+		for _, col := range qe.policy.columns() {
+			if !contains(required, col) {
+				required = append(required, col)
+			}
+		}
+	}
 
-	return result, nil
+	stored, virtual, err := planVirtualColumns(qe.meta, required)
+	if err != nil {
+		return nil, nil, err
+	}
+	stored = nestedRoots(qe.meta.Schema, stored)
+	if err := checkColumns(qe.meta.Schema, stored); err != nil {
+		return nil, nil, err
+	}
+	return stored, virtual, nil
+}
+
+// prepare applies the access policy, sample, if it is still to be drawn,
+// and virtual columns to decrypted rows before a SELECT is evaluated over
+// them. It takes ownership of rec.
+func (qe *queryExec) prepare(rec arrow.Record, virtual []metadata.VirtualColumn, sample *tableSample) (arrow.Record, error) {
+	// Apply the access policy before anything is computed from the data
+	if qe.policy != nil {
+		allowed, err := qe.policy.apply(qe.mem, rec)
+		rec.Release()
+		if err != nil {
+			return nil, err
+		}
+		rec = allowed
+	}
+
+	// Sample before virtual columns are computed so only kept rows pay for them
+	if sample != nil {
+		sampled := sampleRecord(qe.mem, rec, sample)
+		rec.Release()
+		rec = sampled
+	}
+
+	if len(virtual) > 0 {
+		withVirtual, err := addVirtualColumns(qe.mem, rec, virtual)
+		rec.Release()
+		if err != nil {
+			return nil, err
+		}
+		rec = withVirtual
+	}
+	return rec, nil
 }
 
 type aggregateSpec struct {
-	Func string
-	Col  string
+	Func  string
+	Col   string
+	Alias string
 }
 
 type parsedQuery struct {
 	SelectCols []string
+	SelectAs   []string // output name per SelectCols entry, "" keeps the column name
+	// SelectPath has the json_extract path of SelectCols entries, nil for
+	// plain columns
+	SelectPath []*jsonPath
 	Aggregates []aggregateSpec
-	WhereCol   string
-	WhereOp    string
-	WhereVal   string
+	Where      *wherePredicate
+	From       string
+	AsOf       *asOf
+	Sample     *tableSample
 	OrderCol   string
 	OrderDesc  bool
+	OrderVec   *vectorOrder // ORDER BY cosine_distance(...), instead of OrderCol
 	Limit      int
+	Offset     int
 }
 
 func parseQuery(q string) (*parsedQuery, error) {
+	toks, err := lexQuery(q)
+	if err != nil {
+		return nil, fmt.Errorf("invalid query: %w", err)
+	}
+
+	p := &queryParser{toks: toks}
+	pq, err := p.parseSelect()
+	if err != nil {
+		return nil, err
+	}
+	if !p.done() {
+		return nil, fmt.Errorf("invalid query: unexpected %q", p.peek().Text)
+	}
+	return pq, nil
+}
+
+// queryParser walks the token stream of a query
+type queryParser struct {
+	toks []token
+	pos  int
+}
+
+func (p *queryParser) done() bool {
+	return p.pos >= len(p.toks)
+}
+
+func (p *queryParser) peek() token {
+	if p.done() {
+		return token{}
+	}
+	return p.toks[p.pos]
+}
+
+func (p *queryParser) next() token {
+	t := p.peek()
+	p.pos++
+	return t
+}
+
+// acceptKeyword consumes the next token if it is the given keyword
+func (p *queryParser) acceptKeyword(kw string) bool {
+	if !p.done() && p.peek().isKeyword(kw) {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *queryParser) expect(kind tokenKind, what string) (token, error) {
+	if p.done() || p.peek().Kind != kind {
+		return token{}, fmt.Errorf("invalid query: expected %s", what)
+	}
+	return p.next(), nil
+}
+
+// parseSelect parses a SELECT statement, stopping at the end of input or
+// at a closing parenthesis that ends an enclosing subquery.
+func (p *queryParser) parseSelect() (*parsedQuery, error) {
 	pq := &parsedQuery{Limit: -1}
 
-	upper := strings.ToUpper(q)
-	parts := strings.Fields(upper)
-	if len(parts) < 4 || parts[0] != "SELECT" {
+	if !p.acceptKeyword("SELECT") {
 		return nil, fmt.Errorf("invalid query")
 	}
 
-	fromIdx := -1
-	for i, p := range parts {
-		if p == "FROM" {
-			fromIdx = i
+	for {
+		if p.done() {
+			return nil, fmt.Errorf("invalid query")
+		}
+		t := p.next()
+		switch {
+		case t.Kind == tokStar:
+		case t.Kind == tokIdent && p.peek().Kind == tokLParen && isAggregate(t.upper()):
+			p.next()
+			arg := p.next()
+			if arg.Kind != tokStar && arg.Kind != tokIdent {
+				return nil, fmt.Errorf("invalid argument to %s", t.upper())
+			}
+			if _, err := p.expect(tokRParen, "')'"); err != nil {
+				return nil, err
+			}
+			alias, err := p.parseAlias()
+			if err != nil {
+				return nil, err
+			}
+			pq.Aggregates = append(pq.Aggregates, aggregateSpec{Func: t.upper(), Col: strings.ToLower(arg.Text), Alias: alias})
+		case t.isKeyword("JSON_EXTRACT") && p.peek().Kind == tokLParen:
+			col, path, err := p.parseJSONExtract()
+			if err != nil {
+				return nil, err
+			}
+			alias, err := p.parseAlias()
+			if err != nil {
+				return nil, err
+			}
+			pq.SelectPath = append(pq.SelectPath, make([]*jsonPath, len(pq.SelectCols)-len(pq.SelectPath))...)
+			pq.SelectCols = append(pq.SelectCols, col)
+			pq.SelectAs = append(pq.SelectAs, alias)
+			pq.SelectPath = append(pq.SelectPath, path)
+		case t.Kind == tokIdent && !t.isKeyword("FROM"):
+			alias, err := p.parseAlias()
+			if err != nil {
+				return nil, err
+			}
+			pq.SelectCols = append(pq.SelectCols, strings.ToLower(t.Text))
+			pq.SelectAs = append(pq.SelectAs, alias)
+		default:
+			return nil, fmt.Errorf("invalid query")
+		}
+		if p.peek().Kind != tokComma {
 			break
 		}
+		p.next()
 	}
-	if fromIdx == -1 || fromIdx == 1 {
+
+	if !p.acceptKeyword("FROM") {
 		return nil, fmt.Errorf("invalid query")
 	}
+	from, err := p.expect(tokIdent, "table name")
+	if err != nil {
+		return nil, err
+	}
+	pq.From = strings.ToLower(from.Text)
 
-	selectRaw := strings.Join(parts[1:fromIdx], " ")
-	cols := strings.Split(selectRaw, ",")
-	for _, raw := range cols {
-		c := strings.TrimSpace(raw)
-		u := strings.ToUpper(c)
-		switch {
-		case strings.HasPrefix(u, "COUNT(") && strings.HasSuffix(c, ")"):
-			col := strings.TrimSuffix(c[len("COUNT("):], ")")
-			pq.Aggregates = append(pq.Aggregates, aggregateSpec{Func: "COUNT", Col: strings.ToLower(col)})
-		case strings.HasPrefix(u, "SUM(") && strings.HasSuffix(c, ")"):
-			col := strings.TrimSuffix(c[len("SUM("):], ")")
-			pq.Aggregates = append(pq.Aggregates, aggregateSpec{Func: "SUM", Col: strings.ToLower(col)})
-		case strings.HasPrefix(u, "AVG(") && strings.HasSuffix(c, ")"):
-			col := strings.TrimSuffix(c[len("AVG("):], ")")
-			pq.Aggregates = append(pq.Aggregates, aggregateSpec{Func: "AVG", Col: strings.ToLower(col)})
-		case strings.HasPrefix(u, "MIN(") && strings.HasSuffix(c, ")"):
-			col := strings.TrimSuffix(c[len("MIN("):], ")")
-			pq.Aggregates = append(pq.Aggregates, aggregateSpec{Func: "MIN", Col: strings.ToLower(col)})
-		case strings.HasPrefix(u, "MAX(") && strings.HasSuffix(c, ")"):
-			col := strings.TrimSuffix(c[len("MAX("):], ")")
-			pq.Aggregates = append(pq.Aggregates, aggregateSpec{Func: "MAX", Col: strings.ToLower(col)})
-		default:
-			if c != "" && c != "*" {
-				pq.SelectCols = append(pq.SelectCols, strings.ToLower(c))
-			}
+	if p.acceptKeyword("AS") {
+		if !p.acceptKeyword("OF") {
+			return nil, fmt.Errorf("invalid query: expected OF after AS")
+		}
+		if pq.AsOf, err = p.parseAsOf(); err != nil {
+			return nil, err
+		}
+	}
+	if p.acceptKeyword("TABLESAMPLE") {
+		if pq.Sample, err = p.parseTableSample(); err != nil {
+			return nil, err
 		}
 	}
 
-	i := fromIdx + 2 // skip FROM data
-	for i < len(parts) {
-		switch parts[i] {
-		case "WHERE":
-			if i+3 >= len(parts) {
-				return nil, fmt.Errorf("invalid WHERE clause")
+	for !p.done() && p.peek().Kind != tokRParen {
+		switch {
+		case p.acceptKeyword("WHERE"):
+			where, err := p.parseOr()
+			if err != nil {
+				return nil, err
 			}
-			pq.WhereCol = strings.ToLower(parts[i+1])
-			pq.WhereOp = parts[i+2]
-			pq.WhereVal = parts[i+3]
-			i += 4
-		case "ORDER":
-			if i+3 >= len(parts) || parts[i+1] != "BY" {
+			pq.Where = where
+		case p.acceptKeyword("ORDER"):
+			if !p.acceptKeyword("BY") {
 				return nil, fmt.Errorf("invalid ORDER BY clause")
 			}
-			pq.OrderCol = strings.ToLower(parts[i+2])
-			if i+3 < len(parts) && (parts[i+3] == "DESC" || parts[i+3] == "ASC") {
-				pq.OrderDesc = parts[i+3] == "DESC"
-				i += 4
+			col, err := p.expect(tokIdent, "column in ORDER BY clause")
+			if err != nil {
+				return nil, fmt.Errorf("invalid ORDER BY clause")
+			}
+			if col.upper() == "COSINE_DISTANCE" && p.peek().Kind == tokLParen {
+				if pq.OrderVec, err = p.parseCosineDistance(); err != nil {
+					return nil, err
+				}
 			} else {
-				i += 3
+				pq.OrderCol = strings.ToLower(col.Text)
 			}
-		case "LIMIT":
-			if i+1 >= len(parts) {
+			if p.acceptKeyword("DESC") {
+				pq.OrderDesc = true
+			} else {
+				p.acceptKeyword("ASC")
+			}
+		case p.acceptKeyword("LIMIT"):
+			val, err := p.expect(tokNumber, "LIMIT value")
+			if err != nil {
 				return nil, fmt.Errorf("invalid LIMIT clause")
 			}
-			val, err := strconv.Atoi(parts[i+1])
+			n, err := strconv.Atoi(val.Text)
 			if err != nil {
 				return nil, fmt.Errorf("invalid LIMIT value")
 			}
-			pq.Limit = val
-			i += 2
+			pq.Limit = n
+		case p.acceptKeyword("OFFSET"):
+			val, err := p.expect(tokNumber, "OFFSET value")
+			if err != nil {
+				return nil, fmt.Errorf("invalid OFFSET clause")
+			}
+			n, err := strconv.Atoi(val.Text)
+			if err != nil || n < 0 {
+				return nil, fmt.Errorf("invalid OFFSET value")
+			}
+			pq.Offset = n
 		default:
-			i++
+			return nil, fmt.Errorf("invalid query: unexpected %q", p.peek().Text)
+		}
+	}
+
+	// ORDER BY may refer to a column by its output alias
+	for i, alias := range pq.SelectAs {
+		if alias != "" && alias == pq.OrderCol && pq.selectPath(i) == nil {
+			pq.OrderCol = pq.SelectCols[i]
+			break
 		}
 	}
 
 	return pq, nil
 }
 
-func applyQuery(rec arrow.Record, pq *parsedQuery) (arrow.Record, error) {
-	mem := memory.NewGoAllocator()
+// selectPath returns the json_extract path of the i-th select item, or nil
+func (pq *parsedQuery) selectPath(i int) *jsonPath {
+	if i < len(pq.SelectPath) {
+		return pq.SelectPath[i]
+	}
+	return nil
+}
+
+// parseAlias parses an optional "AS name" following a select item
+func (p *queryParser) parseAlias() (string, error) {
+	if !p.acceptKeyword("AS") {
+		return "", nil
+	}
+	alias, err := p.expect(tokIdent, "alias after AS")
+	if err != nil {
+		return "", err
+	}
+	return strings.ToLower(alias.Text), nil
+}
+
+// referencedColumns lists the columns a query needs to read. A nil result
+// means all columns are required, as for SELECT *.
+func (pq *parsedQuery) referencedColumns() []string {
+	if len(pq.SelectCols) == 0 && len(pq.Aggregates) == 0 {
+		return nil
+	}
+
+	required := append([]string{}, pq.SelectCols...)
+	for _, ag := range pq.Aggregates {
+		if ag.Col != "*" && !contains(required, ag.Col) {
+			required = append(required, ag.Col)
+		}
+	}
+	if pq.Where != nil {
+		required = pq.Where.columns(required)
+	}
+	if pq.OrderCol != "" && !contains(required, pq.OrderCol) {
+		required = append(required, pq.OrderCol)
+	}
+	if pq.OrderVec != nil && !contains(required, pq.OrderVec.Col) {
+		required = append(required, pq.OrderVec.Col)
+	}
+	return required
+}
+
+func isAggregate(name string) bool {
+	switch name {
+	case "COUNT", "SUM", "AVG", "MIN", "MAX":
+		return true
+	}
+	return false
+}
+
+func applyQuery(mem memory.Allocator, rec arrow.Record, pq *parsedQuery) (arrow.Record, error) {
+	if len(pq.SelectCols) == 0 && len(pq.Aggregates) == 0 {
+		for _, f := range rec.Schema().Fields() {
+			pq.SelectCols = append(pq.SelectCols, f.Name)
+		}
+		pq.SelectAs = make([]string, len(pq.SelectCols))
+	}
+
+	// Paths into nested columns, e.g. address.city, become columns of
+	// their own
+	rec, err := withNestedColumns(mem, rec, pq.referencedColumns())
+	if err != nil {
+		return nil, err
+	}
+	defer rec.Release()
 
 	rowCount := int(rec.NumRows())
 	idx := make([]int, rowCount)
@@ -488,11 +1186,14 @@ func applyQuery(rec arrow.Record, pq *parsedQuery) (arrow.Record, error) {
 	}
 
 	// WHERE filtering
-	if pq.WhereCol != "" {
-		col := rec.Column(rec.Schema().FieldIndices(pq.WhereCol)[0])
+	if pq.Where != nil {
+		match, err := pq.Where.bind(rec)
+		if err != nil {
+			return nil, err
+		}
 		var keep []int
 		for _, i := range idx {
-			if matchValue(col, i, pq.WhereOp, pq.WhereVal) {
+			if match(i) {
 				keep = append(keep, i)
 			}
 		}
@@ -500,7 +1201,11 @@ func applyQuery(rec arrow.Record, pq *parsedQuery) (arrow.Record, error) {
 	}
 
 	// ORDER BY
-	if pq.OrderCol != "" {
+	if pq.OrderVec != nil {
+		if err := pq.OrderVec.sortByDistance(rec, idx, pq.OrderDesc); err != nil {
+			return nil, err
+		}
+	} else if pq.OrderCol != "" {
 		col := rec.Column(rec.Schema().FieldIndices(pq.OrderCol)[0])
 		sort.Slice(idx, func(a, b int) bool {
 			va := getValue(col, idx[a])
@@ -520,9 +1225,14 @@ func applyQuery(rec arrow.Record, pq *parsedQuery) (arrow.Record, error) {
 		for i, ag := range pq.Aggregates {
 			val, dt, err := computeAggregate(rec, idx, ag)
 			if err != nil {
+				releaseArrays(arrays)
 				return nil, err
 			}
-			fields[i] = arrow.Field{Name: fmt.Sprintf("%s_%s", strings.ToLower(ag.Func), ag.Col), Type: dt}
+			name := ag.Alias
+			if name == "" {
+				name = fmt.Sprintf("%s_%s", strings.ToLower(ag.Func), ag.Col)
+			}
+			fields[i] = arrow.Field{Name: name, Type: dt, Nullable: val == nil}
 			switch dt.ID() {
 			case arrow.INT64:
 				b := array.NewInt64Builder(mem)
@@ -539,6 +1249,17 @@ func applyQuery(rec arrow.Record, pq *parsedQuery) (arrow.Record, error) {
 				b.Append(val.(string))
 				arrays[i] = b.NewArray()
 				b.Release()
+			case arrow.DECIMAL128, arrow.DURATION, arrow.DATE32, arrow.DATE64, arrow.TIME32, arrow.TIME64, arrow.TIMESTAMP:
+				b := array.NewBuilder(mem, dt)
+				if val == nil {
+					b.AppendNull()
+				} else if err := b.AppendValueFromString(val.(string)); err != nil {
+					b.Release()
+					releaseArrays(arrays)
+					return nil, err
+				}
+				arrays[i] = b.NewArray()
+				b.Release()
 			default:
 				b := array.NewStringBuilder(mem)
 				b.Append(fmt.Sprintf("%v", val))
@@ -548,45 +1269,49 @@ func applyQuery(rec arrow.Record, pq *parsedQuery) (arrow.Record, error) {
 		}
 
 		schema := arrow.NewSchema(fields, nil)
-		return array.NewRecord(schema, arrays, 1), nil
+		out := array.NewRecord(schema, arrays, 1)
+		releaseArrays(arrays)
+		return out, nil
 	}
 
-	// LIMIT
+	// OFFSET, then LIMIT
+	idx = idx[min(pq.Offset, len(idx)):]
 	if pq.Limit >= 0 && pq.Limit < len(idx) {
 		idx = idx[:pq.Limit]
 	}
 
 	// Build result
-	if len(pq.SelectCols) == 0 {
-		for _, f := range rec.Schema().Fields() {
-			pq.SelectCols = append(pq.SelectCols, f.Name)
-		}
-	}
-
 	builders := make([]array.Builder, len(pq.SelectCols))
 	fields := make([]arrow.Field, len(pq.SelectCols))
+	docs := make([]func(int) ([]byte, bool), len(pq.SelectCols))
 
 	for i, name := range pq.SelectCols {
 		fIdx := rec.Schema().FieldIndices(name)[0]
 		field := rec.Schema().Field(fIdx)
 		fields[i] = field
-		switch field.Type.ID() {
-		case arrow.INT64:
-			builders[i] = array.NewInt64Builder(mem)
-		case arrow.FLOAT64:
-			builders[i] = array.NewFloat64Builder(mem)
-		case arrow.STRING:
-			builders[i] = array.NewStringBuilder(mem)
-		case arrow.TIMESTAMP:
-			builders[i] = array.NewTimestampBuilder(mem, field.Type.(*arrow.TimestampType))
-		default:
-			// fallback to string, or handle more types as needed
-			builders[i] = array.NewStringBuilder(mem)
+		if path := pq.selectPath(i); path != nil {
+			// json_extract returns text
+			var err error
+			if docs[i], err = jsonDocs(name, rec.Column(fIdx)); err != nil {
+				for _, b := range builders[:i] {
+					b.Release()
+				}
+				return nil, err
+			}
+			fields[i] = arrow.Field{Name: path.outputName(name), Type: arrow.BinaryTypes.String, Nullable: true}
+		}
+		if pq.SelectAs[i] != "" {
+			fields[i].Name = pq.SelectAs[i]
 		}
+		builders[i] = newBuilder(mem, fields[i])
 	}
 
 	for _, row := range idx {
 		for i, name := range pq.SelectCols {
+			if path := pq.selectPath(i); path != nil {
+				path.appendExtracted(builders[i].(*array.StringBuilder), docs[i], row)
+				continue
+			}
 			fIdx := rec.Schema().FieldIndices(name)[0]
 			col := rec.Column(fIdx)
 			appendValue(builders[i], col, row)
@@ -596,14 +1321,21 @@ func applyQuery(rec arrow.Record, pq *parsedQuery) (arrow.Record, error) {
 	arrays := make([]arrow.Array, len(builders))
 	for i, b := range builders {
 		arrays[i] = b.NewArray()
+		// Unsupported types fall back to strings; keep the schema in sync
+		fields[i].Type = arrays[i].DataType()
 		b.Release()
 	}
 
 	schema := arrow.NewSchema(fields, nil)
-	return array.NewRecord(schema, arrays, int64(len(idx))), nil
+	out := array.NewRecord(schema, arrays, int64(len(idx)))
+	releaseArrays(arrays)
+	return out, nil
 }
 
 func matchValue(col arrow.Array, row int, op, val string) bool {
+	if c, ok := compareLiteral(col, row, val); ok {
+		return compareOp(c, op)
+	}
 	cv := getValue(col, row)
 	fVal, ferr := strconv.ParseFloat(val, 64)
 	switch v := cv.(type) {
@@ -656,12 +1388,29 @@ func getValue(col arrow.Array, row int) interface{} {
 	case *array.Int64:
 		val := c.Value(row)
 		return val
+	case *array.Int32:
+		return int64(c.Value(row))
 	case *array.Float64:
 		val := c.Value(row)
 		return val
+	case *array.Float32:
+		return float64(c.Value(row))
 	case *array.String:
 		val := c.Value(row)
 		return val
+	case *array.Boolean:
+		return c.Value(row)
+	case *array.Decimal128:
+		return c.Value(row).ToFloat64(c.DataType().(*arrow.Decimal128Type).Scale)
+	case *array.Date32:
+		return c.Value(row).FormattedString()
+	case *array.Date64:
+		return c.Value(row).FormattedString()
+	case *array.Time32, *array.Time64:
+		// Fixed width per unit, so the text sorts in time order
+		return c.ValueStr(row)
+	case *array.Duration:
+		return durationValue(c, row)
 	case *array.Timestamp:
 		ts := c.Value(row)
 		switch typ := c.DataType().(*arrow.TimestampType); typ.Unit {
@@ -681,7 +1430,37 @@ func getValue(col arrow.Array, row int) interface{} {
 	}
 }
 
+// newBuilder returns a builder able to hold values of the given field
+func newBuilder(mem memory.Allocator, field arrow.Field) array.Builder {
+	switch field.Type.ID() {
+	case arrow.INT64:
+		return array.NewInt64Builder(mem)
+	case arrow.FLOAT64:
+		return array.NewFloat64Builder(mem)
+	case arrow.STRING:
+		return array.NewStringBuilder(mem)
+	case arrow.TIMESTAMP:
+		return array.NewTimestampBuilder(mem, field.Type.(*arrow.TimestampType))
+	case arrow.BOOL, arrow.DECIMAL128, arrow.DATE32, arrow.DATE64, arrow.TIME32, arrow.TIME64, arrow.DURATION:
+		return array.NewBuilder(mem, field.Type)
+	case arrow.FIXED_SIZE_LIST:
+		if _, ok := vectorDim(field.Type); ok {
+			return array.NewFixedSizeListBuilder(mem, field.Type.(*arrow.FixedSizeListType).Len(), arrow.PrimitiveTypes.Float32)
+		}
+		return array.NewBuilder(mem, field.Type)
+	case arrow.STRUCT, arrow.LIST, arrow.LARGE_LIST, arrow.MAP:
+		return array.NewBuilder(mem, field.Type)
+	default:
+		// fallback to string, or handle more types as needed
+		return array.NewStringBuilder(mem)
+	}
+}
+
 func appendValue(b array.Builder, col arrow.Array, row int) {
+	if col.IsNull(row) {
+		b.AppendNull()
+		return
+	}
 	switch c := col.(type) {
 	case *array.Int64:
 		b.(*array.Int64Builder).Append(c.Value(row))
@@ -691,6 +1470,32 @@ func appendValue(b array.Builder, col arrow.Array, row int) {
 		b.(*array.StringBuilder).Append(c.Value(row))
 	case *array.Timestamp:
 		b.(*array.TimestampBuilder).Append(c.Value(row))
+	case *array.Boolean:
+		b.(*array.BooleanBuilder).Append(c.Value(row))
+	case *array.Decimal128:
+		b.(*array.Decimal128Builder).Append(c.Value(row))
+	case *array.Date32:
+		b.(*array.Date32Builder).Append(c.Value(row))
+	case *array.Date64:
+		b.(*array.Date64Builder).Append(c.Value(row))
+	case *array.Time32:
+		b.(*array.Time32Builder).Append(c.Value(row))
+	case *array.Time64:
+		b.(*array.Time64Builder).Append(c.Value(row))
+	case *array.Duration:
+		b.(*array.DurationBuilder).Append(c.Value(row))
+	case *array.FixedSizeList:
+		if lb, ok := b.(*array.FixedSizeListBuilder); ok {
+			appendVector(lb, c, row)
+			return
+		}
+		copyValue(b, col, row)
+	case *array.Struct, *array.List, *array.LargeList, *array.Map:
+		copyValue(b, col, row)
+	default:
+		if sb, ok := b.(*array.StringBuilder); ok {
+			sb.Append(col.ValueStr(row))
+		}
 	}
 }
 
@@ -798,11 +1603,67 @@ func computeAggregate(rec arrow.Record, idx []int, ag aggregateSpec) (interface{
 				return min, arrow.BinaryTypes.String, nil
 			}
 			return max, arrow.BinaryTypes.String, nil
+		case *array.Decimal128, *array.Duration, *array.Date32, *array.Date64, *array.Time32, *array.Time64, *array.Timestamp:
+			return computeTypedAggregate(col, idx, ag.Func)
 		}
 	}
 	return nil, nil, fmt.Errorf("unsupported aggregate")
 }
 
+// computeTypedAggregate computes an aggregate of a decimal, duration, date,
+// time or timestamp column. The result is the text form of a value of the
+// returned type; MIN and MAX are nil when every row is null. SUM of
+// decimals is exact, widened to the largest precision, and AVG of decimals
+// is a float64.
+func computeTypedAggregate(col arrow.Array, idx []int, fn string) (interface{}, arrow.DataType, error) {
+	var rows []int
+	for _, i := range idx {
+		if col.IsValid(i) {
+			rows = append(rows, i)
+		}
+	}
+
+	switch fn {
+	case "MIN", "MAX":
+		if len(rows) == 0 {
+			return nil, col.DataType(), nil
+		}
+		best := rows[0]
+		for _, i := range rows[1:] {
+			if c := compareCells(col, i, best); (fn == "MIN" && c < 0) || (fn == "MAX" && c > 0) {
+				best = i
+			}
+		}
+		return formatValue(col, best), col.DataType(), nil
+	}
+
+	switch c := col.(type) {
+	case *array.Decimal128:
+		typ := c.DataType().(*arrow.Decimal128Type)
+		var sum decimal128.Num
+		for _, i := range rows {
+			sum = sum.Add(c.Value(i))
+		}
+		if fn == "AVG" {
+			if len(rows) == 0 {
+				return float64(0), arrow.PrimitiveTypes.Float64, nil
+			}
+			return sum.ToFloat64(typ.Scale) / float64(len(rows)), arrow.PrimitiveTypes.Float64, nil
+		}
+		return sum.ToString(typ.Scale), &arrow.Decimal128Type{Precision: 38, Scale: typ.Scale}, nil
+	case *array.Duration:
+		var sum time.Duration
+		for _, i := range rows {
+			sum += durationValue(c, i)
+		}
+		if fn == "AVG" && len(rows) > 0 {
+			sum /= time.Duration(len(rows))
+		}
+		return sum.String(), c.DataType(), nil
+	}
+	return nil, nil, fmt.Errorf("unsupported aggregate %s on %s", fn, col.DataType())
+}
+
 func less(a, b interface{}) bool {
 	switch av := a.(type) {
 	case int64:
@@ -811,6 +1672,12 @@ func less(a, b interface{}) bool {
 		return av < b.(float64)
 	case string:
 		return av < b.(string)
+	case bool:
+		bv, ok := b.(bool)
+		return ok && !av && bv
+	case time.Duration:
+		bv, ok := b.(time.Duration)
+		return ok && av < bv
 	default:
 		return false
 	}
@@ -827,17 +1694,70 @@ func contains(list []string, v string) bool {
 
 // Info returns information about the lockbox file
 func (lb *Lockbox) Info() (*Info, error) {
-	meta := lb.file.Metadata()
+	return infoFromFile(lb.file)
+}
+
+// ReadInfo returns the information about a lockbox file that is stored in
+// the clear, without a password
+func ReadInfo(filename string) (*Info, error) {
+	file, err := openMetadataOnly(filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open lockbox file: %w", err)
+	}
+	defer file.Close()
+	return infoFromFile(file)
+}
+
+func infoFromFile(file *format.LockboxFile) (*Info, error) {
+	summary, err := file.Summary()
+	if err != nil {
+		return nil, err
+	}
+	meta := file.Metadata()
+
+	var last *metadata.Snapshot
+	if n := len(meta.Snapshots); n > 0 {
+		s := meta.Snapshots[n-1]
+		last = &s
+	}
+	var preset string
+	if meta.Preset != nil {
+		preset = meta.Preset.Name
+	}
 
 	return &Info{
-		Version:     meta.Header.Version,
-		Schema:      meta.Schema,
-		CreatedAt:   meta.AuditTrail.CreatedAt,
-		CreatedBy:   meta.AuditTrail.CreatedBy,
-		ModifiedAt:  meta.AuditTrail.ModifiedAt,
-		ModifiedBy:  meta.AuditTrail.ModifiedBy,
-		BlockCount:  len(meta.BlockInfo),
+		Version:     summary.Version,
+		Schema:      summary.Schema,
+		CreatedAt:   summary.CreatedAt,
+		CreatedBy:   summary.CreatedBy,
+		ModifiedAt:  summary.ModifiedAt,
+		ModifiedBy:  summary.ModifiedBy,
+		BlockCount:  summary.BlockCount,
+		RowCount:    summary.RowCount,
+		DeletedRows: summary.DeletedRows,
+		FileSize:    summary.FileSize,
 		AccessCount: len(meta.AuditTrail.AccessLog),
+		Module:      summary.Module,
+		Codecs:      summary.Codecs,
+		KeyEpochs:   meta.Encryption.ColumnEpochs,
+		StaleBlocks: file.StaleKeyBlocks(),
+		Description: summary.Description,
+		Tags:        summary.Tags,
+		LastCommit:  last,
+		Preset:      preset,
+		AccessKeys:  accessGrants(meta),
+
+		ColumnGroups: summary.Groups,
+
+		EncryptedMetadata: summary.EncryptedMetadata,
+		KeyDerivation:     summary.KeyDerivation,
+		SyntheticKey:      meta.SyntheticKey,
+		BlockSignatures:   meta.Encryption.SignatureScheme(),
+		SigningKey:        meta.Encryption.SigningKey,
+		Sequences:         meta.Sequences,
+		Columns:           summary.Columns,
+		Replica:           meta.Replica,
+		SchemaRef:         meta.SchemaRef,
 	}, nil
 }
 
@@ -846,9 +1766,22 @@ func (lb *Lockbox) Validate() error {
 	return lb.file.ValidateBlocks()
 }
 
-// Repair attempts to remove corrupted blocks and update metadata
-func (lb *Lockbox) Repair() error {
-	return lb.file.Repair()
+// Repair attempts to remove corrupted blocks and update metadata. Files
+// with signed blocks need WithPassword when row groups after a corrupted
+// one have to be renumbered, so their blocks can be signed again.
+func (lb *Lockbox) Repair(opts ...Option) error {
+	options := &Options{}
+	for _, opt := range opts {
+		opt(options)
+	}
+	if options.Password == "" {
+		return lb.file.Repair()
+	}
+	writer, err := lb.writerFor(options.Password)
+	if err != nil {
+		return err
+	}
+	return writer.Repair()
 }
 
 // GetBlob returns the blob data for the given field and row index.
@@ -884,17 +1817,58 @@ func (lb *Lockbox) GetBlob(field string, row int, opts ...Option) ([]byte, error
 
 // Info represents information about a lockbox file
 type Info struct {
-	Version     uint32        `json:"version"`
-	Schema      *arrow.Schema `json:"-"`
-	CreatedAt   interface{}   `json:"createdAt"`
-	CreatedBy   string        `json:"createdBy"`
-	ModifiedAt  interface{}   `json:"modifiedAt"`
-	ModifiedBy  string        `json:"modifiedBy"`
-	BlockCount  int           `json:"blockCount"`
-	AccessCount int           `json:"accessCount"`
+	Version     uint32             `json:"version"`
+	Schema      *arrow.Schema      `json:"-"`
+	CreatedAt   interface{}        `json:"createdAt"`
+	CreatedBy   string             `json:"createdBy"`
+	ModifiedAt  interface{}        `json:"modifiedAt"`
+	ModifiedBy  string             `json:"modifiedBy"`
+	BlockCount  int                `json:"blockCount"`
+	RowCount    int64              `json:"rowCount"`
+	DeletedRows int64              `json:"deletedRows,omitempty"`
+	FileSize    int64              `json:"fileSize"`
+	AccessCount int                `json:"accessCount"`
+	Module      string             `json:"module"`
+	Codecs      []string           `json:"codecs,omitempty"`
+	KeyEpochs   map[string]int     `json:"keyEpochs,omitempty"`
+	StaleBlocks int                `json:"staleBlocks,omitempty"`
+	Description string             `json:"description,omitempty"`
+	Tags        map[string]string  `json:"tags,omitempty"`
+	LastCommit  *metadata.Snapshot `json:"lastCommit,omitempty"`
+	Preset      string             `json:"preset,omitempty"`
+	AccessKeys  []AccessGrant      `json:"accessKeys,omitempty"`
+
+	// ColumnGroups are the columns stored and encrypted together
+	ColumnGroups []metadata.ColumnGroup `json:"columnGroups,omitempty"`
+	// EncryptedMetadata is set for files whose metadata is encrypted;
+	// read without a password, their info is mostly empty
+	EncryptedMetadata bool `json:"encryptedMetadata,omitempty"`
+	// KeyDerivation is how keys are derived from passwords
+	KeyDerivation string `json:"keyDerivation,omitempty"`
+	// SyntheticKey is the column writes fill with generated keys
+	SyntheticKey *metadata.SyntheticKey `json:"syntheticKey,omitempty"`
+	// BlockSignatures is how blocks are signed; empty for unsigned files
+	BlockSignatures string `json:"blockSignatures,omitempty"`
+	// SigningKey is the public key blocks and change streams are signed
+	// with, recorded with the first signed block
+	SigningKey []byte `json:"signingKey,omitempty"`
+	// Sequences are the auto-increment columns and their last values
+	Sequences []metadata.Sequence `json:"sequences,omitempty"`
+	// Columns sums up the blocks of each column: rows, nulls, encrypted
+	// bytes and, for files with encrypted metadata, the value range
+	Columns map[string]*metadata.ColumnRollup `json:"columns,omitempty"`
+	// Replica names the file and snapshot of read-only replicas
+	Replica *metadata.Replica `json:"replica,omitempty"`
+	// SchemaRef is the schema registry schema the file was created from
+	SchemaRef *metadata.SchemaRef `json:"schemaRef,omitempty"`
 }
 
-// IngestParquet ingests a Parquet file into the lockbox
+// IngestParquet ingests a Parquet file into the lockbox. Columns are
+// matched by name, exactly or else ignoring case, so their order in the file
+// does not matter. Lockbox columns the file lacks are null and must be
+// nullable; columns the lockbox lacks are an error unless
+// WithIgnoreExtraColumns skips them. WithDrift handles new columns and type
+// changes instead.
 func (lb *Lockbox) IngestParquet(ctx context.Context, path string, opts ...Option) error {
 	options := &Options{Password: "", Columns: []string{}, DryRun: false, CryptoModule: ""}
 	for _, opt := range opts {
@@ -911,7 +1885,7 @@ func (lb *Lockbox) IngestParquet(ctx context.Context, path string, opts ...Optio
 	}
 	defer f.Close()
 
-	mem := memory.NewGoAllocator()
+	mem := lb.Allocator()
 
 	pf, err := file.NewParquetReader(f)
 	if err != nil {
@@ -928,10 +1902,41 @@ func (lb *Lockbox) IngestParquet(ctx context.Context, path string, opts ...Optio
 	if err != nil {
 		return fmt.Errorf("failed to get parquet schema: %w", err)
 	}
-	if err := validateParquetSchema(lb.Schema(), pqSchema); err != nil {
+	var drift *SchemaDrift
+	if options.Drift != "" {
+		if drift, err = lb.resolveDrift(ctx, pqSchema, typeFits, options); err != nil {
+			return err
+		}
+	} else if err := validateParquetSchema(lb.Schema(), pqSchema, options.IgnoreExtraColumns); err != nil {
 		return err
 	}
 
+	// Each batch is committed separately with the same message and lineage
+	lineage := metadata.Lineage{Source: path}
+	if options.Lineage != nil {
+		lineage = *options.Lineage
+		if lineage.Source == "" {
+			lineage.Source = path
+		}
+	}
+	if lineage.SourceHash == "" {
+		if lineage.SourceHash, err = SourceHash(path); err != nil {
+			return err
+		}
+	}
+	if options.SchemaRegistry != nil {
+		if lineage.Schema, err = lb.checkSchemaRegistry(ctx, options.SchemaRegistry); err != nil {
+			return err
+		}
+	}
+	writeOpts := []Option{
+		WithPassword(options.Password),
+		WithPrincipal(options.Principal),
+		WithMessage(options.Message),
+		WithLineage(lineage),
+		withOperation(OperationIngestParquet),
+	}
+
 	recReader, err := pqReader.GetRecordReader(ctx, nil, nil)
 	if err != nil {
 		return fmt.Errorf("failed to get record reader: %w", err)
@@ -941,14 +1946,19 @@ func (lb *Lockbox) IngestParquet(ctx context.Context, path string, opts ...Optio
 	var totalRows int64
 	for recReader.Next() {
 		rec := recReader.Record()
-		coerced, err := CoerceRecord(lb.Schema(), rec)
+		var coerced arrow.Record
+		if drift != nil {
+			coerced, err = drift.conformRecord(lb.Allocator(), rec)
+		} else {
+			coerced, err = coerceRecord(lb.Allocator(), lb.Schema(), rec)
+		}
 		if err != nil {
 			rec.Release()
 			return err
 		}
 
 		if !options.DryRun {
-			if err := lb.Write(ctx, coerced, WithPassword(options.Password)); err != nil {
+			if err := lb.Write(ctx, coerced, writeOpts...); err != nil {
 				coerced.Release()
 				rec.Release()
 				return err
@@ -972,21 +1982,34 @@ func (lb *Lockbox) IngestParquetAsync(ctx context.Context, path string, opts ...
 	return ch
 }
 
-// validateParquetSchema ensures the parquet schema matches or is a superset of the lockbox schema
-func validateParquetSchema(lb *arrow.Schema, pq *arrow.Schema) error {
-	for i, field := range lb.Fields() {
-		if i >= len(pq.Fields()) {
-			return fmt.Errorf("parquet missing field %s", field.Name)
-		}
+// WithIgnoreExtraColumns makes IngestParquet skip the columns of the file
+// that the lockbox does not have instead of failing
+func WithIgnoreExtraColumns() Option {
+	return func(o *Options) {
+		o.IgnoreExtraColumns = true
+	}
+}
 
-		pqField := pq.Field(i)
-		if field.Name != pqField.Name {
-			return fmt.Errorf("field name mismatch at index %d: %s vs %s", i, field.Name, pqField.Name)
+// validateParquetSchema ensures the parquet schema has, by name, every
+// column of the lockbox schema that is not nullable, in a compatible type,
+// and no other columns unless ignoreExtra is set
+func validateParquetSchema(lb *arrow.Schema, pq *arrow.Schema, ignoreExtra bool) error {
+	d := detectDrift(lb, pq, typesCompatible)
+	for _, name := range d.Missing {
+		if field, _ := lb.FieldsByName(name); !field[0].Nullable {
+			return fmt.Errorf("parquet missing field %s", name)
 		}
-
-		if !typesCompatible(field.Type, pqField.Type) {
-			return fmt.Errorf("incompatible type for field %s", field.Name)
+	}
+	if len(d.Changed) > 0 {
+		c := d.Changed[0]
+		return fmt.Errorf("incompatible type for field %s: %s, expected %s", c.Name, c.Type, c.Stored)
+	}
+	if len(d.Added) > 0 && !ignoreExtra {
+		var names []string
+		for _, c := range d.Added {
+			names = append(names, c.Name)
 		}
+		return fmt.Errorf("parquet has fields the lockbox does not: %s", strings.Join(names, ", "))
 	}
 	return nil
 }
@@ -1002,17 +2025,37 @@ func typesCompatible(dst, src arrow.DataType) bool {
 	return false
 }
 
-// CoerceRecord converts parquet record columns to lockbox schema order and types
+// CoerceRecord converts parquet record columns to lockbox schema order and
+// types. Columns are matched by name; lockbox columns the record lacks are
+// null and other columns of the record are left out.
 func CoerceRecord(schema *arrow.Schema, rec arrow.Record) (arrow.Record, error) {
+	return coerceRecord(memory.DefaultAllocator, schema, rec)
+}
+
+func coerceRecord(mem memory.Allocator, schema *arrow.Schema, rec arrow.Record) (arrow.Record, error) {
 	if rec.Schema().Equal(schema) {
 		rec.Retain()
 		return rec, nil
 	}
 
-	mem := memory.NewGoAllocator()
-	var cols []arrow.Array
-	for i, field := range schema.Fields() {
-		src := rec.Column(i)
+	d := detectDrift(schema, rec.Schema(), typesCompatible)
+	cols := make([]arrow.Array, 0, schema.NumFields())
+	release := func() {
+		for _, c := range cols {
+			c.Release()
+		}
+	}
+	for _, field := range schema.Fields() {
+		source, ok := d.source[field.Name]
+		if !ok {
+			if !field.Nullable {
+				release()
+				return nil, fmt.Errorf("column %s is missing", field.Name)
+			}
+			cols = append(cols, array.MakeArrayOfNull(mem, field.Type, int(rec.NumRows())))
+			continue
+		}
+		src := rec.Column(rec.Schema().FieldIndices(source)[0])
 		if !arrow.TypeEqual(field.Type, src.DataType()) {
 			if field.Type.ID() == arrow.INT64 && src.DataType().ID() == arrow.INT32 {
 				b := array.NewInt64Builder(mem)
@@ -1027,6 +2070,7 @@ func CoerceRecord(schema *arrow.Schema, rec arrow.Record) (arrow.Record, error)
 				cols = append(cols, b.NewArray())
 				b.Release()
 			} else {
+				release()
 				return nil, fmt.Errorf("cannot coerce column %s", field.Name)
 			}
 		} else {
@@ -1035,8 +2079,6 @@ func CoerceRecord(schema *arrow.Schema, rec arrow.Record) (arrow.Record, error)
 		}
 	}
 	out := array.NewRecord(schema, cols, rec.NumRows())
-	for _, c := range cols {
-		c.Release()
-	}
+	release()
 	return out, nil
 }
@@ -2,9 +2,14 @@ package lockbox
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"os"
+	"path/filepath"
 	"testing"
+	"time"
 
+	"github.com/TFMV/lockbox/pkg/format"
 	"github.com/apache/arrow-go/v18/arrow"
 	"github.com/apache/arrow-go/v18/arrow/array"
 	"github.com/apache/arrow-go/v18/arrow/memory"
@@ -183,6 +188,40 @@ func TestInfo(t *testing.T) {
 	t.Logf("Info test passed: created by %s, %d fields", info.CreatedBy, len(info.Schema.Fields()))
 }
 
+func TestReadInfo(t *testing.T) {
+	tmpFile := filepath.Join(t.TempDir(), "info.lbx")
+	password := "test_password_123"
+	lb := newQueryTestLockbox(t, tmpFile, password)
+	lb.Close()
+
+	// No password is needed for the cleartext metadata
+	info, err := ReadInfo(tmpFile)
+	if err != nil {
+		t.Fatalf("Failed to read info: %v", err)
+	}
+	if info.CreatedBy != "tester" {
+		t.Errorf("Expected creator tester, got %s", info.CreatedBy)
+	}
+	if info.Schema == nil || len(info.Schema.Fields()) != 3 {
+		t.Fatalf("Expected schema with 3 fields, got %v", info.Schema)
+	}
+	if info.RowCount != 3 {
+		t.Errorf("Expected 3 rows, got %d", info.RowCount)
+	}
+	if info.BlockCount != 3 || info.FileSize == 0 {
+		t.Errorf("Unexpected block count %d or file size %d", info.BlockCount, info.FileSize)
+	}
+
+	file, err := format.OpenMetadataOnly(tmpFile)
+	if err != nil {
+		t.Fatalf("Failed to open metadata: %v", err)
+	}
+	defer file.Close()
+	if _, err := file.NewReader(password); !errors.Is(err, format.ErrMetadataOnly) {
+		t.Fatalf("Expected ErrMetadataOnly, got %v", err)
+	}
+}
+
 func TestQuery(t *testing.T) {
 	schema := arrow.NewSchema([]arrow.Field{
 		{Name: "id", Type: arrow.PrimitiveTypes.Int64, Nullable: false},
@@ -319,3 +358,241 @@ func TestQueryAggregate(t *testing.T) {
 		t.Fatalf("unexpected avg %f", avg)
 	}
 }
+
+// newQueryTestLockbox creates a lockbox with three scored rows and reopens it
+func newQueryTestLockbox(t *testing.T, path, password string) *Lockbox {
+	t.Helper()
+
+	schema := arrow.NewSchema([]arrow.Field{
+		{Name: "id", Type: arrow.PrimitiveTypes.Int64, Nullable: false},
+		{Name: "name", Type: arrow.BinaryTypes.String, Nullable: true},
+		{Name: "score", Type: arrow.PrimitiveTypes.Float64, Nullable: true},
+	}, nil)
+
+	lb, err := Create(path, schema, WithPassword(password), WithCreatedBy("tester"))
+	if err != nil {
+		t.Fatalf("Failed to create lockbox: %v", err)
+	}
+
+	mem := memory.NewGoAllocator()
+	idb := array.NewInt64Builder(mem)
+	nameb := array.NewStringBuilder(mem)
+	scoreb := array.NewFloat64Builder(mem)
+	defer idb.Release()
+	defer nameb.Release()
+	defer scoreb.Release()
+
+	idb.AppendValues([]int64{1, 2, 3}, nil)
+	nameb.AppendValues([]string{"alice", "bob", "carol"}, nil)
+	scoreb.AppendValues([]float64{10, 55, 30}, nil)
+
+	idArr := idb.NewArray()
+	nameArr := nameb.NewArray()
+	scoreArr := scoreb.NewArray()
+	record := array.NewRecord(schema, []arrow.Array{idArr, nameArr, scoreArr}, 3)
+	idArr.Release()
+	nameArr.Release()
+	scoreArr.Release()
+
+	if err := lb.Write(context.Background(), record, WithPassword(password)); err != nil {
+		t.Fatalf("write error: %v", err)
+	}
+	lb.Close()
+
+	lb2, err := Open(path, WithPassword(password))
+	if err != nil {
+		t.Fatalf("open error: %v", err)
+	}
+	return lb2
+}
+
+func TestQueryAliasesAndOrder(t *testing.T) {
+	tmpFile := "/tmp/test_lockbox_query_alias.lbx"
+	defer os.Remove(tmpFile)
+
+	password := "test_password_123"
+	lb := newQueryTestLockbox(t, tmpFile, password)
+	defer lb.Close()
+
+	ctx := context.Background()
+	res, err := lb.Query(ctx, "SELECT score, name AS customer FROM data ORDER BY customer DESC", WithPassword(password))
+	if err != nil {
+		t.Fatalf("query error: %v", err)
+	}
+	defer res.Release()
+
+	if got := res.Schema().Field(0).Name; got != "score" {
+		t.Fatalf("expected first column score, got %s", got)
+	}
+	if got := res.Schema().Field(1).Name; got != "customer" {
+		t.Fatalf("expected alias customer, got %s", got)
+	}
+	if name := res.Column(1).(*array.String).Value(0); name != "carol" {
+		t.Fatalf("expected carol first, got %s", name)
+	}
+
+	agg, err := lb.Query(ctx, "SELECT COUNT(*) AS total FROM data", WithPassword(password))
+	if err != nil {
+		t.Fatalf("aggregate query error: %v", err)
+	}
+	defer agg.Release()
+	if got := agg.Schema().Field(0).Name; got != "total" {
+		t.Fatalf("expected aggregate alias total, got %s", got)
+	}
+}
+
+func TestWriteKeepsCallerRecord(t *testing.T) {
+	mem := memory.NewCheckedAllocator(memory.NewGoAllocator())
+	defer mem.AssertSize(t, 0)
+
+	schema := arrow.NewSchema([]arrow.Field{
+		{Name: "id", Type: arrow.PrimitiveTypes.Int64},
+		{Name: "city", Type: arrow.BinaryTypes.String, Nullable: true},
+	}, nil)
+	b := array.NewRecordBuilder(mem, schema)
+	b.Field(0).(*array.Int64Builder).AppendValues([]int64{1, 2, 3}, nil)
+	b.Field(1).(*array.StringBuilder).AppendValues([]string{"Berlin", "Paris", "Berlin"}, nil)
+	rec := b.NewRecord()
+	b.Release()
+
+	password := "test_password_123"
+	lb, err := Create(filepath.Join(t.TempDir(), "reuse.lbx"), schema, WithPassword(password), WithCreatedBy("test"))
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	defer lb.Close()
+
+	// The same batch can be written repeatedly, also dictionary encoded
+	ctx := context.Background()
+	for i := 0; i < 3; i++ {
+		if err := lb.Write(ctx, rec, WithPassword(password), WithDictionary("city")); err != nil {
+			t.Fatalf("write %d: %v", i, err)
+		}
+		if rec.Column(0).(*array.Int64).Value(2) != 3 {
+			t.Fatalf("record changed after write %d", i)
+		}
+	}
+	if got := lb.file.Metadata().RowCount(); got != 9 {
+		t.Fatalf("expected 9 rows, got %d", got)
+	}
+	rec.Release()
+}
+
+func TestReplaceColumn(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "replace.lbx")
+	password := "test_password_123"
+	lb := newQueryTestLockbox(t, path, password)
+	nameBlock := lb.file.Metadata().BlockInfo[1]
+
+	mem := memory.NewGoAllocator()
+	b := array.NewFloat64Builder(mem)
+	b.AppendValues([]float64{1, 2, 3}, nil)
+	scores := b.NewArray()
+	b.Release()
+	defer scores.Release()
+
+	ctx := context.Background()
+	if err := lb.ReplaceColumn(ctx, "score", scores, WithPassword(password), WithMessage("rescore")); err != nil {
+		t.Fatalf("replace column: %v", err)
+	}
+	short := array.NewSlice(scores, 0, 2)
+	defer short.Release()
+	if err := lb.ReplaceColumn(ctx, "score", short, WithPassword(password)); err == nil {
+		t.Fatal("expected a column with too few values to be rejected")
+	}
+	if err := lb.ReplaceColumn(ctx, "name", scores, WithPassword(password)); err == nil {
+		t.Fatal("expected a column of the wrong type to be rejected")
+	}
+	lb.Close()
+
+	lb, err := Open(path, WithPassword(password))
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	defer lb.Close()
+
+	// The other columns keep their blocks
+	if got := lb.file.Metadata().BlockInfo[1]; got.Offset != nameBlock.Offset {
+		t.Fatalf("expected the name block to stay at %d, got %d", nameBlock.Offset, got.Offset)
+	}
+	res, err := lb.Query(ctx, "SELECT name, score FROM data", WithPassword(password))
+	if err != nil {
+		t.Fatalf("query: %v", err)
+	}
+	defer res.Release()
+	names, got := res.Column(0).(*array.String), res.Column(1).(*array.Float64)
+	for i, want := range []float64{1, 2, 3} {
+		if got.Value(i) != want {
+			t.Fatalf("row %d: expected score %v, got %v", i, want, got.Value(i))
+		}
+	}
+	if names.Value(0) != "alice" || names.Value(2) != "carol" {
+		t.Fatalf("names changed: %v", names)
+	}
+
+	snaps := lb.Snapshots()
+	last := snaps[len(snaps)-1]
+	if last.Operation != OperationReplaceColumn || last.Message != "rescore" || last.RowsAdded != 0 || last.TotalRows != 3 {
+		t.Fatalf("unexpected snapshot %+v", last)
+	}
+}
+
+func TestCreateFileMode(t *testing.T) {
+	schema := arrow.NewSchema([]arrow.Field{{Name: "id", Type: arrow.PrimitiveTypes.Int64}}, nil)
+	dir := t.TempDir()
+	for _, tc := range []struct {
+		opts    []Option
+		mode    os.FileMode
+		exposed bool
+	}{
+		{nil, DefaultFileMode, false},
+		{[]Option{WithFileMode(0640)}, 0640, false},
+		{[]Option{WithFileMode(0644), WithOwner(-1, -1)}, 0644, true},
+	} {
+		path := filepath.Join(dir, fmt.Sprintf("mode-%o.lbx", tc.mode))
+		opts := append([]Option{WithPassword("test_password_123")}, tc.opts...)
+		lb, err := Create(path, schema, opts...)
+		if err != nil {
+			t.Fatalf("create: %v", err)
+		}
+		lb.Close()
+		fi, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fi.Mode().Perm() != tc.mode {
+			t.Fatalf("expected mode %v, got %v", tc.mode, fi.Mode().Perm())
+		}
+		if exposed, _ := WorldReadable(path); exposed != tc.exposed {
+			t.Fatalf("mode %v: expected world-readable %v", tc.mode, tc.exposed)
+		}
+	}
+}
+
+func TestExpiredContext(t *testing.T) {
+	tmpFile := "/tmp/test_lockbox_deadline.lbx"
+	defer os.Remove(tmpFile)
+
+	password := "test_password_123"
+	lb := newQueryTestLockbox(t, tmpFile, password)
+	defer lb.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Nanosecond)
+	defer cancel()
+	<-ctx.Done()
+
+	if _, err := lb.Query(ctx, "SELECT id FROM data", WithPassword(password)); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("query: expected a deadline error, got %v", err)
+	}
+	if _, err := lb.Read(ctx, WithPassword(password)); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("read: expected a deadline error, got %v", err)
+	}
+	rec, err := lb.Read(context.Background(), WithPassword(password))
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	defer rec.Release()
+	if err := lb.Write(ctx, rec, WithPassword(password)); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("write: expected a deadline error, got %v", err)
+	}
+}
@@ -0,0 +1,361 @@
+// Package lockboxtest provides helpers for tests that use lockboxes: temporary
+// lockboxes, deterministic schemas and records, and record assertions.
+package lockboxtest
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/TFMV/lockbox/pkg/lockbox"
+	"github.com/apache/arrow-go/v18/arrow"
+	"github.com/apache/arrow-go/v18/arrow/array"
+	"github.com/apache/arrow-go/v18/arrow/memory"
+)
+
+// Password is the password of lockboxes created by New
+const Password = "lockboxtest-password"
+
+// epoch is the base of generated timestamps and dates
+var epoch = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// New creates a lockbox with schema in a temporary directory, opened with
+// Password, writes the given records to it and closes it when the test
+// ends. Records stay owned by the caller.
+func New(t testing.TB, schema *arrow.Schema, records ...arrow.Record) *lockbox.Lockbox {
+	t.Helper()
+	return NewWithOptions(t, schema, nil, records...)
+}
+
+// NewWithOptions is New with extra options for Create and Write. They are
+// applied after the defaults, so WithPassword replaces Password.
+func NewWithOptions(t testing.TB, schema *arrow.Schema, opts []lockbox.Option, records ...arrow.Record) *lockbox.Lockbox {
+	t.Helper()
+
+	all := append([]lockbox.Option{lockbox.WithPassword(Password), lockbox.WithCreatedBy("lockboxtest")}, opts...)
+	lb, err := lockbox.Create(Path(t), schema, all...)
+	if err != nil {
+		t.Fatalf("lockboxtest: create: %v", err)
+	}
+	t.Cleanup(func() { lb.Close() })
+
+	for i, rec := range records {
+		if err := lb.Write(context.Background(), rec, all...); err != nil {
+			t.Fatalf("lockboxtest: write record %d: %v", i, err)
+		}
+	}
+	return lb
+}
+
+// Path returns the path of a new lockbox file in a temporary directory that
+// is removed when the test ends
+func Path(t testing.TB) string {
+	t.Helper()
+	return filepath.Join(t.TempDir(), "test.lbx")
+}
+
+// Reopen closes lb and opens the same file again with Password
+func Reopen(t testing.TB, lb *lockbox.Lockbox) *lockbox.Lockbox {
+	t.Helper()
+	path := lb.Path()
+	lb.Close()
+	reopened, err := lockbox.Open(path, lockbox.WithPassword(Password))
+	if err != nil {
+		t.Fatalf("lockboxtest: reopen: %v", err)
+	}
+	t.Cleanup(func() { reopened.Close() })
+	return reopened
+}
+
+// Schema returns a schema with one nullable field per name/type pair, e.g.
+// Schema("id", arrow.PrimitiveTypes.Int64, "name", arrow.BinaryTypes.String)
+func Schema(pairs ...interface{}) *arrow.Schema {
+	if len(pairs)%2 != 0 {
+		panic("lockboxtest: Schema needs name/type pairs")
+	}
+	fields := make([]arrow.Field, 0, len(pairs)/2)
+	for i := 0; i < len(pairs); i += 2 {
+		name, ok := pairs[i].(string)
+		if !ok {
+			panic(fmt.Sprintf("lockboxtest: field name %v is not a string", pairs[i]))
+		}
+		dt, ok := pairs[i+1].(arrow.DataType)
+		if !ok {
+			panic(fmt.Sprintf("lockboxtest: type of %s is not an arrow.DataType", name))
+		}
+		fields = append(fields, arrow.Field{Name: name, Type: dt, Nullable: true})
+	}
+	return arrow.NewSchema(fields, nil)
+}
+
+// SampleSchema returns a schema covering the common column types
+func SampleSchema() *arrow.Schema {
+	return arrow.NewSchema([]arrow.Field{
+		{Name: "id", Type: arrow.PrimitiveTypes.Int64},
+		{Name: "name", Type: arrow.BinaryTypes.String, Nullable: true},
+		{Name: "score", Type: arrow.PrimitiveTypes.Float64, Nullable: true},
+		{Name: "active", Type: arrow.FixedWidthTypes.Boolean, Nullable: true},
+		{Name: "created", Type: &arrow.TimestampType{Unit: arrow.Microsecond, TimeZone: "UTC"}, Nullable: true},
+	}, nil)
+}
+
+// Records generates n rows for schema. The same seed always yields the same
+// values; nullable columns get a null in every seventh row.
+func Records(schema *arrow.Schema, n int, seed int64) arrow.Record {
+	rng := rand.New(rand.NewSource(seed))
+	mem := memory.NewGoAllocator()
+
+	cols := make([]arrow.Array, len(schema.Fields()))
+	for i, field := range schema.Fields() {
+		b := array.NewBuilder(mem, field.Type)
+		for row := 0; row < n; row++ {
+			if field.Nullable && (row+i)%7 == 6 {
+				b.AppendNull()
+				continue
+			}
+			appendValue(b, row, rng)
+		}
+		cols[i] = b.NewArray()
+		b.Release()
+	}
+	defer func() {
+		for _, c := range cols {
+			c.Release()
+		}
+	}()
+	return array.NewRecord(schema, cols, int64(n))
+}
+
+// appendValue appends a generated value for row to b
+func appendValue(b array.Builder, row int, rng *rand.Rand) {
+	switch bb := b.(type) {
+	case *array.Int8Builder:
+		bb.Append(int8(row))
+	case *array.Int16Builder:
+		bb.Append(int16(row))
+	case *array.Int32Builder:
+		bb.Append(int32(row))
+	case *array.Int64Builder:
+		bb.Append(int64(row))
+	case *array.Uint8Builder:
+		bb.Append(uint8(row))
+	case *array.Uint16Builder:
+		bb.Append(uint16(row))
+	case *array.Uint32Builder:
+		bb.Append(uint32(row))
+	case *array.Uint64Builder:
+		bb.Append(uint64(row))
+	case *array.Float32Builder:
+		bb.Append(float32(rng.Intn(10000)) / 100)
+	case *array.Float64Builder:
+		bb.Append(float64(rng.Intn(10000)) / 100)
+	case *array.BooleanBuilder:
+		bb.Append(rng.Intn(2) == 1)
+	case *array.StringBuilder:
+		bb.Append(fmt.Sprintf("%s-%d", words[rng.Intn(len(words))], row))
+	case *array.BinaryBuilder:
+		buf := make([]byte, 8)
+		rng.Read(buf)
+		bb.Append(buf)
+	case *array.TimestampBuilder:
+		ts, _ := arrow.TimestampFromTime(epoch.Add(time.Duration(row)*time.Hour), bb.Type().(*arrow.TimestampType).Unit)
+		bb.Append(ts)
+	case *array.Date32Builder:
+		bb.Append(arrow.Date32FromTime(epoch.AddDate(0, 0, row)))
+	default:
+		panic(fmt.Sprintf("lockboxtest: cannot generate values of type %s", b.Type()))
+	}
+}
+
+var words = []string{"alpha", "bravo", "charlie", "delta", "echo", "foxtrot", "golf", "hotel"}
+
+// Record builds a record from one Go slice per field, e.g.
+// Record(schema, []int64{1, 2}, []string{"a", "b"}). A nil element of a
+// []interface{} column is a null.
+func Record(schema *arrow.Schema, columns ...interface{}) arrow.Record {
+	if len(columns) != len(schema.Fields()) {
+		panic(fmt.Sprintf("lockboxtest: %d columns for %d fields", len(columns), len(schema.Fields())))
+	}
+	mem := memory.NewGoAllocator()
+
+	rows := -1
+	cols := make([]arrow.Array, len(columns))
+	for i, values := range columns {
+		b := array.NewBuilder(mem, schema.Field(i).Type)
+		n, err := appendSlice(b, values)
+		if err != nil {
+			panic(fmt.Sprintf("lockboxtest: column %s: %v", schema.Field(i).Name, err))
+		}
+		if rows >= 0 && n != rows {
+			panic(fmt.Sprintf("lockboxtest: column %s has %d values, expected %d", schema.Field(i).Name, n, rows))
+		}
+		rows = n
+		cols[i] = b.NewArray()
+		b.Release()
+	}
+	defer func() {
+		for _, c := range cols {
+			c.Release()
+		}
+	}()
+	if rows < 0 {
+		rows = 0
+	}
+	return array.NewRecord(schema, cols, int64(rows))
+}
+
+// appendSlice appends the elements of a Go slice to b
+func appendSlice(b array.Builder, values interface{}) (int, error) {
+	switch v := values.(type) {
+	case []int64:
+		bb, ok := b.(*array.Int64Builder)
+		if !ok {
+			return 0, fmt.Errorf("[]int64 does not match %s", b.Type())
+		}
+		bb.AppendValues(v, nil)
+		return len(v), nil
+	case []int32:
+		bb, ok := b.(*array.Int32Builder)
+		if !ok {
+			return 0, fmt.Errorf("[]int32 does not match %s", b.Type())
+		}
+		bb.AppendValues(v, nil)
+		return len(v), nil
+	case []float64:
+		bb, ok := b.(*array.Float64Builder)
+		if !ok {
+			return 0, fmt.Errorf("[]float64 does not match %s", b.Type())
+		}
+		bb.AppendValues(v, nil)
+		return len(v), nil
+	case []string:
+		bb, ok := b.(*array.StringBuilder)
+		if !ok {
+			return 0, fmt.Errorf("[]string does not match %s", b.Type())
+		}
+		bb.AppendValues(v, nil)
+		return len(v), nil
+	case []bool:
+		bb, ok := b.(*array.BooleanBuilder)
+		if !ok {
+			return 0, fmt.Errorf("[]bool does not match %s", b.Type())
+		}
+		bb.AppendValues(v, nil)
+		return len(v), nil
+	case [][]byte:
+		bb, ok := b.(*array.BinaryBuilder)
+		if !ok {
+			return 0, fmt.Errorf("[][]byte does not match %s", b.Type())
+		}
+		bb.AppendValues(v, nil)
+		return len(v), nil
+	case []time.Time:
+		for _, t := range v {
+			if err := appendTime(b, t); err != nil {
+				return 0, err
+			}
+		}
+		return len(v), nil
+	case []interface{}:
+		for _, e := range v {
+			if e == nil {
+				b.AppendNull()
+				continue
+			}
+			if _, err := appendSlice(b, sliceOf(e)); err != nil {
+				return 0, err
+			}
+		}
+		return len(v), nil
+	default:
+		return 0, fmt.Errorf("unsupported column slice %T", values)
+	}
+}
+
+// sliceOf wraps a single value in a slice of its type
+func sliceOf(v interface{}) interface{} {
+	switch e := v.(type) {
+	case int:
+		return []int64{int64(e)}
+	case int64:
+		return []int64{e}
+	case int32:
+		return []int32{e}
+	case float64:
+		return []float64{e}
+	case string:
+		return []string{e}
+	case bool:
+		return []bool{e}
+	case []byte:
+		return [][]byte{e}
+	case time.Time:
+		return []time.Time{e}
+	default:
+		return v
+	}
+}
+
+func appendTime(b array.Builder, t time.Time) error {
+	switch bb := b.(type) {
+	case *array.TimestampBuilder:
+		ts, err := arrow.TimestampFromTime(t, bb.Type().(*arrow.TimestampType).Unit)
+		if err != nil {
+			return err
+		}
+		bb.Append(ts)
+	case *array.Date32Builder:
+		bb.Append(arrow.Date32FromTime(t))
+	default:
+		return fmt.Errorf("time.Time does not match %s", b.Type())
+	}
+	return nil
+}
+
+// Read returns all stored data of lb, read with Password. The caller
+// releases the result.
+func Read(t testing.TB, lb *lockbox.Lockbox) arrow.Record {
+	t.Helper()
+	rec, err := lb.Read(context.Background(), lockbox.WithPassword(Password))
+	if err != nil {
+		t.Fatalf("lockboxtest: read: %v", err)
+	}
+	return rec
+}
+
+// Query runs query against lb with Password and fails the test on error.
+// The caller releases the result.
+func Query(t testing.TB, lb *lockbox.Lockbox, query string, opts ...lockbox.Option) arrow.Record {
+	t.Helper()
+	all := append([]lockbox.Option{lockbox.WithPassword(Password)}, opts...)
+	rec, err := lb.Query(context.Background(), query, all...)
+	if err != nil {
+		t.Fatalf("lockboxtest: query %q: %v", query, err)
+	}
+	return rec
+}
+
+// AssertRecordsEqual fails the test when got differs from want in schema,
+// row count or any value, using the semantics of lockbox.RecordsEqual
+func AssertRecordsEqual(t testing.TB, want, got arrow.Record) {
+	t.Helper()
+	if lockbox.RecordsEqual(want, got) {
+		return
+	}
+	if want.NumRows() != got.NumRows() {
+		t.Fatalf("lockboxtest: expected %d rows, got %d", want.NumRows(), got.NumRows())
+	}
+	diff, err := lockbox.DiffRecords(want, got, nil)
+	if err != nil {
+		t.Fatalf("lockboxtest: %v:\nwant %s\ngot  %s", err, want.Schema(), got.Schema())
+	}
+	if diff.Equal() {
+		t.Fatalf("lockboxtest: rows are equal but in a different order")
+	}
+	c := diff.Changes[0]
+	t.Fatalf("lockboxtest: records differ (%d missing, %d unexpected rows); first difference: %s row %v",
+		diff.Removed, diff.Added, c.Kind, c.Key)
+}
@@ -0,0 +1,40 @@
+package lockboxtest
+
+import (
+	"testing"
+
+	"github.com/apache/arrow-go/v18/arrow"
+)
+
+func TestRecordsAreDeterministic(t *testing.T) {
+	schema := SampleSchema()
+	a := Records(schema, 20, 42)
+	defer a.Release()
+	b := Records(schema, 20, 42)
+	defer b.Release()
+	AssertRecordsEqual(t, a, b)
+
+	if a.Column(1).NullN() == 0 {
+		t.Fatalf("expected nulls in nullable columns")
+	}
+	if a.Column(0).NullN() != 0 {
+		t.Fatalf("expected no nulls in non-nullable columns")
+	}
+}
+
+func TestNewAndQuery(t *testing.T) {
+	schema := Schema("id", arrow.PrimitiveTypes.Int64, "name", arrow.BinaryTypes.String)
+	rec := Record(schema, []int64{1, 2, 3}, []interface{}{"a", nil, "c"})
+	defer rec.Release()
+
+	lb := New(t, schema, rec)
+	lb = Reopen(t, lb)
+
+	all := Read(t, lb)
+	defer all.Release()
+	AssertRecordsEqual(t, rec, all)
+
+	got := Query(t, lb, "SELECT * FROM data")
+	defer got.Release()
+	AssertRecordsEqual(t, rec, got)
+}
@@ -0,0 +1,32 @@
+package lockbox
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"testing"
+)
+
+// TestMain runs the suite with the debug allocator so that a test which
+// leaves Arrow buffers unreleased fails even when it ignores Close's error
+func TestMain(m *testing.M) {
+	debugAllocatorByDefault = true
+	var (
+		mu    sync.Mutex
+		leaks []string
+	)
+	reportLeak = func(path, report string) {
+		mu.Lock()
+		defer mu.Unlock()
+		leaks = append(leaks, fmt.Sprintf("%s:\n%s", path, report))
+	}
+
+	code := m.Run()
+	for _, leak := range leaks {
+		fmt.Fprintf(os.Stderr, "unreleased Arrow buffers in %s\n", leak)
+	}
+	if code == 0 && len(leaks) > 0 {
+		code = 1
+	}
+	os.Exit(code)
+}
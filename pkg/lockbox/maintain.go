@@ -0,0 +1,262 @@
+package lockbox
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/TFMV/lockbox/pkg/metadata"
+	"github.com/rs/zerolog/log"
+)
+
+// ErrMaintenanceRunning is returned by Maintain when another run holds the
+// maintenance lock of the lockbox
+var ErrMaintenanceRunning = errors.New("maintenance already running")
+
+// MaintenanceReport describes the work done by one Maintain run
+type MaintenanceReport struct {
+	File             string        `json:"file"`
+	StartedAt        time.Time     `json:"startedAt"`
+	Duration         time.Duration `json:"duration"`
+	RekeyedBlocks    int           `json:"rekeyedBlocks"`
+	CompactedRows    int64         `json:"compactedRows"`
+	ExpiredSnapshots int           `json:"expiredSnapshots"`
+	ReclaimedBytes   int64         `json:"reclaimedBytes"`
+	RefreshedStats   int           `json:"refreshedStats"`
+	RefreshedViews   []string      `json:"refreshedViews,omitempty"`
+	ExportedAudit    int           `json:"exportedAudit"`
+	TrimmedAudit     int           `json:"trimmedAudit"`
+	Errors           []string      `json:"errors,omitempty"`
+}
+
+// WithAuditExport makes Maintain append new access log entries to
+// <dir>/<lockbox name>.audit.jsonl
+func WithAuditExport(dir string) Option {
+	return func(o *Options) {
+		o.AuditDir = dir
+	}
+}
+
+// WithAuditRetention makes Maintain drop access log entries older than d.
+// When audit export is enabled only exported entries are dropped.
+func WithAuditRetention(d time.Duration) Option {
+	return func(o *Options) {
+		o.AuditRetention = d
+	}
+}
+
+// Maintain runs the periodic upkeep of a lockbox: blocks left on old keys by
+// lazy rotations are re-encrypted, deleted rows are compacted away,
+// snapshots are expired as WithKeepLast and WithKeepFor allow, blocks
+// written without stats get them, materialized views older than the last
+// write are refreshed, and the access log is exported and trimmed as
+// configured. Snapshots are only expired when a retention is given.
+//
+// A run holds an advisory lock on <file>.lock; when another run holds it
+// Maintain returns ErrMaintenanceRunning without doing anything. A failing
+// task does not stop the others; their errors are listed in the report and
+// joined in the returned error. Metadata is only rewritten when something
+// changed.
+func (lb *Lockbox) Maintain(ctx context.Context, opts ...Option) (*MaintenanceReport, error) {
+	options := &Options{}
+	for _, opt := range opts {
+		opt(options)
+	}
+	if options.Password == "" {
+		return nil, fmt.Errorf("password is required for maintenance")
+	}
+
+	unlock, err := lockMaintenance(lb.file.Path())
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	report := &MaintenanceReport{File: lb.file.Path(), StartedAt: time.Now()}
+	var errs []error
+	fail := func(task string, err error) {
+		err = fmt.Errorf("%s: %w", task, err)
+		errs = append(errs, err)
+		report.Errors = append(report.Errors, err.Error())
+	}
+
+	n, err := lb.CompactKeys(ctx, opts...)
+	report.RekeyedBlocks = n
+	if err != nil {
+		fail("rekey", err)
+	}
+
+	if ctx.Err() == nil {
+		n, err := lb.Compact(ctx, opts...)
+		report.CompactedRows = n
+		if err != nil {
+			fail("compact", err)
+		}
+	}
+
+	if (options.KeepLast > 0 || options.KeepFor > 0) && ctx.Err() == nil {
+		gc, err := lb.GC(ctx, opts...)
+		if err != nil {
+			fail("expire snapshots", err)
+		} else {
+			report.ExpiredSnapshots = len(gc.ExpiredSnapshots)
+			report.ReclaimedBytes = gc.ReclaimedBytes
+		}
+	}
+
+	changed := false
+	if ctx.Err() == nil {
+		n, err := lb.refreshStats(ctx, options.Password)
+		report.RefreshedStats = n
+		if err != nil {
+			fail("refresh stats", err)
+		}
+		changed = n > 0
+	}
+
+	if ctx.Err() == nil {
+		views := lb.staleViews()
+		if len(views) > 0 {
+			if err := lb.RefreshViews(ctx, views, opts...); err != nil {
+				fail("refresh views", err)
+			} else {
+				report.RefreshedViews = views
+			}
+		}
+	}
+
+	meta := lb.file.Metadata()
+	if options.AuditDir != "" && ctx.Err() == nil {
+		n, err := exportAudit(meta, lb.file.Path(), options.AuditDir)
+		report.ExportedAudit = n
+		if err != nil {
+			fail("audit export", err)
+		}
+		changed = changed || n > 0
+	}
+	if options.AuditRetention > 0 && ctx.Err() == nil {
+		n := trimAudit(meta, time.Now().Add(-options.AuditRetention), options.AuditDir != "")
+		report.TrimmedAudit = n
+		changed = changed || n > 0
+	}
+	if changed {
+		if err := lb.file.SaveMetadata(); err != nil {
+			fail("save metadata", err)
+		}
+	}
+
+	if err := ctx.Err(); err != nil {
+		fail("maintenance", err)
+	}
+	report.Duration = time.Since(report.StartedAt)
+	log.Debug().
+		Str("file", report.File).
+		Int("rekeyed", report.RekeyedBlocks).
+		Int64("compacted", report.CompactedRows).
+		Int("expired", report.ExpiredSnapshots).
+		Int("stats", report.RefreshedStats).
+		Int("views", len(report.RefreshedViews)).
+		Int("exported", report.ExportedAudit).
+		Int("trimmed", report.TrimmedAudit).
+		Msg("Maintained lockbox")
+	return report, errors.Join(errs...)
+}
+
+// refreshStats computes the stats of blocks written without them
+func (lb *Lockbox) refreshStats(ctx context.Context, password string) (int, error) {
+	missing := false
+	for _, bi := range lb.file.Metadata().BlockInfo {
+		if bi.Stats == nil {
+			missing = true
+			break
+		}
+	}
+	if !missing {
+		return 0, nil
+	}
+	writer, err := lb.file.NewWriter(password)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create writer: %w", err)
+	}
+	return writer.RefreshStats(ctx)
+}
+
+// staleViews returns the materialized views refreshed before the last write
+func (lb *Lockbox) staleViews() []string {
+	meta := lb.file.Metadata()
+	var names []string
+	for _, v := range meta.Views {
+		if v.Materialized && v.RefreshedAt.Before(meta.AuditTrail.ModifiedAt) {
+			names = append(names, v.Name)
+		}
+	}
+	return names
+}
+
+// exportAudit appends the access entries logged after the last export to
+// the lockbox's audit file in dir, one JSON object per line. Entries are
+// tracked by sequence number, so entries logged with the same or an older
+// timestamp, as clocks allow, are not skipped.
+func exportAudit(meta *metadata.Metadata, path, dir string) (int, error) {
+	meta.AuditTrail.Number()
+	var pending []metadata.AccessEntry
+	for _, e := range meta.AuditTrail.AccessLog {
+		if e.Seq > meta.AuditTrail.ExportedSeq {
+			pending = append(pending, e)
+		}
+	}
+	if len(pending) == 0 {
+		return 0, nil
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return 0, fmt.Errorf("failed to create audit directory: %w", err)
+	}
+	name := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path)) + ".audit.jsonl"
+	f, err := os.OpenFile(filepath.Join(dir, name), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return 0, fmt.Errorf("failed to open audit file: %w", err)
+	}
+	defer f.Close()
+
+	enc := json.NewEncoder(f)
+	for _, e := range pending {
+		if err := enc.Encode(e); err != nil {
+			return 0, fmt.Errorf("failed to write audit entry: %w", err)
+		}
+	}
+	if err := f.Sync(); err != nil {
+		return 0, fmt.Errorf("failed to sync audit file: %w", err)
+	}
+
+	last := pending[len(pending)-1]
+	meta.AuditTrail.ExportedSeq = last.Seq
+	meta.AuditTrail.ExportedThrough = last.Timestamp
+	return len(pending), nil
+}
+
+// trimAudit drops access entries older than cutoff, keeping entries that
+// were not exported yet when exportedOnly is set
+func trimAudit(meta *metadata.Metadata, cutoff time.Time, exportedOnly bool) int {
+	meta.AuditTrail.Number()
+	kept := meta.AuditTrail.AccessLog[:0]
+	trimmed := 0
+	for _, e := range meta.AuditTrail.AccessLog {
+		expired := e.Timestamp.Before(cutoff)
+		if expired && exportedOnly && e.Seq > meta.AuditTrail.ExportedSeq {
+			expired = false
+		}
+		if expired {
+			trimmed++
+			continue
+		}
+		kept = append(kept, e)
+	}
+	meta.AuditTrail.AccessLog = kept
+	return trimmed
+}
@@ -0,0 +1,8 @@
+//go:build !(linux || darwin || freebsd || netbsd || openbsd)
+
+package lockbox
+
+// lockMaintenance does not lock on platforms without flock
+func lockMaintenance(path string) (func(), error) {
+	return func() {}, nil
+}
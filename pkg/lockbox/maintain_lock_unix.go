@@ -0,0 +1,28 @@
+//go:build linux || darwin || freebsd || netbsd || openbsd
+
+package lockbox
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// lockMaintenance takes an exclusive advisory lock on the lock file of the
+// lockbox at path. The lock file is left in place, as removing it would let
+// a waiting run lock a file nobody else sees.
+func lockMaintenance(path string) (func(), error) {
+	f, err := os.OpenFile(path+".lock", os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open maintenance lock: %w", err)
+	}
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		f.Close()
+		if errors.Is(err, syscall.EWOULDBLOCK) {
+			return nil, ErrMaintenanceRunning
+		}
+		return nil, fmt.Errorf("failed to lock maintenance: %w", err)
+	}
+	return func() { f.Close() }, nil
+}
@@ -0,0 +1,30 @@
+//go:build linux || darwin || freebsd || netbsd || openbsd
+
+package lockbox
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+func TestMaintainLock(t *testing.T) {
+	tmpFile := filepath.Join(t.TempDir(), "locked.lbx")
+	password := "test_password_123"
+	lb := newQueryTestLockbox(t, tmpFile, password)
+	defer lb.Close()
+
+	unlock, err := lockMaintenance(tmpFile)
+	if err != nil {
+		t.Fatalf("lock: %v", err)
+	}
+	ctx := context.Background()
+	if _, err := lb.Maintain(ctx, WithPassword(password)); !errors.Is(err, ErrMaintenanceRunning) {
+		t.Fatalf("expected ErrMaintenanceRunning, got %v", err)
+	}
+	unlock()
+	if _, err := lb.Maintain(ctx, WithPassword(password)); err != nil {
+		t.Fatalf("maintain after unlock: %v", err)
+	}
+}
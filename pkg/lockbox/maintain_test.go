@@ -0,0 +1,189 @@
+package lockbox
+
+import (
+	"bufio"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/TFMV/lockbox/pkg/metadata"
+	"github.com/apache/arrow-go/v18/arrow/array"
+)
+
+func TestMaintain(t *testing.T) {
+	dir := t.TempDir()
+	tmpFile := filepath.Join(dir, "maintain.lbx")
+	auditDir := filepath.Join(dir, "audit")
+
+	password := "test_password_123"
+	lb := newQueryTestLockbox(t, tmpFile, password)
+	defer lb.Close()
+
+	if _, err := lb.RotateColumnKey("score", WithPassword(password), WithLazyRotation()); err != nil {
+		t.Fatalf("rotate: %v", err)
+	}
+	if err := lb.CreateView("totals", "SELECT COUNT(*) FROM data", WithMaterialized(true), WithPassword(password)); err != nil {
+		t.Fatalf("create view: %v", err)
+	}
+	// Pretend the data changed after the view was refreshed
+	view, _ := lb.file.Metadata().FindView("totals")
+	lb.file.Metadata().AuditTrail.ModifiedAt = view.RefreshedAt.Add(time.Nanosecond)
+
+	ctx := context.Background()
+	report, err := lb.Maintain(ctx, WithPassword(password), WithAuditExport(auditDir), WithAuditRetention(time.Nanosecond))
+	if err != nil {
+		t.Fatalf("maintain: %v", err)
+	}
+	if report.RekeyedBlocks != 1 {
+		t.Fatalf("expected 1 re-encrypted block, got %d", report.RekeyedBlocks)
+	}
+	if len(report.RefreshedViews) != 1 || report.RefreshedViews[0] != "totals" {
+		t.Fatalf("expected view totals to be refreshed, got %v", report.RefreshedViews)
+	}
+	if report.ExportedAudit == 0 || report.TrimmedAudit != report.ExportedAudit {
+		t.Fatalf("expected exported entries to be trimmed: %+v", report)
+	}
+
+	f, err := os.Open(filepath.Join(auditDir, "maintain.audit.jsonl"))
+	if err != nil {
+		t.Fatalf("open audit export: %v", err)
+	}
+	defer f.Close()
+	lines := 0
+	for sc := bufio.NewScanner(f); sc.Scan(); {
+		lines++
+	}
+	if lines != report.ExportedAudit {
+		t.Fatalf("expected %d exported lines, got %d", report.ExportedAudit, lines)
+	}
+
+	// Nothing is left to do on a second run
+	report, err = lb.Maintain(ctx, WithPassword(password), WithAuditExport(auditDir))
+	if err != nil {
+		t.Fatalf("second maintain: %v", err)
+	}
+	if report.RekeyedBlocks != 0 || len(report.RefreshedViews) != 0 || report.ExportedAudit != 0 {
+		t.Fatalf("expected an idle second run: %+v", report)
+	}
+
+	if _, err := lb.Maintain(ctx); err == nil {
+		t.Fatalf("expected maintenance without a password to fail")
+	}
+}
+
+func TestMaintainCompactsAndRefreshesStats(t *testing.T) {
+	dir := t.TempDir()
+	tmpFile := filepath.Join(dir, "compact.lbx")
+
+	password := "test_password_123"
+	lb := newQueryTestLockbox(t, tmpFile, password)
+	defer lb.Close()
+
+	ctx := context.Background()
+	if n, err := lb.Delete(ctx, "id = 2", WithPassword(password)); err != nil || n != 1 {
+		t.Fatalf("delete: %d, %v", n, err)
+	}
+	report, err := lb.Maintain(ctx, WithPassword(password), WithKeepLast(1))
+	if err != nil {
+		t.Fatalf("maintain: %v", err)
+	}
+	if report.CompactedRows != 1 {
+		t.Fatalf("expected 1 compacted row, got %d", report.CompactedRows)
+	}
+	if report.ExpiredSnapshots == 0 || len(lb.file.Metadata().Snapshots) != 1 {
+		t.Fatalf("expected all but the newest snapshot to expire: %+v", report)
+	}
+
+	// Pretend the blocks were written before block stats
+	meta := lb.file.Metadata()
+	for i := range meta.BlockInfo {
+		meta.BlockInfo[i].Stats = nil
+	}
+	meta.Rollup = nil
+	report, err = lb.Maintain(ctx, WithPassword(password))
+	if err != nil {
+		t.Fatalf("second maintain: %v", err)
+	}
+	if report.RefreshedStats != len(meta.BlockInfo) {
+		t.Fatalf("expected block stats to be refreshed: %+v", report)
+	}
+	for _, bi := range lb.file.Metadata().BlockInfo {
+		if bi.Stats == nil {
+			t.Fatalf("block of column %s still has no stats", bi.ColumnName)
+		}
+	}
+	if c := lb.file.Metadata().Rollup.Columns["id"]; c == nil || c.Partial || c.Rows != 2 {
+		t.Fatalf("expected a complete rollup of 2 rows, got %+v", c)
+	}
+
+	result, err := lb.Query(ctx, "SELECT COUNT(*) FROM data", WithPassword(password))
+	if err != nil {
+		t.Fatalf("query: %v", err)
+	}
+	defer result.Release()
+	if got := result.Column(0).(*array.Int64).Value(0); got != 2 {
+		t.Fatalf("expected 2 rows after maintenance, got %d", got)
+	}
+}
+
+func TestMaintainExportsBySequence(t *testing.T) {
+	dir := t.TempDir()
+	tmpFile := filepath.Join(dir, "seq.lbx")
+	auditDir := filepath.Join(dir, "audit")
+
+	password := "test_password_123"
+	lb := newQueryTestLockbox(t, tmpFile, password)
+	defer lb.Close()
+
+	ctx := context.Background()
+	first, err := lb.Maintain(ctx, WithPassword(password), WithAuditExport(auditDir))
+	if err != nil {
+		t.Fatalf("maintain: %v", err)
+	}
+
+	// An entry logged with a clock behind the last export is still exported
+	trail := &lb.file.Metadata().AuditTrail
+	trail.Append(metadata.AccessEntry{Timestamp: trail.ExportedThrough.Add(-time.Hour), Principal: "late", Action: "read"})
+	report, err := lb.Maintain(ctx, WithPassword(password), WithAuditExport(auditDir))
+	if err != nil {
+		t.Fatalf("second maintain: %v", err)
+	}
+	if report.ExportedAudit != 1 {
+		t.Fatalf("expected the late entry to be exported, got %d", report.ExportedAudit)
+	}
+	if trail.ExportedSeq != trail.LastSeq {
+		t.Fatalf("expected export through sequence %d, got %d", trail.LastSeq, trail.ExportedSeq)
+	}
+
+	data, err := os.ReadFile(filepath.Join(auditDir, "seq.audit.jsonl"))
+	if err != nil {
+		t.Fatalf("read audit export: %v", err)
+	}
+	if lines := strings.Count(string(data), "\n"); lines != first.ExportedAudit+1 {
+		t.Fatalf("expected %d exported lines, got %d", first.ExportedAudit+1, lines)
+	}
+}
+
+func TestAuditTrailNumbersLegacyEntries(t *testing.T) {
+	now := time.Now()
+	trail := metadata.AuditTrail{
+		AccessLog: []metadata.AccessEntry{
+			{Timestamp: now.Add(-2 * time.Hour)},
+			{Timestamp: now.Add(-time.Hour)},
+			{Timestamp: now},
+		},
+		ExportedThrough: now.Add(-time.Hour),
+	}
+	trail.Append(metadata.AccessEntry{Timestamp: now})
+	for i, e := range trail.AccessLog {
+		if e.Seq != int64(i+1) {
+			t.Fatalf("entry %d has sequence %d", i, e.Seq)
+		}
+	}
+	if trail.LastSeq != 4 || trail.ExportedSeq != 2 {
+		t.Fatalf("expected last 4 and exported 2, got %d and %d", trail.LastSeq, trail.ExportedSeq)
+	}
+}
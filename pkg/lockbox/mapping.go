@@ -0,0 +1,187 @@
+package lockbox
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/apache/arrow-go/v18/arrow"
+	"gopkg.in/yaml.v3"
+)
+
+// mappingTransforms are the transforms a FieldMapping can apply to a value
+var mappingTransforms = map[string]func(string) string{
+	"trim":  strings.TrimSpace,
+	"lower": strings.ToLower,
+	"upper": strings.ToUpper,
+	"digits": func(s string) string {
+		return strings.Map(func(r rune) rune {
+			if unicode.IsDigit(r) {
+				return r
+			}
+			return -1
+		}, s)
+	},
+}
+
+// ColumnMapping maps the columns of a source file to lockbox fields for
+// LoadCSV and LoadJSON. Fields not listed are taken from the source column
+// of the same name. In YAML:
+//
+//	columns:
+//	  customer_id:
+//	    source: CustID
+//	    transform: [trim, digits]
+//	  country:
+//	    default: US
+type ColumnMapping struct {
+	Columns map[string]FieldMapping `yaml:"columns" json:"columns"`
+}
+
+// FieldMapping says where a lockbox field comes from
+type FieldMapping struct {
+	// Source is the source column; the field name by default
+	Source string `yaml:"source,omitempty" json:"source,omitempty"`
+	// Default is used when the source column is absent or a value is empty.
+	// A field whose source is absent from the input is set to it throughout.
+	Default *string `yaml:"default,omitempty" json:"default,omitempty"`
+	// Transform lists transforms applied in order: trim, lower, upper and
+	// digits, which keeps only digits
+	Transform Transforms `yaml:"transform,omitempty" json:"transform,omitempty"`
+}
+
+// Transforms is a list of transform names. In YAML a single name may be
+// given without a list.
+type Transforms []string
+
+// UnmarshalYAML implements yaml.Unmarshaler
+func (t *Transforms) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		*t = Transforms{node.Value}
+		return nil
+	}
+	var list []string
+	if err := node.Decode(&list); err != nil {
+		return err
+	}
+	*t = list
+	return nil
+}
+
+// WithColumnMapping makes LoadCSV and LoadJSON fill fields as described by
+// m instead of matching source columns by position or name
+func WithColumnMapping(m *ColumnMapping) Option {
+	return func(o *Options) {
+		o.Mapping = m
+	}
+}
+
+// LoadColumnMapping reads a column mapping from a YAML or JSON file
+func LoadColumnMapping(path string) (*ColumnMapping, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read mapping file: %w", err)
+	}
+	m := &ColumnMapping{}
+	if err := yaml.Unmarshal(data, m); err != nil {
+		return nil, fmt.Errorf("failed to parse mapping file: %w", err)
+	}
+	return m, nil
+}
+
+// validate checks that the mapping refers to schema fields and known
+// transforms
+func (m *ColumnMapping) validate(schema *arrow.Schema) error {
+	names := make([]string, 0, len(m.Columns))
+	for name := range m.Columns {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if _, ok := schema.FieldsByName(name); !ok {
+			return fmt.Errorf("mapping for unknown field %s", name)
+		}
+		for _, t := range m.Columns[name].Transform {
+			if _, ok := mappingTransforms[t]; !ok {
+				return fmt.Errorf("field %s: unknown transform %s", name, t)
+			}
+		}
+	}
+	return nil
+}
+
+// field returns the mapping for a field with the source defaulted
+func (m *ColumnMapping) field(name string) FieldMapping {
+	var fm FieldMapping
+	if m != nil {
+		fm = m.Columns[name]
+	}
+	if fm.Source == "" {
+		fm.Source = name
+	}
+	return fm
+}
+
+// apply fills in the default and runs the transforms
+func (fm FieldMapping) apply(v string, present bool) string {
+	if (!present || v == "") && fm.Default != nil {
+		v = *fm.Default
+	}
+	for _, t := range fm.Transform {
+		v = mappingTransforms[t](v)
+	}
+	return v
+}
+
+// csvMapper builds functions that pick each field's value from a CSV
+// record with the given header
+func (m *ColumnMapping) csvMapper(schema *arrow.Schema, header []string) ([]func([]string) string, error) {
+	if err := m.validate(schema); err != nil {
+		return nil, err
+	}
+	index := map[string]int{}
+	for i, h := range header {
+		if _, dup := index[h]; !dup {
+			index[h] = i
+		}
+	}
+	pick := make([]func([]string) string, schema.NumFields())
+	for i, field := range schema.Fields() {
+		fm := m.field(field.Name)
+		idx, ok := index[fm.Source]
+		if !ok && fm.Default == nil {
+			return nil, fmt.Errorf("source column %s for field %s not found", fm.Source, field.Name)
+		}
+		pick[i] = func(row []string) string {
+			if !ok || idx >= len(row) {
+				return fm.apply("", false)
+			}
+			return fm.apply(row[idx], true)
+		}
+	}
+	return pick, nil
+}
+
+// jsonValue picks a field's value from a JSON object. Defaults and
+// transforms apply to strings and missing values.
+func (fm FieldMapping) jsonValue(obj *jsonObject) json.RawMessage {
+	raw, ok := obj.values[fm.Source]
+	if ok && string(raw) == "null" {
+		ok = false
+	}
+	if ok && (len(raw) == 0 || raw[0] != '"') {
+		return raw
+	}
+	var s string
+	if ok {
+		json.Unmarshal(raw, &s)
+	}
+	if !ok && fm.Default == nil {
+		return nil
+	}
+	out, _ := json.Marshal(fm.apply(s, ok))
+	return out
+}
@@ -0,0 +1,83 @@
+package lockbox
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/apache/arrow-go/v18/arrow"
+	"github.com/apache/arrow-go/v18/arrow/array"
+)
+
+func TestColumnMapping(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	mapPath := write("mapping.yaml", `columns:
+  id:
+    source: CustID
+    transform: digits
+  name:
+    source: Full Name
+    transform: [trim, upper]
+  country:
+    default: US
+  score:
+    default: 0
+`)
+	m, err := LoadColumnMapping(mapPath)
+	if err != nil {
+		t.Fatalf("load mapping: %v", err)
+	}
+
+	schema := arrow.NewSchema([]arrow.Field{
+		{Name: "id", Type: arrow.PrimitiveTypes.Int64},
+		{Name: "name", Type: arrow.BinaryTypes.String},
+		{Name: "country", Type: arrow.BinaryTypes.String},
+		{Name: "score", Type: arrow.PrimitiveTypes.Int64},
+	}, nil)
+	check := func(rec arrow.Record, err error) {
+		t.Helper()
+		if err != nil {
+			t.Fatalf("load: %v", err)
+		}
+		defer rec.Release()
+		if rec.NumRows() != 2 {
+			t.Fatalf("expected 2 rows, got %d", rec.NumRows())
+		}
+		ids := rec.Column(0).(*array.Int64)
+		names := rec.Column(1).(*array.String)
+		countries := rec.Column(2).(*array.String)
+		scores := rec.Column(3).(*array.Int64)
+		if ids.Value(0) != 17 || names.Value(0) != "ADA" || countries.Value(0) != "US" || scores.Value(0) != 5 {
+			t.Fatalf("unexpected first row %v", rec)
+		}
+		if scores.Value(1) != 0 {
+			t.Fatalf("expected default score, got %d", scores.Value(1))
+		}
+	}
+
+	csvPath := write("src.csv", "Full Name,score,CustID,extra\n  ada ,5,C-17,x\nbob,,C-18,y\n")
+	check(LoadCSV(csvPath, schema, WithColumnMapping(m)))
+
+	jsonPath := write("src.ndjson", `{"CustID": "C-17", "Full Name": " ada", "score": 5}
+{"CustID": "C-18", "Full Name": "bob"}
+`)
+	check(LoadJSON(jsonPath, schema, WithColumnMapping(m)))
+
+	// Sources must exist unless there is a default
+	missing := write("missing.csv", "Name,CustID\nada,1\n")
+	if _, err := LoadCSV(missing, schema, WithColumnMapping(m)); err == nil || !strings.Contains(err.Error(), "Full Name") {
+		t.Fatalf("expected missing source error, got %v", err)
+	}
+	bad := &ColumnMapping{Columns: map[string]FieldMapping{"id": {Transform: Transforms{"reverse"}}}}
+	if _, err := LoadCSV(csvPath, schema, WithColumnMapping(bad)); err == nil {
+		t.Fatal("expected error for unknown transform")
+	}
+}
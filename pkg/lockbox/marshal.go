@@ -0,0 +1,539 @@
+package lockbox
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/apache/arrow-go/v18/arrow"
+	"github.com/apache/arrow-go/v18/arrow/array"
+)
+
+// WithSchema makes Marshal build records with schema, e.g. the schema of
+// the lockbox they are written to, instead of one derived from the struct
+func WithSchema(schema *arrow.Schema) Option {
+	return func(o *Options) {
+		o.Schema = schema
+	}
+}
+
+var (
+	timeType  = reflect.TypeOf(time.Time{})
+	bytesType = reflect.TypeOf([]byte(nil))
+)
+
+// structField is a field of a struct mapped to a column
+type structField struct {
+	name  string
+	index []int
+	typ   reflect.Type
+	// pointer fields hold nullable values
+	pointer bool
+}
+
+// structFields returns the columns of the exported fields of a struct
+// type. A field is named by its lockbox tag, e.g. `lockbox:"user_id"`, or
+// else by its Go name; a tag of "-" leaves it out. Fields of embedded
+// structs are promoted as columns of their own.
+func structFields(t reflect.Type) ([]structField, error) {
+	if t.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("%s is not a struct", t)
+	}
+	var fields []structField
+	seen := map[string]bool{}
+	var walk func(t reflect.Type, index []int) error
+	walk = func(t reflect.Type, index []int) error {
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			tag := f.Tag.Get("lockbox")
+			if tag == "-" {
+				continue
+			}
+			path := append(append([]int(nil), index...), i)
+			// Embedded structs promote their exported fields even when
+			// their type is unexported
+			if f.Anonymous && tag == "" && f.Type.Kind() == reflect.Struct && f.Type != timeType {
+				if err := walk(f.Type, path); err != nil {
+					return err
+				}
+				continue
+			}
+			if !f.IsExported() {
+				continue
+			}
+			name := tag
+			if name == "" {
+				name = f.Name
+			}
+			if seen[name] {
+				return fmt.Errorf("column %s is mapped twice in %s", name, t)
+			}
+			seen[name] = true
+			sf := structField{name: name, index: path, typ: f.Type}
+			if f.Type.Kind() == reflect.Pointer {
+				sf.typ, sf.pointer = f.Type.Elem(), true
+			}
+			fields = append(fields, sf)
+		}
+		return nil
+	}
+	if err := walk(t, nil); err != nil {
+		return nil, err
+	}
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("%s has no exported fields", t)
+	}
+	return fields, nil
+}
+
+// goArrowType returns the column type a Go type is stored as by default
+func goArrowType(t reflect.Type) (arrow.DataType, error) {
+	switch t {
+	case timeType:
+		return arrow.FixedWidthTypes.Timestamp_us, nil
+	case bytesType:
+		return arrow.BinaryTypes.Binary, nil
+	}
+	switch t.Kind() {
+	case reflect.Int, reflect.Int64:
+		return arrow.PrimitiveTypes.Int64, nil
+	case reflect.Int32:
+		return arrow.PrimitiveTypes.Int32, nil
+	case reflect.Int16:
+		return arrow.PrimitiveTypes.Int16, nil
+	case reflect.Int8:
+		return arrow.PrimitiveTypes.Int8, nil
+	case reflect.Uint, reflect.Uint64:
+		return arrow.PrimitiveTypes.Uint64, nil
+	case reflect.Uint32:
+		return arrow.PrimitiveTypes.Uint32, nil
+	case reflect.Uint16:
+		return arrow.PrimitiveTypes.Uint16, nil
+	case reflect.Uint8:
+		return arrow.PrimitiveTypes.Uint8, nil
+	case reflect.Float64:
+		return arrow.PrimitiveTypes.Float64, nil
+	case reflect.Float32:
+		return arrow.PrimitiveTypes.Float32, nil
+	case reflect.Bool:
+		return arrow.FixedWidthTypes.Boolean, nil
+	case reflect.String:
+		return arrow.BinaryTypes.String, nil
+	}
+	return nil, fmt.Errorf("unsupported Go type %s", t)
+}
+
+// SchemaOf returns the schema Marshal derives from the struct type T:
+// int64, int32, ... for integers, float64 and float32, boolean, utf8 for
+// strings, binary for []byte and timestamp[us] for time.Time. Pointer
+// fields are nullable.
+func SchemaOf[T any]() (*arrow.Schema, error) {
+	fields, err := structFields(reflect.TypeOf((*T)(nil)).Elem())
+	if err != nil {
+		return nil, err
+	}
+	return schemaOf(fields)
+}
+
+func schemaOf(fields []structField) (*arrow.Schema, error) {
+	out := make([]arrow.Field, len(fields))
+	for i, f := range fields {
+		typ, err := goArrowType(f.typ)
+		if err != nil {
+			return nil, fmt.Errorf("field %s: %w", f.name, err)
+		}
+		out[i] = arrow.Field{Name: f.name, Type: typ, Nullable: f.pointer}
+	}
+	return arrow.NewSchema(out, nil), nil
+}
+
+// intBits returns the width of an integer column type and whether it is
+// signed; zero for other types
+func intBits(t arrow.DataType) (int, bool) {
+	switch t.ID() {
+	case arrow.INT8:
+		return 8, true
+	case arrow.INT16:
+		return 16, true
+	case arrow.INT32:
+		return 32, true
+	case arrow.INT64:
+		return 64, true
+	case arrow.UINT8:
+		return 8, false
+	case arrow.UINT16:
+		return 16, false
+	case arrow.UINT32:
+		return 32, false
+	case arrow.UINT64:
+		return 64, false
+	}
+	return 0, false
+}
+
+// goIntBits is intBits for a Go integer kind
+func goIntBits(t reflect.Type) (int, bool) {
+	switch t.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return t.Bits(), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return t.Bits(), false
+	}
+	return 0, false
+}
+
+// intFits reports whether every integer of width bits and signedness
+// fits an integer of width toBits and toSigned
+func intFits(bits int, signed bool, toBits int, toSigned bool) bool {
+	switch {
+	case signed == toSigned:
+		return bits <= toBits
+	case toSigned:
+		return bits < toBits
+	}
+	return false
+}
+
+// fits reports whether values of a Go type and a column type convert to
+// one another; toColumn checks Go values written to the column, otherwise
+// column values read into Go
+func fits(t reflect.Type, dt arrow.DataType, toColumn bool) bool {
+	if bits, signed := intBits(dt); bits > 0 {
+		goBits, goSigned := goIntBits(t)
+		if goBits == 0 {
+			return false
+		}
+		if toColumn {
+			return intFits(goBits, goSigned, bits, signed)
+		}
+		return intFits(bits, signed, goBits, goSigned)
+	}
+	if _, ok := vectorDim(dt); ok {
+		return t == reflect.TypeOf([]float32(nil))
+	}
+	switch dt.ID() {
+	case arrow.FLOAT64:
+		return t.Kind() == reflect.Float64 || (toColumn && t.Kind() == reflect.Float32)
+	case arrow.FLOAT32:
+		return t.Kind() == reflect.Float32 || (!toColumn && t.Kind() == reflect.Float64)
+	case arrow.BOOL:
+		return t.Kind() == reflect.Bool
+	case arrow.STRING, arrow.LARGE_STRING:
+		return t.Kind() == reflect.String
+	case arrow.BINARY, arrow.LARGE_BINARY:
+		return t == bytesType || t.Kind() == reflect.String
+	case arrow.TIMESTAMP, arrow.DATE32, arrow.DATE64:
+		return t == timeType
+	case arrow.DICTIONARY:
+		return fits(t, dt.(*arrow.DictionaryType).ValueType, toColumn)
+	}
+	return false
+}
+
+// findField returns the struct field of a column, matching names exactly
+// or else case-insensitively
+func findField(fields []structField, name string) (structField, bool) {
+	for _, f := range fields {
+		if f.name == name {
+			return f, true
+		}
+	}
+	for _, f := range fields {
+		if strings.EqualFold(f.name, name) {
+			return f, true
+		}
+	}
+	return structField{}, false
+}
+
+// Marshal converts structs to a record, a column per field as described
+// by SchemaOf, so applications can Write Go values without building Arrow
+// arrays. With WithSchema the record has that schema instead: each column
+// is filled from the field of its name, which must hold values of the
+// column's type; columns without a field must be nullable, and every
+// field needs a column. Nil
+// pointers are null. The caller releases the record.
+func Marshal[T any](rows []T, opts ...Option) (arrow.Record, error) {
+	options := &Options{}
+	for _, opt := range opts {
+		opt(options)
+	}
+	fields, err := structFields(reflect.TypeOf((*T)(nil)).Elem())
+	if err != nil {
+		return nil, err
+	}
+	schema := options.Schema
+	if schema == nil {
+		if schema, err = schemaOf(fields); err != nil {
+			return nil, err
+		}
+	}
+
+	// The field of each column, nil for columns left null
+	columns := make([]*structField, schema.NumFields())
+	mapped := map[string]bool{}
+	for i, col := range schema.Fields() {
+		f, ok := findField(fields, col.Name)
+		if !ok {
+			if !col.Nullable {
+				return nil, fmt.Errorf("column %s is not nullable and has no field", col.Name)
+			}
+			continue
+		}
+		if !fits(f.typ, col.Type, true) {
+			return nil, fmt.Errorf("field %s of type %s cannot be stored in column %s of type %s", f.name, f.typ, col.Name, col.Type)
+		}
+		columns[i] = &f
+		mapped[f.name] = true
+	}
+	// A field without a column is most likely a misspelled tag
+	for _, f := range fields {
+		if !mapped[f.name] {
+			return nil, fmt.Errorf("field %s has no column", f.name)
+		}
+	}
+
+	b := array.NewRecordBuilder(loadAllocator(options), schema)
+	defer b.Release()
+	b.Reserve(len(rows))
+	for r := range rows {
+		v := reflect.ValueOf(&rows[r]).Elem()
+		for i, f := range columns {
+			col := schema.Field(i)
+			if f == nil {
+				b.Field(i).AppendNull()
+				continue
+			}
+			fv := v.FieldByIndex(f.index)
+			if f.pointer {
+				if fv.IsNil() {
+					if !col.Nullable {
+						return nil, fmt.Errorf("row %d: column %s is not nullable but %s is nil", r, col.Name, f.name)
+					}
+					b.Field(i).AppendNull()
+					continue
+				}
+				fv = fv.Elem()
+			}
+			if err := appendGoValue(b.Field(i), fv); err != nil {
+				return nil, fmt.Errorf("row %d, column %s: %w", r, col.Name, err)
+			}
+		}
+	}
+	return b.NewRecord(), nil
+}
+
+// appendGoValue appends a Go value to a builder of a type it fits
+func appendGoValue(b array.Builder, v reflect.Value) error {
+	switch b := b.(type) {
+	case *array.Int8Builder:
+		b.Append(int8(goInt(v)))
+	case *array.Int16Builder:
+		b.Append(int16(goInt(v)))
+	case *array.Int32Builder:
+		b.Append(int32(goInt(v)))
+	case *array.Int64Builder:
+		b.Append(goInt(v))
+	case *array.Uint8Builder:
+		b.Append(uint8(v.Uint()))
+	case *array.Uint16Builder:
+		b.Append(uint16(v.Uint()))
+	case *array.Uint32Builder:
+		b.Append(uint32(v.Uint()))
+	case *array.Uint64Builder:
+		b.Append(v.Uint())
+	case *array.Float32Builder:
+		b.Append(float32(v.Float()))
+	case *array.Float64Builder:
+		b.Append(v.Float())
+	case *array.BooleanBuilder:
+		b.Append(v.Bool())
+	case *array.StringBuilder:
+		b.Append(v.String())
+	case *array.LargeStringBuilder:
+		b.Append(v.String())
+	case *array.BinaryBuilder:
+		if v.Kind() == reflect.String {
+			b.AppendString(v.String())
+		} else {
+			b.Append(v.Bytes())
+		}
+	case *array.TimestampBuilder:
+		ts, err := arrow.TimestampFromTime(v.Interface().(time.Time), b.Type().(*arrow.TimestampType).Unit)
+		if err != nil {
+			return err
+		}
+		b.Append(ts)
+	case *array.Date32Builder:
+		b.Append(arrow.Date32FromTime(v.Interface().(time.Time)))
+	case *array.Date64Builder:
+		b.Append(arrow.Date64FromTime(v.Interface().(time.Time)))
+	case *array.FixedSizeListBuilder:
+		vec := v.Interface().([]float32)
+		if dim := int(b.Type().(*arrow.FixedSizeListType).Len()); len(vec) != dim {
+			return fmt.Errorf("vector has %d values, expected %d", len(vec), dim)
+		}
+		b.Append(true)
+		b.ValueBuilder().(*array.Float32Builder).AppendValues(vec, nil)
+	case *array.BinaryDictionaryBuilder:
+		if v.Kind() == reflect.String {
+			return b.AppendString(v.String())
+		}
+		return b.Append(v.Bytes())
+	default:
+		return fmt.Errorf("unsupported column type %s", b.Type())
+	}
+	return nil
+}
+
+// goInt returns a Go integer, signed or not, as int64; fits has checked
+// that it is in range
+func goInt(v reflect.Value) int64 {
+	if v.CanInt() {
+		return v.Int()
+	}
+	return int64(v.Uint())
+}
+
+// Unmarshal appends the rows of a record to out, filling each field of T
+// from the column of its name, as described by SchemaOf. Columns are
+// matched case-insensitively when no name matches exactly, and columns
+// without a field are ignored. It fails when a field has no column or its
+// type cannot hold the column's values, and on nulls in fields that are
+// not pointers.
+func Unmarshal[T any](rec arrow.Record, out *[]T) error {
+	fields, err := structFields(reflect.TypeOf((*T)(nil)).Elem())
+	if err != nil {
+		return err
+	}
+	schema := rec.Schema()
+	columns := make([]arrow.Array, len(fields))
+	for i, f := range fields {
+		idx := -1
+		for j, col := range schema.Fields() {
+			if col.Name == f.name {
+				idx = j
+				break
+			}
+			if idx < 0 && strings.EqualFold(col.Name, f.name) {
+				idx = j
+			}
+		}
+		if idx < 0 {
+			return fmt.Errorf("field %s has no column", f.name)
+		}
+		if col := schema.Field(idx); !fits(f.typ, col.Type, false) {
+			return fmt.Errorf("column %s of type %s cannot be read into field %s of type %s", col.Name, col.Type, f.name, f.typ)
+		}
+		columns[i] = rec.Column(idx)
+	}
+
+	n := int(rec.NumRows())
+	rows := make([]T, n)
+	for r := 0; r < n; r++ {
+		v := reflect.ValueOf(&rows[r]).Elem()
+		for i, f := range fields {
+			col := columns[i]
+			fv := v.FieldByIndex(f.index)
+			if col.IsNull(r) {
+				if !f.pointer {
+					return fmt.Errorf("row %d: column %s is null; use a pointer for field %s", r, f.name, f.name)
+				}
+				continue
+			}
+			if f.pointer {
+				fv.Set(reflect.New(f.typ))
+				fv = fv.Elem()
+			}
+			if err := setGoValue(fv, col, r); err != nil {
+				return fmt.Errorf("row %d, column %s: %w", r, f.name, err)
+			}
+		}
+	}
+	*out = append(*out, rows...)
+	return nil
+}
+
+// setGoValue sets a Go value to a value of an array of a type it fits
+func setGoValue(v reflect.Value, arr arrow.Array, i int) error {
+	switch a := arr.(type) {
+	case *array.Int8:
+		setGoInt(v, int64(a.Value(i)))
+	case *array.Int16:
+		setGoInt(v, int64(a.Value(i)))
+	case *array.Int32:
+		setGoInt(v, int64(a.Value(i)))
+	case *array.Int64:
+		setGoInt(v, a.Value(i))
+	case *array.Uint8:
+		setGoUint(v, uint64(a.Value(i)))
+	case *array.Uint16:
+		setGoUint(v, uint64(a.Value(i)))
+	case *array.Uint32:
+		setGoUint(v, uint64(a.Value(i)))
+	case *array.Uint64:
+		setGoUint(v, a.Value(i))
+	case *array.Float32:
+		v.SetFloat(float64(a.Value(i)))
+	case *array.Float64:
+		v.SetFloat(a.Value(i))
+	case *array.Boolean:
+		v.SetBool(a.Value(i))
+	case *array.String:
+		v.SetString(a.Value(i))
+	case *array.LargeString:
+		v.SetString(a.Value(i))
+	case *array.Binary:
+		setGoBytes(v, a.Value(i))
+	case *array.LargeBinary:
+		setGoBytes(v, a.Value(i))
+	case *array.Timestamp:
+		v.Set(reflect.ValueOf(a.Value(i).ToTime(a.DataType().(*arrow.TimestampType).Unit)))
+	case *array.Date32:
+		v.Set(reflect.ValueOf(a.Value(i).ToTime()))
+	case *array.Date64:
+		v.Set(reflect.ValueOf(a.Value(i).ToTime()))
+	case *array.FixedSizeList:
+		values, ok := a.ListValues().(*array.Float32)
+		if !ok {
+			return fmt.Errorf("unsupported column type %s", arr.DataType())
+		}
+		dim := int(a.DataType().(*arrow.FixedSizeListType).Len())
+		start := (a.Offset() + i) * dim
+		vec := make([]float32, dim)
+		copy(vec, values.Float32Values()[start:start+dim])
+		v.Set(reflect.ValueOf(vec))
+	case *array.Dictionary:
+		return setGoValue(v, a.Dictionary(), a.GetValueIndex(i))
+	default:
+		return fmt.Errorf("unsupported column type %s", arr.DataType())
+	}
+	return nil
+}
+
+func setGoInt(v reflect.Value, n int64) {
+	if v.CanInt() {
+		v.SetInt(n)
+	} else {
+		v.SetUint(uint64(n))
+	}
+}
+
+func setGoUint(v reflect.Value, n uint64) {
+	if v.CanUint() {
+		v.SetUint(n)
+	} else {
+		v.SetInt(int64(n))
+	}
+}
+
+// setGoBytes sets a []byte or string to a copy of b, which belongs to
+// the array
+func setGoBytes(v reflect.Value, b []byte) {
+	if v.Kind() == reflect.String {
+		v.SetString(string(b))
+		return
+	}
+	v.SetBytes(append([]byte(nil), b...))
+}
@@ -0,0 +1,111 @@
+package lockbox
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/apache/arrow-go/v18/arrow"
+)
+
+type marshalBase struct {
+	ID int64 `lockbox:"id"`
+}
+
+type marshalUser struct {
+	marshalBase
+	Name    string    `lockbox:"name"`
+	Score   *float64  `lockbox:"score"`
+	Joined  time.Time `lockbox:"joined"`
+	Comment string    `lockbox:"-"`
+}
+
+func TestMarshal(t *testing.T) {
+	password := "test_password_123"
+	ctx := context.Background()
+
+	schema, err := SchemaOf[marshalUser]()
+	if err != nil {
+		t.Fatalf("schema: %v", err)
+	}
+	if schema.NumFields() != 4 || schema.Field(0).Name != "id" || !schema.Field(2).Nullable || schema.Field(3).Type.ID() != arrow.TIMESTAMP {
+		t.Fatalf("unexpected schema %v", schema)
+	}
+
+	// The lockbox stores timestamps in seconds; WithSchema converts to them
+	schema = arrow.NewSchema([]arrow.Field{
+		{Name: "id", Type: arrow.PrimitiveTypes.Int64},
+		{Name: "name", Type: arrow.BinaryTypes.String, Nullable: true},
+		{Name: "score", Type: arrow.PrimitiveTypes.Float64, Nullable: true},
+		{Name: "joined", Type: arrow.FixedWidthTypes.Timestamp_s, Nullable: true},
+		{Name: "note", Type: arrow.BinaryTypes.String, Nullable: true},
+	}, nil)
+	lb, err := Create(filepath.Join(t.TempDir(), "users.lbx"), schema, WithPassword(password))
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	defer lb.Close()
+
+	score := 9.5
+	joined := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	users := []marshalUser{
+		{marshalBase: marshalBase{ID: 1}, Name: "ann", Score: &score, Joined: joined, Comment: "not stored"},
+		{marshalBase: marshalBase{ID: 2}, Name: "bob", Joined: joined.Add(time.Hour)},
+	}
+	rec, err := Marshal(users, WithSchema(lb.Schema()))
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+	if err := lb.Write(ctx, rec, WithPassword(password)); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	rec.Release()
+
+	type narrow struct {
+		ID int32 `lockbox:"id"`
+	}
+	if _, err := Marshal([]narrow{{ID: 1}}, WithSchema(arrow.NewSchema([]arrow.Field{{Name: "id", Type: arrow.PrimitiveTypes.Int8}}, nil))); err == nil {
+		t.Errorf("expected an int32 field not to fit an int8 column")
+	}
+	type misspelled struct {
+		ID   int64  `lockbox:"id"`
+		Name string `lockbox:"nmae"`
+	}
+	if _, err := Marshal([]misspelled{{ID: 1}}, WithSchema(lb.Schema())); err == nil {
+		t.Errorf("expected a field without a column to be refused")
+	}
+	type missing struct {
+		Name string `lockbox:"name"`
+	}
+	if _, err := Marshal([]missing{{Name: "x"}}, WithSchema(lb.Schema())); err == nil {
+		t.Errorf("expected the non-nullable id column without a field to be refused")
+	}
+
+	out, err := lb.Read(ctx, WithPassword(password))
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	defer out.Release()
+	var got []marshalUser
+	if err := Unmarshal(out, &got); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	if len(got) != 2 || got[0].ID != 1 || got[0].Name != "ann" || got[0].Score == nil || *got[0].Score != 9.5 ||
+		!got[0].Joined.Equal(joined) || got[1].Score != nil || got[0].Comment != "" {
+		t.Errorf("unexpected rows %+v", got)
+	}
+
+	type wrongType struct {
+		Name int64 `lockbox:"name"`
+	}
+	if err := Unmarshal(out, new([]wrongType)); err == nil {
+		t.Errorf("expected a string column not to fit an int64 field")
+	}
+	type notNull struct {
+		Score float64 `lockbox:"score"`
+	}
+	if err := Unmarshal(out, new([]notNull)); err == nil {
+		t.Errorf("expected a null in a non-pointer field to fail")
+	}
+}
@@ -0,0 +1,267 @@
+package lockbox
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"os"
+
+	"github.com/TFMV/lockbox/pkg/codec"
+	"github.com/TFMV/lockbox/pkg/format"
+	"github.com/TFMV/lockbox/pkg/metadata"
+	"github.com/apache/arrow-go/v18/arrow/ipc"
+	"github.com/apache/arrow-go/v18/arrow/memory"
+)
+
+// defaultMigrateCodec compresses migrated blocks unless WithCodec picks
+// another codec
+const defaultMigrateCodec = "gzip"
+
+// MigrateResult summarizes a migration
+type MigrateResult struct {
+	Source    string `json:"source"`
+	Target    string `json:"target"`
+	Module    string `json:"module"`
+	Codec     string `json:"codec"`
+	RowGroups int    `json:"rowGroups"`
+	Rows      int64  `json:"rows"`
+	// Verified is set once every row group of the target was read back and
+	// matched the source, row count and SHA-256 of the decrypted data
+	Verified bool `json:"verified"`
+	// Dropped lists settings sealed to the old master key that cannot be
+	// carried over, such as access keys and recovery codes
+	Dropped []string `json:"dropped,omitempty"`
+}
+
+// Migrate rewrites the lockbox file src into a new file dst with the
+// current format's settings in one pass: a master key wrapped with the
+// password, fresh column keys with no stale key epochs, explicit row groups
+// and compressed blocks (gzip unless WithCodec names another codec; "none"
+// stores them uncompressed). WithCryptoModule switches the crypto module
+// and WithArgon2id the key derivation; files using Argon2id keep it.
+//
+// The password given with WithPassword must unlock the whole file and also
+// protects dst. Column groups, views, virtual columns, the access policy,
+// hooks, tags, the description and the profile are carried over, and
+// encrypted metadata stays encrypted; hooks do not run for the copied rows. Each row group becomes one commit with the
+// "migrate" operation, whose lineage names src and its SHA-256, so
+// snapshot IDs of src do not apply to dst. Access keys and recovery codes
+// are sealed to the old master key and are dropped; they are listed in the
+// result.
+//
+// The rows of dst are verified against src at the end, by row count and
+// checksum of each row group. dst must not exist and is removed when the
+// migration fails. Strict security applies to dst only, so legacy files
+// can still be read for migration.
+func Migrate(ctx context.Context, src, dst string, opts ...Option) (*MigrateResult, error) {
+	options := &Options{CreatedBy: "system"}
+	for _, opt := range opts {
+		opt(options)
+	}
+	if options.Password == "" {
+		return nil, fmt.Errorf("password is required for migrating")
+	}
+	if _, err := os.Stat(dst); err == nil {
+		return nil, fmt.Errorf("%s already exists", dst)
+	}
+
+	module, err := resolveModule("")
+	if err != nil {
+		return nil, err
+	}
+	source, err := format.Open(src, options.Password, module)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open lockbox file: %w", err)
+	}
+	defer source.Close()
+	source.SetAllocator(newAllocator(options))
+	if _, err := source.MasterKey(options.Password); err != nil {
+		return nil, fmt.Errorf("migrating needs the password of the whole file: %w", err)
+	}
+	reader, err := source.NewReader(options.Password)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create reader: %w", err)
+	}
+	meta := source.Metadata()
+
+	hash, err := SourceHash(src)
+	if err != nil {
+		return nil, err
+	}
+	res := &MigrateResult{
+		Source: src,
+		Target: dst,
+		Module: meta.Encryption.ModuleName(),
+		Codec:  options.Codec,
+	}
+	if options.CryptoModule != "" {
+		res.Module = options.CryptoModule
+	}
+	if res.Codec == "" {
+		res.Codec = defaultMigrateCodec
+	}
+	if res.Codec != codec.None {
+		if _, ok := codec.Get(res.Codec); !ok {
+			return nil, fmt.Errorf("codec %s is not registered", res.Codec)
+		}
+	}
+	if n := len(meta.Encryption.AccessKeys); n > 0 {
+		res.Dropped = append(res.Dropped, fmt.Sprintf("%d access keys", n))
+	}
+	if n := source.RemainingRecoveryCodes(); n > 0 {
+		res.Dropped = append(res.Dropped, fmt.Sprintf("%d recovery codes", n))
+	}
+
+	createOpts := []Option{
+		WithPassword(options.Password),
+		WithCreatedBy(options.CreatedBy),
+		WithCryptoModule(res.Module),
+		WithAllocator(options.Allocator),
+	}
+	if fi, err := os.Stat(src); err == nil {
+		createOpts = append(createOpts, WithFileMode(fi.Mode().Perm()))
+	}
+	if options.StrictSecurity {
+		createOpts = append(createOpts, WithStrictSecurity())
+	}
+	for _, g := range meta.Encryption.ColumnGroups {
+		createOpts = append(createOpts, WithColumnGroup(g.Name, g.Columns...))
+	}
+	if source.MetadataEncrypted() {
+		createOpts = append(createOpts, WithEncryptedMetadata())
+	}
+	if options.Argon2 != nil {
+		createOpts = append(createOpts, WithArgon2id(*options.Argon2))
+	} else if enc := meta.Encryption; enc.KeyDerivation == metadata.KDFArgon2id {
+		createOpts = append(createOpts, WithArgon2id(*enc.Argon2))
+	}
+	target, err := Create(dst, meta.Schema, createOpts...)
+	if err != nil {
+		return nil, err
+	}
+	fail := func(err error) (*MigrateResult, error) {
+		target.Close()
+		os.Remove(dst)
+		return nil, err
+	}
+
+	// Wrapping the master key lets wrong passwords be detected
+	masterKey, err := target.file.MasterKey(options.Password)
+	if err != nil {
+		return fail(err)
+	}
+	if err := target.file.SetPassword(masterKey, options.Password); err != nil {
+		return fail(err)
+	}
+
+	res.RowGroups = reader.NumRowGroups()
+	for n := 0; n < res.RowGroups; n++ {
+		if err := ctx.Err(); err != nil {
+			return fail(err)
+		}
+		rec, err := reader.ReadRowGroup(n, nil)
+		if err != nil {
+			return fail(fmt.Errorf("failed to read row group %d: %w", n, err))
+		}
+		err = target.Write(ctx, rec,
+			WithPassword(options.Password),
+			WithCodec(res.Codec),
+			WithDictionary(options.Dictionary...),
+			WithMessage(fmt.Sprintf("row group %d of %s", n, src)),
+			WithLineage(metadata.Lineage{Source: src, SourceHash: hash, Transformation: "migrate"}),
+			withOperation(OperationMigrate))
+		res.Rows += rec.NumRows()
+		rec.Release()
+		if err != nil {
+			return fail(fmt.Errorf("failed to write row group %d: %w", n, err))
+		}
+	}
+
+	// Settings are copied after the data so hooks and policies don't act on
+	// the copy
+	tmeta := target.file.Metadata()
+	tmeta.Views = meta.Views
+	tmeta.Virtual = meta.Virtual
+	tmeta.AccessPolicy = meta.AccessPolicy
+	tmeta.Hooks = meta.Hooks
+	tmeta.Tags = meta.Tags
+	tmeta.Description = meta.Description
+	tmeta.Preset = meta.Preset
+	tmeta.SyntheticKey = meta.SyntheticKey
+	tmeta.Sequences = meta.Sequences
+	tmeta.LogAccess(options.CreatedBy, "migrate", src, true, fmt.Sprintf("%d rows in %d row groups from %s", res.Rows, res.RowGroups, hash))
+	if err := target.file.SaveMetadata(); err != nil {
+		return fail(err)
+	}
+	if err := target.RefreshViews(ctx, nil, WithPassword(options.Password)); err != nil {
+		return fail(fmt.Errorf("failed to refresh materialized views: %w", err))
+	}
+	if err := target.Close(); err != nil {
+		os.Remove(dst)
+		return nil, err
+	}
+
+	if err := verifyCopy(reader, dst, options.Password, res.RowGroups, source.Allocator()); err != nil {
+		os.Remove(dst)
+		return nil, fmt.Errorf("verification failed: %w", err)
+	}
+	res.Verified = true
+	return res, nil
+}
+
+// verifyCopy reopens a copied file and compares the row count and checksum
+// of each row group with the first groups row groups of the source
+func verifyCopy(source *format.Reader, dst, password string, groups int, mem memory.Allocator) error {
+	file, err := format.Open(dst, password, nil)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	file.SetAllocator(mem)
+	target, err := file.NewReader(password)
+	if err != nil {
+		return err
+	}
+	if groups != target.NumRowGroups() {
+		return fmt.Errorf("source has %d row groups, target %d", groups, target.NumRowGroups())
+	}
+
+	for n := 0; n < groups; n++ {
+		want, wantRows, err := rowGroupChecksum(source, n, mem)
+		if err != nil {
+			return err
+		}
+		got, gotRows, err := rowGroupChecksum(target, n, mem)
+		if err != nil {
+			return err
+		}
+		if wantRows != gotRows {
+			return fmt.Errorf("row group %d: source has %d rows, target %d", n, wantRows, gotRows)
+		}
+		if !bytes.Equal(want, got) {
+			return fmt.Errorf("row group %d: checksum mismatch", n)
+		}
+	}
+	return nil
+}
+
+// rowGroupChecksum returns the SHA-256 of the Arrow IPC encoding of a
+// decrypted row group and its row count
+func rowGroupChecksum(r *format.Reader, n int, mem memory.Allocator) ([]byte, int64, error) {
+	rec, err := r.ReadRowGroup(n, nil)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to read row group %d: %w", n, err)
+	}
+	defer rec.Release()
+
+	h := sha256.New()
+	w := ipc.NewWriter(h, ipc.WithSchema(rec.Schema()), ipc.WithAllocator(mem))
+	if err := w.Write(rec); err != nil {
+		return nil, 0, fmt.Errorf("failed to encode row group %d: %w", n, err)
+	}
+	if err := w.Close(); err != nil {
+		return nil, 0, err
+	}
+	return h.Sum(nil), rec.NumRows(), nil
+}
@@ -0,0 +1,94 @@
+package lockbox
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/apache/arrow-go/v18/arrow/array"
+)
+
+func TestMigrate(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "old.lbx")
+	dst := filepath.Join(dir, "new.lbx")
+	password := "test_password_123"
+	ctx := context.Background()
+
+	lb := newQueryTestLockbox(t, src, password)
+	// A second row group, blocks left on an old key and settings to carry
+	rec, err := lb.Read(ctx, WithPassword(password))
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if _, err := lb.RotateColumnKey("name", WithPassword(password), WithLazyRotation()); err != nil {
+		t.Fatalf("rotate: %v", err)
+	}
+	if err := lb.Write(ctx, rec, WithPassword(password)); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	rec.Release()
+	if err := lb.SetTags(map[string]string{"team": "risk"}); err != nil {
+		t.Fatalf("tags: %v", err)
+	}
+	if err := lb.AddVirtualColumn("double", "score * 2"); err != nil {
+		t.Fatalf("virtual: %v", err)
+	}
+	if err := lb.GrantAccess("public", "public_password_456", []string{"id"}, WithPassword(password)); err != nil {
+		t.Fatalf("grant: %v", err)
+	}
+	lb.Close()
+
+	if _, err := Migrate(ctx, src, dst, WithPassword("wrong_password_789")); err == nil {
+		t.Fatalf("expected migration with a wrong password to fail")
+	}
+	if _, err := Migrate(ctx, src, src, WithPassword(password)); err == nil {
+		t.Fatalf("expected migration onto an existing file to fail")
+	}
+
+	res, err := Migrate(ctx, src, dst, WithPassword(password), WithDebugAllocator())
+	if err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	if !res.Verified || res.Rows != 6 || res.RowGroups != 2 || res.Codec != "gzip" {
+		t.Errorf("unexpected result: %+v", res)
+	}
+	if len(res.Dropped) != 1 {
+		t.Errorf("expected the access key to be reported as dropped, got %v", res.Dropped)
+	}
+
+	lb, err = Open(dst, WithPassword(password))
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer lb.Close()
+	meta := lb.file.Metadata()
+	if len(meta.Encryption.WrappedMasterKey) == 0 {
+		t.Errorf("expected the master key to be wrapped")
+	}
+	if lb.file.StaleKeyBlocks() != 0 || len(meta.Encryption.ColumnEpochs) != 0 {
+		t.Errorf("expected fresh column keys, got epochs %v", meta.Encryption.ColumnEpochs)
+	}
+	for _, bi := range meta.BlockInfo {
+		if bi.Codec != "gzip" {
+			t.Errorf("expected block of %s to be compressed, got codec %q", bi.ColumnName, bi.Codec)
+		}
+	}
+	if meta.Tags["team"] != "risk" || len(meta.Virtual) != 1 || len(meta.Encryption.AccessKeys) != 0 {
+		t.Errorf("settings not carried over as expected: tags %v, virtual %v", meta.Tags, meta.Virtual)
+	}
+	for _, s := range meta.Snapshots {
+		if s.Operation != OperationMigrate || s.Lineage == nil || s.Lineage.Source != src {
+			t.Errorf("expected migrate commits naming the source, got %+v", s)
+		}
+	}
+
+	out, err := lb.Query(ctx, "SELECT sum(double) FROM data", WithPassword(password))
+	if err != nil {
+		t.Fatalf("query: %v", err)
+	}
+	defer out.Release()
+	if got := out.Column(0).(*array.Float64).Value(0); got != 380 {
+		t.Errorf("expected sum 380 after migration, got %v", got)
+	}
+}
@@ -0,0 +1,227 @@
+package lockbox
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/TFMV/lockbox/pkg/crypto"
+	"github.com/TFMV/lockbox/pkg/format"
+	"github.com/apache/arrow-go/v18/arrow"
+	"github.com/apache/arrow-go/v18/arrow/array"
+	"github.com/apache/arrow-go/v18/arrow/memory"
+)
+
+// renamedModule wraps the default module under another name
+type renamedModule struct {
+	crypto.Module
+}
+
+func (renamedModule) Name() string { return "test-renamed" }
+
+func (renamedModule) Params() map[string]string { return map[string]string{"variant": "test"} }
+
+func TestModuleNegotiation(t *testing.T) {
+	tmpFile := "/tmp/test_lockbox_modules.lbx"
+	defer os.Remove(tmpFile)
+
+	base, _ := crypto.GetModule("default")
+	crypto.RegisterModule(renamedModule{base})
+
+	password := "test_password_123"
+	schema := arrow.NewSchema([]arrow.Field{{Name: "id", Type: arrow.PrimitiveTypes.Int64}}, nil)
+
+	if _, err := Create(tmpFile, schema, WithPassword(password), WithCryptoModule("missing")); !errors.Is(err, format.ErrModuleUnavailable) {
+		t.Fatalf("expected ErrModuleUnavailable for unknown module, got %v", err)
+	}
+
+	lb, err := Create(tmpFile, schema, WithPassword(password), WithCryptoModule("test-renamed"))
+	if err != nil {
+		t.Fatalf("Failed to create lockbox: %v", err)
+	}
+	enc := lb.file.Metadata().Encryption
+	if enc.Module != "test-renamed" || enc.ModuleParams["variant"] != "test" {
+		t.Fatalf("module not recorded: %+v", enc)
+	}
+	lb.file.Metadata().Encryption.AddCodec("test-missing-codec")
+	if err := lb.file.SaveMetadata(); err != nil {
+		t.Fatalf("save metadata: %v", err)
+	}
+	lb.Close()
+
+	// The codec recorded in the file is not registered
+	if _, err := Open(tmpFile, WithPassword(password)); !errors.Is(err, format.ErrModuleUnavailable) {
+		t.Fatalf("expected ErrModuleUnavailable for unknown codec, got %v", err)
+	}
+
+	lb, err = Create(tmpFile, schema, WithPassword(password), WithCryptoModule("test-renamed"))
+	if err != nil {
+		t.Fatalf("Failed to create lockbox: %v", err)
+	}
+	lb.Close()
+
+	lb, err = Open(tmpFile, WithPassword(password))
+	if err != nil {
+		t.Fatalf("open error: %v", err)
+	}
+	if lb.file.Module().Name() != "test-renamed" {
+		t.Fatalf("recorded module not selected, got %s", lb.file.Module().Name())
+	}
+	lb.Close()
+
+	if _, err := Open(tmpFile, WithPassword(password), WithCryptoModule("default")); !errors.Is(err, format.ErrModuleUnavailable) {
+		t.Fatalf("expected ErrModuleUnavailable for mismatched module, got %v", err)
+	}
+}
+
+func TestMLKEMModule(t *testing.T) {
+	dir := t.TempDir()
+	password := "test_password_123"
+	ctx := context.Background()
+	schema := arrow.NewSchema([]arrow.Field{{Name: "id", Type: arrow.PrimitiveTypes.Int64}}, nil)
+
+	write := func(path string, opts ...Option) {
+		lb, err := Create(path, schema, append(opts, WithPassword(password))...)
+		if err != nil {
+			t.Fatalf("create: %v", err)
+		}
+		defer lb.Close()
+		b := array.NewInt64Builder(memory.NewGoAllocator())
+		defer b.Release()
+		b.AppendValues([]int64{1, 2, 3}, nil)
+		ids := b.NewArray()
+		defer ids.Release()
+		rec := array.NewRecord(schema, []arrow.Array{ids}, 3)
+		defer rec.Release()
+		if err := lb.Write(ctx, rec, WithPassword(password)); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+	}
+	read := func(path string) *Lockbox {
+		lb, err := Open(path, WithPassword(password))
+		if err != nil {
+			t.Fatalf("open: %v", err)
+		}
+		rec, err := lb.Read(ctx, WithPassword(password))
+		if err != nil {
+			t.Fatalf("read %s: %v", path, err)
+		}
+		defer rec.Release()
+		if rec.NumRows() != 3 || rec.Column(0).(*array.Int64).Value(2) != 3 {
+			t.Fatalf("unexpected data in %s", path)
+		}
+		return lb
+	}
+
+	legacy := filepath.Join(dir, "legacy.lbx")
+	write(legacy)
+	pq := filepath.Join(dir, "pq.lbx")
+	write(pq, WithCryptoModule(crypto.MLKEMModule))
+
+	lb := read(legacy)
+	legacySize := lb.file.Metadata().BlockInfo[0].Length
+	lb.Close()
+	lb = read(pq)
+	defer lb.Close()
+	if got := lb.file.Metadata().BlockInfo[0].Length; got != legacySize+crypto.MLKEMCiphertextSize {
+		t.Errorf("expected the block to carry an ML-KEM encapsulation next to the exchange, got %d bytes against %d", got, legacySize)
+	}
+	if len(lb.file.Metadata().Encryption.KEMKeys) == 0 {
+		t.Errorf("expected wrapped ML-KEM keys in the metadata")
+	}
+	for _, f := range lb.SecurityFindings() {
+		if f.Check == "pq-scheme" {
+			t.Errorf("unexpected finding for %s: %+v", crypto.MLKEMModule, f)
+		}
+	}
+
+	// Exported keys carry the ML-KEM key, which the column key and the
+	// classical secret do not give away
+	escrow, err := crypto.GenerateEscrowKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	bundle, err := lb.ExportColumnKey("id", escrow.PublicPEM(), WithPassword(password))
+	if err != nil {
+		t.Fatalf("export: %v", err)
+	}
+	ck, err := ImportColumnKey(bundle, escrow.PrivatePEM())
+	if err != nil {
+		t.Fatalf("import: %v", err)
+	}
+	if len(ck.KEMSeed) == 0 || recoversMasterKey(lb.file.Metadata(), ck.HybridSecret) {
+		t.Fatalf("unexpected exported key material")
+	}
+	res, err := lb.Query(ctx, "SELECT id FROM data", WithColumnKey(ck))
+	if err != nil {
+		t.Fatalf("query with column key: %v", err)
+	}
+	res.Release()
+	classical := *ck
+	classical.KEMSeed = nil
+	if _, err := lb.Query(ctx, "SELECT id FROM data", WithColumnKey(&classical)); err == nil {
+		t.Errorf("expected a key without its ML-KEM key to be refused")
+	}
+	classical.KEMSeed, _ = crypto.NewKEMSeed()
+	if _, err := lb.Query(ctx, "SELECT id FROM data", WithColumnKey(&classical)); err == nil {
+		t.Errorf("expected another ML-KEM key not to decrypt")
+	}
+
+	// Files created before independent ML-KEM keys derive them from the
+	// column key, and stay readable
+	derived := filepath.Join(dir, "derived.lbx")
+	old, err := Create(derived, schema, WithPassword(password), WithCryptoModule(crypto.MLKEMModule))
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	delete(old.file.Metadata().Encryption.ModuleParams, crypto.MLKEMKeysParam)
+	if err := old.file.SaveMetadata(); err != nil {
+		t.Fatal(err)
+	}
+	old.Close()
+	lb2, err := Open(derived, WithPassword(password))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := array.NewInt64Builder(memory.NewGoAllocator())
+	b.AppendValues([]int64{1, 2, 3}, nil)
+	ids := b.NewArray()
+	b.Release()
+	rec := array.NewRecord(schema, []arrow.Array{ids}, 3)
+	ids.Release()
+	if err := lb2.Write(ctx, rec, WithPassword(password)); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	rec.Release()
+	lb2.Close()
+	lb2 = read(derived)
+	defer lb2.Close()
+	if got := lb2.file.Metadata().BlockInfo[0].Length; got != legacySize-crypto.KyberPublicKeySize+crypto.MLKEMCiphertextSize {
+		t.Errorf("expected a block of the derived scheme, got %d bytes", got)
+	}
+	var flagged bool
+	for _, f := range lb2.SecurityFindings() {
+		flagged = flagged || f.Check == "pq-scheme"
+	}
+	if !flagged {
+		t.Errorf("expected derived ML-KEM keys to be reported")
+	}
+
+	// The derived ML-KEM key comes from the column key
+	enc, err := crypto.NewMLKEMEncryptor(bytes.Repeat([]byte{7}, crypto.KeySize))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ct, _ := enc.Encrypt([]byte("block"))
+	again, _ := crypto.NewMLKEMEncryptor(bytes.Repeat([]byte{7}, crypto.KeySize))
+	if pt, err := again.Decrypt(ct); err != nil || string(pt) != "block" {
+		t.Errorf("expected a second encryptor from the same key to decrypt, got %q, %v", pt, err)
+	}
+	other, _ := crypto.NewMLKEMEncryptor(bytes.Repeat([]byte{8}, crypto.KeySize))
+	if _, err := other.Decrypt(ct); err == nil {
+		t.Error("expected another key to be refused")
+	}
+}
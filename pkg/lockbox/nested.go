@@ -0,0 +1,235 @@
+package lockbox
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/apache/arrow-go/v18/arrow"
+	"github.com/apache/arrow-go/v18/arrow/array"
+	"github.com/apache/arrow-go/v18/arrow/memory"
+)
+
+// nestedPath is a dotted reference into a struct, map or list column:
+// address.city names a struct field, attrs.env the value of a map key and
+// tags.0 the first list element. Steps can be chained, e.g. orders.0.sku.
+type nestedPath struct {
+	Name   string
+	Column string
+	Steps  []string
+	// Type is the type of the values the path reaches
+	Type arrow.DataType
+}
+
+// isNested reports whether values of a type have parts a path can reach
+func isNested(t arrow.DataType) bool {
+	switch t.ID() {
+	case arrow.STRUCT, arrow.MAP, arrow.LIST, arrow.LARGE_LIST, arrow.FIXED_SIZE_LIST:
+		return true
+	}
+	return false
+}
+
+// resolveNestedPath resolves a dotted name against a schema. It returns
+// nil when the name is a column itself, as flattened columns are, or when
+// no nested column starts it; the longest such column wins.
+func resolveNestedPath(schema *arrow.Schema, name string) (*nestedPath, error) {
+	if len(schema.FieldIndices(name)) > 0 {
+		return nil, nil
+	}
+	for i := strings.LastIndexByte(name, '.'); i > 0; i = strings.LastIndexByte(name[:i], '.') {
+		idx := schema.FieldIndices(name[:i])
+		if len(idx) == 0 || !isNested(schema.Field(idx[0]).Type) {
+			continue
+		}
+		p := &nestedPath{Name: name, Column: name[:i], Steps: strings.Split(name[i+1:], "."), Type: schema.Field(idx[0]).Type}
+		for _, step := range p.Steps {
+			t, err := stepType(p.Type, step)
+			if err != nil {
+				return nil, fmt.Errorf("invalid path %s: %w", name, err)
+			}
+			p.Type = t
+		}
+		return p, nil
+	}
+	return nil, nil
+}
+
+// stepType returns the type a step reaches within a value of type t
+func stepType(t arrow.DataType, step string) (arrow.DataType, error) {
+	switch t := t.(type) {
+	case *arrow.StructType:
+		if i := structFieldIndex(t, step); i >= 0 {
+			return t.Field(i).Type, nil
+		}
+		return nil, fmt.Errorf("struct has no field %s", step)
+	case *arrow.MapType:
+		return t.ItemType(), nil
+	case arrow.ListLikeType:
+		if n, err := strconv.Atoi(step); err != nil || n < 0 {
+			return nil, fmt.Errorf("list index %s is not a non-negative integer", step)
+		}
+		return t.Elem(), nil
+	}
+	return nil, fmt.Errorf("%s is not a struct, map or list", t)
+}
+
+// structFieldIndex finds a struct field by name, ignoring case as query
+// identifiers are lower cased; -1 when there is none
+func structFieldIndex(t *arrow.StructType, name string) int {
+	if i, ok := t.FieldIdx(name); ok {
+		return i
+	}
+	for i, f := range t.Fields() {
+		if strings.EqualFold(f.Name, name) {
+			return i
+		}
+	}
+	return -1
+}
+
+// nestedValue follows steps from row of col to the array and index of the
+// value they reach. ok is false when a step finds a null, a missing map
+// key or an index past the end of a list.
+func nestedValue(col arrow.Array, row int, steps []string) (arrow.Array, int, bool) {
+	for _, step := range steps {
+		if col.IsNull(row) {
+			return nil, 0, false
+		}
+		switch c := col.(type) {
+		case *array.Struct:
+			col = c.Field(structFieldIndex(c.DataType().(*arrow.StructType), step))
+		case *array.Map:
+			start, end := c.ValueOffsets(row)
+			found := false
+			for j := int(start); j < int(end) && !found; j++ {
+				if c.Keys().ValueStr(j) == step {
+					col, row, found = c.Items(), j, true
+				}
+			}
+			if !found {
+				return nil, 0, false
+			}
+		case array.ListLike:
+			start, end := c.ValueOffsets(row)
+			n, _ := strconv.Atoi(step)
+			if int64(n) >= end-start {
+				return nil, 0, false
+			}
+			col, row = c.ListValues(), int(start)+n
+		default:
+			return nil, 0, false
+		}
+	}
+	return col, row, true
+}
+
+// nestedRoots replaces the dotted names of nested paths with the columns
+// they start at, so the columns are read. Other names are kept for
+// checkColumns to report.
+func nestedRoots(schema *arrow.Schema, names []string) []string {
+	if names == nil {
+		return nil
+	}
+	roots := make([]string, 0, len(names))
+	for _, name := range names {
+		if p, err := resolveNestedPath(schema, name); err == nil && p != nil {
+			name = p.Column
+		}
+		if !contains(roots, name) {
+			roots = append(roots, name)
+		}
+	}
+	return roots
+}
+
+// withNestedColumns returns rec with a column for each name that is a
+// nested path, holding the values the path reaches. Names that are neither
+// columns nor paths are left for later steps to report.
+func withNestedColumns(mem memory.Allocator, rec arrow.Record, names []string) (arrow.Record, error) {
+	rec.Retain()
+	for _, name := range names {
+		p, err := resolveNestedPath(rec.Schema(), name)
+		if err != nil {
+			rec.Release()
+			return nil, err
+		}
+		if p == nil {
+			continue
+		}
+
+		root := rec.Column(rec.Schema().FieldIndices(p.Column)[0])
+		b := array.NewBuilder(mem, p.Type)
+		for row := 0; row < int(rec.NumRows()); row++ {
+			if col, i, ok := nestedValue(root, row, p.Steps); ok {
+				copyValue(b, col, i)
+			} else {
+				b.AppendNull()
+			}
+		}
+		arr := b.NewArray()
+		b.Release()
+
+		fields := append(append([]arrow.Field{}, rec.Schema().Fields()...),
+			arrow.Field{Name: name, Type: p.Type, Nullable: true})
+		cols := append(append([]arrow.Array{}, rec.Columns()...), arr)
+		next := array.NewRecord(arrow.NewSchema(fields, nil), cols, rec.NumRows())
+		arr.Release()
+		rec.Release()
+		rec = next
+	}
+	return rec, nil
+}
+
+// copyValue appends the value at row of col to a builder of the same type,
+// recursing into structs, maps and lists
+func copyValue(b array.Builder, col arrow.Array, row int) {
+	if col.IsNull(row) {
+		b.AppendNull()
+		return
+	}
+	switch c := col.(type) {
+	case *array.Struct:
+		sb := b.(*array.StructBuilder)
+		sb.Append(true)
+		for i := 0; i < c.NumField(); i++ {
+			copyValue(sb.FieldBuilder(i), c.Field(i), row)
+		}
+	case *array.Map:
+		mb := b.(*array.MapBuilder)
+		mb.Append(true)
+		start, end := c.ValueOffsets(row)
+		for j := int(start); j < int(end); j++ {
+			copyValue(mb.KeyBuilder(), c.Keys(), j)
+			copyValue(mb.ItemBuilder(), c.Items(), j)
+		}
+	case array.ListLike:
+		lb := b.(array.ListLikeBuilder)
+		start, end := c.ValueOffsets(row)
+		lb.Append(true)
+		for j := int(start); j < int(end); j++ {
+			copyValue(lb.ValueBuilder(), c.ListValues(), j)
+		}
+	case *array.Int64:
+		b.(*array.Int64Builder).Append(c.Value(row))
+	case *array.Int32:
+		b.(*array.Int32Builder).Append(c.Value(row))
+	case *array.Float64:
+		b.(*array.Float64Builder).Append(c.Value(row))
+	case *array.Float32:
+		b.(*array.Float32Builder).Append(c.Value(row))
+	case *array.Boolean:
+		b.(*array.BooleanBuilder).Append(c.Value(row))
+	case *array.String:
+		b.(*array.StringBuilder).Append(c.Value(row))
+	case *array.Binary:
+		b.(*array.BinaryBuilder).Append(c.Value(row))
+	case *array.Timestamp:
+		b.(*array.TimestampBuilder).Append(c.Value(row))
+	default:
+		// Other types print in a form their builders parse back
+		if err := b.AppendValueFromString(col.ValueStr(row)); err != nil {
+			b.AppendNull()
+		}
+	}
+}
@@ -0,0 +1,113 @@
+package lockbox
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/apache/arrow-go/v18/arrow"
+	"github.com/apache/arrow-go/v18/arrow/array"
+)
+
+func TestNestedColumns(t *testing.T) {
+	password := "test_password_123"
+	ctx := context.Background()
+
+	address := arrow.StructOf(
+		arrow.Field{Name: "city", Type: arrow.BinaryTypes.String, Nullable: true},
+		arrow.Field{Name: "zip", Type: arrow.PrimitiveTypes.Int32, Nullable: true},
+	)
+	schema := arrow.NewSchema([]arrow.Field{
+		{Name: "id", Type: arrow.PrimitiveTypes.Int64},
+		{Name: "address", Type: address, Nullable: true},
+		{Name: "tags", Type: arrow.ListOf(arrow.BinaryTypes.String), Nullable: true},
+		{Name: "attrs", Type: arrow.MapOf(arrow.BinaryTypes.String, arrow.PrimitiveTypes.Int64), Nullable: true},
+		// A flattened column keeps its dotted name
+		{Name: "address.country", Type: arrow.BinaryTypes.String, Nullable: true},
+	}, nil)
+	lb, err := Create(filepath.Join(t.TempDir(), "nested.lbx"), schema, WithPassword(password), WithDebugAllocator())
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	// Query results are released by cleanups, before the allocator is checked
+	t.Cleanup(func() { lb.Close() })
+
+	// Nested values are written as JSON text
+	err = lb.AppendRows(ctx, []map[string]any{
+		{"id": 1, "address": `{"city":"Oslo","zip":150}`, "tags": `["a","b"]`, "attrs": `[{"key":"env","value":1}]`, "address.country": "NO"},
+		{"id": 2, "address": `{"city":"Lima","zip":null}`, "tags": `[]`},
+		{"id": 3},
+	}, WithPassword(password))
+	if err != nil {
+		t.Fatalf("append rows: %v", err)
+	}
+	if err := lb.AppendRows(ctx, []map[string]any{{"id": 4, "tags": `{"a":1}`}}, WithPassword(password)); err == nil {
+		t.Errorf("expected an object to be refused for a list column")
+	}
+
+	query := func(q string) arrow.Record {
+		t.Helper()
+		rec, err := lb.Query(ctx, q, WithPassword(password))
+		if err != nil {
+			t.Fatalf("%s: %v", q, err)
+		}
+		t.Cleanup(rec.Release)
+		return rec
+	}
+
+	// Nested columns keep their types through a query
+	rec := query("SELECT * FROM data WHERE address.zip >= 100")
+	if rec.NumRows() != 1 || rec.NumCols() != 5 || !arrow.TypeEqual(rec.Schema().Field(1).Type, address) {
+		t.Fatalf("unexpected result %v", rec)
+	}
+	if got := rec.Column(2).ValueStr(0); got != `["a","b"]` {
+		t.Errorf("expected the list to survive, got %s", got)
+	}
+
+	rec = query("SELECT id, address.city, tags.1, attrs.env, address.country FROM data ORDER BY id DESC")
+	if rec.Schema().Field(1).Name != "address.city" || rec.Schema().Field(3).Type.ID() != arrow.INT64 {
+		t.Fatalf("unexpected schema %v", rec.Schema())
+	}
+	cities := rec.Column(1).(*array.String)
+	if !cities.IsNull(0) || cities.Value(1) != "Lima" || cities.Value(2) != "Oslo" {
+		t.Errorf("expected the cities, got %v", cities)
+	}
+	if tags := rec.Column(2); !tags.IsNull(1) || tags.ValueStr(2) != "b" {
+		t.Errorf("expected the second tag of Oslo only, got %v", tags)
+	}
+	if attrs := rec.Column(3).(*array.Int64); !attrs.IsNull(1) || attrs.Value(2) != 1 {
+		t.Errorf("expected the env attribute of Oslo only, got %v", attrs)
+	}
+	if country := rec.Column(4).(*array.String); country.Value(2) != "NO" {
+		t.Errorf("expected the flattened column, got %v", country)
+	}
+
+	rec = query("SELECT COUNT(*) FROM data WHERE tags.0 = 'a' OR address.city = 'Lima'")
+	if rec.Column(0).(*array.Int64).Value(0) != 2 {
+		t.Errorf("expected 2 matches, got %v", rec.Column(0))
+	}
+	if _, err := lb.Query(ctx, "SELECT address.street FROM data", WithPassword(password)); err == nil {
+		t.Errorf("expected an unknown struct field to be refused")
+	}
+
+	// Scans filter and project paths too
+	scanner, err := lb.NewScanner(ctx, ScanOptions{Columns: []string{"id", "address.city"}, Filter: "address.zip = 150"}, WithPassword(password))
+	if err != nil {
+		t.Fatalf("scanner: %v", err)
+	}
+	defer scanner.Release()
+	var rows []string
+	for scanner.Next() {
+		r := scanner.Record()
+		for i := 0; i < int(r.NumRows()); i++ {
+			rows = append(rows, r.Column(0).ValueStr(i)+" "+r.Column(1).ValueStr(i))
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		t.Fatalf("scan: %v", err)
+	}
+	if strings.Join(rows, ",") != "1 Oslo" {
+		t.Errorf("expected Oslo, got %v", rows)
+	}
+}
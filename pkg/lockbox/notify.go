@@ -0,0 +1,173 @@
+package lockbox
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/TFMV/lockbox/pkg/metadata"
+	"github.com/rs/zerolog/log"
+)
+
+// notifyTimeout bounds the time a webhook or exec hook may take
+const notifyTimeout = 10 * time.Second
+
+// ErrAccessDenied is returned when the access policy refuses a request
+var ErrAccessDenied = errors.New("access denied")
+
+// Event is the JSON document notify hooks receive: POSTed to webhooks and
+// written to the standard input of exec hooks. Snapshot is the newest
+// commit after the event, so a pipeline can pick up from there, e.g. with
+// WithSinceSnapshot.
+type Event struct {
+	Event     string           `json:"event"`
+	File      string           `json:"file"`
+	Time      time.Time        `json:"time"`
+	Snapshot  int64            `json:"snapshot,omitempty"`
+	Operation string           `json:"operation,omitempty"`
+	Principal string           `json:"principal,omitempty"`
+	Column    string           `json:"column,omitempty"`
+	Action    string           `json:"action,omitempty"` // policy-denied only
+	Error     string           `json:"error,omitempty"`  // policy-denied only
+	Stats     map[string]int64 `json:"stats,omitempty"`
+}
+
+var notifyEvents = []string{metadata.EventCommit, metadata.EventCompact, metadata.EventRotate, metadata.EventPolicyDenied}
+
+// checkNotifyHook validates the target and events of a webhook or exec hook
+func checkNotifyHook(h metadata.Hook) error {
+	switch h.Kind {
+	case metadata.HookWebhook:
+		u, err := url.Parse(h.URL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("webhook needs an http or https URL, got %q", h.URL)
+		}
+	case metadata.HookExec:
+		if len(h.Command) == 0 || h.Command[0] == "" {
+			return fmt.Errorf("exec hook needs a command")
+		}
+	}
+	for _, ev := range h.Events {
+		if !contains(notifyEvents, ev) {
+			return fmt.Errorf("unknown event %q, expected one of %s", ev, strings.Join(notifyEvents, ", "))
+		}
+	}
+	return nil
+}
+
+// notify runs the notify hooks subscribed to ev. Delivery is best effort:
+// the event has already happened, so failures are logged, not returned.
+func (lb *Lockbox) notify(ctx context.Context, ev Event) {
+	var hooks []metadata.Hook
+	for _, h := range lb.file.Metadata().Hooks {
+		if h.Stage == metadata.HookNotify && (len(h.Events) == 0 || contains(h.Events, ev.Event)) {
+			hooks = append(hooks, h)
+		}
+	}
+	if len(hooks) == 0 {
+		return
+	}
+
+	ev.File = lb.Path()
+	ev.Time = time.Now().UTC()
+	if snaps := lb.file.Metadata().Snapshots; ev.Snapshot == 0 && len(snaps) > 0 {
+		ev.Snapshot = snaps[len(snaps)-1].ID
+	}
+	body, err := json.Marshal(ev)
+	if err != nil {
+		log.Warn().Err(err).Str("event", ev.Event).Msg("Failed to encode event")
+		return
+	}
+
+	for _, h := range hooks {
+		if err := deliverEvent(ctx, h, ev, body); err != nil {
+			log.Warn().Err(err).Str("hook", h.Name).Str("event", ev.Event).Msg("Notify hook failed")
+			continue
+		}
+		log.Debug().Str("hook", h.Name).Str("event", ev.Event).Msg("Delivered event")
+	}
+}
+
+// notifyCommit notifies hooks of the snapshot just committed
+func (lb *Lockbox) notifyCommit(ctx context.Context) {
+	snaps := lb.file.Metadata().Snapshots
+	if len(snaps) == 0 {
+		return
+	}
+	s := snaps[len(snaps)-1]
+	lb.notify(ctx, Event{
+		Event:     metadata.EventCommit,
+		Snapshot:  s.ID,
+		Operation: s.Operation,
+		Principal: s.Principal,
+		Stats: map[string]int64{
+			"rowsAdded":  s.RowsAdded,
+			"totalRows":  s.TotalRows,
+			"blockCount": int64(s.BlockCount),
+		},
+	})
+}
+
+// checkPolicy evaluates the access policy for principal and action and
+// notifies hooks when the request is denied
+func (lb *Lockbox) checkPolicy(principal, action string) (*policyScope, error) {
+	scope, err := authorize(lb.file.Metadata(), principal, action)
+	if errors.Is(err, ErrAccessDenied) {
+		if principal == "" {
+			principal = anonymousPrincipal
+		}
+		lb.notify(context.Background(), Event{
+			Event:     metadata.EventPolicyDenied,
+			Principal: principal,
+			Action:    action,
+			Error:     err.Error(),
+		})
+	}
+	return scope, err
+}
+
+// deliverEvent sends one event to a webhook or exec hook
+func deliverEvent(ctx context.Context, h metadata.Hook, ev Event, body []byte) error {
+	ctx, cancel := context.WithTimeout(ctx, notifyTimeout)
+	defer cancel()
+
+	switch h.Kind {
+	case metadata.HookWebhook:
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, h.URL, bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Lockbox-Event", ev.Event)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		if resp.StatusCode < 200 || resp.StatusCode > 299 {
+			return fmt.Errorf("webhook returned %s", resp.Status)
+		}
+		return nil
+	case metadata.HookExec:
+		cmd := exec.CommandContext(ctx, h.Command[0], h.Command[1:]...)
+		cmd.Stdin = bytes.NewReader(body)
+		cmd.Env = append(os.Environ(), "LOCKBOX_EVENT="+ev.Event, "LOCKBOX_FILE="+ev.File)
+		if out, err := cmd.CombinedOutput(); err != nil {
+			if msg := strings.TrimSpace(string(out)); msg != "" {
+				return fmt.Errorf("%w: %s", err, msg)
+			}
+			return err
+		}
+		return nil
+	default:
+		return fmt.Errorf("hook kind %s does not take events", h.Kind)
+	}
+}
@@ -0,0 +1,123 @@
+package lockbox
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/apache/arrow-go/v18/arrow"
+	"github.com/apache/arrow-go/v18/arrow/array"
+	"github.com/apache/arrow-go/v18/arrow/memory"
+)
+
+func TestObjectStores(t *testing.T) {
+	password := "test_password_123"
+	ctx := context.Background()
+	schema := arrow.NewSchema([]arrow.Field{
+		{Name: "id", Type: arrow.PrimitiveTypes.Int64},
+		{Name: "name", Type: arrow.BinaryTypes.String},
+	}, nil)
+	path := filepath.Join(t.TempDir(), "data.lbx")
+	lb, err := Create(path, schema, WithPassword(password))
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	b := array.NewRecordBuilder(memory.NewGoAllocator(), schema)
+	b.Field(0).(*array.Int64Builder).AppendValues([]int64{1, 2, 3}, nil)
+	b.Field(1).(*array.StringBuilder).AppendValues([]string{"ann", "bob", "cy"}, nil)
+	rec := b.NewRecord()
+	b.Release()
+	if err := lb.Write(ctx, rec, WithPassword(password)); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	rec.Release()
+	lb.Close()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// One object served at /<container>/data.lbx, with ranges; every
+	// request must carry the store's credentials
+	var fullReads int
+	serve := func(prefix, auth string) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !strings.HasPrefix(r.Header.Get("Authorization"), auth) {
+				http.Error(w, "unsigned", http.StatusForbidden)
+				return
+			}
+			if r.URL.Path != prefix+"/data.lbx" {
+				http.NotFound(w, r)
+				return
+			}
+			if r.Method == http.MethodGet && r.Header.Get("Range") == "" && r.Header.Get("X-Ms-Range") == "" {
+				fullReads++
+			}
+			if rg := r.Header.Get("X-Ms-Range"); rg != "" {
+				r.Header.Set("Range", rg)
+			}
+			http.ServeContent(w, r, "data.lbx", time.Time{}, bytes.NewReader(data))
+		}))
+	}
+	query := func(uri string) {
+		t.Helper()
+		lb, err := Open(uri, WithPassword(password))
+		if err != nil {
+			t.Fatalf("open %s: %v", uri, err)
+		}
+		defer lb.Close()
+		res, err := lb.Query(ctx, "SELECT name FROM data WHERE id = 2", WithPassword(password))
+		if err != nil {
+			t.Fatalf("query %s: %v", uri, err)
+		}
+		defer res.Release()
+		if res.NumRows() != 1 || res.Column(0).(*array.String).Value(0) != "bob" {
+			t.Errorf("%s: expected bob, got %v", uri, res)
+		}
+		b := array.NewRecordBuilder(memory.NewGoAllocator(), schema)
+		b.Field(0).(*array.Int64Builder).Append(4)
+		b.Field(1).(*array.StringBuilder).Append("dee")
+		rec := b.NewRecord()
+		b.Release()
+		defer rec.Release()
+		if err := lb.Write(ctx, rec, WithPassword(password)); err == nil {
+			t.Errorf("%s: expected writes to be refused", uri)
+		}
+	}
+
+	s3 := serve("/bucket", "AWS4-HMAC-SHA256 Credential=AKID/")
+	defer s3.Close()
+	t.Setenv("AWS_ENDPOINT_URL_S3", s3.URL)
+	t.Setenv("AWS_ACCESS_KEY_ID", "AKID")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+	query("s3://bucket/data.lbx")
+	if info, err := ReadInfo("s3://bucket/data.lbx"); err != nil || info.RowCount != 3 {
+		t.Errorf("expected info of the object, got %+v, %v", info, err)
+	}
+	if _, err := Open("s3://bucket/missing.lbx", WithPassword(password)); err == nil {
+		t.Error("expected a missing object to fail")
+	}
+
+	gcs := serve("/bucket", "Bearer token")
+	defer gcs.Close()
+	t.Setenv("STORAGE_EMULATOR_HOST", gcs.URL)
+	t.Setenv("GOOGLE_OAUTH_ACCESS_TOKEN", "token")
+	query("gs://bucket/data.lbx")
+
+	az := serve("/container", "SharedKey account:")
+	defer az.Close()
+	t.Setenv("AZURE_STORAGE_BLOB_ENDPOINT", az.URL)
+	t.Setenv("AZURE_STORAGE_KEY", base64.StdEncoding.EncodeToString([]byte("key")))
+	query("az://account/container/data.lbx")
+
+	if fullReads != 0 {
+		t.Errorf("expected only range reads, got %d full reads", fullReads)
+	}
+}
@@ -0,0 +1,39 @@
+package lockbox
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/apache/arrow-go/v18/arrow"
+)
+
+// Page is one page of query results
+type Page struct {
+	// Record holds the rows of the page and belongs to the caller, who must
+	// release it
+	Record arrow.Record
+	// Offset is the position of the first row of the page in the results
+	Offset int64
+	// Total is the number of rows of the whole result
+	Total int64
+}
+
+// QueryPage runs query and returns at most limit of its result rows,
+// starting at offset, along with the total number of result rows so a
+// caller can tell how many pages there are. An offset past the end returns
+// an empty page.
+func (lb *Lockbox) QueryPage(ctx context.Context, query string, offset, limit int, opts ...Option) (*Page, error) {
+	if offset < 0 || limit < 0 {
+		return nil, fmt.Errorf("offset and limit must not be negative")
+	}
+	rec, err := lb.Query(ctx, query, opts...)
+	if err != nil {
+		return nil, err
+	}
+	defer rec.Release()
+
+	total := rec.NumRows()
+	start := min(int64(offset), total)
+	end := min(start+int64(limit), total)
+	return &Page{Record: rec.NewSlice(start, end), Offset: start, Total: total}, nil
+}
@@ -0,0 +1,80 @@
+package lockbox
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/apache/arrow-go/v18/arrow"
+	"github.com/apache/arrow-go/v18/arrow/array"
+)
+
+func TestQueryOffsetAndPage(t *testing.T) {
+	tmpFile := "/tmp/test_lockbox_page.lbx"
+	defer os.Remove(tmpFile)
+
+	password := "test_password_123"
+	lb := newQueryTestLockbox(t, tmpFile, password)
+	defer lb.Close()
+
+	ids := func(rec arrow.Record) string {
+		col := rec.Column(0).(*array.Int64)
+		out := make([]int64, col.Len())
+		for i := range out {
+			out[i] = col.Value(i)
+		}
+		return fmt.Sprint(out)
+	}
+
+	ctx := context.Background()
+	cases := map[string]string{
+		"SELECT id FROM data ORDER BY id LIMIT 1 OFFSET 1":      "[2]",
+		"SELECT id FROM data ORDER BY id OFFSET 1":              "[2 3]",
+		"SELECT id FROM data ORDER BY id DESC OFFSET 2":         "[1]",
+		"SELECT id FROM data ORDER BY id LIMIT 5 OFFSET 3":      "[]",
+		"SELECT id FROM data WHERE id > 1 ORDER BY id OFFSET 1": "[3]",
+	}
+	for q, want := range cases {
+		res, err := lb.Query(ctx, q, WithPassword(password))
+		if err != nil {
+			t.Fatalf("%s: %v", q, err)
+		}
+		if got := ids(res); got != want {
+			t.Errorf("%s: expected ids %s, got %s", q, want, got)
+		}
+		res.Release()
+	}
+	if _, err := lb.Query(ctx, "SELECT id FROM data OFFSET -1", WithPassword(password)); err == nil {
+		t.Errorf("expected an error for a negative OFFSET")
+	}
+
+	// Pages report the total so callers know when to stop
+	var seen string
+	for offset := 0; ; offset += 2 {
+		page, err := lb.QueryPage(ctx, "SELECT id FROM data ORDER BY id", offset, 2, WithPassword(password))
+		if err != nil {
+			t.Fatalf("QueryPage: %v", err)
+		}
+		if page.Total != 3 || page.Offset != int64(offset) {
+			t.Errorf("offset %d: expected total 3 at offset %d, got %d at %d", offset, offset, page.Total, page.Offset)
+		}
+		seen += ids(page.Record)
+		page.Record.Release()
+		if int64(offset+2) >= page.Total {
+			break
+		}
+	}
+	if seen != "[1 2][3]" {
+		t.Errorf("expected pages [1 2][3], got %s", seen)
+	}
+
+	page, err := lb.QueryPage(ctx, "SELECT id FROM data", 10, 2, WithPassword(password))
+	if err != nil {
+		t.Fatalf("QueryPage past the end: %v", err)
+	}
+	if page.Record.NumRows() != 0 || page.Total != 3 {
+		t.Errorf("expected an empty page of 3 rows, got %d rows of %d", page.Record.NumRows(), page.Total)
+	}
+	page.Record.Release()
+}
@@ -0,0 +1,344 @@
+package lockbox
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/TFMV/lockbox/pkg/expr"
+	"github.com/TFMV/lockbox/pkg/metadata"
+	"github.com/apache/arrow-go/v18/arrow"
+	"github.com/apache/arrow-go/v18/arrow/array"
+	"github.com/apache/arrow-go/v18/arrow/memory"
+)
+
+// Policy actions
+const (
+	ActionRead  = "read"
+	ActionWrite = "write"
+)
+
+// anonymousPrincipal is used when no principal is given
+const anonymousPrincipal = "anonymous"
+
+// policyVars are the identifiers available to policy expressions besides
+// the row's columns
+var policyVars = []string{"principal", "action"}
+
+// WithPrincipal sets the user, role or service that policy rules are
+// evaluated for
+func WithPrincipal(name string) Option {
+	return func(o *Options) {
+		o.Principal = name
+	}
+}
+
+// AddPolicyRule adds a rule to the lockbox access policy. Conditions, row
+// filters and masks are expressions that may refer to stored columns and to
+// the principal and action being checked.
+func (lb *Lockbox) AddPolicyRule(rule metadata.PolicyRule) error {
+	meta := lb.file.Metadata()
+
+	rule.Name = strings.ToLower(strings.TrimSpace(rule.Name))
+	if meta.AccessPolicy != nil {
+		for _, existing := range meta.AccessPolicy.Rules {
+			if existing.Name == rule.Name {
+				return fmt.Errorf("policy rule %s already exists", rule.Name)
+			}
+		}
+	}
+	if err := checkPolicyRule(rule, meta.Schema); err != nil {
+		return err
+	}
+
+	now := time.Now()
+	if meta.AccessPolicy == nil {
+		meta.AccessPolicy = &metadata.AccessPolicy{Version: 1, CreatedAt: now}
+	}
+	meta.AccessPolicy.Rules = append(meta.AccessPolicy.Rules, rule)
+	meta.AccessPolicy.ModifiedAt = now
+	meta.LogAccess("system", "add-policy-rule", rule.Name, true, "")
+	return lb.file.SaveMetadata()
+}
+
+// checkPolicyRule checks the name, actions and expressions of a rule
+// against the schema it applies to
+func checkPolicyRule(rule metadata.PolicyRule, schema *arrow.Schema) error {
+	if !viewNamePattern.MatchString(rule.Name) {
+		return fmt.Errorf("invalid rule name %q", rule.Name)
+	}
+	for _, action := range rule.Actions {
+		if action != ActionRead && action != ActionWrite {
+			return fmt.Errorf("unknown action %q", action)
+		}
+	}
+
+	if rule.Condition != "" {
+		if _, err := parsePolicyExpr(rule.Condition, nil); err != nil {
+			return fmt.Errorf("invalid condition: %w", err)
+		}
+	}
+	if rule.RowFilter != "" {
+		if _, err := parsePolicyExpr(rule.RowFilter, schema); err != nil {
+			return fmt.Errorf("invalid row filter: %w", err)
+		}
+	}
+	for col, mask := range rule.Masks {
+		if err := checkColumns(schema, []string{col}); err != nil {
+			return fmt.Errorf("invalid mask: %w", err)
+		}
+		if _, err := parsePolicyExpr(mask, schema); err != nil {
+			return fmt.Errorf("invalid mask for %s: %w", col, err)
+		}
+	}
+	return nil
+}
+
+// RemovePolicyRule deletes a rule from the access policy
+func (lb *Lockbox) RemovePolicyRule(name string) error {
+	meta := lb.file.Metadata()
+	name = strings.ToLower(name)
+	if meta.AccessPolicy != nil {
+		for i, rule := range meta.AccessPolicy.Rules {
+			if rule.Name == name {
+				meta.AccessPolicy.Rules = append(meta.AccessPolicy.Rules[:i], meta.AccessPolicy.Rules[i+1:]...)
+				meta.AccessPolicy.ModifiedAt = time.Now()
+				meta.LogAccess("system", "remove-policy-rule", name, true, "")
+				return lb.file.SaveMetadata()
+			}
+		}
+	}
+	return fmt.Errorf("policy rule %s not found", name)
+}
+
+// PolicyRules returns the rules of the access policy
+func (lb *Lockbox) PolicyRules() []metadata.PolicyRule {
+	policy := lb.file.Metadata().AccessPolicy
+	if policy == nil {
+		return nil
+	}
+	return append([]metadata.PolicyRule(nil), policy.Rules...)
+}
+
+// parsePolicyExpr parses a policy expression and, when a schema is given,
+// checks that every identifier is a stored column or a policy variable.
+// Without a schema only policy variables may be used.
+func parsePolicyExpr(src string, schema *arrow.Schema) (*expr.Expr, error) {
+	e, err := expr.Parse(src)
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range e.Columns() {
+		if contains(policyVars, name) {
+			continue
+		}
+		if schema == nil || len(schema.FieldIndices(name)) == 0 {
+			return nil, fmt.Errorf("unknown identifier %s", name)
+		}
+	}
+	return e, nil
+}
+
+// policyScope holds the row filters and masks that apply to a principal
+type policyScope struct {
+	principal string
+	filters   []*expr.Expr
+	masks     map[string]*expr.Expr
+}
+
+// authorize evaluates the policy for principal and action. It returns an
+// error when access is denied and, for reads, the filters and masks to
+// apply; the scope is nil when there is nothing to apply.
+func authorize(meta *metadata.Metadata, principal, action string) (*policyScope, error) {
+	policy := meta.AccessPolicy
+	if policy == nil {
+		return nil, nil
+	}
+	if principal == "" {
+		principal = anonymousPrincipal
+	}
+	vars := expr.MapEnv{"principal": principal, "action": action}
+
+	for _, cond := range policy.Conditions {
+		src, ok := cond.Value.(string)
+		if cond.Type != "expr" || !ok {
+			continue
+		}
+		if err := checkCondition(src, vars); err != nil {
+			return nil, err
+		}
+	}
+
+	scope := &policyScope{principal: principal, masks: map[string]*expr.Expr{}}
+	for _, rule := range policy.Rules {
+		if !ruleMatches(rule, principal, action) {
+			continue
+		}
+		if rule.Condition != "" {
+			if err := checkCondition(rule.Condition, vars); err != nil {
+				return nil, fmt.Errorf("policy %s: %w", rule.Name, err)
+			}
+		}
+		if action != ActionRead {
+			continue
+		}
+		if rule.RowFilter != "" {
+			e, err := expr.Parse(rule.RowFilter)
+			if err != nil {
+				return nil, fmt.Errorf("policy %s: %w", rule.Name, err)
+			}
+			scope.filters = append(scope.filters, e)
+		}
+		for col, mask := range rule.Masks {
+			e, err := expr.Parse(mask)
+			if err != nil {
+				return nil, fmt.Errorf("policy %s: %w", rule.Name, err)
+			}
+			if _, exists := scope.masks[col]; !exists {
+				scope.masks[col] = e
+			}
+		}
+	}
+
+	if len(scope.filters) == 0 && len(scope.masks) == 0 {
+		return nil, nil
+	}
+	return scope, nil
+}
+
+func ruleMatches(rule metadata.PolicyRule, principal, action string) bool {
+	if len(rule.Principals) > 0 && !contains(rule.Principals, principal) {
+		return false
+	}
+	return len(rule.Actions) == 0 || contains(rule.Actions, action)
+}
+
+func checkCondition(src string, vars expr.MapEnv) error {
+	e, err := expr.Parse(src)
+	if err != nil {
+		return err
+	}
+	ok, err := e.EvalBool(vars)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("%w: %s is not satisfied", ErrAccessDenied, src)
+	}
+	return nil
+}
+
+// columns returns the stored columns the scope needs to read
+func (s *policyScope) columns() []string {
+	var cols []string
+	add := func(e *expr.Expr) {
+		for _, name := range e.Columns() {
+			if !contains(policyVars, name) && !contains(cols, name) {
+				cols = append(cols, name)
+			}
+		}
+	}
+	for _, f := range s.filters {
+		add(f)
+	}
+	for col, m := range s.masks {
+		if !contains(cols, col) {
+			cols = append(cols, col)
+		}
+		add(m)
+	}
+	return cols
+}
+
+// apply filters rows and masks columns of rec. Masks are evaluated against
+// the unmasked row and masked columns hold strings. A row filter that refers
+// to a column missing from rec is an error, so rows are never let through
+// unchecked.
+func (s *policyScope) apply(mem memory.Allocator, rec arrow.Record) (arrow.Record, error) {
+	schema := rec.Schema()
+
+	var keep []int
+	for row := 0; row < int(rec.NumRows()); row++ {
+		env := policyEnv{recordEnv{rec: rec, row: row}, s.principal}
+		allowed := true
+		for _, f := range s.filters {
+			ok, err := f.EvalBool(env)
+			if err != nil {
+				return nil, fmt.Errorf("row filter %s: %w", f, err)
+			}
+			if !ok {
+				allowed = false
+				break
+			}
+		}
+		if allowed {
+			keep = append(keep, row)
+		}
+	}
+
+	fields := append([]arrow.Field{}, schema.Fields()...)
+	cols := append([]arrow.Array{}, rec.Columns()...)
+
+	names := make([]string, 0, len(s.masks))
+	for col := range s.masks {
+		// Columns that were not read need no masking
+		if len(schema.FieldIndices(col)) > 0 {
+			names = append(names, col)
+		}
+	}
+	sort.Strings(names)
+
+	var masked []arrow.Array
+	defer func() { releaseArrays(masked) }()
+	for _, col := range names {
+		mask := s.masks[col]
+		b := array.NewStringBuilder(mem)
+		for row := 0; row < int(rec.NumRows()); row++ {
+			v, err := mask.Eval(policyEnv{recordEnv{rec: rec, row: row}, s.principal})
+			if err != nil {
+				b.Release()
+				return nil, fmt.Errorf("mask for %s: %w", col, err)
+			}
+			if v == nil {
+				b.AppendNull()
+			} else {
+				b.Append(expr.ToString(v))
+			}
+		}
+		arr := b.NewArray()
+		b.Release()
+		masked = append(masked, arr)
+
+		idx := schema.FieldIndices(col)[0]
+		fields[idx] = arrow.Field{Name: col, Type: arrow.BinaryTypes.String, Nullable: true}
+		cols[idx] = arr
+	}
+
+	out := array.NewRecord(arrow.NewSchema(fields, nil), cols, rec.NumRows())
+	if len(keep) == int(rec.NumRows()) {
+		return out, nil
+	}
+	defer out.Release()
+	return takeRows(mem, out, keep), nil
+}
+
+// policyEnv exposes a row plus the principal to policy expressions
+type policyEnv struct {
+	recordEnv
+	principal string
+}
+
+// Lookup implements expr.Env
+func (e policyEnv) Lookup(name string) (interface{}, bool) {
+	if v, ok := e.recordEnv.Lookup(name); ok {
+		return v, true
+	}
+	switch name {
+	case "principal":
+		return e.principal, true
+	case "action":
+		return ActionRead, true
+	}
+	return nil, false
+}
@@ -0,0 +1,72 @@
+package lockbox
+
+import (
+	"context"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/TFMV/lockbox/pkg/metadata"
+	"github.com/apache/arrow-go/v18/arrow/array"
+)
+
+func TestPolicyRules(t *testing.T) {
+	tmpFile := "/tmp/test_lockbox_policy.lbx"
+	defer os.Remove(tmpFile)
+
+	password := "test_password_123"
+	lb := newQueryTestLockbox(t, tmpFile, password)
+	defer lb.Close()
+
+	err := lb.AddPolicyRule(metadata.PolicyRule{
+		Name:       "analysts",
+		Principals: []string{"analyst"},
+		Actions:    []string{ActionRead},
+		RowFilter:  "score > 20",
+		Masks:      map[string]string{"name": "substr(name, 1, 1) || '***'"},
+	})
+	if err != nil {
+		t.Fatalf("add rule: %v", err)
+	}
+	if err := lb.AddPolicyRule(metadata.PolicyRule{Name: "writers", Actions: []string{ActionWrite}, Condition: "principal = 'etl'"}); err != nil {
+		t.Fatalf("add rule: %v", err)
+	}
+	if err := lb.AddPolicyRule(metadata.PolicyRule{Name: "bad", RowFilter: "missing = 1"}); err == nil {
+		t.Fatalf("expected error for unknown column in row filter")
+	}
+
+	ctx := context.Background()
+	res, err := lb.Query(ctx, "SELECT name FROM data ORDER BY name", WithPassword(password), WithPrincipal("analyst"))
+	if err != nil {
+		t.Fatalf("query: %v", err)
+	}
+	defer res.Release()
+	names := res.Column(0).(*array.String)
+	if res.NumRows() != 2 || names.Value(0) != "b***" || names.Value(1) != "c***" {
+		t.Fatalf("unexpected policy result: %v", names)
+	}
+
+	// Filters see masked values, so the original name cannot be probed
+	probe, err := lb.Query(ctx, "SELECT id FROM data WHERE name = 'bob'", WithPassword(password), WithPrincipal("analyst"))
+	if err != nil {
+		t.Fatalf("query: %v", err)
+	}
+	defer probe.Release()
+	if probe.NumRows() != 0 {
+		t.Fatalf("expected masked column to hide original values")
+	}
+
+	full, err := lb.Query(ctx, "SELECT name FROM data", WithPassword(password), WithPrincipal("admin"))
+	if err != nil {
+		t.Fatalf("query: %v", err)
+	}
+	defer full.Release()
+	if full.NumRows() != 3 {
+		t.Fatalf("expected 3 rows for admin, got %d", full.NumRows())
+	}
+
+	err = lb.Write(ctx, full, WithPassword(password), WithPrincipal("analyst"))
+	if err == nil || !strings.Contains(err.Error(), "writers") {
+		t.Fatalf("expected write to be denied, got %v", err)
+	}
+}
@@ -0,0 +1,277 @@
+package lockbox
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/apache/arrow-go/v18/arrow"
+)
+
+// wherePredicate is a node of a WHERE expression tree. Inner nodes join
+// their Terms with AND or OR; leaves compare Col against Val with Op. When
+// Op is IN they test membership in the literal list Vals or in the result
+// of the subquery Sub, when it is LIKE they match the pattern Like, and
+// when it is ST_DWITHIN or ST_INBBOX they test the point column Col
+// against Geo. With a Path they test the value json_extract finds in Col.
+type wherePredicate struct {
+	Op    string
+	Terms []*wherePredicate
+	Col   string
+	Val   string
+	Vals  []string
+	Sub   *parsedQuery
+	Set   map[string]struct{} // values of Sub, filled in before evaluation
+	Like  *regexp.Regexp
+	Geo   *spatialFilter
+	Path  *jsonPath
+}
+
+func (w *wherePredicate) isLeaf() bool {
+	return w.Op != "AND" && w.Op != "OR"
+}
+
+// parseOr parses a WHERE expression. AND binds tighter than OR, and
+// parentheses group.
+func (p *queryParser) parseOr() (*wherePredicate, error) {
+	return p.parseJoined("OR", p.parseAnd)
+}
+
+func (p *queryParser) parseAnd() (*wherePredicate, error) {
+	return p.parseJoined("AND", p.parseCondition)
+}
+
+// parseJoined parses one or more operands separated by the keyword kw
+func (p *queryParser) parseJoined(kw string, operand func() (*wherePredicate, error)) (*wherePredicate, error) {
+	first, err := operand()
+	if err != nil {
+		return nil, err
+	}
+	if !p.peek().isKeyword(kw) {
+		return first, nil
+	}
+	node := &wherePredicate{Op: kw, Terms: []*wherePredicate{first}}
+	for p.acceptKeyword(kw) {
+		term, err := operand()
+		if err != nil {
+			return nil, err
+		}
+		node.Terms = append(node.Terms, term)
+	}
+	return node, nil
+}
+
+// parseCondition parses a parenthesized expression, "col IN (subquery)",
+// "col IN (value, ...)", "col LIKE 'pattern'", "col op value" or a spatial
+// predicate, where col may be json_extract(col, 'path')
+func (p *queryParser) parseCondition() (*wherePredicate, error) {
+	if p.peek().Kind == tokLParen {
+		p.next()
+		inner, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if _, err := p.expect(tokRParen, "')' in WHERE clause"); err != nil {
+			return nil, err
+		}
+		return inner, nil
+	}
+
+	col, err := p.expect(tokIdent, "column in WHERE clause")
+	if err != nil || col.isKeyword("AND") || col.isKeyword("OR") {
+		return nil, fmt.Errorf("invalid WHERE clause")
+	}
+	if isSpatial(col.upper()) && p.peek().Kind == tokLParen {
+		return p.parseSpatial(col)
+	}
+	leaf := &wherePredicate{Col: strings.ToLower(col.Text)}
+	if col.isKeyword("JSON_EXTRACT") && p.peek().Kind == tokLParen {
+		if leaf.Col, leaf.Path, err = p.parseJSONExtract(); err != nil {
+			return nil, err
+		}
+	}
+
+	if p.acceptKeyword("IN") {
+		if _, err := p.expect(tokLParen, "'(' after IN"); err != nil {
+			return nil, err
+		}
+		leaf.Op = "IN"
+		if !p.peek().isKeyword("SELECT") {
+			if leaf.Vals, err = p.parseValueList(); err != nil {
+				return nil, err
+			}
+			return leaf, nil
+		}
+		sub, err := p.parseSelect()
+		if err != nil {
+			return nil, fmt.Errorf("invalid subquery: %w", err)
+		}
+		if _, err := p.expect(tokRParen, "')' after subquery"); err != nil {
+			return nil, err
+		}
+		leaf.Sub = sub
+		return leaf, nil
+	}
+
+	if p.acceptKeyword("LIKE") {
+		pattern, err := p.expect(tokString, "quoted pattern after LIKE")
+		if err != nil {
+			return nil, err
+		}
+		leaf.Op = "LIKE"
+		leaf.Val = pattern.Text
+		leaf.Like = likePattern(pattern.Text)
+		return leaf, nil
+	}
+
+	op := p.next()
+	val := p.next()
+	if op.Kind != tokOp || (val.Kind != tokNumber && val.Kind != tokString && val.Kind != tokIdent) {
+		return nil, fmt.Errorf("invalid WHERE clause")
+	}
+	leaf.Op = op.Text
+	leaf.Val = val.Text
+	return leaf, nil
+}
+
+// parseValueList parses the literals of "IN (value, ...)" up to and
+// including the closing parenthesis
+func (p *queryParser) parseValueList() ([]string, error) {
+	var vals []string
+	for {
+		val := p.next()
+		if val.Kind != tokNumber && val.Kind != tokString {
+			return nil, fmt.Errorf("invalid query: expected a number or quoted string in IN list")
+		}
+		vals = append(vals, val.Text)
+		if p.peek().Kind != tokComma {
+			break
+		}
+		p.next()
+	}
+	if _, err := p.expect(tokRParen, "')' after IN list"); err != nil {
+		return nil, err
+	}
+	return vals, nil
+}
+
+// likePattern compiles a LIKE pattern, where % matches any run of
+// characters and _ matches a single character
+func likePattern(pattern string) *regexp.Regexp {
+	var sb strings.Builder
+	sb.WriteString("(?s)^")
+	for _, r := range pattern {
+		switch r {
+		case '%':
+			sb.WriteString(".*")
+		case '_':
+			sb.WriteString(".")
+		default:
+			sb.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	sb.WriteString("$")
+	return regexp.MustCompile(sb.String())
+}
+
+// columns appends the columns the predicate reads to cols, skipping ones
+// already present
+func (w *wherePredicate) columns(cols []string) []string {
+	if !w.isLeaf() {
+		for _, t := range w.Terms {
+			cols = t.columns(cols)
+		}
+		return cols
+	}
+	if !contains(cols, w.Col) {
+		cols = append(cols, w.Col)
+	}
+	return cols
+}
+
+// resolveSubqueries runs the subquery of every IN leaf
+func (qe *queryExec) resolveSubqueries(w *wherePredicate) error {
+	if !w.isLeaf() {
+		for _, t := range w.Terms {
+			if err := qe.resolveSubqueries(t); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if w.Sub != nil {
+		set, err := qe.subqueryValues(w.Sub)
+		if err != nil {
+			return err
+		}
+		w.Set = set
+	}
+	return nil
+}
+
+// bind resolves the columns of the predicate against rec so it can be
+// evaluated row by row
+func (w *wherePredicate) bind(rec arrow.Record) (func(row int) bool, error) {
+	if !w.isLeaf() {
+		terms := make([]func(int) bool, len(w.Terms))
+		for i, t := range w.Terms {
+			f, err := t.bind(rec)
+			if err != nil {
+				return nil, err
+			}
+			terms[i] = f
+		}
+		if w.Op == "AND" {
+			return func(row int) bool {
+				for _, f := range terms {
+					if !f(row) {
+						return false
+					}
+				}
+				return true
+			}, nil
+		}
+		return func(row int) bool {
+			for _, f := range terms {
+				if f(row) {
+					return true
+				}
+			}
+			return false
+		}, nil
+	}
+
+	indices := rec.Schema().FieldIndices(w.Col)
+	if len(indices) == 0 {
+		return nil, fmt.Errorf("unknown column %q in WHERE clause", w.Col)
+	}
+	col := rec.Column(indices[0])
+	if w.Path != nil {
+		extracted, err := w.Path.extractColumn(w.Col, col, w.numeric())
+		if err != nil {
+			return nil, err
+		}
+		col = extracted
+	}
+	switch {
+	case w.Geo != nil:
+		return w.bindSpatial(rec.Schema().Field(indices[0]), col)
+	case w.Op == "IN" && w.Sub != nil:
+		return func(row int) bool { return inValueSet(col, row, w.Set) }, nil
+	case w.Op == "IN":
+		return func(row int) bool {
+			for _, v := range w.Vals {
+				if matchValue(col, row, "=", v) {
+					return true
+				}
+			}
+			return false
+		}, nil
+	case w.Op == "LIKE":
+		// Numbers are matched in their printed form
+		return func(row int) bool {
+			return !col.IsNull(row) && w.Like.MatchString(fmt.Sprint(getValue(col, row)))
+		}, nil
+	}
+	return func(row int) bool { return matchValue(col, row, w.Op, w.Val) }, nil
+}
@@ -0,0 +1,110 @@
+package lockbox
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/apache/arrow-go/v18/arrow/array"
+)
+
+func TestQueryCompoundWhere(t *testing.T) {
+	tmpFile := "/tmp/test_lockbox_predicate.lbx"
+	defer os.Remove(tmpFile)
+
+	password := "test_password_123"
+	lb := newQueryTestLockbox(t, tmpFile, password)
+	defer lb.Close()
+
+	ctx := context.Background()
+	cases := []struct {
+		query string
+		ids   []int64
+	}{
+		{"SELECT id FROM data WHERE score > 20 AND score < 50 ORDER BY id", []int64{3}},
+		{"SELECT id FROM data WHERE name = 'alice' OR score > 50 ORDER BY id", []int64{1, 2}},
+		// AND binds tighter than OR
+		{"SELECT id FROM data WHERE score > 20 OR id = 1 AND score > 50 ORDER BY id", []int64{2, 3}},
+		{"SELECT id FROM data WHERE (score > 20 OR id = 1) AND score > 50 ORDER BY id", []int64{2}},
+		{"SELECT id FROM data WHERE (id = 1 OR id = 3) AND (score > 20 OR name = 'bob') ORDER BY id", []int64{3}},
+		{"SELECT id FROM data WHERE id IN (SELECT id FROM data WHERE score > 20 AND score < 40) OR name = 'alice' ORDER BY id", []int64{1, 3}},
+	}
+	for _, tc := range cases {
+		res, err := lb.Query(ctx, tc.query, WithPassword(password))
+		if err != nil {
+			t.Fatalf("%s: %v", tc.query, err)
+		}
+		ids := res.Column(0).(*array.Int64)
+		if ids.Len() != len(tc.ids) {
+			t.Errorf("%s: expected ids %v, got %v", tc.query, tc.ids, ids)
+		} else {
+			for i, want := range tc.ids {
+				if ids.Value(i) != want {
+					t.Errorf("%s: expected ids %v, got %v", tc.query, tc.ids, ids)
+					break
+				}
+			}
+		}
+		res.Release()
+	}
+
+	for _, q := range []string{
+		"SELECT id FROM data WHERE id = 1 AND",
+		"SELECT id FROM data WHERE (id = 1 OR id = 2",
+		"SELECT id FROM data WHERE id = 1 OR AND id = 2",
+	} {
+		if _, err := lb.Query(ctx, q, WithPassword(password)); err == nil {
+			t.Errorf("%s: expected an error", q)
+		}
+	}
+}
+
+func TestQueryLikeAndInList(t *testing.T) {
+	tmpFile := "/tmp/test_lockbox_like.lbx"
+	defer os.Remove(tmpFile)
+
+	password := "test_password_123"
+	lb := newQueryTestLockbox(t, tmpFile, password)
+	defer lb.Close()
+
+	ctx := context.Background()
+	cases := []struct {
+		query string
+		ids   []int64
+	}{
+		{"SELECT id FROM data WHERE name LIKE 'a%' ORDER BY id", []int64{1}},
+		{"SELECT id FROM data WHERE name LIKE '%o%' ORDER BY id", []int64{2, 3}},
+		{"SELECT id FROM data WHERE name LIKE '_ob' ORDER BY id", []int64{2}},
+		{"SELECT id FROM data WHERE name LIKE 'bo' ORDER BY id", nil},
+		{"SELECT id FROM data WHERE score LIKE '5%' ORDER BY id", []int64{2}},
+		{"SELECT id FROM data WHERE name IN ('alice', 'carol', 'dave') ORDER BY id", []int64{1, 3}},
+		{"SELECT id FROM data WHERE id IN (2, 3) ORDER BY id", []int64{2, 3}},
+		{"SELECT id FROM data WHERE score IN (55) OR name LIKE 'al%' ORDER BY id", []int64{1, 2}},
+	}
+	for _, tc := range cases {
+		res, err := lb.Query(ctx, tc.query, WithPassword(password))
+		if err != nil {
+			t.Fatalf("%s: %v", tc.query, err)
+		}
+		ids := res.Column(0).(*array.Int64)
+		got := make([]int64, ids.Len())
+		for i := range got {
+			got[i] = ids.Value(i)
+		}
+		if fmt.Sprint(got) != fmt.Sprint(append([]int64{}, tc.ids...)) {
+			t.Errorf("%s: expected ids %v, got %v", tc.query, tc.ids, got)
+		}
+		res.Release()
+	}
+
+	for _, q := range []string{
+		"SELECT id FROM data WHERE id IN ()",
+		"SELECT id FROM data WHERE id IN (1, 2",
+		"SELECT id FROM data WHERE name LIKE bob",
+	} {
+		if _, err := lb.Query(ctx, q, WithPassword(password)); err == nil {
+			t.Errorf("%s: expected an error", q)
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package lockbox
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/TFMV/lockbox/pkg/codec"
+	"github.com/TFMV/lockbox/pkg/crypto"
+	"github.com/TFMV/lockbox/pkg/metadata"
+)
+
+// presetMaskRule names the policy rule holding the masks of a preset
+const presetMaskRule = "preset_masks"
+
+// Preset is a named set of security settings for new files, so a team can
+// create every file the same way, e.g. a "pii-strict" profile that pins the
+// crypto module and compression and masks identifying columns.
+type Preset struct {
+	Name string
+	// CryptoModule is the module files are created with; Create fails when
+	// WithCryptoModule asks for another one
+	CryptoModule string
+	// KeyDerivation is the password KDF the profile requires,
+	// PBKDF2-HMAC-SHA256 or Argon2id with default costs unless WithArgon2id
+	// sets them
+	KeyDerivation string
+	// Codec is recorded in the file and compresses every write that does
+	// not pick a codec itself
+	Codec string
+	// Masks maps columns to mask expressions applied to every read, as a
+	// policy rule named preset_masks
+	Masks map[string]string
+}
+
+// WithPreset makes Create apply the settings of a profile
+func WithPreset(p *Preset) Option {
+	return func(o *Options) {
+		o.Preset = p
+	}
+}
+
+// check validates the preset and reconciles it with the options of Create
+func (p *Preset) check(options *Options) error {
+	if p.CryptoModule != "" {
+		if options.CryptoModule != "" && options.CryptoModule != p.CryptoModule {
+			return fmt.Errorf("profile %s requires crypto module %s, not %s", p.Name, p.CryptoModule, options.CryptoModule)
+		}
+		if _, ok := crypto.GetModule(p.CryptoModule); !ok {
+			return fmt.Errorf("profile %s: crypto module %q is not registered", p.Name, p.CryptoModule)
+		}
+		options.CryptoModule = p.CryptoModule
+	}
+	switch strings.ToLower(strings.ReplaceAll(p.KeyDerivation, "_", "-")) {
+	case "":
+	case "pbkdf2", "pbkdf2-sha256", "pbkdf2-hmac-sha256":
+		if options.Argon2 != nil {
+			return fmt.Errorf("profile %s requires PBKDF2 key derivation, not Argon2id", p.Name)
+		}
+	case "argon2id":
+		if options.Argon2 == nil {
+			WithArgon2id(metadata.Argon2Params{})(options)
+		}
+	default:
+		return fmt.Errorf("profile %s: unsupported key derivation %q, lockbox supports PBKDF2-HMAC-SHA256 and Argon2id", p.Name, p.KeyDerivation)
+	}
+	if p.Codec != "" && p.Codec != codec.None {
+		if _, ok := codec.Get(p.Codec); !ok {
+			return fmt.Errorf("profile %s: codec %s is not registered", p.Name, p.Codec)
+		}
+	}
+	return nil
+}
+
+// applyPreset records the preset in a new file and adds its masks
+func (lb *Lockbox) applyPreset(p *Preset) error {
+	meta := lb.file.Metadata()
+	meta.Preset = &metadata.Preset{Name: p.Name, Codec: p.Codec}
+	meta.LogAccess("system", "apply-preset", p.Name, true, "")
+	if len(p.Masks) > 0 {
+		rule := metadata.PolicyRule{Name: presetMaskRule, Actions: []string{ActionRead}, Masks: p.Masks}
+		if err := lb.AddPolicyRule(rule); err != nil {
+			return fmt.Errorf("profile %s: %w", p.Name, err)
+		}
+		return nil
+	}
+	return lb.file.SaveMetadata()
+}
+
+// codecFor returns the codec a write with options uses: the one asked for,
+// or else the file's default codec, or else the codec of the file's preset
+func (lb *Lockbox) codecFor(options *Options) string {
+	if options.Codec == "" {
+		meta := lb.file.Metadata()
+		if meta.Encryption.DefaultCodec != "" {
+			return meta.Encryption.DefaultCodec
+		}
+		if p := meta.Preset; p != nil {
+			return p.Codec
+		}
+	}
+	return options.Codec
+}
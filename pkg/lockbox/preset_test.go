@@ -0,0 +1,87 @@
+package lockbox
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/apache/arrow-go/v18/arrow"
+	"github.com/apache/arrow-go/v18/arrow/array"
+	"github.com/apache/arrow-go/v18/arrow/memory"
+)
+
+func TestPreset(t *testing.T) {
+	dir := t.TempDir()
+	password := "test_password_123"
+	schema := arrow.NewSchema([]arrow.Field{
+		{Name: "id", Type: arrow.PrimitiveTypes.Int64},
+		{Name: "ssn", Type: arrow.BinaryTypes.String},
+	}, nil)
+	preset := &Preset{
+		Name:          "pii-strict",
+		CryptoModule:  "default",
+		KeyDerivation: "pbkdf2-sha256",
+		Codec:         "gzip",
+		Masks:         map[string]string{"ssn": "'***-**-' || substr(ssn, 8, 4)"},
+	}
+
+	bad := []*Preset{
+		{Name: "scrypt", KeyDerivation: "scrypt"},
+		{Name: "codec", Codec: "missing"},
+	}
+	for _, p := range bad {
+		path := filepath.Join(dir, p.Name+".lbx")
+		if _, err := Create(path, schema, WithPassword(password), WithPreset(p)); err == nil {
+			t.Errorf("expected profile %s to be rejected", p.Name)
+		}
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Errorf("expected no file to be left behind for profile %s", p.Name)
+		}
+	}
+	conflict := filepath.Join(dir, "conflict.lbx")
+	if _, err := Create(conflict, schema, WithPassword(password), WithPreset(preset), WithCryptoModule("other")); err == nil {
+		t.Errorf("expected a crypto module conflicting with the profile to be rejected")
+	}
+
+	path := filepath.Join(dir, "pii.lbx")
+	lb, err := Create(path, schema, WithPassword(password), WithPreset(preset))
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	b := array.NewRecordBuilder(memory.NewGoAllocator(), schema)
+	b.Field(0).(*array.Int64Builder).Append(1)
+	b.Field(1).(*array.StringBuilder).Append("123-45-6789")
+	rec := b.NewRecord()
+	b.Release()
+	defer rec.Release()
+	ctx := context.Background()
+	if err := lb.Write(ctx, rec, WithPassword(password)); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	lb.Close()
+
+	lb, err = Open(path, WithPassword(password))
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer lb.Close()
+
+	if info, err := lb.Info(); err != nil || info.Preset != "pii-strict" {
+		t.Errorf("expected profile pii-strict in info, got %+v (%v)", info, err)
+	}
+	for _, bi := range lb.file.Metadata().BlockInfo {
+		if bi.Codec != "gzip" {
+			t.Errorf("expected block of %s to use the profile codec, got %q", bi.ColumnName, bi.Codec)
+		}
+	}
+
+	res, err := lb.Query(ctx, "SELECT ssn FROM data", WithPassword(password))
+	if err != nil {
+		t.Fatalf("query: %v", err)
+	}
+	defer res.Release()
+	if got := res.Column(0).(*array.String).Value(0); got != "***-**-6789" {
+		t.Errorf("expected profile mask to apply, got %q", got)
+	}
+}
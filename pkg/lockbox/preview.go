@@ -0,0 +1,280 @@
+package lockbox
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/TFMV/lockbox/pkg/metadata"
+	"github.com/apache/arrow-go/v18/arrow"
+	"github.com/apache/arrow-go/v18/arrow/array"
+	"github.com/apache/arrow-go/v18/arrow/ipc"
+	"github.com/apache/arrow-go/v18/arrow/memory"
+)
+
+// ErrPreviewNotApproved is returned when a preview is written for a lockbox
+// whose policy does not approve one
+var ErrPreviewNotApproved = errors.New("preview not approved")
+
+// previewSuffix names the sidecar of data.lbx data.preview.arrow
+const previewSuffix = ".preview.arrow"
+
+// PreviewPath returns the path of the preview sidecar of a lockbox file
+func PreviewPath(filename string) string {
+	return strings.TrimSuffix(filename, ".lbx") + previewSuffix
+}
+
+// AllowPreview approves writing a plaintext preview of at most maxRows rows
+// of columns next to the file. The preview is unencrypted and readable
+// without keys, so only columns that are not sensitive belong in it.
+// Approving again replaces the earlier approval; an existing sidecar is
+// kept until it is written again or revoked.
+func (lb *Lockbox) AllowPreview(columns []string, maxRows int, opts ...Option) error {
+	options := &Options{}
+	for _, opt := range opts {
+		opt(options)
+	}
+	meta := lb.file.Metadata()
+	if len(columns) == 0 {
+		return fmt.Errorf("at least one column is required")
+	}
+	if err := checkColumns(meta.Schema, columns); err != nil {
+		return err
+	}
+	if maxRows <= 0 {
+		return fmt.Errorf("max rows must be positive")
+	}
+	if _, err := lb.checkPolicy(options.Principal, ActionWrite); err != nil {
+		return err
+	}
+
+	principal := options.Principal
+	if principal == "" {
+		principal = anonymousPrincipal
+	}
+	now := time.Now()
+	if meta.AccessPolicy == nil {
+		meta.AccessPolicy = &metadata.AccessPolicy{Version: 1, CreatedAt: now}
+	}
+	meta.AccessPolicy.Preview = &metadata.PreviewPolicy{
+		Columns:    append([]string(nil), columns...),
+		MaxRows:    maxRows,
+		ApprovedBy: principal,
+		ApprovedAt: now,
+	}
+	meta.AccessPolicy.ModifiedAt = now
+	meta.LogAccess(principal, "allow-preview", strings.Join(columns, ","), true, fmt.Sprintf("max %d rows", maxRows))
+	return lb.file.SaveMetadata()
+}
+
+// RevokePreview withdraws the approval of a preview and deletes the sidecar
+func (lb *Lockbox) RevokePreview(opts ...Option) error {
+	options := &Options{}
+	for _, opt := range opts {
+		opt(options)
+	}
+	meta := lb.file.Metadata()
+	if meta.AccessPolicy == nil || meta.AccessPolicy.Preview == nil {
+		return fmt.Errorf("no preview is approved")
+	}
+	if _, err := lb.checkPolicy(options.Principal, ActionWrite); err != nil {
+		return err
+	}
+	if err := os.Remove(PreviewPath(lb.file.Path())); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to remove preview: %w", err)
+	}
+
+	principal := options.Principal
+	if principal == "" {
+		principal = anonymousPrincipal
+	}
+	meta.AccessPolicy.Preview = nil
+	meta.AccessPolicy.ModifiedAt = time.Now()
+	meta.LogAccess(principal, "revoke-preview", "", true, "")
+	return lb.file.SaveMetadata()
+}
+
+// PreviewPolicy returns the approval of a preview, or nil
+func (lb *Lockbox) PreviewPolicy() *metadata.PreviewPolicy {
+	policy := lb.file.Metadata().AccessPolicy
+	if policy == nil || policy.Preview == nil {
+		return nil
+	}
+	p := *policy.Preview
+	p.Columns = append([]string(nil), p.Columns...)
+	return &p
+}
+
+// PreviewResult describes a preview written by WritePreview
+type PreviewResult struct {
+	Path    string   `json:"path"`
+	Columns []string `json:"columns"`
+	Rows    int64    `json:"rows"`
+}
+
+// WritePreview writes the first rows of the approved columns to the
+// sidecar at PreviewPath as an unencrypted Arrow IPC file, so catalogs can
+// show samples without keys. rows is capped at the approved maximum; zero
+// takes the maximum. The rows are read as the principal of opts, so row
+// filters and masks apply. Every attempt is recorded in the audit log, and
+// it fails with ErrPreviewNotApproved unless AllowPreview approved one.
+func (lb *Lockbox) WritePreview(ctx context.Context, rows int, opts ...Option) (*PreviewResult, error) {
+	options := &Options{}
+	for _, opt := range opts {
+		opt(options)
+	}
+	meta := lb.file.Metadata()
+	principal := options.Principal
+	if principal == "" {
+		principal = anonymousPrincipal
+	}
+	path := PreviewPath(lb.file.Path())
+
+	var approval *metadata.PreviewPolicy
+	if meta.AccessPolicy != nil {
+		approval = meta.AccessPolicy.Preview
+	}
+	if approval == nil {
+		meta.LogAccess(principal, "write-preview", path, false, ErrPreviewNotApproved.Error())
+		return nil, errors.Join(ErrPreviewNotApproved, lb.file.SaveMetadata())
+	}
+	if rows <= 0 || rows > approval.MaxRows {
+		rows = approval.MaxRows
+	}
+
+	result, err := lb.writePreview(ctx, path, approval.Columns, rows, opts)
+	if err != nil {
+		meta.LogAccess(principal, "write-preview", path, false, err.Error())
+		return nil, errors.Join(err, lb.file.SaveMetadata())
+	}
+	meta.LogAccess(principal, "write-preview", path, true,
+		fmt.Sprintf("%d rows of %s", result.Rows, strings.Join(result.Columns, ",")))
+	if err := lb.file.SaveMetadata(); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// writePreview scans the first rows of columns into a temporary file and
+// moves it over the sidecar
+func (lb *Lockbox) writePreview(ctx context.Context, path string, columns []string, rows int, opts []Option) (*PreviewResult, error) {
+	scanner, err := lb.NewScanner(ctx, ScanOptions{Columns: columns, BatchSize: rows}, opts...)
+	if err != nil {
+		return nil, err
+	}
+	defer scanner.Release()
+
+	md := arrow.NewMetadata(
+		[]string{"lockbox.preview.source", "lockbox.preview.created"},
+		[]string{filepath.Base(lb.file.Path()), time.Now().UTC().Format(time.RFC3339)},
+	)
+	schema := arrow.NewSchema(scanner.Schema().Fields(), &md)
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create preview: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	w, err := ipc.NewFileWriter(tmp, ipc.WithSchema(schema))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create arrow writer: %w", err)
+	}
+	var written int64
+	for written < int64(rows) && scanner.Next() {
+		rec := scanner.Record()
+		n := min(rec.NumRows(), int64(rows)-written)
+		slice := rec.NewSlice(0, n)
+		part := array.NewRecord(schema, slice.Columns(), n)
+		err := w.Write(part)
+		part.Release()
+		slice.Release()
+		if err != nil {
+			w.Close()
+			return nil, fmt.Errorf("failed to write preview: %w", err)
+		}
+		written += n
+	}
+	if err := scanner.Err(); err != nil {
+		w.Close()
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, fmt.Errorf("failed to write preview: %w", err)
+	}
+	if err := tmp.Chmod(0644); err != nil {
+		return nil, err
+	}
+	if err := tmp.Close(); err != nil {
+		return nil, err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return nil, fmt.Errorf("failed to write preview: %w", err)
+	}
+	result := &PreviewResult{Path: path, Rows: written}
+	for _, f := range schema.Fields() {
+		result.Columns = append(result.Columns, f.Name)
+	}
+	return result, nil
+}
+
+// ReadPreview reads the preview sidecar of a lockbox file, or a sidecar
+// named directly. No password is needed.
+func ReadPreview(filename string) (arrow.Record, error) {
+	if !strings.HasSuffix(filename, previewSuffix) {
+		filename = PreviewPath(filename)
+	}
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open preview: %w", err)
+	}
+	defer f.Close()
+
+	mem := memory.NewGoAllocator()
+	r, err := ipc.NewFileReader(f, ipc.WithAllocator(mem))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read preview: %w", err)
+	}
+	defer r.Close()
+
+	recs := make([]arrow.Record, 0, r.NumRecords())
+	defer func() {
+		for _, rec := range recs {
+			rec.Release()
+		}
+	}()
+	for i := 0; i < r.NumRecords(); i++ {
+		rec, err := r.Record(i)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read preview: %w", err)
+		}
+		rec.Retain()
+		recs = append(recs, rec)
+	}
+
+	cols := make([]arrow.Array, r.Schema().NumFields())
+	var rows int64
+	for _, rec := range recs {
+		rows += rec.NumRows()
+	}
+	for i := range cols {
+		chunks := make([]arrow.Array, 0, len(recs))
+		for _, rec := range recs {
+			chunks = append(chunks, rec.Column(i))
+		}
+		var col arrow.Array
+		if len(chunks) == 0 {
+			col = array.MakeArrayOfNull(mem, r.Schema().Field(i).Type, 0)
+		} else if col, err = array.Concatenate(chunks, mem); err != nil {
+			return nil, fmt.Errorf("failed to read preview: %w", err)
+		}
+		defer col.Release()
+		cols[i] = col
+	}
+	return array.NewRecord(r.Schema(), cols, rows), nil
+}
@@ -0,0 +1,97 @@
+package lockbox
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/apache/arrow-go/v18/arrow"
+	"github.com/apache/arrow-go/v18/arrow/array"
+	"github.com/apache/arrow-go/v18/arrow/memory"
+)
+
+func TestPreview(t *testing.T) {
+	password := "test_password_123"
+	ctx := context.Background()
+	schema := arrow.NewSchema([]arrow.Field{
+		{Name: "id", Type: arrow.PrimitiveTypes.Int64},
+		{Name: "region", Type: arrow.BinaryTypes.String},
+		{Name: "ssn", Type: arrow.BinaryTypes.String},
+	}, nil)
+	path := filepath.Join(t.TempDir(), "people.lbx")
+
+	lb, err := Create(path, schema, WithPassword(password))
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	defer lb.Close()
+	for batch := 0; batch < 2; batch++ {
+		b := array.NewRecordBuilder(memory.NewGoAllocator(), schema)
+		b.Field(0).(*array.Int64Builder).AppendValues([]int64{1, 2, 3}, nil)
+		b.Field(1).(*array.StringBuilder).AppendValues([]string{"eu", "us", "eu"}, nil)
+		b.Field(2).(*array.StringBuilder).AppendValues([]string{"111", "222", "333"}, nil)
+		rec := b.NewRecord()
+		b.Release()
+		if err := lb.Write(ctx, rec, WithPassword(password)); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+		rec.Release()
+	}
+
+	if _, err := lb.WritePreview(ctx, 0, WithPassword(password)); !errors.Is(err, ErrPreviewNotApproved) {
+		t.Fatalf("expected an unapproved preview to be refused, got %v", err)
+	}
+	if _, err := os.Stat(PreviewPath(path)); !os.IsNotExist(err) {
+		t.Fatalf("expected no sidecar, got %v", err)
+	}
+	if err := lb.AllowPreview([]string{"region", "missing"}, 5); err == nil {
+		t.Error("expected an unknown column to be refused")
+	}
+	if err := lb.AllowPreview([]string{"id", "region"}, 4, WithPrincipal("steward")); err != nil {
+		t.Fatalf("allow preview: %v", err)
+	}
+
+	// More rows than approved are capped; rows span both writes
+	result, err := lb.WritePreview(ctx, 100, WithPassword(password))
+	if err != nil {
+		t.Fatalf("write preview: %v", err)
+	}
+	if result.Rows != 4 || result.Path != PreviewPath(path) {
+		t.Errorf("expected 4 rows in %s, got %+v", PreviewPath(path), result)
+	}
+
+	rec, err := ReadPreview(path)
+	if err != nil {
+		t.Fatalf("read preview: %v", err)
+	}
+	if rec.NumRows() != 4 || rec.NumCols() != 2 || rec.Schema().HasField("ssn") {
+		t.Errorf("expected 4 rows of id and region, got %d rows of %v", rec.NumRows(), rec.Schema())
+	}
+	if src, _ := rec.Schema().Metadata().GetValue("lockbox.preview.source"); src != "people.lbx" {
+		t.Errorf("expected the source in the schema metadata, got %q", src)
+	}
+	rec.Release()
+
+	var audited []string
+	for _, e := range lb.file.Metadata().AuditTrail.AccessLog {
+		switch e.Action {
+		case "allow-preview", "write-preview", "revoke-preview":
+			audited = append(audited, e.Action)
+		}
+	}
+	if len(audited) != 3 || audited[0] != "write-preview" || audited[1] != "allow-preview" {
+		t.Errorf("expected the refusal, approval and preview to be audited, got %v", audited)
+	}
+
+	if err := lb.RevokePreview(); err != nil {
+		t.Fatalf("revoke preview: %v", err)
+	}
+	if _, err := os.Stat(PreviewPath(path)); !os.IsNotExist(err) {
+		t.Errorf("expected revoke to delete the sidecar, got %v", err)
+	}
+	if lb.PreviewPolicy() != nil {
+		t.Error("expected no approval after revoke")
+	}
+}
@@ -0,0 +1,332 @@
+package lockbox
+
+import (
+	"context"
+	"fmt"
+	"hash/maphash"
+	"math"
+	"math/bits"
+	"regexp"
+	"sort"
+	"time"
+
+	"github.com/TFMV/lockbox/pkg/expr"
+	"github.com/apache/arrow-go/v18/arrow"
+)
+
+// DataProfile holds data quality metrics for the columns of a lockbox
+type DataProfile struct {
+	File        string          `json:"file"`
+	Rows        int64           `json:"rows"`
+	GeneratedAt time.Time       `json:"generatedAt"`
+	Columns     []ColumnProfile `json:"columns"`
+}
+
+// ColumnProfile holds the quality metrics of one column. Distinct counts
+// beyond profileExactDistinct and the top values of columns with more than
+// profileTopCapacity distinct values are estimates, flagged as approximate.
+type ColumnProfile struct {
+	Name           string       `json:"name"`
+	Type           string       `json:"type"`
+	Nulls          int64        `json:"nulls"`
+	NullPercent    float64      `json:"nullPercent"`
+	Distinct       int64        `json:"distinct"`
+	DistinctApprox bool         `json:"distinctApprox,omitempty"`
+	Min            interface{}  `json:"min,omitempty"`
+	Max            interface{}  `json:"max,omitempty"`
+	TopValues      []ValueCount `json:"topValues,omitempty"`
+	TopApprox      bool         `json:"topApprox,omitempty"`
+	// Patterns holds the percentage of non-null string values that match
+	// each known pattern, for the patterns matched at least once
+	Patterns map[string]float64 `json:"patterns,omitempty"`
+}
+
+// ValueCount is a value and the number of rows holding it
+type ValueCount struct {
+	Value string `json:"value"`
+	Count int64  `json:"count"`
+}
+
+const (
+	// profileExactDistinct is the number of distinct values counted
+	// exactly before switching to an estimate
+	profileExactDistinct = 100000
+	// profileTopCapacity is the number of values whose frequency is tracked
+	profileTopCapacity = 1000
+	// defaultTopValues is the number of top values reported by default
+	defaultTopValues = 5
+)
+
+// profilePatterns are the formats string values are checked against
+var profilePatterns = []struct {
+	name string
+	re   *regexp.Regexp
+}{
+	{"email", regexp.MustCompile(`^[^@\s]+@[^@\s]+\.[^@\s]+$`)},
+	{"date", regexp.MustCompile(`^\d{4}-\d{2}-\d{2}$`)},
+	{"datetime", regexp.MustCompile(`^\d{4}-\d{2}-\d{2}[T ]\d{2}:\d{2}(:\d{2}(\.\d+)?)?(Z|[+-]\d{2}:?\d{2})?$`)},
+	{"phone", regexp.MustCompile(`^\+?[\d\s().-]{7,}$`)},
+	{"uuid", regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)},
+}
+
+// WithTopValues sets the number of most frequent values Profile reports
+// per column
+func WithTopValues(n int) Option {
+	return func(o *Options) {
+		o.TopValues = n
+	}
+}
+
+// Profile computes data quality metrics for every stored column, or for
+// the columns selected with WithColumns. Columns are decrypted one at a
+// time and the state kept per column is bounded, so memory use does not
+// grow with the number of distinct values. Access policies apply as for
+// queries; when they filter or mask rows for the principal the whole
+// record is read at once so the policy can be evaluated.
+func (lb *Lockbox) Profile(ctx context.Context, opts ...Option) (*DataProfile, error) {
+	options := &Options{TopValues: defaultTopValues}
+	for _, opt := range opts {
+		opt(options)
+	}
+	if options.Password == "" {
+		return nil, fmt.Errorf("password is required for reading")
+	}
+
+	meta := lb.file.Metadata()
+	scope, err := lb.checkPolicy(options.Principal, ActionRead)
+	if err != nil {
+		return nil, err
+	}
+	reader, err := lb.readerFor(options.Password)
+	if err != nil {
+		return nil, err
+	}
+
+	columns := options.Columns
+	if len(columns) == 0 {
+		for _, f := range meta.Schema.Fields() {
+			columns = append(columns, f.Name)
+		}
+	}
+	for _, name := range columns {
+		if len(meta.Schema.FieldIndices(name)) == 0 {
+			return nil, fmt.Errorf("column %s not found", name)
+		}
+	}
+
+	profile := &DataProfile{File: lb.file.Path(), GeneratedAt: time.Now().UTC()}
+	var whole arrow.Record
+	if scope != nil {
+		rec, err := reader.ReadRecord()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read record: %w", err)
+		}
+		whole, err = scope.apply(lb.Allocator(), rec)
+		rec.Release()
+		if err != nil {
+			return nil, err
+		}
+		defer whole.Release()
+	}
+
+	for _, name := range columns {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		var col arrow.Array
+		if whole != nil {
+			col = whole.Column(whole.Schema().FieldIndices(name)[0])
+			col.Retain()
+		} else {
+			rec, err := reader.ReadColumns([]string{name})
+			if err != nil {
+				return nil, fmt.Errorf("failed to read column %s: %w", name, err)
+			}
+			col = rec.Column(0)
+			col.Retain()
+			rec.Release()
+		}
+		cp := profileColumn(name, col, options.TopValues)
+		profile.Rows = int64(col.Len())
+		col.Release()
+		profile.Columns = append(profile.Columns, cp)
+	}
+	return profile, nil
+}
+
+// profileColumn computes the metrics of one column
+func profileColumn(name string, col arrow.Array, top int) ColumnProfile {
+	cp := ColumnProfile{Name: name, Type: col.DataType().String()}
+	distinct := newDistinctCounter()
+	freq := newFrequentValues(profileTopCapacity)
+	matches := make([]int64, len(profilePatterns))
+	var stringValues int64
+
+	for row := 0; row < col.Len(); row++ {
+		v := exprValue(col, row)
+		if v == nil {
+			cp.Nulls++
+			continue
+		}
+		key := expr.ToString(v)
+		if b, ok := v.([]byte); ok {
+			key = string(b)
+		}
+		distinct.add(key)
+		freq.add(key)
+
+		if _, isBinary := v.([]byte); !isBinary {
+			if cp.Min == nil || compareValues(v, cp.Min) < 0 {
+				cp.Min = v
+			}
+			if cp.Max == nil || compareValues(v, cp.Max) > 0 {
+				cp.Max = v
+			}
+		}
+		if s, ok := v.(string); ok {
+			stringValues++
+			for i, p := range profilePatterns {
+				if p.re.MatchString(s) {
+					matches[i]++
+				}
+			}
+		}
+	}
+
+	if n := col.Len(); n > 0 {
+		cp.NullPercent = 100 * float64(cp.Nulls) / float64(n)
+	}
+	cp.Distinct, cp.DistinctApprox = distinct.count()
+	cp.TopValues, cp.TopApprox = freq.top(top)
+	for i, p := range profilePatterns {
+		if matches[i] > 0 {
+			if cp.Patterns == nil {
+				cp.Patterns = map[string]float64{}
+			}
+			cp.Patterns[p.name] = 100 * float64(matches[i]) / float64(stringValues)
+		}
+	}
+	return cp
+}
+
+// compareValues orders two non-null expression values
+func compareValues(a, b interface{}) int {
+	c, err := expr.Compare(a, b)
+	if err != nil {
+		return 0
+	}
+	return c
+}
+
+// distinctCounter counts distinct values exactly up to
+// profileExactDistinct and estimates larger counts with HyperLogLog
+type distinctCounter struct {
+	exact     map[string]struct{}
+	registers []uint8
+	seed      maphash.Seed
+}
+
+// hllBits selects 2^14 HyperLogLog registers, for a standard error of
+// about 0.8%
+const hllBits = 14
+
+func newDistinctCounter() *distinctCounter {
+	return &distinctCounter{exact: map[string]struct{}{}, seed: maphash.MakeSeed()}
+}
+
+func (d *distinctCounter) add(key string) {
+	if d.exact != nil {
+		d.exact[key] = struct{}{}
+		if len(d.exact) <= profileExactDistinct {
+			return
+		}
+		// Switch to the estimate, carrying over the values seen so far
+		d.registers = make([]uint8, 1<<hllBits)
+		for k := range d.exact {
+			d.addHash(maphash.String(d.seed, k))
+		}
+		d.exact = nil
+		return
+	}
+	d.addHash(maphash.String(d.seed, key))
+}
+
+func (d *distinctCounter) addHash(h uint64) {
+	idx := h >> (64 - hllBits)
+	rank := uint8(bits.LeadingZeros64(h<<hllBits|1<<(hllBits-1)) + 1)
+	if rank > d.registers[idx] {
+		d.registers[idx] = rank
+	}
+}
+
+// count returns the number of distinct values and whether it is estimated
+func (d *distinctCounter) count() (int64, bool) {
+	if d.exact != nil {
+		return int64(len(d.exact)), false
+	}
+	m := float64(len(d.registers))
+	var sum float64
+	zeros := 0
+	for _, r := range d.registers {
+		sum += math.Pow(2, -float64(r))
+		if r == 0 {
+			zeros++
+		}
+	}
+	estimate := 0.7213 / (1 + 1.079/m) * m * m / sum
+	if estimate <= 2.5*m && zeros > 0 {
+		estimate = m * math.Log(m/float64(zeros))
+	}
+	return int64(estimate + 0.5), true
+}
+
+// frequentValues tracks the most frequent values in bounded space with the
+// Misra-Gries algorithm. Counts are exact while there are at most capacity
+// distinct values and lower bounds otherwise.
+type frequentValues struct {
+	capacity int
+	counts   map[string]int64
+	approx   bool
+}
+
+func newFrequentValues(capacity int) *frequentValues {
+	return &frequentValues{capacity: capacity, counts: map[string]int64{}}
+}
+
+func (f *frequentValues) add(key string) {
+	if _, ok := f.counts[key]; ok || len(f.counts) < f.capacity {
+		f.counts[key]++
+		return
+	}
+	f.approx = true
+	for k, c := range f.counts {
+		if c == 1 {
+			delete(f.counts, k)
+		} else {
+			f.counts[k] = c - 1
+		}
+	}
+}
+
+// top returns the n most frequent values, most frequent first. Once the
+// counts are estimates, values counted only once say nothing and are left
+// out.
+func (f *frequentValues) top(n int) ([]ValueCount, bool) {
+	values := make([]ValueCount, 0, len(f.counts))
+	for k, c := range f.counts {
+		if f.approx && c < 2 {
+			continue
+		}
+		values = append(values, ValueCount{Value: k, Count: c})
+	}
+	sort.Slice(values, func(i, j int) bool {
+		if values[i].Count != values[j].Count {
+			return values[i].Count > values[j].Count
+		}
+		return values[i].Value < values[j].Value
+	})
+	if len(values) > n {
+		values = values[:n]
+	}
+	return values, f.approx
+}
@@ -0,0 +1,67 @@
+package lockbox
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"path/filepath"
+	"testing"
+)
+
+func TestProfile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "profile.lbx")
+	password := "test_password_123"
+	lb := newQueryTestLockbox(t, path, password)
+	defer lb.Close()
+
+	ctx := context.Background()
+	if _, err := lb.Compute(ctx, "email", "if(id = 2, 'nobody', name || '@example.com')", WithPassword(password)); err != nil {
+		t.Fatalf("compute: %v", err)
+	}
+
+	profile, err := lb.Profile(ctx, WithPassword(password), WithTopValues(2))
+	if err != nil {
+		t.Fatalf("profile: %v", err)
+	}
+	if profile.Rows != 3 || len(profile.Columns) != 4 {
+		t.Fatalf("unexpected profile %+v", profile)
+	}
+
+	name := profile.Columns[1]
+	if name.Distinct != 3 || name.Nulls != 0 || name.Min != "alice" || name.Max != "carol" || len(name.TopValues) != 2 {
+		t.Fatalf("unexpected name profile %+v", name)
+	}
+	score := profile.Columns[2]
+	if score.Min != 10.0 || score.Max != 55.0 {
+		t.Fatalf("unexpected score range %v..%v", score.Min, score.Max)
+	}
+	email := profile.Columns[3]
+	if got := email.Patterns["email"]; math.Abs(got-200.0/3) > 0.01 {
+		t.Fatalf("expected two of three emails to conform, got %v%%", got)
+	}
+
+	only, err := lb.Profile(ctx, WithPassword(password), WithColumns("score"))
+	if err != nil || len(only.Columns) != 1 {
+		t.Fatalf("expected one column, got %+v, %v", only, err)
+	}
+}
+
+func TestProfileBoundedState(t *testing.T) {
+	d := newDistinctCounter()
+	f := newFrequentValues(10)
+	const n = 3 * profileExactDistinct
+	for i := 0; i < n; i++ {
+		key := fmt.Sprint(i)
+		d.add(key)
+		f.add(key)
+		f.add("hot")
+	}
+	count, approx := d.count()
+	if !approx || math.Abs(float64(count-n))/n > 0.03 {
+		t.Fatalf("expected an estimate near %d, got %d (approximate %v)", n, count, approx)
+	}
+	top, approx := f.top(1)
+	if !approx || top[0].Value != "hot" || len(f.counts) > 10 {
+		t.Fatalf("expected hot to stay on top with bounded state, got %v", top)
+	}
+}
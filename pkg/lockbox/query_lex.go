@@ -0,0 +1,119 @@
+package lockbox
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+type tokenKind int
+
+const (
+	tokIdent tokenKind = iota
+	tokNumber
+	tokString
+	tokOp
+	tokComma
+	tokLParen
+	tokRParen
+	tokStar
+	tokParam
+)
+
+// token is a lexical element of a query. Pos is the byte offset of the
+// token in the original query text.
+type token struct {
+	Kind tokenKind
+	Text string
+	Pos  int
+}
+
+// upper returns the token text in upper case, for keyword comparisons
+func (t token) upper() string {
+	return strings.ToUpper(t.Text)
+}
+
+// isKeyword reports whether the token is the given (upper case) keyword
+func (t token) isKeyword(kw string) bool {
+	return t.Kind == tokIdent && t.upper() == kw
+}
+
+// lexQuery splits a query into tokens. String literals keep their original
+// case and have their quotes removed.
+func lexQuery(q string) ([]token, error) {
+	var toks []token
+	i := 0
+	for i < len(q) {
+		c := rune(q[i])
+		switch {
+		case unicode.IsSpace(c):
+			i++
+		case c == ',':
+			toks = append(toks, token{Kind: tokComma, Text: ",", Pos: i})
+			i++
+		case c == '(':
+			toks = append(toks, token{Kind: tokLParen, Text: "(", Pos: i})
+			i++
+		case c == ')':
+			toks = append(toks, token{Kind: tokRParen, Text: ")", Pos: i})
+			i++
+		case c == '*':
+			toks = append(toks, token{Kind: tokStar, Text: "*", Pos: i})
+			i++
+		case c == '?':
+			toks = append(toks, token{Kind: tokParam, Text: "?", Pos: i})
+			i++
+		case c == '\'' || c == '"':
+			start := i
+			var sb strings.Builder
+			i++
+			closed := false
+			for i < len(q) {
+				if rune(q[i]) == c {
+					// Doubled quotes escape the quote character
+					if i+1 < len(q) && rune(q[i+1]) == c {
+						sb.WriteByte(q[i])
+						i += 2
+						continue
+					}
+					closed = true
+					i++
+					break
+				}
+				sb.WriteByte(q[i])
+				i++
+			}
+			if !closed {
+				return nil, fmt.Errorf("unterminated string literal at position %d", start)
+			}
+			toks = append(toks, token{Kind: tokString, Text: sb.String(), Pos: start})
+		case strings.ContainsRune("<>=!", c):
+			start := i
+			i++
+			if i < len(q) && strings.ContainsRune("<>=", rune(q[i])) {
+				i++
+			}
+			op := q[start:i]
+			if op == "!" {
+				return nil, fmt.Errorf("unexpected character '!' at position %d", start)
+			}
+			toks = append(toks, token{Kind: tokOp, Text: op, Pos: start})
+		case unicode.IsDigit(c) || ((c == '-' || c == '.') && i+1 < len(q) && unicode.IsDigit(rune(q[i+1]))):
+			start := i
+			i++
+			for i < len(q) && (unicode.IsDigit(rune(q[i])) || q[i] == '.' || q[i] == 'e' || q[i] == 'E') {
+				i++
+			}
+			toks = append(toks, token{Kind: tokNumber, Text: q[start:i], Pos: start})
+		case c == '_' || unicode.IsLetter(c):
+			start := i
+			for i < len(q) && (q[i] == '_' || q[i] == '.' || unicode.IsLetter(rune(q[i])) || unicode.IsDigit(rune(q[i]))) {
+				i++
+			}
+			toks = append(toks, token{Kind: tokIdent, Text: q[start:i], Pos: start})
+		default:
+			return nil, fmt.Errorf("unexpected character %q at position %d", c, i)
+		}
+	}
+	return toks, nil
+}
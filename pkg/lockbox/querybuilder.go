@@ -0,0 +1,334 @@
+package lockbox
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/apache/arrow-go/v18/arrow"
+	"github.com/apache/arrow-go/v18/arrow/array"
+	"github.com/rs/zerolog/log"
+)
+
+// SortOrder is the direction of QueryBuilder.OrderBy
+type SortOrder int
+
+const (
+	// Asc sorts smallest first
+	Asc SortOrder = iota
+	// Desc sorts largest first
+	Desc
+)
+
+// QueryBuilder builds a SELECT in Go instead of SQL text. Start one with
+// Lockbox.Select:
+//
+//	rec, err := lb.Select("name", "score").
+//		Where(lockbox.Col("score").Gt(20)).
+//		OrderBy("score", lockbox.Desc).
+//		Limit(1).
+//		Run(ctx, lockbox.WithPassword(pw))
+//
+// The builder fills in the same plan Query parses from SQL, so the two
+// behave alike. Values are passed as Go values, never spliced into text,
+// and are checked against the column types before anything is decrypted:
+// a string compared with a numeric column, or an ordering operator on a
+// string column, is an error rather than an empty result.
+type QueryBuilder struct {
+	lb    *Lockbox
+	pq    parsedQuery
+	where []Condition
+	err   error
+}
+
+// Select starts a query of the given columns, or of every column when none
+// are given. Nested paths such as address.city can be selected too.
+func (lb *Lockbox) Select(columns ...string) *QueryBuilder {
+	qb := &QueryBuilder{lb: lb, pq: parsedQuery{From: "data", Limit: -1}}
+	for _, col := range columns {
+		qb.pq.SelectCols = append(qb.pq.SelectCols, strings.ToLower(col))
+		qb.pq.SelectAs = append(qb.pq.SelectAs, "")
+	}
+	return qb
+}
+
+// From reads a view instead of the table
+func (qb *QueryBuilder) From(view string) *QueryBuilder {
+	qb.pq.From = strings.ToLower(view)
+	return qb
+}
+
+// Where filters rows by a condition. Calling it again adds conditions that
+// must hold as well.
+func (qb *QueryBuilder) Where(cond Condition) *QueryBuilder {
+	qb.where = append(qb.where, cond)
+	return qb
+}
+
+// OrderBy sorts the result by a column
+func (qb *QueryBuilder) OrderBy(column string, order SortOrder) *QueryBuilder {
+	qb.pq.OrderCol = strings.ToLower(column)
+	qb.pq.OrderDesc = order == Desc
+	return qb
+}
+
+// Limit returns at most n rows
+func (qb *QueryBuilder) Limit(n int) *QueryBuilder {
+	if n < 0 && qb.err == nil {
+		qb.err = fmt.Errorf("invalid LIMIT value %d", n)
+	}
+	qb.pq.Limit = n
+	return qb
+}
+
+// Offset skips the first n rows
+func (qb *QueryBuilder) Offset(n int) *QueryBuilder {
+	if n < 0 && qb.err == nil {
+		qb.err = fmt.Errorf("invalid OFFSET value %d", n)
+	}
+	qb.pq.Offset = n
+	return qb
+}
+
+// Run runs the query and returns its result, as Query does. The caller
+// releases the record.
+func (qb *QueryBuilder) Run(ctx context.Context, opts ...Option) (arrow.Record, error) {
+	qe, pq, err := qb.prepare(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	result, err := qe.execSelect(pq)
+	if err != nil {
+		return nil, err
+	}
+	log.Debug().Int64("rows", result.NumRows()).Msg("Executed built query on lockbox")
+	return result, nil
+}
+
+// Reader runs the query and returns its result a batch at a time, as
+// QueryReader does. The caller releases the reader.
+func (qb *QueryBuilder) Reader(ctx context.Context, opts ...Option) (array.RecordReader, error) {
+	qe, pq, err := qb.prepare(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	return qe.streamSelect(pq)
+}
+
+// prepare opens the lockbox for the options and compiles the plan of the
+// query against its schema, anew for every run
+func (qb *QueryBuilder) prepare(ctx context.Context, opts []Option) (*queryExec, *parsedQuery, error) {
+	if qb.err != nil {
+		return nil, nil, qb.err
+	}
+	options := &Options{}
+	for _, opt := range opts {
+		opt(options)
+	}
+	qe, err := qb.lb.newQueryExec(ctx, options)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	pq := qb.pq
+	if len(qb.where) > 0 {
+		cond := qb.where[0]
+		if len(qb.where) > 1 {
+			cond = And(qb.where...)
+		}
+		if pq.Where, err = cond.compile(qe.meta.Schema); err != nil {
+			return nil, nil, err
+		}
+	}
+	return qe, &pq, nil
+}
+
+// Column names a column in a condition of a QueryBuilder
+type Column struct {
+	name string
+}
+
+// Col returns the column with the given name, or nested path
+func Col(name string) Column {
+	return Column{name: strings.ToLower(name)}
+}
+
+// Eq matches rows where the column equals v
+func (c Column) Eq(v any) Condition { return c.compare("=", v) }
+
+// Gt matches rows where the column is greater than v
+func (c Column) Gt(v any) Condition { return c.compare(">", v) }
+
+// Ge matches rows where the column is greater than or equal to v
+func (c Column) Ge(v any) Condition { return c.compare(">=", v) }
+
+// Lt matches rows where the column is less than v
+func (c Column) Lt(v any) Condition { return c.compare("<", v) }
+
+// Le matches rows where the column is less than or equal to v
+func (c Column) Le(v any) Condition { return c.compare("<=", v) }
+
+// In matches rows where the column equals one of values
+func (c Column) In(values ...any) Condition {
+	return Condition{op: "IN", col: c.name, vals: values}
+}
+
+// Like matches rows where the column matches a LIKE pattern, in which %
+// matches any run of characters and _ a single character
+func (c Column) Like(pattern string) Condition {
+	return Condition{op: "LIKE", col: c.name, vals: []any{pattern}}
+}
+
+func (c Column) compare(op string, v any) Condition {
+	return Condition{op: op, col: c.name, vals: []any{v}}
+}
+
+// Condition is a condition of a QueryBuilder, made by the methods of
+// Column and combined with And and Or
+type Condition struct {
+	op    string
+	col   string
+	vals  []any
+	terms []Condition
+}
+
+// And matches rows that match every condition
+func And(conds ...Condition) Condition {
+	return Condition{op: "AND", terms: conds}
+}
+
+// Or matches rows that match any of the conditions
+func Or(conds ...Condition) Condition {
+	return Condition{op: "OR", terms: conds}
+}
+
+// compile converts the condition to a WHERE predicate, checking its values
+// against the types of the columns of schema. Columns that are not in the
+// schema, such as virtual columns, are checked when the query runs.
+func (c Condition) compile(schema *arrow.Schema) (*wherePredicate, error) {
+	if c.op == "AND" || c.op == "OR" {
+		if len(c.terms) == 0 {
+			return nil, fmt.Errorf("%s needs at least one condition", c.op)
+		}
+		w := &wherePredicate{Op: c.op}
+		for _, t := range c.terms {
+			term, err := t.compile(schema)
+			if err != nil {
+				return nil, err
+			}
+			w.Terms = append(w.Terms, term)
+		}
+		return w, nil
+	}
+
+	if c.op == "" {
+		return nil, fmt.Errorf("empty condition")
+	}
+	if len(c.vals) == 0 {
+		return nil, fmt.Errorf("%s %s needs a value", c.col, c.op)
+	}
+	dt := columnType(schema, c.col)
+	w := &wherePredicate{Op: c.op, Col: c.col}
+	for _, v := range c.vals {
+		if dt != nil && c.op != "LIKE" {
+			if err := checkLiteral(dt, c.op, v); err != nil {
+				return nil, fmt.Errorf("column %s: %w", c.col, err)
+			}
+		}
+		text, err := literalText(v)
+		if err != nil {
+			return nil, fmt.Errorf("column %s: %w", c.col, err)
+		}
+		w.Vals = append(w.Vals, text)
+	}
+	switch c.op {
+	case "IN":
+	case "LIKE":
+		w.Val, w.Vals = w.Vals[0], nil
+		w.Like = likePattern(w.Val)
+	default:
+		w.Val, w.Vals = w.Vals[0], nil
+	}
+	return w, nil
+}
+
+// columnType returns the type of a column or nested path of schema, or nil
+// if there is no such column
+func columnType(schema *arrow.Schema, name string) arrow.DataType {
+	if indices := schema.FieldIndices(name); len(indices) > 0 {
+		return schema.Field(indices[0]).Type
+	}
+	if np, err := resolveNestedPath(schema, name); err == nil && np != nil {
+		return np.Type
+	}
+	return nil
+}
+
+// checkLiteral checks that v can be compared with op to values of type dt
+func checkLiteral(dt arrow.DataType, op string, v any) error {
+	if dict, ok := dt.(*arrow.DictionaryType); ok {
+		dt = dict.ValueType
+	}
+	rv := reflect.ValueOf(v)
+	isNumber := v != nil && (rv.CanInt() || rv.CanUint() || rv.CanFloat())
+	_, isString := v.(string)
+	_, isDuration := v.(time.Duration)
+
+	var ok bool
+	switch dt.ID() {
+	case arrow.INT8, arrow.INT16, arrow.INT32, arrow.INT64, arrow.UINT8, arrow.UINT16, arrow.UINT32, arrow.UINT64,
+		arrow.FLOAT32, arrow.FLOAT64, arrow.DECIMAL128:
+		ok = isNumber && !isDuration
+	case arrow.STRING, arrow.LARGE_STRING:
+		if op != "=" && op != "IN" {
+			return fmt.Errorf("operator %s is not supported on %s", op, dt)
+		}
+		ok = isString
+	case arrow.BOOL:
+		if op != "=" && op != "IN" {
+			return fmt.Errorf("operator %s is not supported on %s", op, dt)
+		}
+		_, ok = v.(bool)
+	case arrow.TIMESTAMP, arrow.DATE32, arrow.DATE64:
+		_, isTime := v.(time.Time)
+		ok = isTime || isString
+	case arrow.TIME32, arrow.TIME64:
+		ok = isString
+	case arrow.DURATION:
+		ok = isDuration || isString || isNumber
+	default:
+		return fmt.Errorf("conditions on %s are not supported", dt)
+	}
+	if !ok {
+		return fmt.Errorf("cannot compare %s with %T", dt, v)
+	}
+	return nil
+}
+
+// literalText writes a Go value as the literal text of a predicate
+func literalText(v any) (string, error) {
+	switch x := v.(type) {
+	case string:
+		return x, nil
+	case bool:
+		return strconv.FormatBool(x), nil
+	case time.Time:
+		return x.UTC().Format(time.RFC3339Nano), nil
+	case time.Duration:
+		return x.String(), nil
+	}
+	rv := reflect.ValueOf(v)
+	switch {
+	case v == nil:
+	case rv.CanInt():
+		return strconv.FormatInt(rv.Int(), 10), nil
+	case rv.CanUint():
+		return strconv.FormatUint(rv.Uint(), 10), nil
+	case rv.CanFloat():
+		return strconv.FormatFloat(rv.Float(), 'f', -1, 64), nil
+	}
+	return "", fmt.Errorf("unsupported value %v of type %T", v, v)
+}
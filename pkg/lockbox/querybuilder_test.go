@@ -0,0 +1,106 @@
+package lockbox
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/apache/arrow-go/v18/arrow"
+	"github.com/apache/arrow-go/v18/arrow/array"
+)
+
+func TestQueryBuilder(t *testing.T) {
+	password := "test_password_123"
+	ctx := context.Background()
+
+	schema := arrow.NewSchema([]arrow.Field{
+		{Name: "id", Type: arrow.PrimitiveTypes.Int64},
+		{Name: "name", Type: arrow.BinaryTypes.String},
+		{Name: "score", Type: arrow.PrimitiveTypes.Float64},
+		{Name: "joined", Type: arrow.FixedWidthTypes.Date32, Nullable: true},
+	}, nil)
+	lb, err := Create(filepath.Join(t.TempDir(), "builder.lbx"), schema, WithPassword(password), WithDebugAllocator())
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	// Query results are released by cleanups, before the allocator is checked
+	t.Cleanup(func() { lb.Close() })
+
+	err = lb.AppendRows(ctx, []map[string]any{
+		{"id": 1, "name": "ann", "score": 25.5, "joined": "2024-01-10"},
+		{"id": 2, "name": "bob", "score": 12, "joined": "2024-03-02"},
+		{"id": 3, "name": "it's", "score": 31, "joined": "2023-11-30"},
+		{"id": 4, "name": "dee", "score": 20},
+	}, WithPassword(password))
+	if err != nil {
+		t.Fatalf("append rows: %v", err)
+	}
+
+	run := func(qb *QueryBuilder) arrow.Record {
+		t.Helper()
+		rec, err := qb.Run(ctx, WithPassword(password))
+		if err != nil {
+			t.Fatalf("run: %v", err)
+		}
+		t.Cleanup(rec.Release)
+		return rec
+	}
+
+	rec := run(lb.Select("name", "score").Where(Col("score").Gt(20)).OrderBy("score", Desc).Limit(1))
+	if rec.NumRows() != 1 || rec.NumCols() != 2 || rec.Column(0).(*array.String).Value(0) != "it's" {
+		t.Fatalf("unexpected result %v", rec)
+	}
+
+	// The same plan as the SQL
+	sql, err := lb.Query(ctx, "SELECT id FROM data WHERE score >= 20 AND joined < '2024-02-01' OR name = 'bob' ORDER BY id", WithPassword(password))
+	if err != nil {
+		t.Fatalf("query: %v", err)
+	}
+	defer sql.Release()
+	built := run(lb.Select("id").
+		Where(Or(And(Col("score").Ge(20), Col("joined").Lt(time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC))), Col("name").Eq("bob"))).
+		OrderBy("id", Asc))
+	if !array.RecordEqual(sql, built) {
+		t.Errorf("expected %v, got %v", sql, built)
+	}
+
+	// Where calls add up, and values need no quoting
+	rec = run(lb.Select().Where(Col("name").In("it's", "bob")).Where(Col("score").Le(12)))
+	if rec.NumRows() != 1 || rec.NumCols() != 4 || rec.Column(0).(*array.Int64).Value(0) != 2 {
+		t.Errorf("expected bob only, got %v", rec)
+	}
+	rec = run(lb.Select("id").Where(Col("name").Like("%n%")).Offset(0).Limit(10))
+	if rec.NumRows() != 1 || rec.Column(0).(*array.Int64).Value(0) != 1 {
+		t.Errorf("expected ann only, got %v", rec)
+	}
+
+	rr, err := lb.Select("id").Where(Col("id").Gt(1)).Reader(ctx, WithPassword(password))
+	if err != nil {
+		t.Fatalf("reader: %v", err)
+	}
+	var rows int64
+	for rr.Next() {
+		rows += rr.Record().NumRows()
+	}
+	rr.Release()
+	if rows != 3 {
+		t.Errorf("expected 3 streamed rows, got %d", rows)
+	}
+
+	// Values that cannot match the column are refused before reading
+	for name, qb := range map[string]*QueryBuilder{
+		"string for number":     lb.Select().Where(Col("score").Gt("20")),
+		"number for string":     lb.Select().Where(Col("name").Eq(1)),
+		"order on string":       lb.Select().Where(Col("name").Gt("a")),
+		"unsupported value":     lb.Select().Where(Col("score").Eq([]int{1})),
+		"empty or":              lb.Select().Where(Or()),
+		"negative limit":        lb.Select().Limit(-1),
+		"unknown select column": lb.Select("missing"),
+	} {
+		if rec, err := qb.Run(ctx, WithPassword(password)); err == nil {
+			rec.Release()
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
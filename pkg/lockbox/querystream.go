@@ -0,0 +1,309 @@
+package lockbox
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync/atomic"
+
+	"github.com/TFMV/lockbox/pkg/metadata"
+	"github.com/apache/arrow-go/v18/arrow"
+	"github.com/apache/arrow-go/v18/arrow/array"
+	"github.com/rs/zerolog/log"
+)
+
+// QueryReader returns the result of a query a batch at a time, decrypting
+// and filtering one stored row group per batch, so results of any size
+// are read in bounded memory and a LIMIT stops reading once it is reached.
+// Queries that need every row before the first can be returned — ORDER BY,
+// aggregates, UNION, TABLESAMPLE or WithSampleRows, and views — are run
+// whole and returned as one batch. The caller releases the reader.
+func (lb *Lockbox) QueryReader(ctx context.Context, query string, opts ...Option) (array.RecordReader, error) {
+	options := &Options{}
+	for _, opt := range opts {
+		opt(options)
+	}
+
+	qe, err := lb.newQueryExec(ctx, options)
+	if err != nil {
+		return nil, err
+	}
+	qs, err := qe.stream(query)
+	if err != nil {
+		return nil, err
+	}
+	log.Debug().Str("query", query).Bool("streaming", qs.groups != nil).Msg("Streaming query on lockbox")
+	return qs, nil
+}
+
+// QueryTo runs a query and writes its result to w in the given format as
+// it is read, as QueryReader returns it, without building the whole result.
+// CSV and newline-delimited JSON suit piping into other tools, and
+// ExportArrowStream Arrow consumers that read as they go. The CSV dialect
+// options of ExportRecord are honored. It returns the number of rows
+// written; on error, w may hold part of the result.
+func (lb *Lockbox) QueryTo(ctx context.Context, query string, w io.Writer, format ExportFormat, opts ...Option) (int64, error) {
+	options := &Options{}
+	for _, opt := range opts {
+		opt(options)
+	}
+
+	rr, err := lb.QueryReader(ctx, query, opts...)
+	if err != nil {
+		return 0, err
+	}
+	defer rr.Release()
+
+	ew, err := newExportWriter(w, rr.Schema(), format, options)
+	if err != nil {
+		return 0, err
+	}
+	var rows int64
+	for rr.Next() {
+		rec := rr.Record()
+		if err := ew.Write(rec); err != nil {
+			ew.Close()
+			return rows, err
+		}
+		rows += rec.NumRows()
+	}
+	if err := rr.Err(); err != nil {
+		ew.Close()
+		return rows, err
+	}
+	return rows, ew.Close()
+}
+
+// queryStream is the array.RecordReader of QueryReader
+type queryStream struct {
+	qe      *queryExec
+	pq      *parsedQuery
+	stored  []string
+	virtual []metadata.VirtualColumn
+	// groups are the row groups left to read; nil when the result was
+	// computed whole and waits in pending
+	groups []int
+	offset int // rows still to skip for OFFSET
+	limit  int // rows still to return for LIMIT, -1 for all
+
+	schema  *arrow.Schema
+	rec     arrow.Record
+	pending arrow.Record
+	err     error
+	refs    int64
+}
+
+// streamable reports whether a SELECT can be evaluated a row group at a
+// time: every row it returns depends on its own row group only
+func (qe *queryExec) streamable(pq *parsedQuery) bool {
+	if _, ok := qe.meta.FindView(pq.From); ok {
+		return false
+	}
+	return len(pq.Aggregates) == 0 && pq.OrderCol == "" && pq.OrderVec == nil && qe.sampleFor(pq) == nil
+}
+
+// stream prepares the reader of a query, running it whole unless it is a
+// single streamable SELECT. The first batch is read right away so the
+// schema is known.
+func (qe *queryExec) stream(query string) (*queryStream, error) {
+	selects, _, err := splitUnion(query)
+	if err != nil {
+		return nil, err
+	}
+	if len(selects) > 1 {
+		qs := &queryStream{qe: qe, refs: 1}
+		if qs.pending, err = qe.run(query); err != nil {
+			return nil, err
+		}
+		qs.schema = qs.pending.Schema()
+		return qs, nil
+	}
+
+	pq, err := parseQuery(selects[0])
+	if err != nil {
+		return nil, err
+	}
+	if _, err = pq.bindArgs(qe.args); err != nil {
+		return nil, err
+	}
+	return qe.streamSelect(pq)
+}
+
+// streamSelect prepares the reader of a parsed SELECT
+func (qe *queryExec) streamSelect(pq *parsedQuery) (*queryStream, error) {
+	qe, err := qe.at(pq.AsOf)
+	if err != nil {
+		return nil, err
+	}
+	qs := &queryStream{qe: qe, refs: 1}
+	if !qe.streamable(pq) {
+		if qs.pending, err = qe.execSelect(pq); err != nil {
+			return nil, err
+		}
+		qs.schema = qs.pending.Schema()
+		return qs, nil
+	}
+
+	if pq.Where != nil {
+		if err := qe.resolveSubqueries(pq.Where); err != nil {
+			return nil, err
+		}
+	}
+	if qs.stored, qs.virtual, err = qe.planColumns(pq); err != nil {
+		return nil, err
+	}
+	if groups, ok := qe.spatialRowGroups(pq.Where); ok {
+		qs.groups = groups
+	} else {
+		qs.groups = append([]int{}, qe.rowGroups()...)
+	}
+	qs.pq, qs.offset, qs.limit = pq, pq.Offset, pq.Limit
+
+	if qs.pending, err = qs.load(); err != nil {
+		return nil, err
+	}
+	if qs.pending == nil {
+		// No rows at all: evaluate an empty batch to learn the schema
+		empty, err := qs.batch(qe.emptyRecord(qs.stored))
+		if err != nil {
+			return nil, err
+		}
+		qs.schema = empty.Schema()
+		empty.Release()
+	} else {
+		qs.schema = qs.pending.Schema()
+	}
+	return qs, nil
+}
+
+// load evaluates the query over the next row groups until one leaves rows,
+// returning nil once the row groups or the LIMIT are used up
+func (qs *queryStream) load() (arrow.Record, error) {
+	for len(qs.groups) > 0 && qs.limit != 0 {
+		if err := qs.qe.ctx.Err(); err != nil {
+			return nil, err
+		}
+		g := qs.groups[0]
+		qs.groups = qs.groups[1:]
+		rec, err := qs.qe.reader.ReadRowGroup(g, qs.stored)
+		if err != nil {
+			return nil, fmt.Errorf("row group %d: %w", g, err)
+		}
+		if rec, err = qs.batch(rec); err != nil {
+			return nil, fmt.Errorf("row group %d: %w", g, err)
+		}
+
+		// OFFSET and LIMIT count rows across row groups
+		n := int(rec.NumRows())
+		skip := min(qs.offset, n)
+		take := n - skip
+		if qs.limit >= 0 {
+			take = min(take, qs.limit)
+			qs.limit -= take
+		}
+		qs.offset -= skip
+		if take == 0 {
+			rec.Release()
+			continue
+		}
+		if take == n {
+			return rec, nil
+		}
+		sliced := rec.NewSlice(int64(skip), int64(skip+take))
+		rec.Release()
+		return sliced, nil
+	}
+	return nil, nil
+}
+
+// batch evaluates the query over the rows of one row group, without its
+// OFFSET and LIMIT. It takes ownership of rec.
+func (qs *queryStream) batch(rec arrow.Record) (arrow.Record, error) {
+	rec, err := qs.qe.prepare(rec, qs.virtual, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer rec.Release()
+	pq := *qs.pq
+	pq.Offset, pq.Limit = 0, -1
+	return applyQuery(qs.qe.mem, rec, &pq)
+}
+
+// Retain increases the reference count of the reader
+func (qs *queryStream) Retain() {
+	atomic.AddInt64(&qs.refs, 1)
+}
+
+// Release decreases the reference count of the reader and frees its
+// batches when it reaches zero
+func (qs *queryStream) Release() {
+	if atomic.AddInt64(&qs.refs, -1) != 0 {
+		return
+	}
+	for _, rec := range []arrow.Record{qs.rec, qs.pending} {
+		if rec != nil {
+			rec.Release()
+		}
+	}
+	qs.rec, qs.pending = nil, nil
+}
+
+// Schema returns the schema of the result
+func (qs *queryStream) Schema() *arrow.Schema {
+	return qs.schema
+}
+
+// Next advances to the next batch of the result
+func (qs *queryStream) Next() bool {
+	if qs.rec != nil {
+		qs.rec.Release()
+		qs.rec = nil
+	}
+	if qs.err != nil {
+		return false
+	}
+	if qs.pending != nil {
+		qs.rec, qs.pending = qs.pending, nil
+		return true
+	}
+	rec, err := qs.load()
+	if err == nil && rec != nil && !rec.Schema().Equal(qs.schema) {
+		// Virtual columns can change type with the values of a row group
+		err = fmt.Errorf("result schema %s differs from %s", rec.Schema(), qs.schema)
+		rec.Release()
+	}
+	if err != nil {
+		qs.err = err
+		return false
+	}
+	qs.rec = rec
+	return rec != nil
+}
+
+// Record returns the current batch. It is valid until the next call to
+// Next; retain it to keep it longer.
+func (qs *queryStream) Record() arrow.Record {
+	return qs.rec
+}
+
+// Err returns the error that stopped the reader, if any
+func (qs *queryStream) Err() error {
+	return qs.err
+}
+
+// emptyRecord returns a record without rows of the stored columns, all of
+// them when stored is nil
+func (qe *queryExec) emptyRecord(stored []string) arrow.Record {
+	var fields []arrow.Field
+	var cols []arrow.Array
+	for _, field := range qe.meta.Schema.Fields() {
+		if stored != nil && !contains(stored, field.Name) {
+			continue
+		}
+		fields = append(fields, field)
+		cols = append(cols, array.MakeArrayOfNull(qe.mem, field.Type, 0))
+	}
+	rec := array.NewRecord(arrow.NewSchema(fields, nil), cols, 0)
+	releaseArrays(cols)
+	return rec
+}
@@ -0,0 +1,119 @@
+package lockbox
+
+import (
+	"bytes"
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/apache/arrow-go/v18/arrow"
+	"github.com/apache/arrow-go/v18/arrow/ipc"
+)
+
+func TestQueryReader(t *testing.T) {
+	password := "test_password_123"
+	ctx := context.Background()
+
+	schema := arrow.NewSchema([]arrow.Field{
+		{Name: "id", Type: arrow.PrimitiveTypes.Int64},
+		{Name: "name", Type: arrow.BinaryTypes.String, Nullable: true},
+	}, nil)
+	lb, err := Create(filepath.Join(t.TempDir(), "stream.lbx"), schema, WithPassword(password), WithDebugAllocator())
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	defer lb.Close()
+
+	// Three writes are three row groups: ids 1-4, 5-8 and 9-12
+	for g := 0; g < 3; g++ {
+		rows := make([]map[string]any, 4)
+		for i := range rows {
+			id := g*4 + i + 1
+			rows[i] = map[string]any{"id": id, "name": strings.Repeat("x", id)}
+		}
+		if err := lb.AppendRows(ctx, rows, WithPassword(password)); err != nil {
+			t.Fatalf("append: %v", err)
+		}
+	}
+
+	read := func(q string) ([]int64, int) {
+		t.Helper()
+		rr, err := lb.QueryReader(ctx, q, WithPassword(password))
+		if err != nil {
+			t.Fatalf("%s: %v", q, err)
+		}
+		defer rr.Release()
+		var ids []int64
+		batches := 0
+		for rr.Next() {
+			batches++
+			rec := rr.Record()
+			for i := 0; i < int(rec.NumRows()); i++ {
+				ids = append(ids, Value(rec.Column(0), i).(int64))
+			}
+		}
+		if err := rr.Err(); err != nil {
+			t.Fatalf("%s: %v", q, err)
+		}
+		return ids, batches
+	}
+
+	// OFFSET and LIMIT count across row groups, and reading stops at the LIMIT
+	ids, batches := read("SELECT id FROM data WHERE id > 1 LIMIT 4 OFFSET 2")
+	if len(ids) != 4 || ids[0] != 4 || ids[3] != 7 || batches != 2 {
+		t.Errorf("expected ids 4-7 in two batches, got %v in %d", ids, batches)
+	}
+	if ids, batches := read("SELECT id FROM data"); len(ids) != 12 || batches != 3 {
+		t.Errorf("expected 12 rows in three batches, got %d in %d", len(ids), batches)
+	}
+
+	// Sorting needs every row first
+	if ids, batches := read("SELECT id FROM data ORDER BY id DESC LIMIT 2"); len(ids) != 2 || ids[0] != 12 || batches != 1 {
+		t.Errorf("expected ids 12 and 11 in one batch, got %v in %d", ids, batches)
+	}
+
+	// An empty result still has the schema of the query
+	rr, err := lb.QueryReader(ctx, "SELECT name FROM data WHERE id > 100", WithPassword(password))
+	if err != nil {
+		t.Fatalf("empty query: %v", err)
+	}
+	if rr.Schema().NumFields() != 1 || rr.Schema().Field(0).Name != "name" || rr.Next() {
+		t.Errorf("expected an empty result with the name column, got %v", rr.Schema())
+	}
+	rr.Release()
+
+	var buf bytes.Buffer
+	n, err := lb.QueryTo(ctx, "SELECT id, name FROM data WHERE id >= 3 LIMIT 2", &buf, ExportCSV, WithPassword(password))
+	if err != nil {
+		t.Fatalf("query to csv: %v", err)
+	}
+	if n != 2 || buf.String() != "id,name\n3,xxx\n4,xxxx\n" {
+		t.Errorf("unexpected CSV (%d rows): %q", n, buf.String())
+	}
+
+	buf.Reset()
+	if _, err := lb.QueryTo(ctx, "SELECT id FROM data WHERE id > 10", &buf, ExportJSON, WithPassword(password)); err != nil {
+		t.Fatalf("query to json: %v", err)
+	}
+	if buf.String() != "{\"id\":11}\n{\"id\":12}\n" {
+		t.Errorf("unexpected NDJSON %q", buf.String())
+	}
+
+	buf.Reset()
+	if _, err := lb.QueryTo(ctx, "SELECT * FROM data", &buf, ExportArrowStream, WithPassword(password)); err != nil {
+		t.Fatalf("query to arrow: %v", err)
+	}
+	ir, err := ipc.NewReader(&buf)
+	if err != nil {
+		t.Fatalf("read arrow stream: %v", err)
+	}
+	defer ir.Release()
+	total := int64(0)
+	for ir.Next() {
+		total += ir.Record().NumRows()
+	}
+	if total != 12 {
+		t.Errorf("expected 12 rows in the Arrow stream, got %d", total)
+	}
+}
@@ -0,0 +1,113 @@
+package lockbox
+
+import (
+	"fmt"
+
+	"github.com/TFMV/lockbox/pkg/format"
+)
+
+// GenerateRecoveryCodes creates n one-time recovery codes that can each be
+// used once to set a new password with Recover. Existing codes are
+// replaced. The codes are not stored and must be shown to the user now.
+func (lb *Lockbox) GenerateRecoveryCodes(n int, opts ...Option) ([]string, error) {
+	options := &Options{CreatedBy: "system"}
+	for _, opt := range opts {
+		opt(options)
+	}
+	if options.Password == "" {
+		return nil, fmt.Errorf("password is required for generating recovery codes")
+	}
+	if n <= 0 {
+		return nil, fmt.Errorf("number of recovery codes must be positive")
+	}
+
+	masterKey, err := lb.file.MasterKey(options.Password)
+	if err != nil {
+		return nil, err
+	}
+	codes, err := lb.file.GenerateRecoveryCodes(masterKey, n)
+	if err != nil {
+		return nil, err
+	}
+
+	lb.file.Metadata().LogAccess(options.CreatedBy, "generate-recovery-codes", "master-key", true, fmt.Sprintf("%d codes", n))
+	if err := lb.file.SaveMetadata(); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// RemainingRecoveryCodes returns the number of unused recovery codes
+func (lb *Lockbox) RemainingRecoveryCodes() int {
+	return lb.file.RemainingRecoveryCodes()
+}
+
+// ChangePassword replaces the password given with WithPassword. Data is not
+// re-encrypted; the master key is wrapped with the new password instead.
+func (lb *Lockbox) ChangePassword(newPassword string, opts ...Option) error {
+	options := &Options{CreatedBy: "system"}
+	for _, opt := range opts {
+		opt(options)
+	}
+	if options.Password == "" || newPassword == "" {
+		return fmt.Errorf("current and new password are required")
+	}
+
+	masterKey, err := lb.file.MasterKey(options.Password)
+	if err != nil {
+		return err
+	}
+	if err := lb.file.SetPassword(masterKey, newPassword); err != nil {
+		return err
+	}
+
+	lb.forgetKeys()
+	lb.file.Metadata().LogAccess(options.CreatedBy, "change-password", "master-key", true, "")
+	return lb.file.SaveMetadata()
+}
+
+// Recover opens a lockbox whose password was lost using a recovery code,
+// sets newPassword and returns the lockbox opened with it. The code cannot
+// be used again.
+func Recover(filename, code, newPassword string, opts ...Option) (*Lockbox, error) {
+	if newPassword == "" {
+		return nil, fmt.Errorf("new password is required")
+	}
+
+	options := &Options{}
+	for _, opt := range opts {
+		opt(options)
+	}
+	module, err := resolveModule(options.CryptoModule)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := format.Open(filename, "", module)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open lockbox file: %w", err)
+	}
+
+	masterKey, err := file.UseRecoveryCode(code)
+	if err != nil {
+		file.Metadata().LogAccess("recovery", "recover", "master-key", false, err.Error())
+		file.SaveMetadata()
+		file.Close()
+		return nil, err
+	}
+	if err := file.SetPassword(masterKey, newPassword); err != nil {
+		file.Close()
+		return nil, err
+	}
+	file.Metadata().LogAccess("recovery", "recover", "master-key", true, "password reset with recovery code")
+	if err := file.SaveMetadata(); err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	file.SetAllocator(newAllocator(options))
+	return &Lockbox{
+		file: file,
+		key:  file.Module().DeriveKey(newPassword, nil),
+	}, nil
+}
@@ -0,0 +1,65 @@
+package lockbox
+
+import (
+	"context"
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/TFMV/lockbox/pkg/format"
+)
+
+func TestRecoveryCodes(t *testing.T) {
+	tmpFile := "/tmp/test_lockbox_recovery.lbx"
+	defer os.Remove(tmpFile)
+
+	password := "test_password_123"
+	lb := newQueryTestLockbox(t, tmpFile, password)
+	codes, err := lb.GenerateRecoveryCodes(2, WithPassword(password))
+	if err != nil {
+		t.Fatalf("generate codes: %v", err)
+	}
+	if len(codes) != 2 || lb.RemainingRecoveryCodes() != 2 {
+		t.Fatalf("unexpected codes: %v", codes)
+	}
+	lb.Close()
+
+	if _, err := Recover(tmpFile, "AAAA-BBBB-CCCC-DDDD", "new_password"); !errors.Is(err, format.ErrInvalidPassword) {
+		t.Fatalf("expected unknown code to fail, got %v", err)
+	}
+
+	lb, err = Recover(tmpFile, codes[0], "new_password")
+	if err != nil {
+		t.Fatalf("recover: %v", err)
+	}
+	res, err := lb.Query(context.Background(), "SELECT name FROM data", WithPassword("new_password"))
+	if err != nil {
+		t.Fatalf("query with new password: %v", err)
+	}
+	res.Release()
+	if lb.RemainingRecoveryCodes() != 1 {
+		t.Fatalf("expected one code left")
+	}
+	lb.Close()
+
+	if _, err := Open(tmpFile, WithPassword(password)); !errors.Is(err, format.ErrInvalidPassword) {
+		t.Fatalf("expected old password to be rejected, got %v", err)
+	}
+	if _, err := Recover(tmpFile, codes[0], "other"); err == nil {
+		t.Fatalf("expected used code to be rejected")
+	}
+
+	lb, err = Open(tmpFile, WithPassword("new_password"))
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer lb.Close()
+	if err := lb.ChangePassword("third_password", WithPassword("new_password")); err != nil {
+		t.Fatalf("change password: %v", err)
+	}
+	res, err = lb.Query(context.Background(), "SELECT score FROM data", WithPassword("third_password"))
+	if err != nil {
+		t.Fatalf("query after password change: %v", err)
+	}
+	res.Release()
+}
@@ -0,0 +1,394 @@
+package lockbox
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/apache/arrow-go/v18/arrow"
+)
+
+// ParseAvroSchema converts an Avro record schema to an Arrow schema, a
+// column per field. Unions of null and one type are nullable columns of
+// that type; other unions are not supported. Records become structs,
+// arrays lists, maps maps with string keys, enums strings and fixed
+// binary. The decimal, date, time, timestamp and uuid logical types map to
+// the Arrow types of the same meaning.
+func ParseAvroSchema(definition []byte) (*arrow.Schema, error) {
+	var root any
+	if err := json.Unmarshal(definition, &root); err != nil {
+		return nil, fmt.Errorf("invalid Avro schema: %w", err)
+	}
+	obj, ok := root.(map[string]any)
+	if !ok || obj["type"] != "record" {
+		return nil, fmt.Errorf("invalid Avro schema: expected a record")
+	}
+	c := &avroConverter{named: map[string]arrow.DataType{}}
+	dt, _, err := c.convert(obj, "")
+	if err != nil {
+		return nil, err
+	}
+	return arrow.NewSchema(dt.(*arrow.StructType).Fields(), nil), nil
+}
+
+// avroConverter converts Avro types, remembering named types for later
+// references to them
+type avroConverter struct {
+	named map[string]arrow.DataType
+}
+
+// convert returns the Arrow type of an Avro type and whether it allows
+// nulls
+func (c *avroConverter) convert(t any, namespace string) (arrow.DataType, bool, error) {
+	switch t := t.(type) {
+	case string:
+		dt, err := c.primitive(t, namespace)
+		return dt, false, err
+	case []any:
+		var types []any
+		nullable := false
+		for _, branch := range t {
+			if branch == "null" {
+				nullable = true
+				continue
+			}
+			types = append(types, branch)
+		}
+		if len(types) != 1 {
+			return nil, false, fmt.Errorf("unions of several types are not supported")
+		}
+		dt, _, err := c.convert(types[0], namespace)
+		return dt, nullable, err
+	case map[string]any:
+		dt, err := c.complex(t, namespace)
+		return dt, false, err
+	}
+	return nil, false, fmt.Errorf("invalid Avro type %v", t)
+}
+
+func (c *avroConverter) primitive(name, namespace string) (arrow.DataType, error) {
+	switch name {
+	case "boolean":
+		return arrow.FixedWidthTypes.Boolean, nil
+	case "int":
+		return arrow.PrimitiveTypes.Int32, nil
+	case "long":
+		return arrow.PrimitiveTypes.Int64, nil
+	case "float":
+		return arrow.PrimitiveTypes.Float32, nil
+	case "double":
+		return arrow.PrimitiveTypes.Float64, nil
+	case "bytes":
+		return arrow.BinaryTypes.Binary, nil
+	case "string":
+		return arrow.BinaryTypes.String, nil
+	case "null":
+		return nil, fmt.Errorf("null is only supported in unions")
+	}
+	if dt, ok := c.named[avroFullName(name, namespace)]; ok {
+		return dt, nil
+	}
+	if dt, ok := c.named[name]; ok {
+		return dt, nil
+	}
+	return nil, fmt.Errorf("unknown Avro type %q", name)
+}
+
+func (c *avroConverter) complex(t map[string]any, namespace string) (arrow.DataType, error) {
+	typ, _ := t["type"].(string)
+	if ns, ok := t["namespace"].(string); ok {
+		namespace = ns
+	}
+	if logical, ok := t["logicalType"].(string); ok {
+		if dt, ok := avroLogicalType(logical, typ, t); ok {
+			return c.define(t, namespace, dt), nil
+		}
+	}
+
+	switch typ {
+	case "record":
+		list, _ := t["fields"].([]any)
+		fields := make([]arrow.Field, 0, len(list))
+		for _, f := range list {
+			field, _ := f.(map[string]any)
+			name, _ := field["name"].(string)
+			if name == "" {
+				return nil, fmt.Errorf("record field without a name")
+			}
+			dt, nullable, err := c.convert(field["type"], namespace)
+			if err != nil {
+				return nil, fmt.Errorf("field %s: %w", name, err)
+			}
+			fields = append(fields, arrow.Field{Name: name, Type: dt, Nullable: nullable})
+		}
+		return c.define(t, namespace, arrow.StructOf(fields...)), nil
+	case "enum":
+		return c.define(t, namespace, arrow.BinaryTypes.String), nil
+	case "fixed":
+		return c.define(t, namespace, arrow.BinaryTypes.Binary), nil
+	case "array":
+		elem, _, err := c.convert(t["items"], namespace)
+		if err != nil {
+			return nil, fmt.Errorf("array items: %w", err)
+		}
+		return arrow.ListOf(elem), nil
+	case "map":
+		values, _, err := c.convert(t["values"], namespace)
+		if err != nil {
+			return nil, fmt.Errorf("map values: %w", err)
+		}
+		return arrow.MapOf(arrow.BinaryTypes.String, values), nil
+	case "":
+		// {"type": {...}} wraps another type
+		dt, _, err := c.convert(t["type"], namespace)
+		return dt, err
+	}
+	return c.primitive(typ, namespace)
+}
+
+// define registers the type of a named Avro type under its full name
+func (c *avroConverter) define(t map[string]any, namespace string, dt arrow.DataType) arrow.DataType {
+	if name, ok := t["name"].(string); ok {
+		c.named[avroFullName(name, namespace)] = dt
+	}
+	return dt
+}
+
+func avroFullName(name, namespace string) string {
+	if strings.Contains(name, ".") || namespace == "" {
+		return name
+	}
+	return namespace + "." + name
+}
+
+// avroLogicalType returns the Arrow type of an Avro logical type. ok is
+// false for logical types that are read as their underlying type.
+func avroLogicalType(logical, typ string, t map[string]any) (arrow.DataType, bool) {
+	switch logical {
+	case "decimal":
+		precision, _ := t["precision"].(float64)
+		scale, _ := t["scale"].(float64)
+		if precision < 1 || precision > 38 || scale < 0 || scale > precision {
+			return nil, false
+		}
+		return &arrow.Decimal128Type{Precision: int32(precision), Scale: int32(scale)}, true
+	case "date":
+		return arrow.FixedWidthTypes.Date32, typ == "int"
+	case "time-millis":
+		return arrow.FixedWidthTypes.Time32ms, typ == "int"
+	case "time-micros":
+		return arrow.FixedWidthTypes.Time64us, typ == "long"
+	case "timestamp-millis":
+		return arrow.FixedWidthTypes.Timestamp_ms, typ == "long"
+	case "timestamp-micros":
+		return arrow.FixedWidthTypes.Timestamp_us, typ == "long"
+	case "timestamp-nanos":
+		return arrow.FixedWidthTypes.Timestamp_ns, typ == "long"
+	case "local-timestamp-millis":
+		return &arrow.TimestampType{Unit: arrow.Millisecond}, typ == "long"
+	case "local-timestamp-micros":
+		return &arrow.TimestampType{Unit: arrow.Microsecond}, typ == "long"
+	case "uuid":
+		return arrow.BinaryTypes.String, typ == "string"
+	}
+	return nil, false
+}
+
+// ParseJSONSchema converts a JSON Schema of an object to an Arrow schema, a
+// column per property in the order they are written. Properties that are
+// not required, or whose type allows null, are nullable. Integers are
+// int64, numbers float64, and strings of the date, time and date-time
+// formats dates, times and timestamps. Objects with properties become
+// structs, objects with only additionalProperties maps and arrays lists;
+// other objects, and arrays without items, are kept as JSON text as
+// DetectJSONSchema keeps them. $ref to $defs or definitions is followed.
+func ParseJSONSchema(definition []byte) (*arrow.Schema, error) {
+	var root jsonSchemaNode
+	if err := json.Unmarshal(definition, &root); err != nil {
+		return nil, fmt.Errorf("invalid JSON schema: %w", err)
+	}
+	if root.Properties == nil {
+		return nil, fmt.Errorf("invalid JSON schema: expected an object with properties")
+	}
+	c := &jsonSchemaConverter{root: &root}
+	dt, _, err := c.convert(&root)
+	if err != nil {
+		return nil, err
+	}
+	st, ok := dt.(*arrow.StructType)
+	if !ok {
+		return nil, fmt.Errorf("invalid JSON schema: expected an object with properties")
+	}
+	return arrow.NewSchema(st.Fields(), nil), nil
+}
+
+// jsonSchemaNode is the part of a JSON Schema the conversion reads
+type jsonSchemaNode struct {
+	Type                 json.RawMessage            `json:"type"`
+	Format               string                     `json:"format"`
+	Properties           *jsonObject                `json:"properties"`
+	Required             []string                   `json:"required"`
+	Items                *jsonSchemaNode            `json:"items"`
+	AdditionalProperties json.RawMessage            `json:"additionalProperties"`
+	Ref                  string                     `json:"$ref"`
+	Defs                 map[string]*jsonSchemaNode `json:"$defs"`
+	Definitions          map[string]*jsonSchemaNode `json:"definitions"`
+	AnyOf                []*jsonSchemaNode          `json:"anyOf"`
+	OneOf                []*jsonSchemaNode          `json:"oneOf"`
+	Enum                 []any                      `json:"enum"`
+}
+
+// jsonSchemaConverter converts the nodes of a JSON Schema, resolving $ref
+// against its root
+type jsonSchemaConverter struct {
+	root  *jsonSchemaNode
+	depth int
+}
+
+// convert returns the Arrow type of a node and whether it allows null
+func (c *jsonSchemaConverter) convert(n *jsonSchemaNode) (arrow.DataType, bool, error) {
+	if n.Ref != "" {
+		ref, err := c.resolve(n.Ref)
+		if err != nil {
+			return nil, false, err
+		}
+		// Recursive schemas have no Arrow type
+		if c.depth++; c.depth > 32 {
+			return nil, false, fmt.Errorf("$ref %s nests too deeply", n.Ref)
+		}
+		defer func() { c.depth-- }()
+		return c.convert(ref)
+	}
+
+	if branches := append(n.AnyOf, n.OneOf...); len(branches) > 0 {
+		var types []*jsonSchemaNode
+		nullable := false
+		for _, b := range branches {
+			if types, _ := b.types(); len(types) == 1 && types[0] == "null" {
+				nullable = true
+				continue
+			}
+			types = append(types, b)
+		}
+		if len(types) != 1 {
+			return nil, false, fmt.Errorf("anyOf and oneOf of several types are not supported")
+		}
+		dt, inner, err := c.convert(types[0])
+		return dt, nullable || inner, err
+	}
+
+	types, err := n.types()
+	if err != nil {
+		return nil, false, err
+	}
+	var typ string
+	nullable := false
+	for _, t := range types {
+		if t == "null" {
+			nullable = true
+		} else if typ == "" {
+			typ = t
+		} else {
+			return nil, false, fmt.Errorf("types %s are not supported together", strings.Join(types, ", "))
+		}
+	}
+	if typ == "" {
+		switch {
+		case n.Properties != nil:
+			typ = "object"
+		case len(n.Enum) > 0:
+			typ = "string"
+		default:
+			return nil, false, fmt.Errorf("a type is required")
+		}
+	}
+
+	switch typ {
+	case "integer":
+		return arrow.PrimitiveTypes.Int64, nullable, nil
+	case "number":
+		return arrow.PrimitiveTypes.Float64, nullable, nil
+	case "boolean":
+		return arrow.FixedWidthTypes.Boolean, nullable, nil
+	case "string":
+		switch n.Format {
+		case "date":
+			return arrow.FixedWidthTypes.Date32, nullable, nil
+		case "time":
+			return arrow.FixedWidthTypes.Time32ms, nullable, nil
+		case "date-time":
+			return arrow.FixedWidthTypes.Timestamp_us, nullable, nil
+		}
+		return arrow.BinaryTypes.String, nullable, nil
+	case "array":
+		if n.Items == nil {
+			return arrow.BinaryTypes.String, nullable, nil
+		}
+		elem, _, err := c.convert(n.Items)
+		if err != nil {
+			return nil, false, fmt.Errorf("items: %w", err)
+		}
+		return arrow.ListOf(elem), nullable, nil
+	case "object":
+		if n.Properties == nil || len(n.Properties.keys) == 0 {
+			var values jsonSchemaNode
+			if len(n.AdditionalProperties) > 0 && json.Unmarshal(n.AdditionalProperties, &values) == nil && len(values.Type)+len(values.Ref) > 0 {
+				dt, _, err := c.convert(&values)
+				if err != nil {
+					return nil, false, fmt.Errorf("additionalProperties: %w", err)
+				}
+				return arrow.MapOf(arrow.BinaryTypes.String, dt), nullable, nil
+			}
+			return arrow.BinaryTypes.String, nullable, nil
+		}
+		fields := make([]arrow.Field, 0, len(n.Properties.keys))
+		for _, name := range n.Properties.keys {
+			var prop jsonSchemaNode
+			if err := json.Unmarshal(n.Properties.values[name], &prop); err != nil {
+				return nil, false, fmt.Errorf("property %s: %w", name, err)
+			}
+			dt, propNullable, err := c.convert(&prop)
+			if err != nil {
+				return nil, false, fmt.Errorf("property %s: %w", name, err)
+			}
+			required := false
+			for _, r := range n.Required {
+				required = required || r == name
+			}
+			fields = append(fields, arrow.Field{Name: name, Type: dt, Nullable: propNullable || !required})
+		}
+		return arrow.StructOf(fields...), nullable, nil
+	}
+	return nil, false, fmt.Errorf("unsupported type %q", typ)
+}
+
+// types returns the type names of a node, one or several
+func (n *jsonSchemaNode) types() ([]string, error) {
+	if len(n.Type) == 0 {
+		return nil, nil
+	}
+	var one string
+	if json.Unmarshal(n.Type, &one) == nil {
+		return []string{one}, nil
+	}
+	var several []string
+	if err := json.Unmarshal(n.Type, &several); err != nil {
+		return nil, fmt.Errorf("invalid type %s", n.Type)
+	}
+	return several, nil
+}
+
+// resolve returns the definition a local $ref points at
+func (c *jsonSchemaConverter) resolve(ref string) (*jsonSchemaNode, error) {
+	for prefix, defs := range map[string]map[string]*jsonSchemaNode{
+		"#/$defs/":       c.root.Defs,
+		"#/definitions/": c.root.Definitions,
+	} {
+		if name, ok := strings.CutPrefix(ref, prefix); ok {
+			if def, ok := defs[name]; ok && def != nil {
+				return def, nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("unresolved $ref %s", ref)
+}
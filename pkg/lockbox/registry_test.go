@@ -0,0 +1,177 @@
+package lockbox
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/TFMV/lockbox/pkg/metadata"
+	"github.com/apache/arrow-go/v18/arrow"
+)
+
+func TestSchemaRegistry(t *testing.T) {
+	password := "test_password_123"
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	orders := `{"type":"record","name":"Order","namespace":"shop","fields":[
+		{"name":"id","type":"long"},
+		{"name":"amount","type":{"type":"bytes","logicalType":"decimal","precision":10,"scale":2}},
+		{"name":"placed","type":{"type":"long","logicalType":"timestamp-micros"}},
+		{"name":"note","type":["null","string"],"default":null},
+		{"name":"tags","type":{"type":"array","items":"string"}},
+		{"name":"status","type":{"type":"enum","name":"Status","symbols":["NEW","PAID"]}},
+		{"name":"previous","type":["null","Status"]}
+	]}`
+	versions := []string{orders}
+	latest := 1
+	events := `{"type":"object","properties":{
+		"id":{"type":"integer"},
+		"at":{"type":"string","format":"date-time"},
+		"user":{"$ref":"#/$defs/user"},
+		"scores":{"type":"array","items":{"type":"number"}},
+		"attrs":{"type":"object","additionalProperties":{"type":"string"}}
+	},"required":["id","at"],
+	"$defs":{"user":{"type":"object","properties":{"name":{"type":["string","null"]}},"required":["name"]}}}`
+
+	var auth string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, pass, _ := r.BasicAuth()
+		auth = user + ":" + pass
+		reply := map[string]any{}
+		switch r.URL.Path {
+		case "/subjects/orders-value/versions/latest":
+			reply = map[string]any{"subject": "orders-value", "version": latest, "id": 10 + latest, "schema": versions[latest-1]}
+		case "/subjects/orders-value/versions/1":
+			reply = map[string]any{"subject": "orders-value", "version": 1, "id": 11, "schema": orders}
+		case "/subjects/events-value/versions/latest":
+			reply = map[string]any{"subject": "events-value", "version": 4, "id": 40, "schema": events, "schemaType": "JSON"}
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			reply = map[string]any{"error_code": 40401, "message": "Subject not found."}
+		}
+		json.NewEncoder(w).Encode(reply)
+	}))
+	defer srv.Close()
+
+	registry := SchemaRegistry{URL: strings.Replace(srv.URL, "http://", "http://key:secret@", 1)}
+	rs, err := registry.Fetch(ctx, "orders-value", 1)
+	if err != nil {
+		t.Fatalf("fetch: %v", err)
+	}
+	if auth != "key:secret" {
+		t.Errorf("expected basic authentication, got %q", auth)
+	}
+	want := metadata.SchemaRef{Registry: srv.URL, Subject: "orders-value", Version: 1, ID: 11, Type: metadata.SchemaTypeAvro}
+	if rs.Ref != want {
+		t.Errorf("expected %+v without credentials, got %+v", want, rs.Ref)
+	}
+	for i, want := range []arrow.Field{
+		{Name: "id", Type: arrow.PrimitiveTypes.Int64},
+		{Name: "amount", Type: &arrow.Decimal128Type{Precision: 10, Scale: 2}},
+		{Name: "placed", Type: arrow.FixedWidthTypes.Timestamp_us},
+		{Name: "note", Type: arrow.BinaryTypes.String, Nullable: true},
+		{Name: "tags", Type: arrow.ListOf(arrow.BinaryTypes.String)},
+		{Name: "status", Type: arrow.BinaryTypes.String},
+		{Name: "previous", Type: arrow.BinaryTypes.String, Nullable: true},
+	} {
+		if got := rs.Schema.Field(i); got.Name != want.Name || got.Nullable != want.Nullable || !arrow.TypeEqual(got.Type, want.Type) {
+			t.Errorf("field %d: expected %v, got %v", i, want, got)
+		}
+	}
+
+	js, err := registry.Fetch(ctx, "events-value", 0)
+	if err != nil {
+		t.Fatalf("fetch json schema: %v", err)
+	}
+	user := arrow.StructOf(arrow.Field{Name: "name", Type: arrow.BinaryTypes.String, Nullable: true})
+	for i, want := range []arrow.Field{
+		{Name: "id", Type: arrow.PrimitiveTypes.Int64},
+		{Name: "at", Type: arrow.FixedWidthTypes.Timestamp_us},
+		{Name: "user", Type: user, Nullable: true},
+		{Name: "scores", Type: arrow.ListOf(arrow.PrimitiveTypes.Float64), Nullable: true},
+		{Name: "attrs", Type: arrow.MapOf(arrow.BinaryTypes.String, arrow.BinaryTypes.String), Nullable: true},
+	} {
+		if got := js.Schema.Field(i); got.Name != want.Name || got.Nullable != want.Nullable || !arrow.TypeEqual(got.Type, want.Type) {
+			t.Errorf("json field %d: expected %v, got %v", i, want, got)
+		}
+	}
+	if js.Ref.Type != metadata.SchemaTypeJSON || js.Ref.Version != 4 {
+		t.Errorf("unexpected reference %+v", js.Ref)
+	}
+	if _, err := registry.Fetch(ctx, "missing", 0); err == nil || !strings.Contains(err.Error(), "Subject not found") {
+		t.Errorf("expected the registry's message, got %v", err)
+	}
+
+	// A flat subject to ingest CSV into, whose version 2 adds a field and
+	// version 3 lets id be null
+	versions = []string{
+		`{"type":"record","name":"Sale","fields":[{"name":"id","type":"long"},{"name":"amount","type":["null","double"]}]}`,
+		`{"type":"record","name":"Sale","fields":[{"name":"id","type":"long"},{"name":"amount","type":["null","double"]},{"name":"region","type":["null","string"]}]}`,
+		`{"type":"record","name":"Sale","fields":[{"name":"id","type":["null","long"]},{"name":"amount","type":["null","double"]}]}`,
+	}
+	rs, err = registry.Fetch(ctx, "orders-value", 0)
+	if err != nil {
+		t.Fatalf("fetch: %v", err)
+	}
+	path := filepath.Join(dir, "sales.lbx")
+	lb, err := Create(path, rs.Schema, WithPassword(password), WithSchemaRef(rs.Ref))
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	defer lb.Close()
+	info, err := lb.Info()
+	if err != nil {
+		t.Fatalf("info: %v", err)
+	}
+	if info.SchemaRef == nil || *info.SchemaRef != rs.Ref {
+		t.Errorf("expected %+v, got %+v", rs.Ref, info.SchemaRef)
+	}
+
+	csvPath := filepath.Join(dir, "sales.csv")
+	if err := os.WriteFile(csvPath, []byte("id,amount\n1,2.5\n2,\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	// The registry recorded at creation is used when none is given
+	latest = 2
+	check := WithSchemaRegistry(SchemaRegistry{})
+	if _, err := lb.IngestCSV(ctx, csvPath, WithPassword(password), check); err != nil {
+		t.Fatalf("ingest: %v", err)
+	}
+	snaps := lb.Snapshots()
+	if l := snaps[len(snaps)-1].Lineage; l == nil || l.Schema == nil || l.Schema.Version != 2 || l.Schema.Subject != "orders-value" {
+		t.Errorf("expected version 2 in the lineage, got %+v", l)
+	}
+
+	latest = 3
+	if _, err := lb.IngestCSV(ctx, csvPath, WithPassword(password), check); err == nil || !strings.Contains(err.Error(), "id became nullable") {
+		t.Errorf("expected version 3 to be refused, got %v", err)
+	}
+	if n := len(lb.Snapshots()); n != len(snaps) {
+		t.Errorf("expected nothing to be committed, got %d snapshots", n)
+	}
+
+	for name, def := range map[string]string{
+		"not a record": `"string"`,
+		"union":        `{"type":"record","name":"r","fields":[{"name":"x","type":["int","string"]}]}`,
+		"unknown type": `{"type":"record","name":"r","fields":[{"name":"x","type":"Missing"}]}`,
+	} {
+		if _, err := ParseAvroSchema([]byte(def)); err == nil {
+			t.Errorf("avro %s: expected an error", name)
+		}
+	}
+	for name, def := range map[string]string{
+		"no properties": `{"type":"string"}`,
+		"recursive":     `{"type":"object","properties":{"n":{"$ref":"#/$defs/n"}},"$defs":{"n":{"type":"object","properties":{"next":{"$ref":"#/$defs/n"}}}}}`,
+		"several types": `{"type":"object","properties":{"x":{"type":["string","integer"]}}}`,
+	} {
+		if _, err := ParseJSONSchema([]byte(def)); err == nil {
+			t.Errorf("json schema %s: expected an error", name)
+		}
+	}
+}
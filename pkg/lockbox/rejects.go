@@ -0,0 +1,155 @@
+package lockbox
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/apache/arrow-go/v18/arrow"
+	"github.com/apache/arrow-go/v18/arrow/array"
+	"github.com/apache/arrow-go/v18/arrow/memory"
+)
+
+// ErrorPolicy decides what loading does with input rows that cannot be
+// converted to the schema
+type ErrorPolicy string
+
+const (
+	// OnErrorAbort fails the load at the first bad row
+	OnErrorAbort ErrorPolicy = "abort"
+	// OnErrorSkip drops bad rows and only counts them
+	OnErrorSkip ErrorPolicy = "skip"
+	// OnErrorQuarantine drops bad rows and keeps them with the reason
+	OnErrorQuarantine ErrorPolicy = "quarantine"
+)
+
+// ParseErrorPolicy validates a policy name such as "skip"
+func ParseErrorPolicy(name string) (ErrorPolicy, error) {
+	switch p := ErrorPolicy(strings.ToLower(name)); p {
+	case OnErrorAbort, OnErrorSkip, OnErrorQuarantine:
+		return p, nil
+	case "":
+		return OnErrorAbort, nil
+	default:
+		return "", fmt.Errorf("unsupported error policy: %s", name)
+	}
+}
+
+// WithOnError sets how LoadCSV and LoadJSON handle bad rows. Rows dropped
+// under OnErrorSkip or OnErrorQuarantine are counted in rejects, which may
+// be nil; only OnErrorQuarantine keeps the rows themselves.
+func WithOnError(policy ErrorPolicy, rejects *Rejects) Option {
+	return func(o *Options) {
+		o.OnError = policy
+		o.Rejects = rejects
+	}
+}
+
+// RejectedRow is an input row that could not be loaded
+type RejectedRow struct {
+	// Line is the line in a CSV or NDJSON file or the position of a JSON
+	// object
+	Line int `json:"line"`
+	// Column is the column that failed to convert, empty when the row as a
+	// whole is malformed
+	Column string `json:"column,omitempty"`
+	Reason string `json:"reason"`
+	// Raw is the row as it appeared in the input
+	Raw string `json:"raw"`
+}
+
+// Rejects collects the rows dropped while loading
+type Rejects struct {
+	Count    int
+	ByColumn map[string]int
+	Rows     []RejectedRow
+}
+
+// rejectRow returns err under OnErrorAbort. Otherwise it records the row
+// and returns nil so that loading goes on.
+func rejectRow(options *Options, row RejectedRow, err error) error {
+	if options.OnError != OnErrorSkip && options.OnError != OnErrorQuarantine {
+		return err
+	}
+	r := options.Rejects
+	if r == nil {
+		return nil
+	}
+	r.Count++
+	if r.ByColumn == nil {
+		r.ByColumn = map[string]int{}
+	}
+	r.ByColumn[row.Column]++
+	if options.OnError == OnErrorQuarantine {
+		r.Rows = append(r.Rows, row)
+	}
+	return nil
+}
+
+// Summary describes the rejected rows, e.g. "3 rows rejected (amount: 2,
+// malformed: 1)"
+func (r *Rejects) Summary() string {
+	if r.Count == 0 {
+		return "no rows rejected"
+	}
+	columns := make([]string, 0, len(r.ByColumn))
+	for c := range r.ByColumn {
+		columns = append(columns, c)
+	}
+	sort.Strings(columns)
+	parts := make([]string, len(columns))
+	for i, c := range columns {
+		name := c
+		if name == "" {
+			name = "malformed"
+		}
+		parts[i] = fmt.Sprintf("%s: %d", name, r.ByColumn[c])
+	}
+	noun := "rows"
+	if r.Count == 1 {
+		noun = "row"
+	}
+	return fmt.Sprintf("%d %s rejected (%s)", r.Count, noun, strings.Join(parts, ", "))
+}
+
+// RejectSchema is the schema of Rejects.Record, for keeping quarantined
+// rows in a lockbox of their own
+var RejectSchema = arrow.NewSchema([]arrow.Field{
+	{Name: "line", Type: arrow.PrimitiveTypes.Int64},
+	{Name: "column", Type: arrow.BinaryTypes.String, Nullable: true},
+	{Name: "reason", Type: arrow.BinaryTypes.String},
+	{Name: "raw", Type: arrow.BinaryTypes.String},
+}, nil)
+
+// Record returns the quarantined rows with RejectSchema. The caller
+// releases the record.
+func (r *Rejects) Record() arrow.Record {
+	b := array.NewRecordBuilder(memory.NewGoAllocator(), RejectSchema)
+	defer b.Release()
+	for _, row := range r.Rows {
+		b.Field(0).(*array.Int64Builder).Append(int64(row.Line))
+		if row.Column == "" {
+			b.Field(1).AppendNull()
+		} else {
+			b.Field(1).(*array.StringBuilder).Append(row.Column)
+		}
+		b.Field(2).(*array.StringBuilder).Append(row.Reason)
+		b.Field(3).(*array.StringBuilder).Append(row.Raw)
+	}
+	return b.NewRecord()
+}
+
+// WriteCSV writes the quarantined rows as CSV with the columns of
+// RejectSchema
+func (r *Rejects) WriteCSV(w io.Writer) error {
+	var sb strings.Builder
+	sb.WriteString("line,column,reason,raw\n")
+	for _, row := range r.Rows {
+		fields := []string{strconv.Itoa(row.Line), row.Column, row.Reason, row.Raw}
+		sb.WriteString(csvLine(fields, ',', '"'))
+	}
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
@@ -0,0 +1,83 @@
+package lockbox
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/apache/arrow-go/v18/arrow"
+	"github.com/apache/arrow-go/v18/arrow/array"
+)
+
+func TestLoadErrorPolicy(t *testing.T) {
+	schema := arrow.NewSchema([]arrow.Field{
+		{Name: "id", Type: arrow.PrimitiveTypes.Int64},
+		{Name: "amount", Type: arrow.PrimitiveTypes.Float64, Nullable: true},
+	}, nil)
+	dir := t.TempDir()
+	csvPath := filepath.Join(dir, "dirty.csv")
+	os.WriteFile(csvPath, []byte("id,amount\n1,2.5\nx,1\n3\n4,\n5,abc\n"), 0600)
+	jsonPath := filepath.Join(dir, "dirty.ndjson")
+	os.WriteFile(jsonPath, []byte(`{"id": 1, "amount": 2.5}
+{"id": "two", "amount": 1}
+{"amount": 3}
+{"id": 4}
+`), 0600)
+
+	if _, err := LoadCSV(csvPath, schema); err == nil || !strings.Contains(err.Error(), "line 3, column id") {
+		t.Fatalf("expected abort at line 3, got %v", err)
+	}
+	if _, err := LoadJSON(jsonPath, schema, WithOnError(OnErrorAbort, nil)); err == nil || !strings.Contains(err.Error(), "object 2, column id") {
+		t.Fatalf("expected abort at object 2, got %v", err)
+	}
+
+	var skipped Rejects
+	rec, err := LoadCSV(csvPath, schema, WithOnError(OnErrorSkip, &skipped))
+	if err != nil {
+		t.Fatalf("load with skip: %v", err)
+	}
+	ids := rec.Column(0).(*array.Int64)
+	if rec.NumRows() != 2 || ids.Value(0) != 1 || ids.Value(1) != 4 || !rec.Column(1).IsNull(1) {
+		t.Fatalf("unexpected rows %v", rec)
+	}
+	rec.Release()
+	if skipped.Count != 3 || len(skipped.Rows) != 0 {
+		t.Fatalf("unexpected skip rejects %+v", skipped)
+	}
+	if got := skipped.Summary(); got != "3 rows rejected (malformed: 1, amount: 1, id: 1)" {
+		t.Fatalf("unexpected summary %q", got)
+	}
+
+	var quarantined Rejects
+	rec, err = LoadJSON(jsonPath, schema, WithOnError(OnErrorQuarantine, &quarantined))
+	if err != nil {
+		t.Fatalf("load with quarantine: %v", err)
+	}
+	if rec.NumRows() != 2 {
+		t.Fatalf("expected 2 rows, got %d", rec.NumRows())
+	}
+	rec.Release()
+	if len(quarantined.Rows) != 2 || quarantined.Rows[0].Line != 2 || quarantined.Rows[1].Raw != `{"amount":3}` {
+		t.Fatalf("unexpected quarantined rows %+v", quarantined.Rows)
+	}
+
+	var buf bytes.Buffer
+	if err := quarantined.WriteCSV(&buf); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 3 || lines[0] != "line,column,reason,raw" || !strings.HasPrefix(lines[2], `3,id,missing value,"{""amount"":3}"`) {
+		t.Fatalf("unexpected quarantine csv %q", buf.String())
+	}
+	qrec := quarantined.Record()
+	defer qrec.Release()
+	if !qrec.Schema().Equal(RejectSchema) || qrec.NumRows() != 2 {
+		t.Fatalf("unexpected quarantine record %v", qrec)
+	}
+
+	if _, err := ParseErrorPolicy("ignore"); err == nil {
+		t.Fatal("expected error for unknown policy")
+	}
+}
@@ -0,0 +1,189 @@
+package lockbox
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/TFMV/lockbox/pkg/metadata"
+)
+
+// ReplicaResult summarizes a snapshot export
+type ReplicaResult struct {
+	Source    string `json:"source"`
+	Target    string `json:"target"`
+	Snapshot  int64  `json:"snapshot"`
+	RowGroups int    `json:"rowGroups"`
+	Rows      int64  `json:"rows"`
+	// Rewrapped is set when the replica has a password of its own
+	Rewrapped bool `json:"rewrapped"`
+	// Verified is set once every row group of the replica was read back
+	// and matched the source
+	Verified bool `json:"verified"`
+}
+
+// WithReplicaPassword protects a replica written by ExportSnapshot with a
+// password of its own instead of the password of the source
+func WithReplicaPassword(password string) Option {
+	return func(o *Options) {
+		o.ReplicaPassword = password
+	}
+}
+
+// ExportSnapshot writes the rows of the lockbox as of snapshot id to a new
+// standalone file dst, a replica that always opens read-only, so heavy
+// analytical readers can work off a copy without contending with writers
+// of the source. Zero exports the latest snapshot.
+//
+// The password given with WithPassword must unlock the whole file. It also
+// protects the replica unless WithReplicaPassword gives another one; either
+// way the replica has a master key of its own. Each row group up to the
+// snapshot is copied as one commit, and views, virtual columns, the access
+// policy, tags and the description are carried over; hooks are not, as
+// the replica is never written. Row groups are copied as they are now, so
+// rewrites of their rows committed after the snapshot, such as compute,
+// are included.
+//
+// The replica is verified against the source by row count and checksum of
+// each row group. dst must not exist and is removed when the export fails.
+func (lb *Lockbox) ExportSnapshot(ctx context.Context, id int64, dst string, opts ...Option) (*ReplicaResult, error) {
+	options := &Options{CreatedBy: "system"}
+	for _, opt := range opts {
+		opt(options)
+	}
+	if options.Password == "" {
+		return nil, fmt.Errorf("password is required for exporting a snapshot")
+	}
+	if _, err := os.Stat(dst); err == nil {
+		return nil, fmt.Errorf("%s already exists", dst)
+	}
+	if _, err := lb.file.MasterKey(options.Password); err != nil {
+		return nil, fmt.Errorf("exporting a snapshot needs the password of the whole file: %w", err)
+	}
+	meta := lb.file.Metadata()
+	if id == 0 {
+		if len(meta.Snapshots) == 0 {
+			return nil, fmt.Errorf("%s has no commits to export", lb.file.Path())
+		}
+		id = meta.Snapshots[len(meta.Snapshots)-1].ID
+	}
+	groups, err := meta.RowGroupsAt(id)
+	if err != nil {
+		return nil, err
+	}
+	reader, err := lb.file.NewReader(options.Password)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create reader: %w", err)
+	}
+
+	password := options.Password
+	if options.ReplicaPassword != "" {
+		password = options.ReplicaPassword
+	}
+	res := &ReplicaResult{
+		Source:    lb.file.Path(),
+		Target:    dst,
+		Snapshot:  id,
+		RowGroups: groups,
+		Rewrapped: password != options.Password,
+	}
+
+	createOpts := []Option{
+		WithPassword(password),
+		WithCreatedBy(options.CreatedBy),
+		WithCryptoModule(meta.Encryption.ModuleName()),
+		WithAllocator(options.Allocator),
+	}
+	if fi, err := os.Stat(lb.file.Path()); err == nil {
+		createOpts = append(createOpts, WithFileMode(fi.Mode().Perm()))
+	}
+	for _, g := range meta.Encryption.ColumnGroups {
+		createOpts = append(createOpts, WithColumnGroup(g.Name, g.Columns...))
+	}
+	if lb.file.MetadataEncrypted() {
+		createOpts = append(createOpts, WithEncryptedMetadata())
+	}
+	if enc := meta.Encryption; enc.KeyDerivation == metadata.KDFArgon2id {
+		createOpts = append(createOpts, WithArgon2id(*enc.Argon2))
+	}
+	target, err := Create(dst, meta.Schema, createOpts...)
+	if err != nil {
+		return nil, err
+	}
+	fail := func(err error) (*ReplicaResult, error) {
+		target.Close()
+		os.Remove(dst)
+		return nil, err
+	}
+
+	// Wrapping the master key lets wrong passwords be detected
+	masterKey, err := target.file.MasterKey(password)
+	if err != nil {
+		return fail(err)
+	}
+	if err := target.file.SetPassword(masterKey, password); err != nil {
+		return fail(err)
+	}
+
+	lineage := metadata.Lineage{Source: res.Source, Transformation: fmt.Sprintf("replica of snapshot %d", id)}
+	for n := 0; n < groups; n++ {
+		if err := ctx.Err(); err != nil {
+			return fail(err)
+		}
+		rec, err := reader.ReadRowGroup(n, nil)
+		if err != nil {
+			return fail(fmt.Errorf("failed to read row group %d: %w", n, err))
+		}
+		err = target.Write(ctx, rec,
+			WithPassword(password),
+			WithCodec(options.Codec),
+			WithDictionary(options.Dictionary...),
+			WithMessage(fmt.Sprintf("row group %d of %s", n, res.Source)),
+			WithLineage(lineage),
+			withOperation(OperationReplica))
+		res.Rows += rec.NumRows()
+		rec.Release()
+		if err != nil {
+			return fail(fmt.Errorf("failed to write row group %d: %w", n, err))
+		}
+	}
+
+	tmeta := target.file.Metadata()
+	tmeta.Views = meta.Views
+	tmeta.Virtual = meta.Virtual
+	tmeta.AccessPolicy = meta.AccessPolicy
+	tmeta.Tags = meta.Tags
+	tmeta.Description = meta.Description
+	tmeta.Preset = meta.Preset
+	tmeta.SyntheticKey = meta.SyntheticKey
+	tmeta.Sequences = meta.Sequences
+	if err := target.RefreshViews(ctx, nil, WithPassword(password)); err != nil {
+		return fail(fmt.Errorf("failed to refresh materialized views: %w", err))
+	}
+	// Marking the replica comes last: it is read-only from its next open
+	tmeta.Replica = &metadata.Replica{Source: res.Source, Snapshot: id, ExportedAt: time.Now().UTC(), ExportedBy: options.CreatedBy}
+	tmeta.LogAccess(options.CreatedBy, "export-snapshot", res.Source, true, fmt.Sprintf("%d rows in %d row groups as of snapshot %d", res.Rows, groups, id))
+	if err := target.file.SaveMetadata(); err != nil {
+		return fail(err)
+	}
+	if err := target.Close(); err != nil {
+		os.Remove(dst)
+		return nil, err
+	}
+
+	if err := verifyCopy(reader, dst, password, groups, lb.file.Allocator()); err != nil {
+		os.Remove(dst)
+		return nil, fmt.Errorf("verification failed: %w", err)
+	}
+	res.Verified = true
+
+	// Sources in object stores are read-only and keep no record
+	if !lb.file.ReadOnly() {
+		meta.LogAccess(options.CreatedBy, "export-snapshot", dst, true, fmt.Sprintf("snapshot %d", id))
+		if err := lb.file.SaveMetadata(); err != nil {
+			return res, err
+		}
+	}
+	return res, nil
+}
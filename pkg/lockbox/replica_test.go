@@ -0,0 +1,81 @@
+package lockbox
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+)
+
+func TestExportSnapshot(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "src.lbx")
+	password := "test_password_123"
+	ctx := context.Background()
+
+	lb := newQueryTestLockbox(t, src, password)
+	defer lb.Close()
+	first := lb.Snapshots()[0].ID
+
+	// A second commit the replica of the first snapshot must not see
+	rec, err := lb.Read(ctx, WithPassword(password))
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if err := lb.Write(ctx, rec, WithPassword(password)); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	rec.Release()
+	if err := lb.AddVirtualColumn("double", "score * 2"); err != nil {
+		t.Fatalf("virtual: %v", err)
+	}
+
+	if _, err := lb.ExportSnapshot(ctx, first, filepath.Join(dir, "bad.lbx"), WithPassword("wrong_password_789")); err == nil {
+		t.Fatalf("expected an export with a wrong password to fail")
+	}
+	if _, err := lb.ExportSnapshot(ctx, 99, filepath.Join(dir, "bad.lbx"), WithPassword(password)); err == nil {
+		t.Fatalf("expected an export of an unknown snapshot to fail")
+	}
+
+	dst := filepath.Join(dir, "replica.lbx")
+	res, err := lb.ExportSnapshot(ctx, first, dst, WithPassword(password), WithReplicaPassword("replica_password_456"))
+	if err != nil {
+		t.Fatalf("export snapshot: %v", err)
+	}
+	if !res.Verified || !res.Rewrapped || res.Rows != 3 || res.RowGroups != 1 || res.Snapshot != first {
+		t.Errorf("unexpected result: %+v", res)
+	}
+	if _, err := lb.ExportSnapshot(ctx, first, dst, WithPassword(password)); err == nil {
+		t.Errorf("expected an export onto an existing file to fail")
+	}
+
+	replica, err := Open(dst, WithPassword("replica_password_456"))
+	if err != nil {
+		t.Fatalf("open replica: %v", err)
+	}
+	defer replica.Close()
+	out, err := replica.Query(ctx, "SELECT COUNT(*) AS n, SUM(double) AS d FROM data", WithPassword("replica_password_456"))
+	if err != nil {
+		t.Fatalf("query replica: %v", err)
+	}
+	if out.NumRows() != 1 || out.Column(0).ValueStr(0) != "3" {
+		t.Errorf("expected the 3 rows of the first snapshot, got %v", out)
+	}
+	out.Release()
+
+	rec, err = replica.Read(ctx, WithPassword("replica_password_456"))
+	if err != nil {
+		t.Fatalf("read replica: %v", err)
+	}
+	defer rec.Release()
+	if err := replica.Write(ctx, rec, WithPassword("replica_password_456")); err == nil {
+		t.Errorf("expected writes to the replica to be refused")
+	}
+
+	info, err := ReadInfo(dst)
+	if err != nil {
+		t.Fatalf("info: %v", err)
+	}
+	if info.Replica == nil || info.Replica.Snapshot != first || info.Replica.Source != src {
+		t.Errorf("expected the replica to name its source, got %+v", info.Replica)
+	}
+}
@@ -0,0 +1,87 @@
+package lockbox
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/apache/arrow-go/v18/arrow"
+	"github.com/apache/arrow-go/v18/arrow/array"
+	"github.com/apache/arrow-go/v18/arrow/memory"
+)
+
+func TestStatsRollup(t *testing.T) {
+	dir := t.TempDir()
+	password := "test_password_123"
+	ctx := context.Background()
+	schema := arrow.NewSchema([]arrow.Field{
+		{Name: "id", Type: arrow.PrimitiveTypes.Int64},
+		{Name: "name", Type: arrow.BinaryTypes.String, Nullable: true},
+	}, nil)
+	write := func(lb *Lockbox, ids []int64, names []string, valid []bool) {
+		t.Helper()
+		b := array.NewRecordBuilder(memory.NewGoAllocator(), schema)
+		b.Field(0).(*array.Int64Builder).AppendValues(ids, nil)
+		b.Field(1).(*array.StringBuilder).AppendValues(names, valid)
+		rec := b.NewRecord()
+		b.Release()
+		if err := lb.Write(ctx, rec, WithPassword(password)); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+		rec.Release()
+	}
+
+	path := filepath.Join(dir, "sealed.lbx")
+	lb, err := Create(path, schema, WithPassword(password), WithEncryptedMetadata())
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	defer lb.Close()
+	write(lb, []int64{5, 9}, []string{"bob", ""}, []bool{true, false})
+	write(lb, []int64{12, 3, 7}, []string{"ann", "cy", "dee"}, nil)
+
+	info, err := lb.Info()
+	if err != nil {
+		t.Fatalf("info: %v", err)
+	}
+	if info.RowCount != 5 {
+		t.Errorf("expected 5 rows, got %d", info.RowCount)
+	}
+	id, name := info.Columns["id"], info.Columns["name"]
+	if id == nil || id.Rows != 5 || id.Min != "3" || id.Max != "12" || id.Partial {
+		t.Errorf("expected id to range over 3 .. 12 across groups, got %+v", id)
+	}
+	if name == nil || name.Nulls != 1 || name.Min != "ann" || name.Max != "dee" {
+		t.Errorf("expected name to range over ann .. dee with one null, got %+v", name)
+	}
+
+	// Replacing a column sums the blocks up again
+	b := array.NewInt64Builder(memory.NewGoAllocator())
+	b.AppendValues([]int64{100, 101, 102, 103, 104}, nil)
+	col := b.NewArray()
+	b.Release()
+	defer col.Release()
+	if err := lb.ReplaceColumn(ctx, "id", col, WithPassword(password)); err != nil {
+		t.Fatalf("replace column: %v", err)
+	}
+	if info, err = lb.Info(); err != nil {
+		t.Fatalf("info: %v", err)
+	}
+	if id := info.Columns["id"]; id == nil || id.Rows != 5 || id.Min != "100" || id.Max != "104" {
+		t.Errorf("expected the rollup to follow the new column, got %+v", id)
+	}
+
+	// Cleartext metadata keeps counts but not value ranges
+	clear, err := Create(filepath.Join(dir, "clear.lbx"), schema, WithPassword(password))
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	defer clear.Close()
+	write(clear, []int64{5, 9}, []string{"bob", ""}, []bool{true, false})
+	if info, err = clear.Info(); err != nil {
+		t.Fatalf("info: %v", err)
+	}
+	if name := info.Columns["name"]; name == nil || name.Rows != 2 || name.Nulls != 1 || name.Min != "" || name.Max != "" {
+		t.Errorf("expected counts without a value range, got %+v", name)
+	}
+}
@@ -0,0 +1,101 @@
+package lockbox
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/apache/arrow-go/v18/arrow"
+	"github.com/apache/arrow-go/v18/arrow/array"
+)
+
+func TestAppendedRowGroups(t *testing.T) {
+	schema := arrow.NewSchema([]arrow.Field{
+		{Name: "id", Type: arrow.PrimitiveTypes.Int64},
+		{Name: "name", Type: arrow.BinaryTypes.String, Nullable: true},
+	}, nil)
+	path := filepath.Join(t.TempDir(), "groups.lbx")
+	password := "test_password_123"
+	ctx := context.Background()
+
+	lb, err := Create(path, schema, WithPassword(password), WithDebugAllocator())
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	next := int64(0)
+	for _, n := range []int{2, 3} {
+		b := array.NewRecordBuilder(lb.Allocator(), schema)
+		for i := 0; i < n; i++ {
+			b.Field(0).(*array.Int64Builder).Append(next)
+			b.Field(1).(*array.StringBuilder).Append("row")
+			next++
+		}
+		rec := b.NewRecord()
+		b.Release()
+		if err := lb.Write(ctx, rec, WithPassword(password)); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+		rec.Release()
+	}
+	if err := lb.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+
+	lb, err = Open(path, WithPassword(password), WithDebugAllocator())
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer lb.Close()
+
+	meta := lb.file.Metadata()
+	if meta.NumRowGroups() != 2 {
+		t.Fatalf("expected 2 row groups, got %d", meta.NumRowGroups())
+	}
+	for _, bi := range meta.BlockInfo {
+		if want := int(bi.RowCount / 3); bi.RowGroup != want {
+			t.Errorf("block of %s with %d rows in row group %d, expected %d", bi.ColumnName, bi.RowCount, bi.RowGroup, want)
+		}
+	}
+
+	// Reads see the rows of every write, in write order
+	rec, err := lb.Read(ctx, WithPassword(password))
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	ids := rec.Column(0).(*array.Int64)
+	if ids.Len() != 5 {
+		t.Fatalf("expected 5 rows, got %d", ids.Len())
+	}
+	for i := 0; i < ids.Len(); i++ {
+		if ids.Value(i) != int64(i) {
+			t.Errorf("row %d: expected id %d, got %d", i, i, ids.Value(i))
+		}
+	}
+	rec.Release()
+
+	rec, err = lb.Query(ctx, "SELECT count(*) FROM data WHERE id >= 1", WithPassword(password))
+	if err != nil {
+		t.Fatalf("query: %v", err)
+	}
+	if got := rec.Column(0).(*array.Int64).Value(0); got != 4 {
+		t.Errorf("expected count 4 across row groups, got %d", got)
+	}
+	rec.Release()
+
+	// A single row group can be read on its own
+	reader, err := lb.readerFor(password)
+	if err != nil {
+		t.Fatalf("reader: %v", err)
+	}
+	group, err := reader.ReadRowGroup(1, []string{"id"})
+	if err != nil {
+		t.Fatalf("ReadRowGroup: %v", err)
+	}
+	if group.NumRows() != 3 || group.NumCols() != 1 || group.Column(0).(*array.Int64).Value(0) != 2 {
+		t.Errorf("expected ids 2..4 in row group 1, got %v", group)
+	}
+	group.Release()
+	if _, err := reader.ReadRowGroup(2, nil); err == nil {
+		t.Errorf("expected an error for a row group out of range")
+	}
+}
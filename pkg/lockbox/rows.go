@@ -0,0 +1,411 @@
+package lockbox
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"reflect"
+	"time"
+
+	"github.com/TFMV/lockbox/pkg/geo"
+	"github.com/apache/arrow-go/v18/arrow"
+	"github.com/apache/arrow-go/v18/arrow/array"
+)
+
+// AppendRows writes rows given as maps from column names to values, for
+// small writes where building a record is overkill. Values may be Go
+// values of the column's type, any number that fits a numeric column, or
+// text as LoadCSV converts it, e.g. "2024-03-01T12:00:00Z" for a timestamp;
+// nil and missing columns are null. Unknown columns are an error. The rows
+// are written in one commit with opts, as Write does.
+func (lb *Lockbox) AppendRows(ctx context.Context, rows []map[string]any, opts ...Option) error {
+	if len(rows) == 0 {
+		return nil
+	}
+	options := &Options{}
+	for _, opt := range opts {
+		opt(options)
+	}
+	schema := lb.Schema()
+	b := array.NewRecordBuilder(lb.Allocator(), schema)
+	defer b.Release()
+	p := newValueParser(options)
+
+	for r, row := range rows {
+		for name := range row {
+			if len(schema.FieldIndices(name)) == 0 {
+				return fmt.Errorf("row %d: unknown column %s", r, name)
+			}
+		}
+		for i, field := range schema.Fields() {
+			if err := appendAny(p, b.Field(i), row[field.Name]); err != nil {
+				// Nothing is written when any row is refused
+				return fmt.Errorf("row %d, column %s: %w", r, field.Name, err)
+			}
+		}
+	}
+
+	rec := b.NewRecord()
+	defer rec.Release()
+	return lb.Write(ctx, rec, opts...)
+}
+
+// appendAny appends a dynamically typed value to a builder
+func appendAny(p *valueParser, b array.Builder, v any) error {
+	if v == nil {
+		b.AppendNull()
+		return nil
+	}
+	rv := reflect.ValueOf(v)
+	if rv.Kind() == reflect.Pointer {
+		if rv.IsNil() {
+			b.AppendNull()
+			return nil
+		}
+		rv = rv.Elem()
+	}
+	if fits(rv.Type(), b.Type(), true) {
+		return appendGoValue(b, rv)
+	}
+
+	// Numbers of another width or kind than the column's, within range
+	var f float64
+	switch {
+	case rv.CanInt():
+		f = float64(rv.Int())
+	case rv.CanUint():
+		f = float64(rv.Uint())
+	case rv.CanFloat():
+		f = rv.Float()
+	case rv.Kind() == reflect.String:
+		if _, ok := b.(*array.BinaryBuilder); !ok {
+			appendValue, err := p.parseValue(b, rv.String())
+			if err != nil {
+				return err
+			}
+			appendValue()
+			return nil
+		}
+	case rv.Type() == reflect.TypeOf([]float64(nil)):
+		if dim, ok := vectorDim(b.Type()); ok && rv.Len() == dim {
+			vec := make([]float32, dim)
+			for i, x := range v.([]float64) {
+				vec[i] = float32(x)
+			}
+			return appendGoValue(b, reflect.ValueOf(vec))
+		}
+	case rv.Type() == reflect.TypeOf(geo.Point{}):
+		if sb, ok := b.(*array.StructBuilder); ok && arrow.TypeEqual(b.Type(), geo.PointType()) {
+			appendPoint(sb, rv.Interface().(geo.Point))
+			return nil
+		}
+	}
+	if rv.CanInt() || rv.CanUint() || rv.CanFloat() {
+		if bits, signed := intBits(b.Type()); bits > 0 {
+			lo, hi := 0.0, math.Exp2(float64(bits))
+			if signed {
+				lo, hi = -hi/2, hi/2
+			}
+			if f != math.Trunc(f) || f < lo || f >= hi {
+				return fmt.Errorf("%v does not fit %s", v, b.Type())
+			}
+		}
+		switch b := b.(type) {
+		case *array.Int8Builder:
+			b.Append(int8(f))
+		case *array.Int16Builder:
+			b.Append(int16(f))
+		case *array.Int32Builder:
+			b.Append(int32(f))
+		case *array.Int64Builder:
+			if rv.CanInt() {
+				b.Append(rv.Int())
+			} else if rv.CanUint() {
+				b.Append(int64(rv.Uint()))
+			} else {
+				b.Append(int64(f))
+			}
+		case *array.Uint8Builder:
+			b.Append(uint8(f))
+		case *array.Uint16Builder:
+			b.Append(uint16(f))
+		case *array.Uint32Builder:
+			b.Append(uint32(f))
+		case *array.Uint64Builder:
+			if rv.CanUint() {
+				b.Append(rv.Uint())
+			} else {
+				b.Append(uint64(f))
+			}
+		case *array.Float32Builder:
+			b.Append(float32(f))
+		case *array.Float64Builder:
+			b.Append(f)
+		case *array.Decimal128Builder:
+			n, err := parseDecimal(fmt.Sprint(rv.Interface()), b.Type().(*arrow.Decimal128Type))
+			if err != nil {
+				return err
+			}
+			b.Append(n)
+		case *array.DurationBuilder:
+			// A time.Duration, or a whole number of the column's unit
+			unit := b.Type().(*arrow.DurationType).Unit
+			if rv.Type() == reflect.TypeOf(time.Duration(0)) {
+				b.Append(arrow.Duration(time.Duration(rv.Int()) / unit.Multiplier()))
+			} else if f == math.Trunc(f) {
+				b.Append(arrow.Duration(f))
+			} else {
+				return fmt.Errorf("%v does not fit %s", v, b.Type())
+			}
+		default:
+			return fmt.Errorf("cannot store %T in a %s column", v, b.Type())
+		}
+		return nil
+	}
+	return fmt.Errorf("cannot store %T in a %s column", v, b.Type())
+}
+
+// Rows iterates over the rows of a lockbox one at a time, like the rows of
+// database/sql:
+//
+//	rows, err := lb.Rows(ctx, lockbox.ScanOptions{Filter: "age > 30"}, lockbox.WithPassword(pw))
+//	if err != nil { ... }
+//	defer rows.Close()
+//	for rows.Next() {
+//		var id int64
+//		var name *string // nil for nulls
+//		if err := rows.Scan(&id, &name); err != nil { ... }
+//	}
+//	if err := rows.Err(); err != nil { ... }
+//
+// Rows are decrypted batch by batch by a Scanner, so memory stays bounded;
+// for more than a few thousand rows, reading records is much faster.
+type Rows struct {
+	scanner *Scanner
+	rec     arrow.Record
+	row     int
+	err     error
+}
+
+// Rows returns the rows of the columns and filter of scan, under the access
+// policy of the principal of opts
+func (lb *Lockbox) Rows(ctx context.Context, scan ScanOptions, opts ...Option) (*Rows, error) {
+	scanner, err := lb.NewScanner(ctx, scan, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return &Rows{scanner: scanner}, nil
+}
+
+// Columns returns the names of the columns of the rows
+func (r *Rows) Columns() []string {
+	fields := r.scanner.Schema().Fields()
+	names := make([]string, len(fields))
+	for i, f := range fields {
+		names[i] = f.Name
+	}
+	return names
+}
+
+// Next advances to the next row, reporting whether there is one
+func (r *Rows) Next() bool {
+	if r.err != nil {
+		return false
+	}
+	r.row++
+	for r.rec == nil || r.row >= int(r.rec.NumRows()) {
+		if !r.scanner.Next() {
+			r.rec = nil
+			r.err = r.scanner.Err()
+			return false
+		}
+		r.rec, r.row = r.scanner.Record(), 0
+	}
+	return true
+}
+
+// Values returns the values of the current row in column order, as Value
+// converts them
+func (r *Rows) Values() []any {
+	values := make([]any, r.rec.NumCols())
+	for i, col := range r.rec.Columns() {
+		values[i] = Value(col, r.row)
+	}
+	return values
+}
+
+// Map returns the current row as a map from column names to values, as
+// Value converts them
+func (r *Rows) Map() map[string]any {
+	m := make(map[string]any, r.rec.NumCols())
+	for i, col := range r.rec.Columns() {
+		m[r.rec.ColumnName(i)] = Value(col, r.row)
+	}
+	return m
+}
+
+// Scan copies the columns of the current row into dest, one pointer per
+// column in order. Values convert to pointers of their own type, to other
+// numeric types they fit and to *any; every value converts to *string.
+// Nulls need a pointer to a pointer, e.g. **string, which is set to nil.
+func (r *Rows) Scan(dest ...any) error {
+	if r.rec == nil {
+		return fmt.Errorf("Scan called without a row")
+	}
+	if len(dest) != int(r.rec.NumCols()) {
+		return fmt.Errorf("expected %d destinations, got %d", r.rec.NumCols(), len(dest))
+	}
+	for i, d := range dest {
+		if err := scanValue(d, Value(r.rec.Column(i), r.row)); err != nil {
+			return fmt.Errorf("column %s: %w", r.rec.ColumnName(i), err)
+		}
+	}
+	return nil
+}
+
+// Err returns the error that ended the iteration, if any
+func (r *Rows) Err() error {
+	return r.err
+}
+
+// Close releases the rows
+func (r *Rows) Close() error {
+	r.scanner.Release()
+	r.rec = nil
+	return nil
+}
+
+// Value returns the value of an array at row i as a Go value: nil for
+// nulls, the native type for numbers, booleans and strings, []byte for
+// binary, time.Time for timestamps and dates, time.Duration for
+// durations, []float32 for vectors and geo.Point for points. Other types
+// come back as text, decimals with every digit of their scale.
+func Value(arr arrow.Array, i int) any {
+	if arr.IsNull(i) {
+		return nil
+	}
+	switch a := arr.(type) {
+	case *array.Int8:
+		return a.Value(i)
+	case *array.Int16:
+		return a.Value(i)
+	case *array.Int32:
+		return a.Value(i)
+	case *array.Int64:
+		return a.Value(i)
+	case *array.Uint8:
+		return a.Value(i)
+	case *array.Uint16:
+		return a.Value(i)
+	case *array.Uint32:
+		return a.Value(i)
+	case *array.Uint64:
+		return a.Value(i)
+	case *array.Float32:
+		return a.Value(i)
+	case *array.Float64:
+		return a.Value(i)
+	case *array.Boolean:
+		return a.Value(i)
+	case *array.String:
+		return a.Value(i)
+	case *array.LargeString:
+		return a.Value(i)
+	case *array.Binary:
+		return append([]byte(nil), a.Value(i)...)
+	case *array.LargeBinary:
+		return append([]byte(nil), a.Value(i)...)
+	case *array.Timestamp:
+		return a.Value(i).ToTime(a.DataType().(*arrow.TimestampType).Unit)
+	case *array.Date32:
+		return a.Value(i).ToTime()
+	case *array.Date64:
+		return a.Value(i).ToTime()
+	case *array.Duration:
+		return durationValue(a, i)
+	case *array.Dictionary:
+		return Value(a.Dictionary(), a.GetValueIndex(i))
+	case *array.FixedSizeList:
+		if values, ok := a.ListValues().(*array.Float32); ok {
+			dim := int(a.DataType().(*arrow.FixedSizeListType).Len())
+			start := (a.Offset() + i) * dim
+			return append([]float32(nil), values.Float32Values()[start:start+dim]...)
+		}
+	case *array.Struct:
+		if arrow.TypeEqual(a.DataType(), geo.PointType()) {
+			return geo.Point{Lat: a.Field(0).(*array.Float64).Value(i), Lon: a.Field(1).(*array.Float64).Value(i)}
+		}
+	}
+	return formatValue(arr, i)
+}
+
+// scanValue stores v in the pointer dest
+func scanValue(dest any, v any) error {
+	if p, ok := dest.(*any); ok {
+		*p = v
+		return nil
+	}
+	dv := reflect.ValueOf(dest)
+	if dv.Kind() != reflect.Pointer || dv.IsNil() {
+		return fmt.Errorf("destination %T is not a pointer", dest)
+	}
+	dv = dv.Elem()
+	if dv.Kind() == reflect.Pointer {
+		if v == nil {
+			dv.Set(reflect.Zero(dv.Type()))
+			return nil
+		}
+		dv.Set(reflect.New(dv.Type().Elem()))
+		dv = dv.Elem()
+	}
+	if v == nil {
+		return fmt.Errorf("null cannot be stored in %s; scan into a pointer", dv.Type())
+	}
+
+	sv := reflect.ValueOf(v)
+	switch {
+	case sv.Type().AssignableTo(dv.Type()):
+		dv.Set(sv)
+	case dv.Kind() == reflect.String:
+		switch v := v.(type) {
+		case []byte:
+			dv.SetString(string(v))
+		case time.Time:
+			dv.SetString(v.Format(time.RFC3339Nano))
+		default:
+			dv.SetString(fmt.Sprint(v))
+		}
+	case dv.CanInt() && sv.CanInt():
+		if dv.OverflowInt(sv.Int()) {
+			return fmt.Errorf("%v overflows %s", v, dv.Type())
+		}
+		dv.SetInt(sv.Int())
+	case dv.CanInt() && sv.CanUint():
+		if sv.Uint() > math.MaxInt64 || dv.OverflowInt(int64(sv.Uint())) {
+			return fmt.Errorf("%v overflows %s", v, dv.Type())
+		}
+		dv.SetInt(int64(sv.Uint()))
+	case dv.CanUint() && sv.CanUint():
+		if dv.OverflowUint(sv.Uint()) {
+			return fmt.Errorf("%v overflows %s", v, dv.Type())
+		}
+		dv.SetUint(sv.Uint())
+	case dv.CanUint() && sv.CanInt():
+		if sv.Int() < 0 || dv.OverflowUint(uint64(sv.Int())) {
+			return fmt.Errorf("%v overflows %s", v, dv.Type())
+		}
+		dv.SetUint(uint64(sv.Int()))
+	case dv.CanFloat() && (sv.CanFloat() || sv.CanInt() || sv.CanUint()):
+		switch {
+		case sv.CanFloat():
+			dv.SetFloat(sv.Float())
+		case sv.CanInt():
+			dv.SetFloat(float64(sv.Int()))
+		default:
+			dv.SetFloat(float64(sv.Uint()))
+		}
+	default:
+		return fmt.Errorf("cannot store %T in %s", v, dv.Type())
+	}
+	return nil
+}
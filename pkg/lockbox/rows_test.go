@@ -0,0 +1,97 @@
+package lockbox
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/apache/arrow-go/v18/arrow"
+)
+
+func TestRows(t *testing.T) {
+	password := "test_password_123"
+	ctx := context.Background()
+
+	schema := arrow.NewSchema([]arrow.Field{
+		{Name: "id", Type: arrow.PrimitiveTypes.Int64},
+		{Name: "name", Type: arrow.BinaryTypes.String, Nullable: true},
+		{Name: "score", Type: arrow.PrimitiveTypes.Float64, Nullable: true},
+		{Name: "joined", Type: arrow.FixedWidthTypes.Timestamp_s, Nullable: true},
+	}, nil)
+	lb, err := Create(filepath.Join(t.TempDir(), "rows.lbx"), schema, WithPassword(password))
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	defer lb.Close()
+
+	joined := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	err = lb.AppendRows(ctx, []map[string]any{
+		{"id": 1, "name": "ann", "score": 9, "joined": joined},
+		{"id": int32(2), "score": 7.5, "joined": "2024-03-02T12:00:00Z"},
+		{"id": 3.0, "name": nil},
+	}, WithPassword(password))
+	if err != nil {
+		t.Fatalf("append rows: %v", err)
+	}
+	if err := lb.AppendRows(ctx, []map[string]any{{"id": 4, "age": 30}}, WithPassword(password)); err == nil {
+		t.Errorf("expected an unknown column to be refused")
+	}
+	if err := lb.AppendRows(ctx, []map[string]any{{"id": 1.5}}, WithPassword(password)); err == nil {
+		t.Errorf("expected a fraction to be refused for an integer column")
+	}
+
+	rows, err := lb.Rows(ctx, ScanOptions{}, WithPassword(password))
+	if err != nil {
+		t.Fatalf("rows: %v", err)
+	}
+	defer rows.Close()
+	if cols := rows.Columns(); len(cols) != 4 || cols[3] != "joined" {
+		t.Errorf("unexpected columns %v", cols)
+	}
+
+	var ids []int
+	var names []*string
+	var first map[string]any
+	for rows.Next() {
+		if first == nil {
+			first = rows.Map()
+		}
+		var id int
+		var name *string
+		var score any
+		var when *time.Time
+		if err := rows.Scan(&id, &name, &score, &when); err != nil {
+			t.Fatalf("scan: %v", err)
+		}
+		ids = append(ids, id)
+		names = append(names, name)
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatalf("rows: %v", err)
+	}
+	if len(ids) != 3 || ids[2] != 3 || *names[0] != "ann" || names[1] != nil {
+		t.Errorf("unexpected rows %v %v", ids, names)
+	}
+	if first["score"] != 9.0 || !first["joined"].(time.Time).Equal(joined) {
+		t.Errorf("unexpected first row %v", first)
+	}
+
+	// Nulls need pointers, and values must fit
+	rows, err = lb.Rows(ctx, ScanOptions{Columns: []string{"name"}, Filter: "id = 2"}, WithPassword(password))
+	if err != nil {
+		t.Fatalf("rows: %v", err)
+	}
+	defer rows.Close()
+	if !rows.Next() {
+		t.Fatalf("expected a row: %v", rows.Err())
+	}
+	var name string
+	if err := rows.Scan(&name); err == nil {
+		t.Errorf("expected a null to be refused for a string")
+	}
+	var b int8
+	if err := scanValue(&b, int64(300)); err == nil {
+		t.Errorf("expected 300 to overflow int8")
+	}
+}
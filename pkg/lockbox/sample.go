@@ -0,0 +1,289 @@
+package lockbox
+
+import (
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"slices"
+	"strconv"
+
+	"github.com/apache/arrow-go/v18/arrow"
+	"github.com/apache/arrow-go/v18/arrow/memory"
+	"github.com/rs/zerolog/log"
+)
+
+// tableSample is a parsed TABLESAMPLE clause. Exactly one of Percent and
+// Rows is set. System samples keep whole row groups.
+type tableSample struct {
+	Percent    float64
+	Rows       int
+	Seed       uint64
+	Repeatable bool
+	System     bool
+}
+
+// WithSampleRows makes Query return a uniform random sample of at most n
+// rows from each table it reads, as if every SELECT without its own
+// TABLESAMPLE clause had "TABLESAMPLE n ROWS"
+func WithSampleRows(n int) Option {
+	return func(o *Options) {
+		o.SampleRows = n
+	}
+}
+
+// parseTableSample parses the clause following the TABLESAMPLE keyword:
+//
+//	[BERNOULLI | SYSTEM] n | (n) PERCENT | ROWS [REPEATABLE (seed)]
+//
+// BERNOULLI, the default, samples individual rows. SYSTEM samples row
+// groups, which is cheaper but returns rows written together.
+func (p *queryParser) parseTableSample() (*tableSample, error) {
+	system := p.acceptKeyword("SYSTEM")
+	if !system {
+		p.acceptKeyword("BERNOULLI")
+	}
+
+	paren := p.peek().Kind == tokLParen
+	if paren {
+		p.next()
+	}
+	val, err := p.expect(tokNumber, "TABLESAMPLE size")
+	if err != nil {
+		return nil, fmt.Errorf("invalid TABLESAMPLE clause")
+	}
+	if paren {
+		if _, err := p.expect(tokRParen, "')' after TABLESAMPLE size"); err != nil {
+			return nil, err
+		}
+	}
+
+	s := &tableSample{System: system}
+	switch {
+	case p.acceptKeyword("ROWS"):
+		n, err := strconv.Atoi(val.Text)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid TABLESAMPLE row count %q", val.Text)
+		}
+		s.Rows = n
+	case p.acceptKeyword("PERCENT"):
+		pct, err := strconv.ParseFloat(val.Text, 64)
+		if err != nil || pct < 0 || pct > 100 {
+			return nil, fmt.Errorf("invalid TABLESAMPLE percentage %q", val.Text)
+		}
+		s.Percent = pct
+	default:
+		return nil, fmt.Errorf("invalid TABLESAMPLE clause: expected PERCENT or ROWS")
+	}
+
+	if p.acceptKeyword("REPEATABLE") {
+		if _, err := p.expect(tokLParen, "'(' after REPEATABLE"); err != nil {
+			return nil, err
+		}
+		seed, err := p.expect(tokNumber, "REPEATABLE seed")
+		if err != nil {
+			return nil, fmt.Errorf("invalid REPEATABLE clause")
+		}
+		s.Seed, err = strconv.ParseUint(seed.Text, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid REPEATABLE seed %q", seed.Text)
+		}
+		if _, err := p.expect(tokRParen, "')' after REPEATABLE seed"); err != nil {
+			return nil, err
+		}
+		s.Repeatable = true
+	}
+
+	return s, nil
+}
+
+// rng returns the random source of the sample, seeded by REPEATABLE
+func (s *tableSample) rng() *rand.Rand {
+	if s.Repeatable {
+		return rand.New(rand.NewPCG(s.Seed, s.Seed))
+	}
+	return rand.New(rand.NewPCG(rand.Uint64(), rand.Uint64()))
+}
+
+// rows picks the sampled row indexes out of n, in ascending order. ROWS
+// draws every subset of the requested size with equal probability; PERCENT
+// keeps each row independently with the given probability.
+func (s *tableSample) rows(n int) []int {
+	return s.pickRows(s.rng(), n)
+}
+
+func (s *tableSample) pickRows(rng *rand.Rand, n int) []int {
+	if s.Percent == 0 {
+		return chooseRows(rng, n, s.Rows)
+	}
+	return bernoulliRows(rng, n, s.Percent/100)
+}
+
+// chooseRows returns k random indexes below n, or all of them when k is at
+// least n, in ascending order. Floyd's algorithm draws k random numbers
+// however large n is.
+func chooseRows(rng *rand.Rand, n, k int) []int {
+	if k >= n {
+		return allRows(n)
+	}
+	chosen := make(map[int]bool, k)
+	for j := n - k; j < n; j++ {
+		i := rng.IntN(j + 1)
+		if chosen[i] {
+			i = j
+		}
+		chosen[i] = true
+	}
+	out := make([]int, 0, k)
+	for i := range chosen {
+		out = append(out, i)
+	}
+	slices.Sort(out)
+	return out
+}
+
+// bernoulliRows keeps each index below n with probability p. It skips
+// ahead by geometrically distributed gaps, so only kept rows draw a random
+// number.
+func bernoulliRows(rng *rand.Rand, n int, p float64) []int {
+	switch {
+	case p <= 0:
+		return nil
+	case p >= 1:
+		return allRows(n)
+	}
+	var out []int
+	for i := -1; ; {
+		gap := math.Floor(math.Log(1-rng.Float64()) / math.Log1p(-p))
+		if float64(i)+gap+1 >= float64(n) {
+			return out
+		}
+		i += int(gap) + 1
+		out = append(out, i)
+	}
+}
+
+// allRows returns the indexes below n
+func allRows(n int) []int {
+	out := make([]int, n)
+	for i := range out {
+		out[i] = i
+	}
+	return out
+}
+
+// pickBlocks draws the sample from the live row counts of row groups
+// first and later, before anything is decrypted. It returns the row groups
+// to read, in file order, and the rows to keep by position among the rows
+// read. Row groups without a sampled row are not read, nor are those keep
+// rejects, which spatial pruning has shown hold no row the query returns.
+//
+// SYSTEM samples keep whole row groups: PERCENT keeps each with the given
+// probability, and ROWS takes row groups in random order until they hold
+// enough rows, then picks the rows among them. Other samples pick rows out
+// of the whole table, as if it were read first.
+func (s *tableSample) pickBlocks(counts []int64, first int, keep func(int) bool) ([]int, []int) {
+	rng := s.rng()
+	candidates := make([]int, 0, max(len(counts)-first, 0))
+	for n := first; n < len(counts); n++ {
+		candidates = append(candidates, n)
+	}
+
+	// Row groups to sample from, in file order, and the sampled rows by
+	// position among theirs
+	var from []int
+	var rows []int
+	switch {
+	case s.System && s.Percent != 0:
+		for _, n := range candidates {
+			if rng.Float64() < s.Percent/100 {
+				from = append(from, n)
+			}
+		}
+		rows = allRows(int(sumRows(counts, from)))
+	case s.System:
+		rng.Shuffle(len(candidates), func(i, j int) {
+			candidates[i], candidates[j] = candidates[j], candidates[i]
+		})
+		var total int64
+		for _, n := range candidates {
+			if total >= int64(s.Rows) {
+				break
+			}
+			from = append(from, n)
+			total += counts[n]
+		}
+		slices.Sort(from)
+		rows = s.pickRows(rng, int(total))
+	default:
+		from = candidates
+		rows = s.pickRows(rng, int(sumRows(counts, from)))
+	}
+
+	// Keep the row groups holding a sampled row, renumbering the rows by
+	// position among those read
+	var groups, kept []int
+	var start, read int64
+	for _, n := range from {
+		end := start + counts[n]
+		i := 0
+		for i < len(rows) && int64(rows[i]) < end {
+			i++
+		}
+		if i > 0 && (keep == nil || keep(n)) {
+			groups = append(groups, n)
+			for _, r := range rows[:i] {
+				kept = append(kept, int(read+int64(r)-start))
+			}
+			read += counts[n]
+		}
+		rows = rows[i:]
+		start = end
+	}
+	return groups, kept
+}
+
+// sumRows adds up the row counts of groups
+func sumRows(counts []int64, groups []int) int64 {
+	var n int64
+	for _, g := range groups {
+		n += counts[g]
+	}
+	return n
+}
+
+// readSample decrypts the sampled rows of the given columns, reading only
+// the row groups that hold one. When pruned is set, only rows of groups are
+// returned.
+func (qe *queryExec) readSample(s *tableSample, groups []int, pruned bool, columns []string) (arrow.Record, error) {
+	var keep func(int) bool
+	if pruned {
+		keep = func(n int) bool { return slices.Contains(groups, n) }
+	}
+	groups, rows := s.pickBlocks(qe.meta.LiveRowGroupRows()[:qe.end()], qe.first, keep)
+	log.Debug().Int("groups", len(groups)).Int("rows", len(rows)).Msg("Sampled row groups")
+	rec, err := qe.reader.ReadColumnsIn(groups, columns)
+	if err != nil {
+		return nil, err
+	}
+	defer rec.Release()
+	return takeRows(qe.mem, rec, rows), nil
+}
+
+// sampleRecord returns the rows of rec selected by s. The input record is
+// not released.
+func sampleRecord(mem memory.Allocator, rec arrow.Record, s *tableSample) arrow.Record {
+	return takeRows(mem, rec, s.rows(int(rec.NumRows())))
+}
+
+// sampleFor returns the sample to apply to the rows pq reads, if any. The
+// WithSampleRows default only applies to the outermost query, not to the
+// queries views are defined by.
+func (qe *queryExec) sampleFor(pq *parsedQuery) *tableSample {
+	if pq.Sample != nil {
+		return pq.Sample
+	}
+	if qe.depth == 0 {
+		return qe.sample
+	}
+	return nil
+}
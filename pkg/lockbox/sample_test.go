@@ -0,0 +1,196 @@
+package lockbox
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/apache/arrow-go/v18/arrow"
+	"github.com/apache/arrow-go/v18/arrow/array"
+	"github.com/apache/arrow-go/v18/arrow/memory"
+)
+
+func TestQueryTableSample(t *testing.T) {
+	tmpFile := "/tmp/test_lockbox_sample.lbx"
+	defer os.Remove(tmpFile)
+
+	password := "test_password_123"
+	schema := arrow.NewSchema([]arrow.Field{
+		{Name: "id", Type: arrow.PrimitiveTypes.Int64},
+	}, nil)
+
+	lb, err := Create(tmpFile, schema, WithPassword(password))
+	if err != nil {
+		t.Fatalf("Failed to create lockbox: %v", err)
+	}
+	defer lb.Close()
+
+	const total = 10000
+	b := array.NewInt64Builder(memory.NewGoAllocator())
+	for i := 0; i < total; i++ {
+		b.Append(int64(i))
+	}
+	arr := b.NewArray()
+	b.Release()
+	rec := array.NewRecord(schema, []arrow.Array{arr}, total)
+	arr.Release()
+	defer rec.Release()
+
+	ctx := context.Background()
+	if err := lb.Write(ctx, rec, WithPassword(password)); err != nil {
+		t.Fatalf("write error: %v", err)
+	}
+
+	rows, err := lb.Query(ctx, "SELECT id FROM data TABLESAMPLE 100 ROWS", WithPassword(password))
+	if err != nil {
+		t.Fatalf("sample rows error: %v", err)
+	}
+	defer rows.Release()
+	if rows.NumRows() != 100 {
+		t.Fatalf("expected 100 sampled rows, got %d", rows.NumRows())
+	}
+	ids := rows.Column(0).(*array.Int64)
+	for i := 1; i < ids.Len(); i++ {
+		if ids.Value(i) <= ids.Value(i-1) {
+			t.Fatalf("sampled rows are not in file order: %d after %d", ids.Value(i), ids.Value(i-1))
+		}
+	}
+	if ids.Value(ids.Len()-1) < total/2 {
+		t.Errorf("sample is not spread over the file; last id %d", ids.Value(ids.Len()-1))
+	}
+
+	pct, err := lb.Query(ctx, "SELECT id FROM data TABLESAMPLE BERNOULLI (10) PERCENT REPEATABLE (7)", WithPassword(password))
+	if err != nil {
+		t.Fatalf("sample percent error: %v", err)
+	}
+	defer pct.Release()
+	if n := pct.NumRows(); n < 800 || n > 1200 {
+		t.Errorf("expected about 1000 rows from a 10 percent sample, got %d", n)
+	}
+
+	again, err := lb.Query(ctx, "SELECT id FROM data TABLESAMPLE BERNOULLI (10) PERCENT REPEATABLE (7)", WithPassword(password))
+	if err != nil {
+		t.Fatalf("repeat sample error: %v", err)
+	}
+	defer again.Release()
+	if !array.RecordEqual(pct, again) {
+		t.Errorf("REPEATABLE samples with the same seed differ")
+	}
+
+	// The sample is drawn before WHERE and LIMIT are applied
+	filtered, err := lb.Query(ctx, "SELECT id FROM data TABLESAMPLE 50 ROWS WHERE id >= 0 LIMIT 10", WithPassword(password))
+	if err != nil {
+		t.Fatalf("sample with filter error: %v", err)
+	}
+	defer filtered.Release()
+	if filtered.NumRows() != 10 {
+		t.Errorf("expected 10 rows, got %d", filtered.NumRows())
+	}
+
+	opt, err := lb.Query(ctx, "SELECT id FROM data", WithPassword(password), WithSampleRows(25))
+	if err != nil {
+		t.Fatalf("WithSampleRows error: %v", err)
+	}
+	defer opt.Release()
+	if opt.NumRows() != 25 {
+		t.Errorf("expected 25 rows with WithSampleRows, got %d", opt.NumRows())
+	}
+
+	for _, q := range []string{
+		"SELECT id FROM data TABLESAMPLE 10",
+		"SELECT id FROM data TABLESAMPLE 150 PERCENT",
+		"SELECT id FROM data TABLESAMPLE 10 ROWS REPEATABLE 3",
+	} {
+		if _, err := lb.Query(ctx, q, WithPassword(password)); err == nil {
+			t.Errorf("expected error for %q", q)
+		}
+	}
+}
+
+func TestSampleRowGroups(t *testing.T) {
+	counts := []int64{100, 100, 40, 100, 100, 100}
+
+	// Row samples decrypt only the row groups holding a sampled row
+	s := &tableSample{Rows: 3, Repeatable: true, Seed: 1}
+	groups, rows := s.pickBlocks(counts, 0, nil)
+	if len(rows) != 3 || len(groups) == 0 || len(groups) > 3 {
+		t.Fatalf("expected 3 rows from at most 3 row groups, got %v of %v", rows, groups)
+	}
+	if read := sumRows(counts, groups); int64(rows[2]) >= read {
+		t.Errorf("row %d is past the %d rows read", rows[2], read)
+	}
+
+	// Row groups before first are not sampled, and those keep rejects are
+	// not read
+	s = &tableSample{Percent: 100}
+	groups, rows = s.pickBlocks(counts, 2, func(n int) bool { return n != 3 })
+	if want := []int{2, 4, 5}; !slices.Equal(groups, want) || len(rows) != 240 {
+		t.Errorf("expected all rows of row groups %v, got %d rows of %v", want, len(rows), groups)
+	}
+
+	// SYSTEM samples whole row groups and picks the rows among them
+	s = &tableSample{Rows: 150, System: true}
+	groups, rows = s.pickBlocks(counts, 0, nil)
+	if len(rows) != 150 || len(groups) > 3 {
+		t.Errorf("expected 150 rows from at most 3 row groups, got %d of %v", len(rows), groups)
+	}
+	s = &tableSample{Percent: 50, System: true, Repeatable: true, Seed: 3}
+	groups, rows = s.pickBlocks(counts, 0, nil)
+	if read := sumRows(counts, groups); int64(len(rows)) != read {
+		t.Errorf("expected every row of the sampled row groups, got %d of %d", len(rows), read)
+	}
+}
+
+func TestQuerySystemSample(t *testing.T) {
+	tmpFile := filepath.Join(t.TempDir(), "system.lbx")
+	password := "test_password_123"
+	schema := arrow.NewSchema([]arrow.Field{
+		{Name: "id", Type: arrow.PrimitiveTypes.Int64},
+	}, nil)
+	lb, err := Create(tmpFile, schema, WithPassword(password))
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	defer lb.Close()
+
+	ctx := context.Background()
+	for g := 0; g < 10; g++ {
+		b := array.NewInt64Builder(memory.NewGoAllocator())
+		for i := 0; i < 100; i++ {
+			b.Append(int64(g*100 + i))
+		}
+		arr := b.NewArray()
+		b.Release()
+		rec := array.NewRecord(schema, []arrow.Array{arr}, 100)
+		arr.Release()
+		if err := lb.Write(ctx, rec, WithPassword(password)); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+		rec.Release()
+	}
+	if _, err := lb.Delete(ctx, "id < 50", WithPassword(password)); err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+
+	res, err := lb.Query(ctx, "SELECT id FROM data TABLESAMPLE SYSTEM 150 ROWS", WithPassword(password))
+	if err != nil {
+		t.Fatalf("query: %v", err)
+	}
+	defer res.Release()
+	if res.NumRows() != 150 {
+		t.Fatalf("expected 150 rows, got %d", res.NumRows())
+	}
+	seen := map[int64]bool{}
+	ids := res.Column(0).(*array.Int64)
+	for i := 0; i < ids.Len(); i++ {
+		if ids.Value(i) < 50 {
+			t.Fatalf("sampled deleted row %d", ids.Value(i))
+		}
+		seen[ids.Value(i)/100] = true
+	}
+	if len(seen) > 3 {
+		t.Errorf("expected rows of at most 3 row groups, got %d", len(seen))
+	}
+}
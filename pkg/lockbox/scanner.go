@@ -0,0 +1,379 @@
+package lockbox
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+
+	"github.com/TFMV/lockbox/pkg/expr"
+	"github.com/TFMV/lockbox/pkg/metadata"
+	"github.com/apache/arrow-go/v18/arrow"
+	"github.com/apache/arrow-go/v18/arrow/array"
+)
+
+var _ array.RecordReader = (*Scanner)(nil)
+
+// Scanner iterates over the record batches of a lockbox or Dataset. Each
+// write is stored as its own row group, and row groups are decrypted one at
+// a time as Next is called, so a scan holds a single batch in memory however
+// many were appended. Call Next until it returns false, then check Err:
+//
+//	s, err := lb.NewScanner(ctx, lockbox.ScanOptions{}, lockbox.WithPassword(pw))
+//	if err != nil { ... }
+//	defer s.Release()
+//	for s.Next() {
+//		rec := s.Record() // valid until the next call to Next
+//	}
+//	if err := s.Err(); err != nil { ... }
+//
+// A Scanner implements array.RecordReader.
+type Scanner struct {
+	ctx       context.Context
+	fragments []*Fragment
+	opts      []Option
+	columns   []string
+	filter    *expr.Expr
+	batchSize int
+	refs      int64
+
+	schema  *arrow.Schema
+	frag    int        // fragment being scanned
+	batch   int        // next row group of that fragment
+	qe      *queryExec // reader and policy of the fragment, nil between fragments
+	stored  []string
+	virtual []metadata.VirtualColumn
+	current arrow.Record // scanned rows of the current batch
+	offset  int64        // rows of current already returned
+	rec     arrow.Record // the record returned by Record
+	err     error
+}
+
+// NewScanner returns a Scanner over the record batches of the lockbox. opts
+// supply the password or column keys and the principal whose access policy
+// applies.
+func (lb *Lockbox) NewScanner(ctx context.Context, scan ScanOptions, opts ...Option) (*Scanner, error) {
+	d := &Dataset{fragments: []*Fragment{{lb: lb}}, schema: lb.Schema(), opts: opts}
+	return d.NewScanner(ctx, scan)
+}
+
+// NewScanner checks scan against the dataset and returns a Scanner over
+// the batches of its fragments, in order. The first batch is decrypted
+// right away so the Scanner knows its schema.
+func (d *Dataset) NewScanner(ctx context.Context, scan ScanOptions) (*Scanner, error) {
+	s := &Scanner{
+		ctx:       ctx,
+		fragments: d.fragments,
+		opts:      d.opts,
+		columns:   scan.Columns,
+		batchSize: scan.BatchSize,
+		refs:      1,
+	}
+	if scan.Filter != "" {
+		e, err := expr.Parse(scan.Filter)
+		if err != nil {
+			return nil, fmt.Errorf("invalid filter: %w", err)
+		}
+		s.filter = e
+	}
+
+	names := append([]string{}, s.columns...)
+	if s.filter != nil {
+		names = append(names, s.filter.Columns()...)
+	}
+	for _, name := range names {
+		if p, _ := resolveNestedPath(d.schema, name); p != nil || len(d.schema.FieldIndices(name)) > 0 {
+			continue
+		}
+		for _, f := range d.fragments {
+			if _, ok := f.lb.file.Metadata().FindVirtualColumn(name); !ok {
+				return nil, fmt.Errorf("%s: column %s not found", f.Path(), name)
+			}
+		}
+	}
+
+	if !s.load() && s.err == nil {
+		// No batches at all: scan an empty one to learn the schema
+		s.frag = 0
+		s.err = s.loadEmpty()
+	}
+	if s.err != nil {
+		s.Release()
+		return nil, s.err
+	}
+	return s, nil
+}
+
+// Retain increases the reference count of the Scanner
+func (s *Scanner) Retain() {
+	atomic.AddInt64(&s.refs, 1)
+}
+
+// Release decreases the reference count of the Scanner and frees the
+// current batch when it reaches zero
+func (s *Scanner) Release() {
+	if atomic.AddInt64(&s.refs, -1) != 0 {
+		return
+	}
+	if s.rec != nil {
+		s.rec.Release()
+		s.rec = nil
+	}
+	if s.current != nil {
+		s.current.Release()
+		s.current = nil
+	}
+}
+
+// Schema returns the schema of the records, after projection
+func (s *Scanner) Schema() *arrow.Schema {
+	return s.schema
+}
+
+// Record returns the current record. It is valid until the next call to
+// Next; retain it to keep it longer.
+func (s *Scanner) Record() arrow.Record {
+	return s.rec
+}
+
+// Err returns the error that stopped the scan, if any
+func (s *Scanner) Err() error {
+	return s.err
+}
+
+// Next advances to the next record, decrypting the next stored batch when
+// the current one is exhausted. Batches left with no rows by the filter
+// are skipped.
+func (s *Scanner) Next() bool {
+	if s.rec != nil {
+		s.rec.Release()
+		s.rec = nil
+	}
+	for s.err == nil {
+		if s.current != nil && s.offset < s.current.NumRows() {
+			end := s.current.NumRows()
+			if s.batchSize > 0 {
+				end = min(s.offset+int64(s.batchSize), end)
+			}
+			s.rec = s.current.NewSlice(s.offset, end)
+			s.offset = end
+			return true
+		}
+		if !s.load() {
+			return false
+		}
+	}
+	return false
+}
+
+// ToTable reads the remaining records into a table
+func (s *Scanner) ToTable() (arrow.Table, error) {
+	var recs []arrow.Record
+	defer func() {
+		for _, rec := range recs {
+			rec.Release()
+		}
+	}()
+	for s.Next() {
+		rec := s.Record()
+		rec.Retain()
+		recs = append(recs, rec)
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	return array.NewTableFromRecords(s.schema, recs), nil
+}
+
+// load replaces the current batch with the scan of the next stored batch.
+// It returns false at the end of the dataset or on error.
+func (s *Scanner) load() bool {
+	if s.current != nil {
+		s.current.Release()
+		s.current = nil
+	}
+
+	for s.frag < len(s.fragments) {
+		if err := s.ctx.Err(); err != nil {
+			s.err = err
+			return false
+		}
+		f := s.fragments[s.frag]
+		if s.qe == nil {
+			if err := s.openFragment(f); err != nil {
+				s.err = fmt.Errorf("%s: %w", f.Path(), err)
+				return false
+			}
+		}
+		if s.batch >= s.qe.end() {
+			s.frag++
+			s.batch = 0
+			s.qe = nil
+			continue
+		}
+
+		rec, err := s.qe.reader.ReadRowGroup(s.batch, s.stored)
+		if err == nil {
+			rec, err = s.process(rec)
+		}
+		if err != nil {
+			s.err = fmt.Errorf("%s, row group %d: %w", f.Path(), s.batch, err)
+			return false
+		}
+		s.batch++
+
+		if err := s.setCurrent(rec); err != nil {
+			s.err = fmt.Errorf("%s: %w", f.Path(), err)
+			return false
+		}
+		return true
+	}
+	return false
+}
+
+// loadEmpty runs an empty batch of the first fragment through the scan so
+// a scan of a dataset without rows still has a schema
+func (s *Scanner) loadEmpty() error {
+	f := s.fragments[0]
+	if err := s.openFragment(f); err != nil {
+		return fmt.Errorf("%s: %w", f.Path(), err)
+	}
+	s.frag = len(s.fragments)
+
+	rec, err := s.process(s.qe.emptyRecord(s.stored))
+	if err != nil {
+		return fmt.Errorf("%s: %w", f.Path(), err)
+	}
+	return s.setCurrent(rec)
+}
+
+// openFragment prepares the reader, policy and read plan of a fragment
+func (s *Scanner) openFragment(f *Fragment) error {
+	options := &Options{}
+	for _, opt := range s.opts {
+		opt(options)
+	}
+	qe, err := f.lb.newQueryExec(s.ctx, options)
+	if err != nil {
+		return err
+	}
+
+	var required []string
+	if len(s.columns) > 0 {
+		required = append(required, s.columns...)
+		if s.filter != nil {
+			for _, col := range s.filter.Columns() {
+				if !contains(required, col) {
+					required = append(required, col)
+				}
+			}
+		}
+		if qe.policy != nil {
+			for _, col := range qe.policy.columns() {
+				if !contains(required, col) {
+					required = append(required, col)
+				}
+			}
+		}
+	}
+
+	stored, virtual, err := planVirtualColumns(qe.meta, required)
+	if err != nil {
+		return err
+	}
+	stored = nestedRoots(qe.meta.Schema, stored)
+	if err := checkColumns(qe.meta.Schema, stored); err != nil {
+		return err
+	}
+	s.qe, s.stored, s.virtual = qe, stored, virtual
+	s.batch = qe.first
+	return nil
+}
+
+// process applies the access policy, virtual columns, filter and
+// projection to a decrypted batch. It takes ownership of rec.
+func (s *Scanner) process(rec arrow.Record) (arrow.Record, error) {
+	if s.qe.policy != nil {
+		allowed, err := s.qe.policy.apply(s.qe.mem, rec)
+		rec.Release()
+		if err != nil {
+			return nil, err
+		}
+		rec = allowed
+	}
+
+	if len(s.virtual) > 0 {
+		withVirtual, err := addVirtualColumns(s.qe.mem, rec, s.virtual)
+		rec.Release()
+		if err != nil {
+			return nil, err
+		}
+		rec = withVirtual
+	}
+	defer rec.Release()
+
+	var rows []int
+	for row := 0; row < int(rec.NumRows()); row++ {
+		if s.filter != nil {
+			ok, err := s.filter.EvalBool(recordEnv{rec: rec, row: row})
+			if err != nil {
+				return nil, fmt.Errorf("filter, row %d: %w", row, err)
+			}
+			if !ok {
+				continue
+			}
+		}
+		rows = append(rows, row)
+	}
+
+	// Paths into nested columns are projected as columns of their own
+	withNested, err := withNestedColumns(s.qe.mem, rec, s.columns)
+	if err != nil {
+		return nil, err
+	}
+	defer withNested.Release()
+
+	projected, err := projectRecord(withNested, s.columns)
+	if err != nil {
+		return nil, err
+	}
+	if len(rows) == int(projected.NumRows()) {
+		return projected, nil
+	}
+	defer projected.Release()
+	return takeRows(s.qe.mem, projected, rows), nil
+}
+
+// setCurrent makes rec the current batch after checking its schema
+// against the batches before it
+func (s *Scanner) setCurrent(rec arrow.Record) error {
+	if s.schema == nil {
+		s.schema = rec.Schema()
+	} else if !s.schema.Equal(rec.Schema()) {
+		// Masks and virtual columns can change a column's type per file
+		rec.Release()
+		return fmt.Errorf("scan schema %s differs from %s", rec.Schema(), s.schema)
+	}
+	s.current = rec
+	s.offset = 0
+	return nil
+}
+
+// projectRecord returns the named columns of rec, in that order, or all of
+// them when names is empty
+func projectRecord(rec arrow.Record, names []string) (arrow.Record, error) {
+	if len(names) == 0 {
+		rec.Retain()
+		return rec, nil
+	}
+	fields := make([]arrow.Field, len(names))
+	cols := make([]arrow.Array, len(names))
+	for i, name := range names {
+		idx := rec.Schema().FieldIndices(name)
+		if len(idx) == 0 {
+			return nil, fmt.Errorf("column %s not found", name)
+		}
+		fields[i] = rec.Schema().Field(idx[0])
+		cols[i] = rec.Column(idx[0])
+	}
+	return array.NewRecord(arrow.NewSchema(fields, nil), cols, rec.NumRows()), nil
+}
@@ -0,0 +1,102 @@
+package lockbox
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/apache/arrow-go/v18/arrow"
+	"github.com/apache/arrow-go/v18/arrow/array"
+)
+
+func TestScannerBatches(t *testing.T) {
+	schema := arrow.NewSchema([]arrow.Field{
+		{Name: "id", Type: arrow.PrimitiveTypes.Int64},
+		{Name: "name", Type: arrow.BinaryTypes.String, Nullable: true},
+	}, nil)
+	path := filepath.Join(t.TempDir(), "scan.lbx")
+	password := "test_password_123"
+	ctx := context.Background()
+
+	lb, err := Create(path, schema, WithPassword(password), WithDebugAllocator())
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+
+	// An empty lockbox still scans with a schema
+	empty, err := lb.NewScanner(ctx, ScanOptions{}, WithPassword(password))
+	if err != nil {
+		t.Fatalf("scanner on empty lockbox: %v", err)
+	}
+	if empty.Schema().NumFields() != 2 || empty.Next() {
+		t.Errorf("expected a schema and no records from an empty lockbox")
+	}
+	empty.Release()
+
+	// Three separate writes are stored as three batches
+	sizes := []int{3, 1, 4}
+	next := int64(0)
+	for _, n := range sizes {
+		b := array.NewRecordBuilder(lb.Allocator(), schema)
+		for i := 0; i < n; i++ {
+			b.Field(0).(*array.Int64Builder).Append(next)
+			b.Field(1).(*array.StringBuilder).Append("row")
+			next++
+		}
+		rec := b.NewRecord()
+		b.Release()
+		if err := lb.Write(ctx, rec, WithPassword(password)); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+		rec.Release()
+	}
+
+	s, err := lb.NewScanner(ctx, ScanOptions{}, WithPassword(password))
+	if err != nil {
+		t.Fatalf("NewScanner: %v", err)
+	}
+	var got []int64
+	var ids []int64
+	for s.Next() {
+		rec := s.Record()
+		got = append(got, rec.NumRows())
+		col := rec.Column(0).(*array.Int64)
+		for i := 0; i < col.Len(); i++ {
+			ids = append(ids, col.Value(i))
+		}
+	}
+	if err := s.Err(); err != nil {
+		t.Fatalf("scan: %v", err)
+	}
+	s.Release()
+	if len(got) != 3 || got[0] != 3 || got[1] != 1 || got[2] != 4 {
+		t.Errorf("expected batches of 3, 1 and 4 rows, got %v", got)
+	}
+	for i, id := range ids {
+		if id != int64(i) {
+			t.Fatalf("expected ids in write order, got %v", ids)
+		}
+	}
+
+	// Filters and projections apply per batch; BatchSize splits batches
+	s, err = lb.NewScanner(ctx, ScanOptions{Columns: []string{"id"}, Filter: "id >= 2", BatchSize: 2}, WithPassword(password))
+	if err != nil {
+		t.Fatalf("NewScanner: %v", err)
+	}
+	table, err := s.ToTable()
+	if err != nil {
+		t.Fatalf("ToTable: %v", err)
+	}
+	if table.NumRows() != 6 || table.NumCols() != 1 {
+		t.Errorf("expected 6 rows of 1 column, got %d of %d", table.NumRows(), table.NumCols())
+	}
+	if chunks := table.Column(0).Data().Chunks(); len(chunks) != 4 {
+		t.Errorf("expected 4 records (1, 1, 2, 2 rows), got %d", len(chunks))
+	}
+	table.Release()
+	s.Release()
+
+	if err := lb.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+}
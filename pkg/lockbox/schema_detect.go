@@ -1,68 +1,237 @@
 package lockbox
 
 import (
-	"encoding/csv"
+	"bufio"
+	"fmt"
 	"io"
 	"os"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/apache/arrow-go/v18/arrow"
 )
 
+// decimalCommaLocales use "," as decimal separator and "." or a space to
+// group digits
+var decimalCommaLocales = map[string]bool{
+	"de": true, "fr": true, "es": true, "it": true, "nl": true, "pt": true,
+	"ru": true, "pl": true, "sv": true, "da": true, "fi": true, "nb": true,
+	"cs": true, "tr": true,
+}
+
+// localeDateFormats are the date layouts tried for a locale besides RFC 3339
+var localeDateFormats = map[string][]string{
+	"en": {"01/02/2006", "2006-01-02"},
+	"de": {"02.01.2006", "2006-01-02"},
+	"fr": {"02/01/2006", "2006-01-02"},
+	"es": {"02/01/2006", "2006-01-02"},
+	"it": {"02/01/2006", "2006-01-02"},
+	"nl": {"02-01-2006", "2006-01-02"},
+}
+
+// WithTypeHint makes schema detection use typ for a column instead of
+// inferring it
+func WithTypeHint(column string, typ arrow.DataType) Option {
+	return func(o *Options) {
+		if o.TypeHints == nil {
+			o.TypeHints = map[string]arrow.DataType{}
+		}
+		o.TypeHints[column] = typ
+	}
+}
+
+// WithNoHeader treats the first CSV line as data; columns are named
+// column_1, column_2, ...
+func WithNoHeader() Option {
+	return func(o *Options) {
+		o.NoHeader = true
+	}
+}
+
+// WithDelimiter sets the CSV field delimiter. By default it is detected
+// among ",", ";", tab and "|".
+func WithDelimiter(r rune) Option {
+	return func(o *Options) {
+		o.Delimiter = r
+	}
+}
+
+// WithQuote sets the CSV quote character. By default it is detected among
+// '"' and '\”.
+func WithQuote(r rune) Option {
+	return func(o *Options) {
+		o.Quote = r
+	}
+}
+
+// WithLocale parses numbers and dates the way a locale writes them, e.g.
+// "en" accepts 1,234.5 and 01/02/2006 and "de" accepts 1.234,5 and
+// 02.01.2006. Only the language part of the locale is used.
+func WithLocale(locale string) Option {
+	return func(o *Options) {
+		o.Locale = locale
+	}
+}
+
+// WithDateFormats adds time layouts, as for time.Parse, that detection
+// recognizes as timestamps
+func WithDateFormats(layouts ...string) Option {
+	return func(o *Options) {
+		o.DateFormats = append(o.DateFormats, layouts...)
+	}
+}
+
 // DetectCSVSchema reads a CSV file and attempts to infer an Arrow schema.
-// It reads up to sample records to determine column types.
-func DetectCSVSchema(path string, sample int) (*arrow.Schema, error) {
+// It reads up to sample records to determine column types; sample <= 0
+// reads 10. Integers that do not fit a column's other values widen to
+// float64 and mixed types fall back to strings. Columns with empty values
+// or the WithNullToken value in the sample are nullable. Type hints, the
+// dialect, header-less input and locale-specific numbers and dates are set
+// with options.
+func DetectCSVSchema(path string, sample int, opts ...Option) (*arrow.Schema, error) {
+	options := &Options{}
+	for _, opt := range opts {
+		opt(options)
+	}
+	if sample <= 0 {
+		sample = 10
+	}
+
 	f, err := os.Open(path)
 	if err != nil {
 		return nil, err
 	}
 	defer f.Close()
 
-	r := csv.NewReader(f)
-	headers, err := r.Read()
-	if err != nil {
-		return nil, err
-	}
-	if sample <= 0 {
-		sample = 10
+	br := bufio.NewReader(f)
+	skipBOM(br)
+	comma, quote := sniffCSV(br, options.Delimiter, options.Quote)
+	r := newCSVReader(br, comma, quote)
+
+	var headers []string
+	var rows [][]string
+	if options.NoHeader {
+		first, err := r.Read()
+		if err != nil {
+			return nil, err
+		}
+		headers = csvColumnNames(len(first))
+		rows = append(rows, first)
+	} else {
+		if headers, err = r.Read(); err != nil {
+			return nil, err
+		}
 	}
-	types := make([]arrow.DataType, len(headers))
-	for i := 0; i < sample; i++ {
+	for len(rows) < sample {
 		row, err := r.Read()
+		if err == io.EOF {
+			break
+		}
 		if err != nil {
-			if err == io.EOF {
-				break
-			}
 			return nil, err
 		}
+		rows = append(rows, row)
+	}
+
+	for name := range options.TypeHints {
+		found := false
+		for _, h := range headers {
+			found = found || h == name
+		}
+		if !found {
+			return nil, fmt.Errorf("type hint for unknown column %s", name)
+		}
+	}
+
+	p := newValueParser(options)
+	types := make([]arrow.DataType, len(headers))
+	nullable := make([]bool, len(headers))
+	for _, row := range rows {
 		for j, val := range row {
-			t := detectValueType(val)
-			types[j] = mergeArrowType(types[j], t)
+			if j >= len(headers) {
+				break
+			}
+			if strings.TrimSpace(val) == "" || val == options.NullToken {
+				nullable[j] = true
+				continue
+			}
+			types[j] = mergeArrowType(types[j], p.detect(val))
+		}
+		// Short rows lack values for the remaining columns
+		for j := len(row); j < len(headers); j++ {
+			nullable[j] = true
 		}
 	}
+
 	fields := make([]arrow.Field, len(headers))
 	for i, name := range headers {
 		typ := types[i]
+		if hint, ok := options.TypeHints[name]; ok {
+			typ = hint
+		}
 		if typ == nil {
 			typ = arrow.BinaryTypes.String
+			nullable[i] = true
 		}
-		fields[i] = arrow.Field{Name: name, Type: typ, Nullable: true}
+		fields[i] = arrow.Field{Name: name, Type: typ, Nullable: nullable[i]}
 	}
 	return arrow.NewSchema(fields, nil), nil
 }
 
-func detectValueType(v string) arrow.DataType {
-	if _, err := strconv.ParseInt(v, 10, 64); err == nil {
-		return arrow.PrimitiveTypes.Int64
+// valueParser recognizes typed values in text
+type valueParser struct {
+	decimalComma bool
+	grouping     bool
+	dateFormats  []string
+}
+
+func newValueParser(options *Options) *valueParser {
+	lang := strings.ToLower(options.Locale)
+	if i := strings.IndexAny(lang, "-_"); i >= 0 {
+		lang = lang[:i]
 	}
-	if _, err := strconv.ParseFloat(v, 64); err == nil {
-		return arrow.PrimitiveTypes.Float64
+	return &valueParser{
+		decimalComma: decimalCommaLocales[lang],
+		grouping:     lang != "",
+		dateFormats:  append(append([]string(nil), options.DateFormats...), localeDateFormats[lang]...),
 	}
+}
+
+// number normalizes a number written for the locale to Go syntax
+func (p *valueParser) number(v string) string {
+	if !p.grouping {
+		return v
+	}
+	group, decimal := ",", "."
+	if p.decimalComma {
+		group, decimal = ".", ","
+	}
+	v = strings.ReplaceAll(v, group, "")
+	v = strings.ReplaceAll(v, " ", "")
+	v = strings.ReplaceAll(v, "\u00a0", "")
+	return strings.Replace(v, decimal, ".", 1)
+}
+
+func (p *valueParser) detect(v string) arrow.DataType {
+	v = strings.TrimSpace(v)
+	// Dates first: with grouping 31.12.2024 would pass as a number
 	if _, err := time.Parse(time.RFC3339, v); err == nil {
 		return arrow.FixedWidthTypes.Timestamp_s
 	}
-	if v == "true" || v == "false" {
+	for _, layout := range p.dateFormats {
+		if _, err := time.Parse(layout, v); err == nil {
+			return arrow.FixedWidthTypes.Timestamp_s
+		}
+	}
+	n := p.number(v)
+	if _, err := strconv.ParseInt(n, 10, 64); err == nil {
+		return arrow.PrimitiveTypes.Int64
+	}
+	if _, err := strconv.ParseFloat(n, 64); err == nil {
+		return arrow.PrimitiveTypes.Float64
+	}
+	if strings.EqualFold(v, "true") || strings.EqualFold(v, "false") {
 		return arrow.FixedWidthTypes.Boolean
 	}
 	return arrow.BinaryTypes.String
@@ -76,7 +245,14 @@ func mergeArrowType(a, b arrow.DataType) arrow.DataType {
 		return a
 	}
 	if a.ID() != b.ID() {
+		if isNumeric(a) && isNumeric(b) {
+			return arrow.PrimitiveTypes.Float64
+		}
 		return arrow.BinaryTypes.String
 	}
 	return a
 }
+
+func isNumeric(t arrow.DataType) bool {
+	return t.ID() == arrow.INT64 || t.ID() == arrow.FLOAT64
+}
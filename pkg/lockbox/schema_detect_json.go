@@ -0,0 +1,305 @@
+package lockbox
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/apache/arrow-go/v18/arrow"
+	"github.com/apache/arrow-go/v18/parquet/file"
+	"github.com/apache/arrow-go/v18/parquet/pqarrow"
+)
+
+// WithFlatten maps nested objects and structs to top-level columns whose
+// names join the path with sep, e.g. address.city for sep ".". Without it
+// DetectJSONSchema stores nested objects as JSON text and
+// DetectParquetSchema keeps struct columns.
+func WithFlatten(sep string) Option {
+	return func(o *Options) {
+		o.FlattenSep = sep
+	}
+}
+
+// DetectJSONSchema infers an Arrow schema from a JSON file holding an array
+// of objects or one object per line (NDJSON). It reads up to sample
+// objects; sample <= 0 reads 10. Columns are ordered as first seen.
+// Integral numbers are int64, other numbers float64, RFC 3339 strings
+// timestamps; columns that are null or missing in some object are
+// nullable. Arrays, and objects unless WithFlatten is given, become string
+// columns holding JSON. WithTypeHint overrides inferred types. Lines of
+// NDJSON that are not JSON objects are left out of the sample, as
+// IngestNDJSON rejects them.
+func DetectJSONSchema(path string, sample int, opts ...Option) (*arrow.Schema, error) {
+	options := &Options{}
+	for _, opt := range opts {
+		opt(options)
+	}
+	if sample <= 0 {
+		sample = 10
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	br := bufio.NewReader(f)
+	skipBOM(br)
+	array := isJSONArray(br)
+	objects, err := sampleJSONObjects(br, sample)
+	if err != nil && !array {
+		if _, serr := f.Seek(0, io.SeekStart); serr != nil {
+			return nil, err
+		}
+		br = bufio.NewReader(f)
+		skipBOM(br)
+		objects, err = sampleNDJSONLines(br, sample)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	d := &jsonDetector{parser: newValueParser(options), sep: options.FlattenSep, types: map[string]arrow.DataType{}, seen: map[string]int{}}
+	for _, obj := range objects {
+		d.object("", obj)
+	}
+
+	fields := make([]arrow.Field, len(d.names))
+	for i, name := range d.names {
+		typ := d.types[name]
+		if hint, ok := options.TypeHints[name]; ok {
+			typ = hint
+		}
+		nullable := d.nulls[name] || d.seen[name] < len(objects)
+		if typ == nil {
+			typ = arrow.BinaryTypes.String
+			nullable = true
+		}
+		fields[i] = arrow.Field{Name: name, Type: typ, Nullable: nullable}
+	}
+	for name := range options.TypeHints {
+		if _, ok := d.seen[name]; !ok {
+			return nil, fmt.Errorf("type hint for unknown column %s", name)
+		}
+	}
+	return arrow.NewSchema(fields, nil), nil
+}
+
+// jsonObject is a decoded JSON object that remembers the order of its keys
+type jsonObject struct {
+	keys   []string
+	values map[string]json.RawMessage
+	raw    []byte
+}
+
+// UnmarshalJSON implements json.Unmarshaler
+func (o *jsonObject) UnmarshalJSON(data []byte) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	tok, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	if delim, ok := tok.(json.Delim); !ok || delim != '{' {
+		return fmt.Errorf("expected a JSON object")
+	}
+	o.values = map[string]json.RawMessage{}
+	o.raw = append([]byte(nil), data...)
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		key := tok.(string)
+		var raw json.RawMessage
+		if err := dec.Decode(&raw); err != nil {
+			return err
+		}
+		if _, dup := o.values[key]; !dup {
+			o.keys = append(o.keys, key)
+		}
+		o.values[key] = raw
+	}
+	return nil
+}
+
+// isJSONArray reports whether r holds a JSON array rather than NDJSON
+func isJSONArray(r *bufio.Reader) bool {
+	head, _ := r.Peek(4096)
+	return bytes.HasPrefix(bytes.TrimSpace(head), []byte("["))
+}
+
+// sampleNDJSONLines decodes up to n objects from the lines of NDJSON,
+// skipping lines that are not JSON objects
+func sampleNDJSONLines(r *bufio.Reader, n int) ([]*jsonObject, error) {
+	var objects []*jsonObject
+	for len(objects) < n {
+		line, err := r.ReadBytes('\n')
+		if line = bytes.TrimSpace(line); len(line) > 0 {
+			obj := &jsonObject{}
+			if json.Unmarshal(line, obj) == nil {
+				objects = append(objects, obj)
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	if len(objects) == 0 {
+		return nil, errors.New("no JSON objects found")
+	}
+	return objects, nil
+}
+
+// sampleJSONObjects decodes up to n objects from a JSON array or NDJSON
+func sampleJSONObjects(r *bufio.Reader, n int) ([]*jsonObject, error) {
+	array := isJSONArray(r)
+	dec := json.NewDecoder(r)
+
+	if array {
+		if _, err := dec.Token(); err != nil {
+			return nil, fmt.Errorf("failed to read JSON array: %w", err)
+		}
+	}
+	var objects []*jsonObject
+	for len(objects) < n && dec.More() {
+		obj := &jsonObject{}
+		if err := dec.Decode(obj); err != nil {
+			return nil, fmt.Errorf("failed to decode object %d: %w", len(objects)+1, err)
+		}
+		objects = append(objects, obj)
+	}
+	if len(objects) == 0 {
+		return nil, errors.New("no JSON objects found")
+	}
+	return objects, nil
+}
+
+// jsonDetector accumulates column types over sampled objects
+type jsonDetector struct {
+	parser *valueParser
+	sep    string
+	names  []string
+	types  map[string]arrow.DataType
+	nulls  map[string]bool
+	seen   map[string]int
+}
+
+func (d *jsonDetector) object(prefix string, obj *jsonObject) {
+	for _, k := range obj.keys {
+		name := prefix + k
+		raw := bytes.TrimSpace(obj.values[k])
+		if d.sep != "" && bytes.HasPrefix(raw, []byte("{")) {
+			nested := &jsonObject{}
+			if err := json.Unmarshal(raw, nested); err == nil {
+				d.object(name+d.sep, nested)
+				continue
+			}
+		}
+		d.value(name, raw)
+	}
+}
+
+func (d *jsonDetector) value(name string, raw json.RawMessage) {
+	if _, ok := d.seen[name]; !ok {
+		d.names = append(d.names, name)
+	}
+	d.seen[name]++
+
+	var typ arrow.DataType
+	switch {
+	case string(raw) == "null":
+		if d.nulls == nil {
+			d.nulls = map[string]bool{}
+		}
+		d.nulls[name] = true
+		return
+	case string(raw) == "true" || string(raw) == "false":
+		typ = arrow.FixedWidthTypes.Boolean
+	case len(raw) > 0 && raw[0] == '"':
+		var s string
+		if err := json.Unmarshal(raw, &s); err == nil {
+			typ = d.parser.detect(s)
+			if typ.ID() != arrow.TIMESTAMP {
+				typ = arrow.BinaryTypes.String
+			}
+		}
+	case len(raw) > 0 && (raw[0] == '{' || raw[0] == '['):
+		typ = arrow.BinaryTypes.String
+	default:
+		var n json.Number
+		if err := json.Unmarshal(raw, &n); err == nil {
+			if _, err := n.Int64(); err == nil {
+				typ = arrow.PrimitiveTypes.Int64
+			} else {
+				typ = arrow.PrimitiveTypes.Float64
+			}
+		}
+	}
+	if typ == nil {
+		typ = arrow.BinaryTypes.String
+	}
+	d.types[name] = mergeArrowType(d.types[name], typ)
+}
+
+// DetectParquetSchema returns the Arrow schema of a Parquet file. With
+// WithFlatten struct columns are replaced by their leaf fields; lists and
+// maps are kept. WithTypeHint overrides column types.
+func DetectParquetSchema(path string, opts ...Option) (*arrow.Schema, error) {
+	options := &Options{}
+	for _, opt := range opts {
+		opt(options)
+	}
+
+	pf, err := file.OpenParquetFile(path, false)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open parquet file: %w", err)
+	}
+	defer pf.Close()
+
+	schema, err := pqarrow.FromParquet(pf.MetaData().Schema, nil, pf.MetaData().KeyValueMetadata())
+	if err != nil {
+		return nil, fmt.Errorf("failed to convert parquet schema: %w", err)
+	}
+
+	var fields []arrow.Field
+	for _, f := range schema.Fields() {
+		fields = append(fields, flattenField(f, "", options.FlattenSep, false)...)
+	}
+	seen := map[string]bool{}
+	for i, f := range fields {
+		seen[f.Name] = true
+		if hint, ok := options.TypeHints[f.Name]; ok {
+			fields[i].Type = hint
+		}
+	}
+	for name := range options.TypeHints {
+		if !seen[name] {
+			return nil, fmt.Errorf("type hint for unknown column %s", name)
+		}
+	}
+	return arrow.NewSchema(fields, nil), nil
+}
+
+// flattenField replaces a struct field by its leaves when sep is set. A
+// leaf is nullable when it or any enclosing struct is.
+func flattenField(f arrow.Field, prefix, sep string, nullable bool) []arrow.Field {
+	st, ok := f.Type.(*arrow.StructType)
+	if sep == "" || !ok {
+		f.Name = prefix + f.Name
+		f.Nullable = f.Nullable || nullable
+		return []arrow.Field{f}
+	}
+	var out []arrow.Field
+	for _, child := range st.Fields() {
+		out = append(out, flattenField(child, prefix+f.Name+sep, sep, nullable || f.Nullable)...)
+	}
+	return out
+}
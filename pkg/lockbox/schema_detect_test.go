@@ -1,6 +1,14 @@
 package lockbox
 
-import "testing"
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/apache/arrow-go/v18/arrow"
+	"github.com/apache/arrow-go/v18/arrow/array"
+	"github.com/apache/arrow-go/v18/arrow/memory"
+)
 
 func TestDetectCSVSchema(t *testing.T) {
 	schema, err := DetectCSVSchema("../../data.csv", 2)
@@ -11,3 +19,214 @@ func TestDetectCSVSchema(t *testing.T) {
 		t.Fatalf("expected schema")
 	}
 }
+
+func TestDetectCSVSchemaOptions(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	tests := []struct {
+		name     string
+		content  string
+		opts     []Option
+		want     []arrow.Type
+		nullable []bool
+		names    []string
+	}{
+		{
+			name:     "defaults",
+			content:  "id,score,note\n1,2,\n2,2.5,x\n",
+			want:     []arrow.Type{arrow.INT64, arrow.FLOAT64, arrow.STRING},
+			nullable: []bool{false, false, true},
+		},
+		{
+			name:    "semicolon and single quotes",
+			content: "id;name\n1;'a;b'\n2;'c'\n",
+			want:    []arrow.Type{arrow.INT64, arrow.STRING},
+		},
+		{
+			name:    "german locale",
+			content: "amount\tday\n1.234,5\t31.12.2024\n7\t01.01.2025\n",
+			opts:    []Option{WithLocale("de-DE")},
+			want:    []arrow.Type{arrow.FLOAT64, arrow.TIMESTAMP},
+		},
+		{
+			name:    "no header",
+			content: "1|true\n2|FALSE\n",
+			opts:    []Option{WithNoHeader()},
+			want:    []arrow.Type{arrow.INT64, arrow.BOOL},
+			names:   []string{"column_1", "column_2"},
+		},
+		{
+			name:    "type hint and date format",
+			content: "zip,seen\n01234,15/03/2024 10:00\n",
+			opts:    []Option{WithTypeHint("zip", arrow.BinaryTypes.String), WithDateFormats("02/01/2006 15:04")},
+			want:    []arrow.Type{arrow.STRING, arrow.TIMESTAMP},
+		},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := write(filepath.Base(t.Name())+".csv", tt.content)
+			schema, err := DetectCSVSchema(path, 10, tt.opts...)
+			if err != nil {
+				t.Fatalf("detect: %v", err)
+			}
+			if schema.NumFields() != len(tt.want) {
+				t.Fatalf("expected %d fields, got %s", len(tt.want), schema)
+			}
+			for j, f := range schema.Fields() {
+				if f.Type.ID() != tt.want[j] {
+					t.Errorf("case %d field %s: expected %s, got %s", i, f.Name, tt.want[j], f.Type)
+				}
+				if tt.nullable != nil && f.Nullable != tt.nullable[j] {
+					t.Errorf("field %s: expected nullable %v", f.Name, tt.nullable[j])
+				}
+				if tt.names != nil && f.Name != tt.names[j] {
+					t.Errorf("expected name %s, got %s", tt.names[j], f.Name)
+				}
+			}
+		})
+	}
+
+	path := write("hint.csv", "a\n1\n")
+	if _, err := DetectCSVSchema(path, 10, WithTypeHint("b", arrow.BinaryTypes.String)); err == nil {
+		t.Fatal("expected error for hint on unknown column")
+	}
+}
+
+func TestDetectJSONSchema(t *testing.T) {
+	dir := t.TempDir()
+	ndjson := filepath.Join(dir, "rows.ndjson")
+	content := `{"id": 1, "user": {"name": "a", "age": 30}, "at": "2024-01-02T03:04:05Z", "tags": ["x"]}
+{"id": 2, "user": {"name": "b", "age": 31.5}, "at": null, "ok": true}
+`
+	if err := os.WriteFile(ndjson, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	arrayPath := filepath.Join(dir, "rows.json")
+	if err := os.WriteFile(arrayPath, []byte("[\n"+`{"id": 1, "user": {"name": "a"}}`+",\n"+`{"id": 2}`+"\n]"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	type field struct {
+		name     string
+		typ      arrow.Type
+		nullable bool
+	}
+	tests := []struct {
+		name string
+		path string
+		opts []Option
+		want []field
+	}{
+		{
+			name: "nested as json",
+			path: ndjson,
+			want: []field{
+				{"id", arrow.INT64, false},
+				{"user", arrow.STRING, false},
+				{"at", arrow.TIMESTAMP, true},
+				{"tags", arrow.STRING, true},
+				{"ok", arrow.BOOL, true},
+			},
+		},
+		{
+			name: "flattened",
+			path: ndjson,
+			opts: []Option{WithFlatten(".")},
+			want: []field{
+				{"id", arrow.INT64, false},
+				{"user.name", arrow.STRING, false},
+				{"user.age", arrow.FLOAT64, false},
+				{"at", arrow.TIMESTAMP, true},
+				{"tags", arrow.STRING, true},
+				{"ok", arrow.BOOL, true},
+			},
+		},
+		{
+			name: "array",
+			path: arrayPath,
+			opts: []Option{WithFlatten("_"), WithTypeHint("id", arrow.BinaryTypes.String)},
+			want: []field{
+				{"id", arrow.STRING, false},
+				{"user_name", arrow.STRING, true},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			schema, err := DetectJSONSchema(tt.path, 10, tt.opts...)
+			if err != nil {
+				t.Fatalf("detect: %v", err)
+			}
+			if schema.NumFields() != len(tt.want) {
+				t.Fatalf("expected %d fields, got %s", len(tt.want), schema)
+			}
+			for i, f := range schema.Fields() {
+				w := tt.want[i]
+				if f.Name != w.name || f.Type.ID() != w.typ || f.Nullable != w.nullable {
+					t.Errorf("field %d: expected %+v, got %s", i, w, f)
+				}
+			}
+		})
+	}
+}
+
+func TestDetectParquetSchema(t *testing.T) {
+	point := arrow.StructOf(
+		arrow.Field{Name: "x", Type: arrow.PrimitiveTypes.Float64},
+		arrow.Field{Name: "y", Type: arrow.PrimitiveTypes.Float64},
+	)
+	schema := arrow.NewSchema([]arrow.Field{
+		{Name: "id", Type: arrow.PrimitiveTypes.Int64},
+		{Name: "point", Type: point, Nullable: true},
+	}, nil)
+	b := array.NewRecordBuilder(memory.NewGoAllocator(), schema)
+	defer b.Release()
+	b.Field(0).(*array.Int64Builder).Append(1)
+	sb := b.Field(1).(*array.StructBuilder)
+	sb.Append(true)
+	sb.FieldBuilder(0).(*array.Float64Builder).Append(1.5)
+	sb.FieldBuilder(1).(*array.Float64Builder).Append(2.5)
+	rec := b.NewRecord()
+	defer rec.Release()
+
+	path := filepath.Join(t.TempDir(), "points.parquet")
+	if err := writeParquet(path, rec); err != nil {
+		t.Fatalf("write parquet: %v", err)
+	}
+
+	got, err := DetectParquetSchema(path)
+	if err != nil {
+		t.Fatalf("detect: %v", err)
+	}
+	if got.NumFields() != 2 || got.Field(1).Type.ID() != arrow.STRUCT {
+		t.Fatalf("unexpected schema %s", got)
+	}
+
+	got, err = DetectParquetSchema(path, WithFlatten("_"))
+	if err != nil {
+		t.Fatalf("detect flattened: %v", err)
+	}
+	want := []string{"id", "point_x", "point_y"}
+	if got.NumFields() != len(want) {
+		t.Fatalf("unexpected flattened schema %s", got)
+	}
+	for i, f := range got.Fields() {
+		if f.Name != want[i] {
+			t.Errorf("expected %s, got %s", want[i], f.Name)
+		}
+		if i > 0 && (!f.Nullable || f.Type.ID() != arrow.FLOAT64) {
+			t.Errorf("field %s: expected nullable float64", f)
+		}
+	}
+
+	if _, err := DetectParquetSchema(path, WithTypeHint("missing", arrow.BinaryTypes.String)); err == nil {
+		t.Fatal("expected error for hint on unknown column")
+	}
+}
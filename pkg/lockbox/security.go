@@ -0,0 +1,208 @@
+package lockbox
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/TFMV/lockbox/pkg/crypto"
+	"github.com/TFMV/lockbox/pkg/format"
+	"github.com/TFMV/lockbox/pkg/metadata"
+)
+
+// StrictSecurityEnv turns on strict security for every lockbox opened or
+// created by the process, as if WithStrictSecurity were given
+const StrictSecurityEnv = "LOCKBOX_STRICT_SECURITY"
+
+// StrictMinIterations is the fewest PBKDF2-HMAC-SHA256 iterations strict
+// security accepts, the current OWASP recommendation
+const StrictMinIterations = 600000
+
+// StrictMinArgon2Memory is the least Argon2id memory cost, in KiB, strict
+// security accepts, the OWASP minimum
+const StrictMinArgon2Memory = 19 * 1024
+
+// ErrWeakSecurity is returned in strict security mode for files that use
+// legacy or weak settings
+var ErrWeakSecurity = errors.New("weak security settings")
+
+// SecurityFinding is a setting of a file that strict security refuses
+type SecurityFinding struct {
+	Check  string `json:"check"`
+	Detail string `json:"detail"`
+	Fix    string `json:"fix"`
+}
+
+// WithStrictSecurity makes Open and Create refuse files whose settings have
+// any SecurityFindings: a PBKDF2 iteration count below StrictMinIterations
+// or Argon2id memory cost below StrictMinArgon2Memory, the edwards25519
+// hybrid scheme of the default crypto module or ML-KEM keys derived from
+// column keys, unsigned blocks or cleartext metadata. New files sign their
+// blocks, so they pass once created with enough key derivation cost, the
+// ML-KEM module and WithEncryptedMetadata; the error lists what has to
+// change.
+func WithStrictSecurity() Option {
+	return func(o *Options) {
+		o.StrictSecurity = true
+	}
+}
+
+// WithEncryptedMetadata encrypts the metadata of a new lockbox with a key
+// derived from the master key: the schema, audit trail, access policy,
+// tags and block layout. Only the header and the parameters needed to
+// unlock the master key stay in the clear, so information that is read
+// without a password, such as ReadInfo, is reduced to the file size and
+// crypto module. Access passwords can still open the file.
+func WithEncryptedMetadata() Option {
+	return func(o *Options) {
+		o.SealMetadata = true
+	}
+}
+
+// WithArgon2id makes Create derive keys from passwords with Argon2id
+// instead of PBKDF2. Zero costs take the defaults of the crypto package,
+// 3 passes over 64 MiB with 4 threads. The costs are recorded in the file,
+// and Open uses whichever derivation a file records, so files created with
+// PBKDF2 still open.
+func WithArgon2id(params metadata.Argon2Params) Option {
+	return func(o *Options) {
+		if params.Time == 0 {
+			params.Time = crypto.Argon2Time
+		}
+		if params.Memory == 0 {
+			params.Memory = crypto.Argon2Memory
+		}
+		if params.Threads == 0 {
+			params.Threads = crypto.Argon2Threads
+		}
+		o.Argon2 = &params
+	}
+}
+
+// encryptMetadata seals the metadata of a new file with the master key
+// that password unlocks
+func encryptMetadata(file *format.LockboxFile, password string) error {
+	masterKey, err := file.MasterKey(password)
+	if err != nil {
+		return err
+	}
+	return file.EncryptMetadata(masterKey)
+}
+
+// strictSecurity reports whether options or the environment ask for strict
+// security
+func strictSecurity(options *Options) bool {
+	return options.StrictSecurity || os.Getenv(StrictSecurityEnv) != ""
+}
+
+// SecurityFindings returns the settings of the lockbox strict security
+// refuses
+func (lb *Lockbox) SecurityFindings() []SecurityFinding {
+	return securityFindings(lb.file.Metadata().Encryption, lb.file.MetadataEncrypted())
+}
+
+// CheckSecurity returns the settings of a lockbox file strict security
+// refuses. It reads the cleartext metadata, so no password is needed; of
+// encrypted metadata that is the key derivation parameters.
+func CheckSecurity(filename string) ([]SecurityFinding, error) {
+	file, err := openMetadataOnly(filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open lockbox file: %w", err)
+	}
+	defer file.Close()
+	return securityFindings(file.Metadata().Encryption, file.MetadataEncrypted()), nil
+}
+
+// newEncryptionParams returns the settings Create records for module and
+// options
+func newEncryptionParams(module crypto.Module, options *Options) metadata.EncryptionParams {
+	enc := metadata.EncryptionParams{KeyDerivation: metadata.KDFPBKDF2, Iterations: crypto.PBKDF2Iterations, Module: module.Name(),
+		ColumnKeyDerivation: metadata.ColumnKeySignedHKDF, BlockSignatures: metadata.SignatureEd25519}
+	if pp, ok := module.(crypto.ParamsProvider); ok {
+		enc.ModuleParams = pp.Params()
+	}
+	if options.Argon2 != nil {
+		enc.KeyDerivation, enc.Argon2 = metadata.KDFArgon2id, options.Argon2
+	}
+	return enc
+}
+
+// securityFindings checks encryption settings and whether the metadata is
+// encrypted against strict security. Modules that derive keys differently
+// than PBKDF2 with the recorded iterations report their count in the
+// "iterations" module parameter.
+func securityFindings(enc metadata.EncryptionParams, sealed bool) []SecurityFinding {
+	var findings []SecurityFinding
+
+	if enc.KeyDerivation == metadata.KDFArgon2id {
+		if enc.Argon2 == nil || enc.Argon2.Memory < StrictMinArgon2Memory {
+			var memory uint32
+			if enc.Argon2 != nil {
+				memory = enc.Argon2.Memory
+			}
+			findings = append(findings, SecurityFinding{
+				Check:  "kdf-memory",
+				Detail: fmt.Sprintf("keys are derived with Argon2id over %d KiB, less than %d", memory, StrictMinArgon2Memory),
+				Fix:    fmt.Sprintf("create the file with an Argon2id memory cost of at least %d KiB", StrictMinArgon2Memory),
+			})
+		}
+	} else {
+		iterations := enc.Iterations
+		if v, ok := enc.ModuleParams["iterations"]; ok {
+			if n, err := strconv.Atoi(v); err == nil {
+				iterations = n
+			}
+		}
+		if iterations < StrictMinIterations {
+			findings = append(findings, SecurityFinding{
+				Check:  "kdf-iterations",
+				Detail: fmt.Sprintf("keys are derived with %d PBKDF2 iterations, fewer than %d", iterations, StrictMinIterations),
+				Fix:    fmt.Sprintf("create the file with Argon2id, or use a crypto module that derives keys with at least %d iterations", StrictMinIterations),
+			})
+		}
+	}
+	switch {
+	case enc.ModuleName() == "default":
+		findings = append(findings, SecurityFinding{
+			Check:  "pq-scheme",
+			Detail: "the default crypto module's post-quantum layer is a Diffie-Hellman exchange on edwards25519, which is not quantum resistant",
+			Fix:    "create or migrate the file with the " + crypto.MLKEMModule + " crypto module, which adds ML-KEM-768 under keys of its own",
+		})
+	case enc.ModuleName() == crypto.MLKEMModule && enc.ModuleParams[crypto.MLKEMKeysParam] != crypto.MLKEMKeysWrapped:
+		findings = append(findings, SecurityFinding{
+			Check:  "pq-scheme",
+			Detail: "the ML-KEM-768 keys of the file are derived from its column keys, so the encapsulation adds no security beyond them",
+			Fix:    "migrate the file; new " + crypto.MLKEMModule + " files keep an independent ML-KEM key per column",
+		})
+	}
+	if enc.SignatureScheme() == "" {
+		findings = append(findings, SecurityFinding{
+			Check:  "block-signatures",
+			Detail: "data blocks carry SHA-256 checksums but no signatures, so anyone who can write the file can replace them undetected",
+			Fix:    "migrate the file; new files sign their blocks",
+		})
+	}
+	if !sealed {
+		findings = append(findings, SecurityFinding{
+			Check:  "cleartext-metadata",
+			Detail: "the schema, tags, policies and audit trail are stored unencrypted",
+			Fix:    "create the file with encrypted metadata",
+		})
+	}
+	return findings
+}
+
+// refuseWeak returns ErrWeakSecurity listing findings, or nil when there
+// are none
+func refuseWeak(findings []SecurityFinding) error {
+	if len(findings) == 0 {
+		return nil
+	}
+	details := make([]string, len(findings))
+	for i, f := range findings {
+		details[i] = f.Detail
+	}
+	return fmt.Errorf("%w (strict security): %s", ErrWeakSecurity, strings.Join(details, "; "))
+}
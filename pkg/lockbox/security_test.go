@@ -0,0 +1,265 @@
+package lockbox
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/TFMV/lockbox/pkg/format"
+	"github.com/TFMV/lockbox/pkg/metadata"
+	"github.com/apache/arrow-go/v18/arrow"
+	"github.com/apache/arrow-go/v18/arrow/array"
+	"github.com/apache/arrow-go/v18/arrow/memory"
+)
+
+func TestStrictSecurity(t *testing.T) {
+	dir := t.TempDir()
+	password := "test_password_123"
+	schema := arrow.NewSchema([]arrow.Field{{Name: "id", Type: arrow.PrimitiveTypes.Int64}}, nil)
+
+	strict := filepath.Join(dir, "strict.lbx")
+	if _, err := Create(strict, schema, WithPassword(password), WithStrictSecurity()); !errors.Is(err, ErrWeakSecurity) {
+		t.Fatalf("expected strict create to be refused, got %v", err)
+	}
+	if _, err := os.Stat(strict); !os.IsNotExist(err) {
+		t.Errorf("expected no file to be created in strict mode")
+	}
+
+	path := filepath.Join(dir, "legacy.lbx")
+	lb, err := Create(path, schema, WithPassword(password))
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	lb.Close()
+
+	if _, err := Open(path, WithPassword(password), WithStrictSecurity()); !errors.Is(err, ErrWeakSecurity) {
+		t.Fatalf("expected strict open to be refused, got %v", err)
+	}
+	t.Setenv(StrictSecurityEnv, "1")
+	if _, err := Open(path, WithPassword(password)); !errors.Is(err, ErrWeakSecurity) {
+		t.Fatalf("expected %s to turn on strict mode, got %v", StrictSecurityEnv, err)
+	}
+
+	findings, err := CheckSecurity(path)
+	if err != nil {
+		t.Fatalf("check: %v", err)
+	}
+	var checks []string
+	for _, f := range findings {
+		checks = append(checks, f.Check)
+	}
+	want := []string{"kdf-iterations", "pq-scheme", "cleartext-metadata"}
+	if len(checks) != len(want) {
+		t.Fatalf("expected findings %v, got %v", want, checks)
+	}
+	for i := range want {
+		if checks[i] != want[i] {
+			t.Errorf("finding %d: expected %s, got %s", i, want[i], checks[i])
+		}
+	}
+
+	// A module with enough iterations and another scheme is only held back
+	// by unsigned blocks
+	enc := metadata.EncryptionParams{Iterations: 100000, Module: "hardened", ModuleParams: map[string]string{"iterations": "600000"}}
+	if got := securityFindings(enc, false); len(got) != 2 || got[0].Check != "block-signatures" {
+		t.Errorf("expected only format findings, got %+v", got)
+	}
+	if got := securityFindings(enc, true); len(got) != 1 {
+		t.Errorf("expected encrypted metadata to pass, got %+v", got)
+	}
+}
+
+func TestEncryptedMetadata(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sealed.lbx")
+	password := "test_password_123"
+	public := "public_password_456"
+	ctx := context.Background()
+	schema := arrow.NewSchema([]arrow.Field{
+		{Name: "id", Type: arrow.PrimitiveTypes.Int64},
+		{Name: "patient_ssn", Type: arrow.BinaryTypes.String},
+	}, nil)
+
+	lb, err := Create(path, schema, WithPassword(password), WithEncryptedMetadata(), WithCreatedBy("dr-house"))
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	b := array.NewRecordBuilder(memory.NewGoAllocator(), schema)
+	b.Field(0).(*array.Int64Builder).AppendValues([]int64{1, 2}, nil)
+	b.Field(1).(*array.StringBuilder).AppendValues([]string{"123-45-6789", "987-65-4321"}, nil)
+	rec := b.NewRecord()
+	b.Release()
+	defer rec.Release()
+	if err := lb.Write(ctx, rec, WithPassword(password)); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if err := lb.SetTags(map[string]string{"ward": "oncology"}); err != nil {
+		t.Fatalf("tags: %v", err)
+	}
+	codes, err := lb.GenerateRecoveryCodes(1, WithPassword(password))
+	if err != nil {
+		t.Fatalf("recovery codes: %v", err)
+	}
+	if err := lb.GrantAccess("public", public, []string{"id"}, WithPassword(password)); err != nil {
+		t.Fatalf("grant: %v", err)
+	}
+	lb.Close()
+
+	// Neither the structure nor the audit trail is left in the clear
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{"patient_ssn", "oncology", "dr-house", "public"} {
+		if bytes.Contains(raw, []byte(s)) {
+			t.Errorf("found %q in the clear", s)
+		}
+	}
+	info, err := ReadInfo(path)
+	if err != nil {
+		t.Fatalf("read info: %v", err)
+	}
+	if !info.EncryptedMetadata || info.Schema != nil || info.RowCount != 0 {
+		t.Errorf("expected only the cleartext part of the metadata, got %+v", info)
+	}
+	findings, err := CheckSecurity(path)
+	if err != nil {
+		t.Fatalf("check: %v", err)
+	}
+	for _, f := range findings {
+		if f.Check == "cleartext-metadata" {
+			t.Errorf("unexpected finding %+v", f)
+		}
+	}
+
+	if _, err := Open(path, WithPassword("wrong_password")); !errors.Is(err, format.ErrInvalidPassword) {
+		t.Errorf("expected a wrong password to be refused, got %v", err)
+	}
+	countRows := func(password, query string) int64 {
+		t.Helper()
+		lb, err := Open(path, WithPassword(password))
+		if err != nil {
+			t.Fatalf("open: %v", err)
+		}
+		defer lb.Close()
+		res, err := lb.Query(ctx, query, WithPassword(password))
+		if err != nil {
+			t.Fatalf("query: %v", err)
+		}
+		defer res.Release()
+		return res.NumRows()
+	}
+	if n := countRows(password, "SELECT patient_ssn FROM data"); n != 2 {
+		t.Errorf("expected 2 rows, got %d", n)
+	}
+	if n := countRows(public, "SELECT id FROM data"); n != 2 {
+		t.Errorf("expected 2 rows with the access password, got %d", n)
+	}
+
+	lb, err = Recover(path, codes[0], "new_password_789")
+	if err != nil {
+		t.Fatalf("recover: %v", err)
+	}
+	lb.Close()
+	if n := countRows("new_password_789", "SELECT id FROM data"); n != 2 {
+		t.Errorf("expected 2 rows after recovery, got %d", n)
+	}
+}
+
+func TestArgon2id(t *testing.T) {
+	dir := t.TempDir()
+	password := "test_password_123"
+	ctx := context.Background()
+	schema := arrow.NewSchema([]arrow.Field{{Name: "id", Type: arrow.PrimitiveTypes.Int64}}, nil)
+	cheap := metadata.Argon2Params{Time: 1, Memory: 1024, Threads: 1}
+
+	b := array.NewRecordBuilder(memory.NewGoAllocator(), schema)
+	b.Field(0).(*array.Int64Builder).AppendValues([]int64{1, 2, 3}, nil)
+	rec := b.NewRecord()
+	b.Release()
+	defer rec.Release()
+	countRows := func(path string, opts ...Option) int64 {
+		t.Helper()
+		lb, err := Open(path, opts...)
+		if err != nil {
+			t.Fatalf("open %s: %v", path, err)
+		}
+		defer lb.Close()
+		res, err := lb.Read(ctx, opts...)
+		if err != nil {
+			t.Fatalf("read %s: %v", path, err)
+		}
+		defer res.Release()
+		return res.NumRows()
+	}
+
+	path := filepath.Join(dir, "argon.lbx")
+	lb, err := Create(path, schema, WithPassword(password), WithArgon2id(cheap), WithEncryptedMetadata())
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	if err := lb.Write(ctx, rec, WithPassword(password)); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if err := lb.GrantAccess("reader", "reader_password_456", []string{"id"}, WithPassword(password)); err != nil {
+		t.Fatalf("grant: %v", err)
+	}
+	if err := lb.file.SetArgon2id(cheap); err == nil {
+		t.Errorf("expected the key derivation of a file with data to be fixed")
+	}
+	lb.Close()
+
+	// The costs stay in the clear so the key can be derived at Open
+	info, err := ReadInfo(path)
+	if err != nil {
+		t.Fatalf("read info: %v", err)
+	}
+	if info.KeyDerivation != metadata.KDFArgon2id {
+		t.Errorf("expected %s key derivation, got %q", metadata.KDFArgon2id, info.KeyDerivation)
+	}
+	if n := countRows(path, WithPassword(password)); n != 3 {
+		t.Errorf("expected 3 rows, got %d", n)
+	}
+	if n := countRows(path, WithPassword("reader_password_456")); n != 3 {
+		t.Errorf("expected 3 rows with the access password, got %d", n)
+	}
+	if _, err := Open(path, WithPassword("wrong_password")); err == nil {
+		t.Errorf("expected a wrong password to be refused")
+	}
+
+	// PBKDF2 files still open, and migrate to Argon2id
+	legacy := filepath.Join(dir, "legacy.lbx")
+	lb, err = Create(legacy, schema, WithPassword(password))
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	if err := lb.Write(ctx, rec, WithPassword(password)); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	lb.Close()
+	if n := countRows(legacy, WithPassword(password)); n != 3 {
+		t.Errorf("expected 3 rows, got %d", n)
+	}
+	migrated := filepath.Join(dir, "migrated.lbx")
+	if _, err := Migrate(ctx, legacy, migrated, WithPassword(password), WithArgon2id(cheap)); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	if info, err := ReadInfo(migrated); err != nil || info.KeyDerivation != metadata.KDFArgon2id {
+		t.Errorf("expected the migrated file to use %s, got %+v, %v", metadata.KDFArgon2id, info, err)
+	}
+	if n := countRows(migrated, WithPassword(password)); n != 3 {
+		t.Errorf("expected 3 migrated rows, got %d", n)
+	}
+
+	// Strict security judges Argon2id by its memory cost
+	enc := metadata.EncryptionParams{KeyDerivation: metadata.KDFArgon2id, Argon2: &cheap, Module: "hardened"}
+	if got := securityFindings(enc, true); len(got) != 2 || got[0].Check != "kdf-memory" {
+		t.Errorf("expected a kdf-memory finding, got %+v", got)
+	}
+	enc.Argon2 = &metadata.Argon2Params{Time: 3, Memory: 64 * 1024, Threads: 4}
+	if got := securityFindings(enc, true); len(got) != 1 {
+		t.Errorf("expected default Argon2id costs to pass, got %+v", got)
+	}
+}
@@ -0,0 +1,105 @@
+package lockbox
+
+import (
+	"fmt"
+
+	"github.com/TFMV/lockbox/pkg/metadata"
+	"github.com/apache/arrow-go/v18/arrow"
+	"github.com/apache/arrow-go/v18/arrow/array"
+	"github.com/apache/arrow-go/v18/arrow/memory"
+)
+
+// WithAutoIncrement makes Create mark int64 columns as auto-increment.
+// Writes that leave such a column out, or leave values null, get the next
+// values of its sequence; values given explicitly are kept, and the values
+// assigned come after the largest of them so they never collide. The high-water mark is stored in the metadata, so
+// numbering continues across commits and reopens.
+func WithAutoIncrement(columns ...string) Option {
+	return func(o *Options) {
+		o.AutoIncrement = append(o.AutoIncrement, columns...)
+	}
+}
+
+// checkSequences returns the sequences of columns of schema, which must
+// be int64 columns
+func checkSequences(schema *arrow.Schema, columns []string) ([]metadata.Sequence, error) {
+	var seqs []metadata.Sequence
+	seen := make(map[string]bool)
+	for _, name := range columns {
+		idx := schema.FieldIndices(name)
+		if len(idx) == 0 {
+			return nil, fmt.Errorf("auto-increment column %s is not in the schema", name)
+		}
+		if f := schema.Field(idx[0]); f.Type.ID() != arrow.INT64 {
+			return nil, fmt.Errorf("auto-increment column %s must be int64, got %s", name, f.Type)
+		}
+		if !seen[name] {
+			seen[name] = true
+			seqs = append(seqs, metadata.Sequence{Column: name})
+		}
+	}
+	return seqs, nil
+}
+
+// Sequences returns the auto-increment columns of the lockbox and the
+// last value each has assigned
+func (lb *Lockbox) Sequences() []metadata.Sequence {
+	return append([]metadata.Sequence(nil), lb.file.Metadata().Sequences...)
+}
+
+// fillSequences returns rec with values assigned to the nulls, or all rows
+// when rec lacks the column, of each auto-increment column, and advances
+// the high-water marks in the metadata, which the write then saves. A
+// failed write leaves a gap in the numbering. It returns rec itself when
+// there is nothing to fill.
+func (lb *Lockbox) fillSequences(mem memory.Allocator, rec arrow.Record) (arrow.Record, error) {
+	meta := lb.file.Metadata()
+	out := rec
+	for i := range meta.Sequences {
+		seq := &meta.Sequences[i]
+		var old *array.Int64
+		if idx := out.Schema().FieldIndices(seq.Column); len(idx) > 0 {
+			var ok bool
+			if old, ok = out.Column(idx[0]).(*array.Int64); !ok {
+				err := fmt.Errorf("auto-increment column %s must be int64, got %s", seq.Column, out.Column(idx[0]).DataType())
+				if out != rec {
+					out.Release()
+				}
+				return nil, err
+			}
+		}
+
+		last := seq.Last
+		if old != nil {
+			for row := 0; row < old.Len(); row++ {
+				if old.IsValid(row) {
+					last = max(last, old.Value(row))
+				}
+			}
+			if old.NullN() == 0 {
+				seq.Last = last
+				continue
+			}
+		}
+
+		b := array.NewInt64Builder(mem)
+		for row := 0; row < int(out.NumRows()); row++ {
+			if old != nil && old.IsValid(row) {
+				b.Append(old.Value(row))
+				continue
+			}
+			last++
+			b.Append(last)
+		}
+		col := b.NewArray()
+		b.Release()
+		next := withColumn(out, seq.Column, col)
+		col.Release()
+		if out != rec {
+			out.Release()
+		}
+		out = next
+		seq.Last = last
+	}
+	return out, nil
+}
@@ -0,0 +1,86 @@
+package lockbox
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/TFMV/lockbox/pkg/metadata"
+	"github.com/apache/arrow-go/v18/arrow"
+	"github.com/apache/arrow-go/v18/arrow/array"
+	"github.com/apache/arrow-go/v18/arrow/memory"
+)
+
+func TestAutoIncrement(t *testing.T) {
+	dir := t.TempDir()
+	password := "test_password_123"
+	ctx := context.Background()
+	schema := arrow.NewSchema([]arrow.Field{
+		{Name: "id", Type: arrow.PrimitiveTypes.Int64, Nullable: true},
+		{Name: "name", Type: arrow.BinaryTypes.String},
+	}, nil)
+
+	if _, err := Create(filepath.Join(dir, "bad.lbx"), schema, WithPassword(password), WithAutoIncrement("name")); err == nil {
+		t.Error("expected a string column to be refused")
+	}
+	if _, err := Create(filepath.Join(dir, "bad.lbx"), schema, WithPassword(password), WithAutoIncrement("missing")); err == nil {
+		t.Error("expected a missing column to be refused")
+	}
+
+	path := filepath.Join(dir, "people.lbx")
+	lb, err := Create(path, schema, WithPassword(password), WithAutoIncrement("id"))
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+
+	// Without the column
+	names := arrow.NewSchema(schema.Fields()[1:], nil)
+	b := array.NewRecordBuilder(memory.NewGoAllocator(), names)
+	b.Field(0).(*array.StringBuilder).AppendValues([]string{"ann", "bob"}, nil)
+	rec := b.NewRecord()
+	b.Release()
+	if err := lb.Write(ctx, rec, WithPassword(password)); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	rec.Release()
+	lb.Close()
+
+	// Numbering continues after reopening; given values are kept, and the
+	// values assigned in the same write come after them
+	lb, err = Open(path, WithPassword(password))
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer lb.Close()
+	b = array.NewRecordBuilder(memory.NewGoAllocator(), schema)
+	b.Field(0).(*array.Int64Builder).AppendValues([]int64{0, 10, 0}, []bool{false, true, false})
+	b.Field(1).(*array.StringBuilder).AppendValues([]string{"cy", "dee", "eve"}, nil)
+	rec = b.NewRecord()
+	b.Release()
+	if err := lb.Write(ctx, rec, WithPassword(password)); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	rec.Release()
+
+	res, err := lb.Query(ctx, "SELECT id FROM data", WithPassword(password))
+	if err != nil {
+		t.Fatalf("query: %v", err)
+	}
+	defer res.Release()
+	ids := res.Column(0).(*array.Int64)
+	want := []int64{1, 2, 11, 10, 12}
+	if ids.Len() != len(want) || ids.NullN() != 0 {
+		t.Fatalf("expected ids %v, got %v", want, ids)
+	}
+	for i, id := range want {
+		if ids.Value(i) != id {
+			t.Fatalf("expected ids %v, got %v", want, ids)
+		}
+	}
+	if seqs := lb.Sequences(); len(seqs) != 1 || seqs[0] != (metadata.Sequence{Column: "id", Last: 12}) {
+		t.Errorf("expected the high-water mark 12, got %+v", seqs)
+	}
+	if info, err := ReadInfo(path); err != nil || len(info.Sequences) != 1 || info.Sequences[0].Last != 12 {
+		t.Errorf("expected info to show the sequence, got %+v, %v", info, err)
+	}
+}
@@ -0,0 +1,421 @@
+package lockbox
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/TFMV/lockbox/pkg/metadata"
+	"github.com/apache/arrow-go/v18/arrow/array"
+	"github.com/rs/zerolog/log"
+)
+
+// DefaultTokenTTL is how long a bearer token handed out by a server is
+// valid
+const DefaultTokenTTL = time.Hour
+
+// DefaultAuditInterval is how long audit entries of served tables are
+// buffered before they are written to the files
+const DefaultAuditInterval = time.Minute
+
+// auditBatchSize is the number of buffered audit entries of a table that
+// are written without waiting for the interval
+const auditBatchSize = 256
+
+// Errors of the servers
+var (
+	// ErrUnauthenticated is returned for unknown users, wrong passwords and
+	// invalid or expired tokens
+	ErrUnauthenticated = errors.New("invalid credentials")
+	// ErrNotServed is returned for tables that are not served
+	ErrNotServed = errors.New("table not served")
+)
+
+// ServedTables are the lockbox files and the users of the Flight and HTTP
+// servers. Users log in with a password and get a bearer token for later
+// requests. Every read on behalf of a user runs with the user name as
+// principal, so access policies apply, and is recorded in the audit trail
+// of the file. Audit entries are buffered and written in batches, at the
+// latest after the audit interval and on Close. Tables of a tenant are
+// only visible to its users and count against its quota.
+//
+// Each file is opened once, when it is added, and its handle is shared by
+// the requests, so rows written to it later are served after a restart.
+type ServedTables struct {
+	ttl     time.Duration
+	every   time.Duration // audit interval
+	mu      sync.Mutex
+	tables  map[string]*servedTable
+	tenants []*Tenant
+	users   map[string][sha256.Size]byte
+	tokens  map[string]servedToken
+	now     func() time.Time
+}
+
+// servedTable is a lockbox file served under a name, of tenant when it was
+// found by AddDirectory. pending are the audit entries not yet written to
+// the file, flushing serializes writing them.
+type servedTable struct {
+	name     string
+	path     string
+	password string
+	tenant   *Tenant
+	lb       *Lockbox
+
+	audit    sync.Mutex
+	pending  []metadata.AccessEntry
+	timer    *time.Timer
+	flushing sync.Mutex
+}
+
+// servedToken is a bearer token handed out at login
+type servedToken struct {
+	user    string
+	expires time.Time
+}
+
+// NewServedTables returns an empty set of tables and users whose bearer
+// tokens are valid for ttl
+func NewServedTables(ttl time.Duration) *ServedTables {
+	if ttl <= 0 {
+		ttl = DefaultTokenTTL
+	}
+	return &ServedTables{
+		ttl:    ttl,
+		every:  DefaultAuditInterval,
+		tables: map[string]*servedTable{},
+		users:  map[string][sha256.Size]byte{},
+		tokens: map[string]servedToken{},
+		now:    time.Now,
+	}
+}
+
+// AddTable serves the lockbox at path as name, unlocked with password. It
+// fails when the password does not decrypt the file's first rows.
+func (s *ServedTables) AddTable(name, path, password string) error {
+	return s.addTable(name, path, password, nil)
+}
+
+// addTable serves a lockbox file as a table of tenant
+func (s *ServedTables) addTable(name, path, password string, tenant *Tenant) error {
+	lb, err := Open(path, WithPassword(password))
+	if err != nil {
+		return err
+	}
+	if err := checkServedPassword(lb, password); err != nil {
+		lb.Close()
+		return err
+	}
+	if tenant != nil {
+		if err := tenant.applyPolicy(lb); err != nil {
+			lb.Close()
+			return err
+		}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.tables[name]; ok {
+		lb.Close()
+		return fmt.Errorf("table %s is already served", name)
+	}
+	s.tables[name] = &servedTable{name: name, path: path, password: password, tenant: tenant, lb: lb}
+	return nil
+}
+
+// checkServedPassword fails when password does not decrypt the first rows
+// of lb
+func checkServedPassword(lb *Lockbox, password string) error {
+	if lb.file.Metadata().RowCount() == 0 {
+		return nil
+	}
+	scanner, err := lb.NewScanner(context.Background(), ScanOptions{BatchSize: 1}, WithPassword(password))
+	if err != nil {
+		return err
+	}
+	defer scanner.Release()
+	scanner.Next()
+	return scanner.Err()
+}
+
+// SetAuditInterval sets how long audit entries are buffered before they
+// are written to the files
+func (s *ServedTables) SetAuditInterval(d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.every = d
+}
+
+// Close writes the buffered audit entries and closes the files
+func (s *ServedTables) Close() error {
+	s.mu.Lock()
+	tables := make([]*servedTable, 0, len(s.tables))
+	for _, t := range s.tables {
+		tables = append(tables, t)
+	}
+	s.tables = map[string]*servedTable{}
+	s.mu.Unlock()
+
+	var errs []error
+	for _, t := range tables {
+		errs = append(errs, t.flush(), t.lb.Close())
+	}
+	return errors.Join(errs...)
+}
+
+// AddUser lets name log in with password
+func (s *ServedTables) AddUser(name, password string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.users[name] = sha256.Sum256([]byte(password))
+}
+
+// Names returns the names of the tables, sorted
+func (s *ServedTables) Names() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	names := make([]string, 0, len(s.tables))
+	for name := range s.tables {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// visible returns the names of the tables user may read, sorted
+func (s *ServedTables) visible(user string) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	names := make([]string, 0, len(s.tables))
+	for name, t := range s.tables {
+		if t.tenant == nil || t.tenant.member(user) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// CheckPassword verifies a user's password
+func (s *ServedTables) CheckPassword(username, password string) error {
+	sum := sha256.Sum256([]byte(password))
+	s.mu.Lock()
+	want, ok := s.users[username]
+	s.mu.Unlock()
+	if !ok || subtle.ConstantTimeCompare(sum[:], want[:]) != 1 {
+		log.Warn().Str("user", username).Msg("Rejected login")
+		return ErrUnauthenticated
+	}
+	return nil
+}
+
+// Login verifies a user's password and returns a new bearer token and when
+// it expires
+func (s *ServedTables) Login(username, password string) (string, time.Time, error) {
+	if err := s.CheckPassword(username, password); err != nil {
+		return "", time.Time{}, err
+	}
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", time.Time{}, fmt.Errorf("failed to create token: %w", err)
+	}
+	token := base64.RawURLEncoding.EncodeToString(raw)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.now()
+	for t, e := range s.tokens {
+		if now.After(e.expires) {
+			delete(s.tokens, t)
+		}
+	}
+	expires := now.Add(s.ttl)
+	s.tokens[token] = servedToken{user: username, expires: expires}
+	return token, expires, nil
+}
+
+// User returns the user a bearer token was handed to
+func (s *ServedTables) User(token string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, ok := s.tokens[token]
+	if !ok || s.now().After(e.expires) {
+		delete(s.tokens, token)
+		return "", ErrUnauthenticated
+	}
+	return e.user, nil
+}
+
+// Logout forgets every token
+func (s *ServedTables) Logout() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tokens = map[string]servedToken{}
+}
+
+// table returns a served table for user. Tables of tenants user is not a
+// member of are not served to them, so their names do not leak.
+func (s *ServedTables) table(name, user string) (*servedTable, error) {
+	t, err := s.lookup(name)
+	if err != nil {
+		return nil, err
+	}
+	if t.tenant != nil && !t.tenant.member(user) {
+		log.Warn().Str("table", name).Str("user", user).Str("tenant", t.tenant.Name).Msg("Refused table of another tenant")
+		return nil, fmt.Errorf("%w: %q", ErrNotServed, name)
+	}
+	return t, nil
+}
+
+// lookup returns a served table
+func (s *ServedTables) lookup(name string) (*servedTable, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t, ok := s.tables[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrNotServed, name)
+	}
+	return t, nil
+}
+
+// open returns a served table for user, within the quota of its tenant.
+// Its handle is shared and must not be closed.
+func (s *ServedTables) open(name, user string) (*servedTable, error) {
+	t, err := s.table(name, user)
+	if err != nil {
+		return nil, err
+	}
+	if t.tenant != nil {
+		if err := t.tenant.admit(s.now()); err != nil {
+			return nil, err
+		}
+	}
+	return t, nil
+}
+
+// read returns the table of a ticket and a reader over the rows it
+// selects for user: the result of its query, or its columns of the rows
+// matching its filter, batch by batch. Rows of a tenant's tables are
+// charged to its quota as they are read.
+func (s *ServedTables) read(ctx context.Context, t *FlightTicket, user string) (*servedTable, array.RecordReader, error) {
+	table, err := s.open(t.Table, user)
+	if err != nil {
+		return nil, nil, err
+	}
+	opts := []Option{WithPassword(table.password), WithPrincipal(user)}
+
+	var rr array.RecordReader
+	if t.Query != "" {
+		// Results are streamed a row group at a time where the query allows
+		rr, err = table.lb.QueryReader(ctx, t.Query, opts...)
+	} else {
+		rr, err = table.lb.NewScanner(ctx, ScanOptions{Columns: t.Columns, Filter: t.Filter}, opts...)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	if table.tenant != nil {
+		rr = &quotaReader{RecordReader: rr, tenant: table.tenant, now: s.now}
+	}
+	return table, rr, nil
+}
+
+// audit records a request that returned rows in the audit trail of a
+// table and, for tables of a tenant, writes it to the tenant's audit
+// stream. The entry of the file is
+// buffered, see flush.
+func (s *ServedTables) audit(name, user, action, resource string, rows int64, err error, details string) {
+	t, terr := s.lookup(name)
+	if terr != nil {
+		return
+	}
+	if err != nil {
+		details = err.Error()
+	}
+	if t.tenant != nil {
+		t.tenant.record(tenantAuditEntry{
+			Timestamp: s.now(),
+			Table:     name,
+			Principal: user,
+			Action:    action,
+			Resource:  resource,
+			Success:   err == nil,
+			Rows:      rows,
+			Details:   details,
+		})
+	}
+
+	entry := metadata.AccessEntry{
+		Timestamp: s.now(),
+		Principal: user,
+		Action:    action,
+		Resource:  resource,
+		Success:   err == nil,
+		Details:   details,
+	}
+	s.mu.Lock()
+	every := s.every
+	s.mu.Unlock()
+	t.audit.Lock()
+	t.pending = append(t.pending, entry)
+	full := len(t.pending) >= auditBatchSize
+	if !full && t.timer == nil {
+		t.timer = time.AfterFunc(every, func() { t.flush() })
+	}
+	t.audit.Unlock()
+	if full {
+		t.flush()
+	}
+}
+
+// flush writes the buffered audit entries of a table to its file. They are
+// written through a handle of their own, so the metadata saved is that of
+// the file and not the one the shared handle read when it was opened.
+// Failing to write them is logged rather than failing a request, which has
+// been answered.
+func (t *servedTable) flush() error {
+	t.flushing.Lock()
+	defer t.flushing.Unlock()
+	t.audit.Lock()
+	entries := t.pending
+	t.pending = nil
+	if t.timer != nil {
+		t.timer.Stop()
+		t.timer = nil
+	}
+	t.audit.Unlock()
+	if len(entries) == 0 {
+		return nil
+	}
+
+	lb, err := Open(t.path, WithPassword(t.password))
+	if err == nil {
+		meta := lb.file.Metadata()
+		meta.AuditTrail.Append(entries...)
+		err = lb.file.SaveMetadata()
+		lb.Close()
+	}
+	if err != nil {
+		log.Warn().Err(err).Str("table", t.name).Int("entries", len(entries)).Msg("Failed to record requests in audit trail")
+	}
+	return err
+}
+
+// describe renders a ticket for the audit trail
+func (t *FlightTicket) describe() string {
+	switch {
+	case t.Query != "":
+		return t.Query
+	case t.Filter != "":
+		return fmt.Sprintf("%v where %s", t.Columns, t.Filter)
+	case len(t.Columns) > 0:
+		return fmt.Sprint(t.Columns)
+	}
+	return t.Table
+}
@@ -0,0 +1,55 @@
+package lockbox
+
+import (
+	"context"
+	"fmt"
+)
+
+// SignatureReport is the result of VerifySignatures
+type SignatureReport struct {
+	File string `json:"file"`
+	// Scheme is how blocks are signed; empty for files created before
+	// block signatures, which have nothing to verify
+	Scheme  string   `json:"scheme,omitempty"`
+	Blocks  int      `json:"blocks"`
+	Invalid []string `json:"invalid,omitempty"`
+}
+
+// Valid reports whether the file signs its blocks and every signature
+// verifies
+func (r *SignatureReport) Valid() bool {
+	return r.Scheme != "" && len(r.Invalid) == 0
+}
+
+// VerifySignatures checks the checksum and signature of every block,
+// including the results of materialized views, against the signing key
+// derived from the password. Reads check the signatures of the blocks they
+// decrypt as well and fail with format.ErrBadSignature; this checks all of
+// them and lists every block that fails.
+func (lb *Lockbox) VerifySignatures(ctx context.Context, opts ...Option) (*SignatureReport, error) {
+	options := &Options{}
+	for _, opt := range opts {
+		opt(options)
+	}
+	if options.Password == "" {
+		return nil, fmt.Errorf("password is required for verifying signatures")
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	reader, err := lb.readerFor(options.Password)
+	if err != nil {
+		return nil, err
+	}
+
+	blocks, invalid, err := reader.VerifySignatures()
+	if err != nil {
+		return nil, err
+	}
+	return &SignatureReport{
+		File:    lb.Path(),
+		Scheme:  lb.file.Metadata().Encryption.SignatureScheme(),
+		Blocks:  blocks,
+		Invalid: invalid,
+	}, nil
+}
@@ -0,0 +1,180 @@
+package lockbox
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/TFMV/lockbox/pkg/format"
+	"github.com/TFMV/lockbox/pkg/metadata"
+	"github.com/apache/arrow-go/v18/arrow"
+	"github.com/apache/arrow-go/v18/arrow/array"
+	"github.com/apache/arrow-go/v18/arrow/memory"
+)
+
+func TestBlockSignatures(t *testing.T) {
+	dir := t.TempDir()
+	password := "test_password_123"
+	ctx := context.Background()
+	schema := arrow.NewSchema([]arrow.Field{
+		{Name: "id", Type: arrow.PrimitiveTypes.Int64},
+		{Name: "name", Type: arrow.BinaryTypes.String},
+	}, nil)
+	create := func(path string, signed bool) {
+		lb, err := Create(path, schema, WithPassword(password))
+		if err != nil {
+			t.Fatalf("create: %v", err)
+		}
+		defer lb.Close()
+		if !signed {
+			// As written before block signatures
+			enc := &lb.file.Metadata().Encryption
+			enc.BlockSignatures, enc.ColumnKeyDerivation = "", metadata.ColumnKeyHKDF
+		}
+		for batch := 0; batch < 2; batch++ {
+			b := array.NewRecordBuilder(memory.NewGoAllocator(), schema)
+			b.Field(0).(*array.Int64Builder).AppendValues([]int64{1, 2}, nil)
+			b.Field(1).(*array.StringBuilder).AppendValues([]string{"ann", "bob"}, nil)
+			rec := b.NewRecord()
+			b.Release()
+			if err := lb.Write(ctx, rec, WithPassword(password)); err != nil {
+				t.Fatalf("write: %v", err)
+			}
+			rec.Release()
+		}
+		if err := lb.CreateView("names", "SELECT name FROM data", WithMaterialized(true), WithPassword(password)); err != nil {
+			t.Fatalf("create view: %v", err)
+		}
+	}
+	verify := func(path string) *SignatureReport {
+		t.Helper()
+		lb, err := Open(path, WithPassword(password))
+		if err != nil {
+			t.Fatalf("open: %v", err)
+		}
+		defer lb.Close()
+		report, err := lb.VerifySignatures(ctx, WithPassword(password))
+		if err != nil {
+			t.Fatalf("verify: %v", err)
+		}
+		return report
+	}
+
+	path := filepath.Join(dir, "signed.lbx")
+	create(path, true)
+	if r := verify(path); !r.Valid() || r.Blocks != 5 || r.Scheme != metadata.SignatureEd25519 {
+		t.Fatalf("expected 5 valid blocks, got %+v", r)
+	}
+	if info, err := ReadInfo(path); err != nil || info.BlockSignatures != metadata.SignatureEd25519 {
+		t.Errorf("expected info to show block signatures, got %+v, %v", info, err)
+	}
+
+	// Someone without the password alters a block and fixes its checksum
+	lb, err := Open(path, WithPassword(password))
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	bi := &lb.file.Metadata().BlockInfo[2]
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := make([]byte, bi.Length)
+	if _, err := f.ReadAt(data, bi.Offset); err != nil {
+		t.Fatal(err)
+	}
+	data[len(data)-1] ^= 0xff
+	if _, err := f.WriteAt(data, bi.Offset); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	sum := sha256.Sum256(data)
+	bi.Checksum = sum[:]
+	if err := lb.file.SaveMetadata(); err != nil {
+		t.Fatalf("save metadata: %v", err)
+	}
+	lb.Close()
+
+	if r := verify(path); r.Valid() || len(r.Invalid) != 1 {
+		t.Errorf("expected one invalid block, got %+v", r)
+	}
+	lb, err = Open(path, WithPassword(password))
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	if _, err := lb.Read(ctx, WithPassword(password)); !errors.Is(err, format.ErrBadSignature) {
+		t.Errorf("expected ErrBadSignature reading the altered block, got %v", err)
+	}
+	lb.Close()
+
+	// Signatures cover the position of a block: swapping the blocks of two
+	// row groups leaves every checksum valid but no signature
+	swapped := filepath.Join(dir, "swapped.lbx")
+	create(swapped, true)
+	lb, err = Open(swapped, WithPassword(password))
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	blocks := lb.file.Metadata().BlockInfo
+	blocks[0], blocks[2] = blocks[2], blocks[0]
+	blocks[0].RowGroup, blocks[2].RowGroup = blocks[2].RowGroup, blocks[0].RowGroup
+	if err := lb.file.SaveMetadata(); err != nil {
+		t.Fatalf("save metadata: %v", err)
+	}
+	lb.Close()
+	if r := verify(swapped); len(r.Invalid) != 2 {
+		t.Errorf("expected both swapped blocks to be invalid, got %+v", r)
+	}
+
+	// Clearing the scheme in the metadata doesn't turn verification off,
+	// and claiming the older column key derivation breaks decryption
+	stripped := filepath.Join(dir, "stripped.lbx")
+	create(stripped, true)
+	strip := func(kdf string) {
+		t.Helper()
+		lb, err := Open(stripped, WithPassword(password))
+		if err != nil {
+			t.Fatalf("open: %v", err)
+		}
+		defer lb.Close()
+		enc := &lb.file.Metadata().Encryption
+		enc.BlockSignatures, enc.ColumnKeyDerivation = "", kdf
+		lb.file.Metadata().BlockInfo[0].Signature = nil
+		if err := lb.file.SaveMetadata(); err != nil {
+			t.Fatalf("save metadata: %v", err)
+		}
+	}
+	strip(metadata.ColumnKeySignedHKDF)
+	if r := verify(stripped); len(r.Invalid) != 2 || r.Scheme != metadata.SignatureEd25519 {
+		t.Errorf("expected the removed scheme and unsigned block to be reported, got %+v", r)
+	}
+	strip(metadata.ColumnKeyHKDF)
+	lb, err = Open(stripped, WithPassword(password))
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	if _, err := lb.Read(ctx, WithPassword(password)); err == nil {
+		t.Error("expected reading with the downgraded column key derivation to fail")
+	}
+	lb.Close()
+
+	// Files from before block signatures still read, and migrate signs them
+	legacy := filepath.Join(dir, "legacy.lbx")
+	create(legacy, false)
+	if r := verify(legacy); r.Valid() || r.Scheme != "" {
+		t.Errorf("expected an unsigned file, got %+v", r)
+	}
+	if findings, err := CheckSecurity(legacy); err != nil || findings[len(findings)-2].Check != "block-signatures" {
+		t.Errorf("expected a block-signatures finding, got %+v, %v", findings, err)
+	}
+	migrated := filepath.Join(dir, "migrated.lbx")
+	if _, err := Migrate(ctx, legacy, migrated, WithPassword(password)); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	if r := verify(migrated); !r.Valid() || r.Blocks != 5 {
+		t.Errorf("expected the migrated file to be signed, got %+v", r)
+	}
+}
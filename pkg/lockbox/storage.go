@@ -0,0 +1,67 @@
+package lockbox
+
+import (
+	"context"
+	"os"
+
+	"github.com/TFMV/lockbox/pkg/crypto"
+	"github.com/TFMV/lockbox/pkg/format"
+	"github.com/TFMV/lockbox/pkg/storage"
+	"github.com/apache/arrow-go/v18/arrow"
+)
+
+// WithStorage makes Create and Open keep the lockbox in storage instead of
+// a file on disk, such as a format.Buffer in memory. The file name is then
+// only used to identify the lockbox in logs and errors. Create needs empty
+// storage, and Close closes it.
+func WithStorage(storage format.Storage) Option {
+	return func(o *Options) {
+		o.Storage = storage
+	}
+}
+
+// createFile creates the lockbox file, in options.Storage when given
+func createFile(filename string, schema *arrow.Schema, options *Options, module crypto.Module) (*format.LockboxFile, error) {
+	if options.Storage != nil {
+		return format.CreateOn(options.Storage, filename, schema, options.Password, options.CreatedBy, module)
+	}
+	return format.Create(filename, schema, options.Password, options.CreatedBy, module)
+}
+
+// openFile opens the lockbox file, from options.Storage when given. Object
+// store URIs such as s3://bucket/file.lbx open read-only through
+// pkg/storage.
+func openFile(filename string, options *Options, module crypto.Module) (*format.LockboxFile, error) {
+	if options.Storage == nil && storage.IsRemote(filename) {
+		s, err := storage.Open(context.Background(), filename)
+		if err != nil {
+			return nil, err
+		}
+		options.Storage = s
+	}
+	if options.Storage != nil {
+		return format.OpenOn(options.Storage, filename, options.Password, module)
+	}
+	return format.Open(filename, options.Password, module)
+}
+
+// openMetadataOnly opens the cleartext metadata of a file, which may be in
+// an object store
+func openMetadataOnly(filename string) (*format.LockboxFile, error) {
+	if !storage.IsRemote(filename) {
+		return format.OpenMetadataOnly(filename)
+	}
+	s, err := storage.Open(context.Background(), filename)
+	if err != nil {
+		return nil, err
+	}
+	return format.OpenMetadataOnlyOn(s, filename)
+}
+
+// removeFile removes a file that failed to be created; storage is left to
+// its owner
+func removeFile(filename string, options *Options) {
+	if options.Storage == nil {
+		os.Remove(filename)
+	}
+}
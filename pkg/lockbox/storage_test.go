@@ -0,0 +1,78 @@
+package lockbox
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/TFMV/lockbox/pkg/format"
+	"github.com/apache/arrow-go/v18/arrow"
+	"github.com/apache/arrow-go/v18/arrow/array"
+	"github.com/apache/arrow-go/v18/arrow/memory"
+)
+
+func TestMemoryStorage(t *testing.T) {
+	password := "test_password_123"
+	ctx := context.Background()
+	schema := arrow.NewSchema([]arrow.Field{
+		{Name: "id", Type: arrow.PrimitiveTypes.Int64},
+		{Name: "name", Type: arrow.BinaryTypes.String},
+	}, nil)
+	// Only names the lockbox; nothing is written there
+	name := filepath.Join(t.TempDir(), "mem.lbx")
+
+	buf := &format.Buffer{}
+	lb, err := Create(name, schema, WithPassword(password), WithStorage(buf), WithEncryptedMetadata())
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	for batch := 0; batch < 2; batch++ {
+		b := array.NewRecordBuilder(memory.NewGoAllocator(), schema)
+		b.Field(0).(*array.Int64Builder).AppendValues([]int64{1, 2}, nil)
+		b.Field(1).(*array.StringBuilder).AppendValues([]string{"ann", "bob"}, nil)
+		rec := b.NewRecord()
+		b.Release()
+		if err := lb.Write(ctx, rec, WithPassword(password)); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+		rec.Release()
+	}
+
+	// gc compacts the buffer in place
+	before := len(buf.Bytes())
+	report, err := lb.GC(ctx)
+	if err != nil {
+		t.Fatalf("gc: %v", err)
+	}
+	if report.ReclaimedBytes == 0 || len(buf.Bytes()) != before-int(report.ReclaimedBytes) {
+		t.Errorf("expected gc to shrink the buffer from %d bytes, got %d (%+v)", before, len(buf.Bytes()), report)
+	}
+	lb.Close()
+	if _, err := os.Stat(name); !os.IsNotExist(err) {
+		t.Errorf("expected no file on disk, got %v", err)
+	}
+
+	// A copy of the bytes opens as the same lockbox
+	data := append([]byte(nil), buf.Bytes()...)
+	lb, err = Open(name, WithPassword(password), WithStorage(format.NewBuffer(data)))
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer lb.Close()
+	res, err := lb.Query(ctx, "SELECT name FROM data WHERE id = 2", WithPassword(password))
+	if err != nil {
+		t.Fatalf("query: %v", err)
+	}
+	defer res.Release()
+	if res.NumRows() != 2 || res.Column(0).(*array.String).Value(0) != "bob" {
+		t.Errorf("expected bob twice, got %v", res)
+	}
+	if err := lb.Validate(); err != nil {
+		t.Errorf("validate: %v", err)
+	}
+
+	if _, err := Create(name, schema, WithPassword(password), WithStorage(format.NewBuffer(data))); err == nil {
+		t.Error("expected Create to refuse storage that is not empty")
+	}
+}
@@ -0,0 +1,46 @@
+package lockbox
+
+import (
+	"fmt"
+
+	"github.com/apache/arrow-go/v18/arrow"
+)
+
+// subqueryValues runs an uncorrelated subquery and returns the set of
+// values in its single result column, keyed by their printed form.
+func (qe *queryExec) subqueryValues(sub *parsedQuery) (map[string]struct{}, error) {
+	rec, err := qe.execSelect(sub)
+	if err != nil {
+		return nil, fmt.Errorf("subquery failed: %w", err)
+	}
+	defer rec.Release()
+
+	if rec.NumCols() != 1 {
+		return nil, fmt.Errorf("subquery must select exactly one column, got %d", rec.NumCols())
+	}
+
+	col := rec.Column(0)
+	set := make(map[string]struct{}, col.Len())
+	for i := 0; i < col.Len(); i++ {
+		if col.IsNull(i) {
+			continue
+		}
+		set[valueKey(col, i)] = struct{}{}
+	}
+	return set, nil
+}
+
+// inValueSet reports whether the value at row is a member of set
+func inValueSet(col arrow.Array, row int, set map[string]struct{}) bool {
+	if col.IsNull(row) {
+		return false
+	}
+	_, ok := set[valueKey(col, row)]
+	return ok
+}
+
+// valueKey normalizes a value so that equal numbers compare equal across
+// integer and floating point columns.
+func valueKey(col arrow.Array, row int) string {
+	return fmt.Sprint(getValue(col, row))
+}
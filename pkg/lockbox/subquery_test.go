@@ -0,0 +1,36 @@
+package lockbox
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/apache/arrow-go/v18/arrow/array"
+)
+
+func TestQueryInSubquery(t *testing.T) {
+	tmpFile := "/tmp/test_lockbox_subquery.lbx"
+	defer os.Remove(tmpFile)
+
+	password := "test_password_123"
+	lb := newQueryTestLockbox(t, tmpFile, password)
+	defer lb.Close()
+
+	ctx := context.Background()
+	res, err := lb.Query(ctx, "SELECT id, name FROM data WHERE id IN (SELECT id FROM data WHERE name = 'bob') ORDER BY id", WithPassword(password))
+	if err != nil {
+		t.Fatalf("query error: %v", err)
+	}
+	defer res.Release()
+
+	if res.NumRows() != 1 {
+		t.Fatalf("expected 1 row, got %d", res.NumRows())
+	}
+	if name := res.Column(1).(*array.String).Value(0); name != "bob" {
+		t.Fatalf("unexpected name: %s", name)
+	}
+
+	if _, err := lb.Query(ctx, "SELECT id FROM data WHERE id IN (SELECT id, name FROM data)", WithPassword(password)); err == nil {
+		t.Fatalf("expected error for multi-column subquery")
+	}
+}
@@ -0,0 +1,176 @@
+package lockbox
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/TFMV/lockbox/pkg/metadata"
+	"github.com/apache/arrow-go/v18/arrow"
+	"github.com/apache/arrow-go/v18/arrow/array"
+	"github.com/apache/arrow-go/v18/arrow/memory"
+)
+
+// WithSyntheticKey makes Create record column as the file's synthetic key,
+// filled by writes with generated keys of kind metadata.SyntheticKeyUUID or
+// metadata.SyntheticKeySnowflake. The column is added to the schema when it
+// is not in it; otherwise it must be a string column for UUIDs and an int64
+// column for snowflake ids.
+func WithSyntheticKey(column, kind string) Option {
+	return func(o *Options) {
+		o.SyntheticKey = &metadata.SyntheticKey{Column: column, Kind: kind}
+	}
+}
+
+// SyntheticKey returns the column writes fill with generated keys, or nil
+func (lb *Lockbox) SyntheticKey() *metadata.SyntheticKey {
+	return lb.file.Metadata().SyntheticKey
+}
+
+// syntheticKeyType returns the type of the column holding keys of kind
+func syntheticKeyType(kind string) (arrow.DataType, error) {
+	switch kind {
+	case metadata.SyntheticKeyUUID:
+		return arrow.BinaryTypes.String, nil
+	case metadata.SyntheticKeySnowflake:
+		return arrow.PrimitiveTypes.Int64, nil
+	}
+	return nil, fmt.Errorf("unknown synthetic key kind %q: expected %s or %s", kind, metadata.SyntheticKeyUUID, metadata.SyntheticKeySnowflake)
+}
+
+// withSyntheticKey checks the synthetic key against schema and returns the
+// schema with its column, appended if it was missing
+func withSyntheticKey(schema *arrow.Schema, key *metadata.SyntheticKey) (*arrow.Schema, error) {
+	typ, err := syntheticKeyType(key.Kind)
+	if err != nil {
+		return nil, err
+	}
+	if key.Column == "" {
+		return nil, fmt.Errorf("synthetic key needs a column name")
+	}
+	if idx := schema.FieldIndices(key.Column); len(idx) > 0 {
+		if f := schema.Field(idx[0]); !arrow.TypeEqual(f.Type, typ) {
+			return nil, fmt.Errorf("synthetic key column %s is %s, %s keys need %s", key.Column, f.Type, key.Kind, typ)
+		}
+		return schema, nil
+	}
+	md := schema.Metadata()
+	return arrow.NewSchema(append(schema.Fields(), arrow.Field{Name: key.Column, Type: typ}), &md), nil
+}
+
+// fillSyntheticKey returns rec with keys generated for the synthetic key
+// column: all of it when rec lacks the column, else its null values. It
+// returns rec itself when there is nothing to fill.
+func (lb *Lockbox) fillSyntheticKey(mem memory.Allocator, rec arrow.Record) (arrow.Record, error) {
+	key := lb.SyntheticKey()
+	if key == nil {
+		return rec, nil
+	}
+	var existing arrow.Array
+	if idx := rec.Schema().FieldIndices(key.Column); len(idx) > 0 {
+		existing = rec.Column(idx[0])
+		if existing.NullN() == 0 {
+			return rec, nil
+		}
+	}
+
+	var col arrow.Array
+	switch key.Kind {
+	case metadata.SyntheticKeyUUID:
+		b := array.NewStringBuilder(mem)
+		defer b.Release()
+		old, _ := existing.(*array.String)
+		if existing != nil && old == nil {
+			return nil, fmt.Errorf("synthetic key column %s must be %s, got %s", key.Column, arrow.BinaryTypes.String, existing.DataType())
+		}
+		for row := 0; row < int(rec.NumRows()); row++ {
+			if old != nil && old.IsValid(row) {
+				b.Append(old.Value(row))
+				continue
+			}
+			id, err := newUUID()
+			if err != nil {
+				return nil, err
+			}
+			b.Append(id)
+		}
+		col = b.NewArray()
+	case metadata.SyntheticKeySnowflake:
+		b := array.NewInt64Builder(mem)
+		defer b.Release()
+		old, _ := existing.(*array.Int64)
+		if existing != nil && old == nil {
+			return nil, fmt.Errorf("synthetic key column %s must be %s, got %s", key.Column, arrow.PrimitiveTypes.Int64, existing.DataType())
+		}
+		for row := 0; row < int(rec.NumRows()); row++ {
+			if old != nil && old.IsValid(row) {
+				b.Append(old.Value(row))
+				continue
+			}
+			b.Append(snowflakes.next())
+		}
+		col = b.NewArray()
+	default:
+		return nil, fmt.Errorf("unknown synthetic key kind %q", key.Kind)
+	}
+	defer col.Release()
+	return withColumn(rec, key.Column, col), nil
+}
+
+// withColumn returns rec with the column name set to col, which has no
+// nulls: replaced if rec has it, appended otherwise
+func withColumn(rec arrow.Record, name string, col arrow.Array) arrow.Record {
+	fields := rec.Schema().Fields()
+	cols := rec.Columns()
+	if idx := rec.Schema().FieldIndices(name); len(idx) > 0 {
+		cols = append([]arrow.Array{}, cols...)
+		cols[idx[0]] = col
+		fields[idx[0]].Nullable = false
+	} else {
+		fields = append(fields, arrow.Field{Name: name, Type: col.DataType()})
+		cols = append(cols, col)
+	}
+	md := rec.Schema().Metadata()
+	return array.NewRecord(arrow.NewSchema(fields, &md), cols, rec.NumRows())
+}
+
+// snowflakeEpoch is the start of snowflake timestamps
+var snowflakeEpoch = time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// snowflakeGen generates ids of 41 bits of milliseconds since
+// snowflakeEpoch, a 10-bit node picked at random per process and a 12-bit
+// sequence, so ids increase within a process and rarely collide across
+// processes
+type snowflakeGen struct {
+	mu     sync.Mutex
+	node   int64
+	millis int64
+	seq    int64
+}
+
+var snowflakes = func() *snowflakeGen {
+	var b [2]byte
+	_, _ = rand.Read(b[:])
+	return &snowflakeGen{node: int64(binary.BigEndian.Uint16(b[:]) & 0x3ff)}
+}()
+
+func (g *snowflakeGen) next() int64 {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	now := time.Since(snowflakeEpoch).Milliseconds()
+	if now <= g.millis {
+		// The same millisecond, or the clock went back: count on from the
+		// last id
+		now = g.millis
+		g.seq = (g.seq + 1) & 0xfff
+		if g.seq == 0 {
+			now++
+		}
+	} else {
+		g.seq = 0
+	}
+	g.millis = now
+	return now<<22 | g.node<<12 | g.seq
+}
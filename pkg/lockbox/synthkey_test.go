@@ -0,0 +1,114 @@
+package lockbox
+
+import (
+	"context"
+	"path/filepath"
+	"regexp"
+	"testing"
+
+	"github.com/TFMV/lockbox/pkg/metadata"
+	"github.com/apache/arrow-go/v18/arrow"
+	"github.com/apache/arrow-go/v18/arrow/array"
+	"github.com/apache/arrow-go/v18/arrow/memory"
+)
+
+func TestSyntheticKey(t *testing.T) {
+	dir := t.TempDir()
+	password := "test_password_123"
+	ctx := context.Background()
+	input := arrow.NewSchema([]arrow.Field{{Name: "name", Type: arrow.BinaryTypes.String}}, nil)
+	names := func() arrow.Record {
+		b := array.NewRecordBuilder(memory.NewGoAllocator(), input)
+		defer b.Release()
+		b.Field(0).(*array.StringBuilder).AppendValues([]string{"ann", "bob", "cy"}, nil)
+		return b.NewRecord()
+	}
+
+	if _, err := Create(filepath.Join(dir, "bad.lbx"), input, WithPassword(password), WithSyntheticKey("name", metadata.SyntheticKeySnowflake)); err == nil {
+		t.Error("expected a string column to be refused for snowflake ids")
+	}
+	if _, err := Create(filepath.Join(dir, "bad.lbx"), input, WithPassword(password), WithSyntheticKey("id", "serial")); err == nil {
+		t.Error("expected an unknown kind to be refused")
+	}
+
+	path := filepath.Join(dir, "people.lbx")
+	lb, err := Create(path, input, WithPassword(password), WithSyntheticKey("id", metadata.SyntheticKeyUUID))
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	if f, ok := lb.Schema().FieldsByName("id"); !ok || f[0].Type.ID() != arrow.STRING {
+		t.Fatalf("expected the key column to be added to the schema, got %s", lb.Schema())
+	}
+	rec := names()
+	if err := lb.Write(ctx, rec, WithPassword(password)); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	rec.Release()
+
+	// Given keys are kept, nulls are filled
+	full := arrow.NewSchema([]arrow.Field{{Name: "name", Type: arrow.BinaryTypes.String}, {Name: "id", Type: arrow.BinaryTypes.String, Nullable: true}}, nil)
+	b := array.NewRecordBuilder(memory.NewGoAllocator(), full)
+	b.Field(0).(*array.StringBuilder).AppendValues([]string{"dee", "eve"}, nil)
+	b.Field(1).(*array.StringBuilder).AppendValues([]string{"given", ""}, []bool{true, false})
+	rec = b.NewRecord()
+	b.Release()
+	if err := lb.Write(ctx, rec, WithPassword(password)); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	rec.Release()
+	lb.Close()
+
+	lb, err = Open(path, WithPassword(password))
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer lb.Close()
+	if k := lb.SyntheticKey(); k == nil || k.Column != "id" || k.Kind != metadata.SyntheticKeyUUID {
+		t.Fatalf("synthetic key not recorded: %+v", k)
+	}
+	ix, err := lb.KeyIndex(ctx, "", WithPassword(password))
+	if err != nil {
+		t.Fatalf("key index: %v", err)
+	}
+	if ix.Column != "id" || ix.Len() != 5 || ix.Nulls != 0 || !ix.Contains("given") {
+		t.Fatalf("expected 5 distinct keys including the given one, got %d in %s", ix.Len(), ix.Column)
+	}
+	res, err := lb.Query(ctx, "SELECT id FROM data WHERE name = 'ann'", WithPassword(password))
+	if err != nil {
+		t.Fatalf("query: %v", err)
+	}
+	uuid := regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+	if id := res.Column(0).(*array.String).Value(0); !uuid.MatchString(id) {
+		t.Errorf("expected a version 4 UUID, got %q", id)
+	}
+	res.Release()
+
+	// Snowflake ids increase
+	path = filepath.Join(dir, "events.lbx")
+	sf, err := Create(path, input, WithPassword(password), WithSyntheticKey("id", metadata.SyntheticKeySnowflake))
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	defer sf.Close()
+	for i := 0; i < 2; i++ {
+		rec := names()
+		if err := sf.Write(ctx, rec, WithPassword(password)); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+		rec.Release()
+	}
+	res, err = sf.Query(ctx, "SELECT id FROM data", WithPassword(password))
+	if err != nil {
+		t.Fatalf("query: %v", err)
+	}
+	defer res.Release()
+	ids := res.Column(0).(*array.Int64)
+	if ids.Len() != 6 {
+		t.Fatalf("expected 6 ids, got %d", ids.Len())
+	}
+	for i := 1; i < ids.Len(); i++ {
+		if ids.Value(i) <= ids.Value(i-1) {
+			t.Fatalf("expected increasing ids, got %v", ids)
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package lockbox
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// tagKeyPattern restricts tag keys so that they can be written as key=value
+// and used in catalog searches
+var tagKeyPattern = regexp.MustCompile(`^[A-Za-z0-9_./-]+$`)
+
+// SetTags adds tags to the lockbox, replacing the values of existing keys.
+// Tags and the description are stored in the clear so that catalogs can
+// index them without a password; do not put secrets in them.
+func (lb *Lockbox) SetTags(tags map[string]string) error {
+	if len(tags) == 0 {
+		return nil
+	}
+	for key := range tags {
+		if !tagKeyPattern.MatchString(key) {
+			return fmt.Errorf("invalid tag key %q", key)
+		}
+	}
+
+	meta := lb.file.Metadata()
+	if meta.Tags == nil {
+		meta.Tags = make(map[string]string, len(tags))
+	}
+	keys := make([]string, 0, len(tags))
+	for key, value := range tags {
+		meta.Tags[key] = value
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	meta.LogAccess("system", "set-tags", strings.Join(keys, ","), true, "")
+	return lb.file.SaveMetadata()
+}
+
+// RemoveTags deletes tags from the lockbox
+func (lb *Lockbox) RemoveTags(keys ...string) error {
+	meta := lb.file.Metadata()
+	for _, key := range keys {
+		if _, ok := meta.Tags[key]; !ok {
+			return fmt.Errorf("tag %s not found", key)
+		}
+	}
+	for _, key := range keys {
+		delete(meta.Tags, key)
+	}
+	if len(meta.Tags) == 0 {
+		meta.Tags = nil
+	}
+	meta.LogAccess("system", "remove-tags", strings.Join(keys, ","), true, "")
+	return lb.file.SaveMetadata()
+}
+
+// Tags returns the tags of the lockbox
+func (lb *Lockbox) Tags() map[string]string {
+	tags := make(map[string]string, len(lb.file.Metadata().Tags))
+	for key, value := range lb.file.Metadata().Tags {
+		tags[key] = value
+	}
+	return tags
+}
+
+// SetDescription sets the free-form description of the lockbox; an empty
+// description removes it
+func (lb *Lockbox) SetDescription(description string) error {
+	meta := lb.file.Metadata()
+	meta.Description = strings.TrimSpace(description)
+	meta.LogAccess("system", "set-description", "", true, "")
+	return lb.file.SaveMetadata()
+}
+
+// Description returns the description of the lockbox
+func (lb *Lockbox) Description() string {
+	return lb.file.Metadata().Description
+}
+
+// ParseTags parses key=value arguments into tags. A key without "=" gets an
+// empty value.
+func ParseTags(args []string) (map[string]string, error) {
+	tags := make(map[string]string, len(args))
+	for _, arg := range args {
+		key, value, _ := strings.Cut(arg, "=")
+		key = strings.TrimSpace(key)
+		if !tagKeyPattern.MatchString(key) {
+			return nil, fmt.Errorf("invalid tag %q, expected key=value", arg)
+		}
+		tags[key] = strings.TrimSpace(value)
+	}
+	return tags, nil
+}
@@ -0,0 +1,68 @@
+package lockbox
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+)
+
+func TestTags(t *testing.T) {
+	dir := t.TempDir()
+	tmpFile := filepath.Join(dir, "tags.lbx")
+	password := "test_password_123"
+	lb := newQueryTestLockbox(t, tmpFile, password)
+
+	tags, err := ParseTags([]string{"env=prod", "owner=data-eng", "pii"})
+	if err != nil {
+		t.Fatalf("parse tags: %v", err)
+	}
+	if err := lb.SetTags(tags); err != nil {
+		t.Fatalf("set tags: %v", err)
+	}
+	if err := lb.SetDescription("  Payroll exports from HR "); err != nil {
+		t.Fatalf("set description: %v", err)
+	}
+	if err := lb.RemoveTags("owner"); err != nil {
+		t.Fatalf("remove tags: %v", err)
+	}
+	if err := lb.RemoveTags("missing"); err == nil {
+		t.Fatal("expected error removing a missing tag")
+	}
+	if err := lb.SetTags(map[string]string{"bad key": "x"}); err == nil {
+		t.Fatal("expected error for invalid tag key")
+	}
+	lb.Close()
+
+	// Tags and the description are readable without a password
+	info, err := ReadInfo(tmpFile)
+	if err != nil {
+		t.Fatalf("read info: %v", err)
+	}
+	if info.Description != "Payroll exports from HR" {
+		t.Errorf("unexpected description %q", info.Description)
+	}
+	if len(info.Tags) != 2 || info.Tags["env"] != "prod" || info.Tags["pii"] != "" {
+		t.Errorf("unexpected tags %v", info.Tags)
+	}
+
+	c, err := IndexCatalog(context.Background(), dir)
+	if err != nil {
+		t.Fatalf("index: %v", err)
+	}
+	for query, want := range map[string]int{
+		"tag:env=prod":           1,
+		"tag:owner":              0,
+		"tag:pii column:score":   1,
+		"payroll":                1,
+		"description:*exports*":  1,
+		"description:*invoices*": 0,
+	} {
+		matches, err := c.Search(query)
+		if err != nil {
+			t.Fatalf("search %q: %v", query, err)
+		}
+		if len(matches) != want {
+			t.Errorf("search %q: expected %d matches, got %d", query, want, len(matches))
+		}
+	}
+}
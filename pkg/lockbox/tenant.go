@@ -0,0 +1,305 @@
+package lockbox
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/TFMV/lockbox/pkg/metadata"
+	"github.com/apache/arrow-go/v18/arrow/array"
+	"github.com/rs/zerolog/log"
+)
+
+// DefaultQuotaWindow is the quota window of tenants whose Quota sets none
+const DefaultQuotaWindow = time.Minute
+
+// AllUsers in the Users of a tenant lets every user that logs in read its
+// tables
+const AllUsers = "*"
+
+// ErrQuotaExceeded is returned for requests of a tenant that used up its
+// quota for the current window
+var ErrQuotaExceeded = errors.New("quota exceeded")
+
+// KeyProvider supplies the passwords of the lockbox files of a tenant
+type KeyProvider interface {
+	Password(path string) (string, error)
+}
+
+// KeyProviderFunc adapts a function to a KeyProvider
+type KeyProviderFunc func(path string) (string, error)
+
+// Password calls f
+func (f KeyProviderFunc) Password(path string) (string, error) {
+	return f(path)
+}
+
+// StaticPassword is a KeyProvider with one password for every file
+type StaticPassword string
+
+// Password returns p
+func (p StaticPassword) Password(string) (string, error) {
+	return string(p), nil
+}
+
+// Quota bounds the requests a tenant's users make and the rows they read
+// per window. Zero limits are unlimited.
+type Quota struct {
+	Window   time.Duration `json:"window,omitempty"`
+	Requests int           `json:"requests,omitempty"`
+	Rows     int64         `json:"rows,omitempty"`
+}
+
+// TenantUsage is what a tenant used in its current quota window
+type TenantUsage struct {
+	WindowStart time.Time `json:"windowStart"`
+	Requests    int       `json:"requests"`
+	Rows        int64     `json:"rows"`
+}
+
+// Tenant is a team hosted in a served directory: the tables under Prefix,
+// unlocked with the passwords of Keys, readable only by Users, bounded by
+// Quota. A tenant without Users is readable by no one; AllUsers opens it
+// to every user that logs in. Policy rules apply to its tables on top of
+// the access policy of each file, so a tenant can filter rows and mask
+// columns for its users without changing the files. Requests on its
+// tables are recorded in the audit trail of each file and, when Audit is
+// set, as JSON lines in Audit.
+type Tenant struct {
+	Name   string
+	Prefix string
+	Users  []string
+	Keys   KeyProvider
+	Quota  Quota
+	Policy []metadata.PolicyRule
+	Audit  io.Writer
+
+	mu    sync.Mutex
+	usage TenantUsage
+}
+
+// tenantAuditEntry is a line of a tenant's audit stream
+type tenantAuditEntry struct {
+	Timestamp time.Time `json:"timestamp"`
+	Tenant    string    `json:"tenant"`
+	Table     string    `json:"table"`
+	Principal string    `json:"principal"`
+	Action    string    `json:"action"`
+	Resource  string    `json:"resource"`
+	Success   bool      `json:"success"`
+	Rows      int64     `json:"rows,omitempty"`
+	Details   string    `json:"details,omitempty"`
+}
+
+// Usage returns what the tenant used in its current quota window
+func (t *Tenant) Usage() TenantUsage {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.usage
+}
+
+// member reports whether user may read the tenant's tables
+func (t *Tenant) member(user string) bool {
+	for _, u := range t.Users {
+		if u == user || u == AllUsers {
+			return true
+		}
+	}
+	return false
+}
+
+// window starts a new quota window when the current one is over; t.mu
+// must be held
+func (t *Tenant) window(now time.Time) {
+	w := t.Quota.Window
+	if w <= 0 {
+		w = DefaultQuotaWindow
+	}
+	if now.Sub(t.usage.WindowStart) >= w {
+		t.usage = TenantUsage{WindowStart: now}
+	}
+}
+
+// admit reserves a request of the tenant's quota, so concurrent requests
+// can't all pass before any is counted. It fails with ErrQuotaExceeded
+// once the tenant used up its quota.
+func (t *Tenant) admit(now time.Time) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.window(now)
+	if q := t.Quota.Requests; q > 0 && t.usage.Requests >= q {
+		return fmt.Errorf("%w: tenant %s made %d requests in %s", ErrQuotaExceeded, t.Name, t.usage.Requests, now.Sub(t.usage.WindowStart).Round(time.Second))
+	}
+	if q := t.Quota.Rows; q > 0 && t.usage.Rows >= q {
+		return fmt.Errorf("%w: tenant %s read %d rows in %s", ErrQuotaExceeded, t.Name, t.usage.Rows, now.Sub(t.usage.WindowStart).Round(time.Second))
+	}
+	t.usage.Requests++
+	return nil
+}
+
+// take charges rows about to be served to the tenant's quota. It fails
+// with ErrQuotaExceeded, charging nothing, when they would exceed it.
+func (t *Tenant) take(now time.Time, rows int64) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.window(now)
+	if q := t.Quota.Rows; q > 0 && t.usage.Rows+rows > q {
+		return fmt.Errorf("%w: tenant %s may read %d more rows in this window", ErrQuotaExceeded, t.Name, q-t.usage.Rows)
+	}
+	t.usage.Rows += rows
+	return nil
+}
+
+// quotaReader charges each batch to the quota of a tenant before it is
+// served, and stops with ErrQuotaExceeded at the first batch that would
+// exceed it
+type quotaReader struct {
+	array.RecordReader
+	tenant *Tenant
+	now    func() time.Time
+	err    error
+}
+
+// Next charges the next batch
+func (r *quotaReader) Next() bool {
+	if r.err != nil || !r.RecordReader.Next() {
+		return false
+	}
+	if err := r.tenant.take(r.now(), r.Record().NumRows()); err != nil {
+		r.err = err
+		return false
+	}
+	return true
+}
+
+// Err returns the error that stopped the reader
+func (r *quotaReader) Err() error {
+	if r.err != nil {
+		return r.err
+	}
+	return r.RecordReader.Err()
+}
+
+// applyPolicy adds the rules of the tenant's policy to the access policy
+// lb reads with. They are not saved to the file.
+func (t *Tenant) applyPolicy(lb *Lockbox) error {
+	if len(t.Policy) == 0 {
+		return nil
+	}
+	meta := lb.file.Metadata()
+	rules := make([]metadata.PolicyRule, 0, len(t.Policy))
+	for _, rule := range t.Policy {
+		rule.Name = strings.ToLower(strings.TrimSpace(rule.Name))
+		if err := checkPolicyRule(rule, meta.Schema); err != nil {
+			return fmt.Errorf("tenant %s: %w", t.Name, err)
+		}
+		rules = append(rules, rule)
+	}
+	if meta.AccessPolicy == nil {
+		meta.AccessPolicy = &metadata.AccessPolicy{Version: 1}
+	}
+	meta.AccessPolicy.Rules = append(meta.AccessPolicy.Rules, rules...)
+	return nil
+}
+
+// record writes an entry to the tenant's audit stream
+func (t *Tenant) record(e tenantAuditEntry) {
+	if t.Audit == nil {
+		return
+	}
+	e.Tenant = t.Name
+	line, err := json.Marshal(e)
+	if err != nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if _, err := t.Audit.Write(append(line, '\n')); err != nil {
+		log.Warn().Err(err).Str("tenant", t.Name).Msg("Failed to write tenant audit stream")
+	}
+}
+
+// AddTenant registers a tenant for the files of AddDirectory. Prefixes
+// end in a slash, so "risk" holds "risk/trades" but not "riskier/trades";
+// when prefixes nest, files belong to the tenant with the longest one.
+func (s *ServedTables) AddTenant(t *Tenant) error {
+	if t.Name == "" {
+		return fmt.Errorf("tenant name is required")
+	}
+	if t.Keys == nil {
+		return fmt.Errorf("tenant %s has no key provider", t.Name)
+	}
+	t.Prefix = strings.Trim(filepath.ToSlash(t.Prefix), "/")
+	if t.Prefix != "" {
+		t.Prefix += "/"
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, other := range s.tenants {
+		if other.Name == t.Name {
+			return fmt.Errorf("tenant %s is already registered", t.Name)
+		}
+		if other.Prefix == t.Prefix {
+			return fmt.Errorf("tenants %s and %s share the prefix %q", other.Name, t.Name, t.Prefix)
+		}
+	}
+	s.tenants = append(s.tenants, t)
+	return nil
+}
+
+// tenantOf returns the tenant with the longest prefix of name, or nil
+func (s *ServedTables) tenantOf(name string) *Tenant {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var best *Tenant
+	for _, t := range s.tenants {
+		if strings.HasPrefix(name, t.Prefix) && (best == nil || len(t.Prefix) > len(best.Prefix)) {
+			best = t
+		}
+	}
+	return best
+}
+
+// AddDirectory serves every lockbox file under root, named by its path
+// relative to root without the extension, e.g. "risk/trades" for
+// root/risk/trades.lbx. Each file belongs to a tenant registered with
+// AddTenant and is unlocked with the password of its key provider; files
+// of no tenant are skipped. It returns the number of tables added and
+// fails on the first file whose password does not decrypt it.
+func (s *ServedTables) AddDirectory(root string) (int, error) {
+	added := 0
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || filepath.Ext(path) != ".lbx" {
+			return nil
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		name := strings.TrimSuffix(filepath.ToSlash(rel), ".lbx")
+		tenant := s.tenantOf(name)
+		if tenant == nil {
+			log.Warn().Str("file", path).Msg("Not serving file outside every tenant")
+			return nil
+		}
+		password, err := tenant.Keys.Password(path)
+		if err != nil {
+			return fmt.Errorf("no password for %s of tenant %s: %w", path, tenant.Name, err)
+		}
+		if err := s.addTable(name, path, password, tenant); err != nil {
+			return fmt.Errorf("failed to serve %s: %w", path, err)
+		}
+		added++
+		return nil
+	})
+	return added, err
+}
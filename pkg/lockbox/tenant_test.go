@@ -0,0 +1,182 @@
+package lockbox
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/TFMV/lockbox/pkg/metadata"
+)
+
+func TestTenants(t *testing.T) {
+	root := t.TempDir()
+	for _, dir := range []string{"risk", "ops", "misc"} {
+		if err := os.Mkdir(filepath.Join(root, dir), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	newQueryTestLockbox(t, filepath.Join(root, "risk", "trades.lbx"), "risk_password_123").Close()
+	newQueryTestLockbox(t, filepath.Join(root, "ops", "jobs.lbx"), "ops_password_456").Close()
+	newQueryTestLockbox(t, filepath.Join(root, "misc", "stray.lbx"), "misc_password_789").Close()
+
+	var riskAudit bytes.Buffer
+	tables := NewServedTables(time.Minute)
+	risk := &Tenant{Name: "risk", Prefix: "risk", Users: []string{"ana"}, Keys: StaticPassword("risk_password_123"),
+		Quota: Quota{Window: time.Hour, Requests: 2}, Audit: &riskAudit}
+	ops := &Tenant{Name: "ops", Prefix: "ops/", Users: []string{"raj"}, Keys: StaticPassword("ops_password_456"),
+		Policy: []metadata.PolicyRule{{Name: "mask_names", Masks: map[string]string{"name": "'***'"}}}}
+	for _, tenant := range []*Tenant{risk, ops} {
+		if err := tables.AddTenant(tenant); err != nil {
+			t.Fatalf("add tenant: %v", err)
+		}
+	}
+	if err := tables.AddTenant(&Tenant{Name: "dup", Prefix: "risk/", Keys: StaticPassword("x")}); err == nil {
+		t.Errorf("expected a second tenant with the same prefix to be refused")
+	}
+	n, err := tables.AddDirectory(root)
+	if err != nil {
+		t.Fatalf("add directory: %v", err)
+	}
+	if n != 2 || strings.Join(tables.Names(), ",") != "ops/jobs,risk/trades" {
+		t.Fatalf("expected the two tenant tables, got %d %v", n, tables.Names())
+	}
+	defer tables.Close()
+
+	// A tenant's key provider must unlock its files
+	wrong := NewServedTables(time.Minute)
+	wrong.AddTenant(&Tenant{Name: "risk", Prefix: "risk/", Keys: StaticPassword("ops_password_456")})
+	if _, err := wrong.AddDirectory(root); err == nil {
+		t.Errorf("expected the wrong tenant password to be refused")
+	}
+	wrong.Close()
+
+	tables.AddUser("ana", "ana-pw")
+	tables.AddUser("raj", "raj-pw")
+	srv := httptest.NewServer(NewHTTPServer(tables).Handler())
+	defer srv.Close()
+	get := func(user, target string) *http.Response {
+		t.Helper()
+		req, _ := http.NewRequest("GET", srv.URL+target, nil)
+		req.SetBasicAuth(user, user+"-pw")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("GET %s: %v", target, err)
+		}
+		resp.Body.Close()
+		return resp
+	}
+
+	req, _ := http.NewRequest("GET", srv.URL+"/tables", nil)
+	req.SetBasicAuth("raj", "raj-pw")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var list struct{ Tables []string }
+	json.NewDecoder(resp.Body).Decode(&list)
+	resp.Body.Close()
+	if strings.Join(list.Tables, ",") != "ops/jobs" {
+		t.Errorf("expected raj to see only the ops table, got %v", list.Tables)
+	}
+
+	if resp := get("raj", "/query?table=risk/trades&sql=SELECT+id+FROM+data"); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("expected another tenant's table to be not found, got %s", resp.Status)
+	}
+	if resp := get("ana", "/query?sql=SELECT+id+FROM+data"); resp.StatusCode != http.StatusOK {
+		t.Errorf("expected ana's only table to be queried, got %s", resp.Status)
+	}
+	if resp := get("ana", "/info?table=risk/trades"); resp.StatusCode != http.StatusOK {
+		t.Errorf("expected info, got %s", resp.Status)
+	}
+	if resp := get("ana", "/info?table=risk/trades"); resp.StatusCode != http.StatusTooManyRequests {
+		t.Errorf("expected the third request to exceed the quota, got %s", resp.Status)
+	}
+	if resp := get("raj", "/info?table=ops/jobs"); resp.StatusCode != http.StatusOK {
+		t.Errorf("expected other tenants to keep their quota, got %s", resp.Status)
+	}
+
+	// The tenant's policy applies on top of the file's
+	req, _ = http.NewRequest("GET", srv.URL+"/query?table=ops/jobs&sql=SELECT+name+FROM+data", nil)
+	req.SetBasicAuth("raj", "raj-pw")
+	if resp, err = http.DefaultClient.Do(req); err != nil {
+		t.Fatal(err)
+	}
+	var rows []map[string]interface{}
+	json.NewDecoder(resp.Body).Decode(&rows)
+	resp.Body.Close()
+	if len(rows) != 3 || rows[0]["name"] != "***" {
+		t.Errorf("expected masked names, got %v", rows)
+	}
+	if u := risk.Usage(); u.Requests != 2 || u.Rows != 3 {
+		t.Errorf("expected 2 requests and 3 rows charged, got %+v", u)
+	}
+
+	var actions []string
+	for _, line := range strings.Split(strings.TrimSpace(riskAudit.String()), "\n") {
+		var e tenantAuditEntry
+		if err := json.Unmarshal([]byte(line), &e); err != nil {
+			t.Fatalf("audit line %q: %v", line, err)
+		}
+		if e.Tenant != "risk" || e.Table != "risk/trades" {
+			t.Errorf("unexpected audit entry %+v", e)
+		}
+		actions = append(actions, e.Principal+" "+e.Action)
+	}
+	want := []string{"raj http-query", "ana http-query", "ana http-info", "ana http-info"}
+	if strings.Join(actions, ",") != strings.Join(want, ",") {
+		t.Errorf("expected tenant audit entries %v, got %v", want, actions)
+	}
+}
+
+func TestTenantMembers(t *testing.T) {
+	closed := &Tenant{Name: "closed"}
+	if closed.member("ana") {
+		t.Errorf("expected a tenant without users to be closed")
+	}
+	open := &Tenant{Name: "open", Users: []string{AllUsers}}
+	if !open.member("ana") {
+		t.Errorf("expected %q to admit every user", AllUsers)
+	}
+}
+
+// Concurrent requests can't all pass admit, and rows are charged before
+// they are served
+func TestTenantQuotaReservation(t *testing.T) {
+	now := time.Now()
+	tenant := &Tenant{Name: "risk", Quota: Quota{Window: time.Hour, Requests: 5, Rows: 10}}
+
+	var admitted atomic.Int32
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if tenant.admit(now) == nil {
+				admitted.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+	if admitted.Load() != 5 {
+		t.Errorf("expected 5 requests admitted, got %d", admitted.Load())
+	}
+
+	if err := tenant.take(now, 8); err != nil {
+		t.Fatalf("take: %v", err)
+	}
+	if err := tenant.take(now, 3); !errors.Is(err, ErrQuotaExceeded) {
+		t.Errorf("expected rows beyond the quota to be refused, got %v", err)
+	}
+	if u := tenant.Usage(); u.Requests != 5 || u.Rows != 8 {
+		t.Errorf("expected 5 requests and 8 rows charged, got %+v", u)
+	}
+}
@@ -0,0 +1,157 @@
+package lockbox
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/apache/arrow-go/v18/arrow"
+	"github.com/apache/arrow-go/v18/arrow/array"
+	"github.com/apache/arrow-go/v18/arrow/memory"
+)
+
+// splitUnion splits a query into its SELECT statements. The returned flags
+// report, for each UNION operator, whether it was UNION ALL. UNION keywords
+// nested inside parentheses belong to subqueries and are left in place.
+func splitUnion(q string) ([]string, []bool, error) {
+	toks, err := lexQuery(q)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid query: %w", err)
+	}
+
+	var selects []string
+	var all []bool
+	start, depth := 0, 0
+	for i := 0; i < len(toks); i++ {
+		t := toks[i]
+		switch {
+		case t.Kind == tokLParen:
+			depth++
+		case t.Kind == tokRParen:
+			depth--
+		case depth == 0 && t.isKeyword("UNION"):
+			part := strings.TrimSpace(q[start:t.Pos])
+			if part == "" {
+				return nil, nil, fmt.Errorf("invalid query: UNION without preceding SELECT")
+			}
+			selects = append(selects, part)
+
+			isAll := i+1 < len(toks) && toks[i+1].isKeyword("ALL")
+			if isAll {
+				i++
+			}
+			all = append(all, isAll)
+			start = len(q)
+			if i+1 < len(toks) {
+				start = toks[i+1].Pos
+			}
+		}
+	}
+	part := strings.TrimSpace(q[start:])
+	if part == "" {
+		return nil, nil, fmt.Errorf("invalid query: UNION without following SELECT")
+	}
+	selects = append(selects, part)
+
+	return selects, all, nil
+}
+
+// unionRecords appends the rows of b to a. When distinct is set duplicate
+// rows are removed from the combined result, as with SQL UNION.
+// Column names are taken from a; column types must match positionally.
+func unionRecords(mem memory.Allocator, a, b arrow.Record, distinct bool) (arrow.Record, error) {
+	if a.NumCols() != b.NumCols() {
+		return nil, fmt.Errorf("UNION queries must select the same number of columns (%d vs %d)", a.NumCols(), b.NumCols())
+	}
+
+	schema := a.Schema()
+
+	cols := make([]arrow.Array, a.NumCols())
+	for i := range cols {
+		if !arrow.TypeEqual(a.Column(i).DataType(), b.Column(i).DataType()) {
+			releaseArrays(cols)
+			return nil, fmt.Errorf("UNION column %s has mismatched types: %s vs %s",
+				schema.Field(i).Name, a.Column(i).DataType(), b.Column(i).DataType())
+		}
+		out, err := array.Concatenate([]arrow.Array{a.Column(i), b.Column(i)}, mem)
+		if err != nil {
+			releaseArrays(cols)
+			return nil, fmt.Errorf("failed to combine column %s: %w", schema.Field(i).Name, err)
+		}
+		cols[i] = out
+	}
+
+	combined := array.NewRecord(schema, cols, a.NumRows()+b.NumRows())
+	releaseArrays(cols)
+
+	if !distinct {
+		return combined, nil
+	}
+
+	deduped := distinctRows(mem, combined)
+	combined.Release()
+	return deduped, nil
+}
+
+// distinctRows returns a record containing the first occurrence of each row
+func distinctRows(mem memory.Allocator, rec arrow.Record) arrow.Record {
+	seen := make(map[string]struct{}, rec.NumRows())
+	var keep []int
+	for row := 0; row < int(rec.NumRows()); row++ {
+		key := rowKey(rec, row)
+		if _, ok := seen[key]; ok {
+			continue
+		}
+		seen[key] = struct{}{}
+		keep = append(keep, row)
+	}
+	return takeRows(mem, rec, keep)
+}
+
+// rowKey builds a comparable key from all column values of a row
+func rowKey(rec arrow.Record, row int) string {
+	var sb strings.Builder
+	for _, col := range rec.Columns() {
+		if col.IsNull(row) {
+			sb.WriteString("\x00N")
+		} else {
+			sb.WriteString("\x00V")
+			sb.WriteString(col.ValueStr(row))
+		}
+	}
+	return sb.String()
+}
+
+// takeRows builds a new record with the given rows of rec, in order
+func takeRows(mem memory.Allocator, rec arrow.Record, rows []int) arrow.Record {
+	schema := rec.Schema()
+
+	builders := make([]array.Builder, rec.NumCols())
+	for i, field := range schema.Fields() {
+		builders[i] = newBuilder(mem, field)
+	}
+	for _, row := range rows {
+		for i, col := range rec.Columns() {
+			appendValue(builders[i], col, row)
+		}
+	}
+
+	arrays := make([]arrow.Array, len(builders))
+	fields := make([]arrow.Field, len(builders))
+	for i, b := range builders {
+		arrays[i] = b.NewArray()
+		fields[i] = arrow.Field{Name: schema.Field(i).Name, Type: arrays[i].DataType(), Nullable: schema.Field(i).Nullable}
+		b.Release()
+	}
+
+	out := array.NewRecord(arrow.NewSchema(fields, nil), arrays, int64(len(rows)))
+	releaseArrays(arrays)
+	return out
+}
+
+func releaseArrays(arrays []arrow.Array) {
+	for _, arr := range arrays {
+		if arr != nil {
+			arr.Release()
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package lockbox
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/apache/arrow-go/v18/arrow/array"
+	"github.com/apache/arrow-go/v18/arrow/memory"
+)
+
+func TestQueryUnion(t *testing.T) {
+	tmpFile := "/tmp/test_lockbox_union.lbx"
+	defer os.Remove(tmpFile)
+
+	password := "test_password_123"
+	lb := newQueryTestLockbox(t, tmpFile, password)
+	defer lb.Close()
+
+	ctx := context.Background()
+
+	all, err := lb.Query(ctx, "SELECT name FROM data WHERE score > 20 UNION ALL SELECT name FROM data WHERE score < 40", WithPassword(password))
+	if err != nil {
+		t.Fatalf("union all error: %v", err)
+	}
+	defer all.Release()
+	if all.NumRows() != 4 {
+		t.Fatalf("expected 4 rows from UNION ALL, got %d", all.NumRows())
+	}
+
+	distinct, err := lb.Query(ctx, "SELECT name FROM data WHERE score > 20 UNION SELECT name FROM data WHERE score < 40", WithPassword(password))
+	if err != nil {
+		t.Fatalf("union error: %v", err)
+	}
+	defer distinct.Release()
+	if distinct.NumRows() != 3 {
+		t.Fatalf("expected 3 rows from UNION, got %d", distinct.NumRows())
+	}
+
+	if _, err := lb.Query(ctx, "SELECT name FROM data UNION SELECT id FROM data", WithPassword(password)); err == nil {
+		t.Fatalf("expected type mismatch error")
+	}
+}
+
+func TestQueryUnionAsOf(t *testing.T) {
+	password := "test_password_123"
+	lb := newQueryTestLockbox(t, filepath.Join(t.TempDir(), "asof.lbx"), password)
+	defer lb.Close()
+	ctx := context.Background()
+
+	b := array.NewRecordBuilder(memory.NewGoAllocator(), lb.file.Metadata().Schema)
+	b.Field(0).(*array.Int64Builder).AppendValues([]int64{4, 5}, nil)
+	b.Field(1).(*array.StringBuilder).AppendValues([]string{"dave", "erin"}, nil)
+	b.Field(2).(*array.Float64Builder).AppendValues([]float64{70, 80}, nil)
+	rec := b.NewRecord()
+	b.Release()
+	defer rec.Release()
+	if err := lb.Write(ctx, rec, WithPassword(password)); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+
+	counts := func(q string, opts ...Option) []int64 {
+		t.Helper()
+		res, err := lb.Query(ctx, q, append(opts, WithPassword(password))...)
+		if err != nil {
+			t.Fatalf("%s: %v", q, err)
+		}
+		defer res.Release()
+		var out []int64
+		for i := 0; i < int(res.NumRows()); i++ {
+			out = append(out, res.Column(0).(*array.Int64).Value(i))
+		}
+		return out
+	}
+
+	// Compare the first commit with the latest
+	got := counts("SELECT COUNT(*) FROM data AS OF SNAPSHOT 1 UNION ALL SELECT COUNT(*) FROM data")
+	if len(got) != 2 || got[0] != 3 || got[1] != 5 {
+		t.Errorf("expected counts 3 and 5, got %v", got)
+	}
+	got = counts("SELECT id FROM data UNION SELECT id FROM data AS OF SNAPSHOT 1 TABLESAMPLE 100 PERCENT WHERE score > 20")
+	if len(got) != 5 {
+		t.Errorf("expected the 5 distinct ids, got %v", got)
+	}
+
+	// AS OF a time reads the last snapshot committed by then
+	yesterday := time.Now().Add(-24 * time.Hour).Format(time.RFC3339)
+	if _, err := lb.Query(ctx, "SELECT id FROM data AS OF '"+yesterday+"'", WithPassword(password)); err == nil {
+		t.Error("expected no snapshot before the file was created")
+	}
+	tomorrow := time.Now().Add(24 * time.Hour).Format(time.DateOnly)
+	if got := counts("SELECT id FROM data AS OF '" + tomorrow + "'"); len(got) != 5 {
+		t.Errorf("expected every row as of tomorrow, got %v", got)
+	}
+
+	// WithAsOfSnapshot applies to SELECTs without their own clause
+	if got := counts("SELECT id FROM data", WithAsOfSnapshot(1)); len(got) != 3 {
+		t.Errorf("expected the 3 rows of snapshot 1, got %v", got)
+	}
+	if got := counts("SELECT id FROM data AS OF SNAPSHOT 2", WithAsOfSnapshot(1)); len(got) != 5 {
+		t.Errorf("expected AS OF to override WithAsOfSnapshot, got %v", got)
+	}
+
+	for _, q := range []string{
+		"SELECT id FROM data AS OF SNAPSHOT 9",
+		"SELECT id FROM data AS OF 'yesterday'",
+		"SELECT id FROM data AS 1",
+	} {
+		if _, err := lb.Query(ctx, q, WithPassword(password)); err == nil {
+			t.Errorf("expected error for %q", q)
+		}
+	}
+}
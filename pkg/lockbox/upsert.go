@@ -0,0 +1,113 @@
+package lockbox
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/TFMV/lockbox/pkg/metadata"
+	"github.com/apache/arrow-go/v18/arrow"
+	"github.com/rs/zerolog/log"
+)
+
+// UpsertResult counts the stored rows an Upsert replaced and the rows of
+// the record whose key was new
+type UpsertResult struct {
+	Replaced int64 `json:"replaced"`
+	Inserted int64 `json:"inserted"`
+}
+
+// Upsert writes record keyed on keyColumn: stored rows whose key is in the
+// record are replaced by the record's rows and the other rows of the record
+// are added, so a lockbox can keep the latest version of each key. Replaced
+// rows are deleted with tombstones as Delete deletes them and the record is
+// appended, both in one commit taking the options of Write; Compact removes
+// the old versions. Keys must be neither null nor repeated in the record.
+// The caller keeps ownership of record.
+func (lb *Lockbox) Upsert(ctx context.Context, record arrow.Record, keyColumn string, opts ...Option) (*UpsertResult, error) {
+	options := &Options{}
+	for _, opt := range opts {
+		opt(options)
+	}
+	if options.Password == "" {
+		return nil, fmt.Errorf("password is required for writing")
+	}
+	if _, err := lb.checkPolicy(options.Principal, ActionWrite); err != nil {
+		return nil, err
+	}
+
+	meta := lb.file.Metadata()
+	stored, _ := meta.Schema.FieldsByName(keyColumn)
+	if len(stored) == 0 {
+		return nil, fmt.Errorf("key column %s not found", keyColumn)
+	}
+	idx := record.Schema().FieldIndices(keyColumn)
+	if len(idx) == 0 {
+		return nil, fmt.Errorf("record has no key column %s", keyColumn)
+	}
+	if key := record.Column(idx[0]); !arrow.TypeEqual(key.DataType(), stored[0].Type) {
+		return nil, fmt.Errorf("key column %s has type %s, got %s", keyColumn, stored[0].Type, key.DataType())
+	}
+	keys := make(map[string]int, record.NumRows())
+	for row := 0; row < int(record.NumRows()); row++ {
+		if record.Column(idx[0]).IsNull(row) {
+			return nil, fmt.Errorf("key %s is null at row %d", keyColumn, row)
+		}
+		key := rowKeyOf(record, idx, row)
+		if first, ok := keys[key]; ok {
+			return nil, fmt.Errorf("duplicate key %s at rows %d and %d", record.Column(idx[0]).ValueStr(row), first, row)
+		}
+		keys[key] = row
+	}
+
+	qe, err := lb.newQueryExec(ctx, options)
+	if err != nil {
+		return nil, err
+	}
+	// Rows the policy hides from the principal could not be replaced
+	if qe.policy != nil {
+		return nil, fmt.Errorf("upsert needs unfiltered, unmasked read access for %s", qe.policy.principal)
+	}
+	replace := make(map[int][]int)
+	found := make(map[string]bool)
+	for n := 0; n < meta.NumRowGroups(); n++ {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		rec, err := qe.reader.ReadRowGroup(n, []string{keyColumn})
+		if err != nil {
+			return nil, fmt.Errorf("failed to read row group %d: %w", n, err)
+		}
+		matched := storedPositions(meta, n, int(rec.NumRows()), func(row int) bool {
+			key := rowKeyOf(rec, []int{0}, row)
+			if _, ok := keys[key]; !ok {
+				return false
+			}
+			found[key] = true
+			return true
+		})
+		rec.Release()
+		if len(matched) > 0 {
+			replace[n] = matched
+		}
+	}
+
+	// The tombstones are saved with the commit of the write, and dropped
+	// again when it fails
+	saved := make([]metadata.Tombstone, len(meta.Tombstones))
+	for i, t := range meta.Tombstones {
+		saved[i] = t
+		saved[i].Rows = append([]byte(nil), t.Rows...)
+	}
+	result := &UpsertResult{}
+	for n, rows := range replace {
+		result.Replaced += meta.MarkDeleted(n, rows)
+	}
+	result.Inserted = record.NumRows() - int64(len(found))
+	if err := lb.Write(ctx, record, append(opts, withOperation(OperationUpsert))...); err != nil {
+		meta.Tombstones = saved
+		return nil, err
+	}
+
+	log.Debug().Str("key", keyColumn).Int64("replaced", result.Replaced).Int64("inserted", result.Inserted).Msg("Upserted rows")
+	return result, nil
+}
@@ -0,0 +1,107 @@
+package lockbox
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/apache/arrow-go/v18/arrow"
+	"github.com/apache/arrow-go/v18/arrow/array"
+)
+
+func TestUpsert(t *testing.T) {
+	password := "test_password_123"
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "upsert.lbx")
+
+	schema := arrow.NewSchema([]arrow.Field{
+		{Name: "id", Type: arrow.PrimitiveTypes.Int64},
+		{Name: "name", Type: arrow.BinaryTypes.String, Nullable: true},
+		{Name: "score", Type: arrow.PrimitiveTypes.Float64, Nullable: true},
+	}, nil)
+	lb, err := Create(path, schema, WithPassword(password))
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	defer func() { lb.Close() }()
+
+	rec := diffTestRecord([]int64{1, 2, 3}, []string{"a", "b", "c"}, nil, []float64{1, 2, 3})
+	err = lb.Write(ctx, rec, WithPassword(password))
+	rec.Release()
+	if err != nil {
+		t.Fatalf("write: %v", err)
+	}
+
+	upsert := func(ids []int64, names []string, valid []bool) (*UpsertResult, error) {
+		rec := diffTestRecord(ids, names, valid, make([]float64, len(ids)))
+		defer rec.Release()
+		return lb.Upsert(ctx, rec, "id", WithPassword(password), WithMessage("sync"))
+	}
+	names := func() string {
+		t.Helper()
+		res, err := lb.Query(ctx, "SELECT id, name FROM data ORDER BY id", WithPassword(password))
+		if err != nil {
+			t.Fatalf("query: %v", err)
+		}
+		defer res.Release()
+		var out []string
+		for i := 0; i < int(res.NumRows()); i++ {
+			out = append(out, res.Column(0).ValueStr(i)+"="+res.Column(1).ValueStr(i))
+		}
+		return strings.Join(out, " ")
+	}
+
+	snaps := len(lb.Snapshots())
+	res, err := upsert([]int64{4, 2}, []string{"d", "B"}, nil)
+	if err != nil {
+		t.Fatalf("upsert: %v", err)
+	}
+	if res.Replaced != 1 || res.Inserted != 1 {
+		t.Errorf("expected 1 replaced and 1 inserted, got %+v", res)
+	}
+	if got := names(); got != "1=a 2=B 3=c 4=d" {
+		t.Errorf("unexpected rows %s", got)
+	}
+	if s := lb.Snapshots(); len(s) != snaps+1 || s[len(s)-1].Operation != OperationUpsert || s[len(s)-1].Message != "sync" {
+		t.Errorf("expected a single upsert commit, got %+v", s[snaps:])
+	}
+
+	// A replaced row is replaced again
+	if res, err = upsert([]int64{2}, []string{"bee"}, nil); err != nil || res.Replaced != 1 || res.Inserted != 0 {
+		t.Fatalf("upsert again: %+v, %v", res, err)
+	}
+	if got := names(); got != "1=a 2=bee 3=c 4=d" {
+		t.Errorf("unexpected rows %s", got)
+	}
+
+	if _, err := upsert([]int64{5, 5}, []string{"e", "f"}, nil); err == nil || !strings.Contains(err.Error(), "duplicate key 5") {
+		t.Errorf("expected a duplicate key to be refused, got %v", err)
+	}
+	rec = diffTestRecord([]int64{1}, []string{"x"}, nil, []float64{0})
+	if _, err := lb.Upsert(ctx, rec, "name", WithPassword(password)); err != nil {
+		t.Errorf("upsert on name: %v", err)
+	}
+	if _, err := lb.Upsert(ctx, rec, "missing", WithPassword(password)); err == nil {
+		t.Errorf("expected an unknown key column to be refused")
+	}
+	rec.Release()
+	if got := names(); got != "1=a 1=x 2=bee 3=c 4=d" {
+		t.Errorf("expected a new row for name x, got %s", got)
+	}
+
+	if n, err := lb.Compact(ctx, WithPassword(password)); err != nil || n != 2 {
+		t.Fatalf("compact: %d, %v", n, err)
+	}
+	if got := names(); got != "1=a 1=x 2=bee 3=c 4=d" {
+		t.Errorf("unexpected rows after compaction %s", got)
+	}
+	if res, err := lb.Query(ctx, "SELECT COUNT(*) FROM data WHERE name = 'b'", WithPassword(password)); err != nil {
+		t.Fatalf("count: %v", err)
+	} else {
+		if n := res.Column(0).(*array.Int64).Value(0); n != 0 {
+			t.Errorf("expected the old version to be gone, got %d", n)
+		}
+		res.Release()
+	}
+}
@@ -0,0 +1,86 @@
+package lockbox
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/TFMV/lockbox/pkg/format"
+	"github.com/apache/arrow-go/v18/arrow"
+	"github.com/apache/arrow-go/v18/arrow/array"
+	"github.com/apache/arrow-go/v18/arrow/memory"
+)
+
+func TestValidateBlocks(t *testing.T) {
+	password := "test_password_123"
+	ctx := context.Background()
+	schema := arrow.NewSchema([]arrow.Field{
+		{Name: "id", Type: arrow.PrimitiveTypes.Int64},
+		{Name: "name", Type: arrow.BinaryTypes.String},
+	}, nil)
+	path := filepath.Join(t.TempDir(), "validate.lbx")
+
+	lb, err := Create(path, schema, WithPassword(password))
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	const groups = 16
+	for g := 0; g < groups; g++ {
+		b := array.NewRecordBuilder(memory.NewGoAllocator(), schema)
+		b.Field(0).(*array.Int64Builder).AppendValues([]int64{int64(g), int64(g) + 100}, nil)
+		b.Field(1).(*array.StringBuilder).AppendValues([]string{"ann", "bob"}, nil)
+		rec := b.NewRecord()
+		b.Release()
+		if err := lb.Write(ctx, rec, WithPassword(password)); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+		rec.Release()
+	}
+	if err := lb.Validate(); err != nil {
+		t.Fatalf("validate: %v", err)
+	}
+
+	// Flip a byte in a block of a row group in the middle
+	var target int64 = -1
+	for _, bi := range lb.file.Metadata().BlockInfo {
+		if bi.RowGroup == groups/2 && bi.ColumnName == "name" {
+			target = bi.Offset + bi.Length/2
+		}
+	}
+	lb.Close()
+	if target < 0 {
+		t.Fatal("no block for the middle row group")
+	}
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	b := make([]byte, 1)
+	f.ReadAt(b, target)
+	b[0] ^= 0xff
+	f.WriteAt(b, target)
+	f.Close()
+
+	lb, err = Open(path, WithPassword(password))
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer lb.Close()
+	if err := lb.Validate(); !errors.Is(err, format.ErrCorruptedBlock) {
+		t.Fatalf("expected a corrupted block, got %v", err)
+	}
+	if err := lb.Repair(); err == nil {
+		t.Fatal("expected renumbering a signed file without the password to fail")
+	}
+	if err := lb.Repair(WithPassword(password)); err != nil {
+		t.Fatalf("repair: %v", err)
+	}
+	if err := lb.Validate(); err != nil {
+		t.Errorf("validate after repair: %v", err)
+	}
+	if n := lb.file.Metadata().NumRowGroups(); n != groups-1 {
+		t.Errorf("expected repair to drop one row group, got %d left", n)
+	}
+}
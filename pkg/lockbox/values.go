@@ -0,0 +1,185 @@
+package lockbox
+
+import (
+	"cmp"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/apache/arrow-go/v18/arrow"
+	"github.com/apache/arrow-go/v18/arrow/array"
+	"github.com/apache/arrow-go/v18/arrow/decimal128"
+)
+
+// timeOfDayLayouts are the accepted forms of time of day values
+var timeOfDayLayouts = []string{"15:04:05.999999999", "15:04"}
+
+// formatValue returns the text form of a value as exports write it: as
+// ValueStr, except that decimals keep every digit of their scale (12.50,
+// not 12.5) and durations are written as Go durations (1m30s).
+func formatValue(col arrow.Array, row int) string {
+	switch c := col.(type) {
+	case *array.Decimal128:
+		return c.Value(row).ToString(c.DataType().(*arrow.Decimal128Type).Scale)
+	case *array.Duration:
+		return durationValue(c, row).String()
+	}
+	return col.ValueStr(row)
+}
+
+// durationValue returns the duration at row
+func durationValue(c *array.Duration, row int) time.Duration {
+	return time.Duration(c.Value(row)) * c.DataType().(*arrow.DurationType).Unit.Multiplier()
+}
+
+// timeOfDay returns the time of a Time32 or Time64 value as the duration
+// since midnight
+func timeOfDay(col arrow.Array, row int) (time.Duration, bool) {
+	switch c := col.(type) {
+	case *array.Time32:
+		return time.Duration(c.Value(row)) * c.DataType().(*arrow.Time32Type).Unit.Multiplier(), true
+	case *array.Time64:
+		return time.Duration(c.Value(row)) * c.DataType().(*arrow.Time64Type).Unit.Multiplier(), true
+	}
+	return 0, false
+}
+
+// parseTimeOfDay parses a time of day such as 13:45, 13:45:10 or
+// 13:45:10.250 as the duration since midnight
+func parseTimeOfDay(v string) (time.Duration, error) {
+	for _, layout := range timeOfDayLayouts {
+		if tm, err := time.Parse(layout, v); err == nil {
+			return tm.Sub(time.Date(tm.Year(), tm.Month(), tm.Day(), 0, 0, 0, 0, time.UTC)), nil
+		}
+	}
+	return 0, fmt.Errorf("invalid time: %s", v)
+}
+
+// parseDuration parses a Go duration such as 1h30m, or a whole number of
+// the given unit
+func parseDuration(v string, unit arrow.TimeUnit) (time.Duration, error) {
+	if n, err := strconv.ParseInt(v, 10, 64); err == nil {
+		return time.Duration(n) * unit.Multiplier(), nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		return 0, fmt.Errorf("invalid duration: %s", v)
+	}
+	return d, nil
+}
+
+// parseDecimal parses a decimal number for a Decimal128 type, rounding it
+// to the scale of the type
+func parseDecimal(v string, typ *arrow.Decimal128Type) (decimal128.Num, error) {
+	n, err := decimal128.FromString(v, typ.Precision, typ.Scale)
+	if err != nil {
+		return decimal128.Num{}, fmt.Errorf("invalid %s: %s", typ, v)
+	}
+	return n, nil
+}
+
+// date parses a date as 2006-01-02, RFC 3339 or one of the configured
+// date formats
+func (p *valueParser) date(v string) (time.Time, error) {
+	if tm, err := time.Parse(time.DateOnly, v); err == nil {
+		return tm, nil
+	}
+	tm, err := p.time(v)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid date: %s", v)
+	}
+	return tm, nil
+}
+
+// compareLiteral compares the value at row with a literal of a WHERE
+// clause, for decimals, dates, timestamps, times of day, durations and
+// booleans. ok is false for other types, nulls, and literals that are not
+// a value of the column's type.
+func compareLiteral(col arrow.Array, row int, val string) (int, bool) {
+	if col.IsNull(row) {
+		return 0, false
+	}
+	val = strings.Trim(strings.TrimSpace(val), "'\"")
+	switch c := col.(type) {
+	case *array.Decimal128:
+		// Compare at the finer of the two scales so 12.345 is not 12.35
+		scale := c.DataType().(*arrow.Decimal128Type).Scale
+		if _, frac, found := strings.Cut(val, "."); found && int32(len(frac)) > scale {
+			scale = min(int32(len(frac)), 38)
+		}
+		lit, err := decimal128.FromString(val, 38, scale)
+		if err != nil {
+			return 0, false
+		}
+		return c.Value(row).IncreaseScaleBy(scale - c.DataType().(*arrow.Decimal128Type).Scale).Cmp(lit), true
+	case *array.Date32, *array.Date64, *array.Timestamp:
+		lit, err := (&valueParser{}).date(val)
+		if err != nil {
+			return 0, false
+		}
+		return Value(col, row).(time.Time).Compare(lit), true
+	case *array.Time32, *array.Time64:
+		lit, err := parseTimeOfDay(val)
+		if err != nil {
+			return 0, false
+		}
+		tod, _ := timeOfDay(col, row)
+		return cmp.Compare(tod, lit), true
+	case *array.Duration:
+		lit, err := parseDuration(val, c.DataType().(*arrow.DurationType).Unit)
+		if err != nil {
+			return 0, false
+		}
+		return cmp.Compare(durationValue(c, row), lit), true
+	case *array.Boolean:
+		lit, err := strconv.ParseBool(val)
+		if err != nil {
+			return 0, false
+		}
+		switch v := c.Value(row); {
+		case v == lit:
+			return 0, true
+		case lit:
+			return -1, true
+		default:
+			return 1, true
+		}
+	}
+	return 0, false
+}
+
+// compareCells compares the values at rows a and b of a column of one of
+// the types of compareLiteral
+func compareCells(col arrow.Array, a, b int) int {
+	switch c := col.(type) {
+	case *array.Decimal128:
+		return c.Value(a).Cmp(c.Value(b))
+	case *array.Date32, *array.Date64, *array.Timestamp:
+		return Value(col, a).(time.Time).Compare(Value(col, b).(time.Time))
+	case *array.Time32, *array.Time64:
+		ta, _ := timeOfDay(col, a)
+		tb, _ := timeOfDay(col, b)
+		return cmp.Compare(ta, tb)
+	case *array.Duration:
+		return cmp.Compare(durationValue(c, a), durationValue(c, b))
+	}
+	return strings.Compare(col.ValueStr(a), col.ValueStr(b))
+}
+
+// compareOp reports whether the result of a comparison satisfies op
+func compareOp(c int, op string) bool {
+	switch op {
+	case "=":
+		return c == 0
+	case "<":
+		return c < 0
+	case ">":
+		return c > 0
+	case "<=":
+		return c <= 0
+	case ">=":
+		return c >= 0
+	}
+	return false
+}
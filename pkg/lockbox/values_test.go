@@ -0,0 +1,153 @@
+package lockbox
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/apache/arrow-go/v18/arrow"
+	"github.com/apache/arrow-go/v18/arrow/array"
+)
+
+func TestDecimalAndTemporalTypes(t *testing.T) {
+	password := "test_password_123"
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	amount := &arrow.Decimal128Type{Precision: 10, Scale: 2}
+	schema := arrow.NewSchema([]arrow.Field{
+		{Name: "id", Type: arrow.PrimitiveTypes.Int64},
+		{Name: "amount", Type: amount, Nullable: true},
+		{Name: "day", Type: arrow.FixedWidthTypes.Date32, Nullable: true},
+		{Name: "at", Type: arrow.FixedWidthTypes.Time32ms, Nullable: true},
+		{Name: "took", Type: arrow.FixedWidthTypes.Duration_s, Nullable: true},
+		{Name: "paid", Type: arrow.FixedWidthTypes.Boolean, Nullable: true},
+	}, nil)
+
+	csvPath := filepath.Join(dir, "orders.csv")
+	data := "id,amount,day,at,took,paid\n" +
+		"1,12.50,2024-01-02,13:04:05.250,90,true\n" +
+		"2,3.1,2024-03-01,08:00,1h,false\n" +
+		"3,20.15,2024-01-20,23:59:59,30m,true\n" +
+		"4,,,,,\n"
+	if err := os.WriteFile(csvPath, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	rec, err := LoadCSV(csvPath, schema)
+	if err != nil {
+		t.Fatalf("load csv: %v", err)
+	}
+	defer rec.Release()
+	if got := rec.Column(1).(*array.Decimal128).Value(1).ToString(2); got != "3.10" {
+		t.Errorf("expected 3.10, got %s", got)
+	}
+	for i := 1; i < 6; i++ {
+		if !rec.Column(i).IsNull(3) {
+			t.Errorf("expected an empty %s to load as null", rec.Schema().Field(i).Name)
+		}
+	}
+
+	lb, err := Create(filepath.Join(dir, "orders.lbx"), schema, WithPassword(password), WithDebugAllocator())
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	// Query results are released by cleanups, before the allocator is checked
+	t.Cleanup(func() { lb.Close() })
+	if err := lb.Write(ctx, rec, WithPassword(password)); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+
+	query := func(q string) arrow.Record {
+		t.Helper()
+		rec, err := lb.Query(ctx, q, WithPassword(password))
+		if err != nil {
+			t.Fatalf("%s: %v", q, err)
+		}
+		t.Cleanup(rec.Release)
+		return rec
+	}
+	ids := func(q string) []int64 {
+		t.Helper()
+		return query(q).Column(0).(*array.Int64).Int64Values()
+	}
+
+	for q, want := range map[string][]int64{
+		"SELECT id FROM data WHERE amount >= 12.5":           {1, 3},
+		"SELECT id FROM data WHERE amount = 12.505":          nil,
+		"SELECT id FROM data WHERE day < '2024-02-01'":       {1, 3},
+		"SELECT id FROM data WHERE at > '12:00'":             {1, 3},
+		"SELECT id FROM data WHERE took >= '30m'":            {2, 3},
+		"SELECT id FROM data WHERE took < 100":               {1},
+		"SELECT id FROM data WHERE paid = true":              {1, 3},
+		"SELECT id FROM data WHERE amount > 1 ORDER BY at":   {2, 1, 3},
+		"SELECT id FROM data WHERE amount > 1 ORDER BY took": {1, 3, 2},
+	} {
+		if got := ids(q); len(got) != len(want) || (len(want) > 0 && got[0] != want[0]) || (len(want) > 1 && got[len(got)-1] != want[len(want)-1]) {
+			t.Errorf("%s: expected %v, got %v", q, want, got)
+		}
+	}
+
+	// Columns keep their types through a query
+	res := query("SELECT amount, day, at, took, paid FROM data WHERE id = 1")
+	for i, field := range res.Schema().Fields() {
+		if !arrow.TypeEqual(field.Type, schema.Field(i+1).Type) {
+			t.Errorf("column %s: expected %s, got %s", field.Name, schema.Field(i+1).Type, field.Type)
+		}
+	}
+
+	agg := query("SELECT SUM(amount), AVG(amount), MIN(day), MAX(took) FROM data")
+	if got := agg.Column(0).(*array.Decimal128).Value(0).ToString(2); got != "35.75" {
+		t.Errorf("expected an exact sum of 35.75, got %s", got)
+	}
+	if got := agg.Column(1).(*array.Float64).Value(0); got < 11.916 || got > 11.917 {
+		t.Errorf("expected an average of 11.9166..., got %v", got)
+	}
+	if got := Value(agg.Column(2), 0).(time.Time); !got.Equal(time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("expected 2024-01-02, got %v", got)
+	}
+	if got := Value(agg.Column(3), 0); got != time.Hour {
+		t.Errorf("expected 1h, got %v", got)
+	}
+
+	// Exports write values that load back
+	var buf bytes.Buffer
+	if err := ExportRecord(&buf, res, ExportCSV); err != nil {
+		t.Fatalf("export: %v", err)
+	}
+	if want := "amount,day,at,took,paid\n12.50,2024-01-02,13:04:05.250,1m30s,true\n"; buf.String() != want {
+		t.Errorf("expected %q, got %q", want, buf.String())
+	}
+
+	jsonPath := filepath.Join(dir, "orders.ndjson")
+	line := `{"id":7,"amount":1.5,"day":"2024-06-01","at":"09:15:00","took":"1m30s","paid":true}` + "\n"
+	if err := os.WriteFile(jsonPath, []byte(line), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	jrec, err := LoadJSON(jsonPath, schema)
+	if err != nil {
+		t.Fatalf("load json: %v", err)
+	}
+	defer jrec.Release()
+	for i, want := range []string{"7", "1.50", "2024-06-01", "09:15:00.000", "1m30s", "true"} {
+		if got := formatValue(jrec.Column(i), 0); got != want {
+			t.Errorf("json %s: expected %s, got %s", jrec.Schema().Field(i).Name, want, got)
+		}
+	}
+
+	// Go values are accepted too
+	err = lb.AppendRows(ctx, []map[string]any{
+		{"id": 5, "amount": 7.25, "day": "2024-05-05", "at": "10:30", "took": 2 * time.Minute, "paid": false},
+	}, WithPassword(password))
+	if err != nil {
+		t.Fatalf("append rows: %v", err)
+	}
+	if got := ids("SELECT id FROM data WHERE took = '2m' AND amount = 7.25"); len(got) != 1 || got[0] != 5 {
+		t.Errorf("expected row 5, got %v", got)
+	}
+	if err := lb.AppendRows(ctx, []map[string]any{{"id": 6, "amount": "123456789.5"}}, WithPassword(password)); err == nil {
+		t.Errorf("expected an amount beyond the precision to be refused")
+	}
+}
@@ -0,0 +1,198 @@
+package lockbox
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/apache/arrow-go/v18/arrow"
+	"github.com/apache/arrow-go/v18/arrow/array"
+)
+
+// VectorType returns the type of a vector column holding embeddings of dim
+// float32 values. Queries order rows by their distance to a query vector
+// with ORDER BY cosine_distance(column, ?).
+func VectorType(dim int) arrow.DataType {
+	return arrow.FixedSizeListOf(int32(dim), arrow.PrimitiveTypes.Float32)
+}
+
+// vectorDim returns the dimension of a vector column type
+func vectorDim(t arrow.DataType) (int, bool) {
+	lt, ok := t.(*arrow.FixedSizeListType)
+	if !ok || lt.Elem().ID() != arrow.FLOAT32 {
+		return 0, false
+	}
+	return int(lt.Len()), true
+}
+
+// WithArgs binds the ? placeholders of a query, in order. A vector is
+// given as []float32, []float64 or text such as "[0.1, 0.2]".
+func WithArgs(args ...any) Option {
+	return func(o *Options) {
+		o.Args = append(o.Args, args...)
+	}
+}
+
+// vectorOrder is an ORDER BY cosine_distance(column, vector) clause
+type vectorOrder struct {
+	Col         string
+	Placeholder bool      // the vector is a ? bound with WithArgs
+	Query       []float32 // nil until a placeholder is bound
+}
+
+// parseCosineDistance parses the arguments of cosine_distance in ORDER BY:
+// a vector column and a ? or a quoted vector literal
+func (p *queryParser) parseCosineDistance() (*vectorOrder, error) {
+	p.next()
+	col, err := p.expect(tokIdent, "vector column in cosine_distance")
+	if err != nil {
+		return nil, err
+	}
+	if _, err := p.expect(tokComma, "',' in cosine_distance"); err != nil {
+		return nil, err
+	}
+	vo := &vectorOrder{Col: strings.ToLower(col.Text)}
+	switch t := p.next(); t.Kind {
+	case tokParam:
+		vo.Placeholder = true
+	case tokString:
+		if vo.Query, err = parseVector(t.Text); err != nil {
+			return nil, fmt.Errorf("invalid query vector: %w", err)
+		}
+	default:
+		return nil, fmt.Errorf("invalid query: expected ? or a vector in cosine_distance")
+	}
+	if _, err := p.expect(tokRParen, "')'"); err != nil {
+		return nil, err
+	}
+	return vo, nil
+}
+
+// bindArgs binds the placeholder of the query, if any, to the first of
+// args and returns the rest
+func (pq *parsedQuery) bindArgs(args []any) ([]any, error) {
+	vo := pq.OrderVec
+	if vo == nil || !vo.Placeholder {
+		return args, nil
+	}
+	if len(args) == 0 {
+		return nil, fmt.Errorf("missing argument for ? in cosine_distance")
+	}
+	v, err := vectorArg(args[0])
+	if err != nil {
+		return nil, err
+	}
+	vo.Query = v
+	return args[1:], nil
+}
+
+// vectorArg converts an argument to a vector
+func vectorArg(arg any) ([]float32, error) {
+	switch v := arg.(type) {
+	case []float32:
+		return v, nil
+	case []float64:
+		out := make([]float32, len(v))
+		for i, f := range v {
+			out[i] = float32(f)
+		}
+		return out, nil
+	case string:
+		return parseVector(v)
+	default:
+		return nil, fmt.Errorf("unsupported vector argument of type %T", arg)
+	}
+}
+
+// parseVector parses a vector written as comma-separated numbers,
+// optionally in brackets
+func parseVector(s string) ([]float32, error) {
+	s = strings.TrimSpace(s)
+	s = strings.TrimSuffix(strings.TrimPrefix(s, "["), "]")
+	if strings.TrimSpace(s) == "" {
+		return nil, fmt.Errorf("empty vector")
+	}
+	parts := strings.Split(s, ",")
+	v := make([]float32, len(parts))
+	for i, p := range parts {
+		f, err := strconv.ParseFloat(strings.TrimSpace(p), 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid vector element %q", p)
+		}
+		v[i] = float32(f)
+	}
+	return v, nil
+}
+
+// sortByDistance orders the rows in idx by the cosine distance of their
+// vector to the query vector, nearest first unless desc. Rows without a
+// vector come last.
+func (vo *vectorOrder) sortByDistance(rec arrow.Record, idx []int, desc bool) error {
+	if vo.Query == nil {
+		return fmt.Errorf("missing argument for ? in cosine_distance")
+	}
+	fIdx := rec.Schema().FieldIndices(vo.Col)
+	if len(fIdx) == 0 {
+		return fmt.Errorf("column %s not found", vo.Col)
+	}
+	dim, ok := vectorDim(rec.Schema().Field(fIdx[0]).Type)
+	if !ok {
+		return fmt.Errorf("cosine_distance needs a vector column, %s is %s", vo.Col, rec.Schema().Field(fIdx[0]).Type)
+	}
+	if dim != len(vo.Query) {
+		return fmt.Errorf("query vector has %d dimensions, column %s has %d", len(vo.Query), vo.Col, dim)
+	}
+
+	col := rec.Column(fIdx[0]).(*array.FixedSizeList)
+	values := col.ListValues().(*array.Float32).Float32Values()
+	dist := make(map[int]float64, len(idx))
+	for _, i := range idx {
+		if col.IsNull(i) {
+			continue
+		}
+		start, end := col.ValueOffsets(i)
+		dist[i] = cosineDistance(values[start:end], vo.Query)
+	}
+	sort.SliceStable(idx, func(a, b int) bool {
+		da, oka := dist[idx[a]]
+		db, okb := dist[idx[b]]
+		if !oka || !okb {
+			return oka && !okb
+		}
+		if desc {
+			return db < da
+		}
+		return da < db
+	})
+	return nil
+}
+
+// cosineDistance returns 1 minus the cosine similarity of a and b, from 0
+// for vectors pointing the same way to 2 for opposite ones. A zero vector
+// is at distance 1 from everything.
+func cosineDistance(a, b []float32) float64 {
+	var dot, na, nb float64
+	for i := range a {
+		x, y := float64(a[i]), float64(b[i])
+		dot += x * y
+		na += x * x
+		nb += y * y
+	}
+	if na == 0 || nb == 0 {
+		return 1
+	}
+	return 1 - dot/(math.Sqrt(na)*math.Sqrt(nb))
+}
+
+// appendVector copies a row of a vector column
+func appendVector(b *array.FixedSizeListBuilder, col *array.FixedSizeList, row int) {
+	values := col.ListValues().(*array.Float32)
+	vb := b.ValueBuilder().(*array.Float32Builder)
+	b.Append(true)
+	start, end := col.ValueOffsets(row)
+	for j := start; j < end; j++ {
+		vb.Append(values.Value(int(j)))
+	}
+}
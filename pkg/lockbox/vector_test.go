@@ -0,0 +1,103 @@
+package lockbox
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/apache/arrow-go/v18/arrow"
+	"github.com/apache/arrow-go/v18/arrow/array"
+)
+
+func TestVectorSearch(t *testing.T) {
+	dir := t.TempDir()
+	password := "test_password_123"
+	ctx := context.Background()
+	schema := arrow.NewSchema([]arrow.Field{
+		{Name: "id", Type: arrow.PrimitiveTypes.Int64},
+		{Name: "embedding", Type: VectorType(3), Nullable: true},
+	}, nil)
+
+	csvPath := filepath.Join(dir, "docs.csv")
+	csvData := "id,embedding\n" +
+		"1,\"[1, 0, 0]\"\n" +
+		"2,\"[0, 1, 0]\"\n" +
+		"3,\"[0.9, 0.1, 0]\"\n" +
+		"4,\n" +
+		"5,\"[-1, 0, 0]\"\n"
+	if err := os.WriteFile(csvPath, []byte(csvData), 0600); err != nil {
+		t.Fatal(err)
+	}
+	rec, err := LoadCSV(csvPath, schema)
+	if err != nil {
+		t.Fatalf("load csv: %v", err)
+	}
+	defer rec.Release()
+
+	lb, err := Create(filepath.Join(dir, "docs.lbx"), schema, WithPassword(password))
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	defer lb.Close()
+	if err := lb.Write(ctx, rec, WithPassword(password)); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+
+	ids := func(query string, opts ...Option) []int64 {
+		t.Helper()
+		res, err := lb.Query(ctx, query, append(opts, WithPassword(password))...)
+		if err != nil {
+			t.Fatalf("%s: %v", query, err)
+		}
+		defer res.Release()
+		col := res.Column(0).(*array.Int64)
+		out := make([]int64, col.Len())
+		for i := range out {
+			out[i] = col.Value(i)
+		}
+		return out
+	}
+	check := func(got []int64, want ...int64) {
+		t.Helper()
+		if len(got) != len(want) {
+			t.Fatalf("expected ids %v, got %v", want, got)
+		}
+		for i := range want {
+			if got[i] != want[i] {
+				t.Fatalf("expected ids %v, got %v", want, got)
+			}
+		}
+	}
+
+	check(ids("SELECT id FROM data ORDER BY cosine_distance(embedding, ?) LIMIT 2", WithArgs([]float32{1, 0, 0})), 1, 3)
+	check(ids("SELECT id FROM data ORDER BY cosine_distance(embedding, '[0, 1, 0]') LIMIT 1"), 2)
+	check(ids("SELECT id FROM data WHERE id > 1 ORDER BY cosine_distance(embedding, ?) DESC", WithArgs("1, 0, 0")), 5, 2, 3, 4)
+
+	// Vectors come back as vectors
+	res, err := lb.Query(ctx, "SELECT embedding FROM data ORDER BY cosine_distance(embedding, ?) LIMIT 1",
+		WithPassword(password), WithArgs([]float64{0, 0.9, 0.1}))
+	if err != nil {
+		t.Fatalf("query: %v", err)
+	}
+	defer res.Release()
+	if !arrow.TypeEqual(res.Schema().Field(0).Type, VectorType(3)) {
+		t.Fatalf("expected a vector column, got %s", res.Schema().Field(0).Type)
+	}
+	if v := res.Column(0).(*array.FixedSizeList).ListValues().(*array.Float32).Float32Values(); v[1] != 1 {
+		t.Errorf("expected the nearest vector [0 1 0], got %v", v)
+	}
+
+	for _, tc := range []struct {
+		query string
+		args  []any
+	}{
+		{"SELECT id FROM data ORDER BY cosine_distance(embedding, ?)", nil},
+		{"SELECT id FROM data ORDER BY cosine_distance(embedding, ?)", []any{[]float32{1, 0}}},
+		{"SELECT id FROM data ORDER BY cosine_distance(id, ?)", []any{[]float32{1}}},
+	} {
+		if _, err := lb.Query(ctx, tc.query, WithPassword(password), WithArgs(tc.args...)); err == nil {
+			t.Errorf("%s with %v: expected an error", tc.query, tc.args)
+		}
+	}
+}
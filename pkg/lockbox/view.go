@@ -0,0 +1,215 @@
+package lockbox
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/TFMV/lockbox/pkg/metadata"
+	"github.com/apache/arrow-go/v18/arrow"
+	"github.com/rs/zerolog/log"
+)
+
+var viewNamePattern = regexp.MustCompile(`^[a-z_][a-z0-9_]*$`)
+
+// CreateView saves a named query in the lockbox metadata. The view can
+// then be queried like a table, e.g. "SELECT * FROM active_users".
+func (lb *Lockbox) CreateView(name, query string, opts ...Option) error {
+	options := &Options{CreatedBy: "system"}
+	for _, opt := range opts {
+		opt(options)
+	}
+
+	name = strings.ToLower(strings.TrimSpace(name))
+	if !viewNamePattern.MatchString(name) {
+		return fmt.Errorf("invalid view name %q", name)
+	}
+	if name == "data" {
+		return fmt.Errorf("view name %q is reserved", name)
+	}
+
+	meta := lb.file.Metadata()
+	if _, exists := meta.FindView(name); exists {
+		return fmt.Errorf("view %s already exists", name)
+	}
+
+	// Reject queries that can never run
+	selects, _, err := splitUnion(query)
+	if err != nil {
+		return err
+	}
+	for _, sel := range selects {
+		pq, err := parseQuery(sel)
+		if err != nil {
+			return fmt.Errorf("invalid view query: %w", err)
+		}
+		if pq.From == name {
+			return fmt.Errorf("view %s cannot select from itself", name)
+		}
+		if pq.OrderVec != nil && pq.OrderVec.Placeholder {
+			return fmt.Errorf("view %s cannot have ? placeholders", name)
+		}
+	}
+
+	meta.Views = append(meta.Views, metadata.View{
+		Name:         name,
+		Query:        query,
+		CreatedAt:    time.Now(),
+		CreatedBy:    options.CreatedBy,
+		Materialized: options.Materialized,
+	})
+	meta.LogAccess(options.CreatedBy, "create-view", name, true, "")
+
+	// Materialize right away when we can decrypt the data; otherwise the
+	// view is computed live until the next commit or refresh.
+	if options.Materialized && options.Password != "" {
+		return lb.refreshMaterializedViews(options.Password, name)
+	}
+
+	return lb.file.SaveMetadata()
+}
+
+// RefreshViews recomputes the stored results of materialized views. When
+// names are given only those views are refreshed.
+func (lb *Lockbox) RefreshViews(ctx context.Context, names []string, opts ...Option) error {
+	options := &Options{}
+	for _, opt := range opts {
+		opt(options)
+	}
+	if options.Password == "" {
+		return fmt.Errorf("password is required for refreshing views")
+	}
+
+	meta := lb.file.Metadata()
+	if len(names) == 0 {
+		return lb.refreshMaterializedViews(options.Password, "")
+	}
+	for _, name := range names {
+		view, ok := meta.FindView(strings.ToLower(name))
+		if !ok {
+			return fmt.Errorf("view %s not found", name)
+		}
+		if !view.Materialized {
+			return fmt.Errorf("view %s is not materialized", name)
+		}
+		if err := lb.refreshMaterializedViews(options.Password, view.Name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// refreshMaterializedViews re-runs materialized view queries and stores the
+// results as encrypted blocks. An empty name refreshes every materialized view.
+// Views are refreshed in creation order so views built on other views see
+// fresh results.
+func (lb *Lockbox) refreshMaterializedViews(password, name string) error {
+	meta := lb.file.Metadata()
+
+	var pending []int
+	for i, v := range meta.Views {
+		if v.Materialized && (name == "" || v.Name == name) {
+			pending = append(pending, i)
+		}
+	}
+	if len(pending) == 0 {
+		return nil
+	}
+
+	reader, err := lb.file.NewReader(password)
+	if err != nil {
+		return fmt.Errorf("failed to create reader: %w", err)
+	}
+	writer, err := lb.writerFor(password)
+	if err != nil {
+		return err
+	}
+
+	// Refreshes complete the commit that triggered them, so they aren't cancelled
+	qe := &queryExec{ctx: context.Background(), reader: reader, meta: meta, mem: lb.Allocator()}
+	for _, i := range pending {
+		view := &meta.Views[i]
+		rec, err := qe.run(view.Query)
+		if err != nil {
+			return fmt.Errorf("view %s: %w", view.Name, err)
+		}
+		block, err := writer.WriteDerivedRecord("view:"+view.Name, rec)
+		rows := rec.NumRows()
+		rec.Release()
+		if err != nil {
+			return fmt.Errorf("view %s: %w", view.Name, err)
+		}
+
+		view.Block = block
+		view.RefreshedAt = time.Now()
+		log.Debug().Str("view", view.Name).Int64("rows", rows).Msg("Refreshed materialized view")
+	}
+
+	return lb.file.SaveMetadata()
+}
+
+// DropView removes a saved view
+func (lb *Lockbox) DropView(name string) error {
+	meta := lb.file.Metadata()
+	name = strings.ToLower(name)
+	for i, v := range meta.Views {
+		if v.Name == name {
+			meta.Views = append(meta.Views[:i], meta.Views[i+1:]...)
+			meta.LogAccess("system", "drop-view", name, true, "")
+			return lb.file.SaveMetadata()
+		}
+	}
+	return fmt.Errorf("view %s not found", name)
+}
+
+// Views returns the views saved in the lockbox
+func (lb *Lockbox) Views() []metadata.View {
+	return append([]metadata.View(nil), lb.file.Metadata().Views...)
+}
+
+// execView evaluates the view query and applies the outer query to its result
+func (qe *queryExec) execView(view *metadata.View, pq *parsedQuery) (arrow.Record, error) {
+	if qe.depth >= maxViewDepth {
+		return nil, fmt.Errorf("view %s nests too deeply", view.Name)
+	}
+
+	// Stored results were computed without a principal and their columns
+	// may be renamed or derived from masked ones, so a principal with masks
+	// or row filters gets the view evaluated live
+	var base arrow.Record
+	var err error
+	if view.Materialized && view.Block != nil && qe.policy == nil {
+		base, err = qe.reader.ReadDerivedRecord(*view.Block)
+	} else {
+		inner := &queryExec{ctx: qe.ctx, reader: qe.reader, meta: qe.meta, depth: qe.depth + 1, policy: qe.policy, mem: qe.mem, first: qe.first, until: qe.until}
+		base, err = inner.run(view.Query)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("view %s: %w", view.Name, err)
+	}
+	defer base.Release()
+
+	if sample := qe.sampleFor(pq); sample != nil {
+		sampled := sampleRecord(qe.mem, base, sample)
+		defer sampled.Release()
+		base = sampled
+	}
+
+	if err := checkColumns(base.Schema(), nestedRoots(base.Schema(), pq.referencedColumns())); err != nil {
+		return nil, fmt.Errorf("view %s: %w", view.Name, err)
+	}
+
+	return applyQuery(qe.mem, base, pq)
+}
+
+// checkColumns ensures every name refers to a field of the schema
+func checkColumns(schema *arrow.Schema, names []string) error {
+	for _, name := range names {
+		if len(schema.FieldIndices(name)) == 0 {
+			return fmt.Errorf("column %s not found", name)
+		}
+	}
+	return nil
+}
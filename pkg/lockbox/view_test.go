@@ -0,0 +1,141 @@
+package lockbox
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/TFMV/lockbox/pkg/metadata"
+	"github.com/apache/arrow-go/v18/arrow/array"
+)
+
+func TestViews(t *testing.T) {
+	tmpFile := "/tmp/test_lockbox_view.lbx"
+	defer os.Remove(tmpFile)
+
+	password := "test_password_123"
+	lb := newQueryTestLockbox(t, tmpFile, password)
+
+	if err := lb.CreateView("high_scores", "SELECT name, score FROM data WHERE score > 20", WithCreatedBy("tester")); err != nil {
+		t.Fatalf("create view: %v", err)
+	}
+	if err := lb.CreateView("high_scores", "SELECT * FROM data"); err == nil {
+		t.Fatalf("expected duplicate view error")
+	}
+	lb.Close()
+
+	// Views must survive reopening the file
+	lb2, err := Open(tmpFile, WithPassword(password))
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer lb2.Close()
+
+	if views := lb2.Views(); len(views) != 1 || views[0].Name != "high_scores" {
+		t.Fatalf("unexpected views: %+v", views)
+	}
+
+	res, err := lb2.Query(context.Background(), "SELECT name FROM high_scores ORDER BY score DESC", WithPassword(password))
+	if err != nil {
+		t.Fatalf("query view: %v", err)
+	}
+	defer res.Release()
+
+	if res.NumRows() != 2 {
+		t.Fatalf("expected 2 rows, got %d", res.NumRows())
+	}
+	if name := res.Column(0).(*array.String).Value(0); name != "bob" {
+		t.Fatalf("unexpected first row: %s", name)
+	}
+
+	if err := lb2.DropView("high_scores"); err != nil {
+		t.Fatalf("drop view: %v", err)
+	}
+	if len(lb2.Views()) != 0 {
+		t.Fatalf("expected no views after drop")
+	}
+}
+
+func TestMaterializedView(t *testing.T) {
+	tmpFile := "/tmp/test_lockbox_matview.lbx"
+	defer os.Remove(tmpFile)
+
+	password := "test_password_123"
+	lb := newQueryTestLockbox(t, tmpFile, password)
+	defer lb.Close()
+
+	err := lb.CreateView("totals", "SELECT COUNT(*) FROM data",
+		WithMaterialized(true), WithPassword(password))
+	if err != nil {
+		t.Fatalf("create view: %v", err)
+	}
+
+	view, ok := lb.file.Metadata().FindView("totals")
+	if !ok || view.Block == nil {
+		t.Fatalf("expected materialized block for view")
+	}
+
+	if err := lb.Validate(); err != nil {
+		t.Fatalf("validate: %v", err)
+	}
+
+	res, err := lb.Query(context.Background(), "SELECT * FROM totals", WithPassword(password))
+	if err != nil {
+		t.Fatalf("query view: %v", err)
+	}
+	defer res.Release()
+
+	if cnt := res.Column(0).(*array.Int64).Value(0); cnt != 3 {
+		t.Fatalf("expected count 3, got %d", cnt)
+	}
+}
+
+func TestMaterializedViewMasked(t *testing.T) {
+	tmpFile := "/tmp/test_lockbox_matview_masked.lbx"
+	defer os.Remove(tmpFile)
+
+	password := "test_password_123"
+	lb := newQueryTestLockbox(t, tmpFile, password)
+	defer lb.Close()
+
+	err := lb.AddPolicyRule(metadata.PolicyRule{
+		Name:       "analysts",
+		Principals: []string{"analyst"},
+		Masks:      map[string]string{"name": "'***'"},
+	})
+	if err != nil {
+		t.Fatalf("add rule: %v", err)
+	}
+
+	// Renaming the column must not get it past the mask
+	err = lb.CreateView("renamed", "SELECT name AS x FROM data",
+		WithMaterialized(true), WithPassword(password))
+	if err != nil {
+		t.Fatalf("create view: %v", err)
+	}
+	if view, ok := lb.file.Metadata().FindView("renamed"); !ok || view.Block == nil {
+		t.Fatalf("expected materialized block for view")
+	}
+
+	ctx := context.Background()
+	res, err := lb.Query(ctx, "SELECT x FROM renamed", WithPassword(password), WithPrincipal("analyst"))
+	if err != nil {
+		t.Fatalf("query view: %v", err)
+	}
+	defer res.Release()
+	xs := res.Column(0).(*array.String)
+	for i := 0; i < xs.Len(); i++ {
+		if xs.Value(i) != "***" {
+			t.Fatalf("expected masked value, got %q", xs.Value(i))
+		}
+	}
+
+	full, err := lb.Query(ctx, "SELECT x FROM renamed ORDER BY x", WithPassword(password), WithPrincipal("admin"))
+	if err != nil {
+		t.Fatalf("query view: %v", err)
+	}
+	defer full.Release()
+	if x := full.Column(0).(*array.String).Value(0); x != "alice" {
+		t.Fatalf("expected stored result for unmasked principal, got %q", x)
+	}
+}
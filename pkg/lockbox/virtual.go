@@ -0,0 +1,323 @@
+package lockbox
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/TFMV/lockbox/pkg/expr"
+	"github.com/TFMV/lockbox/pkg/metadata"
+	"github.com/apache/arrow-go/v18/arrow"
+	"github.com/apache/arrow-go/v18/arrow/array"
+	"github.com/apache/arrow-go/v18/arrow/memory"
+)
+
+// AddVirtualColumn defines a column computed from an expression whenever
+// the lockbox is queried, e.g. "first || ' ' || last" or
+// "datediff(now, dob) / 365". Virtual columns are not stored; they can be
+// selected and filtered on like regular columns and may refer to virtual
+// columns defined before them.
+func (lb *Lockbox) AddVirtualColumn(name, expression string) error {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if !viewNamePattern.MatchString(name) {
+		return fmt.Errorf("invalid column name %q", name)
+	}
+
+	meta := lb.file.Metadata()
+	if len(meta.Schema.FieldIndices(name)) > 0 {
+		return fmt.Errorf("column %s already exists", name)
+	}
+	if _, exists := meta.FindVirtualColumn(name); exists {
+		return fmt.Errorf("virtual column %s already exists", name)
+	}
+
+	e, err := expr.Parse(expression)
+	if err != nil {
+		return fmt.Errorf("invalid expression for %s: %w", name, err)
+	}
+	for _, dep := range e.Columns() {
+		_, isVirtual := meta.FindVirtualColumn(dep)
+		if len(meta.Schema.FieldIndices(dep)) == 0 && !isVirtual {
+			return fmt.Errorf("expression for %s refers to unknown column %s", name, dep)
+		}
+	}
+
+	meta.Virtual = append(meta.Virtual, metadata.VirtualColumn{Name: name, Expression: expression})
+	meta.LogAccess("system", "add-virtual-column", name, true, expression)
+	return lb.file.SaveMetadata()
+}
+
+// DropVirtualColumn removes a virtual column definition
+func (lb *Lockbox) DropVirtualColumn(name string) error {
+	meta := lb.file.Metadata()
+	name = strings.ToLower(name)
+	for i, vc := range meta.Virtual {
+		if vc.Name != name {
+			continue
+		}
+		for _, other := range meta.Virtual[i+1:] {
+			e, err := expr.Parse(other.Expression)
+			if err != nil {
+				return fmt.Errorf("virtual column %s: %w", other.Name, err)
+			}
+			if contains(e.Columns(), name) {
+				return fmt.Errorf("virtual column %s is used by %s", name, other.Name)
+			}
+		}
+		meta.Virtual = append(meta.Virtual[:i], meta.Virtual[i+1:]...)
+		meta.LogAccess("system", "drop-virtual-column", name, true, "")
+		return lb.file.SaveMetadata()
+	}
+	return fmt.Errorf("virtual column %s not found", name)
+}
+
+// VirtualColumns returns the virtual column definitions
+func (lb *Lockbox) VirtualColumns() []metadata.VirtualColumn {
+	return append([]metadata.VirtualColumn(nil), lb.file.Metadata().Virtual...)
+}
+
+// planVirtualColumns splits the columns a query needs into stored columns
+// to decrypt and virtual columns to compute, in definition order. A nil
+// required list selects every stored and virtual column.
+func planVirtualColumns(meta *metadata.Metadata, required []string) ([]string, []metadata.VirtualColumn, error) {
+	if len(meta.Virtual) == 0 {
+		return required, nil, nil
+	}
+	if required == nil {
+		return nil, meta.Virtual, nil
+	}
+
+	needed := map[string]bool{}
+	var stored []string
+	var visit func(name string) error
+	visit = func(name string) error {
+		vc, ok := meta.FindVirtualColumn(name)
+		if !ok {
+			if !contains(stored, name) {
+				stored = append(stored, name)
+			}
+			return nil
+		}
+		if needed[name] {
+			return nil
+		}
+		needed[name] = true
+		e, err := expr.Parse(vc.Expression)
+		if err != nil {
+			return fmt.Errorf("virtual column %s: %w", vc.Name, err)
+		}
+		for _, dep := range e.Columns() {
+			if err := visit(dep); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	for _, name := range required {
+		if err := visit(name); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	var virtual []metadata.VirtualColumn
+	for _, vc := range meta.Virtual {
+		if needed[vc.Name] {
+			virtual = append(virtual, vc)
+		}
+	}
+	if stored == nil {
+		stored = []string{}
+	}
+	return stored, virtual, nil
+}
+
+// addVirtualColumns evaluates virtual columns over rec and returns a new
+// record with them appended
+func addVirtualColumns(mem memory.Allocator, rec arrow.Record, virtual []metadata.VirtualColumn) (arrow.Record, error) {
+	rec.Retain()
+	for _, vc := range virtual {
+		e, err := expr.Parse(vc.Expression)
+		if err != nil {
+			rec.Release()
+			return nil, fmt.Errorf("virtual column %s: %w", vc.Name, err)
+		}
+
+		values := make([]interface{}, rec.NumRows())
+		for row := range values {
+			v, err := e.Eval(recordEnv{rec: rec, row: row})
+			if err != nil {
+				rec.Release()
+				return nil, fmt.Errorf("virtual column %s, row %d: %w", vc.Name, row, err)
+			}
+			values[row] = v
+		}
+
+		arr := buildValueArray(mem, values)
+		fields := append(append([]arrow.Field{}, rec.Schema().Fields()...),
+			arrow.Field{Name: vc.Name, Type: arr.DataType(), Nullable: true})
+		cols := append(append([]arrow.Array{}, rec.Columns()...), arr)
+
+		next := array.NewRecord(arrow.NewSchema(fields, nil), cols, rec.NumRows())
+		arr.Release()
+		rec.Release()
+		rec = next
+	}
+	return rec, nil
+}
+
+// recordEnv exposes one row of a record to expression evaluation
+type recordEnv struct {
+	rec arrow.Record
+	row int
+}
+
+// Lookup implements expr.Env
+func (e recordEnv) Lookup(name string) (interface{}, bool) {
+	idx := e.rec.Schema().FieldIndices(name)
+	if len(idx) == 0 {
+		idx = e.rec.Schema().FieldIndices(strings.ToLower(name))
+	}
+	if len(idx) == 0 {
+		// A path into a nested column, e.g. address.city
+		p, err := resolveNestedPath(e.rec.Schema(), strings.ToLower(name))
+		if err != nil || p == nil {
+			return nil, false
+		}
+		col, row, ok := nestedValue(e.rec.Column(e.rec.Schema().FieldIndices(p.Column)[0]), e.row, p.Steps)
+		if !ok {
+			return nil, true
+		}
+		return exprValue(col, row), true
+	}
+	return exprValue(e.rec.Column(idx[0]), e.row), true
+}
+
+// exprValue converts an Arrow value to the representation used by expr
+func exprValue(col arrow.Array, row int) interface{} {
+	if col.IsNull(row) {
+		return nil
+	}
+	switch c := col.(type) {
+	case *array.Int64:
+		return c.Value(row)
+	case *array.Int32:
+		return int64(c.Value(row))
+	case *array.Int16:
+		return int64(c.Value(row))
+	case *array.Int8:
+		return int64(c.Value(row))
+	case *array.Float64:
+		return c.Value(row)
+	case *array.Float32:
+		return float64(c.Value(row))
+	case *array.Decimal128:
+		return c.Value(row).ToFloat64(c.DataType().(*arrow.Decimal128Type).Scale)
+	case *array.String:
+		return c.Value(row)
+	case *array.Boolean:
+		return c.Value(row)
+	case *array.Binary:
+		return c.Value(row)
+	case *array.Timestamp:
+		unit := c.DataType().(*arrow.TimestampType).Unit
+		return c.Value(row).ToTime(unit).UTC()
+	case *array.Date32:
+		return c.Value(row).ToTime().UTC()
+	case *array.Date64:
+		return c.Value(row).ToTime().UTC()
+	default:
+		return col.ValueStr(row)
+	}
+}
+
+// buildValueArray builds an array from expression results, choosing the
+// narrowest type that holds every non-null value
+func buildValueArray(mem memory.Allocator, values []interface{}) arrow.Array {
+	kind := ""
+	for _, v := range values {
+		var k string
+		switch v.(type) {
+		case nil:
+			continue
+		case int64:
+			k = "int"
+		case float64:
+			k = "float"
+		case bool:
+			k = "bool"
+		case time.Time:
+			k = "time"
+		default:
+			k = "string"
+		}
+		switch {
+		case kind == "" || kind == k:
+			kind = k
+		case (kind == "int" && k == "float") || (kind == "float" && k == "int"):
+			kind = "float"
+		default:
+			kind = "string"
+		}
+	}
+
+	switch kind {
+	case "int":
+		b := array.NewInt64Builder(mem)
+		defer b.Release()
+		for _, v := range values {
+			if v == nil {
+				b.AppendNull()
+			} else {
+				b.Append(v.(int64))
+			}
+		}
+		return b.NewArray()
+	case "float":
+		b := array.NewFloat64Builder(mem)
+		defer b.Release()
+		for _, v := range values {
+			switch t := v.(type) {
+			case nil:
+				b.AppendNull()
+			case int64:
+				b.Append(float64(t))
+			default:
+				b.Append(t.(float64))
+			}
+		}
+		return b.NewArray()
+	case "bool":
+		b := array.NewBooleanBuilder(mem)
+		defer b.Release()
+		for _, v := range values {
+			if v == nil {
+				b.AppendNull()
+			} else {
+				b.Append(v.(bool))
+			}
+		}
+		return b.NewArray()
+	case "time":
+		b := array.NewTimestampBuilder(mem, &arrow.TimestampType{Unit: arrow.Second, TimeZone: "UTC"})
+		defer b.Release()
+		for _, v := range values {
+			if v == nil {
+				b.AppendNull()
+			} else {
+				b.Append(arrow.Timestamp(v.(time.Time).Unix()))
+			}
+		}
+		return b.NewArray()
+	default:
+		b := array.NewStringBuilder(mem)
+		defer b.Release()
+		for _, v := range values {
+			if v == nil {
+				b.AppendNull()
+			} else {
+				b.Append(expr.ToString(v))
+			}
+		}
+		return b.NewArray()
+	}
+}
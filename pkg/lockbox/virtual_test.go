@@ -0,0 +1,63 @@
+package lockbox
+
+import (
+	"context"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/TFMV/lockbox/pkg/metadata"
+	"github.com/apache/arrow-go/v18/arrow/array"
+)
+
+func TestVirtualColumns(t *testing.T) {
+	tmpFile := "/tmp/test_lockbox_virtual.lbx"
+	defer os.Remove(tmpFile)
+
+	password := "test_password_123"
+	lb := newQueryTestLockbox(t, tmpFile, password)
+	defer lb.Close()
+
+	if err := lb.AddVirtualColumn("label", "upper(name) || '-' || id"); err != nil {
+		t.Fatalf("add virtual column: %v", err)
+	}
+	if err := lb.AddVirtualColumn("doubled", "score * 2"); err != nil {
+		t.Fatalf("add virtual column: %v", err)
+	}
+	if err := lb.AddVirtualColumn("bad", "missing + 1"); err == nil {
+		t.Fatalf("expected error for unknown column reference")
+	}
+
+	res, err := lb.Query(context.Background(), "SELECT label, doubled FROM data WHERE doubled > 50 ORDER BY doubled", WithPassword(password))
+	if err != nil {
+		t.Fatalf("query: %v", err)
+	}
+	defer res.Release()
+
+	if res.NumRows() != 2 {
+		t.Fatalf("expected 2 rows, got %d", res.NumRows())
+	}
+	if got := res.Column(0).(*array.String).Value(0); got != "CAROL-3" {
+		t.Fatalf("unexpected label: %s", got)
+	}
+	if got := res.Column(1).(*array.Float64).Value(1); got != 110 {
+		t.Fatalf("unexpected doubled value: %v", got)
+	}
+
+	if err := lb.DropVirtualColumn("label"); err != nil {
+		t.Fatalf("drop: %v", err)
+	}
+	if len(lb.VirtualColumns()) != 1 {
+		t.Fatalf("expected one virtual column left")
+	}
+
+	// A damaged definition is reported, not a panic
+	meta := lb.file.Metadata()
+	meta.Virtual = append(meta.Virtual, metadata.VirtualColumn{Name: "broken", Expression: "score +"})
+	if _, err := lb.Query(context.Background(), "SELECT broken FROM data", WithPassword(password)); err == nil || !strings.Contains(err.Error(), "virtual column broken") {
+		t.Fatalf("expected an error for the damaged virtual column, got %v", err)
+	}
+	if err := lb.DropVirtualColumn("doubled"); err == nil || !strings.Contains(err.Error(), "virtual column broken") {
+		t.Fatalf("expected an error for the damaged virtual column, got %v", err)
+	}
+}
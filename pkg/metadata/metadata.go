@@ -4,8 +4,10 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"sort"
 	"time"
 
+	"github.com/TFMV/lockbox/pkg/geo"
 	"github.com/apache/arrow-go/v18/arrow"
 	"github.com/apache/arrow-go/v18/arrow/ipc"
 )
@@ -15,8 +17,44 @@ const (
 	FileFormatVersion = 1
 	// MagicBytes identifies a lockbox file
 	MagicBytes = "LOCKBOX\x00"
+	// GroupKeyPrefix starts the key name of a column group
+	GroupKeyPrefix = "@"
+	// KDFPBKDF2 names the derivation of keys from passwords with
+	// PBKDF2-HMAC-SHA256 over Iterations, or the crypto module's own
+	KDFPBKDF2 = "PBKDF2"
+	// KDFArgon2id names the derivation of keys from passwords with Argon2id
+	// over EncryptionParams.Argon2
+	KDFArgon2id = "Argon2id"
+	// ColumnKeyHKDF names the derivation of column keys from the master key
+	// with HKDF-SHA256
+	ColumnKeyHKDF = "HKDF-SHA256"
+	// ColumnKeySignedHKDF derives column keys with HKDF-SHA256 under a label
+	// of their own, for files whose blocks are signed with Ed25519. Keys
+	// derived any other way decrypt nothing in such files, so the metadata
+	// can't be rewritten to turn signature checks off.
+	ColumnKeySignedHKDF = "HKDF-SHA256+Ed25519"
 )
 
+// FlagWideMetadataLength is set in FileHeader.Flags when the metadata length
+// is stored in 8 bytes instead of 4, because the metadata outgrew 4 GiB.
+// Files only get the flag when they need it, so other files stay readable
+// by older versions.
+const FlagWideMetadataLength uint32 = 1 << 0
+
+// FlagColumnGroups is set in FileHeader.Flags when the file declares column
+// groups, whose columns share blocks that readers unaware of groups would
+// misread
+const FlagColumnGroups uint32 = 1 << 1
+
+// FlagEncryptedMetadata is set in FileHeader.Flags when the metadata is
+// sealed with a key derived from the master key. Only the parameters needed
+// to derive that key stay in the clear.
+const FlagEncryptedMetadata uint32 = 1 << 2
+
+// KnownFlags are the header flags this version understands; files with
+// other flags need a newer version
+const KnownFlags = FlagWideMetadataLength | FlagColumnGroups | FlagEncryptedMetadata
+
 // FileHeader represents the lockbox file header
 type FileHeader struct {
 	Magic    [8]byte `json:"magic"`
@@ -28,22 +66,227 @@ type FileHeader struct {
 // EncryptionParams holds encryption configuration
 type EncryptionParams struct {
 	Algorithm     string            `json:"algorithm"`     // "AES-256-GCM"
-	KeyDerivation string            `json:"keyDerivation"` // "PBKDF2"
+	KeyDerivation string            `json:"keyDerivation"` // KDFPBKDF2 or KDFArgon2id
 	Iterations    int               `json:"iterations"`
 	SaltSize      int               `json:"saltSize"`
 	ColumnSalts   map[string][]byte `json:"columnSalts"` // Column name -> salt
 	MasterSalt    []byte            `json:"masterSalt"`
+	// Module is the crypto module the file was created with; empty means
+	// "default" for files written before modules were recorded
+	Module       string            `json:"module,omitempty"`
+	ModuleParams map[string]string `json:"moduleParams,omitempty"`
+	// Argon2 holds the costs of KDFArgon2id
+	Argon2 *Argon2Params `json:"argon2,omitempty"`
+	// ColumnKeyDerivation is how column keys are derived from the master
+	// key; empty means PBKDF2 with Iterations for each column, as in files
+	// created before ColumnKeyHKDF
+	ColumnKeyDerivation string `json:"columnKeyDerivation,omitempty"`
+	// Codecs lists every codec used by a data block
+	Codecs []string `json:"codecs,omitempty"`
+	// DefaultCodec compresses writes that don't choose a codec
+	DefaultCodec string `json:"defaultCodec,omitempty"`
+	// WrappedMasterKey holds the master key encrypted with a key derived
+	// from the password and KeySalt. Files without it derive the master key
+	// from the password directly.
+	WrappedMasterKey []byte         `json:"wrappedMasterKey,omitempty"`
+	KeySalt          []byte         `json:"keySalt,omitempty"`
+	RecoveryCodes    []RecoveryCode `json:"recoveryCodes,omitempty"`
+	// ColumnEpochs holds the current key epoch of columns whose key was
+	// rotated; other columns are at epoch 0
+	ColumnEpochs map[string]int `json:"columnEpochs,omitempty"`
+	// KEMKeys holds the ML-KEM keys of files that keep independent ones,
+	// wrapped under the master key, by column key name and epoch
+	// ("name#epoch")
+	KEMKeys map[string][]byte `json:"kemKeys,omitempty"`
+	// AccessKeys let other passwords decrypt a subset of the columns
+	AccessKeys []AccessKey `json:"accessKeys,omitempty"`
+	// ColumnGroups are stored and encrypted together, one block per row
+	// group under a key of their own
+	ColumnGroups []ColumnGroup `json:"columnGroups,omitempty"`
+	// BlockSignatures is SignatureEd25519 for files whose blocks are all
+	// signed, and empty for files created before block signatures. It is
+	// informational: readers with the master key go by
+	// ColumnKeyDerivation, see SignatureScheme. SigningKey is the public
+	// key, recorded with the first signed block.
+	BlockSignatures string `json:"blockSignatures,omitempty"`
+	SigningKey      []byte `json:"signingKey,omitempty"`
+}
+
+// SignatureEd25519 signs blocks with Ed25519 under a key derived from the
+// master key
+const SignatureEd25519 = "Ed25519"
+
+// SignatureScheme returns how the blocks of the file are signed, empty for
+// unsigned files. Files with ColumnKeySignedHKDF are signed with Ed25519
+// whatever BlockSignatures says.
+func (e EncryptionParams) SignatureScheme() string {
+	if e.ColumnKeyDerivation == ColumnKeySignedHKDF {
+		return SignatureEd25519
+	}
+	return e.BlockSignatures
+}
+
+// KeyParams returns the parameters needed to unlock the master key or an
+// access key, and whether blocks are signed, which stay in the clear when
+// the metadata is encrypted
+func (e EncryptionParams) KeyParams() EncryptionParams {
+	params := EncryptionParams{
+		Algorithm:           e.Algorithm,
+		KeyDerivation:       e.KeyDerivation,
+		Iterations:          e.Iterations,
+		Argon2:              e.Argon2,
+		SaltSize:            e.SaltSize,
+		MasterSalt:          e.MasterSalt,
+		Module:              e.Module,
+		ModuleParams:        e.ModuleParams,
+		ColumnKeyDerivation: e.ColumnKeyDerivation,
+		BlockSignatures:     e.BlockSignatures,
+		WrappedMasterKey:    e.WrappedMasterKey,
+		KeySalt:             e.KeySalt,
+		RecoveryCodes:       e.RecoveryCodes,
+	}
+	for _, ak := range e.AccessKeys {
+		params.AccessKeys = append(params.AccessKeys, AccessKey{
+			Salt:        ak.Salt,
+			WrappedKey:  ak.WrappedKey,
+			MetadataKey: ak.MetadataKey,
+		})
+	}
+	return params
+}
+
+// Argon2Params are the costs of Argon2id key derivation
+type Argon2Params struct {
+	Time    uint32 `json:"time"`
+	Memory  uint32 `json:"memory"` // KiB
+	Threads uint8  `json:"threads"`
+}
+
+// ColumnGroup is a set of columns serialized and encrypted as one block
+type ColumnGroup struct {
+	Name    string   `json:"name"`
+	Columns []string `json:"columns"`
+}
+
+// ColumnEpoch returns the key epoch new blocks of a column are written with
+func (e EncryptionParams) ColumnEpoch(column string) int {
+	return e.ColumnEpochs[e.KeyName(column)]
+}
+
+// FindColumnGroup returns the group a column belongs to, if any
+func (e EncryptionParams) FindColumnGroup(column string) (*ColumnGroup, bool) {
+	for i := range e.ColumnGroups {
+		for _, c := range e.ColumnGroups[i].Columns {
+			if c == column {
+				return &e.ColumnGroups[i], true
+			}
+		}
+	}
+	return nil, false
+}
+
+// KeyName returns the name a column's key is derived from and its epochs
+// are tracked under: the column name, or "@" and the group name for the
+// columns of a group, which share one key
+func (e EncryptionParams) KeyName(column string) string {
+	if g, ok := e.FindColumnGroup(column); ok {
+		return GroupKeyPrefix + g.Name
+	}
+	return column
+}
+
+// RecoveryCode is a one-time code that can unwrap the master key
+type RecoveryCode struct {
+	Hash       []byte     `json:"hash"`
+	Salt       []byte     `json:"salt"`
+	WrappedKey []byte     `json:"wrappedKey"`
+	UsedAt     *time.Time `json:"usedAt,omitempty"`
+}
+
+// AccessKey grants a password of its own the keys of some columns, e.g. a
+// "public" password for everything but the identifying columns. A random
+// access key is wrapped once with a key derived from that password and
+// once under the master key, so the grant can be resealed when a column
+// key is rotated; ColumnKeys holds the sealed key material.
+type AccessKey struct {
+	Name             string    `json:"name"`
+	Columns          []string  `json:"columns"`
+	Salt             []byte    `json:"salt"`
+	WrappedKey       []byte    `json:"wrappedKey"`
+	MasterWrappedKey []byte    `json:"masterWrappedKey"`
+	ColumnKeys       []byte    `json:"columnKeys"`
+	CreatedAt        time.Time `json:"createdAt"`
+	// MetadataKey is the key of encrypted metadata wrapped with the access
+	// key, so that the access password can open the file
+	MetadataKey []byte `json:"metadataKey,omitempty"`
+}
+
+// FindAccessKey returns the access key with the given name, if any
+func (e *EncryptionParams) FindAccessKey(name string) (*AccessKey, bool) {
+	for i := range e.AccessKeys {
+		if e.AccessKeys[i].Name == name {
+			return &e.AccessKeys[i], true
+		}
+	}
+	return nil, false
+}
+
+// AddCodec records that a block was written with the named codec
+func (e *EncryptionParams) AddCodec(name string) {
+	for _, c := range e.Codecs {
+		if c == name {
+			return
+		}
+	}
+	e.Codecs = append(e.Codecs, name)
+}
+
+// ModuleName returns the recorded crypto module, defaulting to "default"
+func (e EncryptionParams) ModuleName() string {
+	if e.Module == "" {
+		return "default"
+	}
+	return e.Module
 }
 
 // AccessPolicy represents access control rules
 type AccessPolicy struct {
-	Version    int         `json:"version"`
-	Principals []Principal `json:"principals"`
-	Resources  []Resource  `json:"resources"`
-	Actions    []string    `json:"actions"`
-	Conditions []Condition `json:"conditions"`
-	CreatedAt  time.Time   `json:"createdAt"`
-	ModifiedAt time.Time   `json:"modifiedAt"`
+	Version    int          `json:"version"`
+	Principals []Principal  `json:"principals"`
+	Resources  []Resource   `json:"resources"`
+	Actions    []string     `json:"actions"`
+	Conditions []Condition  `json:"conditions"`
+	Rules      []PolicyRule `json:"rules,omitempty"`
+	CreatedAt  time.Time    `json:"createdAt"`
+	ModifiedAt time.Time    `json:"modifiedAt"`
+	// Preview approves writing a plaintext preview next to the file; nil
+	// forbids it
+	Preview *PreviewPolicy `json:"preview,omitempty"`
+}
+
+// PreviewPolicy approves a plaintext preview of a lockbox: at most MaxRows
+// rows of Columns, which whoever approved it judged not sensitive
+type PreviewPolicy struct {
+	Columns    []string  `json:"columns"`
+	MaxRows    int       `json:"maxRows"`
+	ApprovedBy string    `json:"approvedBy"`
+	ApprovedAt time.Time `json:"approvedAt"`
+}
+
+// PolicyRule is a declarative access rule written in the expression
+// language of package expr. A rule applies when the principal and action
+// match; empty lists match everything.
+type PolicyRule struct {
+	Name       string   `json:"name"`
+	Principals []string `json:"principals,omitempty"`
+	Actions    []string `json:"actions,omitempty"` // "read", "write"
+	// Condition must be true for access to be granted, e.g.
+	// "action = 'read' OR principal = 'etl'"
+	Condition string `json:"condition,omitempty"`
+	// RowFilter limits the rows that can be read, e.g. "owner = principal"
+	RowFilter string `json:"rowFilter,omitempty"`
+	// Masks replace column values on read, e.g. ssn: "'***-**-' || substr(ssn, 8, 4)"
+	Masks map[string]string `json:"masks,omitempty"`
 }
 
 // Principal represents a user, role, or service account
@@ -60,7 +303,7 @@ type Resource struct {
 
 // Condition represents access conditions
 type Condition struct {
-	Type  string      `json:"type"` // "time", "ip", "custom"
+	Type  string      `json:"type"` // "time", "ip", "custom", "expr"
 	Value interface{} `json:"value"`
 }
 
@@ -72,10 +315,20 @@ type AuditTrail struct {
 	ModifiedBy string        `json:"modifiedBy"`
 	AccessLog  []AccessEntry `json:"accessLog"`
 	Version    int           `json:"version"`
+	// LastSeq is the sequence number of the last access entry logged
+	LastSeq int64 `json:"lastSeq,omitempty"`
+	// ExportedSeq is the sequence number of the last access entry exported
+	// by maintenance
+	ExportedSeq int64 `json:"exportedSeq,omitempty"`
+	// ExportedThrough is the timestamp of the last access entry exported
+	// by maintenance. Files written before sequence numbers only have this.
+	ExportedThrough time.Time `json:"exportedThrough,omitempty"`
 }
 
 // AccessEntry represents a single access event
 type AccessEntry struct {
+	// Seq numbers the entries of a file in the order they were logged
+	Seq       int64     `json:"seq,omitempty"`
 	Timestamp time.Time `json:"timestamp"`
 	Principal string    `json:"principal"`
 	Action    string    `json:"action"`
@@ -86,25 +339,267 @@ type AccessEntry struct {
 
 // Metadata represents the complete lockbox metadata
 type Metadata struct {
-	Header       FileHeader       `json:"header"`
-	Schema       *arrow.Schema    `json:"-"` // Serialized separately
-	SchemaBytes  []byte           `json:"schemaBytes"`
-	Encryption   EncryptionParams `json:"encryption"`
-	AccessPolicy *AccessPolicy    `json:"accessPolicy,omitempty"`
-	AuditTrail   AuditTrail       `json:"auditTrail"`
-	BlockInfo    []BlockInfo      `json:"blockInfo"`
+	Header       FileHeader        `json:"header"`
+	Schema       *arrow.Schema     `json:"-"` // Serialized separately
+	SchemaBytes  []byte            `json:"schemaBytes"`
+	Encryption   EncryptionParams  `json:"encryption"`
+	AccessPolicy *AccessPolicy     `json:"accessPolicy,omitempty"`
+	AuditTrail   AuditTrail        `json:"auditTrail"`
+	BlockInfo    []BlockInfo       `json:"blockInfo"`
+	Views        []View            `json:"views,omitempty"`
+	Virtual      []VirtualColumn   `json:"virtualColumns,omitempty"`
+	Hooks        []Hook            `json:"hooks,omitempty"`
+	Description  string            `json:"description,omitempty"`
+	Tags         map[string]string `json:"tags,omitempty"` // free-form labels, stored in the clear
+	Snapshots    []Snapshot        `json:"snapshots,omitempty"`
+	Preset       *Preset           `json:"preset,omitempty"`
+	// SyntheticKey is the column writes fill with generated keys
+	SyntheticKey *SyntheticKey `json:"syntheticKey,omitempty"`
+	// Sequences are the auto-increment columns
+	Sequences []Sequence `json:"sequences,omitempty"`
+	// Rollup sums up the blocks; nil for files written before rollups
+	// until their next write
+	Rollup *Rollup `json:"rollup,omitempty"`
+	// Replica is set for read-only copies exported from a snapshot
+	Replica *Replica `json:"replica,omitempty"`
+	// SchemaRef is the schema registry schema the file was created from
+	SchemaRef *SchemaRef `json:"schemaRef,omitempty"`
+	// Tombstones mark the deleted rows of row groups until compaction
+	Tombstones []Tombstone `json:"tombstones,omitempty"`
+	// Sealed holds the encrypted metadata of files with
+	// FlagEncryptedMetadata until it is unsealed
+	Sealed []byte `json:"sealed,omitempty"`
+}
+
+// Replica records the file and snapshot a read-only replica was exported
+// from. Files with a Replica are opened read-only.
+type Replica struct {
+	Source     string    `json:"source"`
+	Snapshot   int64     `json:"snapshot"`
+	ExportedAt time.Time `json:"exportedAt"`
+	ExportedBy string    `json:"exportedBy,omitempty"`
+}
+
+// Schema registry schema types
+const (
+	SchemaTypeAvro = "AVRO"
+	SchemaTypeJSON = "JSON"
+)
+
+// SchemaRef identifies a schema of a schema registry. Registry is the base
+// URL of the registry, without credentials; ID is the registry-wide id of
+// the schema.
+type SchemaRef struct {
+	Registry string `json:"registry"`
+	Subject  string `json:"subject"`
+	Version  int    `json:"version"`
+	ID       int    `json:"id,omitempty"`
+	Type     string `json:"type"`
+}
+
+// Preset records the settings profile a file was created with. Writes use
+// its codec unless they ask for another one.
+type Preset struct {
+	Name  string `json:"name"`
+	Codec string `json:"codec,omitempty"`
+}
+
+// Kinds of synthetic key
+const (
+	SyntheticKeyUUID      = "uuid"      // random (version 4) UUIDs in a string column
+	SyntheticKeySnowflake = "snowflake" // time-ordered 63-bit ids in an int64 column
+)
+
+// SyntheticKey names the column that identifies rows with keys generated
+// at write time, for features that need a key such as key indexes
+type SyntheticKey struct {
+	Column string `json:"column"`
+	Kind   string `json:"kind"`
+}
+
+// Sequence is an int64 column writes fill with increasing values. Last is
+// the high-water mark: the largest value assigned or written so far.
+type Sequence struct {
+	Column string `json:"column"`
+	Last   int64  `json:"last"`
 }
 
-// BlockInfo describes an encrypted data block
+// Snapshot records one commit: the state of the data after a write. The
+// data of a snapshot is the first BlockCount entries of BlockInfo.
+type Snapshot struct {
+	ID         int64     `json:"id"`
+	ParentID   int64     `json:"parentId,omitempty"`
+	Timestamp  time.Time `json:"timestamp"`
+	Principal  string    `json:"principal"`
+	Operation  string    `json:"operation"`
+	Message    string    `json:"message,omitempty"`
+	RowsAdded  int64     `json:"rowsAdded"`
+	TotalRows  int64     `json:"totalRows"`
+	BlockCount int       `json:"blockCount"`
+	Lineage    *Lineage  `json:"lineage,omitempty"`
+}
+
+// Lineage describes where the data of a commit came from
+type Lineage struct {
+	Source         string `json:"source,omitempty"`     // file name or URI of the input
+	SourceHash     string `json:"sourceHash,omitempty"` // "sha256:<hex>" of the input
+	Tool           string `json:"tool,omitempty"`       // program and version that wrote the data
+	Transformation string `json:"transformation,omitempty"`
+	// Schema is the registry schema the input was checked against
+	Schema *SchemaRef `json:"schema,omitempty"`
+}
+
+// VirtualColumn is a column computed from an expression at read time
+type VirtualColumn struct {
+	Name       string `json:"name"`
+	Expression string `json:"expression"`
+}
+
+// Hook stages
+const (
+	HookPreWrite  = "pre-write"
+	HookPostWrite = "post-write"
+	// HookNotify hooks run after the events they subscribe to, with a JSON
+	// description of the event
+	HookNotify = "notify"
+)
+
+// Hook kinds
+const (
+	// HookValidate rejects or drops rows for which Expression is not true
+	HookValidate = "validate"
+	// HookTransform replaces Column with the result of Expression
+	HookTransform = "transform"
+	// HookPlugin runs a hook registered in Go under Plugin
+	HookPlugin = "plugin"
+	// HookWebhook POSTs the event to URL
+	HookWebhook = "webhook"
+	// HookExec runs Command with the event on its standard input
+	HookExec = "exec"
+)
+
+// Events notify hooks subscribe to
+const (
+	EventCommit       = "commit"        // a snapshot was committed
+	EventCompact      = "compact"       // key compaction or gc rewrote blocks
+	EventRotate       = "rotate"        // a column key was rotated
+	EventPolicyDenied = "policy-denied" // the access policy refused a request
+)
+
+// Hook is a validation or transformation step run around each write, or a
+// notification sent after commits and other events
+type Hook struct {
+	Name       string   `json:"name"`
+	Stage      string   `json:"stage"`
+	Kind       string   `json:"kind"`
+	Expression string   `json:"expression,omitempty"`
+	Column     string   `json:"column,omitempty"`
+	Action     string   `json:"action,omitempty"` // validate only: "reject" (default) or "drop"
+	Plugin     string   `json:"plugin,omitempty"`
+	URL        string   `json:"url,omitempty"`     // webhook only
+	Command    []string `json:"command,omitempty"` // exec only: program and arguments
+	Events     []string `json:"events,omitempty"`  // notify only; empty means all
+}
+
+// View is a named query saved with the file. Materialized views also keep
+// their last result as an encrypted block that is refreshed on commit.
+type View struct {
+	Name         string     `json:"name"`
+	Query        string     `json:"query"`
+	CreatedAt    time.Time  `json:"createdAt"`
+	CreatedBy    string     `json:"createdBy"`
+	Materialized bool       `json:"materialized,omitempty"`
+	Block        *BlockInfo `json:"block,omitempty"`
+	RefreshedAt  time.Time  `json:"refreshedAt,omitempty"`
+}
+
+// BlockInfo describes an encrypted data block. Each write stores one block
+// per column, and the blocks of one write form a row group.
 type BlockInfo struct {
 	ColumnName string `json:"columnName"`
+	// Group is set on the blocks of a column group's columns, which share
+	// one stored block
+	Group      string `json:"group,omitempty"`
+	RowGroup   int    `json:"rowGroup,omitempty"`
 	Offset     int64  `json:"offset"`
 	Length     int64  `json:"length"`
 	RowCount   int64  `json:"rowCount"`
 	Compressed bool   `json:"compressed"`
+	Codec      string `json:"codec,omitempty"` // empty means uncompressed
+	KeyEpoch   int    `json:"keyEpoch,omitempty"`
 	Checksum   []byte `json:"checksum"`
 	OrigSize   int64  `json:"origSize,omitempty"`
 	MimeType   string `json:"mimeType,omitempty"`
+	// Signature signs the position, row count and checksum of the block in
+	// files with EncryptionParams.BlockSignatures
+	Signature []byte `json:"signature,omitempty"`
+
+	// BBox bounds the points of a point column's block, so spatial
+	// queries can skip it; nil for other columns and older blocks
+	BBox *geo.BBox `json:"bbox,omitempty"`
+	// Stats are the null count and value range of the block; nil for
+	// older blocks
+	Stats *BlockStats `json:"stats,omitempty"`
+}
+
+// BlockStats describes the values of a column block. Min and Max are the
+// smallest and largest non-null values as text, with times in UTC at a
+// fixed width so they sort as text. They are only recorded for numeric,
+// string and temporal columns of files whose metadata is encrypted, since
+// they reveal values.
+type BlockStats struct {
+	Nulls int64  `json:"nulls"`
+	Min   string `json:"min,omitempty"`
+	Max   string `json:"max,omitempty"`
+}
+
+// Tombstone marks the deleted rows of a row group, which reads skip. Bit i
+// of Rows, least significant first, is set when row i of the group is
+// deleted; the bitmap covers the blocks of every column of the group.
+type Tombstone struct {
+	RowGroup int    `json:"rowGroup"`
+	Rows     []byte `json:"rows"`
+	Count    int64  `json:"count"`
+}
+
+// Deleted reports whether row i of the group is deleted
+func (t *Tombstone) Deleted(i int) bool {
+	return i/8 < len(t.Rows) && t.Rows[i/8]&(1<<(i%8)) != 0
+}
+
+// Delete marks row i of the group as deleted and reports whether it was
+// live
+func (t *Tombstone) Delete(i int) bool {
+	if t.Deleted(i) {
+		return false
+	}
+	for len(t.Rows) <= i/8 {
+		t.Rows = append(t.Rows, 0)
+	}
+	t.Rows[i/8] |= 1 << (i % 8)
+	t.Count++
+	return true
+}
+
+// Rollup sums up the blocks of a file. It is updated as blocks are
+// written, so summaries don't have to go through every block.
+type Rollup struct {
+	Rows      int64                    `json:"rows"`
+	DataBytes int64                    `json:"dataBytes"` // encrypted bytes, shared blocks counted once
+	Columns   map[string]*ColumnRollup `json:"columns,omitempty"`
+}
+
+// ColumnRollup sums up the blocks of a column. Bytes counts blocks shared
+// by a column group in full for each of its columns.
+type ColumnRollup struct {
+	Rows  int64  `json:"rows"`
+	Nulls int64  `json:"nulls"`
+	Bytes int64  `json:"bytes"`
+	Min   string `json:"min,omitempty"`
+	Max   string `json:"max,omitempty"`
+	// Partial is set when some blocks have no stats, so Nulls, Min and
+	// Max cover only the others
+	Partial bool `json:"partial,omitempty"`
 }
 
 // NewMetadata creates new metadata for a lockbox file
@@ -127,11 +622,14 @@ func NewMetadata(schema *arrow.Schema, masterSalt []byte, createdBy string) (*Me
 	// Create encryption params
 	encryption := EncryptionParams{
 		Algorithm:     "AES-256-GCM",
-		KeyDerivation: "PBKDF2",
+		KeyDerivation: KDFPBKDF2,
 		Iterations:    100000,
 		SaltSize:      32,
 		ColumnSalts:   make(map[string][]byte),
 		MasterSalt:    masterSalt,
+
+		ColumnKeyDerivation: ColumnKeySignedHKDF,
+		BlockSignatures:     SignatureEd25519,
 	}
 
 	// Create audit trail
@@ -187,22 +685,243 @@ func Deserialize(data []byte) (*Metadata, error) {
 		m.Schema = reader.Schema()
 		reader.Release()
 	}
+	m.inferRowGroups()
 
 	return &m, nil
 }
 
+// inferRowGroups numbers the blocks of files written before row groups were
+// recorded, where every block has group 0: the i-th block of each column
+// belongs to row group i.
+func (m *Metadata) inferRowGroups() {
+	seen := make(map[string]int)
+	legacy := false
+	for _, bi := range m.BlockInfo {
+		seen[bi.ColumnName]++
+		if seen[bi.ColumnName] > 1 && bi.RowGroup == 0 {
+			legacy = true
+			break
+		}
+	}
+	if !legacy {
+		return
+	}
+	next := make(map[string]int)
+	for i := range m.BlockInfo {
+		bi := &m.BlockInfo[i]
+		bi.RowGroup = next[bi.ColumnName]
+		next[bi.ColumnName]++
+	}
+}
+
 // AddBlockInfo adds information about an encrypted block
-func (m *Metadata) AddBlockInfo(columnName string, offset, length, rowCount int64, checksum []byte, origSize int64, mime string) {
+func (m *Metadata) AddBlockInfo(columnName string, offset, length, rowCount int64, checksum []byte, origSize int64, mime, codec string) *BlockInfo {
 	m.BlockInfo = append(m.BlockInfo, BlockInfo{
 		ColumnName: columnName,
 		Offset:     offset,
 		Length:     length,
 		RowCount:   rowCount,
-		Compressed: false,
+		Compressed: codec != "" && codec != "none",
+		Codec:      codec,
 		Checksum:   checksum,
 		OrigSize:   origSize,
 		MimeType:   mime,
 	})
+	return &m.BlockInfo[len(m.BlockInfo)-1]
+}
+
+// NumRowGroups returns the number of row groups, one per write
+func (m *Metadata) NumRowGroups() int {
+	n := 0
+	for _, bi := range m.BlockInfo {
+		n = max(n, bi.RowGroup+1)
+	}
+	return n
+}
+
+// RowCount returns the number of rows stored. Every write stores one block
+// per column, so the blocks of the first column hold all rows.
+func (m *Metadata) RowCount() int64 {
+	if m.Schema == nil || len(m.Schema.Fields()) == 0 {
+		return 0
+	}
+	first := m.Schema.Field(0).Name
+	var rows int64
+	for _, bi := range m.BlockInfo {
+		if bi.ColumnName == first {
+			rows += bi.RowCount
+		}
+	}
+	return rows
+}
+
+// FindTombstone returns the tombstone of row group n, if it has deleted
+// rows
+func (m *Metadata) FindTombstone(n int) (*Tombstone, bool) {
+	for i := range m.Tombstones {
+		if m.Tombstones[i].RowGroup == n {
+			return &m.Tombstones[i], true
+		}
+	}
+	return nil, false
+}
+
+// MarkDeleted marks rows of row group n as deleted, by position among the
+// rows stored in the group, and returns the number that were not already
+// deleted. Tombstones are kept in row group order.
+func (m *Metadata) MarkDeleted(n int, rows []int) int64 {
+	t, ok := m.FindTombstone(n)
+	if !ok {
+		i := sort.Search(len(m.Tombstones), func(i int) bool { return m.Tombstones[i].RowGroup > n })
+		m.Tombstones = append(m.Tombstones, Tombstone{})
+		copy(m.Tombstones[i+1:], m.Tombstones[i:])
+		m.Tombstones[i] = Tombstone{RowGroup: n}
+		t = &m.Tombstones[i]
+	}
+	var deleted int64
+	for _, i := range rows {
+		if t.Delete(i) {
+			deleted++
+		}
+	}
+	return deleted
+}
+
+// DeletedRows returns the number of rows deleted but not yet compacted
+// away. RowCount includes them.
+func (m *Metadata) DeletedRows() int64 {
+	var n int64
+	for _, t := range m.Tombstones {
+		n += t.Count
+	}
+	return n
+}
+
+// LiveRows returns the number of rows reads see: those stored less those
+// deleted
+func (m *Metadata) LiveRows() int64 {
+	return m.RowCount() - m.DeletedRows()
+}
+
+// RowGroupRows returns the number of rows stored in each row group,
+// deleted or not
+func (m *Metadata) RowGroupRows() []int64 {
+	rows := make([]int64, m.NumRowGroups())
+	if m.Schema == nil || len(m.Schema.Fields()) == 0 {
+		return rows
+	}
+	first := m.Schema.Field(0).Name
+	for _, bi := range m.BlockInfo {
+		if bi.ColumnName == first {
+			rows[bi.RowGroup] += bi.RowCount
+		}
+	}
+	return rows
+}
+
+// LiveRowGroupRows returns the number of rows reads see in each row group
+func (m *Metadata) LiveRowGroupRows() []int64 {
+	rows := m.RowGroupRows()
+	for _, t := range m.Tombstones {
+		if t.RowGroup < len(rows) {
+			rows[t.RowGroup] -= t.Count
+		}
+	}
+	return rows
+}
+
+// AddSnapshot records a commit of the current blocks. The ID, parent,
+// block count and total rows are filled in, and the time if it is zero.
+func (m *Metadata) AddSnapshot(s Snapshot) *Snapshot {
+	if s.Timestamp.IsZero() {
+		s.Timestamp = time.Now()
+	}
+	if n := len(m.Snapshots); n > 0 {
+		s.ParentID = m.Snapshots[n-1].ID
+		s.ID = s.ParentID + 1
+	} else {
+		s.ID = 1
+	}
+	s.BlockCount = len(m.BlockInfo)
+	s.TotalRows = m.RowCount()
+	m.Snapshots = append(m.Snapshots, s)
+	return &m.Snapshots[len(m.Snapshots)-1]
+}
+
+// RowGroupsAt returns the number of row groups committed up to and
+// including snapshot id, which is also the first row group committed after
+// it. Snapshot 0 stands for the empty file before the first commit.
+func (m *Metadata) RowGroupsAt(id int64) (int, error) {
+	if id == 0 {
+		return 0, nil
+	}
+	s, ok := m.FindSnapshot(id)
+	if !ok {
+		return 0, fmt.Errorf("snapshot %d not found", id)
+	}
+	if m.Schema == nil || len(m.Schema.Fields()) == 0 {
+		return 0, nil
+	}
+
+	first := m.Schema.Field(0).Name
+	rows := make([]int64, m.NumRowGroups())
+	for _, bi := range m.BlockInfo {
+		if bi.ColumnName == first {
+			rows[bi.RowGroup] += bi.RowCount
+		}
+	}
+	var total int64
+	for n, r := range rows {
+		if total == s.TotalRows {
+			return n, nil
+		}
+		total += r
+	}
+	if total == s.TotalRows {
+		return len(rows), nil
+	}
+	return 0, fmt.Errorf("snapshot %d does not end at a row group", id)
+}
+
+// FindSnapshot returns the snapshot with the given ID, if any
+func (m *Metadata) FindSnapshot(id int64) (*Snapshot, bool) {
+	for i := range m.Snapshots {
+		if m.Snapshots[i].ID == id {
+			return &m.Snapshots[i], true
+		}
+	}
+	return nil, false
+}
+
+// SnapshotAt returns the last snapshot committed at or before t, if any
+func (m *Metadata) SnapshotAt(t time.Time) (*Snapshot, bool) {
+	var found *Snapshot
+	for i := range m.Snapshots {
+		if !m.Snapshots[i].Timestamp.After(t) {
+			found = &m.Snapshots[i]
+		}
+	}
+	return found, found != nil
+}
+
+// FindView returns the view with the given name, if any
+func (m *Metadata) FindView(name string) (*View, bool) {
+	for i := range m.Views {
+		if m.Views[i].Name == name {
+			return &m.Views[i], true
+		}
+	}
+	return nil, false
+}
+
+// FindVirtualColumn returns the virtual column with the given name, if any
+func (m *Metadata) FindVirtualColumn(name string) (*VirtualColumn, bool) {
+	for i := range m.Virtual {
+		if m.Virtual[i].Name == name {
+			return &m.Virtual[i], true
+		}
+	}
+	return nil, false
 }
 
 // LogAccess logs an access event
@@ -215,7 +934,34 @@ func (m *Metadata) LogAccess(principal, action, resource string, success bool, d
 		Success:   success,
 		Details:   details,
 	}
-	m.AuditTrail.AccessLog = append(m.AuditTrail.AccessLog, entry)
+	m.AuditTrail.Append(entry)
+}
+
+// Append adds entries to the access log, numbering them after the last
+// logged entry
+func (a *AuditTrail) Append(entries ...AccessEntry) {
+	a.Number()
+	for _, e := range entries {
+		a.LastSeq++
+		e.Seq = a.LastSeq
+		a.AccessLog = append(a.AccessLog, e)
+	}
+}
+
+// Number gives sequence numbers to access entries logged before files had
+// them. Entries exported by timestamp are marked exported by sequence.
+func (a *AuditTrail) Number() {
+	for i := range a.AccessLog {
+		e := &a.AccessLog[i]
+		if e.Seq != 0 {
+			continue
+		}
+		a.LastSeq++
+		e.Seq = a.LastSeq
+		if !a.ExportedThrough.IsZero() && !e.Timestamp.After(a.ExportedThrough) {
+			a.ExportedSeq = e.Seq
+		}
+	}
 }
 
 // writeBuffer is a helper for writing schema bytes
@@ -0,0 +1,35 @@
+package storage
+
+import (
+	"net/http"
+	"os"
+	"strings"
+)
+
+// gcsObject returns the URL of bucket/object and a signer. The OAuth 2.0
+// access token comes from GOOGLE_OAUTH_ACCESS_TOKEN, such as the output of
+// "gcloud auth print-access-token". STORAGE_EMULATOR_HOST selects an
+// emulator.
+func gcsObject(path string) (string, requestSigner, error) {
+	bucket, name, err := splitObject(path)
+	if err != nil {
+		return "", nil, err
+	}
+	endpoint := "https://storage.googleapis.com"
+	if host := os.Getenv("STORAGE_EMULATOR_HOST"); host != "" {
+		endpoint = strings.TrimSuffix(host, "/")
+		if !strings.Contains(endpoint, "://") {
+			endpoint = "http://" + endpoint
+		}
+	}
+	u := endpoint + "/" + bucket + "/" + escapePath(name)
+
+	token := os.Getenv("GOOGLE_OAUTH_ACCESS_TOKEN")
+	if token == "" {
+		return u, nil, nil
+	}
+	return u, func(req *http.Request) error {
+		req.Header.Set("Authorization", "Bearer "+token)
+		return nil
+	}, nil
+}
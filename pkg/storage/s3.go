@@ -0,0 +1,139 @@
+package storage
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// emptySHA256 is the hash of the empty payload of GET and HEAD requests
+const emptySHA256 = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+// s3Object returns the URL of bucket/key and a signer. Credentials come
+// from AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and AWS_SESSION_TOKEN, the
+// region from AWS_REGION or AWS_DEFAULT_REGION (us-east-1 by default).
+// AWS_ENDPOINT_URL_S3 or AWS_ENDPOINT_URL select a compatible store, such
+// as MinIO, addressed path-style.
+func s3Object(path string) (string, requestSigner, error) {
+	bucket, key, err := splitObject(path)
+	if err != nil {
+		return "", nil, err
+	}
+	region := firstEnv("AWS_REGION", "AWS_DEFAULT_REGION")
+	if region == "" {
+		region = "us-east-1"
+	}
+
+	var u string
+	if endpoint := firstEnv("AWS_ENDPOINT_URL_S3", "AWS_ENDPOINT_URL"); endpoint != "" {
+		u = strings.TrimSuffix(endpoint, "/") + "/" + bucket + "/" + escapePath(key)
+	} else {
+		u = "https://" + bucket + ".s3." + region + ".amazonaws.com/" + escapePath(key)
+	}
+
+	id, secret := os.Getenv("AWS_ACCESS_KEY_ID"), os.Getenv("AWS_SECRET_ACCESS_KEY")
+	if id == "" || secret == "" {
+		return u, nil, nil
+	}
+	token := os.Getenv("AWS_SESSION_TOKEN")
+	return u, func(req *http.Request) error {
+		signV4(req, id, secret, token, region, time.Now().UTC())
+		return nil
+	}, nil
+}
+
+// signV4 signs a request without a body with AWS Signature Version 4
+func signV4(req *http.Request, id, secret, token, region string, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	date := amzDate[:8]
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", emptySHA256)
+	if token != "" {
+		req.Header.Set("X-Amz-Security-Token", token)
+	}
+
+	signed := []string{"host"}
+	if req.Header.Get("Range") != "" {
+		signed = append(signed, "range")
+	}
+	signed = append(signed, "x-amz-content-sha256", "x-amz-date")
+	if token != "" {
+		signed = append(signed, "x-amz-security-token")
+	}
+	var headers strings.Builder
+	for _, h := range signed {
+		v := req.Header.Get(h)
+		if h == "host" {
+			v = req.URL.Host
+		}
+		headers.WriteString(h + ":" + strings.TrimSpace(v) + "\n")
+	}
+	signedHeaders := strings.Join(signed, ";")
+
+	canonical := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		canonicalQuery(req.URL.Query()),
+		headers.String(),
+		signedHeaders,
+		emptySHA256,
+	}, "\n")
+	scope := date + "/" + region + "/s3/aws4_request"
+	hash := sha256.Sum256([]byte(canonical))
+	toSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(hash[:])
+
+	key := hmacSHA256([]byte("AWS4"+secret), date)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, toSign))
+
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+id+"/"+scope+", SignedHeaders="+signedHeaders+", Signature="+signature)
+}
+
+// canonicalQuery encodes query parameters sorted by name, as signatures
+// expect
+func canonicalQuery(q url.Values) string {
+	return strings.ReplaceAll(q.Encode(), "+", "%20")
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// escapePath escapes every byte of an object name but unreserved
+// characters and slashes, as signatures expect
+func escapePath(name string) string {
+	const hexDigits = "0123456789ABCDEF"
+	var b strings.Builder
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == '~', c == '/':
+			b.WriteByte(c)
+		default:
+			b.WriteByte('%')
+			b.WriteByte(hexDigits[c>>4])
+			b.WriteByte(hexDigits[c&15])
+		}
+	}
+	return b.String()
+}
+
+// firstEnv returns the first of the environment variables that is set
+func firstEnv(names ...string) string {
+	for _, name := range names {
+		if v := os.Getenv(name); v != "" {
+			return v
+		}
+	}
+	return ""
+}
@@ -0,0 +1,198 @@
+// Package storage holds lockbox files in object stores. Objects are read
+// with HTTP range requests, so only the header, the metadata and the
+// blocks a query needs are fetched. They are read-only: write the file
+// locally and upload it.
+//
+// Supported URIs:
+//
+//	s3://bucket/key       Amazon S3 and compatible stores
+//	gs://bucket/object    Google Cloud Storage
+//	az://account/container/blob
+//	                      Azure Blob Storage
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/TFMV/lockbox/pkg/format"
+)
+
+// ErrReadOnly is returned when writing to an object
+var ErrReadOnly = errors.New("object storage is read-only")
+
+// IsRemote reports whether name is an object store URI rather than a path
+func IsRemote(name string) bool {
+	scheme, _, ok := strings.Cut(name, "://")
+	if !ok {
+		return false
+	}
+	switch scheme {
+	case "s3", "gs", "az":
+		return true
+	}
+	return false
+}
+
+// Open returns the storage of the object at uri. Credentials come from the
+// environment, as described for each store; without them objects are read
+// anonymously. ctx bounds every request made through the storage.
+func Open(ctx context.Context, uri string) (format.Storage, error) {
+	scheme, rest, ok := strings.Cut(uri, "://")
+	if !ok {
+		return nil, fmt.Errorf("%s is not an object store URI", uri)
+	}
+	var (
+		signer requestSigner
+		url    string
+		err    error
+	)
+	switch scheme {
+	case "s3":
+		url, signer, err = s3Object(rest)
+	case "gs":
+		url, signer, err = gcsObject(rest)
+	case "az":
+		url, signer, err = azureObject(rest)
+	default:
+		return nil, fmt.Errorf("unsupported object store %s://", scheme)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", uri, err)
+	}
+
+	o := &object{ctx: ctx, uri: uri, url: url, sign: signer, client: http.DefaultClient}
+	if err := o.stat(); err != nil {
+		return nil, err
+	}
+	return o, nil
+}
+
+// requestSigner adds the credentials of a store to a request
+type requestSigner func(req *http.Request) error
+
+// object is an object read with HTTP range requests
+type object struct {
+	ctx    context.Context
+	uri    string
+	url    string
+	sign   requestSigner
+	client *http.Client
+	size   int64
+}
+
+// splitObject splits "container/name" at the first slash
+func splitObject(path string) (container, name string, err error) {
+	container, name, ok := strings.Cut(path, "/")
+	if !ok || container == "" || name == "" {
+		return "", "", fmt.Errorf("expected <bucket>/<object>, got %q", path)
+	}
+	return container, name, nil
+}
+
+// do sends a signed request and checks its status
+func (o *object) do(method string, header http.Header) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(o.ctx, method, o.url, nil)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	if o.sign != nil {
+		if err := o.sign(req); err != nil {
+			return nil, fmt.Errorf("%s: failed to sign request: %w", o.uri, err)
+		}
+	}
+	resp, err := o.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", o.uri, err)
+	}
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusPartialContent:
+		return resp, nil
+	case http.StatusNotFound:
+		resp.Body.Close()
+		return nil, fmt.Errorf("%s: %w", o.uri, fs.ErrNotExist)
+	case http.StatusForbidden, http.StatusUnauthorized:
+		resp.Body.Close()
+		return nil, fmt.Errorf("%s: %w: %s", o.uri, fs.ErrPermission, resp.Status)
+	}
+	resp.Body.Close()
+	return nil, fmt.Errorf("%s: %s", o.uri, resp.Status)
+}
+
+// stat reads the size of the object
+func (o *object) stat() error {
+	resp, err := o.do(http.MethodHead, nil)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.ContentLength < 0 {
+		return fmt.Errorf("%s: size unknown", o.uri)
+	}
+	o.size = resp.ContentLength
+	return nil
+}
+
+// ReadAt reads len(p) bytes at off with one range request
+func (o *object) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, fmt.Errorf("negative offset %d", off)
+	}
+	if off >= o.size {
+		return 0, io.EOF
+	}
+	want := min(int64(len(p)), o.size-off)
+	if want == 0 {
+		return 0, nil
+	}
+	header := http.Header{"Range": {"bytes=" + strconv.FormatInt(off, 10) + "-" + strconv.FormatInt(off+want-1, 10)}}
+	resp, err := o.do(http.MethodGet, header)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	body := io.Reader(resp.Body)
+	if resp.StatusCode == http.StatusOK {
+		// The store ignored the range and sent the whole object
+		if _, err := io.CopyN(io.Discard, body, off); err != nil {
+			return 0, fmt.Errorf("%s: %w", o.uri, err)
+		}
+	}
+	n, err := io.ReadFull(body, p[:want])
+	if err != nil {
+		return n, fmt.Errorf("%s: %w", o.uri, err)
+	}
+	if want < int64(len(p)) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// WriteAt fails; objects are read-only
+func (o *object) WriteAt(p []byte, off int64) (int, error) {
+	return 0, fmt.Errorf("%s: %w", o.uri, ErrReadOnly)
+}
+
+// Size returns the size of the object when it was opened
+func (o *object) Size() (int64, error) {
+	return o.size, nil
+}
+
+// ReadOnly reports that objects cannot be written
+func (o *object) ReadOnly() bool {
+	return true
+}
+
+// Close does nothing; every read is its own request
+func (o *object) Close() error {
+	return nil
+}